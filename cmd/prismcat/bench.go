@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/bench"
+)
+
+// runBench 运行 "prismcat bench" 子命令：通过完整的代理 + 存储链路施加合成负载，
+// 报告吞吐、延迟和日志丢弃率
+func runBench(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	upstream := fs.String("upstream", "mock", "上游：mock（内置模拟上游）或 http(s) 地址")
+	concurrency := fs.Int("concurrency", 50, "并发请求数")
+	duration := fs.Duration("duration", 30*time.Second, "压测时长")
+	method := fs.String("method", "POST", "请求方法")
+	path := fs.String("path", "/v1/chat/completions", "请求路径")
+	requestBytes := fs.Int("request-bytes", 1024, "请求体大小（字节）")
+	responseBytes := fs.Int("response-bytes", 2048, "模拟上游的响应体大小（字节）")
+	storageDriver := fs.String("storage", "sqlite", "存储：sqlite（临时数据库）或 memory")
+	database := fs.String("db", "", "使用指定的 SQLite 文件代替临时数据库")
+	asyncBuffer := fs.Int("async-buffer", 4096, "异步日志队列容量")
+	jsonOut := fs.Bool("json", false, "以 JSON 输出结果")
+	verbose := fs.Bool("verbose", false, "压测期间保留代理和存储的运行日志")
+	_ = fs.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	log.Printf("压测中: upstream=%s concurrency=%d duration=%s storage=%s",
+		*upstream, *concurrency, *duration, *storageDriver)
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+	res, err := bench.Run(ctx, bench.Options{
+		Upstream:      *upstream,
+		Concurrency:   *concurrency,
+		Duration:      *duration,
+		Path:          *path,
+		Method:        *method,
+		RequestBytes:  *requestBytes,
+		ResponseBytes: *responseBytes,
+		Storage:       *storageDriver,
+		Database:      *database,
+		AsyncBuffer:   *asyncBuffer,
+	})
+	log.SetOutput(os.Stderr)
+	if err != nil {
+		log.Fatalf("压测失败: %v", err)
+	}
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(res); err != nil {
+			log.Fatalf("输出结果失败: %v", err)
+		}
+		return
+	}
+	fmt.Println()
+	res.Print(os.Stdout)
+}
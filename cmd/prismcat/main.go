@@ -9,8 +9,13 @@ import (
 	"strings"
 	"time"
 
+	"github.com/prismcat/prismcat/internal/backup"
 	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/models"
+	"github.com/prismcat/prismcat/internal/plugin"
+	"github.com/prismcat/prismcat/internal/proxy"
 	"github.com/prismcat/prismcat/internal/server"
+	"github.com/prismcat/prismcat/internal/sink"
 	"github.com/prismcat/prismcat/internal/storage"
 )
 
@@ -39,12 +44,26 @@ storage:
   retention_days: 7
   blob_store: "fs"
   blob_dir: "data/blobs"
+
+upstreams:
+  # 内置演示上游（模拟 OpenAI 接口，无需 API Key）：http://demo.localhost:8080/v1/chat/completions
+  demo:
+    type: demo
 `
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		runBench(os.Args[2:])
+		return
+	}
+
 	defaultPath := filepath.Join("data", "config.yaml")
 	configPath := flag.String("config", defaultPath, "配置文件路径")
 	showConsole := flag.Bool("console", false, "是否显示控制台窗口")
+	autoPort := flag.Bool("auto-port", false, "端口被占用时自动改用下一个空闲端口")
+	backupPath := flag.String("backup", "", "写入备份归档到指定文件后退出（可在服务运行时执行）")
+	restorePath := flag.String("restore", "", "从备份归档恢复数据库、blob 和配置后退出（需先停止服务）")
+	ephemeral := flag.Bool("ephemeral", false, "日志只保存在内存中，不写入磁盘，退出后丢失（同 storage.driver: memory）")
 	flag.Parse()
 
 	// 统一路径处理：如果要使用的是默认路径，但老路径 config.yaml 存在，则尝试迁移或提示
@@ -77,6 +96,11 @@ func main() {
 			log.Printf("使用内置默认配置初始化")
 			configData = []byte(strings.TrimSpace(defaultYAML))
 		}
+		// 3. .json / .toml 配置由 YAML 模版转换（注释不保留）
+		configData, err := config.FromYAML(*configPath, configData)
+		if err != nil {
+			log.Fatalf("转换配置模版失败: %v", err)
+		}
 
 		// 确保目标路径的父目录存在
 		if dir := filepath.Dir(*configPath); dir != "." {
@@ -99,16 +123,65 @@ func main() {
 	log.Printf("配置已加载: DetachBodyOverBytes=%d, BodyPreviewBytes=%d",
 		cfg.Logging.DetachBodyOverBytes, cfg.Logging.BodyPreviewBytes)
 
+	if *restorePath != "" {
+		runRestore(*restorePath, cfg, *configPath)
+		return
+	}
+
 	// 初始化存储
-	sqliteRepo, err := storage.NewSQLiteRepository(cfg.Storage.Database)
+	// The flag is kept out of cfg so saving settings never persists it.
+	inMemory := *ephemeral || cfg.Storage.Driver == config.StorageDriverMemory
+	memoryMaxBytes := cfg.Storage.MemoryMaxMB << 20
+	if memoryMaxBytes <= 0 {
+		memoryMaxBytes = 256 << 20
+	}
+	var sqliteRepo *storage.SQLiteRepository
+	if inMemory {
+		if *backupPath != "" {
+			log.Fatalf("内存存储模式下没有可备份的数据")
+		}
+		sqliteRepo, err = storage.NewMemorySQLiteRepository()
+		log.Printf("使用内存存储（上限 %d MB），日志不会写入磁盘，退出后丢失", memoryMaxBytes>>20)
+	} else {
+		sqliteRepo, err = storage.NewSQLiteRepository(cfg.Storage.Database)
+	}
 	if err != nil {
 		log.Fatalf("初始化存储失败: %v", err)
 	}
+	// 启动恢复：上次异常退出遗留的进行中日志标记为 interrupted，后台检查数据库完整性
+	// （-backup 可能与运行中的服务并存，不做恢复）
+	// 请求 journal：请求在转发前同步记录，异步队列中未落库的请求也能在此恢复
+	var recovery *storage.Recovery
+	var journal *storage.Journal
+	if !inMemory && *backupPath == "" {
+		var source string
+		if cc := cfg.ClusterSnapshot(); cc.Enabled {
+			source = cc.InstanceID
+		}
+		if cfg.Storage.Journal {
+			journal, err = storage.OpenJournal(storage.JournalPath(cfg.Storage.Database, source), cfg.Storage.JournalSync)
+			if err != nil {
+				log.Fatalf("打开请求 journal 失败: %v", err)
+			}
+			defer journal.Close()
+		}
+		recovery = storage.Recover(sqliteRepo, journal, source, time.Now())
+	}
+	if !inMemory && (cfg.Storage.ReadPoolSize > 0 || cfg.Storage.ReadReplica != "") {
+		if err := sqliteRepo.OpenReadPool(cfg.Storage.ReadReplica, cfg.Storage.ReadPoolSize); err != nil {
+			log.Fatalf("初始化只读连接池失败: %v", err)
+		}
+	}
+	if ms := cfg.Storage.QueryTimeoutMs; ms != 0 {
+		sqliteRepo.SetQueryTimeout(time.Duration(ms) * time.Millisecond)
+	}
 
 	// Blob store for detached bodies.
 	var blobStore storage.BlobStore
-	switch cfg.Storage.BlobStore {
-	case "", "fs":
+	switch {
+	case inMemory:
+		blobStore = storage.NewMemoryBlobStore(memoryMaxBytes / 2)
+	case cfg.Storage.BlobStore == "" || cfg.Storage.BlobStore == "fs":
 		bs, err := storage.NewFileBlobStore(cfg.Storage.BlobDir)
 		if err != nil {
 			log.Fatalf("初始化 blob 存储失败: %v", err)
@@ -118,10 +191,145 @@ func main() {
 		log.Fatalf("不支持的 blob_store: %s", cfg.Storage.BlobStore)
 	}
 
+	// 归档层：旧日志的完整 body 移入压缩归档，读取时自动回源
+	var tiered *storage.TieredBlobStore
+	if ac := cfg.StorageSnapshot().Archive; ac.AfterDays > 0 {
+		archive, err := storage.NewArchive(ac)
+		if err != nil {
+			log.Fatalf("初始化归档存储失败: %v", err)
+		}
+		tiered = storage.NewTieredBlobStore(blobStore, archive)
+		blobStore = tiered
+		log.Printf("超过 %d 天的请求/响应体将移入归档存储", ac.AfterDays)
+	}
+
+	if *backupPath != "" {
+		runBackup(*backupPath, sqliteRepo, blobStore, *configPath)
+		return
+	}
+
 	detachingRepo := storage.NewDetachingRepository(sqliteRepo, blobStore, cfg)
-	asyncRepo := storage.NewAsyncRepository(detachingRepo, cfg.Storage.AsyncBuffer)
+	diskGuard := storage.NewDiskGuard(cfg)
+	detachingRepo.SetDiskGuard(diskGuard)
+	stopDiskGuard := make(chan struct{})
+	if !inMemory {
+		go diskGuard.Run(30*time.Second, stopDiskGuard)
+	}
+	defer close(stopDiskGuard)
+
+	// 日志外送：在 detach 之后、异步队列之内复制最终日志
+	sinkRepo := sink.NewRepository(detachingRepo)
+	sinks := cfg.SinksSnapshot()
+	if sinks.Remote != nil {
+		remote, err := sink.NewRemote(*sinks.Remote, blobStore)
+		if err != nil {
+			log.Fatalf("加载日志外送配置失败: %v", err)
+		}
+		sinkRepo.Add(remote, sink.NewOptions(sinks.Remote.BatchSize, sinks.Remote.FlushIntervalMs))
+		log.Printf("日志将转发到 %s", sinks.Remote.URL)
+	}
+	if sinks.Kafka != nil {
+		kafka, err := sink.NewKafka(*sinks.Kafka)
+		if err != nil {
+			log.Fatalf("加载日志外送配置失败: %v", err)
+		}
+		sinkRepo.Add(kafka, sink.NewOptions(sinks.Kafka.BatchSize, sinks.Kafka.FlushIntervalMs))
+		log.Printf("日志将发布到 Kafka topic %s", sinks.Kafka.Topic)
+	}
+	if sinks.ClickHouse != nil {
+		ch, err := sink.NewClickHouse(*sinks.ClickHouse)
+		if err != nil {
+			log.Fatalf("加载日志外送配置失败: %v", err)
+		}
+		sinkRepo.Add(ch, sink.NewOptions(sinks.ClickHouse.BatchSize, sinks.ClickHouse.FlushIntervalMs))
+		log.Printf("日志将写入 ClickHouse %s", sinks.ClickHouse.URL)
+	}
+	if sinks.Elastic != nil {
+		es, err := sink.NewElastic(*sinks.Elastic)
+		if err != nil {
+			log.Fatalf("加载日志外送配置失败: %v", err)
+		}
+		sinkRepo.Add(es, sink.NewOptions(sinks.Elastic.BatchSize, sinks.Elastic.FlushIntervalMs))
+		log.Printf("日志将写入 Elasticsearch %s", sinks.Elastic.URL)
+	}
+	if sinks.OTLP != nil {
+		otlp, err := sink.NewOTLP(*sinks.OTLP)
+		if err != nil {
+			log.Fatalf("加载日志外送配置失败: %v", err)
+		}
+		sinkRepo.Add(otlp, sink.NewOptions(sinks.OTLP.BatchSize, sinks.OTLP.FlushIntervalMs))
+		log.Printf("日志将以 OTLP 导出到 %s", sinks.OTLP.Endpoint)
+	}
+	if sinks.Langfuse != nil {
+		langfuse, err := sink.NewLangfuse(*sinks.Langfuse, blobStore)
+		if err != nil {
+			log.Fatalf("加载日志外送配置失败: %v", err)
+		}
+		sinkRepo.Add(langfuse, sink.NewOptions(sinks.Langfuse.BatchSize, sinks.Langfuse.FlushIntervalMs))
+		log.Printf("LLM 调用将导出到 Langfuse")
+	}
+	if sinks.LangSmith != nil {
+		langsmith, err := sink.NewLangSmith(*sinks.LangSmith, blobStore)
+		if err != nil {
+			log.Fatalf("加载日志外送配置失败: %v", err)
+		}
+		sinkRepo.Add(langsmith, sink.NewOptions(sinks.LangSmith.BatchSize, sinks.LangSmith.FlushIntervalMs))
+		log.Printf("LLM 调用将导出到 LangSmith")
+	}
+	if sinks.File != nil {
+		file, err := sink.NewFile(*sinks.File, blobStore)
+		if err != nil {
+			log.Fatalf("加载日志外送配置失败: %v", err)
+		}
+		sinkRepo.Add(file, sink.NewOptions(sinks.File.BatchSize, sinks.File.FlushIntervalMs))
+		log.Printf("日志将以 JSONL 追加写入 %s", sinks.File.Path)
+	}
+	if sinks.Syslog != nil {
+		syslog, err := sink.NewSyslog(*sinks.Syslog)
+		if err != nil {
+			log.Fatalf("加载日志外送配置失败: %v", err)
+		}
+		sinkRepo.Add(syslog, sink.NewOptions(sinks.Syslog.BatchSize, sinks.Syslog.FlushIntervalMs))
+		log.Printf("访问记录将以 syslog 发送到 %s", sinks.Syslog.Address)
+	}
+	// 统计缓存：仪表盘轮询不再每次触发全表聚合
+	var statsRepo storage.Repository = sinkRepo
+	if ms := cfg.Storage.StatsCacheMs; ms >= 0 {
+		if ms == 0 {
+			ms = 5000
+		}
+		statsRepo = storage.NewStatsCache(sinkRepo, time.Duration(ms)*time.Millisecond, cfg.ClusterSnapshot().Enabled)
+	}
+	if journal != nil {
+		statsRepo = storage.NewJournalRepository(statsRepo, journal)
+	}
+	asyncRepo := storage.NewAsyncRepository(statsRepo, cfg.Storage.AsyncBuffer)
 	defer asyncRepo.Close()
 
+	// 定时备份
+	backupRunner, err := backup.NewRunner(cfg, asyncRepo, blobStore)
+	if err != nil {
+		log.Fatalf("加载备份配置失败: %v", err)
+	}
+
+	// 多实例共享存储：维护任务和定时备份只在持有租约的实例上运行
+	isLeader := func() bool { return true }
+	if cc := cfg.ClusterSnapshot(); cc.Enabled {
+		leader := storage.NewLeader(sqliteRepo, "maintenance", cc.InstanceID, time.Duration(cc.LeaseSeconds)*time.Second)
+		stopLeader := make(chan struct{})
+		go leader.Run(stopLeader)
+		defer close(stopLeader)
+		isLeader = leader.IsLeader
+		backupRunner.SetLeader(isLeader)
+		log.Printf("集群模式: 实例 %s", cc.InstanceID)
+	}
+
+	stopBackup := make(chan struct{})
+	if !inMemory {
+		go backupRunner.Run(stopBackup)
+	}
+	defer close(stopBackup)
+
 	// Best-effort log retention cleanup.
 	stopRetention := make(chan struct{})
 	go func() {
@@ -130,25 +338,66 @@ func main() {
 
 		var lastCleanup time.Time
 		var lastBlobGC time.Time
+		var lastQuota time.Time
+		var lastArchive time.Time
 		for {
+			if inMemory {
+				if deleted, err := sqliteRepo.TrimToSize(memoryMaxBytes / 2); err != nil {
+					log.Printf("in-memory log trim failed: %v", err)
+				} else if deleted > 0 {
+					log.Printf("dropped %d oldest logs to stay under storage.memory_max_mb", deleted)
+				}
+			}
+			if fsStore, ok := storage.HotBlobs(blobStore).(*storage.FileBlobStore); ok && isLeader() {
+				maxBlobBytes := cfg.StorageSnapshot().MaxBlobBytes
+				if maxBlobBytes > 0 && time.Since(lastQuota) >= 10*time.Minute {
+					if report, err := storage.EnforceBlobQuota(context.Background(), sqliteRepo, fsStore, maxBlobBytes, time.Hour); err != nil {
+						log.Printf("blob quota enforcement failed: %v", err)
+					} else if report.Evicted > 0 {
+						log.Printf("evicted %d blobs (%d bytes) to stay under max_blob_bytes", report.Evicted, report.ReclaimedBytes)
+					}
+					lastQuota = time.Now()
+				}
+			}
+			if archiveDays := cfg.StorageSnapshot().Archive.AfterDays; tiered != nil && archiveDays > 0 && isLeader() &&
+				(lastArchive.IsZero() || time.Since(lastArchive) >= time.Hour) {
+				before := time.Now().AddDate(0, 0, -archiveDays)
+				if res, err := storage.ArchiveBodiesBefore(context.Background(), asyncRepo, tiered, before, cfg.LoggingSnapshot().BodyPreviewBytes); err != nil {
+					log.Printf("body archiving failed: %v", err)
+				} else if res.Logs > 0 {
+					log.Printf("archived bodies of %d logs older than %d days (%d blobs, %d bytes)", res.Logs, archiveDays, res.Blobs, res.Bytes)
+				}
+				lastArchive = time.Now()
+			}
 			retentionDays := cfg.StorageSnapshot().RetentionDays
-			if retentionDays > 0 && (lastCleanup.IsZero() || time.Since(lastCleanup) >= 6*time.Hour) {
+			if retentionDays > 0 && isLeader() && (lastCleanup.IsZero() || time.Since(lastCleanup) >= 6*time.Hour) {
 				before := time.Now().Add(-time.Duration(retentionDays) * 24 * time.Hour)
-				deleted, err := asyncRepo.DeleteLogsBefore(before)
+				deleted, err := asyncRepo.DeleteLogsBefore(context.Background(), before)
 				if err != nil {
 					log.Printf("log retention cleanup failed: %v", err)
 				} else if deleted > 0 {
 					log.Printf("deleted %d logs older than %d days", deleted, retentionDays)
 				}
 
-				if fsStore, ok := blobStore.(*storage.FileBlobStore); ok {
+				if fsStore, ok := storage.HotBlobs(blobStore).(*storage.FileBlobStore); ok {
 					if lastBlobGC.IsZero() || time.Since(lastBlobGC) >= 24*time.Hour {
-						if refs, err := sqliteRepo.ListBlobRefs(); err != nil {
+						if refs, err := sqliteRepo.ListBlobRefs(context.Background()); err != nil {
 							log.Printf("blob GC list refs failed: %v", err)
-						} else if n, err := fsStore.GarbageCollect(context.Background(), refs, time.Hour); err != nil {
-							log.Printf("blob GC failed: %v", err)
-						} else if n > 0 {
-							log.Printf("deleted %d unreferenced blobs", n)
+						} else {
+							if report, err := fsStore.GarbageCollect(context.Background(), refs, time.Hour); err != nil {
+								log.Printf("blob GC failed: %v", err)
+							} else if report.Deleted > 0 {
+								log.Printf("deleted %d unreferenced blobs (%d bytes)", report.Deleted, report.ReclaimedBytes)
+							}
+							if tiered != nil {
+								if dir, ok := tiered.Archive().(*storage.DirArchive); ok {
+									if report, err := dir.GarbageCollect(context.Background(), refs, time.Hour); err != nil {
+										log.Printf("archive GC failed: %v", err)
+									} else if report.Deleted > 0 {
+										log.Printf("deleted %d unreferenced archived blobs (%d bytes)", report.Deleted, report.ReclaimedBytes)
+									}
+								}
+							}
 						}
 						lastBlobGC = time.Now()
 					}
@@ -164,11 +413,88 @@ func main() {
 	}()
 	defer close(stopRetention)
 
+	// 加载插件（WASM 需要使用 -tags prismcat_wasm 构建）
+	var middlewares []proxy.Middleware
+	wasmPlugins, err := plugin.LoadWASM(context.Background(), cfg.Plugins.WASM)
+	if err != nil {
+		log.Fatalf("加载插件失败: %v", err)
+	}
+	for _, p := range wasmPlugins {
+		log.Printf("已加载 WASM 插件: %s", p.Name())
+		middlewares = append(middlewares, p)
+		defer p.Close(context.Background())
+	}
+	callouts, err := plugin.NewCallouts(cfg.Plugins.Callouts, cfg.LoggingSnapshot().SensitiveHeaders)
+	if err != nil {
+		log.Fatalf("加载插件失败: %v", err)
+	}
+	for _, c := range callouts {
+		log.Printf("已启用外部回调: %s", c.Name())
+		middlewares = append(middlewares, c)
+	}
+
+	// 自动发现本机模型服务（启动时只注册到内存，不写入配置文件）
+	if cfg.DiscoverySnapshot().Local {
+		found := models.DiscoverLocal(context.Background(), cfg.ListUpstreams())
+		for _, s := range models.RegisterLocal(cfg, found) {
+			log.Printf("发现本地模型服务 %s (%s, %d 个模型)，上游: %s", s.Type, s.Target, len(s.Models), s.Upstream)
+		}
+	}
+
 	// 启动服务器
-	srv := server.New(cfg, asyncRepo, blobStore)
+	srv := server.New(cfg, asyncRepo, blobStore, middlewares...)
+	srv.SetDiskGuard(diskGuard)
+	srv.SetRecovery(recovery)
+	srv.SetJournal(journal)
+	srv.SetSinks(sinkRepo)
+	srv.SetLeader(isLeader)
+	srv.SetPortFallback(*autoPort)
 
 	// 平台相关的运行逻辑（Windows: 系统托盘, 其他: 直接运行）
 	if err := platformRun(srv, cfg, *showConsole); err != nil {
 		log.Fatalf("运行失败: %v", err)
 	}
 }
+
+// runBackup 写入备份归档
+func runBackup(path string, repo storage.Repository, blobs storage.BlobStore, configPath string) {
+	defer repo.Close()
+	f, err := os.Create(path)
+	if err != nil {
+		log.Fatalf("创建备份文件失败: %v", err)
+	}
+	manifest, err := storage.WriteBackup(context.Background(), f, repo, blobs, configPath)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		log.Fatalf("备份失败: %v", err)
+	}
+	log.Printf("备份完成: %s (数据库 %d 字节, %d 个 blob, 缺失 %d 个)", path, manifest.DBBytes, manifest.Blobs, manifest.MissingBlobs)
+}
+
+// runRestore 从备份归档恢复；数据库文件会被替换，需先停止正在运行的实例
+func runRestore(path string, cfg *config.Config, configPath string) {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("打开备份文件失败: %v", err)
+	}
+	defer f.Close()
+
+	var blobs storage.BlobStore
+	if cfg.Storage.BlobStore == "fs" {
+		if blobs, err = storage.NewFileBlobStore(cfg.Storage.BlobDir); err != nil {
+			log.Fatalf("初始化 blob 存储失败: %v", err)
+		}
+	}
+	manifest, err := storage.RestoreBackup(f, cfg.Storage.Database, blobs, configPath)
+	if err != nil {
+		log.Fatalf("恢复失败: %v", err)
+	}
+	log.Printf("恢复完成: 备份创建于 %s (版本 %s), %d 个 blob",
+		manifest.CreatedAt.Format(time.RFC3339), manifest.Version, manifest.Blobs)
+	if manifest.Config {
+		log.Printf("配置文件已恢复，原配置保存为 %s.bak", configPath)
+	}
+}
@@ -3,10 +3,13 @@ package main
 import (
 	"fmt"
 	"log"
+	"sync/atomic"
 	"syscall"
+	"unsafe"
 
 	"github.com/getlantern/systray"
 	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/proxy"
 	"github.com/prismcat/prismcat/internal/server"
 	"github.com/skratchdot/open-golang/open"
 )
@@ -17,13 +20,75 @@ var (
 	getConsoleWindow = kernel32.NewProc("GetConsoleWindow")
 	showWindowProc   = user32.NewProc("ShowWindow")
 	allocConsole     = kernel32.NewProc("AllocConsole")
+	messageBoxW      = user32.NewProc("MessageBoxW")
+	findWindowW      = user32.NewProc("FindWindowW")
+	shell32          = syscall.NewLazyDLL("shell32.dll")
+	shellNotifyIconW = shell32.NewProc("Shell_NotifyIconW")
 )
 
 const (
 	swHide = 0
 	swShow = 5
+
+	mbIconError       = 0x10
+	mbIconInformation = 0x40
+
+	nimModify  = 0x1
+	nifInfo    = 0x10
+	niifError  = 0x3
+	trayIconID = 100 // systray 注册托盘图标时使用的 ID
 )
 
+// notifyIconData 对应 NOTIFYICONDATAW，仅用于弹出气泡通知
+type notifyIconData struct {
+	Size            uint32
+	Wnd             uintptr
+	ID              uint32
+	Flags           uint32
+	CallbackMessage uint32
+	Icon            uintptr
+	Tip             [128]uint16
+	State           uint32
+	StateMask       uint32
+	Info            [256]uint16
+	Version         uint32
+	InfoTitle       [64]uint16
+	InfoFlags       uint32
+	GUIDItem        [16]byte
+	BalloonIcon     uintptr
+}
+
+// showNotification 在托盘图标上弹出气泡通知（Windows 10+ 显示为系统通知）
+func showNotification(title, text string) {
+	className, _ := syscall.UTF16PtrFromString("SystrayClass")
+	hwnd, _, _ := findWindowW.Call(uintptr(unsafe.Pointer(className)), 0)
+	if hwnd == 0 {
+		return
+	}
+	nid := notifyIconData{Wnd: hwnd, ID: trayIconID, Flags: nifInfo, InfoFlags: niifError}
+	nid.Size = uint32(unsafe.Sizeof(nid))
+	copyUTF16(nid.InfoTitle[:], title)
+	copyUTF16(nid.Info[:], text)
+	shellNotifyIconW.Call(nimModify, uintptr(unsafe.Pointer(&nid)))
+}
+
+// copyUTF16 复制 s 到定长缓冲区，超长时截断并保留结尾的 0
+func copyUTF16(dst []uint16, s string) {
+	u, _ := syscall.UTF16FromString(s)
+	if len(u) > len(dst) {
+		u = u[:len(dst)]
+		u[len(u)-1] = 0
+	}
+	copy(dst, u)
+}
+
+// showMessage 弹出消息框（阻塞直到用户关闭）
+func showMessage(text string, icon uintptr) {
+	title, _ := syscall.UTF16PtrFromString("PrismCat")
+	body, _ := syscall.UTF16PtrFromString(text)
+	messageBoxW.Call(0, uintptr(unsafe.Pointer(body)), uintptr(unsafe.Pointer(title)), icon)
+}
+
 func hideConsole() {
 	hwnd, _, _ := getConsoleWindow.Call()
 	if hwnd != 0 {
@@ -31,16 +96,36 @@ func hideConsole() {
 	}
 }
 
-func getTrayLabels() (openTitle, quitTitle string) {
+func isChineseUI() bool {
 	userDefaultUILang := kernel32.NewProc("GetUserDefaultUILanguage")
 	ret, _, _ := userDefaultUILang.Call()
 	primaryLangId := uint16(ret) & 0x3ff
-	if primaryLangId == 0x04 { // LANG_CHINESE
+	return primaryLangId == 0x04 // LANG_CHINESE
+}
+
+func getTrayLabels() (openTitle, quitTitle string) {
+	if isChineseUI() {
 		return "打开仪表盘", "退出"
 	}
 	return "Open Dashboard", "Exit"
 }
 
+// upstreamFailingMessage 上游连续失败通知的标题和正文
+func upstreamFailingMessage(f proxy.UpstreamFailure) (title, text string) {
+	if isChineseUI() {
+		return fmt.Sprintf("上游 %s 连续失败 %d 次", f.Upstream, f.Count), f.LastError
+	}
+	return fmt.Sprintf("Upstream %s failed %d times in a row", f.Upstream, f.Count), f.LastError
+}
+
+// portChangedMessage 提示端口冲突后实际使用的地址
+func portChangedMessage(configured int, url string) string {
+	if isChineseUI() {
+		return fmt.Sprintf("端口 %d 已被占用，PrismCat 已改用 %s", configured, url)
+	}
+	return fmt.Sprintf("Port %d is in use; PrismCat is running on %s instead.", configured, url)
+}
+
 func platformRun(srv *server.Server, cfg *config.Config, showConsole bool) error {
 	// Windows 控制台处理
 	if showConsole {
@@ -68,22 +153,40 @@ func platformRun(srv *server.Server, cfg *config.Config, showConsole bool) error
 		systray.AddSeparator()
 		mQuit := systray.AddMenuItem(titleQuit, "")
 
+		// 实际端口可能因冲突而改变（-auto-port），以绑定结果为准
+		var port atomic.Int64
+		port.Store(int64(cfg.Server.Port))
+		srv.SetOnListening(func(p int) {
+			port.Store(int64(p))
+			url := fmt.Sprintf("http://localhost:%d", p)
+			systray.SetTooltip("PrismCat LLM Proxy " + config.Version + " - " + url)
+			if p != cfg.Server.Port {
+				go showMessage(portChangedMessage(cfg.Server.Port, url), mbIconInformation)
+			}
+		})
+
+		// 上游连续失败时弹出通知，不在请求路径上等待
+		srv.SetOnUpstreamFailing(func(f proxy.UpstreamFailure) {
+			go showNotification(upstreamFailingMessage(f))
+		})
+
 		// 托盘菜单事件循环
 		go func() {
 			for {
 				select {
 				case <-mOpen.ClickedCh:
-					open.Run(fmt.Sprintf("http://localhost:%d", cfg.Server.Port))
+					open.Run(fmt.Sprintf("http://localhost:%d", port.Load()))
 				case <-mQuit.ClickedCh:
 					systray.Quit()
 				}
 			}
 		}()
 
-		// 在后台启动服务器
+		// 在后台启动服务器；失败时提示原因后再退出，而不是静默消失
 		go func() {
 			if err := srv.Start(); err != nil {
 				log.Printf("服务器错误: %v", err)
+				showMessage(err.Error(), mbIconError)
 				systray.Quit()
 			}
 		}()
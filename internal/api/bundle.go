@@ -0,0 +1,277 @@
+package api
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/llm"
+	"github.com/prismcat/prismcat/internal/redact"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+// bundleFormatVersion is bumped when the bundle layout changes incompatibly.
+const bundleFormatVersion = 1
+
+const redactedValue = "[REDACTED]"
+
+// bundleSecretHeaders are always redacted in bundles, in addition to
+// logging.sensitive_headers. Captured logs only partially mask secrets, which
+// is fine locally but not for an artifact meant to be attached to a bug report.
+var bundleSecretHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+	"Api-Key",
+	"X-Goog-Api-Key",
+}
+
+// bundleSecretQueryParams are query parameters that carry credentials
+// (e.g. Gemini's ?key=).
+var bundleSecretQueryParams = []string{"key", "api_key", "apikey", "access_token", "token"}
+
+// logBundle is a self-contained, redacted snapshot of one log.
+type logBundle struct {
+	FormatVersion   int       `json:"format_version"`
+	PrismCatVersion string    `json:"prismcat_version"`
+	GeneratedAt     time.Time `json:"generated_at"`
+	// Redacted reports whether the PII pipeline was applied (redact=true).
+	Redacted bool                `json:"redacted"`
+	Log      *storage.RequestLog `json:"log"`
+	Stream   *bundleStream       `json:"stream,omitempty"`
+	// Files lists the other entries of a zip bundle.
+	Files []string `json:"files,omitempty"`
+}
+
+// bundleStream is the reassembled view of a streamed response.
+type bundleStream struct {
+	Events int                    `json:"events"`
+	Format string                 `json:"format,omitempty"`
+	Merged map[string]interface{} `json:"merged,omitempty"`
+}
+
+// handleLogBundle 导出单条日志的可分享包 (json 或 zip)
+func (h *Handler) handleLogBundle(w http.ResponseWriter, r *http.Request, id string) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "zip" {
+		h.jsonError(w, "format 必须是 json 或 zip", http.StatusBadRequest)
+		return
+	}
+
+	var pii *redact.Redactor
+	if redactParam(r) {
+		var err error
+		if pii, err = redact.New(h.cfg.LoggingSnapshot().RedactPatterns); err != nil {
+			h.jsonError(w, "脱敏规则无效: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	log, err := h.repo.GetLog(r.Context(), id)
+	if err != nil {
+		h.jsonError(w, "日志不存在", http.StatusNotFound)
+		return
+	}
+	b, err := h.buildLogBundle(r, log, pii)
+	if err != nil {
+		h.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	filename := "prismcat-" + id
+	if format == "json" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".json"))
+		h.jsonResponse(w, b)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".zip"))
+	if err := writeBundleZip(w, b); err != nil {
+		// Headers are already sent; all we can do is cut the archive short.
+		return
+	}
+}
+
+// buildLogBundle resolves detached bodies, reassembles streams and redacts
+// secrets. When pii is non-nil, personal data is masked as well. log is
+// modified in place.
+func (h *Handler) buildLogBundle(r *http.Request, log *storage.RequestLog, pii *redact.Redactor) (*logBundle, error) {
+	if err := h.resolveBodies(r.Context(), log); err != nil {
+		return nil, err
+	}
+
+	sensitive := append(append([]string(nil), bundleSecretHeaders...), h.cfg.LoggingSnapshot().SensitiveHeaders...)
+	log.RequestHeaders = redactHeaders(log.RequestHeaders, sensitive)
+	log.ResponseHeaders = redactHeaders(log.ResponseHeaders, sensitive)
+	log.Query = redactQuery(log.Query)
+	if pii != nil {
+		redactLog(log, pii)
+	}
+
+	b := &logBundle{
+		FormatVersion:   bundleFormatVersion,
+		PrismCatVersion: config.Version,
+		GeneratedAt:     time.Now().UTC(),
+		Redacted:        pii != nil,
+		Log:             log,
+	}
+	if log.Streaming && log.ResponseBody != "" {
+		events := llm.ParseStream(firstHeader(log.ResponseHeaders, "Content-Type"), []byte(log.ResponseBody))
+		b.Stream = &bundleStream{Events: len(events)}
+		if merged, format, ok := llm.MergeStream(events); ok {
+			b.Stream.Format, b.Stream.Merged = format, merged
+		}
+	}
+	return b, nil
+}
+
+// writeBundleZip writes bundle.json plus the bodies as separate files, which
+// is easier to read and diff than escaped JSON strings.
+func writeBundleZip(w http.ResponseWriter, b *logBundle) error {
+	files := map[string]string{}
+	var order []string
+	add := func(name, content string) {
+		if content != "" {
+			files[name] = content
+			order = append(order, name)
+		}
+	}
+	add("request_body.txt", b.Log.RequestBody)
+	add("response_body.txt", b.Log.ResponseBody)
+	if b.Stream != nil && b.Stream.Merged != nil {
+		merged, err := json.MarshalIndent(b.Stream.Merged, "", "  ")
+		if err != nil {
+			return err
+		}
+		add("response_merged.json", string(merged))
+	}
+
+	manifest := *b
+	logCopy := *b.Log
+	logCopy.RequestBody, logCopy.ResponseBody = "", ""
+	manifest.Log = &logCopy
+	if manifest.Stream != nil {
+		stream := *manifest.Stream
+		stream.Merged = nil
+		manifest.Stream = &stream
+	}
+	manifest.Files = order
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+	write := func(name string, data []byte) error {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: b.GeneratedAt})
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(data)
+		return err
+	}
+	if err := write("bundle.json", manifestJSON); err != nil {
+		return err
+	}
+	for _, name := range order {
+		if err := write(name, []byte(files[name])); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// redactParam reports whether the export request asked for redact=true.
+func redactParam(r *http.Request) bool {
+	v, _ := strconv.ParseBool(r.URL.Query().Get("redact"))
+	return v
+}
+
+// redactLog applies the PII pipeline to every free-text field of an exported
+// log. Streams are redacted as raw text before being reassembled.
+func redactLog(log *storage.RequestLog, pii *redact.Redactor) {
+	log.Path = pii.String(log.Path)
+	log.Query = pii.String(log.Query)
+	log.TargetURL = pii.String(log.TargetURL)
+	log.RequestHeaders = pii.Headers(log.RequestHeaders)
+	log.ResponseHeaders = pii.Headers(log.ResponseHeaders)
+	log.RequestBody = pii.String(log.RequestBody)
+	log.ResponseBody = pii.String(log.ResponseBody)
+	log.Error = pii.String(log.Error)
+	log.UserAgent = pii.String(log.UserAgent)
+	log.ClientIP, log.RemoteAddr = "", ""
+}
+
+// redactHeaders replaces the values of sensitive headers entirely.
+func redactHeaders(headers map[string][]string, sensitive []string) map[string][]string {
+	if headers == nil {
+		return nil
+	}
+	out := make(map[string][]string, len(headers))
+	for k, vv := range headers {
+		secret := false
+		for _, s := range sensitive {
+			if strings.EqualFold(k, s) {
+				secret = true
+				break
+			}
+		}
+		if !secret {
+			out[k] = vv
+			continue
+		}
+		masked := make([]string, len(vv))
+		for i := range vv {
+			masked[i] = redactedValue
+		}
+		out[k] = masked
+	}
+	return out
+}
+
+// redactQuery masks credential-carrying query parameters.
+func redactQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return rawQuery
+	}
+	changed := false
+	for k := range values {
+		for _, p := range bundleSecretQueryParams {
+			if strings.EqualFold(k, p) {
+				for i := range values[k] {
+					values[k][i] = redactedValue
+				}
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return rawQuery
+	}
+	return values.Encode()
+}
+
+func firstHeader(headers map[string][]string, key string) string {
+	for k, vv := range headers {
+		if strings.EqualFold(k, key) && len(vv) > 0 {
+			return vv[0]
+		}
+	}
+	return ""
+}
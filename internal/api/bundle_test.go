@@ -0,0 +1,126 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+func newTestHandlerWithLog(t *testing.T) *Handler {
+	t.Helper()
+	repo, err := storage.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+
+	err = repo.SaveLog(context.Background(), &storage.RequestLog{
+		ID:              "log-1",
+		CreatedAt:       time.Now(),
+		Upstream:        "openai",
+		Method:          "POST",
+		Path:            "/v1/chat/completions",
+		Query:           "key=secret-key&alt=sse",
+		RequestHeaders:  map[string][]string{"Authorization": {"Bearer sk-12***xyz"}, "Content-Type": {"application/json"}},
+		RequestBody:     `{"stream":true,"user":"alice@example.com"}`,
+		StatusCode:      200,
+		ResponseHeaders: map[string][]string{"Content-Type": {"text/event-stream"}, "Set-Cookie": {"session=abc"}},
+		ResponseBody:    "data: {\"object\":\"chat.completion.chunk\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"hi\"}}]}\n\ndata: [DONE]\n\n",
+		Streaming:       true,
+		ClientIP:        "203.0.113.9",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(&config.Config{}, repo, nil)
+}
+
+func TestLogBundleJSONIsRedacted(t *testing.T) {
+	h := newTestHandlerWithLog(t)
+	mux := http.NewServeMux()
+	h.RegisterRoutes(mux)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/logs/log-1/bundle", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	body := rec.Body.String()
+	for _, secret := range []string{"sk-12", "session=abc", "secret-key"} {
+		if strings.Contains(body, secret) {
+			t.Errorf("bundle leaks %q: %s", secret, body)
+		}
+	}
+
+	var b logBundle
+	if err := json.Unmarshal(rec.Body.Bytes(), &b); err != nil {
+		t.Fatal(err)
+	}
+	if b.Stream == nil || b.Stream.Events != 2 || b.Stream.Format != "openai_chat" {
+		t.Fatalf("stream = %+v", b.Stream)
+	}
+	choices := b.Stream.Merged["choices"].([]interface{})
+	msg := choices[0].(map[string]interface{})["message"].(map[string]interface{})
+	if msg["content"] != "hi" {
+		t.Fatalf("merged message = %v", msg)
+	}
+}
+
+func TestLogBundleZip(t *testing.T) {
+	h := newTestHandlerWithLog(t)
+	rec := httptest.NewRecorder()
+	h.handleLogDetail(rec, httptest.NewRequest(http.MethodGet, "/api/logs/log-1/bundle?format=zip", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("status = %d, content-type = %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+		if f.Name == "request_body.txt" {
+			rc, _ := f.Open()
+			data, _ := io.ReadAll(rc)
+			rc.Close()
+			if string(data) != `{"stream":true,"user":"alice@example.com"}` {
+				t.Errorf("request_body.txt = %q", data)
+			}
+		}
+	}
+	if got := strings.Join(names, ","); got != "bundle.json,request_body.txt,response_body.txt,response_merged.json" {
+		t.Fatalf("zip entries = %s", got)
+	}
+}
+
+func TestLogBundleRedactPII(t *testing.T) {
+	h := newTestHandlerWithLog(t)
+	rec := httptest.NewRecorder()
+	h.handleLogDetail(rec, httptest.NewRequest(http.MethodGet, "/api/logs/log-1/bundle?redact=true", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var b logBundle
+	if err := json.Unmarshal(rec.Body.Bytes(), &b); err != nil {
+		t.Fatal(err)
+	}
+	if !b.Redacted || b.Log.ClientIP != "" {
+		t.Fatalf("redacted = %v, client_ip = %q", b.Redacted, b.Log.ClientIP)
+	}
+	if b.Log.RequestBody != `{"stream":true,"user":"[REDACTED:EMAIL]"}` {
+		t.Fatalf("request_body = %s", b.Log.RequestBody)
+	}
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/llm"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+// driftEntry is one log in a drift timeline.
+type driftEntry struct {
+	ID                string    `json:"id"`
+	CreatedAt         time.Time `json:"created_at"`
+	StatusCode        int       `json:"status_code"`
+	Latency           int64     `json:"latency_ms"`
+	Model             string    `json:"model,omitempty"`
+	SystemFingerprint string    `json:"system_fingerprint,omitempty"`
+	FinishReason      string    `json:"finish_reason,omitempty"`
+	ResponseHash      string    `json:"response_hash"`
+	// Variant indexes the response's entry in the variants list.
+	Variant int `json:"variant"`
+	// Changes lists the fields that differ from the previous entry.
+	Changes []string `json:"changes,omitempty"`
+
+	outputHash string
+}
+
+// driftVariant groups entries with the same response.
+type driftVariant struct {
+	ResponseHash string    `json:"response_hash"`
+	Count        int       `json:"count"`
+	FirstSeen    time.Time `json:"first_seen"`
+	LastSeen     time.Time `json:"last_seen"`
+	Model        string    `json:"model,omitempty"`
+	StatusCode   int       `json:"status_code"`
+	Output       string    `json:"output"`
+}
+
+// handleLogDrift 列出与该日志请求指纹相同的所有日志（按时间正序），标出响应变化
+func (h *Handler) handleLogDrift(w http.ResponseWriter, r *http.Request, id string) {
+	base, err := h.repo.GetLog(r.Context(), id)
+	if err != nil {
+		h.jsonError(w, "日志不存在", http.StatusNotFound)
+		return
+	}
+	if base.Fingerprint == "" {
+		h.jsonError(w, "该日志没有请求指纹（请求体未完整记录）", http.StatusUnprocessableEntity)
+		return
+	}
+
+	limit := 100
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 {
+		limit = v
+	}
+	summaries, total, err := h.repo.ListLogs(r.Context(), storage.LogFilter{Fingerprint: base.Fingerprint, Limit: limit})
+	if err != nil {
+		h.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	entries := make([]driftEntry, 0, len(summaries))
+	var variants []driftVariant
+	byHash := make(map[string]int)
+	changes := 0
+	// ListLogs returns newest first; walk oldest first so changes read forward.
+	for i := len(summaries) - 1; i >= 0; i-- {
+		log, err := h.repo.GetLog(r.Context(), summaries[i].ID)
+		if err != nil {
+			continue // deleted since the listing
+		}
+		body, err := h.responseJSON(r.Context(), log)
+		if err != nil {
+			h.jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		e := driftEntry{
+			ID:         log.ID,
+			CreatedAt:  log.CreatedAt,
+			StatusCode: log.StatusCode,
+			Latency:    log.Latency,
+			Model:      llm.ExtractModel(body),
+		}
+		e.SystemFingerprint, _ = body["system_fingerprint"].(string)
+		text := log.ResponseBody
+		if out, ok := llm.ExtractOutput(body); ok {
+			text = out.Text
+			e.FinishReason = out.FinishReason
+		}
+		out := sha256.Sum256([]byte(text))
+		e.outputHash = hex.EncodeToString(out[:])
+		sum := sha256.Sum256([]byte(strconv.Itoa(log.StatusCode) + "\n" + e.FinishReason + "\n" + e.outputHash))
+		e.ResponseHash = "sha256:" + hex.EncodeToString(sum[:])
+
+		idx, seen := byHash[e.ResponseHash]
+		if !seen {
+			idx = len(variants)
+			byHash[e.ResponseHash] = idx
+			variants = append(variants, driftVariant{
+				ResponseHash: e.ResponseHash,
+				FirstSeen:    e.CreatedAt,
+				Model:        e.Model,
+				StatusCode:   e.StatusCode,
+				Output:       text,
+			})
+		}
+		variants[idx].Count++
+		variants[idx].LastSeen = e.CreatedAt
+		e.Variant = idx
+
+		if n := len(entries); n > 0 {
+			e.Changes = driftChanges(entries[n-1], e)
+			if len(e.Changes) > 0 {
+				changes++
+			}
+		}
+		entries = append(entries, e)
+	}
+
+	h.jsonResponse(w, map[string]interface{}{
+		"fingerprint": base.Fingerprint,
+		"total":       total,
+		"entries":     entries,
+		"variants":    variants,
+		"changes":     changes,
+	})
+}
+
+// driftChanges names the fields of cur that differ from prev.
+func driftChanges(prev, cur driftEntry) []string {
+	var changed []string
+	if prev.StatusCode != cur.StatusCode {
+		changed = append(changed, "status_code")
+	}
+	if prev.Model != cur.Model {
+		changed = append(changed, "model")
+	}
+	if prev.SystemFingerprint != cur.SystemFingerprint {
+		changed = append(changed, "system_fingerprint")
+	}
+	if prev.FinishReason != cur.FinishReason {
+		changed = append(changed, "finish_reason")
+	}
+	if prev.outputHash != cur.outputHash {
+		changed = append(changed, "output")
+	}
+	return changed
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+func TestLogDrift(t *testing.T) {
+	repo, err := storage.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+
+	start := time.Now().Add(-time.Hour)
+	for i, resp := range []string{
+		`{"model":"gpt-4o-2024-05-13","choices":[{"message":{"content":"Paris"},"finish_reason":"stop"}]}`,
+		`{"model":"gpt-4o-2024-05-13","choices":[{"message":{"content":"Paris"},"finish_reason":"stop"}]}`,
+		`{"model":"gpt-4o-2024-08-06","choices":[{"message":{"content":"Paris."},"finish_reason":"stop"}]}`,
+	} {
+		err := repo.SaveLog(context.Background(), &storage.RequestLog{
+			ID:           "log-" + string(rune('1'+i)),
+			CreatedAt:    start.Add(time.Duration(i) * time.Minute),
+			Method:       "POST",
+			Path:         "/v1/chat/completions",
+			StatusCode:   200,
+			ResponseBody: resp,
+			Fingerprint:  "sha256:same",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := New(&config.Config{}, repo, nil)
+
+	rec := httptest.NewRecorder()
+	h.handleLogDetail(rec, httptest.NewRequest(http.MethodGet, "/api/logs/log-1/drift", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var got struct {
+		Entries  []driftEntry   `json:"entries"`
+		Variants []driftVariant `json:"variants"`
+		Changes  int            `json:"changes"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Entries) != 3 || got.Entries[0].ID != "log-1" || got.Entries[2].ID != "log-3" {
+		t.Fatalf("entries = %+v", got.Entries)
+	}
+	if len(got.Variants) != 2 || got.Variants[0].Count != 2 || got.Variants[1].Output != "Paris." {
+		t.Fatalf("variants = %+v", got.Variants)
+	}
+	if got.Changes != 1 || strings.Join(got.Entries[2].Changes, ",") != "model,output" {
+		t.Fatalf("changes = %d, last = %v", got.Changes, got.Entries[2].Changes)
+	}
+}
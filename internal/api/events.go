@@ -0,0 +1,129 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/prismcat/prismcat/internal/llm"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+// logEvent is one parsed event of a captured streaming body.
+type logEvent struct {
+	llm.SSEEvent
+	// JSON is Data decoded, omitted when Data is not JSON (e.g. "[DONE]").
+	JSON interface{} `json:"json,omitempty"`
+	// AtMs is when the event reached the client, in milliseconds since the
+	// request started; omitted for logs captured without event timings.
+	AtMs *int64 `json:"at_ms,omitempty"`
+}
+
+// handleLogEvents 将流式响应体解析为有序事件列表
+func (h *Handler) handleLogEvents(w http.ResponseWriter, r *http.Request, id string) {
+	log, err := h.repo.GetLog(r.Context(), id)
+	if err != nil {
+		h.jsonError(w, "日志不存在", http.StatusNotFound)
+		return
+	}
+	if err := h.resolveBodies(r.Context(), log); err != nil {
+		h.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	events := []logEvent{}
+	if log.Streaming {
+		for _, ev := range llm.ParseStream(firstHeader(log.ResponseHeaders, "Content-Type"), []byte(log.ResponseBody)) {
+			events = append(events, logEvent{SSEEvent: ev, JSON: ev.JSON(), AtMs: eventTime(log.EventTimings, ev.Offset)})
+		}
+	}
+	h.jsonResponse(w, map[string]interface{}{
+		"id":        log.ID,
+		"streaming": log.Streaming,
+		"truncated": log.Truncated,
+		"events":    events,
+	})
+}
+
+// eventTime returns the time of the forwarded event containing offset: the
+// last timing at or before it.
+func eventTime(timings []storage.EventTiming, offset int) *int64 {
+	i := sort.Search(len(timings), func(i int) bool { return timings[i].Offset > int64(offset) })
+	if i == 0 {
+		return nil
+	}
+	ms := timings[i-1].Ms
+	return &ms
+}
+
+// applyLogView selects how a streaming response body is returned. By default
+// the body is returned as stored: the reassembled JSON when the log has
+// storage.FlagStreamMerged, otherwise the raw capture. "raw" loads the raw
+// stream from its blob; "merged" reassembles older logs on the fly.
+func (h *Handler) applyLogView(ctx context.Context, log *storage.RequestLog, view string) error {
+	switch {
+	case view == "raw" && log.HasFlag(storage.FlagStreamMerged):
+		return h.resolveBodies(ctx, log)
+	case view == "merged" && log.Streaming && !log.HasFlag(storage.FlagStreamMerged):
+		full := *log
+		if err := h.resolveBodies(ctx, &full); err != nil {
+			return err
+		}
+		events := llm.ParseStream(firstHeader(log.ResponseHeaders, "Content-Type"), []byte(full.ResponseBody))
+		merged, _, ok := llm.MergeStream(events)
+		if !ok {
+			return nil
+		}
+		data, err := json.Marshal(merged)
+		if err != nil {
+			return err
+		}
+		log.ResponseBody = string(data)
+		log.AddFlag(storage.FlagStreamMerged)
+	}
+	return nil
+}
+
+// resolveBodies replaces detached body previews with the full blob content.
+// Missing blobs (e.g. already garbage-collected) leave the preview in place.
+// For merged streams the response body becomes the raw stream again.
+func (h *Handler) resolveBodies(ctx context.Context, log *storage.RequestLog) error {
+	return h.inlineBodies(ctx, log, false)
+}
+
+// inlineBodies is resolveBodies for ?include_bodies=true: when keepMerged is
+// set, a merged stream keeps its reassembled response, which is already
+// complete, along with the ref to the raw stream.
+func (h *Handler) inlineBodies(ctx context.Context, log *storage.RequestLog, keepMerged bool) error {
+	if h.blobs == nil {
+		return nil
+	}
+	for _, body := range []struct {
+		ref  *string
+		dest *string
+	}{
+		{&log.RequestBodyRef, &log.RequestBody},
+		{&log.ResponseBodyRef, &log.ResponseBody},
+	} {
+		if *body.ref == "" {
+			continue
+		}
+		if keepMerged && body.dest == &log.ResponseBody && log.HasFlag(storage.FlagStreamMerged) {
+			continue
+		}
+		data, err := h.blobs.Get(ctx, *body.ref)
+		if err != nil {
+			if err == storage.ErrBlobNotFound {
+				continue
+			}
+			return fmt.Errorf("读取 blob 失败: %w", err)
+		}
+		*body.dest, *body.ref = string(data), ""
+		if body.dest == &log.ResponseBody {
+			log.RemoveFlag(storage.FlagStreamMerged)
+		}
+	}
+	return nil
+}
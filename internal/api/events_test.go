@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+func TestLogEvents(t *testing.T) {
+	h := newTestHandlerWithLog(t)
+	rec := httptest.NewRecorder()
+	h.handleLogDetail(rec, httptest.NewRequest(http.MethodGet, "/api/logs/log-1/events", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+
+	var resp struct {
+		Streaming bool `json:"streaming"`
+		Events    []struct {
+			Data   string                 `json:"data"`
+			Offset int                    `json:"offset"`
+			JSON   map[string]interface{} `json:"json"`
+		} `json:"events"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Streaming || len(resp.Events) != 2 {
+		t.Fatalf("resp = %+v", resp)
+	}
+	if resp.Events[0].JSON["object"] != "chat.completion.chunk" || resp.Events[0].Offset != 0 {
+		t.Errorf("first event = %+v", resp.Events[0])
+	}
+	if resp.Events[1].Data != "[DONE]" || resp.Events[1].JSON != nil || resp.Events[1].Offset == 0 {
+		t.Errorf("second event = %+v", resp.Events[1])
+	}
+}
+
+func TestLogOutputText(t *testing.T) {
+	h := newTestHandlerWithLog(t)
+	rec := httptest.NewRecorder()
+	h.handleLogDetail(rec, httptest.NewRequest(http.MethodGet, "/api/logs/log-1/output?format=text", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "hi" {
+		t.Fatalf("status = %d, body = %q", rec.Code, rec.Body)
+	}
+}
+
+func TestLogDetailIncludeBodies(t *testing.T) {
+	repo, err := storage.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	blobs := storage.NewMemoryBlobStore(0)
+	full := `{"messages":[{"role":"user","content":"` + strings.Repeat("x", 64) + `"}]}`
+	ref, err := blobs.Put(context.Background(), []byte(full))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.SaveLog(context.Background(), &storage.RequestLog{
+		ID: "log-1", CreatedAt: time.Now(), Upstream: "openai", Method: "POST", Path: "/v1/chat/completions",
+		RequestBody: full[:16], RequestBodyRef: ref, ResponseBody: `{"id":"1"}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := New(&config.Config{}, repo, blobs)
+
+	for _, tc := range []struct {
+		query    string
+		body     string
+		wantsRef bool
+	}{
+		{"", full[:16], true},
+		{"?include_bodies=true", full, false},
+	} {
+		rec := httptest.NewRecorder()
+		h.handleLogDetail(rec, httptest.NewRequest(http.MethodGet, "/api/logs/log-1"+tc.query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%q: status = %d: %s", tc.query, rec.Code, rec.Body)
+		}
+		var got storage.RequestLog
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.RequestBody != tc.body || (got.RequestBodyRef != "") != tc.wantsRef {
+			t.Errorf("%q: body = %q, ref = %q", tc.query, got.RequestBody, got.RequestBodyRef)
+		}
+	}
+}
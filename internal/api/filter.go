@@ -0,0 +1,144 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+// parseLogFilter builds a storage.LogFilter from /api/logs query parameters.
+//
+// upstream, method, tag, path and status_code accept comma-separated values
+// (OR), and status_code accepts ranges such as "500-599". Appending "!" to the
+// parameter name excludes matches instead: "path!=/v1/models" arrives as the
+// key "path!" with value "/v1/models". "property.<key>=<value>" and
+// "metadata.<key>=<value>" match a custom property or metadata key exactly.
+func parseLogFilter(query url.Values) (storage.LogFilter, error) {
+	filter := storage.LogFilter{
+		Upstreams:        splitList(query.Get("upstream")),
+		ExcludeUpstreams: splitList(query.Get("upstream!")),
+		Methods:          splitList(query.Get("method")),
+		ExcludeMethods:   splitList(query.Get("method!")),
+		Tags:             splitList(query.Get("tag")),
+		ExcludeTags:      splitList(query.Get("tag!")),
+		Paths:            splitList(query.Get("path")),
+		ExcludePaths:     splitList(query.Get("path!")),
+		Flag:             query.Get("flag"),
+		Variant:          query.Get("variant"),
+		ClientIP:         query.Get("client_ip"),
+		RemoteAddr:       query.Get("remote_addr"),
+		UserAgent:        query.Get("user_agent"),
+		Source:           query.Get("source"),
+		PathRegex:        query.Get("path_regex"),
+		Fingerprint:      query.Get("fingerprint"),
+		DuplicateOf:      query.Get("duplicate_of"),
+	}
+
+	var err error
+	if filter.PathRegex != "" {
+		if _, err := regexp.Compile(filter.PathRegex); err != nil {
+			return filter, fmt.Errorf("无效的 path_regex: %v", err)
+		}
+	}
+	if filter.StatusCodes, err = parseStatusRanges(query.Get("status_code")); err != nil {
+		return filter, err
+	}
+	if filter.ExcludeStatusCodes, err = parseStatusRanges(query.Get("status_code!")); err != nil {
+		return filter, err
+	}
+
+	for param, dst := range map[string]*int64{
+		"min_latency_ms": &filter.MinLatencyMs,
+		"max_latency_ms": &filter.MaxLatencyMs,
+		"min_body_size":  &filter.MinBodySize,
+		"max_body_size":  &filter.MaxBodySize,
+	} {
+		if v := query.Get(param); v != "" {
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				*dst = n
+			}
+		}
+	}
+
+	for param, vv := range query {
+		if len(vv) == 0 {
+			continue
+		}
+		if key, ok := strings.CutPrefix(param, "property."); ok && key != "" {
+			if filter.Properties == nil {
+				filter.Properties = make(map[string]string)
+			}
+			filter.Properties[strings.ToLower(key)] = vv[0]
+		} else if key, ok := strings.CutPrefix(param, "metadata."); ok && key != "" {
+			if filter.Metadata == nil {
+				filter.Metadata = make(map[string]string)
+			}
+			filter.Metadata[key] = vv[0]
+		}
+	}
+
+	if offset := query.Get("offset"); offset != "" {
+		if o, err := strconv.Atoi(offset); err == nil {
+			filter.Offset = o
+		}
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		if l, err := strconv.Atoi(limit); err == nil {
+			filter.Limit = l
+		}
+	}
+
+	if startTime := query.Get("start_time"); startTime != "" {
+		if t, err := time.Parse(time.RFC3339, startTime); err == nil {
+			filter.StartTime = &t
+		}
+	}
+
+	if endTime := query.Get("end_time"); endTime != "" {
+		if t, err := time.Parse(time.RFC3339, endTime); err == nil {
+			filter.EndTime = &t
+		}
+	}
+
+	return filter, nil
+}
+
+// splitList splits a comma-separated parameter, dropping empty items.
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// parseStatusRanges parses "429,500-599" into status ranges.
+func parseStatusRanges(s string) ([]storage.StatusRange, error) {
+	var out []storage.StatusRange
+	for _, part := range splitList(s) {
+		lo, hi, isRange := strings.Cut(part, "-")
+		min, err := strconv.Atoi(strings.TrimSpace(lo))
+		if err != nil {
+			return nil, fmt.Errorf("无效的 status_code: %s", part)
+		}
+		max := min
+		if isRange {
+			if max, err = strconv.Atoi(strings.TrimSpace(hi)); err != nil || max < min {
+				return nil, fmt.Errorf("无效的 status_code: %s", part)
+			}
+		}
+		out = append(out, storage.StatusRange{Min: min, Max: max})
+	}
+	return out, nil
+}
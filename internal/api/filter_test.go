@@ -0,0 +1,33 @@
+package api
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+func TestParseLogFilterMultiValueAndNegation(t *testing.T) {
+	q, _ := url.ParseQuery("upstream=openai,azure&status_code=429,500-599&path!=/v1/models&method=POST")
+	f, err := parseLogFilter(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(f.Upstreams, []string{"openai", "azure"}) {
+		t.Errorf("Upstreams = %v", f.Upstreams)
+	}
+	if !reflect.DeepEqual(f.StatusCodes, []storage.StatusRange{{Min: 429, Max: 429}, {Min: 500, Max: 599}}) {
+		t.Errorf("StatusCodes = %v", f.StatusCodes)
+	}
+	if !reflect.DeepEqual(f.ExcludePaths, []string{"/v1/models"}) {
+		t.Errorf("ExcludePaths = %v", f.ExcludePaths)
+	}
+
+	for _, bad := range []string{"status_code=abc", "status_code=599-500", "status_code!=4xx"} {
+		q, _ := url.ParseQuery(bad)
+		if _, err := parseLogFilter(q); err == nil {
+			t.Errorf("%s: expected error", bad)
+		}
+	}
+}
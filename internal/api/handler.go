@@ -3,24 +3,48 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/prismcat/prismcat/internal/awsauth"
+	"github.com/prismcat/prismcat/internal/azure"
 	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/demo"
+	"github.com/prismcat/prismcat/internal/dnscache"
+	"github.com/prismcat/prismcat/internal/gcpauth"
+	"github.com/prismcat/prismcat/internal/models"
+	"github.com/prismcat/prismcat/internal/sink"
 	"github.com/prismcat/prismcat/internal/storage"
 )
 
 // Handler API 处理器
 type Handler struct {
-	cfg    *config.Config
-	repo   storage.Repository
-	blobs  storage.BlobStore
-	client *http.Client
+	cfg     *config.Config
+	repo    storage.Repository
+	blobs   storage.BlobStore
+	client  *http.Client
+	disk    *storage.DiskGuard
+	recover *storage.Recovery
+	sinks   *sink.Repository
+	metrics MetricsWriter
+	dns     *dnscache.Cache
+	catalog *models.Catalog
+	leader  func() bool
+	aws     *awsauth.Resolver
+	gcp     *gcpauth.Resolver
+}
+
+// MetricsWriter 以 Prometheus 文本格式输出指标（由代理实现）
+type MetricsWriter interface {
+	WriteMetrics(w io.Writer) error
 }
 
 // New 创建 API 处理器
@@ -37,29 +61,86 @@ func New(cfg *config.Config, repo storage.Repository, blobs storage.BlobStore) *
 		TLSHandshakeTimeout:   10 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
 	}
-	return &Handler{
-		cfg:   cfg,
-		repo:  repo,
-		blobs: blobs,
-		client: &http.Client{
-			CheckRedirect: func(req *http.Request, via []*http.Request) error {
-				return http.ErrUseLastResponse
-			},
-			Transport: transport,
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
 		},
+		Transport: transport,
 	}
+	return &Handler{
+		cfg:     cfg,
+		repo:    repo,
+		blobs:   blobs,
+		client:  client,
+		catalog: models.New(client, modelsCacheTTL),
+		aws:     awsauth.NewResolver(),
+		gcp:     gcpauth.NewResolver(),
+	}
+}
+
+// SetDiskGuard 设置磁盘空间监控，状态通过 /api/health 暴露
+func (h *Handler) SetDiskGuard(g *storage.DiskGuard) {
+	h.disk = g
+}
+
+// SetRecovery 设置启动恢复与完整性检查结果，通过 /api/health 暴露
+func (h *Handler) SetRecovery(r *storage.Recovery) {
+	h.recover = r
+}
+
+// SetLeader 设置集群维护租约状态，通过 /api/health 暴露
+func (h *Handler) SetLeader(isLeader func() bool) {
+	h.leader = isLeader
+}
+
+// SetSinks 设置日志外送，各目标的计数通过 /api/health 暴露
+func (h *Handler) SetSinks(s *sink.Repository) {
+	h.sinks = s
+}
+
+// SetMetrics 设置指标来源，通过 /metrics 暴露
+func (h *Handler) SetMetrics(m MetricsWriter) {
+	h.metrics = m
+}
+
+// SetDNSCache 设置上游 DNS 缓存，统计通过 /api/debug 暴露
+func (h *Handler) SetDNSCache(c *dnscache.Cache) {
+	h.dns = c
 }
 
 // RegisterRoutes 注册 API 路由
 func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/api/logs", h.handleLogs)
 	mux.HandleFunc("/api/logs/", h.handleLogDetail)
+	mux.HandleFunc("/api/logs/purge", h.handleLogPurge)
 	mux.HandleFunc("/api/stats", h.handleStats)
+	mux.HandleFunc("/api/stats/properties", h.handlePropertyStats)
+	mux.HandleFunc("/api/stats/checks", h.handleCheckStats)
+	mux.HandleFunc("/api/stats/traffic", h.handleTrafficStats)
+	mux.HandleFunc("/api/stats/hourly", h.handleHourlyStats)
+	mux.HandleFunc("/api/storage/stats", h.handleStorageStats)
+	mux.HandleFunc("/api/storage/upstreams", h.handleStorageUpstreams)
+	mux.HandleFunc("/api/maintenance/blob-gc", h.handleBlobGC)
+	mux.HandleFunc("/api/maintenance/purge-bodies", h.handlePurgeBodies)
+	mux.HandleFunc("/api/maintenance/archive-bodies", h.handleArchiveBodies)
+	mux.HandleFunc("/api/maintenance/backup", h.handleBackup)
 	mux.HandleFunc("/api/upstreams", h.handleUpstreams)
+	mux.HandleFunc("/api/upstreams/maintenance", h.handleUpstreamMaintenance)
+	mux.HandleFunc("/api/upstreams/export", h.handleUpstreamExport)
+	mux.HandleFunc("/api/upstreams/import", h.handleUpstreamImport)
+	mux.HandleFunc("/api/upstreams/presets", h.handleUpstreamPresets)
+	mux.HandleFunc("/api/upstreams/", h.handleUpstreamDetail)
+	mux.HandleFunc("/api/routes", h.handleRoutes)
 	mux.HandleFunc("/api/config", h.handleConfig)
 	mux.HandleFunc("/api/health", h.handleHealth)
+	mux.HandleFunc("/api/debug", h.handleDebug)
 	mux.HandleFunc("/api/blobs/", h.handleBlob)
+	mux.HandleFunc("/api/ingest", h.handleIngest)
 	mux.HandleFunc("/api/replay", h.handleReplay)
+	mux.HandleFunc("/api/models", h.handleModels)
+	mux.HandleFunc("/api/discovery/local", h.handleLocalDiscovery)
+	mux.HandleFunc("/api/openapi.json", h.handleOpenAPI)
+	mux.HandleFunc("/metrics", h.handleMetrics)
 }
 
 // handleLogs 获取日志列表
@@ -69,45 +150,13 @@ func (h *Handler) handleLogs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	query := r.URL.Query()
-	filter := storage.LogFilter{
-		Upstream: query.Get("upstream"),
-		Method:   query.Get("method"),
-		Path:     query.Get("path"),
-		Tag:      query.Get("tag"),
-	}
-
-	if statusCode := query.Get("status_code"); statusCode != "" {
-		if code, err := strconv.Atoi(statusCode); err == nil {
-			filter.StatusCode = code
-		}
-	}
-
-	if offset := query.Get("offset"); offset != "" {
-		if o, err := strconv.Atoi(offset); err == nil {
-			filter.Offset = o
-		}
-	}
-
-	if limit := query.Get("limit"); limit != "" {
-		if l, err := strconv.Atoi(limit); err == nil {
-			filter.Limit = l
-		}
-	}
-
-	if startTime := query.Get("start_time"); startTime != "" {
-		if t, err := time.Parse(time.RFC3339, startTime); err == nil {
-			filter.StartTime = &t
-		}
-	}
-
-	if endTime := query.Get("end_time"); endTime != "" {
-		if t, err := time.Parse(time.RFC3339, endTime); err == nil {
-			filter.EndTime = &t
-		}
+	filter, err := parseLogFilter(r.URL.Query())
+	if err != nil {
+		h.jsonError(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	logs, total, err := h.repo.ListLogs(filter)
+	logs, total, err := h.repo.ListLogs(r.Context(), filter)
 	if err != nil {
 		h.jsonError(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -123,27 +172,115 @@ func (h *Handler) handleLogs(w http.ResponseWriter, r *http.Request) {
 
 // handleLogDetail 获取日志详情
 func (h *Handler) handleLogDetail(w http.ResponseWriter, r *http.Request) {
+	// 从路径中提取 ID: /api/logs/{id}[/{sub}]
+	id, sub, _ := strings.Cut(r.URL.Path[len("/api/logs/"):], "/")
+	if id == "" {
+		h.jsonError(w, "缺少日志 ID", http.StatusBadRequest)
+		return
+	}
+	if sub == "metadata" {
+		h.handleLogMetadata(w, r, id)
+		return
+	}
 	if r.Method != http.MethodGet {
 		h.jsonError(w, "方法不允许", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// 从路径中提取 ID: /api/logs/{id}
-	id := r.URL.Path[len("/api/logs/"):]
-	if id == "" {
-		h.jsonError(w, "缺少日志 ID", http.StatusBadRequest)
+	switch sub {
+	case "":
+	case "bundle":
+		h.handleLogBundle(w, r, id)
+		return
+	case "events":
+		h.handleLogEvents(w, r, id)
+		return
+	case "output":
+		h.handleLogOutput(w, r, id)
+		return
+	case "tools":
+		h.handleLogTools(w, r, id)
+		return
+	case "drift":
+		h.handleLogDrift(w, r, id)
+		return
+	case "replays":
+		h.handleLogReplays(w, r, id)
+		return
+	default:
+		h.jsonError(w, "未知的日志子资源: "+sub, http.StatusNotFound)
+		return
+	}
+
+	view := r.URL.Query().Get("view")
+	if view != "" && view != "raw" && view != "merged" {
+		h.jsonError(w, "view 必须是 raw 或 merged", http.StatusBadRequest)
 		return
 	}
 
-	log, err := h.repo.GetLog(id)
+	log, err := h.repo.GetLog(r.Context(), id)
 	if err != nil {
 		h.jsonError(w, "日志不存在", http.StatusNotFound)
 		return
 	}
 
+	if err := h.applyLogView(r.Context(), log, view); err != nil {
+		h.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if includeBodies, _ := strconv.ParseBool(r.URL.Query().Get("include_bodies")); includeBodies {
+		if err := h.inlineBodies(r.Context(), log, true); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, storage.ErrArchiveUnavailable) {
+				status = http.StatusServiceUnavailable
+			}
+			h.jsonError(w, err.Error(), status)
+			return
+		}
+	}
 	h.jsonResponse(w, log)
 }
 
+// handleLogPurge 按内容删除日志及其 blob (数据删除请求)
+func (h *Handler) handleLogPurge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.jsonError(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Contains string `json:"contains"`
+		Pattern  string `json:"pattern"`
+		DryRun   bool   `json:"dry_run"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20) // 1MB
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.jsonError(w, "无效的请求体", http.StatusBadRequest)
+		return
+	}
+	if req.Contains == "" && req.Pattern == "" {
+		h.jsonError(w, "contains 或 pattern 必填", http.StatusBadRequest)
+		return
+	}
+
+	q := storage.PurgeQuery{Contains: req.Contains, DryRun: req.DryRun}
+	if req.Pattern != "" {
+		re, err := regexp.Compile(req.Pattern)
+		if err != nil {
+			h.jsonError(w, "无效的 pattern: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		q.Pattern = re
+	}
+
+	res, err := storage.PurgeByContent(r.Context(), h.repo, h.blobs, q)
+	if err != nil {
+		h.jsonError(w, "删除失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.jsonResponse(w, res)
+}
+
 // handleStats 获取统计信息
 func (h *Handler) handleStats(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -158,7 +295,7 @@ func (h *Handler) handleStats(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	stats, err := h.repo.GetStats(since)
+	stats, err := h.repo.GetStats(r.Context(), since)
 	if err != nil {
 		h.jsonError(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -167,18 +304,378 @@ func (h *Handler) handleStats(w http.ResponseWriter, r *http.Request) {
 	h.jsonResponse(w, stats)
 }
 
+// handlePropertyStats 按自定义属性值聚合统计
+func (h *Handler) handlePropertyStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.jsonError(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	key := strings.ToLower(strings.TrimSpace(q.Get("key")))
+	if key == "" {
+		h.jsonError(w, "缺少 key 参数", http.StatusBadRequest)
+		return
+	}
+	var since *time.Time
+	if sinceStr := q.Get("since"); sinceStr != "" {
+		if t, err := time.Parse(time.RFC3339, sinceStr); err == nil {
+			since = &t
+		}
+	}
+	limit, _ := strconv.Atoi(q.Get("limit"))
+
+	stats, err := h.repo.GetPropertyStats(r.Context(), key, since, limit)
+	if err != nil {
+		h.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.jsonResponse(w, map[string]interface{}{"key": key, "values": stats})
+}
+
+// handleCheckStats 按上游统计各项响应检查的通过/失败次数
+func (h *Handler) handleCheckStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.jsonError(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	var since *time.Time
+	if sinceStr := q.Get("since"); sinceStr != "" {
+		if t, err := time.Parse(time.RFC3339, sinceStr); err == nil {
+			since = &t
+		}
+	}
+
+	stats, err := h.repo.GetCheckStats(r.Context(), strings.ToLower(strings.TrimSpace(q.Get("upstream"))), since)
+	if err != nil {
+		h.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.jsonResponse(w, map[string]interface{}{"checks": stats})
+}
+
+// handleTrafficStats 按天、按上游汇总请求/响应体流量（默认最近 30 天）
+func (h *Handler) handleTrafficStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.jsonError(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	const dayLayout = "2006-01-02"
+	q := r.URL.Query()
+	to := time.Now()
+	if v := q.Get("to"); v != "" {
+		t, err := time.ParseInLocation(dayLayout, v, time.Local)
+		if err != nil {
+			h.jsonError(w, "to 格式应为 YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		to = t
+	}
+	from := to.AddDate(0, 0, -29)
+	if v := q.Get("from"); v != "" {
+		t, err := time.ParseInLocation(dayLayout, v, time.Local)
+		if err != nil {
+			h.jsonError(w, "from 格式应为 YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		from = t
+	}
+	upstream := strings.ToLower(strings.TrimSpace(q.Get("upstream")))
+
+	days, err := h.repo.GetTrafficStats(r.Context(), from.Format(dayLayout), to.Format(dayLayout), upstream)
+	if err != nil {
+		h.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	totals := make(map[string]*storage.TrafficStat)
+	for _, d := range days {
+		t, ok := totals[d.Upstream]
+		if !ok {
+			t = &storage.TrafficStat{Upstream: d.Upstream}
+			totals[d.Upstream] = t
+		}
+		t.Requests += d.Requests
+		t.BytesIn += d.BytesIn
+		t.BytesOut += d.BytesOut
+	}
+
+	h.jsonResponse(w, map[string]interface{}{
+		"from":   from.Format(dayLayout),
+		"to":     to.Format(dayLayout),
+		"days":   days,
+		"totals": totals,
+	})
+}
+
+// handleHourlyStats 按小时、上游、模型、状态类别汇总请求数、token 和延迟（默认最近 7 天）
+func (h *Handler) handleHourlyStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.jsonError(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	const hourLayout = "2006-01-02T15"
+	q := r.URL.Query()
+	to := time.Now()
+	if v := q.Get("to"); v != "" {
+		t, err := time.ParseInLocation(hourLayout, v, time.Local)
+		if err != nil {
+			h.jsonError(w, "to 格式应为 YYYY-MM-DDTHH", http.StatusBadRequest)
+			return
+		}
+		to = t
+	}
+	from := to.Add(-7 * 24 * time.Hour)
+	if v := q.Get("from"); v != "" {
+		t, err := time.ParseInLocation(hourLayout, v, time.Local)
+		if err != nil {
+			h.jsonError(w, "from 格式应为 YYYY-MM-DDTHH", http.StatusBadRequest)
+			return
+		}
+		from = t
+	}
+	upstream := strings.ToLower(strings.TrimSpace(q.Get("upstream")))
+
+	hours, err := h.repo.GetHourlyRollups(r.Context(), from.Format(hourLayout), to.Format(hourLayout), upstream, strings.TrimSpace(q.Get("model")))
+	if err != nil {
+		h.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.jsonResponse(w, map[string]interface{}{
+		"from":  from.Format(hourLayout),
+		"to":    to.Format(hourLayout),
+		"hours": hours,
+	})
+}
+
+// handleStorageStats 获取存储占用（数据库、WAL、blob）
+func (h *Handler) handleStorageStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.jsonError(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	stats, err := h.repo.GetStorageStats(r.Context())
+	if err != nil {
+		h.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if bs, ok := h.blobs.(storage.BlobStatser); ok {
+		blobStats, err := bs.Stats(r.Context())
+		if err != nil {
+			h.jsonError(w, "统计 blob 失败: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		stats.Blobs = &blobStats
+	}
+	if t, ok := h.blobs.(*storage.TieredBlobStore); ok {
+		if as, ok := t.Archive().(storage.BlobStatser); ok {
+			archiveStats, err := as.Stats(r.Context())
+			if err != nil {
+				h.jsonError(w, "统计归档存储失败: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			stats.Archive = &archiveStats
+		}
+	}
+
+	h.jsonResponse(w, stats)
+}
+
+// handleStorageUpstreams 按上游统计存储占用（行数、内联请求体、blob）
+func (h *Handler) handleStorageUpstreams(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.jsonError(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	footprints, err := h.repo.GetUpstreamFootprints(r.Context())
+	if err != nil {
+		h.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var total int64
+	for _, f := range footprints {
+		total += f.TotalBytes
+	}
+	h.jsonResponse(w, map[string]interface{}{
+		"upstreams":   footprints,
+		"total_bytes": total,
+	})
+}
+
+// handleBlobGC 立即回收未被日志引用的 blob
+func (h *Handler) handleBlobGC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.jsonError(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+	fsStore, ok := storage.HotBlobs(h.blobs).(*storage.FileBlobStore)
+	if !ok {
+		h.jsonError(w, "当前 blob 存储不支持垃圾回收", http.StatusNotImplemented)
+		return
+	}
+
+	// Blobs newer than min_age are kept: their log may still be in the async queue.
+	minAge := time.Hour
+	if v := r.URL.Query().Get("min_age"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			h.jsonError(w, "无效的 min_age", http.StatusBadRequest)
+			return
+		}
+		minAge = d
+	}
+
+	refs, err := h.repo.ListBlobRefs(r.Context())
+	if err != nil {
+		h.jsonError(w, "读取 blob 引用失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	report, err := fsStore.GarbageCollect(r.Context(), refs, minAge)
+	if err != nil {
+		h.jsonError(w, "blob 回收失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.jsonResponse(w, report)
+}
+
+// handlePurgeBodies 删除早于指定天数的日志的 blob 请求/响应体，保留日志与预览
+func (h *Handler) handlePurgeBodies(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.jsonError(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		OlderThanDays int  `json:"older_than_days"`
+		DryRun        bool `json:"dry_run"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20) // 1MB
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.jsonError(w, "无效的请求体", http.StatusBadRequest)
+		return
+	}
+	if req.OlderThanDays <= 0 {
+		h.jsonError(w, "older_than_days 必须大于 0", http.StatusBadRequest)
+		return
+	}
+
+	before := time.Now().AddDate(0, 0, -req.OlderThanDays)
+	res, err := storage.PurgeBodiesBefore(r.Context(), h.repo, h.blobs, before, req.DryRun)
+	if err != nil {
+		h.jsonError(w, "删除请求体失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.jsonResponse(w, res)
+}
+
+// handleArchiveBodies 立即将早于指定天数的日志的完整请求/响应体移入归档存储
+func (h *Handler) handleArchiveBodies(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.jsonError(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+	tiered, ok := h.blobs.(*storage.TieredBlobStore)
+	if !ok {
+		h.jsonError(w, "未配置归档存储 (storage.archive)", http.StatusNotImplemented)
+		return
+	}
+
+	var req struct {
+		OlderThanDays int `json:"older_than_days"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20) // 1MB
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.jsonError(w, "无效的请求体", http.StatusBadRequest)
+		return
+	}
+	if req.OlderThanDays <= 0 {
+		h.jsonError(w, "older_than_days 必须大于 0", http.StatusBadRequest)
+		return
+	}
+
+	before := time.Now().AddDate(0, 0, -req.OlderThanDays)
+	res, err := storage.ArchiveBodiesBefore(r.Context(), h.repo, tiered, before, h.cfg.LoggingSnapshot().BodyPreviewBytes)
+	if err != nil {
+		h.jsonError(w, "归档请求体失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.jsonResponse(w, res)
+}
+
+// handleBackup 下载备份归档（数据库快照 + 引用的 blob + 配置文件）
+func (h *Handler) handleBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		h.jsonError(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Build the archive in a temp file first so failures still get a JSON
+	// error and the download has a Content-Length.
+	f, err := os.CreateTemp("", "prismcat-backup-*.tar.gz")
+	if err != nil {
+		h.jsonError(w, "创建备份失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if _, err := storage.WriteBackup(r.Context(), f, h.repo, h.blobs, h.cfg.Path()); err != nil {
+		h.jsonError(w, "创建备份失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		h.jsonError(w, "创建备份失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	name := "prismcat-backup-" + time.Now().Format("20060102-150405") + ".tar.gz"
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	http.ServeContent(w, r, name, time.Time{}, f)
+}
+
+// defaultTargets are the targets of upstream types that have one.
+var defaultTargets = map[string]string{
+	config.UpstreamTypeDemo:     config.DemoTarget,
+	config.UpstreamTypeVertexAI: config.VertexDefaultTarget,
+	config.UpstreamTypeOllama:   config.OllamaDefaultTarget,
+	config.UpstreamTypeLMStudio: config.LMStudioDefaultTarget,
+}
+
 // handleUpstreams 获取或管理上游配置
 func (h *Handler) handleUpstreams(w http.ResponseWriter, r *http.Request) {
 	// GET: 获取列表
 	if r.Method == http.MethodGet {
 		upstreams := make([]map[string]interface{}, 0)
+		add := func(name string, upCfg config.UpstreamConfig) map[string]interface{} {
+			item := map[string]interface{}{
+				"name":        name,
+				"type":        upCfg.Type,
+				"target":      upCfg.Target,
+				"timeout":     upCfg.Timeout,
+				"maintenance": upCfg.Maintenance,
+				"disabled":    upCfg.Disabled,
+			}
+			upstreams = append(upstreams, item)
+			return item
+		}
 		// Snapshot upstreams for safe iteration.
 		for name, upCfg := range h.cfg.ListUpstreams() {
-			upstreams = append(upstreams, map[string]interface{}{
-				"name":    name,
-				"target":  upCfg.Target,
-				"timeout": upCfg.Timeout,
-			})
+			add(name, upCfg)
+		}
+		// Deleted upstreams let the UI describe historical logs.
+		if includeDeleted, _ := strconv.ParseBool(r.URL.Query().Get("include_deleted")); includeDeleted {
+			for name, upCfg := range h.cfg.ListDeletedUpstreams() {
+				add(name, upCfg)["deleted_at"] = upCfg.DeletedAt
+			}
 		}
 		h.jsonResponse(w, upstreams)
 		return
@@ -187,24 +684,61 @@ func (h *Handler) handleUpstreams(w http.ResponseWriter, r *http.Request) {
 	// POST: 添加/更新
 	if r.Method == http.MethodPost {
 		var req struct {
-			Name    string `json:"name"`
-			Target  string `json:"target"`
-			Timeout int    `json:"timeout"`
+			Name     string `json:"name"`
+			Type     string `json:"type"`
+			Target   string `json:"target"`
+			Timeout  int    `json:"timeout"`
+			Disabled *bool  `json:"disabled"`
 		}
 		r.Body = http.MaxBytesReader(w, r.Body, 1<<20) // 1MB
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			h.jsonError(w, "无效的请求体", http.StatusBadRequest)
 			return
 		}
+		req.Type = strings.ToLower(strings.TrimSpace(req.Type))
+		switch req.Type {
+		case "", config.UpstreamTypeAzureOpenAI, config.UpstreamTypeBedrock:
+		case config.UpstreamTypeDemo, config.UpstreamTypeVertexAI, config.UpstreamTypeOllama, config.UpstreamTypeLMStudio:
+			if req.Target == "" {
+				req.Target = defaultTargets[req.Type]
+			}
+		default:
+			h.jsonError(w, "不支持的上游类型: "+req.Type, http.StatusBadRequest)
+			return
+		}
 		if req.Name == "" || req.Target == "" {
 			h.jsonError(w, "名称和目标必填", http.StatusBadRequest)
 			return
 		}
 
-		err := h.cfg.AddUpstream(req.Name, config.UpstreamConfig{
-			Target:  req.Target,
-			Timeout: req.Timeout,
-		})
+		// Keep settings not exposed by this endpoint (e.g. response_schemas).
+		upCfg := config.UpstreamConfig{}
+		if existing, ok := h.cfg.GetUpstream(req.Name); ok {
+			upCfg = *existing
+		}
+		// Clients unaware of types send none: keep the existing type, except
+		// that a demo upstream given a real target becomes a plain proxy.
+		switch {
+		case req.Type != "":
+			upCfg.Type = req.Type
+		case upCfg.Type == config.UpstreamTypeDemo && req.Target != config.DemoTarget:
+			upCfg.Type = ""
+		}
+		if upCfg.Type == config.UpstreamTypeAzureOpenAI && upCfg.Azure.APIVersion == "" {
+			upCfg.Azure.APIVersion = config.DefaultAzureAPIVersion
+		}
+		if upCfg.Type == config.UpstreamTypeBedrock && upCfg.Bedrock.Region == "" {
+			if upCfg.Bedrock.Region = config.BedrockRegion(req.Target); upCfg.Bedrock.Region == "" {
+				h.jsonError(w, "无法从目标地址识别 AWS 区域，请在配置文件中设置 bedrock.region", http.StatusBadRequest)
+				return
+			}
+		}
+		upCfg.Target = req.Target
+		upCfg.Timeout = req.Timeout
+		if req.Disabled != nil {
+			upCfg.Disabled = *req.Disabled
+		}
+		err := h.cfg.AddUpstream(req.Name, upCfg)
 		if err != nil {
 			h.jsonError(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -224,7 +758,12 @@ func (h *Handler) handleUpstreams(w http.ResponseWriter, r *http.Request) {
 			h.jsonError(w, "名称必填", http.StatusBadRequest)
 			return
 		}
-		if err := h.cfg.RemoveUpstream(name); err != nil {
+		// Deletion is soft (logs keep resolving the upstream) unless purge=true.
+		remove := h.cfg.RemoveUpstream
+		if purge, _ := strconv.ParseBool(r.URL.Query().Get("purge")); purge {
+			remove = h.cfg.PurgeUpstream
+		}
+		if err := remove(name); err != nil {
 			h.jsonError(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -239,15 +778,113 @@ func (h *Handler) handleUpstreams(w http.ResponseWriter, r *http.Request) {
 	h.jsonError(w, "方法不允许", http.StatusMethodNotAllowed)
 }
 
+// handleUpstreamMaintenance 开启或关闭上游维护模式
+func (h *Handler) handleUpstreamMaintenance(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.jsonError(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+		config.MaintenanceConfig
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20) // 1MB
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.jsonError(w, "无效的请求体", http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" {
+		h.jsonError(w, "名称必填", http.StatusBadRequest)
+		return
+	}
+	if req.Status != 0 && (req.Status < 400 || req.Status > 599) {
+		h.jsonError(w, "status 必须是 4xx 或 5xx", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.cfg.SetUpstreamMaintenance(req.Name, req.MaintenanceConfig); err != nil {
+		h.jsonError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err := h.cfg.Save(); err != nil {
+		h.jsonError(w, "保存配置失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.jsonResponse(w, map[string]string{"status": "ok"})
+}
+
 // handleHealth 健康检查
 func (h *Handler) handleHealth(w http.ResponseWriter, r *http.Request) {
-	h.jsonResponse(w, map[string]string{
+	resp := map[string]interface{}{
 		"status":  "ok",
 		"version": config.Version,
 		"time":    time.Now().Format(time.RFC3339),
+	}
+	if q, ok := h.repo.(interface{ QueueStats() storage.QueueStats }); ok {
+		resp["log_queue"] = q.QueueStats()
+	}
+	if h.sinks != nil {
+		resp["sinks"] = h.sinks.Stats()
+	}
+	if cc := h.cfg.ClusterSnapshot(); cc.Enabled && h.leader != nil {
+		resp["cluster"] = map[string]interface{}{
+			"instance_id": cc.InstanceID,
+			"leader":      h.leader(),
+		}
+	}
+	if h.disk != nil {
+		disk := h.disk.Status()
+		resp["disk"] = disk
+		if disk.Low {
+			resp["status"] = "degraded"
+		}
+	}
+	if h.recover != nil {
+		recovery := h.recover.Status()
+		resp["recovery"] = recovery
+		if recovery.Integrity == "failed" {
+			resp["status"] = "degraded"
+		}
+	}
+	h.jsonResponse(w, resp)
+}
+
+// handleDebug 返回排查用的运行时内部状态（DNS 缓存等）
+func (h *Handler) handleDebug(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.jsonError(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+	dnsCfg := h.cfg.DNSSnapshot()
+	dns := map[string]interface{}{
+		"enabled":              dnsCfg.CacheTTLSeconds > 0,
+		"cache_ttl_seconds":    dnsCfg.CacheTTLSeconds,
+		"negative_ttl_seconds": dnsCfg.NegativeTTLSeconds,
+	}
+	if h.dns != nil {
+		dns["stats"] = h.dns.Stats()
+	}
+	h.jsonResponse(w, map[string]interface{}{
+		"dns_cache": dns,
 	})
 }
 
+// handleMetrics 以 Prometheus 文本格式输出上游连接指标
+func (h *Handler) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.jsonError(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.metrics == nil {
+		h.jsonError(w, "指标未启用", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	// 写出失败只可能是客户端断开，无需处理
+	_ = h.metrics.WriteMetrics(w)
+}
+
 // handleConfig 获取或更新配置
 func (h *Handler) handleConfig(w http.ResponseWriter, r *http.Request) {
 	// GET: 获取配置
@@ -267,6 +904,8 @@ func (h *Handler) handleConfig(w http.ResponseWriter, r *http.Request) {
 				"detach_body_over_bytes": logging.DetachBodyOverBytes,
 				"body_preview_bytes":     logging.BodyPreviewBytes,
 				"store_base64":           logging.StoreBase64,
+				"summarize_embeddings":   logging.SummarizeEmbeddings,
+				"allow_no_log_header":    logging.AllowNoLogHeader,
 			},
 			"storage": map[string]interface{}{
 				"database":       storageCfg.Database,
@@ -288,6 +927,8 @@ func (h *Handler) handleConfig(w http.ResponseWriter, r *http.Request) {
 				DetachBodyOver   *int64    `json:"detach_body_over_bytes"`
 				BodyPreviewBytes *int64    `json:"body_preview_bytes"`
 				StoreBase64      *bool     `json:"store_base64"`
+				SummarizeEmbed   *bool     `json:"summarize_embeddings"`
+				AllowNoLog       *bool     `json:"allow_no_log_header"`
 			} `json:"logging"`
 			Storage *struct {
 				RetentionDays *int `json:"retention_days"`
@@ -320,6 +961,12 @@ func (h *Handler) handleConfig(w http.ResponseWriter, r *http.Request) {
 				if req.Logging.StoreBase64 != nil {
 					c.Logging.StoreBase64 = *req.Logging.StoreBase64
 				}
+				if req.Logging.SummarizeEmbed != nil {
+					c.Logging.SummarizeEmbeddings = *req.Logging.SummarizeEmbed
+				}
+				if req.Logging.AllowNoLog != nil {
+					c.Logging.AllowNoLogHeader = *req.Logging.AllowNoLog
+				}
 			}
 
 			if req.Storage != nil {
@@ -342,7 +989,7 @@ func (h *Handler) handleConfig(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) handleBlob(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut {
 		h.jsonError(w, "方法不允许", http.StatusMethodNotAllowed)
 		return
 	}
@@ -360,12 +1007,21 @@ func (h *Handler) handleBlob(w http.ResponseWriter, r *http.Request) {
 		ref = unescaped
 	}
 
+	if r.Method == http.MethodPut {
+		h.putBlob(w, r, ref)
+		return
+	}
+
 	data, err := h.blobs.Get(r.Context(), ref)
 	if err != nil {
 		if err == storage.ErrBlobNotFound {
 			http.NotFound(w, r)
 			return
 		}
+		if errors.Is(err, storage.ErrArchiveUnavailable) {
+			h.jsonError(w, "归档存储暂时不可达: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
 		h.jsonError(w, "读取 blob 失败: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -375,6 +1031,83 @@ func (h *Handler) handleBlob(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(data)
 }
 
+// putBlob 接收转发实例上传的 body；内容必须与 ref 的哈希一致
+func (h *Handler) putBlob(w http.ResponseWriter, r *http.Request, ref string) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIngestBytes))
+	if err != nil {
+		h.jsonError(w, "读取请求体失败", http.StatusBadRequest)
+		return
+	}
+	got, err := h.blobs.Put(r.Context(), data)
+	if err != nil {
+		h.jsonError(w, "写入 blob 失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Content addressing makes a mismatched upload harmless, but tell the
+	// sender so it doesn't assume the ref now resolves.
+	if !strings.EqualFold(got, ref) {
+		h.jsonError(w, "内容与 ref 不匹配", http.StatusBadRequest)
+		return
+	}
+	h.jsonResponse(w, map[string]string{"status": "ok"})
+}
+
+// maxIngestBytes 限制 /api/ingest 与 blob 上传的请求体大小
+const maxIngestBytes = 64 << 20 // 64MB
+
+// handleIngest 接收其他 PrismCat 实例转发的日志（团队汇总）
+func (h *Handler) handleIngest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.jsonError(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Source string                `json:"source"`
+		Logs   []*storage.RequestLog `json:"logs"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxIngestBytes)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.jsonError(w, "无效的请求体", http.StatusBadRequest)
+		return
+	}
+
+	accepted := 0
+	missing := []string{}
+	seen := make(map[string]bool)
+	for _, l := range req.Logs {
+		if l == nil || l.ID == "" {
+			continue
+		}
+		if l.Source == "" {
+			l.Source = req.Source
+		}
+		if err := h.repo.SaveLog(r.Context(), l); err != nil {
+			h.jsonError(w, "保存日志失败: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		accepted++
+
+		if h.blobs == nil {
+			continue
+		}
+		for _, ref := range []string{l.RequestBodyRef, l.ResponseBodyRef} {
+			if ref == "" || seen[ref] {
+				continue
+			}
+			seen[ref] = true
+			if ok, err := h.blobs.Exists(r.Context(), ref); err == nil && !ok {
+				missing = append(missing, ref)
+			}
+		}
+	}
+
+	h.jsonResponse(w, map[string]interface{}{
+		"accepted":      accepted,
+		"missing_blobs": missing,
+	})
+}
+
 // handleReplay sends a request to the configured upstream and returns the response.
 func (h *Handler) handleReplay(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -388,6 +1121,8 @@ func (h *Handler) handleReplay(w http.ResponseWriter, r *http.Request) {
 		Path     string            `json:"path"`
 		Headers  map[string]string `json:"headers"`
 		Body     string            `json:"body"`
+		// LogID names the log being replayed; the replay is recorded in its history.
+		LogID string `json:"log_id"`
 	}
 	r.Body = http.MaxBytesReader(w, r.Body, 100<<20) // 100MB
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -405,6 +1140,12 @@ func (h *Handler) handleReplay(w http.ResponseWriter, r *http.Request) {
 		h.jsonError(w, "未知的 upstream: "+req.Upstream, http.StatusBadRequest)
 		return
 	}
+	if req.LogID != "" {
+		if _, err := h.repo.GetLogMetadata(r.Context(), req.LogID); errors.Is(err, storage.ErrLogNotFound) {
+			h.jsonError(w, "日志不存在", http.StatusNotFound)
+			return
+		}
+	}
 
 	targetURL, err := url.Parse(upstream.Target)
 	if err != nil {
@@ -420,6 +1161,25 @@ func (h *Handler) handleReplay(w http.ResponseWriter, r *http.Request) {
 		}
 		fullURL += req.Path
 	}
+	if upstream.Type == config.UpstreamTypeAzureOpenAI {
+		in, err := url.Parse(req.Path)
+		if err != nil {
+			h.jsonError(w, "无效的请求路径", http.StatusBadRequest)
+			return
+		}
+		var body struct {
+			Model string `json:"model"`
+		}
+		if azure.NeedsModel(in.Path) {
+			_ = json.Unmarshal([]byte(req.Body), &body)
+		}
+		u, err := azure.RequestURL(targetURL, in, body.Model, upstream.Azure)
+		if err != nil {
+			h.jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fullURL = u.String()
+	}
 
 	timeout := upstream.Timeout
 	if timeout <= 0 {
@@ -444,9 +1204,47 @@ func (h *Handler) handleReplay(w http.ResponseWriter, r *http.Request) {
 		upstreamReq.Header.Set(k, v)
 	}
 	upstreamReq.Host = targetURL.Host
+	if upstream.Type == config.UpstreamTypeAzureOpenAI {
+		azure.ApplyAuth(upstreamReq.Header, upstream.Azure)
+	}
+	if upstream.Type == config.UpstreamTypeVertexAI {
+		tok, err := h.gcp.Token(ctx, upstream.Vertex.CredentialsFile)
+		if err != nil {
+			h.jsonError(w, "获取 Google 访问令牌失败: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		upstreamReq.Header.Set("Authorization", "Bearer "+tok)
+	}
+	if upstream.Type == config.UpstreamTypeBedrock {
+		bc := upstream.Bedrock
+		creds, err := h.aws.Resolve(ctx, awsauth.Credentials{
+			AccessKeyID:     bc.AccessKeyID,
+			SecretAccessKey: bc.SecretAccessKey,
+			SessionToken:    bc.SessionToken,
+		})
+		if err != nil {
+			h.jsonError(w, "AWS 凭证不可用: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		awsauth.Sign(upstreamReq, []byte(req.Body), "bedrock", bc.Region, creds, time.Now())
+	}
 
-	resp, err := h.client.Do(upstreamReq)
+	client := h.client
+	if upstream.Type == config.UpstreamTypeDemo {
+		client = demo.Client()
+	}
+	replay := &storage.Replay{
+		LogID:     req.LogID,
+		CreatedAt: time.Now(),
+		Actor:     replayActor(r),
+		Upstream:  req.Upstream,
+		Method:    req.Method,
+		Path:      req.Path,
+	}
+	resp, err := client.Do(upstreamReq)
 	if err != nil {
+		replay.Error = err.Error()
+		h.recordReplay(replay)
 		h.jsonError(w, "上游请求失败: "+err.Error(), http.StatusBadGateway)
 		return
 	}
@@ -460,6 +1258,12 @@ func (h *Handler) handleReplay(w http.ResponseWriter, r *http.Request) {
 		respBody = respBody[:maxRespBody]
 		truncated = true
 	}
+	replay.StatusCode = resp.StatusCode
+	replay.ResponseSize = int64(len(respBody))
+	if err != nil {
+		replay.Error = err.Error()
+	}
+	h.recordReplay(replay)
 
 	respHeaders := make(map[string][]string)
 	for k, vv := range resp.Header {
@@ -468,12 +1272,16 @@ func (h *Handler) handleReplay(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	h.jsonResponse(w, map[string]interface{}{
+	result := map[string]interface{}{
 		"status_code": resp.StatusCode,
 		"headers":     respHeaders,
 		"body":        string(respBody),
 		"truncated":   truncated,
-	})
+	}
+	if replay.ID != "" {
+		result["replay_id"] = replay.ID
+	}
+	h.jsonResponse(w, result)
 }
 
 // jsonResponse 发送 JSON 响应
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/sink"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+func TestRemoteSinkShipsLogsAndMissingBlobs(t *testing.T) {
+	ctx := context.Background()
+
+	centralRepo, err := storage.NewSQLiteRepository(filepath.Join(t.TempDir(), "central.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = centralRepo.Close() })
+	centralBlobs, err := storage.NewFileBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	New(&config.Config{}, centralRepo, centralBlobs).RegisterRoutes(mux)
+	central := httptest.NewServer(mux)
+	defer central.Close()
+
+	localBlobs, err := storage.NewFileBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := localBlobs.Put(ctx, []byte(`{"big":"response"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remote, err := sink.NewRemote(config.RemoteSinkConfig{URL: central.URL, Source: "alice"}, localBlobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = remote.Write(ctx, []*storage.RequestLog{{
+		ID:              "log-1",
+		CreatedAt:       time.Now(),
+		Upstream:        "openai",
+		Method:          "POST",
+		Path:            "/v1/chat/completions",
+		StatusCode:      200,
+		ResponseBodyRef: ref,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := centralRepo.GetLog(context.Background(), "log-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Source != "alice" || got.ResponseBodyRef != ref {
+		t.Fatalf("central log = source %q ref %q", got.Source, got.ResponseBodyRef)
+	}
+	if data, err := centralBlobs.Get(ctx, ref); err != nil || string(data) != `{"big":"response"}` {
+		t.Fatalf("central blob = %q, %v", data, err)
+	}
+
+	logs, total, err := centralRepo.ListLogs(context.Background(), storage.LogFilter{Source: "alice", Limit: 10})
+	if err != nil || total != 1 || len(logs) != 1 {
+		t.Fatalf("source filter: total=%d len=%d err=%v", total, len(logs), err)
+	}
+}
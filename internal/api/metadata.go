@@ -0,0 +1,60 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+// handleLogMetadata 读取 (GET) 或合并更新 (PATCH) 日志元数据；PATCH 中值为 null 或空字符串表示删除该键
+func (h *Handler) handleLogMetadata(w http.ResponseWriter, r *http.Request, id string) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPatch:
+		var req map[string]*string
+		r.Body = http.MaxBytesReader(w, r.Body, 1<<20) // 1MB
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			h.jsonError(w, "无效的请求体", http.StatusBadRequest)
+			return
+		}
+		md := make(map[string]string, len(req))
+		for k, v := range req {
+			if strings.TrimSpace(k) == "" {
+				h.jsonError(w, "元数据键不能为空", http.StatusBadRequest)
+				return
+			}
+			md[k] = ""
+			if v != nil {
+				md[k] = *v
+			}
+		}
+		if err := h.repo.SetLogMetadata(r.Context(), id, md); err != nil {
+			h.metadataError(w, err)
+			return
+		}
+	default:
+		h.jsonError(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	md, err := h.repo.GetLogMetadata(r.Context(), id)
+	if err != nil {
+		h.metadataError(w, err)
+		return
+	}
+	if md == nil {
+		md = map[string]string{}
+	}
+	h.jsonResponse(w, md)
+}
+
+func (h *Handler) metadataError(w http.ResponseWriter, err error) {
+	if errors.Is(err, storage.ErrLogNotFound) {
+		h.jsonError(w, "日志不存在", http.StatusNotFound)
+		return
+	}
+	h.jsonError(w, err.Error(), http.StatusInternalServerError)
+}
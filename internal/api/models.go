@@ -0,0 +1,68 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/models"
+)
+
+// modelsCacheTTL is how long an upstream's model listing is reused.
+const modelsCacheTTL = 5 * time.Minute
+
+// handleModels 汇总所有上游的模型列表 (OpenAI 列表格式，附带来源上游)
+func (h *Handler) handleModels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.jsonError(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	upstreams := h.cfg.ListUpstreams()
+	for name, up := range upstreams {
+		if up.Disabled {
+			delete(upstreams, name)
+		}
+	}
+	if names := splitList(query.Get("upstream")); len(names) > 0 {
+		selected := make(map[string]config.UpstreamConfig, len(names))
+		for _, name := range names {
+			up, ok := upstreams[strings.ToLower(name)]
+			if !ok {
+				h.jsonError(w, "未知的 upstream: "+name, http.StatusBadRequest)
+				return
+			}
+			selected[strings.ToLower(name)] = up
+		}
+		upstreams = selected
+	}
+	refresh, _ := strconv.ParseBool(query.Get("refresh"))
+
+	list, statuses := h.catalog.List(r.Context(), upstreams, refresh)
+	h.jsonResponse(w, map[string]interface{}{
+		"object":    "list",
+		"data":      list,
+		"upstreams": statuses,
+	})
+}
+
+// handleLocalDiscovery 探测本机的 Ollama / LM Studio 服务；POST 将未配置的服务注册为上游并保存配置
+func (h *Handler) handleLocalDiscovery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		h.jsonError(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	servers := models.DiscoverLocal(r.Context(), h.cfg.ListUpstreams())
+	if r.Method == http.MethodPost {
+		servers = models.RegisterLocal(h.cfg, servers)
+		if err := h.cfg.Save(); err != nil {
+			h.jsonError(w, "保存配置失败: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	h.jsonResponse(w, map[string]interface{}{"servers": servers})
+}
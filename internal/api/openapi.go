@@ -0,0 +1,978 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/prismcat/prismcat/internal/config"
+)
+
+// routeDoc describes a single management API operation for the OpenAPI document.
+// Keep this table in sync with RegisterRoutes when adding or changing endpoints.
+type routeDoc struct {
+	Method      string
+	Path        string
+	Summary     string
+	Params      []paramDoc
+	RequestBody string // schema name under components/schemas, empty for none
+	Response    string // schema name under components/schemas, empty for a generic object
+	ResponseRaw string // non-JSON response media type (e.g. "text/plain")
+}
+
+type paramDoc struct {
+	Name        string
+	In          string // "query" or "path"
+	Type        string // "string", "integer", "boolean"
+	Format      string
+	Description string
+	Required    bool
+}
+
+// logFilterParams documents the query parameters understood by parseLogFilter.
+// upstream, method, tag, path and status_code take comma-separated values;
+// the same names suffixed with "!" exclude matches (e.g. path!=/v1/models).
+var logFilterParams = []paramDoc{
+	{Name: "upstream", In: "query", Type: "string", Description: "Filter by upstream name(s), comma-separated"},
+	{Name: "upstream!", In: "query", Type: "string", Description: "Exclude upstream name(s)"},
+	{Name: "method", In: "query", Type: "string", Description: "Filter by HTTP method(s)"},
+	{Name: "method!", In: "query", Type: "string", Description: "Exclude HTTP method(s)"},
+	{Name: "path", In: "query", Type: "string", Description: "Substring match(es) on request path"},
+	{Name: "path_regex", In: "query", Type: "string", Description: "Go regexp matched against the request path, e.g. ^/v1/(chat|completions)"},
+	{Name: "path!", In: "query", Type: "string", Description: "Exclude paths containing any of these substrings"},
+	{Name: "tag", In: "query", Type: "string", Description: "Filter by X-PrismCat-Tag value(s)"},
+	{Name: "tag!", In: "query", Type: "string", Description: "Exclude tag value(s)"},
+	{Name: "status_code", In: "query", Type: "string", Description: "Status codes or ranges, e.g. 429,500-599"},
+	{Name: "status_code!", In: "query", Type: "string", Description: "Exclude status codes or ranges"},
+	{Name: "flag", In: "query", Type: "string", Description: "Filter by log flag (e.g. schema_invalid)"},
+	{Name: "variant", In: "query", Type: "string", Description: "Filter by canary variant (stable or canary)"},
+	{Name: "client_ip", In: "query", Type: "string", Description: "Filter by resolved client IP"},
+	{Name: "remote_addr", In: "query", Type: "string", Description: "Filter by direct peer address"},
+	{Name: "user_agent", In: "query", Type: "string", Description: "Substring match on User-Agent"},
+	{Name: "source", In: "query", Type: "string", Description: "Filter by source instance of shipped logs"},
+	{Name: "fingerprint", In: "query", Type: "string", Description: "Filter by request fingerprint (logs of equivalent requests)"},
+	{Name: "duplicate_of", In: "query", Type: "string", Description: "Repeats of the log with this ID (see logging.duplicate_window_seconds)"},
+	{Name: "property.<key>", In: "query", Type: "string", Description: "Exact match on a custom property from X-PrismCat-Property-<Key>; repeat for several keys"},
+	{Name: "metadata.<key>", In: "query", Type: "string", Description: "Exact match on a metadata key; repeat for several keys"},
+	{Name: "min_latency_ms", In: "query", Type: "integer", Description: "Minimum latency in milliseconds"},
+	{Name: "max_latency_ms", In: "query", Type: "integer", Description: "Maximum latency in milliseconds"},
+	{Name: "min_body_size", In: "query", Type: "integer", Description: "Minimum of max(request, response) body size in bytes"},
+	{Name: "max_body_size", In: "query", Type: "integer", Description: "Maximum of max(request, response) body size in bytes"},
+	{Name: "start_time", In: "query", Type: "string", Format: "date-time", Description: "RFC3339 lower bound on created_at"},
+	{Name: "end_time", In: "query", Type: "string", Format: "date-time", Description: "RFC3339 upper bound on created_at"},
+}
+
+var apiRoutes = []routeDoc{
+	{
+		Method:  http.MethodGet,
+		Path:    "/api/logs",
+		Summary: "List request logs (summaries, newest first)",
+		Params: append(logFilterParams,
+			paramDoc{Name: "offset", In: "query", Type: "integer", Description: "Pagination offset"},
+			paramDoc{Name: "limit", In: "query", Type: "integer", Description: "Page size (default 50, max 1000)"},
+		),
+		Response: "LogList",
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/api/logs/{id}",
+		Summary: "Get a single request log including headers and bodies",
+		Params: []paramDoc{
+			{Name: "id", In: "path", Type: "string", Required: true},
+			{Name: "view", In: "query", Type: "string", Description: "Streaming response body: raw (captured stream) or merged (reassembled JSON); default is as stored"},
+			{Name: "include_bodies", In: "query", Type: "boolean", Description: "Resolve detached and archived bodies server-side and return them in full, clearing their refs (a merged stream keeps its ref to the raw stream)"},
+		},
+		Response: "RequestLog",
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/api/logs/{id}/bundle",
+		Summary: "Export a log as a redacted, self-contained bundle for bug reports",
+		Params: []paramDoc{
+			{Name: "id", In: "path", Type: "string", Required: true},
+			{Name: "format", In: "query", Type: "string", Description: "json (default) or zip"},
+			{Name: "redact", In: "query", Type: "boolean", Description: "Also mask PII (emails, keys, cards, phones, IPs, logging.redact_patterns)"},
+		},
+		Response:    "LogBundle",
+		ResponseRaw: "application/zip",
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/api/logs/{id}/events",
+		Summary:  "Parse a captured streaming response into ordered SSE/NDJSON events",
+		Params:   []paramDoc{{Name: "id", In: "path", Type: "string", Required: true}},
+		Response: "LogEvents",
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/api/logs/{id}/output",
+		Summary: "Extract the assistant's text output from the response (any supported provider)",
+		Params: []paramDoc{
+			{Name: "id", In: "path", Type: "string", Required: true},
+			{Name: "format", In: "query", Type: "string", Description: "text returns the output as text/markdown instead of JSON"},
+		},
+		Response:    "LogOutput",
+		ResponseRaw: "text/markdown",
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/api/logs/{id}/tools",
+		Summary:  "Tool/function calls found in the request history and response, with arguments and results",
+		Params:   []paramDoc{{Name: "id", In: "path", Type: "string", Required: true}},
+		Response: "LogTools",
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/api/logs/{id}/drift",
+		Summary: "Logs with the same request fingerprint, oldest first, with response changes between consecutive entries",
+		Params: []paramDoc{
+			{Name: "id", In: "path", Type: "string", Required: true},
+			{Name: "limit", In: "query", Type: "integer", Description: "Most recent logs to compare (default 100, max 1000)"},
+		},
+		Response: "LogDrift",
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/api/logs/{id}/replays",
+		Summary:  "Replays run from this log (POST /api/replay with log_id), newest first",
+		Params:   []paramDoc{{Name: "id", In: "path", Type: "string", Required: true}},
+		Response: "Replays",
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/api/logs/{id}/metadata",
+		Summary:  "Get the metadata attached to a log",
+		Params:   []paramDoc{{Name: "id", In: "path", Type: "string", Required: true}},
+		Response: "Metadata",
+	},
+	{
+		Method:      http.MethodPatch,
+		Path:        "/api/logs/{id}/metadata",
+		Summary:     "Merge keys into a log's metadata; null or empty values remove keys",
+		Params:      []paramDoc{{Name: "id", In: "path", Type: "string", Required: true}},
+		RequestBody: "Metadata",
+		Response:    "Metadata",
+	},
+	{Method: http.MethodPost, Path: "/api/logs/purge", Summary: "Delete logs (and their blobs) whose content matches a string or regexp", RequestBody: "PurgeRequest", Response: "PurgeResult"},
+	{
+		Method:  http.MethodGet,
+		Path:    "/api/stats",
+		Summary: "Aggregate request statistics",
+		Params: []paramDoc{
+			{Name: "since", In: "query", Type: "string", Format: "date-time", Description: "RFC3339 lower bound on created_at"},
+		},
+		Response: "LogStats",
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/api/stats/properties",
+		Summary: "Request counts, errors and latency grouped by the values of a custom property",
+		Params: []paramDoc{
+			{Name: "key", In: "query", Type: "string", Required: true, Description: "Property key (lowercase)"},
+			{Name: "since", In: "query", Type: "string", Format: "date-time", Description: "RFC3339 lower bound on created_at"},
+			{Name: "limit", In: "query", Type: "integer", Description: "Maximum values returned, most frequent first (default 50, max 1000)"},
+		},
+		Response: "PropertyStats",
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/api/stats/checks",
+		Summary: "Pass and fail counts of each upstream's response checks",
+		Params: []paramDoc{
+			{Name: "upstream", In: "query", Type: "string", Description: "Only this upstream"},
+			{Name: "since", In: "query", Type: "string", Format: "date-time", Description: "RFC3339 lower bound on created_at"},
+		},
+		Response: "CheckStats",
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/api/stats/traffic",
+		Summary: "Daily request/response body volume per upstream (kept after logs are deleted)",
+		Params: []paramDoc{
+			{Name: "from", In: "query", Type: "string", Format: "date", Description: "First day, YYYY-MM-DD (default 29 days before to)"},
+			{Name: "to", In: "query", Type: "string", Format: "date", Description: "Last day, YYYY-MM-DD (default today)"},
+			{Name: "upstream", In: "query", Type: "string", Description: "Only this upstream"},
+		},
+		Response: "TrafficStats",
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/api/stats/hourly",
+		Summary: "Hourly request counts, tokens and latency per upstream, model and status class (kept after logs are deleted)",
+		Params: []paramDoc{
+			{Name: "from", In: "query", Type: "string", Description: "First hour, YYYY-MM-DDTHH local time (default 7 days before to)"},
+			{Name: "to", In: "query", Type: "string", Description: "Last hour, YYYY-MM-DDTHH local time (default this hour)"},
+			{Name: "upstream", In: "query", Type: "string", Description: "Only this upstream"},
+			{Name: "model", In: "query", Type: "string", Description: "Only this model"},
+		},
+		Response: "HourlyRollups",
+	},
+	{Method: http.MethodGet, Path: "/api/storage/stats", Summary: "Disk usage of the database and blob store", Response: "StorageStats"},
+	{Method: http.MethodGet, Path: "/api/storage/upstreams", Summary: "Storage taken by each upstream's logs (rows, inline body bytes, referenced blob bytes), largest first", Response: "UpstreamFootprints"},
+	{
+		Method:  http.MethodPost,
+		Path:    "/api/maintenance/blob-gc",
+		Summary: "Delete blobs no longer referenced by any log",
+		Params: []paramDoc{
+			{Name: "min_age", In: "query", Type: "string", Description: "Keep blobs newer than this Go duration (default 1h)"},
+		},
+		Response: "BlobGCReport",
+	},
+	{Method: http.MethodPost, Path: "/api/maintenance/purge-bodies", Summary: "Remove detached bodies of logs older than N days, keeping the logs and their previews", RequestBody: "BodyPurgeRequest", Response: "BodyPurgeResult"},
+	{Method: http.MethodPost, Path: "/api/maintenance/archive-bodies", Summary: "Move full bodies of logs older than N days to the archive tier now, keeping previews inline", RequestBody: "ArchiveRequest", Response: "ArchiveResult"},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/maintenance/backup",
+		Summary:     "Download a backup archive (database snapshot, referenced blobs, config)",
+		ResponseRaw: "application/gzip",
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/api/upstreams",
+		Summary:  "List configured upstreams",
+		Params:   []paramDoc{{Name: "include_deleted", In: "query", Type: "boolean", Description: "Also list deleted upstreams (with deleted_at), e.g. to describe historical logs"}},
+		Response: "UpstreamList",
+	},
+	{Method: http.MethodPost, Path: "/api/upstreams", Summary: "Add or update an upstream", RequestBody: "Upstream", Response: "Status"},
+	{
+		Method:  http.MethodDelete,
+		Path:    "/api/upstreams",
+		Summary: "Delete an upstream (soft: its config is kept for historical logs and can be restored)",
+		Params: []paramDoc{
+			{Name: "name", In: "query", Type: "string", Required: true},
+			{Name: "purge", In: "query", Type: "boolean", Description: "Remove the config for good"},
+		},
+		Response: "Status",
+	},
+	{Method: http.MethodPost, Path: "/api/upstreams/maintenance", Summary: "Enable or disable maintenance mode for an upstream", RequestBody: "MaintenanceRequest", Response: "Status"},
+	{
+		Method:  http.MethodGet,
+		Path:    "/api/upstreams/export",
+		Summary: "Export upstreams as the upstreams section of a config file",
+		Params: []paramDoc{
+			{Name: "format", In: "query", Type: "string", Description: "yaml (default) or json"},
+			{Name: "include_secrets", In: "query", Type: "boolean", Description: "Keep API keys and credential headers (secret references are always kept)"},
+		},
+		ResponseRaw: "application/yaml",
+	},
+	{
+		Method:      http.MethodPost,
+		Path:        "/api/upstreams/import",
+		Summary:     "Import upstreams from a YAML or JSON document (as exported, or a bare name -> upstream mapping) and save the config",
+		Params:      []paramDoc{{Name: "replace", In: "query", Type: "boolean", Description: "Remove upstreams not in the document"}},
+		RequestBody: "UpstreamImport",
+		Response:    "UpstreamImportResult",
+	},
+	{
+		Method:   http.MethodGet,
+		Path:     "/api/upstreams/{name}/stats",
+		Summary:  "Request volume, error breakdown, latency percentiles, token totals and last-seen time of one upstream",
+		Params:   []paramDoc{{Name: "name", In: "path", Type: "string", Required: true}, {Name: "since", In: "query", Type: "string", Format: "date-time", Description: "RFC3339 lower bound on created_at"}},
+		Response: "UpstreamStats",
+	},
+	{
+		Method:   http.MethodPost,
+		Path:     "/api/upstreams/{name}/enable",
+		Summary:  "Enable a disabled upstream",
+		Params:   []paramDoc{{Name: "name", In: "path", Type: "string", Required: true}},
+		Response: "Status",
+	},
+	{
+		Method:   http.MethodPost,
+		Path:     "/api/upstreams/{name}/disable",
+		Summary:  "Disable an upstream: requests get 503 upstream_disabled, the config is kept",
+		Params:   []paramDoc{{Name: "name", In: "path", Type: "string", Required: true}},
+		Response: "Status",
+	},
+	{
+		Method:   http.MethodPost,
+		Path:     "/api/upstreams/{name}/restore",
+		Summary:  "Restore a deleted upstream",
+		Params:   []paramDoc{{Name: "name", In: "path", Type: "string", Required: true}},
+		Response: "Status",
+	},
+	{Method: http.MethodGet, Path: "/api/upstreams/presets", Summary: "Built-in provider presets (OpenAI, Anthropic, Gemini, DeepSeek, OpenRouter, Groq)", Response: "UpstreamPresets"},
+	{Method: http.MethodPost, Path: "/api/upstreams/presets", Summary: "Add upstreams from presets, optionally with API keys, and save the config", RequestBody: "UpstreamPresetRequest", Response: "UpstreamPresetResult"},
+	{Method: http.MethodGet, Path: "/api/routes", Summary: "The routing table, in evaluation order", Response: "Routes"},
+	{Method: http.MethodPut, Path: "/api/routes", Summary: "Replace the routing table and save the config", RequestBody: "Routes", Response: "Status"},
+	{Method: http.MethodGet, Path: "/api/config", Summary: "Get runtime configuration", Response: "ConfigView"},
+	{Method: http.MethodPut, Path: "/api/config", Summary: "Update logging/storage configuration", RequestBody: "ConfigUpdate", Response: "Status"},
+	{Method: http.MethodGet, Path: "/api/health", Summary: "Health check", Response: "Health"},
+	{Method: http.MethodGet, Path: "/api/debug", Summary: "Runtime internals for troubleshooting (DNS cache)", Response: "Debug"},
+	{
+		Method:      http.MethodGet,
+		Path:        "/api/blobs/{ref}",
+		Summary:     "Download a detached body by content-addressed ref",
+		Params:      []paramDoc{{Name: "ref", In: "path", Type: "string", Required: true, Description: "Blob ref, e.g. sha256:<hex>"}},
+		ResponseRaw: "text/plain",
+	},
+	{
+		Method:  http.MethodPut,
+		Path:    "/api/blobs/{ref}",
+		Summary: "Upload a body for a ref reported missing by /api/ingest",
+		Params:  []paramDoc{{Name: "ref", In: "path", Type: "string", Required: true, Description: "Blob ref, e.g. sha256:<hex>"}},
+	},
+	{Method: http.MethodPost, Path: "/api/ingest", Summary: "Accept finalized logs shipped from another PrismCat instance", RequestBody: "IngestRequest", Response: "IngestResult"},
+	{Method: http.MethodPost, Path: "/api/replay", Summary: "Send a request to an upstream and return the response", RequestBody: "ReplayRequest", Response: "ReplayResponse"},
+	{
+		Method:  http.MethodGet,
+		Path:    "/api/models",
+		Summary: "Models offered by all upstreams (OpenAI list format with upstream attribution), cached for 5 minutes",
+		Params: []paramDoc{
+			{Name: "upstream", In: "query", Type: "string", Description: "Only these upstream name(s), comma-separated"},
+			{Name: "refresh", In: "query", Type: "boolean", Description: "Bypass the cache and query the upstreams again"},
+		},
+		Response: "ModelList",
+	},
+	{Method: http.MethodGet, Path: "/api/discovery/local", Summary: "Probe this machine for Ollama (port 11434) and LM Studio (port 1234) servers", Response: "LocalServers"},
+	{Method: http.MethodPost, Path: "/api/discovery/local", Summary: "Register discovered local servers not yet configured as upstreams and save the config", Response: "LocalServers"},
+	{Method: http.MethodGet, Path: "/api/openapi.json", Summary: "This OpenAPI document"},
+	{Method: http.MethodGet, Path: "/metrics", Summary: "Upstream connection metrics (Prometheus text format)", ResponseRaw: "text/plain"},
+}
+
+var apiSchemas = map[string]interface{}{
+	"Error": object(map[string]interface{}{
+		"error": prop("string"),
+	}),
+	"Status": object(map[string]interface{}{
+		"status": prop("string"),
+	}),
+	"Headers": map[string]interface{}{
+		"type":                 "object",
+		"additionalProperties": map[string]interface{}{"type": "array", "items": prop("string")},
+	},
+	"RequestLog": object(map[string]interface{}{
+		"id":                 prop("string"),
+		"created_at":         propFmt("string", "date-time"),
+		"upstream":           prop("string"),
+		"target_url":         prop("string"),
+		"method":             prop("string"),
+		"path":               prop("string"),
+		"query":              prop("string"),
+		"request_headers":    ref("Headers"),
+		"request_body":       prop("string"),
+		"request_body_ref":   prop("string"),
+		"request_body_size":  prop("integer"),
+		"status_code":        prop("integer"),
+		"response_headers":   ref("Headers"),
+		"response_body":      prop("string"),
+		"response_body_ref":  prop("string"),
+		"response_body_size": prop("integer"),
+		"streaming":          prop("boolean"),
+		"latency_ms":         prop("integer"),
+		"error":              prop("string"),
+		"truncated":          prop("boolean"),
+		"tag":                prop("string"),
+		"flags":              map[string]interface{}{"type": "array", "items": prop("string")},
+		"variant":            prop("string"),
+		"client_ip":          prop("string"),
+		"remote_addr":        prop("string"),
+		"user_agent":         prop("string"),
+		"source":             prop("string"),
+		"fingerprint":        prop("string"),
+		"duplicate_of":       prop("string"),
+		"duplicate_count":    prop("integer"),
+		"model":              prop("string"),
+		"input_tokens":       prop("integer"),
+		"output_tokens":      prop("integer"),
+		"total_tokens":       prop("integer"),
+		"properties":         map[string]interface{}{"type": "object", "additionalProperties": prop("string")},
+		"metadata":           ref("Metadata"),
+		"checks":             map[string]interface{}{"type": "object", "additionalProperties": prop("boolean")},
+		"stream_events":      prop("integer"),
+		"connection":         ref("ConnTimings"),
+		"event_timings": map[string]interface{}{"type": "array", "items": object(map[string]interface{}{
+			"offset": prop("integer"),
+			"ms":     prop("integer"),
+		})},
+	}),
+	"Debug": object(map[string]interface{}{
+		"dns_cache": object(map[string]interface{}{
+			"enabled":              prop("boolean"),
+			"cache_ttl_seconds":    prop("integer"),
+			"negative_ttl_seconds": prop("integer"),
+			"stats": object(map[string]interface{}{
+				"hits":          prop("integer"),
+				"negative_hits": prop("integer"),
+				"misses":        prop("integer"),
+				"errors":        prop("integer"),
+				"entries": map[string]interface{}{"type": "array", "items": object(map[string]interface{}{
+					"host":    prop("string"),
+					"addrs":   map[string]interface{}{"type": "array", "items": prop("string")},
+					"error":   prop("string"),
+					"expires": propFmt("string", "date-time"),
+				})},
+			}),
+		}),
+	}),
+	"ConnTimings": object(map[string]interface{}{
+		"reused":      prop("boolean"),
+		"dns_ms":      prop("number"),
+		"connect_ms":  prop("number"),
+		"tls_ms":      prop("number"),
+		"ttfb_ms":     prop("number"),
+		"write_ms":    prop("number"),
+		"wait_ms":     prop("number"),
+		"transfer_ms": prop("number"),
+	}),
+	"Metadata": map[string]interface{}{"type": "object", "additionalProperties": map[string]interface{}{"type": "string", "nullable": true}},
+	"LogList": object(map[string]interface{}{
+		"logs":   map[string]interface{}{"type": "array", "items": ref("RequestLog")},
+		"total":  prop("integer"),
+		"offset": prop("integer"),
+		"limit":  prop("integer"),
+	}),
+	"LogBundle": object(map[string]interface{}{
+		"format_version":   prop("integer"),
+		"prismcat_version": prop("string"),
+		"generated_at":     propFmt("string", "date-time"),
+		"redacted":         prop("boolean"),
+		"log":              ref("RequestLog"),
+		"stream": object(map[string]interface{}{
+			"events": prop("integer"),
+			"format": prop("string"),
+			"merged": prop("object"),
+		}),
+		"files": map[string]interface{}{"type": "array", "items": prop("string")},
+	}),
+	"LogEvents": object(map[string]interface{}{
+		"id":        prop("string"),
+		"streaming": prop("boolean"),
+		"truncated": prop("boolean"),
+		"events": map[string]interface{}{"type": "array", "items": object(map[string]interface{}{
+			"event":  prop("string"),
+			"id":     prop("string"),
+			"data":   prop("string"),
+			"offset": prop("integer"),
+			"json":   map[string]interface{}{},
+			"at_ms":  prop("integer"),
+		})},
+	}),
+	"LogOutput": object(map[string]interface{}{
+		"id":            prop("string"),
+		"format":        prop("string"),
+		"text":          prop("string"),
+		"reasoning":     prop("string"),
+		"finish_reason": prop("string"),
+		"truncated":     prop("boolean"),
+	}),
+	"LogDrift": object(map[string]interface{}{
+		"fingerprint": prop("string"),
+		"total":       prop("integer"),
+		"changes":     prop("integer"),
+		"entries": map[string]interface{}{"type": "array", "items": object(map[string]interface{}{
+			"id":                 prop("string"),
+			"created_at":         propFmt("string", "date-time"),
+			"status_code":        prop("integer"),
+			"latency_ms":         prop("integer"),
+			"model":              prop("string"),
+			"system_fingerprint": prop("string"),
+			"finish_reason":      prop("string"),
+			"response_hash":      prop("string"),
+			"variant":            prop("integer"),
+			"changes":            map[string]interface{}{"type": "array", "items": prop("string")},
+		})},
+		"variants": map[string]interface{}{"type": "array", "items": object(map[string]interface{}{
+			"response_hash": prop("string"),
+			"count":         prop("integer"),
+			"first_seen":    propFmt("string", "date-time"),
+			"last_seen":     propFmt("string", "date-time"),
+			"model":         prop("string"),
+			"status_code":   prop("integer"),
+			"output":        prop("string"),
+		})},
+	}),
+	"LogTools": object(map[string]interface{}{
+		"id":        prop("string"),
+		"declared":  map[string]interface{}{"type": "array", "items": prop("string")},
+		"truncated": prop("boolean"),
+		"calls": map[string]interface{}{"type": "array", "items": object(map[string]interface{}{
+			"id":         prop("string"),
+			"name":       prop("string"),
+			"source":     prop("string"),
+			"arguments":  map[string]interface{}{},
+			"result":     map[string]interface{}{},
+			"has_result": prop("boolean"),
+		})},
+	}),
+	"PurgeRequest": object(map[string]interface{}{
+		"contains": prop("string"),
+		"pattern":  prop("string"),
+		"dry_run":  prop("boolean"),
+	}),
+	"PurgeResult": object(map[string]interface{}{
+		"ids":           map[string]interface{}{"type": "array", "items": prop("string")},
+		"matched":       prop("integer"),
+		"deleted":       prop("integer"),
+		"blobs_deleted": prop("integer"),
+		"dry_run":       prop("boolean"),
+	}),
+	"BodyPurgeRequest": object(map[string]interface{}{
+		"older_than_days": prop("integer"),
+		"dry_run":         prop("boolean"),
+	}),
+	"BodyPurgeResult": object(map[string]interface{}{
+		"before":        propFmt("string", "date-time"),
+		"logs":          prop("integer"),
+		"blobs":         prop("integer"),
+		"blobs_deleted": prop("integer"),
+		"dry_run":       prop("boolean"),
+	}),
+	"ArchiveRequest": object(map[string]interface{}{
+		"older_than_days": prop("integer"),
+	}),
+	"ArchiveResult": object(map[string]interface{}{
+		"before": propFmt("string", "date-time"),
+		"logs":   prop("integer"),
+		"blobs":  prop("integer"),
+		"bytes":  prop("integer"),
+	}),
+	"IngestRequest": object(map[string]interface{}{
+		"source": prop("string"),
+		"logs":   map[string]interface{}{"type": "array", "items": ref("RequestLog")},
+	}),
+	"IngestResult": object(map[string]interface{}{
+		"accepted":      prop("integer"),
+		"missing_blobs": map[string]interface{}{"type": "array", "items": prop("string")},
+	}),
+	"LogStats": object(map[string]interface{}{
+		"total_requests":       prop("integer"),
+		"success_count":        prop("integer"),
+		"error_count":          prop("integer"),
+		"streaming_count":      prop("integer"),
+		"avg_latency_ms":       prop("number"),
+		"schema_invalid_count": prop("integer"),
+		"check_failed_count":   prop("integer"),
+		"input_tokens":         prop("integer"),
+		"output_tokens":        prop("integer"),
+		"total_tokens":         prop("integer"),
+		"by_upstream":          map[string]interface{}{"type": "object", "additionalProperties": prop("integer")},
+		"by_status_code":       map[string]interface{}{"type": "object", "additionalProperties": prop("integer")},
+	}),
+	"PropertyStats": object(map[string]interface{}{
+		"key": prop("string"),
+		"values": map[string]interface{}{"type": "array", "items": object(map[string]interface{}{
+			"value":          prop("string"),
+			"count":          prop("integer"),
+			"error_count":    prop("integer"),
+			"avg_latency_ms": prop("number"),
+		})},
+	}),
+	"CheckStats": object(map[string]interface{}{
+		"checks": map[string]interface{}{"type": "array", "items": object(map[string]interface{}{
+			"upstream": prop("string"),
+			"name":     prop("string"),
+			"passed":   prop("integer"),
+			"failed":   prop("integer"),
+		})},
+	}),
+	"TrafficStat": object(map[string]interface{}{
+		"day":       propFmt("string", "date"),
+		"upstream":  prop("string"),
+		"requests":  prop("integer"),
+		"bytes_in":  prop("integer"),
+		"bytes_out": prop("integer"),
+	}),
+	"TrafficStats": object(map[string]interface{}{
+		"from":   propFmt("string", "date"),
+		"to":     propFmt("string", "date"),
+		"days":   map[string]interface{}{"type": "array", "items": ref("TrafficStat")},
+		"totals": map[string]interface{}{"type": "object", "additionalProperties": ref("TrafficStat")},
+	}),
+	"HourlyRollup": object(map[string]interface{}{
+		"hour":           prop("string"),
+		"upstream":       prop("string"),
+		"model":          prop("string"),
+		"status_class":   prop("string"),
+		"requests":       prop("integer"),
+		"input_tokens":   prop("integer"),
+		"output_tokens":  prop("integer"),
+		"latency_ms_sum": prop("integer"),
+	}),
+	"HourlyRollups": object(map[string]interface{}{
+		"from":  prop("string"),
+		"to":    prop("string"),
+		"hours": map[string]interface{}{"type": "array", "items": ref("HourlyRollup")},
+	}),
+	"StorageStats": object(map[string]interface{}{
+		"db_bytes":         prop("integer"),
+		"wal_bytes":        prop("integer"),
+		"free_bytes":       prop("integer"),
+		"rows":             prop("integer"),
+		"rows_by_upstream": map[string]interface{}{"type": "object", "additionalProperties": prop("integer")},
+		"oldest_at":        propFmt("string", "date-time"),
+		"schema_version":   prop("integer"),
+		"blobs": object(map[string]interface{}{
+			"count": prop("integer"),
+			"bytes": prop("integer"),
+		}),
+		"archive": object(map[string]interface{}{
+			"count": prop("integer"),
+			"bytes": prop("integer"),
+		}),
+	}),
+	"UpstreamFootprints": object(map[string]interface{}{
+		"total_bytes": prop("integer"),
+		"upstreams": map[string]interface{}{"type": "array", "items": object(map[string]interface{}{
+			"upstream":     prop("string"),
+			"rows":         prop("integer"),
+			"inline_bytes": prop("integer"),
+			"blob_bytes":   prop("integer"),
+			"blob_count":   prop("integer"),
+			"total_bytes":  prop("integer"),
+		})},
+	}),
+	"BlobGCReport": object(map[string]interface{}{
+		"scanned":         prop("integer"),
+		"referenced":      prop("integer"),
+		"deleted":         prop("integer"),
+		"reclaimed_bytes": prop("integer"),
+	}),
+	"ModelList": object(map[string]interface{}{
+		"object": prop("string"),
+		"data": map[string]interface{}{"type": "array", "items": object(map[string]interface{}{
+			"id":           prop("string"),
+			"object":       prop("string"),
+			"owned_by":     prop("string"),
+			"display_name": prop("string"),
+			"upstream":     prop("string"),
+		})},
+		"upstreams": map[string]interface{}{"type": "array", "items": object(map[string]interface{}{
+			"name":       prop("string"),
+			"models":     prop("integer"),
+			"fetched_at": propFmt("string", "date-time"),
+			"cached":     prop("boolean"),
+			"error":      prop("string"),
+		})},
+	}),
+	"LocalServers": object(map[string]interface{}{
+		"servers": map[string]interface{}{"type": "array", "items": object(map[string]interface{}{
+			"type":     prop("string"),
+			"target":   prop("string"),
+			"models":   map[string]interface{}{"type": "array", "items": prop("string")},
+			"upstream": prop("string"),
+		})},
+	}),
+	"Upstream": object(map[string]interface{}{
+		"name":        prop("string"),
+		"type":        prop("string"),
+		"target":      prop("string"),
+		"timeout":     prop("integer"),
+		"maintenance": ref("Maintenance"),
+		"disabled":    prop("boolean"),
+		"deleted_at":  map[string]interface{}{"type": "string", "format": "date-time"},
+	}),
+	"Maintenance": object(map[string]interface{}{
+		"enabled":     prop("boolean"),
+		"status":      prop("integer"),
+		"message":     prop("string"),
+		"retry_after": prop("integer"),
+	}),
+	"MaintenanceRequest": object(map[string]interface{}{
+		"name":        prop("string"),
+		"enabled":     prop("boolean"),
+		"status":      prop("integer"),
+		"message":     prop("string"),
+		"retry_after": prop("integer"),
+	}),
+	"UpstreamList": map[string]interface{}{
+		"type":  "array",
+		"items": ref("Upstream"),
+	},
+	"UpstreamStats": object(map[string]interface{}{
+		"upstream":        prop("string"),
+		"configured":      prop("boolean"),
+		"target":          prop("string"),
+		"total_requests":  prop("integer"),
+		"success_count":   prop("integer"),
+		"error_count":     prop("integer"),
+		"streaming_count": prop("integer"),
+		"client_errors":   prop("integer"),
+		"server_errors":   prop("integer"),
+		"network_errors":  prop("integer"),
+		"by_status_code":  map[string]interface{}{"type": "object", "additionalProperties": prop("integer")},
+		"top_errors": map[string]interface{}{"type": "array", "items": object(map[string]interface{}{
+			"message": prop("string"),
+			"count":   prop("integer"),
+		})},
+		"latency_ms": object(map[string]interface{}{
+			"avg": prop("number"),
+			"p50": prop("integer"),
+			"p90": prop("integer"),
+			"p95": prop("integer"),
+			"p99": prop("integer"),
+			"max": prop("integer"),
+		}),
+		"input_tokens":  prop("integer"),
+		"output_tokens": prop("integer"),
+		"total_tokens":  prop("integer"),
+		"last_seen_at":  map[string]interface{}{"type": "string", "format": "date-time"},
+		"last_error_at": map[string]interface{}{"type": "string", "format": "date-time"},
+	}),
+	"UpstreamImport": object(map[string]interface{}{
+		"upstreams": map[string]interface{}{"type": "object", "additionalProperties": map[string]interface{}{"type": "object"}},
+	}),
+	"UpstreamImportResult": object(map[string]interface{}{
+		"status":   prop("string"),
+		"imported": map[string]interface{}{"type": "array", "items": prop("string")},
+	}),
+	"UpstreamPresets": object(map[string]interface{}{
+		"presets": map[string]interface{}{"type": "array", "items": object(map[string]interface{}{
+			"name":         prop("string"),
+			"display_name": prop("string"),
+			"target":       prop("string"),
+			"auth_header":  prop("string"),
+			"configured":   prop("boolean"),
+		})},
+	}),
+	"UpstreamPresetRequest": object(map[string]interface{}{
+		"presets":   map[string]interface{}{"type": "array", "items": prop("string")},
+		"api_keys":  map[string]interface{}{"type": "object", "additionalProperties": prop("string")},
+		"overwrite": prop("boolean"),
+	}),
+	"UpstreamPresetResult": object(map[string]interface{}{
+		"status":  prop("string"),
+		"added":   map[string]interface{}{"type": "array", "items": prop("string")},
+		"skipped": map[string]interface{}{"type": "array", "items": prop("string")},
+	}),
+	"Routes": object(map[string]interface{}{
+		"routes": map[string]interface{}{"type": "array", "items": ref("Route")},
+	}),
+	"Route": object(map[string]interface{}{
+		"name": prop("string"),
+		"match": object(map[string]interface{}{
+			"host":    prop("string"),
+			"path":    prop("string"),
+			"headers": map[string]interface{}{"type": "object", "additionalProperties": prop("string")},
+			"model":   prop("string"),
+		}),
+		"upstream":     prop("string"),
+		"target":       prop("string"),
+		"destinations": map[string]interface{}{"type": "array", "items": ref("RouteDestination")},
+	}),
+	"RouteDestination": object(map[string]interface{}{
+		"upstream": prop("string"),
+		"target":   prop("string"),
+		"weight":   prop("integer"),
+		"variant":  prop("string"),
+	}),
+	"ConfigView": object(map[string]interface{}{
+		"version": prop("string"),
+		"server":  map[string]interface{}{"type": "object"},
+		"logging": map[string]interface{}{"type": "object"},
+		"storage": map[string]interface{}{"type": "object"},
+	}),
+	"ConfigUpdate": object(map[string]interface{}{
+		"logging": object(map[string]interface{}{
+			"max_request_body":       prop("integer"),
+			"max_response_body":      prop("integer"),
+			"sensitive_headers":      map[string]interface{}{"type": "array", "items": prop("string")},
+			"detach_body_over_bytes": prop("integer"),
+			"body_preview_bytes":     prop("integer"),
+			"store_base64":           prop("boolean"),
+			"summarize_embeddings":   prop("boolean"),
+			"allow_no_log_header":    prop("boolean"),
+		}),
+		"storage": object(map[string]interface{}{
+			"retention_days": prop("integer"),
+		}),
+	}),
+	"Health": object(map[string]interface{}{
+		"status":  prop("string"),
+		"version": prop("string"),
+		"time":    propFmt("string", "date-time"),
+		"disk": object(map[string]interface{}{
+			"free_bytes":     prop("integer"),
+			"min_free_bytes": prop("integer"),
+			"low":            prop("boolean"),
+			"checked_at":     propFmt("string", "date-time"),
+			"error":          prop("string"),
+		}),
+		"recovery": object(map[string]interface{}{
+			"interrupted": prop("integer"),
+			"integrity":   prop("string"),
+			"problems":    map[string]interface{}{"type": "array", "items": prop("string")},
+			"error":       prop("string"),
+			"checked_at":  propFmt("string", "date-time"),
+		}),
+		"cluster": object(map[string]interface{}{
+			"instance_id": prop("string"),
+			"leader":      prop("boolean"),
+		}),
+		"log_queue": object(map[string]interface{}{
+			"length":   prop("integer"),
+			"capacity": prop("integer"),
+			"dropped": object(map[string]interface{}{
+				"success":  prop("integer"),
+				"error":    prop("integer"),
+				"snapshot": prop("integer"),
+			}),
+		}),
+		"sinks": map[string]interface{}{"type": "array", "items": object(map[string]interface{}{
+			"name":    prop("string"),
+			"sent":    prop("integer"),
+			"failed":  prop("integer"),
+			"dropped": prop("integer"),
+		})},
+	}),
+	"ReplayRequest": object(map[string]interface{}{
+		"upstream": prop("string"),
+		"method":   prop("string"),
+		"path":     prop("string"),
+		"headers":  map[string]interface{}{"type": "object", "additionalProperties": prop("string")},
+		"body":     prop("string"),
+		"log_id":   prop("string"),
+	}),
+	"ReplayResponse": object(map[string]interface{}{
+		"status_code": prop("integer"),
+		"headers":     ref("Headers"),
+		"body":        prop("string"),
+		"truncated":   prop("boolean"),
+		"replay_id":   prop("string"),
+	}),
+	"Replays": object(map[string]interface{}{
+		"replays": map[string]interface{}{"type": "array", "items": ref("Replay")},
+	}),
+	"Replay": object(map[string]interface{}{
+		"id":            prop("string"),
+		"log_id":        prop("string"),
+		"created_at":    propFmt("string", "date-time"),
+		"actor":         prop("string"),
+		"upstream":      prop("string"),
+		"method":        prop("string"),
+		"path":          prop("string"),
+		"status_code":   prop("integer"),
+		"latency_ms":    prop("integer"),
+		"response_size": prop("integer"),
+		"error":         prop("string"),
+	}),
+}
+
+func prop(typ string) map[string]interface{} {
+	return map[string]interface{}{"type": typ}
+}
+
+func propFmt(typ, format string) map[string]interface{} {
+	return map[string]interface{}{"type": typ, "format": format}
+}
+
+func ref(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+func object(props map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"type": "object", "properties": props}
+}
+
+// openAPISpec builds the OpenAPI 3.0 document from apiRoutes and apiSchemas.
+func openAPISpec() map[string]interface{} {
+	paths := make(map[string]interface{})
+	for _, rt := range apiRoutes {
+		item, _ := paths[rt.Path].(map[string]interface{})
+		if item == nil {
+			item = make(map[string]interface{})
+			paths[rt.Path] = item
+		}
+		item[strings.ToLower(rt.Method)] = rt.operation()
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "PrismCat Management API",
+			"version":     config.Version,
+			"description": "Management API served on the UI hosts. Protected by HTTP Basic auth when server.ui_password is set.",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": apiSchemas,
+			"securitySchemes": map[string]interface{}{
+				"basicAuth": map[string]interface{}{"type": "http", "scheme": "basic"},
+			},
+		},
+		"security": []interface{}{map[string]interface{}{"basicAuth": []string{}}},
+	}
+}
+
+func (rt routeDoc) operation() map[string]interface{} {
+	op := map[string]interface{}{
+		"summary":     rt.Summary,
+		"operationId": operationID(rt.Method, rt.Path),
+	}
+
+	if len(rt.Params) > 0 {
+		params := make([]interface{}, 0, len(rt.Params))
+		for _, p := range rt.Params {
+			schema := prop(p.Type)
+			if p.Format != "" {
+				schema["format"] = p.Format
+			}
+			param := map[string]interface{}{
+				"name":     p.Name,
+				"in":       p.In,
+				"required": p.Required || p.In == "path",
+				"schema":   schema,
+			}
+			if p.Description != "" {
+				param["description"] = p.Description
+			}
+			params = append(params, param)
+		}
+		op["parameters"] = params
+	}
+
+	if rt.RequestBody != "" {
+		op["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": ref(rt.RequestBody)},
+			},
+		}
+	}
+
+	// Response and ResponseRaw may both be set when a query parameter selects the format.
+	okContent := map[string]interface{}{}
+	if rt.Response != "" {
+		okContent["application/json"] = map[string]interface{}{"schema": ref(rt.Response)}
+	}
+	if rt.ResponseRaw != "" {
+		okContent[rt.ResponseRaw] = map[string]interface{}{"schema": prop("string")}
+	}
+	if len(okContent) == 0 {
+		okContent["application/json"] = map[string]interface{}{"schema": prop("object")}
+	}
+	op["responses"] = map[string]interface{}{
+		"200": map[string]interface{}{"description": "OK", "content": okContent},
+		"default": map[string]interface{}{
+			"description": "Error",
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": ref("Error")},
+			},
+		},
+	}
+	return op
+}
+
+// operationID derives a stable camelCase id, e.g. GET /api/logs/{id} -> getLogsId.
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, seg := range strings.Split(strings.TrimPrefix(path, "/api/"), "/") {
+		seg = strings.Trim(seg, "{}")
+		for _, part := range strings.FieldsFunc(seg, func(r rune) bool { return r == '-' || r == '_' || r == '.' }) {
+			if part == "" {
+				continue
+			}
+			b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	return b.String()
+}
+
+// handleOpenAPI 返回管理 API 的 OpenAPI 描述
+func (h *Handler) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.jsonError(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+	h.jsonResponse(w, openAPISpec())
+}
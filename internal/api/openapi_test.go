@@ -0,0 +1,39 @@
+package api
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPISpecRefsResolve(t *testing.T) {
+	data, err := json.Marshal(openAPISpec())
+	if err != nil {
+		t.Fatalf("marshal spec: %v", err)
+	}
+
+	const prefix = `"#/components/schemas/`
+	s := string(data)
+	for {
+		i := strings.Index(s, prefix)
+		if i < 0 {
+			break
+		}
+		s = s[i+len(prefix):]
+		name := s[:strings.IndexByte(s, '"')]
+		if _, ok := apiSchemas[name]; !ok {
+			t.Fatalf("dangling schema ref %q", name)
+		}
+	}
+}
+
+func TestOpenAPIOperationIDsUnique(t *testing.T) {
+	seen := make(map[string]string)
+	for _, rt := range apiRoutes {
+		id := operationID(rt.Method, rt.Path)
+		if prev, ok := seen[id]; ok {
+			t.Fatalf("duplicate operationId %q for %s %s and %s", id, rt.Method, rt.Path, prev)
+		}
+		seen[id] = rt.Method + " " + rt.Path
+	}
+}
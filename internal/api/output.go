@@ -0,0 +1,60 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/prismcat/prismcat/internal/llm"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+// handleLogOutput 提取助手的文本回复 (?format=text 直接返回纯文本)
+func (h *Handler) handleLogOutput(w http.ResponseWriter, r *http.Request, id string) {
+	log, err := h.repo.GetLog(r.Context(), id)
+	if err != nil {
+		h.jsonError(w, "日志不存在", http.StatusNotFound)
+		return
+	}
+	body, err := h.responseJSON(r.Context(), log)
+	if err != nil {
+		h.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	out, ok := llm.ExtractOutput(body)
+	if !ok {
+		h.jsonError(w, "无法识别的响应格式", http.StatusUnprocessableEntity)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		_, _ = w.Write([]byte(out.Text))
+		return
+	}
+	h.jsonResponse(w, map[string]interface{}{
+		"id":            log.ID,
+		"format":        out.Format,
+		"text":          out.Text,
+		"reasoning":     out.Reasoning,
+		"finish_reason": out.FinishReason,
+		"truncated":     log.Truncated,
+	})
+}
+
+// responseJSON returns the full response body decoded as a JSON object, using
+// the reassembled form for streaming logs. A nil map means the body is not JSON.
+func (h *Handler) responseJSON(ctx context.Context, log *storage.RequestLog) (map[string]interface{}, error) {
+	if log.Streaming {
+		if err := h.applyLogView(ctx, log, "merged"); err != nil {
+			return nil, err
+		}
+	} else if err := h.resolveBodies(ctx, log); err != nil {
+		return nil, err
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal([]byte(log.ResponseBody), &body); err != nil {
+		return nil, nil
+	}
+	return body, nil
+}
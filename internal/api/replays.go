@@ -0,0 +1,50 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+// replayActor 返回执行重放的人：控制台 Basic 认证的用户名，否则为客户端地址
+func replayActor(r *http.Request) string {
+	if user, _, ok := r.BasicAuth(); ok && user != "" {
+		return user
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// recordReplay 记录从日志发起的重放（耗时算到此刻）；未关联日志的重放不记录。
+// 重放已经发出，客户端断开也照样记录
+func (h *Handler) recordReplay(rp *storage.Replay) {
+	if rp.LogID == "" {
+		return
+	}
+	rp.LatencyMs = time.Since(rp.CreatedAt).Milliseconds()
+	if err := h.repo.SaveReplay(context.Background(), rp); err != nil {
+		rp.ID = ""
+		log.Printf("record replay of %s failed: %v", rp.LogID, err)
+	}
+}
+
+// handleLogReplays 获取日志的重放历史（最新的在前）
+func (h *Handler) handleLogReplays(w http.ResponseWriter, r *http.Request, id string) {
+	replays, err := h.repo.ListReplays(r.Context(), id)
+	if errors.Is(err, storage.ErrLogNotFound) {
+		h.jsonError(w, "日志不存在", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.jsonResponse(w, map[string]interface{}{"replays": replays})
+}
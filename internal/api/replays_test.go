@@ -0,0 +1,87 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+func TestReplayHistory(t *testing.T) {
+	status := http.StatusOK
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer upstream.Close()
+
+	repo, err := storage.NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	if err := repo.SaveLog(context.Background(), &storage.RequestLog{ID: "orig", Upstream: "up", Method: "POST", Path: "/v1/chat/completions", StatusCode: 200}); err != nil {
+		t.Fatal(err)
+	}
+	h := New(&config.Config{Upstreams: map[string]config.UpstreamConfig{"up": {Target: upstream.URL}}}, repo, nil)
+
+	replay := func(body, user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/replay", strings.NewReader(body))
+		if user != "" {
+			req.SetBasicAuth(user, "secret")
+		}
+		rec := httptest.NewRecorder()
+		h.handleReplay(rec, req)
+		return rec
+	}
+
+	if rec := replay(`{"upstream":"up","method":"POST","path":"/v1/chat/completions","log_id":"orig"}`, "alice"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"replay_id"`) {
+		t.Fatalf("replay: %d %s", rec.Code, rec.Body)
+	}
+	status = http.StatusTooManyRequests
+	if rec := replay(`{"upstream":"up","method":"POST","path":"/v1/chat/completions","log_id":"orig"}`, ""); rec.Code != http.StatusOK {
+		t.Fatalf("replay: %d %s", rec.Code, rec.Body)
+	}
+	// Ad-hoc replays aren't recorded; replays of unknown logs aren't sent.
+	if rec := replay(`{"upstream":"up","method":"GET","path":"/v1/models"}`, ""); strings.Contains(rec.Body.String(), `"replay_id"`) {
+		t.Fatalf("ad-hoc replay recorded: %s", rec.Body)
+	}
+	if rec := replay(`{"upstream":"up","method":"GET","log_id":"missing"}`, ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown log: %d", rec.Code)
+	}
+
+	rec := httptest.NewRecorder()
+	h.handleLogDetail(rec, httptest.NewRequest(http.MethodGet, "/api/logs/orig/replays", nil))
+	var got struct {
+		Replays []storage.Replay `json:"replays"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("list: %d %s (%v)", rec.Code, rec.Body, err)
+	}
+	if len(got.Replays) != 2 {
+		t.Fatalf("replays = %+v", got.Replays)
+	}
+	newest, oldest := got.Replays[0], got.Replays[1]
+	if newest.StatusCode != http.StatusTooManyRequests || newest.Actor != "192.0.2.1" {
+		t.Errorf("newest = %+v", newest)
+	}
+	if oldest.StatusCode != http.StatusOK || oldest.Actor != "alice" || oldest.Upstream != "up" || oldest.ResponseSize != int64(len(`{"ok":true}`)) || oldest.CreatedAt.IsZero() {
+		t.Errorf("oldest = %+v", oldest)
+	}
+
+	// Replays go with their log.
+	if _, err := repo.DeleteLogs(context.Background(), []string{"orig"}); err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	h.handleLogDetail(rec, httptest.NewRequest(http.MethodGet, "/api/logs/orig/replays", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("deleted log: %d", rec.Code)
+	}
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/prismcat/prismcat/internal/config"
+)
+
+// handleRoutes 查看（GET）或整体替换（PUT）路由表，按顺序匹配
+func (h *Handler) handleRoutes(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		routes := h.cfg.RoutesSnapshot()
+		if routes == nil {
+			routes = []config.RouteConfig{}
+		}
+		h.jsonResponse(w, map[string]interface{}{"routes": routes})
+	case http.MethodPut:
+		var req struct {
+			Routes []config.RouteConfig `json:"routes"`
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, 1<<20) // 1MB
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			h.jsonError(w, "无效的请求体", http.StatusBadRequest)
+			return
+		}
+		if err := h.cfg.SetRoutes(req.Routes); err != nil {
+			h.jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := h.cfg.Save(); err != nil {
+			h.jsonError(w, "保存配置失败: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		h.jsonResponse(w, map[string]string{"status": "ok"})
+	default:
+		h.jsonError(w, "方法不允许", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/prismcat/prismcat/internal/llm"
+)
+
+// handleLogTools 提取请求与响应中的工具调用 (名称、参数、结果)
+func (h *Handler) handleLogTools(w http.ResponseWriter, r *http.Request, id string) {
+	log, err := h.repo.GetLog(r.Context(), id)
+	if err != nil {
+		h.jsonError(w, "日志不存在", http.StatusNotFound)
+		return
+	}
+	// Load the full request body first; a merged stream is reassembled again
+	// from the raw blob by responseJSON.
+	if err := h.resolveBodies(r.Context(), log); err != nil {
+		h.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp, err := h.responseJSON(r.Context(), log)
+	if err != nil {
+		h.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var req map[string]interface{}
+	_ = json.Unmarshal([]byte(log.RequestBody), &req)
+
+	report := llm.ExtractTools(req, resp)
+	h.jsonResponse(w, map[string]interface{}{
+		"id":        log.ID,
+		"declared":  report.Declared,
+		"calls":     report.Calls,
+		"truncated": log.Truncated,
+	})
+}
@@ -0,0 +1,273 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+// handleUpstreamExport 导出上游配置（YAML / JSON），默认不含密钥
+func (h *Handler) handleUpstreamExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.jsonError(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	format := strings.ToLower(query.Get("format"))
+	switch format {
+	case "", "yml":
+		format = config.FormatYAML
+	case config.FormatYAML, config.FormatJSON:
+	default:
+		h.jsonError(w, "format 仅支持 yaml 或 json", http.StatusBadRequest)
+		return
+	}
+	includeSecrets, _ := strconv.ParseBool(query.Get("include_secrets"))
+
+	upstreams, err := h.cfg.ExportUpstreams()
+	if err != nil {
+		h.jsonError(w, "导出上游失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !includeSecrets {
+		sensitive := append(append([]string{}, bundleSecretHeaders...), h.cfg.LoggingSnapshot().SensitiveHeaders...)
+		for name, up := range upstreams {
+			upstreams[name] = withoutSecrets(up, sensitive)
+		}
+	}
+	data, err := config.MarshalUpstreams(format, upstreams)
+	if err != nil {
+		h.jsonError(w, "导出上游失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if format == config.FormatJSON {
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		w.Header().Set("Content-Type", "application/yaml")
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="upstreams.`+format+`"`)
+	w.Write(data)
+}
+
+// withoutSecrets drops the credentials of up: API keys, AWS keys and
+// credential headers. Secret references are kept, since they name where the
+// secret lives rather than the secret itself.
+func withoutSecrets(up config.UpstreamConfig, sensitiveHeaders []string) config.UpstreamConfig {
+	secret := func(s string) bool { return s != "" && !config.HasSecretRef(s) }
+	if len(up.DefaultHeaders) > 0 {
+		headers := make(map[string]string, len(up.DefaultHeaders))
+		for k, v := range up.DefaultHeaders {
+			if secret(v) && containsFold(sensitiveHeaders, k) {
+				continue
+			}
+			headers[k] = v
+		}
+		up.DefaultHeaders = headers
+	}
+	if secret(up.Azure.APIKey) {
+		up.Azure.APIKey = ""
+	}
+	if secret(up.Bedrock.AccessKeyID) || secret(up.Bedrock.SecretAccessKey) || secret(up.Bedrock.SessionToken) {
+		up.Bedrock.AccessKeyID, up.Bedrock.SecretAccessKey, up.Bedrock.SessionToken = "", "", ""
+	}
+	return up
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleUpstreamImport 导入上游配置（YAML / JSON）并保存；replace=true 时删除未导入的上游
+func (h *Handler) handleUpstreamImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.jsonError(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+	replace, _ := strconv.ParseBool(r.URL.Query().Get("replace"))
+
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20) // 1MB
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.jsonError(w, "无效的请求体", http.StatusBadRequest)
+		return
+	}
+	upstreams, err := config.ParseUpstreams(data)
+	if err != nil {
+		h.jsonError(w, "解析上游配置失败: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.cfg.ImportUpstreams(upstreams, replace); err != nil {
+		h.jsonError(w, "导入上游失败: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.cfg.Save(); err != nil {
+		h.jsonError(w, "保存配置失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	names := make([]string, 0, len(upstreams))
+	for name := range upstreams {
+		names = append(names, strings.ToLower(strings.TrimSpace(name)))
+	}
+	sort.Strings(names)
+	h.jsonResponse(w, map[string]interface{}{"status": "ok", "imported": names})
+}
+
+// handleUpstreamPresets 列出内置的常用服务商预设；POST 按预设添加上游并保存配置
+func (h *Handler) handleUpstreamPresets(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		existing := h.cfg.ListUpstreams()
+		presets := make([]map[string]interface{}, 0)
+		for _, p := range config.UpstreamPresets() {
+			_, configured := existing[p.Name]
+			presets = append(presets, map[string]interface{}{
+				"name":         p.Name,
+				"display_name": p.DisplayName,
+				"target":       p.Target,
+				"auth_header":  p.AuthHeader,
+				"configured":   configured,
+			})
+		}
+		h.jsonResponse(w, map[string]interface{}{"presets": presets})
+		return
+	case http.MethodPost:
+	default:
+		h.jsonError(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Presets   []string          `json:"presets"`
+		APIKeys   map[string]string `json:"api_keys"`
+		Overwrite bool              `json:"overwrite"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20) // 1MB
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.jsonError(w, "无效的请求体", http.StatusBadRequest)
+		return
+	}
+	if len(req.Presets) == 0 {
+		h.jsonError(w, "presets 必填", http.StatusBadRequest)
+		return
+	}
+	presets := make([]config.UpstreamPreset, 0, len(req.Presets))
+	for _, name := range req.Presets {
+		p, ok := config.LookupUpstreamPreset(name)
+		if !ok {
+			h.jsonError(w, "未知的预设: "+name, http.StatusBadRequest)
+			return
+		}
+		presets = append(presets, p)
+	}
+	apiKeys := make(map[string]string, len(req.APIKeys))
+	for name, key := range req.APIKeys {
+		apiKeys[strings.ToLower(strings.TrimSpace(name))] = key
+	}
+
+	existing := h.cfg.ListUpstreams()
+	added, skipped := make([]string, 0), make([]string, 0)
+	for _, p := range presets {
+		if _, ok := existing[p.Name]; ok && !req.Overwrite {
+			skipped = append(skipped, p.Name)
+			continue
+		}
+		if err := h.cfg.AddUpstream(p.Name, p.Upstream(apiKeys[p.Name])); err != nil {
+			h.jsonError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		existing[p.Name] = config.UpstreamConfig{}
+		added = append(added, p.Name)
+	}
+	if len(added) > 0 {
+		if err := h.cfg.Save(); err != nil {
+			h.jsonError(w, "保存配置失败: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	h.jsonResponse(w, map[string]interface{}{"status": "ok", "added": added, "skipped": skipped})
+}
+
+// handleUpstreamDetail 单个上游的子资源: /api/upstreams/{name}/{sub}
+func (h *Handler) handleUpstreamDetail(w http.ResponseWriter, r *http.Request) {
+	name, sub, _ := strings.Cut(r.URL.Path[len("/api/upstreams/"):], "/")
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		h.jsonError(w, "缺少上游名称", http.StatusBadRequest)
+		return
+	}
+
+	if sub == "stats" {
+		if r.Method != http.MethodGet {
+			h.jsonError(w, "方法不允许", http.StatusMethodNotAllowed)
+			return
+		}
+		h.handleUpstreamStats(w, r, name)
+		return
+	}
+
+	var err error
+	switch sub {
+	case "enable", "disable", "restore":
+		if r.Method != http.MethodPost {
+			h.jsonError(w, "方法不允许", http.StatusMethodNotAllowed)
+			return
+		}
+		if sub == "restore" {
+			err = h.cfg.RestoreUpstream(name)
+		} else {
+			err = h.cfg.SetUpstreamDisabled(name, sub == "disable")
+		}
+	default:
+		h.jsonError(w, "未知的上游子资源: "+sub, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.jsonError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err := h.cfg.Save(); err != nil {
+		h.jsonError(w, "保存配置失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.jsonResponse(w, map[string]string{"status": "ok"})
+}
+
+// handleUpstreamStats 单个上游的请求量、错误分布、延迟分位数与 token 汇总
+func (h *Handler) handleUpstreamStats(w http.ResponseWriter, r *http.Request, name string) {
+	var since *time.Time
+	if sinceStr := r.URL.Query().Get("since"); sinceStr != "" {
+		if t, err := time.Parse(time.RFC3339, sinceStr); err == nil {
+			since = &t
+		}
+	}
+
+	stats, err := h.repo.GetUpstreamStats(r.Context(), name, since)
+	if err != nil {
+		h.jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_, configured := h.cfg.GetUpstream(name)
+	var target string
+	if up, ok := h.cfg.LookupUpstream(name); ok {
+		target = up.Target
+	}
+	h.jsonResponse(w, struct {
+		*storage.UpstreamStats
+		Configured bool   `json:"configured"`
+		Target     string `json:"target,omitempty"` // also for deleted upstreams
+	}{stats, configured, target})
+}
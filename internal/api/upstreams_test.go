@@ -0,0 +1,123 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prismcat/prismcat/internal/config"
+)
+
+func newTestUpstreamMux(t *testing.T, yamlData string) (*http.ServeMux, *config.Config) {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	yamlData = "storage:\n  database: " + filepath.Join(dir, "prismcat.db") + "\n" + yamlData
+	if err := os.WriteFile(path, []byte(yamlData), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	New(cfg, nil, nil).RegisterRoutes(mux)
+	return mux, cfg
+}
+
+func TestUpstreamExportImport(t *testing.T) {
+	t.Setenv("TEST_ANTHROPIC_KEY", "sk-ant-secret")
+	src, _ := newTestUpstreamMux(t, `
+upstreams:
+  openai:
+    target: https://api.openai.com
+    timeout: 60
+    default_headers:
+      Authorization: Bearer sk-plain-secret
+      OpenAI-Organization: org-1
+  anthropic:
+    target: https://api.anthropic.com
+    default_headers:
+      x-api-key: ${env:TEST_ANTHROPIC_KEY}
+`)
+
+	export := func(query string) string {
+		rec := httptest.NewRecorder()
+		src.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/upstreams/export"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("export%s: status %d: %s", query, rec.Code, rec.Body)
+		}
+		return rec.Body.String()
+	}
+	body := export("")
+	if strings.Contains(body, "sk-plain-secret") || strings.Contains(body, "sk-ant-secret") {
+		t.Fatalf("export leaks a secret:\n%s", body)
+	}
+	if !strings.Contains(body, "${env:TEST_ANTHROPIC_KEY}") || !strings.Contains(body, "org-1") {
+		t.Fatalf("export lacks the reference or a plain header:\n%s", body)
+	}
+	if body := export("?format=json&include_secrets=true"); !strings.Contains(body, "sk-plain-secret") {
+		t.Fatalf("include_secrets export lacks the key:\n%s", body)
+	}
+
+	dst, cfg := newTestUpstreamMux(t, "upstreams:\n  local:\n    type: ollama\n")
+	rec := httptest.NewRecorder()
+	dst.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/upstreams/import?replace=true", strings.NewReader(export("?format=json&include_secrets=true"))))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("import: status %d: %s", rec.Code, rec.Body)
+	}
+	ups := cfg.ListUpstreams()
+	if _, ok := ups["local"]; ok || len(ups) != 2 {
+		t.Fatalf("upstreams after replace = %+v", ups)
+	}
+	if ups["openai"].Timeout != 60 || ups["anthropic"].DefaultHeaders["x-api-key"] != "sk-ant-secret" {
+		t.Fatalf("imported = %+v", ups)
+	}
+
+	rec = httptest.NewRecorder()
+	dst.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/upstreams/import", strings.NewReader("groq: {target: ftp}\n  bad")))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("malformed import: status %d", rec.Code)
+	}
+}
+
+func TestUpstreamPresets(t *testing.T) {
+	mux, cfg := newTestUpstreamMux(t, "upstreams:\n  openai:\n    target: https://proxy.example.com\n")
+
+	rec := httptest.NewRecorder()
+	body := `{"presets": ["OpenAI", "groq", "anthropic"], "api_keys": {"groq": "gsk-1", "anthropic": "sk-ant"}}`
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/upstreams/presets", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var res struct {
+		Added   []string `json:"added"`
+		Skipped []string `json:"skipped"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(res.Added, ",") != "groq,anthropic" || strings.Join(res.Skipped, ",") != "openai" {
+		t.Fatalf("result = %+v", res)
+	}
+	ups := cfg.ListUpstreams()
+	if ups["openai"].Target != "https://proxy.example.com" {
+		t.Fatalf("existing upstream overwritten: %+v", ups["openai"])
+	}
+	if h := ups["groq"].DefaultHeaders["Authorization"]; h != "Bearer gsk-1" || ups["groq"].Target != "https://api.groq.com/openai" {
+		t.Fatalf("groq = %+v", ups["groq"])
+	}
+	if h := ups["anthropic"].DefaultHeaders["x-api-key"]; h != "sk-ant" {
+		t.Fatalf("anthropic = %+v", ups["anthropic"])
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/upstreams/presets", strings.NewReader(`{"presets": ["nope"]}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown preset: status %d", rec.Code)
+	}
+}
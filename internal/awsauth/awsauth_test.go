@@ -0,0 +1,76 @@
+package awsauth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestSignKnownVector checks the example from the AWS SigV4 documentation.
+func TestSignKnownVector(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	creds := Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	Sign(req, nil, "iam", "us-east-1", creds, time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, " +
+		"SignedHeaders=content-type;host;x-amz-date, " +
+		"Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Fatalf("Authorization =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestCanonicalPathDoubleEncodes(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPost, "https://bedrock-runtime.us-east-1.amazonaws.com/model/anthropic.claude-v2:1/invoke", nil)
+	if got := canonicalPath(req.URL); got != "/model/anthropic.claude-v2%3A1/invoke" {
+		t.Fatalf("canonicalPath = %s", got)
+	}
+	req, _ = http.NewRequest(http.MethodPost, "https://bedrock-runtime.us-east-1.amazonaws.com/model/anthropic.claude-v2%3A1/invoke", nil)
+	if got := canonicalPath(req.URL); got != "/model/anthropic.claude-v2%253A1/invoke" {
+		t.Fatalf("canonicalPath = %s", got)
+	}
+}
+
+func TestResolveOrder(t *testing.T) {
+	var calls int
+	imds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch r.URL.Path {
+		case "/latest/api/token":
+			w.Write([]byte("tok"))
+		case "/latest/meta-data/iam/security-credentials/":
+			if r.Header.Get("X-aws-ec2-metadata-token") != "tok" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte("role\n"))
+		case "/latest/meta-data/iam/security-credentials/role":
+			w.Write([]byte(`{"Code":"Success","AccessKeyId":"ASIA","SecretAccessKey":"s","Token":"t","Expiration":"2030-01-01T00:00:00Z"}`))
+		}
+	}))
+	defer imds.Close()
+
+	env := map[string]string{"AWS_EC2_METADATA_SERVICE_ENDPOINT": imds.URL}
+	r := NewResolver()
+	r.getenv = func(k string) string { return env[k] }
+	ctx := context.Background()
+
+	if c, err := r.Resolve(ctx, Credentials{AccessKeyID: "AKIA", SecretAccessKey: "x"}); err != nil || c.AccessKeyID != "AKIA" {
+		t.Fatalf("static: %+v, %v", c, err)
+	}
+	c, err := r.Resolve(ctx, Credentials{})
+	if err != nil || c.AccessKeyID != "ASIA" || c.SessionToken != "t" {
+		t.Fatalf("imds: %+v, %v", c, err)
+	}
+	if _, err := r.Resolve(ctx, Credentials{}); err != nil || calls != 3 {
+		t.Fatalf("cached credentials refetched: calls=%d err=%v", calls, err)
+	}
+
+	env["AWS_ACCESS_KEY_ID"], env["AWS_SECRET_ACCESS_KEY"] = "ENV", "e"
+	if c, err := r.Resolve(ctx, Credentials{}); err != nil || c.AccessKeyID != "ENV" {
+		t.Fatalf("env: %+v, %v", c, err)
+	}
+}
@@ -0,0 +1,153 @@
+package awsauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Credentials are AWS access keys; SessionToken is set for temporary
+// credentials, which expire at Expires.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expires         time.Time
+}
+
+// ErrNoCredentials is returned when no credential source is available.
+var ErrNoCredentials = errors.New("aws: no credentials (set access_key_id/secret_access_key, AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY, or run on EC2 with an instance role)")
+
+const (
+	defaultIMDSEndpoint = "http://169.254.169.254"
+	// imdsRefreshBefore renews instance role credentials ahead of expiry.
+	imdsRefreshBefore = 5 * time.Minute
+)
+
+// Resolver finds credentials in order: explicitly configured keys, the
+// AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY / AWS_SESSION_TOKEN environment
+// variables, then the EC2 instance metadata service (IMDSv2). Instance role
+// credentials are cached until shortly before they expire.
+type Resolver struct {
+	client *http.Client
+	now    func() time.Time
+	getenv func(string) string
+
+	mu     sync.Mutex
+	cached Credentials
+}
+
+// NewResolver returns a resolver using the default metadata endpoint
+// (overridable with AWS_EC2_METADATA_SERVICE_ENDPOINT).
+func NewResolver() *Resolver {
+	return &Resolver{
+		// A zero Transport ignores HTTP_PROXY: metadata requests must stay local.
+		client: &http.Client{Transport: &http.Transport{}, Timeout: 2 * time.Second},
+		now:    time.Now,
+		getenv: os.Getenv,
+	}
+}
+
+// Resolve returns static if it holds keys, else credentials from the
+// environment or the instance metadata service.
+func (r *Resolver) Resolve(ctx context.Context, static Credentials) (Credentials, error) {
+	if static.AccessKeyID != "" && static.SecretAccessKey != "" {
+		return static, nil
+	}
+	if id, secret := r.getenv("AWS_ACCESS_KEY_ID"), r.getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+		return Credentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: r.getenv("AWS_SESSION_TOKEN")}, nil
+	}
+	if strings.EqualFold(r.getenv("AWS_EC2_METADATA_DISABLED"), "true") {
+		return Credentials{}, ErrNoCredentials
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cached.AccessKeyID != "" && r.now().Add(imdsRefreshBefore).Before(r.cached.Expires) {
+		return r.cached, nil
+	}
+	creds, err := r.fromIMDS(ctx)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("%w: instance metadata: %v", ErrNoCredentials, err)
+	}
+	r.cached = creds
+	return creds, nil
+}
+
+func (r *Resolver) fromIMDS(ctx context.Context) (Credentials, error) {
+	endpoint := strings.TrimSuffix(r.getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT"), "/")
+	if endpoint == "" {
+		endpoint = defaultIMDSEndpoint
+	}
+
+	token, err := r.imds(ctx, http.MethodPut, endpoint+"/latest/api/token", map[string]string{
+		"X-aws-ec2-metadata-token-ttl-seconds": "21600",
+	})
+	if err != nil {
+		return Credentials{}, err
+	}
+	auth := map[string]string{"X-aws-ec2-metadata-token": string(token)}
+	const credsPath = "/latest/meta-data/iam/security-credentials/"
+	roles, err := r.imds(ctx, http.MethodGet, endpoint+credsPath, auth)
+	if err != nil {
+		return Credentials{}, err
+	}
+	role, _, _ := strings.Cut(strings.TrimSpace(string(roles)), "\n")
+	if role == "" {
+		return Credentials{}, errors.New("no instance role attached")
+	}
+	body, err := r.imds(ctx, http.MethodGet, endpoint+credsPath+role, auth)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	var doc struct {
+		Code            string
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string
+		Token           string
+		Expiration      time.Time
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return Credentials{}, fmt.Errorf("invalid credentials document: %v", err)
+	}
+	if doc.Code != "" && doc.Code != "Success" {
+		return Credentials{}, fmt.Errorf("role %s: %s", role, doc.Code)
+	}
+	return Credentials{
+		AccessKeyID:     doc.AccessKeyID,
+		SecretAccessKey: doc.SecretAccessKey,
+		SessionToken:    doc.Token,
+		Expires:         doc.Expiration,
+	}, nil
+}
+
+func (r *Resolver) imds(ctx context.Context, method, url string, headers map[string]string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: HTTP %d", method, req.URL.Path, resp.StatusCode)
+	}
+	return body, nil
+}
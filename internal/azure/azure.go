@@ -0,0 +1,94 @@
+// Package azure maps OpenAI-style requests onto the Azure OpenAI REST
+// layout, so clients written against api.openai.com can use an upstream of
+// type "azure-openai" unchanged: /v1/chat/completions with model "gpt-4o"
+// becomes /openai/deployments/<deployment>/chat/completions?api-version=...,
+// and the bearer token becomes the api-key header.
+package azure
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/prismcat/prismcat/internal/config"
+)
+
+// ErrNoDeployment is returned when a deployment-scoped request names no
+// model and the upstream has no default deployment.
+var ErrNoDeployment = errors.New("azure-openai: no deployment for request (set model or azure.default_deployment)")
+
+// NeedsModel reports whether a request to path is deployment-scoped, i.e.
+// RequestURL needs the model named in its body.
+func NeedsModel(path string) bool {
+	if strings.HasPrefix(path, "/openai/") {
+		return false
+	}
+	return strings.TrimPrefix(path, "/v1") != "/models"
+}
+
+// RequestURL returns the Azure URL for an OpenAI-style request URL in.
+// Paths already in the Azure layout (/openai/...) are kept. The api-version
+// query parameter is added unless the client sent one.
+func RequestURL(target, in *url.URL, model string, az config.AzureOpenAIConfig) (*url.URL, error) {
+	path := in.Path
+	if !strings.HasPrefix(path, "/openai/") {
+		op := strings.TrimPrefix(path, "/v1")
+		if op == "/models" {
+			path = "/openai/models"
+		} else {
+			deployment := Deployment(model, az)
+			if deployment == "" {
+				return nil, ErrNoDeployment
+			}
+			path = "/openai/deployments/" + url.PathEscape(deployment) + op
+		}
+	}
+
+	u := *target
+	u.Path = strings.TrimSuffix(target.Path, "/") + path
+	u.RawPath = ""
+	u.Fragment = ""
+	q := in.Query()
+	if q.Get("api-version") == "" {
+		q.Set("api-version", az.APIVersion)
+	}
+	u.RawQuery = q.Encode()
+	return &u, nil
+}
+
+// Deployment picks the deployment serving model: the configured mapping,
+// else a deployment named like the model, else the default deployment.
+func Deployment(model string, az config.AzureOpenAIConfig) string {
+	if d, ok := az.Deployments[model]; ok {
+		return d
+	}
+	if model != "" {
+		return model
+	}
+	return az.DefaultDeployment
+}
+
+// ApplyAuth sets the api-key header Azure expects. A configured key wins;
+// otherwise an OpenAI-style "Authorization: Bearer <key>" is converted.
+// Microsoft Entra ID tokens (JWTs) are valid bearer tokens and kept as is.
+func ApplyAuth(h http.Header, az config.AzureOpenAIConfig) {
+	if az.APIKey != "" {
+		h.Set("api-key", az.APIKey)
+		h.Del("Authorization")
+		return
+	}
+	if h.Get("api-key") != "" {
+		return
+	}
+	token, ok := strings.CutPrefix(h.Get("Authorization"), "Bearer ")
+	if !ok || token == "" || isJWT(token) {
+		return
+	}
+	h.Set("api-key", token)
+	h.Del("Authorization")
+}
+
+func isJWT(token string) bool {
+	return strings.HasPrefix(token, "eyJ") && strings.Count(token, ".") == 2
+}
@@ -0,0 +1,61 @@
+package azure
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/prismcat/prismcat/internal/config"
+)
+
+func TestRequestURL(t *testing.T) {
+	target, _ := url.Parse("https://res.openai.azure.com")
+	az := config.AzureOpenAIConfig{
+		APIVersion:        "2024-10-21",
+		Deployments:       map[string]string{"gpt-4o": "prod-4o"},
+		DefaultDeployment: "fallback",
+	}
+	cases := []struct {
+		in, model, want string
+	}{
+		{"/v1/chat/completions", "gpt-4o", "https://res.openai.azure.com/openai/deployments/prod-4o/chat/completions?api-version=2024-10-21"},
+		{"/chat/completions", "gpt-4o-mini", "https://res.openai.azure.com/openai/deployments/gpt-4o-mini/chat/completions?api-version=2024-10-21"},
+		{"/v1/embeddings", "", "https://res.openai.azure.com/openai/deployments/fallback/embeddings?api-version=2024-10-21"},
+		{"/v1/models", "", "https://res.openai.azure.com/openai/models?api-version=2024-10-21"},
+		{"/openai/deployments/x/completions?api-version=2023-05-15", "", "https://res.openai.azure.com/openai/deployments/x/completions?api-version=2023-05-15"},
+	}
+	for _, c := range cases {
+		in, _ := url.Parse(c.in)
+		u, err := RequestURL(target, in, c.model, az)
+		if err != nil || u.String() != c.want {
+			t.Errorf("RequestURL(%s, %q) = %v, %v; want %s", c.in, c.model, u, err, c.want)
+		}
+	}
+
+	in, _ := url.Parse("/v1/chat/completions")
+	if _, err := RequestURL(target, in, "", config.AzureOpenAIConfig{}); !errors.Is(err, ErrNoDeployment) {
+		t.Fatalf("err = %v, want ErrNoDeployment", err)
+	}
+}
+
+func TestApplyAuth(t *testing.T) {
+	h := http.Header{"Authorization": {"Bearer sk-123"}}
+	ApplyAuth(h, config.AzureOpenAIConfig{})
+	if h.Get("api-key") != "sk-123" || h.Get("Authorization") != "" {
+		t.Fatalf("bearer key not converted: %v", h)
+	}
+
+	jwt := "Bearer eyJhbGciOiJSUzI1NiJ9.eyJzdWIiOiJ4In0.sig"
+	h = http.Header{"Authorization": {jwt}}
+	ApplyAuth(h, config.AzureOpenAIConfig{})
+	if h.Get("api-key") != "" || h.Get("Authorization") != jwt {
+		t.Fatalf("Entra ID token modified: %v", h)
+	}
+
+	h = http.Header{"Authorization": {"Bearer client"}}
+	ApplyAuth(h, config.AzureOpenAIConfig{APIKey: "configured"})
+	if h.Get("api-key") != "configured" || h.Get("Authorization") != "" {
+		t.Fatalf("configured key not applied: %v", h)
+	}
+}
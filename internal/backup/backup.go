@@ -0,0 +1,192 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+const (
+	defaultDir  = "./data/backups"
+	defaultKeep = 7
+
+	filePrefix = "prismcat-backup-"
+	fileSuffix = ".tar.gz"
+)
+
+// Runner writes backups on the configured schedule. The schedule and
+// destinations are re-read from config before every run.
+type Runner struct {
+	cfg   *config.Config
+	repo  storage.Repository
+	blobs storage.BlobStore
+	// leader, when set, skips scheduled runs while it returns false.
+	leader func() bool
+}
+
+// NewRunner creates a Runner. It returns an error when the configured
+// schedule is invalid.
+func NewRunner(cfg *config.Config, repo storage.Repository, blobs storage.BlobStore) (*Runner, error) {
+	if spec := cfg.BackupSnapshot().Schedule; spec != "" {
+		if _, err := ParseSchedule(spec); err != nil {
+			return nil, fmt.Errorf("backup.schedule: %w", err)
+		}
+	}
+	return &Runner{cfg: cfg, repo: repo, blobs: blobs}, nil
+}
+
+// SetLeader limits scheduled backups to when isLeader returns true, so only
+// one of several instances sharing a database writes them.
+func (r *Runner) SetLeader(isLeader func() bool) {
+	r.leader = isLeader
+}
+
+// Run waits for each scheduled time and backs up until stop is closed. With
+// no schedule it re-checks the config every minute.
+func (r *Runner) Run(stop <-chan struct{}) {
+	for {
+		wait := time.Minute
+		var due bool
+		if spec := r.cfg.BackupSnapshot().Schedule; spec != "" {
+			if sched, err := ParseSchedule(spec); err != nil {
+				log.Printf("backup: %v", err)
+			} else if next := sched.Next(time.Now()); !next.IsZero() {
+				wait, due = time.Until(next), true
+			}
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-stop:
+			timer.Stop()
+			return
+		}
+		if due && r.leader != nil && !r.leader() {
+			continue
+		}
+		if due {
+			if path, err := r.RunOnce(context.Background()); err != nil {
+				log.Printf("scheduled backup failed: %v", err)
+			} else {
+				log.Printf("scheduled backup written: %s", path)
+			}
+		}
+	}
+}
+
+// RunOnce writes one archive to the backup dir, uploads it to S3 when
+// configured, and prunes old archives. It returns the local archive path.
+func (r *Runner) RunOnce(ctx context.Context) (string, error) {
+	bc := r.cfg.BackupSnapshot()
+	dir := bc.Dir
+	if dir == "" {
+		dir = defaultDir
+	}
+	keep := bc.Keep
+	if keep <= 0 {
+		keep = defaultKeep
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	// Timestamped names sort chronologically, which pruning relies on.
+	name := filePrefix + time.Now().Format("20060102-150405") + fileSuffix
+	path := filepath.Join(dir, name)
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return "", err
+	}
+	_, err = storage.WriteBackup(ctx, f, r.repo, r.blobs, r.cfg.Path())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return "", err
+	}
+	pruneDir(dir, keep)
+
+	if bc.S3 != nil {
+		if err := uploadS3(ctx, *bc.S3, path, name, keep); err != nil {
+			return path, fmt.Errorf("upload to s3: %w", err)
+		}
+	}
+	return path, nil
+}
+
+func uploadS3(ctx context.Context, cfg config.S3Config, path, name string, keep int) error {
+	client, err := newS3Client(cfg)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := client.Put(ctx, cfg.Prefix+name, f, info.Size()); err != nil {
+		return err
+	}
+
+	keys, err := client.List(ctx, cfg.Prefix+filePrefix)
+	if err != nil {
+		return fmt.Errorf("list old backups: %w", err)
+	}
+	for _, key := range oldest(keys, keep) {
+		if err := client.Delete(ctx, key); err != nil {
+			log.Printf("backup: delete %s from s3: %v", key, err)
+		}
+	}
+	return nil
+}
+
+func pruneDir(dir string, keep int) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), filePrefix) && strings.HasSuffix(e.Name(), fileSuffix) {
+			names = append(names, e.Name())
+		}
+	}
+	for _, name := range oldest(names, keep) {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			log.Printf("backup: prune %s: %v", name, err)
+		}
+	}
+}
+
+// oldest returns the names beyond the newest keep.
+func oldest(names []string, keep int) []string {
+	var archives []string
+	for _, n := range names {
+		if strings.HasSuffix(n, fileSuffix) {
+			archives = append(archives, n)
+		}
+	}
+	if len(archives) <= keep {
+		return nil
+	}
+	sort.Strings(archives)
+	return archives[:len(archives)-keep]
+}
@@ -0,0 +1,119 @@
+package backup
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+// fakeS3 serves path-style put/list/delete for one bucket.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+		http.Error(w, "unsigned", http.StatusForbidden)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	switch r.Method {
+	case http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		f.objects[key] = data
+	case http.MethodDelete:
+		delete(f.objects, key)
+	case http.MethodGet:
+		prefix := r.URL.Query().Get("prefix")
+		var sb strings.Builder
+		sb.WriteString("<ListBucketResult>")
+		for k := range f.objects {
+			if strings.HasPrefix(k, prefix) {
+				sb.WriteString("<Contents><Key>" + k + "</Key></Contents>")
+			}
+		}
+		sb.WriteString("<IsTruncated>false</IsTruncated></ListBucketResult>")
+		_, _ = w.Write([]byte(sb.String()))
+	}
+}
+
+func TestRunOnceUploadsAndPrunes(t *testing.T) {
+	s3 := &fakeS3{objects: map[string][]byte{
+		"pc/prismcat-backup-20000101-000000.tar.gz": []byte("old"),
+		"pc/prismcat-backup-20000102-000000.tar.gz": []byte("old"),
+		"pc/unrelated.txt":                          []byte("keep"),
+	}}
+	srv := httptest.NewServer(s3)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	repo, err := storage.NewSQLiteRepository(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+
+	backupDir := filepath.Join(dir, "backups")
+	if err := os.MkdirAll(backupDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, old := range []string{"prismcat-backup-20000101-000000.tar.gz", "prismcat-backup-20000102-000000.tar.gz"} {
+		if err := os.WriteFile(filepath.Join(backupDir, old), []byte("old"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := &config.Config{Backup: config.BackupConfig{
+		Dir:  backupDir,
+		Keep: 2,
+		S3: &config.S3Config{
+			Bucket: "bucket", Region: "us-east-1", Prefix: "pc/",
+			Endpoint: srv.URL, PathStyle: true,
+			AccessKeyID: "AKID", SecretAccessKey: "secret",
+		},
+	}}
+	r, err := NewRunner(cfg, repo, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, err := r.RunOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Base(path)
+
+	entries, _ := os.ReadDir(backupDir)
+	var local []string
+	for _, e := range entries {
+		local = append(local, e.Name())
+	}
+	if len(local) != 2 || local[1] != name {
+		t.Fatalf("local backups = %v, want the newest 2 ending with %s", local, name)
+	}
+
+	var remote []string
+	for k := range s3.objects {
+		remote = append(remote, k)
+	}
+	sort.Strings(remote)
+	want := []string{"pc/prismcat-backup-20000102-000000.tar.gz", "pc/" + name, "pc/unrelated.txt"}
+	if strings.Join(remote, ",") != strings.Join(want, ",") {
+		t.Fatalf("remote = %v, want %v", remote, want)
+	}
+	if len(s3.objects["pc/"+name]) == 0 {
+		t.Fatal("uploaded archive is empty")
+	}
+}
@@ -0,0 +1,387 @@
+// Package bench drives synthetic traffic through the proxy and the log
+// storage pipeline (detach, async queue, SQLite) to measure throughput,
+// latency and how many logs the write path sheds under load.
+package bench
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/proxy"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+// Upstream name and host the benchmark proxies through.
+const (
+	upstreamName = "bench"
+	proxyDomain  = "localhost"
+)
+
+// Options configures a benchmark run.
+type Options struct {
+	// Upstream is "mock" for a built-in upstream, or a base URL.
+	Upstream    string
+	Concurrency int
+	Duration    time.Duration
+	// Path is appended to the upstream; Method defaults to POST.
+	Path   string
+	Method string
+	// RequestBytes and ResponseBytes size the request body and the mock
+	// upstream's response body.
+	RequestBytes  int
+	ResponseBytes int
+	// Storage is "sqlite" (a temporary database unless Database is set) or
+	// "memory".
+	Storage     string
+	Database    string
+	AsyncBuffer int
+}
+
+// Result summarizes a run. Drops are log entries shed by the async queue;
+// Lost is successful requests whose log never reached the database.
+type Result struct {
+	Requests   int64              `json:"requests"`
+	Errors     int64              `json:"errors"`
+	Status     map[int]int64      `json:"status"`
+	Elapsed    time.Duration      `json:"elapsed"`
+	Throughput float64            `json:"throughput"` // requests per second
+	Latency    LatencySummary     `json:"latency"`
+	LogWrites  int64              `json:"log_writes"`
+	Dropped    storage.DropCounts `json:"dropped"`
+	DropRate   float64            `json:"drop_rate"`
+	Stored     int64              `json:"stored"`
+	Lost       int64              `json:"lost"`
+	// Drain is how long the queue took to flush after traffic stopped.
+	Drain time.Duration `json:"drain"`
+}
+
+// LatencySummary holds end-to-end request latencies through the proxy.
+type LatencySummary struct {
+	Mean time.Duration `json:"mean"`
+	P50  time.Duration `json:"p50"`
+	P90  time.Duration `json:"p90"`
+	P99  time.Duration `json:"p99"`
+	Max  time.Duration `json:"max"`
+}
+
+// keepOpen lets the async queue drain without closing the database, so the
+// stored rows can be counted afterwards.
+type keepOpen struct {
+	storage.Repository
+}
+
+func (keepOpen) Close() error { return nil }
+
+// countingWriter counts the log writes the proxy issues.
+type countingWriter struct {
+	storage.LogWriter
+	saves atomic.Int64
+}
+
+func (w *countingWriter) SaveLog(ctx context.Context, log *storage.RequestLog) error {
+	w.saves.Add(1)
+	return w.LogWriter.SaveLog(ctx, log)
+}
+
+// Run executes the benchmark until opts.Duration elapses or ctx is done.
+func Run(ctx context.Context, opts Options) (*Result, error) {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	if opts.Duration <= 0 {
+		return nil, errors.New("duration must be positive")
+	}
+	if opts.Method == "" {
+		opts.Method = http.MethodPost
+	}
+
+	target := opts.Upstream
+	if target == "" || target == "mock" {
+		mock, err := startMock(opts.ResponseBytes)
+		if err != nil {
+			return nil, err
+		}
+		defer mock.Close()
+		target = "http://" + mock.Addr
+	} else if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+		return nil, fmt.Errorf("upstream must be \"mock\" or an http(s) URL: %q", target)
+	}
+
+	cfg := &config.Config{
+		Server: config.ServerConfig{ProxyDomains: []string{proxyDomain}},
+		Logging: config.LoggingConfig{
+			MaxRequestBody:      1 << 20,
+			MaxResponseBody:     10 << 20,
+			SensitiveHeaders:    []string{"Authorization", "x-api-key", "api-key"},
+			StoreBase64:         true,
+			DetachBodyOverBytes: 256 * 1024,
+			BodyPreviewBytes:    4 * 1024,
+		},
+		Storage:   config.StorageConfig{AsyncBuffer: opts.AsyncBuffer},
+		Upstreams: map[string]config.UpstreamConfig{upstreamName: {Target: target}},
+	}
+
+	repo, blobs, cleanup, err := openStorage(opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	async := storage.NewAsyncRepository(keepOpen{storage.NewDetachingRepository(repo, blobs, cfg)}, opts.AsyncBuffer)
+	writer := &countingWriter{LogWriter: async}
+	p := proxy.New(cfg, writer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{Handler: p}
+	go func() { _ = srv.Serve(ln) }()
+	defer srv.Close()
+
+	res := drive(ctx, opts, "http://"+ln.Addr().String())
+
+	// Shutdown waits for handlers still saving their final log entry.
+	drainStart := time.Now()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	_ = srv.Shutdown(shutdownCtx)
+	cancel()
+	_ = async.Close()
+	res.Drain = time.Since(drainStart)
+
+	res.LogWrites = writer.saves.Load()
+	res.Dropped = async.QueueStats().Dropped
+	if res.LogWrites > 0 {
+		res.DropRate = float64(async.Dropped()) / float64(res.LogWrites)
+	}
+	_, stored, err := repo.ListLogs(context.Background(), storage.LogFilter{Limit: 1})
+	if err != nil {
+		return nil, fmt.Errorf("count stored logs: %w", err)
+	}
+	res.Stored = stored
+	if ok := res.Requests - res.Errors; ok > stored {
+		res.Lost = ok - stored
+	}
+	return res, nil
+}
+
+// openStorage opens the repository and blob store the run writes to.
+func openStorage(opts Options) (*storage.SQLiteRepository, storage.BlobStore, func(), error) {
+	switch opts.Storage {
+	case "memory":
+		repo, err := storage.NewMemorySQLiteRepository()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return repo, storage.NewMemoryBlobStore(0), func() { _ = repo.Close() }, nil
+	case "", "sqlite":
+	default:
+		return nil, nil, nil, fmt.Errorf("unknown storage %q (sqlite, memory)", opts.Storage)
+	}
+
+	dir, err := os.MkdirTemp("", "prismcat-bench-*")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	removeDir := func() { _ = os.RemoveAll(dir) }
+	dbPath := opts.Database
+	if dbPath == "" {
+		dbPath = filepath.Join(dir, "bench.db")
+	}
+	repo, err := storage.NewSQLiteRepository(dbPath)
+	if err != nil {
+		removeDir()
+		return nil, nil, nil, err
+	}
+	blobs, err := storage.NewFileBlobStore(filepath.Join(dir, "blobs"))
+	if err != nil {
+		_ = repo.Close()
+		removeDir()
+		return nil, nil, nil, err
+	}
+	return repo, blobs, func() {
+		_ = repo.Close()
+		removeDir()
+	}, nil
+}
+
+// drive runs the workers against the proxy at base and collects results.
+func drive(ctx context.Context, opts Options, base string) *Result {
+	ctx, cancel := context.WithTimeout(ctx, opts.Duration)
+	defer cancel()
+
+	client := &http.Client{Transport: &http.Transport{
+		MaxIdleConns:        opts.Concurrency,
+		MaxIdleConnsPerHost: opts.Concurrency,
+	}}
+	defer client.CloseIdleConnections()
+
+	body := []byte(padJSON(`{"model":"bench","input":"`, `"}`, opts.RequestBytes))
+	url := base + "/" + strings.TrimPrefix(opts.Path, "/")
+
+	var (
+		mu        sync.Mutex
+		latencies []time.Duration
+		status    = make(map[int]int64)
+		errs      int64
+		wg        sync.WaitGroup
+	)
+	start := time.Now()
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			local := make([]time.Duration, 0, 1024)
+			localStatus := make(map[int]int64)
+			var localErrs int64
+			for ctx.Err() == nil {
+				req, err := http.NewRequestWithContext(ctx, opts.Method, url, bytes.NewReader(body))
+				if err != nil {
+					localErrs++
+					break
+				}
+				req.Host = upstreamName + "." + proxyDomain
+				req.Header.Set("Content-Type", "application/json")
+				t0 := time.Now()
+				resp, err := client.Do(req)
+				if err != nil {
+					// Requests cut off by the deadline aren't counted.
+					if ctx.Err() == nil {
+						localErrs++
+						local = append(local, time.Since(t0))
+					}
+					continue
+				}
+				_, _ = io.Copy(io.Discard, resp.Body)
+				_ = resp.Body.Close()
+				local = append(local, time.Since(t0))
+				localStatus[resp.StatusCode]++
+				if resp.StatusCode >= 400 {
+					localErrs++
+				}
+			}
+			mu.Lock()
+			latencies = append(latencies, local...)
+			for code, n := range localStatus {
+				status[code] += n
+			}
+			errs += localErrs
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	res := &Result{
+		Requests: int64(len(latencies)),
+		Errors:   errs,
+		Status:   status,
+		Elapsed:  elapsed,
+		Latency:  summarize(latencies),
+	}
+	if elapsed > 0 {
+		res.Throughput = float64(res.Requests) / elapsed.Seconds()
+	}
+	return res
+}
+
+func summarize(latencies []time.Duration) LatencySummary {
+	if len(latencies) == 0 {
+		return LatencySummary{}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var total time.Duration
+	for _, d := range latencies {
+		total += d
+	}
+	at := func(q float64) time.Duration {
+		return latencies[int(q*float64(len(latencies)-1))]
+	}
+	return LatencySummary{
+		Mean: total / time.Duration(len(latencies)),
+		P50:  at(0.50),
+		P90:  at(0.90),
+		P99:  at(0.99),
+		Max:  latencies[len(latencies)-1],
+	}
+}
+
+// mockServer is an in-process upstream answering every request with a
+// fixed JSON body, so the benchmark measures PrismCat rather than a backend.
+type mockServer struct {
+	Addr string
+	srv  *http.Server
+}
+
+func startMock(responseBytes int) (*mockServer, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	payload := []byte(padJSON(`{"id":"bench","object":"chat.completion","content":"`, `"}`, responseBytes))
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(payload)
+	})}
+	go func() { _ = srv.Serve(ln) }()
+	return &mockServer{Addr: ln.Addr().String(), srv: srv}, nil
+}
+
+func (m *mockServer) Close() { _ = m.srv.Close() }
+
+// padJSON fills a JSON string value between prefix and suffix so the whole
+// document is about size bytes.
+func padJSON(prefix, suffix string, size int) string {
+	pad := size - len(prefix) - len(suffix)
+	if pad < 0 {
+		pad = 0
+	}
+	return prefix + strings.Repeat("x", pad) + suffix
+}
+
+// Print writes a human-readable report.
+func (r *Result) Print(w io.Writer) {
+	fmt.Fprintf(w, "Requests:    %d in %s (%.1f req/s)\n", r.Requests, r.Elapsed.Round(time.Millisecond), r.Throughput)
+	codes := make([]int, 0, len(r.Status))
+	for code := range r.Status {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	parts := make([]string, 0, len(codes))
+	for _, code := range codes {
+		parts = append(parts, fmt.Sprintf("%d=%d", code, r.Status[code]))
+	}
+	fmt.Fprintf(w, "Errors:      %d (status %s)\n", r.Errors, strings.Join(parts, " "))
+	fmt.Fprintf(w, "Latency:     mean %s  p50 %s  p90 %s  p99 %s  max %s\n",
+		round(r.Latency.Mean), round(r.Latency.P50), round(r.Latency.P90), round(r.Latency.P99), round(r.Latency.Max))
+	fmt.Fprintf(w, "Log writes:  %d, dropped %d (%.2f%%; success %d, error %d, snapshot %d)\n",
+		r.LogWrites, r.Dropped.Success+r.Dropped.Error+r.Dropped.Snapshot, r.DropRate*100,
+		r.Dropped.Success, r.Dropped.Error, r.Dropped.Snapshot)
+	fmt.Fprintf(w, "Stored:      %d logs, %d successful requests unlogged; queue drained in %s\n",
+		r.Stored, r.Lost, r.Drain.Round(time.Millisecond))
+}
+
+func round(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	default:
+		return d.Round(time.Microsecond)
+	}
+}
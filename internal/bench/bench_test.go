@@ -0,0 +1,31 @@
+package bench
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRunMock(t *testing.T) {
+	res, err := Run(context.Background(), Options{
+		Upstream:      "mock",
+		Concurrency:   4,
+		Duration:      300 * time.Millisecond,
+		RequestBytes:  256,
+		ResponseBytes: 512,
+		Storage:       "memory",
+		AsyncBuffer:   1024,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Requests == 0 || res.Errors != 0 || res.Status[200] != res.Requests {
+		t.Fatalf("requests = %d, errors = %d, status = %v", res.Requests, res.Errors, res.Status)
+	}
+	if res.Stored < res.Requests || res.Lost != 0 {
+		t.Fatalf("stored %d of %d requests (lost %d)", res.Stored, res.Requests, res.Lost)
+	}
+	if res.LogWrites < res.Requests || res.Latency.P50 <= 0 || res.Latency.Max < res.Latency.P99 {
+		t.Fatalf("unexpected summary: %+v", res)
+	}
+}
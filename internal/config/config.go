@@ -2,11 +2,15 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/google/uuid"
 	"gopkg.in/yaml.v3"
 )
 
@@ -14,13 +18,113 @@ const Version = "1.1.0"
 
 // Config 应用配置
 type Config struct {
-	Server    ServerConfig              `yaml:"server"`
-	Upstreams map[string]UpstreamConfig `yaml:"upstreams"`
-	Logging   LoggingConfig             `yaml:"logging"`
-	Storage   StorageConfig             `yaml:"storage"`
+	Server     ServerConfig              `yaml:"server"`
+	Upstreams  map[string]UpstreamConfig `yaml:"upstreams"`
+	Logging    LoggingConfig             `yaml:"logging"`
+	Storage    StorageConfig             `yaml:"storage"`
+	Routes     []RouteConfig             `yaml:"routes,omitempty"`
+	Rules      []RuleConfig              `yaml:"rules,omitempty"`
+	Guardrails []GuardrailConfig         `yaml:"guardrails,omitempty"`
+	Plugins    PluginsConfig             `yaml:"plugins,omitempty"`
+	Backup     BackupConfig              `yaml:"backup,omitempty"`
+	Sinks      SinksConfig               `yaml:"sinks,omitempty"`
+	DNS        DNSConfig                 `yaml:"dns,omitempty"`
+	Bandwidth  BandwidthConfig           `yaml:"bandwidth,omitempty"`
+	Cluster    ClusterConfig             `yaml:"cluster,omitempty"`
+	Discovery  DiscoveryConfig           `yaml:"discovery,omitempty"`
 
 	configPath string // 配置文件路径
 	mu         sync.RWMutex
+
+	// secretRefs 记录 ${env:...} / ${file:...} 引用，Save 时写回引用而不是明文
+	secretRefs []secretRef
+
+	// loaded 是加载（或上次保存）时配置的 YAML 形式，Save 据此只改动变化的键
+	loaded *yaml.Node
+}
+
+// RuleConfig 请求规则配置
+//
+// Rules are evaluated in order for every proxied request, before the upstream
+// is resolved. When is an expression over request attributes, e.g.
+//
+//	when: 'path.startsWith("/v1/chat") && json.model == "gpt-4o"'
+//
+// A matching rule applies all of its actions. Block stops evaluation immediately;
+// Stop ends evaluation after this rule's actions.
+type RuleConfig struct {
+	Name string `yaml:"name"`
+	When string `yaml:"when"`
+
+	// Route overrides the upstream selected by the Host (first matching rule wins).
+	Route string `yaml:"route,omitempty"`
+	// Block rejects the request with Status (default 403) and Message.
+	Block   bool   `yaml:"block,omitempty"`
+	Status  int    `yaml:"status,omitempty"`
+	Message string `yaml:"message,omitempty"`
+	// Tag sets the log tag (overrides X-PrismCat-Tag).
+	Tag string `yaml:"tag,omitempty"`
+	// SetHeaders / RemoveHeaders mutate the request forwarded upstream.
+	SetHeaders    map[string]string `yaml:"set_headers,omitempty"`
+	RemoveHeaders []string          `yaml:"remove_headers,omitempty"`
+	Stop          bool              `yaml:"stop,omitempty"`
+}
+
+// GuardrailConfig 出站内容防护规则
+//
+// Guardrails inspect request bodies before they are forwarded. A request whose
+// body contains any Keywords (case-insensitive) or matches any Patterns (regex)
+// is rejected with 403 and logged with the guardrail name.
+type GuardrailConfig struct {
+	Name string `yaml:"name"`
+	// Upstreams limits the guardrail to these upstreams (empty: all).
+	Upstreams []string `yaml:"upstreams,omitempty"`
+	Keywords  []string `yaml:"keywords,omitempty"`
+	Patterns  []string `yaml:"patterns,omitempty"`
+	// JSONFields restricts matching to string values at these dotted paths of a
+	// JSON body, e.g. "messages.*.content" ("*" matches any key or array index).
+	// Empty: match against the whole body.
+	JSONFields []string `yaml:"json_fields,omitempty"`
+	// Message is returned to the client (default: generic refusal).
+	Message string `yaml:"message,omitempty"`
+}
+
+// PluginsConfig 插件配置
+type PluginsConfig struct {
+	WASM     []WASMPluginConfig `yaml:"wasm,omitempty"`
+	Callouts []CalloutConfig    `yaml:"callouts,omitempty"`
+}
+
+// CalloutConfig 外部处理回调配置
+//
+// Before forwarding, a compact request summary is POSTed to URL and the
+// returned verdict (allow/deny/mutate headers) is honored.
+type CalloutConfig struct {
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+	// Upstreams limits the callout to these upstreams (empty: all).
+	Upstreams []string `yaml:"upstreams,omitempty"`
+	// TimeoutMs bounds the callout round trip (default 200ms).
+	TimeoutMs int `yaml:"timeout_ms,omitempty"`
+	// FailOpen forwards the request when the callout errors or times out;
+	// otherwise the request is rejected with 503.
+	FailOpen bool `yaml:"fail_open,omitempty"`
+	// IncludeBody adds up to MaxBodyBytes (default 64KB) of the request body to the summary.
+	IncludeBody  bool  `yaml:"include_body,omitempty"`
+	MaxBodyBytes int64 `yaml:"max_body_bytes,omitempty"`
+	// Headers are sent with the callout request (e.g. an auth token for the hook).
+	Headers map[string]string `yaml:"headers,omitempty"`
+}
+
+// WASMPluginConfig describes a WASM module loaded into the proxy pipeline.
+// Requires a binary built with the "prismcat_wasm" build tag.
+type WASMPluginConfig struct {
+	Name string `yaml:"name"`
+	Path string `yaml:"path"`
+	// Upstreams limits the plugin to these upstreams (empty: all).
+	Upstreams []string `yaml:"upstreams,omitempty"`
+	// TimeoutMs bounds each hook invocation (default 200ms).
+	TimeoutMs int `yaml:"timeout_ms,omitempty"`
 }
 
 // ServerConfig 服务器配置
@@ -38,6 +142,12 @@ type ServerConfig struct {
 	// so that "openai.prismcat.example.com" routes to upstream "openai".
 	ProxyDomains []string `yaml:"proxy_domains"`
 
+	// RoutingMode selects how proxy requests name their upstream: "host"
+	// (default) by subdomain of ProxyDomains, "path" by a "/u/<upstream>"
+	// path prefix that is stripped before forwarding (for networks that
+	// won't resolve "*.localhost"), or "both".
+	RoutingMode string `yaml:"routing_mode,omitempty"`
+
 	// ShutdownTimeoutSeconds controls graceful shutdown time budget.
 	ShutdownTimeoutSeconds int `yaml:"shutdown_timeout_seconds"`
 
@@ -46,12 +156,370 @@ type ServerConfig struct {
 	CORSAllowOrigins []string `yaml:"cors_allow_origins"`
 	CORSAllowMethods []string `yaml:"cors_allow_methods"`
 	CORSAllowHeaders []string `yaml:"cors_allow_headers"`
+
+	// StripClientIdentity removes client-identifying headers (X-Forwarded-*,
+	// Forwarded, X-Real-IP, Via, ...) from forwarded requests instead of
+	// appending the client address to X-Forwarded-For.
+	StripClientIdentity bool `yaml:"strip_client_identity"`
+
+	// TrustedProxies lists IPs/CIDRs of reverse proxies in front of PrismCat.
+	// X-Forwarded-For is only used to resolve the client IP when the peer is trusted.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+
+	// MaxRequestBytes caps client request bodies on the proxy path; larger
+	// requests are answered with 413 and logged (0: unlimited).
+	MaxRequestBytes int64 `yaml:"max_request_bytes,omitempty"`
+
+	// FailureNotifyThreshold is how many consecutive failures of one upstream
+	// (no response, 5xx, 401 or 403) trigger a desktop notification from the
+	// Windows tray; each streak notifies once (0: default 5, negative: off).
+	FailureNotifyThreshold int `yaml:"failure_notify_threshold,omitempty"`
 }
 
 // UpstreamConfig 上游配置
 type UpstreamConfig struct {
+	// Type "demo" answers requests with a built-in OpenAI-compatible
+	// emulator (no network, no API key); "azure-openai" maps OpenAI-style
+	// requests onto an Azure OpenAI resource (see Azure); "bedrock" signs
+	// requests for Amazon Bedrock with AWS SigV4 (see Bedrock); "vertex-ai"
+	// authenticates to Google Vertex AI with OAuth2 tokens (see Vertex);
+	// empty proxies to Target.
+	Type    string `yaml:"type,omitempty"`
 	Target  string `yaml:"target"`
 	Timeout int    `yaml:"timeout"` // 秒
+
+	// UserAgent, when set, replaces the client's User-Agent on forwarded requests.
+	UserAgent string `yaml:"user_agent,omitempty"`
+	// DefaultHeaders are added to forwarded requests only when the client did not send them.
+	DefaultHeaders map[string]string `yaml:"default_headers,omitempty"`
+
+	// ResponseSchemas validates JSON responses; failures flag the log as schema_invalid.
+	ResponseSchemas []ResponseSchemaConfig `yaml:"response_schemas,omitempty"`
+	// ResponseChecks are quality checks run on successful responses; results
+	// are stored per log and any failure flags it as check_failed.
+	ResponseChecks []ResponseCheckConfig `yaml:"response_checks,omitempty"`
+
+	// Maintenance short-circuits the upstream with an error response.
+	Maintenance MaintenanceConfig `yaml:"maintenance,omitempty"`
+
+	// Disabled answers every request with 503 without removing the upstream.
+	Disabled bool `yaml:"disabled,omitempty"`
+	// DeletedAt (RFC3339) marks an upstream deleted through the API. It is
+	// neither routed nor listed, but its config is kept so historical logs
+	// still resolve its target; RestoreUpstream brings it back.
+	DeletedAt string `yaml:"deleted_at,omitempty"`
+
+	// Canary gradually shifts traffic from Target to Canary.Target.
+	Canary CanaryConfig `yaml:"canary,omitempty"`
+
+	// Sampling logs only a share of successful requests.
+	Sampling SamplingConfig `yaml:"sampling,omitempty"`
+
+	// StreamKeepAliveSeconds sends an SSE comment (": ping") to the client
+	// whenever a text/event-stream response has been silent this long, so
+	// idle-timeout proxies don't cut long generations (0: disabled).
+	StreamKeepAliveSeconds int `yaml:"stream_keepalive_seconds,omitempty"`
+
+	// IPFamily restricts dials to one address family: "ipv4", "ipv6" or
+	// "auto" (default, either). Forcing ipv4 avoids long dial hangs on
+	// endpoints with broken IPv6 routes.
+	IPFamily string `yaml:"ip_family,omitempty"`
+
+	// Bandwidth caps this upstream's combined body throughput, on top of
+	// the global bandwidth limit.
+	Bandwidth BandwidthConfig `yaml:"bandwidth,omitempty"`
+
+	// ContextLimits maps model names to context windows in tokens. Requests
+	// whose estimated prompt plus requested output (max_tokens) exceed the
+	// model's window are rejected with 400 before reaching the provider. A
+	// key ending in "*" matches a model prefix (the longest match wins);
+	// "*" alone applies to all models.
+	ContextLimits map[string]int `yaml:"context_limits,omitempty"`
+
+	// AllowedPaths restricts the request paths forwarded to this upstream;
+	// others are rejected with 403, limiting what a client can reach with
+	// an injected key. Patterns are as in capture rules ("/v1/chat/*"
+	// matches a prefix). Empty allows every path.
+	AllowedPaths []string `yaml:"allowed_paths,omitempty"`
+
+	// MaxResponseBytes stops forwarding a response body larger than this:
+	// with 502 when Content-Length announces it, otherwise by aborting the
+	// client connection once the limit is passed (0: unlimited).
+	MaxResponseBytes int64 `yaml:"max_response_bytes,omitempty"`
+
+	// AllowUpgrade passes Upgrade / "Connection: upgrade" through to this
+	// upstream instead of stripping them as hop-by-hop headers, so
+	// WebSocket and h2c backends can switch protocols. A 101 response turns
+	// the request into a raw tunnel that is not captured.
+	AllowUpgrade bool `yaml:"allow_upgrade,omitempty"`
+
+	// Azure configures upstreams of type "azure-openai".
+	Azure AzureOpenAIConfig `yaml:"azure,omitempty"`
+	// Bedrock configures upstreams of type "bedrock".
+	Bedrock BedrockConfig `yaml:"bedrock,omitempty"`
+	// Vertex configures upstreams of type "vertex-ai".
+	Vertex VertexConfig `yaml:"vertex,omitempty"`
+}
+
+// UpstreamTypeDemo marks the built-in demo upstream. DemoTarget is the
+// placeholder target it gets when none is configured.
+const (
+	UpstreamTypeDemo = "demo"
+	DemoTarget       = "http://demo.invalid"
+)
+
+// UpstreamTypeAzureOpenAI marks an Azure OpenAI resource upstream; Target is
+// the resource endpoint, e.g. https://my-resource.openai.azure.com.
+const UpstreamTypeAzureOpenAI = "azure-openai"
+
+// DefaultAzureAPIVersion is the api-version used when none is configured.
+const DefaultAzureAPIVersion = "2024-10-21"
+
+// UpstreamTypeBedrock marks an Amazon Bedrock upstream whose requests are
+// signed with AWS SigV4.
+const UpstreamTypeBedrock = "bedrock"
+
+// BedrockConfig Amazon Bedrock 上游配置
+//
+// Forwarded requests are signed with AWS SigV4 for the "bedrock" service,
+// replacing any client Authorization header. Credentials come from the keys
+// below, else AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY / AWS_SESSION_TOKEN,
+// else the EC2 instance role (IMDSv2).
+type BedrockConfig struct {
+	// Region defaults to the region in a bedrock-runtime.<region>.amazonaws.com
+	// target. With a region and no target, the target defaults to that
+	// endpoint.
+	Region          string `yaml:"region,omitempty"`
+	AccessKeyID     string `yaml:"access_key_id,omitempty"`
+	SecretAccessKey string `yaml:"secret_access_key,omitempty"`
+	SessionToken    string `yaml:"session_token,omitempty"`
+}
+
+// UpstreamTypeOllama and UpstreamTypeLMStudio are presets for local model
+// servers: plain proxies that default to the server's standard address and
+// list models with the server's native endpoint.
+const (
+	UpstreamTypeOllama    = "ollama"
+	UpstreamTypeLMStudio  = "lmstudio"
+	OllamaDefaultTarget   = "http://127.0.0.1:11434"
+	LMStudioDefaultTarget = "http://127.0.0.1:1234"
+)
+
+// UpstreamTypeVertexAI marks a Google Vertex AI upstream authenticated with
+// Google OAuth2 access tokens. VertexDefaultTarget is its default target.
+const (
+	UpstreamTypeVertexAI = "vertex-ai"
+	VertexDefaultTarget  = "https://aiplatform.googleapis.com"
+)
+
+// VertexConfig Vertex AI 上游配置
+//
+// Forwarded requests carry "Authorization: Bearer <access token>", replacing
+// the client's. Tokens are obtained and refreshed automatically from
+// CredentialsFile (a service account key or gcloud authorized-user file) or,
+// when empty, from Application Default Credentials:
+// GOOGLE_APPLICATION_CREDENTIALS, the gcloud ADC file, then the GCE
+// metadata server.
+type VertexConfig struct {
+	CredentialsFile string `yaml:"credentials_file,omitempty"`
+}
+
+// AzureOpenAIConfig Azure OpenAI 上游配置
+//
+// OpenAI-style requests (/v1/chat/completions with a "model" field) are
+// rewritten to /openai/deployments/{deployment}/chat/completions, the
+// api-version query parameter is added when the client didn't send one, and
+// a client "Authorization: Bearer <key>" is sent as the api-key header.
+// Requests already using the Azure path layout pass through unchanged.
+type AzureOpenAIConfig struct {
+	// APIVersion defaults to DefaultAzureAPIVersion.
+	APIVersion string `yaml:"api_version,omitempty" json:"api_version,omitempty"`
+	// APIKey, when set, authenticates every request in place of the
+	// client's credentials.
+	APIKey string `yaml:"api_key,omitempty" json:"api_key,omitempty"`
+	// Deployments maps model names to deployment names. Unmapped models are
+	// used as the deployment name.
+	Deployments map[string]string `yaml:"deployments,omitempty" json:"deployments,omitempty"`
+	// DefaultDeployment serves requests that name no model.
+	DefaultDeployment string `yaml:"default_deployment,omitempty" json:"default_deployment,omitempty"`
+}
+
+// Routing modes for ServerConfig.RoutingMode.
+const (
+	RoutingModeHost = "host"
+	RoutingModePath = "path"
+	RoutingModeBoth = "both"
+)
+
+// RoutesByHost reports whether the upstream may be named by the host.
+func (s ServerConfig) RoutesByHost() bool {
+	return s.RoutingMode != RoutingModePath
+}
+
+// RoutesByPath reports whether the upstream may be named by a "/u/<upstream>"
+// path prefix.
+func (s ServerConfig) RoutesByPath() bool {
+	return s.RoutingMode == RoutingModePath || s.RoutingMode == RoutingModeBoth
+}
+
+// IP families for UpstreamConfig.IPFamily.
+const (
+	IPFamilyAuto = "auto"
+	IPFamilyV4   = "ipv4"
+	IPFamilyV6   = "ipv6"
+)
+
+// SamplingConfig 日志采样配置
+//
+// Only Rate of successful requests are logged. Errors (status >= 400 or
+// transport errors), requests taking at least SlowMs, tagged requests and
+// flagged logs (e.g. schema_invalid) are always kept. Requests that are not
+// sampled do not appear in the in-flight view.
+type SamplingConfig struct {
+	// Rate is the fraction of successful requests to keep, in (0, 1).
+	// 0 or >= 1 disables sampling.
+	Rate float64 `yaml:"rate,omitempty"`
+	// SlowMs always keeps requests at least this slow (0: disabled).
+	SlowMs int64 `yaml:"slow_ms,omitempty"`
+}
+
+// CanaryConfig 灰度发布配置
+//
+// Traffic to the canary target ramps through Steps (percentages), advancing one
+// step every StepSeconds. If the canary's error rate (5xx or transport errors)
+// exceeds MaxErrorRate after at least MinRequests canary requests, the rollout
+// is rolled back and all traffic returns to the stable target until the canary
+// config is changed.
+type CanaryConfig struct {
+	Target string `yaml:"target,omitempty"`
+	// Steps defaults to [5, 25, 100].
+	Steps []int `yaml:"steps,omitempty"`
+	// StepSeconds defaults to 600.
+	StepSeconds int `yaml:"step_seconds,omitempty"`
+	// MaxErrorRate is a fraction in (0, 1]; defaults to 0.05.
+	MaxErrorRate float64 `yaml:"max_error_rate,omitempty"`
+	// MinRequests defaults to 20.
+	MinRequests int `yaml:"min_requests,omitempty"`
+}
+
+// MaintenanceConfig 上游维护模式配置
+//
+// While enabled, requests to the upstream are answered immediately with a JSON
+// error and are never forwarded to the provider.
+type MaintenanceConfig struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Status defaults to 503.
+	Status  int    `yaml:"status,omitempty" json:"status,omitempty"`
+	Message string `yaml:"message,omitempty" json:"message,omitempty"`
+	// RetryAfter sets the Retry-After header in seconds (0: omitted).
+	RetryAfter int `yaml:"retry_after,omitempty" json:"retry_after,omitempty"`
+}
+
+// ResponseSchemaConfig 响应 JSON Schema 校验配置
+type ResponseSchemaConfig struct {
+	// Path matches the request path exactly, or as a prefix when it ends with "*".
+	Path string `yaml:"path"`
+	// SchemaFile is a path to a JSON Schema document; Schema is an inline alternative.
+	SchemaFile string                 `yaml:"schema_file,omitempty"`
+	Schema     map[string]interface{} `yaml:"schema,omitempty"`
+}
+
+// MatchPath reports whether the rule applies to the request path.
+func (s ResponseSchemaConfig) MatchPath(path string) bool {
+	if strings.HasSuffix(s.Path, "*") {
+		return strings.HasPrefix(path, strings.TrimSuffix(s.Path, "*"))
+	}
+	return s.Path == path
+}
+
+// Response check types for ResponseCheckConfig.
+const (
+	CheckJSON         = "json"          // the answer parses as JSON
+	CheckRequiredKeys = "required_keys" // the answer's JSON holds every key in Keys
+	CheckFinishReason = "finish_reason" // the model didn't stop at its token limit
+)
+
+// ResponseCheckConfig 响应质量检查配置
+//
+// Checks look at the assistant's answer extracted from the response (merged
+// for streams), or at the whole body when it isn't a known LLM format.
+type ResponseCheckConfig struct {
+	// Name identifies the check in logs and stats (default: Type).
+	Name string `yaml:"name,omitempty"`
+	// Path matches like ResponseSchemaConfig.Path; empty checks every path.
+	Path string `yaml:"path,omitempty"`
+	Type string `yaml:"type"`
+	// Keys are dotted paths for required_keys, e.g. "answer" or "items.0.id".
+	Keys []string `yaml:"keys,omitempty"`
+}
+
+// MatchPath reports whether the check applies to the request path.
+func (c ResponseCheckConfig) MatchPath(path string) bool {
+	return c.Path == "" || ResponseSchemaConfig{Path: c.Path}.MatchPath(path)
+}
+
+// Capture modes for CaptureRuleConfig.
+const (
+	CaptureFull     = "full"
+	CaptureMetadata = "metadata"
+	CaptureNone     = "none"
+)
+
+// CaptureRuleConfig 按方法 + 路径控制日志采集
+//
+// Rules are evaluated in order and the first match wins. Path is a glob where
+// "*" matches within one segment; a trailing "*" matches any suffix.
+type CaptureRuleConfig struct {
+	Method    string   `yaml:"method,omitempty"`    // empty: any method
+	Path      string   `yaml:"path"`                // e.g. "/v1/audio/*"
+	Upstreams []string `yaml:"upstreams,omitempty"` // empty: all upstreams
+	// Capture is "full" (default), "metadata" (no bodies) or "none" (no log).
+	Capture string `yaml:"capture"`
+}
+
+// Match reports whether the rule applies to the request.
+func (c CaptureRuleConfig) Match(upstream, method, reqPath string) bool {
+	if c.Method != "" && !strings.EqualFold(c.Method, method) {
+		return false
+	}
+	if len(c.Upstreams) > 0 {
+		found := false
+		for _, u := range c.Upstreams {
+			if strings.EqualFold(u, upstream) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return matchPath(c.Path, reqPath)
+}
+
+// matchPath matches a request path against a pattern: a trailing "*" on an
+// otherwise literal pattern matches by prefix ("/v1/audio/*"), anything else
+// uses path.Match.
+func matchPath(pattern, reqPath string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok && !strings.ContainsAny(prefix, "*?[") {
+		return strings.HasPrefix(reqPath, prefix)
+	}
+	ok, _ := path.Match(pattern, reqPath)
+	return ok
+}
+
+func validateCaptureRules(rules []CaptureRuleConfig) error {
+	for i, rule := range rules {
+		switch rule.Capture {
+		case "", CaptureFull, CaptureMetadata, CaptureNone:
+		default:
+			return fmt.Errorf("logging.capture_rules[%d]: invalid capture %q (full, metadata, none)", i, rule.Capture)
+		}
+		if _, err := path.Match(rule.Path, ""); err != nil {
+			return fmt.Errorf("logging.capture_rules[%d]: invalid path %q: %w", i, rule.Path, err)
+		}
+	}
+	return nil
 }
 
 // LoggingConfig 日志配置
@@ -70,10 +538,81 @@ type LoggingConfig struct {
 	// in request_logs.request_body/response_body for quick viewing.
 	// 0: disable preview (store empty preview).
 	BodyPreviewBytes int64 `yaml:"body_preview_bytes"`
+
+	// CaptureRules exclude noisy or sensitive endpoints from capture per
+	// method + path, without disabling logging for the whole upstream.
+	CaptureRules []CaptureRuleConfig `yaml:"capture_rules"`
+
+	// SummarizeEmbeddings stores a summary (model, count, dimensions, usage)
+	// instead of the vectors for embeddings responses. Clients still receive
+	// the full response.
+	SummarizeEmbeddings bool `yaml:"summarize_embeddings"`
+
+	// RedactPatterns are extra regular expressions masked by redacted exports
+	// (redact=true), on top of the built-in PII rules.
+	RedactPatterns []string `yaml:"redact_patterns"`
+
+	// FullCapture stores only body previews unless a trigger fires.
+	FullCapture FullCaptureConfig `yaml:"full_capture"`
+
+	// Properties controls capture of X-PrismCat-Property-* headers.
+	Properties PropertiesConfig `yaml:"properties"`
+
+	// AllowNoLogHeader lets clients opt a request out of capture with
+	// "X-PrismCat-No-Log: body" (metadata only) or "all" (no log entry).
+	// The header is always removed before forwarding.
+	AllowNoLogHeader bool `yaml:"allow_no_log_header"`
+
+	// DuplicateWindowSeconds flags a request as a duplicate when an identical
+	// one (same fingerprint) was logged less than this many seconds before,
+	// linking it to the first log of the run (0: disabled).
+	DuplicateWindowSeconds int `yaml:"duplicate_window_seconds"`
+}
+
+// FullCaptureConfig 条件完整捕获配置
+//
+// When enabled, bodies are stored as previews of BodyPreviewBytes and kept in
+// full (up to max_request_body/max_response_body) only for requests that
+// hit a trigger: a status code >= MinStatus, a latency >= SlowMs, or the
+// TriggerHeader on the request.
+type FullCaptureConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// MinStatus keeps full bodies for responses at or above this status
+	// (0: default 400). Transport errors always trigger.
+	MinStatus int `yaml:"min_status"`
+	// SlowMs keeps full bodies for requests at least this slow (0: disabled).
+	SlowMs int64 `yaml:"slow_ms"`
+	// TriggerHeader keeps full bodies when the client sends this header
+	// with any non-empty value (default "X-PrismCat-Capture").
+	TriggerHeader string `yaml:"trigger_header"`
 }
 
+// PropertiesConfig 自定义属性采集配置
+//
+// A request header "X-PrismCat-Property-Feature: search" is recorded as the
+// property feature=search on the log and removed before forwarding. Keys are
+// lowercased; headers outside Allow are still removed but not recorded.
+type PropertiesConfig struct {
+	// Allow limits the captured keys; empty captures any key.
+	Allow []string `yaml:"allow"`
+	// MaxCount caps properties per request (0: default 20).
+	MaxCount int `yaml:"max_count"`
+	// MaxValueBytes truncates longer values (0: default 256).
+	MaxValueBytes int `yaml:"max_value_bytes"`
+}
+
+// Storage drivers for StorageConfig.Driver.
+const (
+	StorageDriverSQLite = "sqlite"
+	StorageDriverMemory = "memory"
+)
+
 // StorageConfig 存储配置
 type StorageConfig struct {
+	// Driver selects where logs live: "sqlite" (default, Database on disk)
+	// or "memory" (in process, capped by MemoryMaxMB and lost on exit; for
+	// demos, CI and sessions where nothing should touch disk).
+	Driver        string `yaml:"driver,omitempty"`
 	Database      string `yaml:"database"`
 	RetentionDays int    `yaml:"retention_days"`
 
@@ -83,8 +622,354 @@ type StorageConfig struct {
 	// BlobDir is used when BlobStore == "fs".
 	// BlobDir is used when BlobStore == "fs".
 	BlobDir string `yaml:"blob_dir"`
+	// MaxBlobBytes caps the blob store size. Over the cap, the
+	// least-recently-referenced blobs are evicted and their logs keep only
+	// the inline preview. 0: unlimited.
+	MaxBlobBytes int64 `yaml:"max_blob_bytes"`
 	// AsyncBuffer controls the capacity of the async log queue.
 	AsyncBuffer int `yaml:"async_buffer"`
+	// MinFreeDiskMB switches logging to metadata-only while free space on the
+	// database or blob volume is below this many MB. 0 disables the check.
+	MinFreeDiskMB int64 `yaml:"min_free_disk_mb"`
+	// ReadPoolSize, when positive, serves log browsing and stats from a
+	// separate read-only connection pool of this size, so heavy dashboard
+	// queries never block log ingestion. 0 shares the write pool.
+	ReadPoolSize int `yaml:"read_pool_size,omitempty"`
+	// ReadReplica points those reads at a replica of the database (kept in
+	// sync externally, e.g. by Litestream) instead of the primary file.
+	// Implies a read pool (default size 4).
+	ReadReplica string `yaml:"read_replica,omitempty"`
+	// MemoryMaxMB caps the memory driver, split evenly between logs and
+	// detached bodies; the oldest are dropped first. 0: default 256.
+	MemoryMaxMB int64 `yaml:"memory_max_mb,omitempty"`
+	// Archive moves the full bodies of old logs to a compressed cold tier.
+	Archive ArchiveConfig `yaml:"archive,omitempty"`
+	// StatsCacheMs caches the dashboard aggregates (stats, property and
+	// traffic stats): under write load a result is reused for this long,
+	// with no writes until the next one. 0: default 5000; negative disables.
+	StatsCacheMs int `yaml:"stats_cache_ms,omitempty"`
+	// QueryTimeoutMs bounds each log browsing or stats query, so a slow
+	// aggregate is abandoned instead of holding a pool connection; a client
+	// that disconnects cancels its query sooner. 0: default 30000; negative
+	// disables.
+	QueryTimeoutMs int `yaml:"query_timeout_ms,omitempty"`
+	// Journal records each request in a small append-only file next to the
+	// database before it is forwarded, outside the async log queue, so a
+	// crash can't lose the fact that it was sent (see storage.Journal).
+	// Each logged request appends two or three short lines under one
+	// process-wide lock (no fsync unless JournalSync); turn it off if that
+	// shows up at very high request rates. Default true; the memory driver
+	// has no journal.
+	Journal bool `yaml:"journal"`
+	// JournalSync fsyncs every journal record, so requests also survive a
+	// power loss, at the cost of a disk flush per request.
+	JournalSync bool `yaml:"journal_sync,omitempty"`
+}
+
+// ArchiveConfig 请求/响应体归档配置
+//
+// After AfterDays, the maintenance loop moves the full bodies of logs into
+// gzip-compressed objects in Dir or an S3 bucket, leaving the inline preview
+// and all metadata in the database. Reading a body falls back to the archive
+// transparently while it is reachable. Exactly one of Dir and S3 is set.
+type ArchiveConfig struct {
+	// AfterDays is the log age at which bodies are archived. 0 disables.
+	AfterDays int              `yaml:"after_days"`
+	Dir       string           `yaml:"dir,omitempty"`
+	S3        *S3ArchiveConfig `yaml:"s3,omitempty"`
+}
+
+// S3ArchiveConfig points the archive at an S3 (or S3-compatible) bucket.
+// Credentials default to the AWS environment variables or the EC2 instance
+// role, as for bedrock upstreams.
+type S3ArchiveConfig struct {
+	Bucket string `yaml:"bucket"`
+	Region string `yaml:"region"`
+	// Endpoint selects an S3-compatible service (e.g. MinIO), addressed
+	// path-style. Empty: AWS S3 in Region.
+	Endpoint        string `yaml:"endpoint,omitempty"`
+	Prefix          string `yaml:"prefix,omitempty"`
+	AccessKeyID     string `yaml:"access_key_id,omitempty"`
+	SecretAccessKey string `yaml:"secret_access_key,omitempty"`
+	SessionToken    string `yaml:"session_token,omitempty"`
+}
+
+// ClusterConfig 多实例共享存储配置
+//
+// Several instances can serve traffic from one shared store (a SQLite
+// database and blob dir on a volume local to all of them). Each instance
+// stamps its logs with InstanceID in the source field, and maintenance jobs
+// (retention, blob GC and quota, scheduled backups) run only on the instance
+// currently holding the maintenance lease, taken over by another instance
+// once it expires.
+type ClusterConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// InstanceID names this instance. It must differ between instances, as
+	// it tells apart lease holders, journals and in-flight logs. The default,
+	// the hostname plus a random suffix, is unique but changes on every
+	// start, so a restarted instance can't recover the requests its last run
+	// left in flight; set a stable id per process to keep that.
+	InstanceID string `yaml:"instance_id,omitempty"`
+	// LeaseSeconds is how long the maintenance lease lasts without renewal
+	// (default 60); the holder renews it every third of that.
+	LeaseSeconds int `yaml:"lease_seconds,omitempty"`
+}
+
+// DiscoveryConfig 本地模型服务发现配置
+//
+// When Local is set, PrismCat probes for an Ollama (127.0.0.1:11434) or LM
+// Studio (127.0.0.1:1234) server at startup and registers each one found as
+// an upstream of type "ollama" or "lmstudio" for this run, unless an upstream
+// already targets it. Registering from the UI (/api/discovery/local) also
+// saves them to the config file.
+type DiscoveryConfig struct {
+	Local bool `yaml:"local"`
+}
+
+// DNSConfig 上游 DNS 缓存配置
+//
+// When CacheTTLSeconds is positive, upstream host lookups are cached in
+// process so flaky or slow resolvers are hit at most once per TTL. Failed
+// lookups are cached for NegativeTTLSeconds (0 = not cached) so a broken
+// resolver fails fast instead of stalling every request.
+type DNSConfig struct {
+	CacheTTLSeconds    int `yaml:"cache_ttl_seconds"`
+	NegativeTTLSeconds int `yaml:"negative_ttl_seconds,omitempty"`
+}
+
+// BandwidthConfig 带宽限制配置
+//
+// Limits are in bytes per second and shared by all concurrent requests in
+// scope (globally, or per upstream). Upload is request bodies sent to the
+// upstream, download is response bodies forwarded to clients. 0 = unlimited.
+type BandwidthConfig struct {
+	UploadBytesPerSec   int64 `yaml:"upload_bytes_per_sec,omitempty"`
+	DownloadBytesPerSec int64 `yaml:"download_bytes_per_sec,omitempty"`
+}
+
+// BackupConfig 定时备份配置
+//
+// Backups are archives written by storage.WriteBackup. Each run writes one to
+// Dir and, when S3 is configured, uploads it as well. Only the newest Keep
+// archives are retained in each destination.
+type BackupConfig struct {
+	// Schedule is a 5-field cron expression ("0 3 * * *"), a descriptor
+	// (@hourly, @daily, @weekly, @monthly) or "@every <duration>".
+	// Empty disables scheduled backups.
+	Schedule string `yaml:"schedule"`
+	// Dir holds local archives (default "./data/backups").
+	Dir string `yaml:"dir,omitempty"`
+	// Keep is how many archives to retain per destination (default 7).
+	Keep int `yaml:"keep,omitempty"`
+	// S3 optionally uploads each archive to an S3-compatible bucket.
+	S3 *S3Config `yaml:"s3,omitempty"`
+}
+
+// S3Config S3 兼容对象存储配置
+//
+// Credentials fall back to PRISMCAT_S3_ACCESS_KEY_ID / PRISMCAT_S3_SECRET_ACCESS_KEY,
+// then AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY, so they can stay out of the
+// config file.
+type S3Config struct {
+	Bucket string `yaml:"bucket"`
+	Region string `yaml:"region"`
+	Prefix string `yaml:"prefix,omitempty"`
+	// Endpoint overrides the AWS endpoint for S3-compatible stores
+	// (e.g. "https://minio.local:9000").
+	Endpoint string `yaml:"endpoint,omitempty"`
+	// PathStyle addresses the bucket as endpoint/bucket/key (MinIO etc.)
+	// instead of bucket.endpoint/key.
+	PathStyle       bool   `yaml:"path_style,omitempty"`
+	AccessKeyID     string `yaml:"access_key_id,omitempty"`
+	SecretAccessKey string `yaml:"secret_access_key,omitempty"`
+}
+
+// SinksConfig 日志外送配置
+//
+// Sinks receive a copy of every finalized log after it has been stored
+// locally. They are set up at startup; changes need a restart.
+type SinksConfig struct {
+	Remote     *RemoteSinkConfig     `yaml:"remote,omitempty"`
+	Kafka      *KafkaSinkConfig      `yaml:"kafka,omitempty"`
+	ClickHouse *ClickHouseSinkConfig `yaml:"clickhouse,omitempty"`
+	Elastic    *ElasticSinkConfig    `yaml:"elasticsearch,omitempty"`
+	OTLP       *OTLPSinkConfig       `yaml:"otlp,omitempty"`
+	Langfuse   *LangfuseSinkConfig   `yaml:"langfuse,omitempty"`
+	LangSmith  *LangSmithSinkConfig  `yaml:"langsmith,omitempty"`
+	File       *FileSinkConfig       `yaml:"file,omitempty"`
+	Syslog     *SyslogSinkConfig     `yaml:"syslog,omitempty"`
+}
+
+// RemoteSinkConfig 转发到中心 PrismCat 实例
+//
+// Logs are posted to the central instance's /api/ingest; bodies it doesn't
+// have yet are uploaded from the local blob store on request.
+type RemoteSinkConfig struct {
+	// URL is the central instance's UI base URL, e.g. "https://prismcat.team.internal".
+	URL string `yaml:"url"`
+	// Password is the central instance's ui_password, if it has one.
+	Password string `yaml:"password,omitempty"`
+	// Source names this instance on the central dashboard (default hostname).
+	Source string `yaml:"source,omitempty"`
+	// BatchSize caps logs per request (default 100).
+	BatchSize int `yaml:"batch_size,omitempty"`
+	// FlushIntervalMs sends partial batches after this long (default 2000).
+	FlushIntervalMs int `yaml:"flush_interval_ms,omitempty"`
+}
+
+// KafkaSinkConfig 发布到 Kafka topic
+//
+// Each log is one JSON message keyed by log ID, so a log always lands on the
+// same partition. Delivery is at-least-once: a retried batch only resends
+// the logs whose partition did not acknowledge them, but a connection lost
+// mid-request may still repeat messages. A log over 900KB of JSON is dropped.
+type KafkaSinkConfig struct {
+	Brokers []string `yaml:"brokers"`
+	Topic   string   `yaml:"topic"`
+	// TLS connects to the brokers over TLS.
+	TLS bool `yaml:"tls,omitempty"`
+	// Username and Password enable SASL/PLAIN.
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	// BatchSize caps logs per produce round (default 100).
+	BatchSize int `yaml:"batch_size,omitempty"`
+	// FlushIntervalMs sends partial batches after this long (default 2000).
+	FlushIntervalMs int `yaml:"flush_interval_ms,omitempty"`
+}
+
+// ClickHouseSinkConfig 写入 ClickHouse 用于长期分析
+//
+// Rows are inserted over the HTTP interface into Table, which is created on
+// first use as a ReplacingMergeTree ordered by (upstream, created_at, id), so
+// rows repeated by a retried insert collapse on merge.
+type ClickHouseSinkConfig struct {
+	// URL is the HTTP interface, e.g. "http://clickhouse:8123".
+	URL      string `yaml:"url"`
+	Database string `yaml:"database,omitempty"` // default "default"
+	Table    string `yaml:"table,omitempty"`    // default "prismcat_logs"
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	// Bodies also exports request/response bodies; otherwise only summaries
+	// and body refs are written.
+	Bodies bool `yaml:"bodies,omitempty"`
+	// BatchSize caps rows per insert (default 100).
+	BatchSize int `yaml:"batch_size,omitempty"`
+	// FlushIntervalMs sends partial batches after this long (default 2000).
+	FlushIntervalMs int `yaml:"flush_interval_ms,omitempty"`
+}
+
+// ElasticSinkConfig 写入 Elasticsearch / OpenSearch
+//
+// Logs are indexed with the bulk API under their log ID. An index template
+// with the PrismCat mapping is installed on first use and covers Index and,
+// when Daily is set, the dated indices "<index>-YYYY.MM.DD".
+type ElasticSinkConfig struct {
+	// URL is the cluster endpoint, e.g. "https://es.internal:9200".
+	URL   string `yaml:"url"`
+	Index string `yaml:"index,omitempty"` // default "prismcat-logs"
+	// Daily writes to one index per UTC day, which makes retention a matter
+	// of deleting old indices.
+	Daily bool `yaml:"daily,omitempty"`
+	// Username/Password use basic auth; APIKey (Elasticsearch) takes precedence.
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	APIKey   string `yaml:"api_key,omitempty"`
+	// BatchSize caps documents per bulk request (default 100).
+	BatchSize int `yaml:"batch_size,omitempty"`
+	// FlushIntervalMs sends partial batches after this long (default 2000).
+	FlushIntervalMs int `yaml:"flush_interval_ms,omitempty"`
+}
+
+// OTLPSinkConfig 以 OpenTelemetry 日志记录导出（OTLP/HTTP JSON）
+//
+// Requests that carried a W3C traceparent header are exported with that
+// trace and span ID, so the record sits next to the caller's span.
+type OTLPSinkConfig struct {
+	// Endpoint is the collector's OTLP/HTTP base URL, e.g.
+	// "http://otel-collector:4318"; "/v1/logs" is appended unless present.
+	Endpoint string `yaml:"endpoint"`
+	// Headers are added to every export request (e.g. auth tokens).
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// ServiceName sets the service.name resource attribute (default "prismcat").
+	ServiceName string `yaml:"service_name,omitempty"`
+	// Bodies adds request/response bodies as attributes.
+	Bodies bool `yaml:"bodies,omitempty"`
+	// BatchSize caps records per export (default 100).
+	BatchSize int `yaml:"batch_size,omitempty"`
+	// FlushIntervalMs sends partial batches after this long (default 2000).
+	FlushIntervalMs int `yaml:"flush_interval_ms,omitempty"`
+}
+
+// LangfuseSinkConfig 将 LLM 调用导出为 Langfuse trace / generation
+//
+// Only logs recognised as LLM calls are exported, with the prompt, completion,
+// model, token usage and latency decoded from the captured bodies.
+type LangfuseSinkConfig struct {
+	// Host is the Langfuse base URL (default "https://cloud.langfuse.com").
+	Host      string `yaml:"host,omitempty"`
+	PublicKey string `yaml:"public_key"`
+	SecretKey string `yaml:"secret_key"`
+	// BatchSize caps calls per ingestion request (default 100).
+	BatchSize int `yaml:"batch_size,omitempty"`
+	// FlushIntervalMs sends partial batches after this long (default 2000).
+	FlushIntervalMs int `yaml:"flush_interval_ms,omitempty"`
+}
+
+// LangSmithSinkConfig 将 LLM 调用导出为 LangSmith llm run
+type LangSmithSinkConfig struct {
+	// Endpoint is the LangSmith API URL (default "https://api.smith.langchain.com").
+	Endpoint string `yaml:"endpoint,omitempty"`
+	APIKey   string `yaml:"api_key"`
+	// Project receives the runs (default "default").
+	Project string `yaml:"project,omitempty"`
+	// BatchSize caps runs per batch request (default 100).
+	BatchSize int `yaml:"batch_size,omitempty"`
+	// FlushIntervalMs sends partial batches after this long (default 2000).
+	FlushIntervalMs int `yaml:"flush_interval_ms,omitempty"`
+}
+
+// FileSinkConfig 以 JSONL 追加写入本地滚动文件
+//
+// Every finalized log becomes one JSON line, independent of the database,
+// so raw captures can be processed with jq whatever the DB state.
+type FileSinkConfig struct {
+	// Path is the active file, e.g. "./data/capture.jsonl". Rotated files
+	// get a timestamp before the extension: capture-20260102T150405.000.jsonl.
+	Path string `yaml:"path"`
+	// MaxSizeMB rotates the file once it reaches this size (default 100).
+	MaxSizeMB int `yaml:"max_size_mb,omitempty"`
+	// MaxFiles keeps this many rotated files, deleting the oldest (0: keep all).
+	MaxFiles int `yaml:"max_files,omitempty"`
+	// InlineBodies writes full bodies in place of the previews of detached
+	// bodies, read back from the blob store, so each line stands alone.
+	InlineBodies bool `yaml:"inline_bodies,omitempty"`
+	// BatchSize caps logs per write (default 100).
+	BatchSize int `yaml:"batch_size,omitempty"`
+	// FlushIntervalMs writes partial batches after this long (default 2000).
+	FlushIntervalMs int `yaml:"flush_interval_ms,omitempty"`
+}
+
+// SyslogSinkConfig 以 RFC 5424 syslog 发送单行访问记录
+//
+// Each request becomes one message whose text is key=value pairs (method,
+// path, status, latency, sizes, client IP, ...), for SIEM collection.
+// Bodies and headers are never sent.
+type SyslogSinkConfig struct {
+	// Address is the collector's host:port, e.g. "siem.internal:514".
+	Address string `yaml:"address"`
+	// Network is "udp" (default), "tcp" or "tls". Stream transports use
+	// octet-counting framing (RFC 6587 / RFC 5425).
+	Network string `yaml:"network,omitempty"`
+	// Facility is the syslog facility name (default "local0").
+	Facility string `yaml:"facility,omitempty"`
+	// AppName and Hostname fill the message header (defaults "prismcat"
+	// and the machine hostname).
+	AppName  string `yaml:"app_name,omitempty"`
+	Hostname string `yaml:"hostname,omitempty"`
+	// BatchSize caps messages per write round (default 100).
+	BatchSize int `yaml:"batch_size,omitempty"`
+	// FlushIntervalMs sends partial batches after this long (default 2000).
+	FlushIntervalMs int `yaml:"flush_interval_ms,omitempty"`
 }
 
 var (
@@ -92,7 +977,7 @@ var (
 	once sync.Once
 )
 
-// Load 加载配置文件
+// Load 加载配置文件，按扩展名识别格式：.json、.toml，其余按 YAML 解析
 func Load(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -106,7 +991,7 @@ func Load(path string) (*Config, error) {
 			ProxyDomains:           []string{"localhost"},
 			ShutdownTimeoutSeconds: 10,
 			CORSAllowOrigins:       []string{"*"},
-			CORSAllowMethods:       []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+			CORSAllowMethods:       []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 			CORSAllowHeaders:       []string{"Content-Type", "Authorization"},
 		},
 		Logging: LoggingConfig{
@@ -118,58 +1003,39 @@ func Load(path string) (*Config, error) {
 			BodyPreviewBytes:    4 * 1024,
 		},
 		Storage: StorageConfig{
-			Database:    "./data/prismcat.db",
-			BlobStore:   "fs",
-			BlobDir:     "./data/blobs",
-			AsyncBuffer: 4096,
+			Database:      "./data/prismcat.db",
+			BlobStore:     "fs",
+			BlobDir:       "./data/blobs",
+			AsyncBuffer:   4096,
+			MinFreeDiskMB: 512,
+			Journal:       true,
 		},
 		Upstreams: make(map[string]UpstreamConfig),
 	}
 
-	if err := yaml.Unmarshal(data, &c); err != nil {
+	if err := decodeConfig(FormatOf(path), data, &c); err != nil {
 		return nil, fmt.Errorf("解析配置文件失败: %w", err)
 	}
 
 	c.configPath = path
 
-	// 覆盖环境变量 (云端/容器化部署优先)
-	if envAddr := os.Getenv("PRISMCAT_ADDR"); envAddr != "" {
-		c.Server.Addr = envAddr
-	}
-	if envPort := os.Getenv("PRISMCAT_PORT"); envPort != "" {
-		if p, err := parsePort(envPort); err == nil {
-			c.Server.Port = p
-		}
-	}
-	if envUIHosts := os.Getenv("PRISMCAT_UI_HOSTS"); envUIHosts != "" {
-		c.Server.UIHosts = splitCSV(envUIHosts)
-	}
-	if envProxyDomains := os.Getenv("PRISMCAT_PROXY_DOMAINS"); envProxyDomains != "" {
-		c.Server.ProxyDomains = splitCSV(envProxyDomains)
-	}
-	if envDB := os.Getenv("PRISMCAT_DB_PATH"); envDB != "" {
-		c.Storage.Database = envDB
-	}
-	if envBlobDir := os.Getenv("PRISMCAT_BLOB_DIR"); envBlobDir != "" {
-		c.Storage.BlobDir = envBlobDir
-	}
-	if envRetention := os.Getenv("PRISMCAT_RETENTION_DAYS"); envRetention != "" {
-		if d, err := parsePort(envRetention); err == nil { // reuse parsePort for int
-			c.Storage.RetentionDays = d
-		}
-	}
-	if envAsyncBuffer := os.Getenv("PRISMCAT_ASYNC_BUFFER"); envAsyncBuffer != "" {
-		if b, err := parsePort(envAsyncBuffer); err == nil {
-			c.Storage.AsyncBuffer = b
-		}
-	}
-	if envPassword := os.Getenv("PRISMCAT_UI_PASSWORD"); envPassword != "" {
-		c.Server.UIPassword = envPassword
+	// 覆盖环境变量 (云端/容器化部署优先)，见 applyEnv
+	if err := applyEnv(&c, os.Environ()); err != nil {
+		return nil, fmt.Errorf("环境变量配置无效: %w", err)
 	}
 
 	// Normalize case/spacing for host-based matching.
 	c.Server.UIHosts = normalizeLowerList(c.Server.UIHosts)
 	c.Server.ProxyDomains = normalizeLowerList(c.Server.ProxyDomains)
+	switch c.Server.RoutingMode = normalizeLower(c.Server.RoutingMode); c.Server.RoutingMode {
+	case "", RoutingModeHost, RoutingModePath, RoutingModeBoth:
+	default:
+		return nil, fmt.Errorf("server.routing_mode: invalid value %q (host, path, both)", c.Server.RoutingMode)
+	}
+
+	if err := validateCaptureRules(c.Logging.CaptureRules); err != nil {
+		return nil, err
+	}
 
 	normalizedUpstreams, err := normalizeUpstreams(c.Upstreams)
 	if err != nil {
@@ -177,25 +1043,73 @@ func Load(path string) (*Config, error) {
 	}
 	c.Upstreams = normalizedUpstreams
 
-	// 确保目录存在
-	dbDir := filepath.Dir(c.Storage.Database)
-	if err := os.MkdirAll(dbDir, 0755); err != nil {
-		return nil, fmt.Errorf("创建数据库目录失败: %w", err)
+	if c.Routes, err = normalizeRoutes(c.Routes); err != nil {
+		return nil, err
+	}
+
+	// 解析密钥引用（${env:NAME}、${file:/path}），保存时还原为引用
+	if err := resolveSecrets(&c); err != nil {
+		return nil, fmt.Errorf("解析密钥引用失败: %w", err)
+	}
+
+	switch c.Storage.Driver = normalizeLower(c.Storage.Driver); c.Storage.Driver {
+	case "", StorageDriverSQLite, StorageDriverMemory:
+	default:
+		return nil, fmt.Errorf("storage.driver: invalid value %q (sqlite, memory)", c.Storage.Driver)
+	}
+
+	if c.Cluster.Enabled {
+		if c.Storage.Driver == StorageDriverMemory {
+			return nil, fmt.Errorf("cluster.enabled: not supported with storage.driver %q", StorageDriverMemory)
+		}
+		if c.Cluster.InstanceID = strings.TrimSpace(c.Cluster.InstanceID); c.Cluster.InstanceID == "" {
+			c.Cluster.InstanceID = defaultInstanceID()
+		}
+		if c.Cluster.LeaseSeconds <= 0 {
+			c.Cluster.LeaseSeconds = 60
+		}
+	}
+
+	if a := c.Storage.Archive; a.AfterDays > 0 {
+		switch {
+		case c.Storage.Driver == StorageDriverMemory:
+			return nil, fmt.Errorf("storage.archive: not supported with storage.driver %q", StorageDriverMemory)
+		case (a.Dir == "") == (a.S3 == nil):
+			return nil, fmt.Errorf("storage.archive: set exactly one of dir and s3")
+		case a.S3 != nil && (a.S3.Bucket == "" || a.S3.Region == ""):
+			return nil, fmt.Errorf("storage.archive.s3: bucket and region are required")
+		}
 	}
-	if c.Storage.BlobStore == "fs" {
-		if err := os.MkdirAll(c.Storage.BlobDir, 0755); err != nil {
-			return nil, fmt.Errorf("创建 blob 目录失败: %w", err)
+
+	// 确保目录存在（内存存储不落盘）
+	if c.Storage.Driver != StorageDriverMemory {
+		dbDir := filepath.Dir(c.Storage.Database)
+		if err := os.MkdirAll(dbDir, 0755); err != nil {
+			return nil, fmt.Errorf("创建数据库目录失败: %w", err)
+		}
+		if c.Storage.BlobStore == "fs" {
+			if err := os.MkdirAll(c.Storage.BlobDir, 0755); err != nil {
+				return nil, fmt.Errorf("创建 blob 目录失败: %w", err)
+			}
 		}
 	}
 
+	if c.loaded, err = encodeNode(&c); err != nil {
+		return nil, fmt.Errorf("序列化配置失败: %w", err)
+	}
+
 	cfg = &c
 	return &c, nil
 }
 
-func parsePort(s string) (int, error) {
-	var n int
-	_, err := fmt.Sscanf(s, "%d", &n)
-	return n, err
+// defaultInstanceID returns the hostname with a random suffix, so that
+// several instances on one host get different ids.
+func defaultInstanceID() string {
+	suffix := uuid.New().String()[:8]
+	if host, err := os.Hostname(); err == nil && host != "" {
+		return host + "-" + suffix
+	}
+	return suffix
 }
 
 func splitCSV(s string) []string {
@@ -246,11 +1160,124 @@ func normalizeUpstreams(in map[string]UpstreamConfig) (map[string]UpstreamConfig
 		if _, exists := out[n]; exists {
 			return nil, fmt.Errorf("重复的 upstream 名称（大小写不敏感）: %q", n)
 		}
+		for i, pattern := range v.AllowedPaths {
+			if _, err := path.Match(pattern, ""); err != nil || !strings.HasPrefix(pattern, "/") {
+				return nil, fmt.Errorf("upstreams.%s.allowed_paths[%d]: invalid pattern %q (must start with /)", n, i, pattern)
+			}
+		}
+		checks, err := normalizeResponseChecks(n, v.ResponseChecks)
+		if err != nil {
+			return nil, err
+		}
+		v.ResponseChecks = checks
+		switch v.IPFamily = normalizeLower(v.IPFamily); v.IPFamily {
+		case "", IPFamilyAuto, IPFamilyV4, IPFamilyV6:
+		default:
+			return nil, fmt.Errorf("upstreams.%s.ip_family: invalid value %q (auto, ipv4, ipv6)", n, v.IPFamily)
+		}
+		switch v.Type = normalizeLower(v.Type); v.Type {
+		case "":
+		case UpstreamTypeDemo:
+			if v.Target == "" {
+				v.Target = DemoTarget
+			}
+		case UpstreamTypeAzureOpenAI:
+			if v.Target == "" {
+				return nil, fmt.Errorf("upstreams.%s.target: required for type %q", n, v.Type)
+			}
+			if v.Azure.APIVersion == "" {
+				v.Azure.APIVersion = DefaultAzureAPIVersion
+			}
+		case UpstreamTypeBedrock:
+			if v.Bedrock.Region == "" {
+				v.Bedrock.Region = BedrockRegion(v.Target)
+			}
+			if v.Bedrock.Region == "" {
+				return nil, fmt.Errorf("upstreams.%s.bedrock.region: required unless target is https://bedrock-runtime.<region>.amazonaws.com", n)
+			}
+			if v.Target == "" {
+				v.Target = "https://bedrock-runtime." + v.Bedrock.Region + ".amazonaws.com"
+			}
+		case UpstreamTypeVertexAI:
+			if v.Target == "" {
+				v.Target = VertexDefaultTarget
+			}
+		case UpstreamTypeOllama:
+			if v.Target == "" {
+				v.Target = OllamaDefaultTarget
+			}
+		case UpstreamTypeLMStudio:
+			if v.Target == "" {
+				v.Target = LMStudioDefaultTarget
+			}
+		default:
+			return nil, fmt.Errorf("upstreams.%s.type: invalid value %q (demo, azure-openai, bedrock, vertex-ai, ollama, lmstudio)", n, v.Type)
+		}
 		out[n] = v
 	}
 	return out, nil
 }
 
+// normalizeResponseChecks validates an upstream's response checks, defaulting
+// names to the check type.
+func normalizeResponseChecks(upstream string, in []ResponseCheckConfig) ([]ResponseCheckConfig, error) {
+	if len(in) == 0 {
+		return in, nil
+	}
+	checks := append([]ResponseCheckConfig(nil), in...)
+	seen := make(map[string]bool, len(checks))
+	for i := range checks {
+		c := &checks[i]
+		c.Type = normalizeLower(c.Type)
+		switch c.Type {
+		case CheckJSON, CheckFinishReason:
+		case CheckRequiredKeys:
+			if len(c.Keys) == 0 {
+				return nil, fmt.Errorf("upstreams.%s.response_checks[%d].keys: required for type %q", upstream, i, c.Type)
+			}
+		default:
+			return nil, fmt.Errorf("upstreams.%s.response_checks[%d].type: invalid value %q (json, required_keys, finish_reason)", upstream, i, c.Type)
+		}
+		if c.Name = strings.TrimSpace(c.Name); c.Name == "" {
+			c.Name = c.Type
+		}
+		if seen[c.Name] {
+			return nil, fmt.Errorf("upstreams.%s.response_checks[%d]: duplicate name %q", upstream, i, c.Name)
+		}
+		seen[c.Name] = true
+	}
+	return checks, nil
+}
+
+// AllowsPath reports whether AllowedPaths admits reqPath. The path is
+// cleaned first, so "/v1/chat/../admin" can't slip past a prefix.
+func (u UpstreamConfig) AllowsPath(reqPath string) bool {
+	if len(u.AllowedPaths) == 0 {
+		return true
+	}
+	cleaned := path.Clean("/" + reqPath)
+	for _, pattern := range u.AllowedPaths {
+		if matchPath(pattern, cleaned) {
+			return true
+		}
+	}
+	return false
+}
+
+// BedrockRegion extracts the region from a Bedrock endpoint such as
+// https://bedrock-runtime.us-east-1.amazonaws.com.
+func BedrockRegion(target string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return ""
+	}
+	parts := strings.Split(strings.ToLower(u.Hostname()), ".")
+	if len(parts) < 4 || !strings.HasPrefix(parts[0], "bedrock") || parts[len(parts)-2] != "amazonaws" {
+		return ""
+	}
+	return parts[1]
+}
+
 // Update applies an in-memory update under an exclusive lock.
 // Callers should call Save separately if persistence is required.
 func (c *Config) Update(fn func(*Config)) {
@@ -269,9 +1296,109 @@ func (c *Config) LoggingSnapshot() LoggingConfig {
 	if len(out.SensitiveHeaders) > 0 {
 		out.SensitiveHeaders = append([]string(nil), c.Logging.SensitiveHeaders...)
 	}
+	if len(out.RedactPatterns) > 0 {
+		out.RedactPatterns = append([]string(nil), c.Logging.RedactPatterns...)
+	}
+	if len(out.CaptureRules) > 0 {
+		out.CaptureRules = append([]CaptureRuleConfig(nil), c.Logging.CaptureRules...)
+	}
+	if len(out.Properties.Allow) > 0 {
+		out.Properties.Allow = append([]string(nil), c.Logging.Properties.Allow...)
+	}
+	return out
+}
+
+// DNSSnapshot returns a copy of the DNS cache config.
+func (c *Config) DNSSnapshot() DNSConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.DNS
+}
+
+// BandwidthSnapshot returns a copy of the global bandwidth limits.
+func (c *Config) BandwidthSnapshot() BandwidthConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Bandwidth
+}
+
+// BackupSnapshot returns a copy of the backup config.
+func (c *Config) BackupSnapshot() BackupConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	out := c.Backup
+	if c.Backup.S3 != nil {
+		s3 := *c.Backup.S3
+		out.S3 = &s3
+	}
+	return out
+}
+
+// SinksSnapshot returns a copy of the sinks config.
+func (c *Config) SinksSnapshot() SinksConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	out := c.Sinks
+	if c.Sinks.Remote != nil {
+		remote := *c.Sinks.Remote
+		out.Remote = &remote
+	}
+	if c.Sinks.Kafka != nil {
+		kafka := *c.Sinks.Kafka
+		kafka.Brokers = append([]string(nil), c.Sinks.Kafka.Brokers...)
+		out.Kafka = &kafka
+	}
+	if c.Sinks.ClickHouse != nil {
+		ch := *c.Sinks.ClickHouse
+		out.ClickHouse = &ch
+	}
+	if c.Sinks.Elastic != nil {
+		es := *c.Sinks.Elastic
+		out.Elastic = &es
+	}
+	if c.Sinks.OTLP != nil {
+		otlp := *c.Sinks.OTLP
+		otlp.Headers = make(map[string]string, len(c.Sinks.OTLP.Headers))
+		for k, v := range c.Sinks.OTLP.Headers {
+			otlp.Headers[k] = v
+		}
+		out.OTLP = &otlp
+	}
+	if c.Sinks.Langfuse != nil {
+		langfuse := *c.Sinks.Langfuse
+		out.Langfuse = &langfuse
+	}
+	if c.Sinks.LangSmith != nil {
+		langsmith := *c.Sinks.LangSmith
+		out.LangSmith = &langsmith
+	}
+	if c.Sinks.File != nil {
+		file := *c.Sinks.File
+		out.File = &file
+	}
+	if c.Sinks.Syslog != nil {
+		syslog := *c.Sinks.Syslog
+		out.Syslog = &syslog
+	}
 	return out
 }
 
+// RulesSnapshot returns a copy of the configured request rules.
+func (c *Config) RulesSnapshot() []RuleConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]RuleConfig(nil), c.Rules...)
+}
+
+// GuardrailsSnapshot returns a copy of the configured content guardrails.
+func (c *Config) GuardrailsSnapshot() []GuardrailConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]GuardrailConfig(nil), c.Guardrails...)
+}
+
 // StorageSnapshot returns a copy of the current storage config.
 func (c *Config) StorageSnapshot() StorageConfig {
 	c.mu.RLock()
@@ -279,6 +1406,20 @@ func (c *Config) StorageSnapshot() StorageConfig {
 	return c.Storage
 }
 
+// DiscoverySnapshot returns a copy of the current discovery config.
+func (c *Config) DiscoverySnapshot() DiscoveryConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Discovery
+}
+
+// ClusterSnapshot returns a copy of the current cluster config.
+func (c *Config) ClusterSnapshot() ClusterConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Cluster
+}
+
 // ServerSnapshot returns a copy of the current server config safe for use
 // without holding locks.
 func (c *Config) ServerSnapshot() ServerConfig {
@@ -301,6 +1442,9 @@ func (c *Config) ServerSnapshot() ServerConfig {
 	if len(out.CORSAllowHeaders) > 0 {
 		out.CORSAllowHeaders = append([]string(nil), c.Server.CORSAllowHeaders...)
 	}
+	if len(out.TrustedProxies) > 0 {
+		out.TrustedProxies = append([]string(nil), c.Server.TrustedProxies...)
+	}
 	return out
 }
 
@@ -309,20 +1453,32 @@ func Get() *Config {
 	return cfg
 }
 
+// Path 返回配置文件路径（未从文件加载时为空）
+func (c *Config) Path() string {
+	return c.configPath
+}
+
 // Save 保存配置文件
 func (c *Config) Save() error {
 	// Save writes the config file; it must be exclusive to avoid concurrent writes.
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	data, err := yaml.Marshal(c)
+	// 密钥引用原样写回；只改动变化的键，环境变量覆盖的值不会写入文件；
+	// YAML 保留注释和顺序，JSON/TOML 按原有键顺序重新编码。先写临时文件再重命名，避免写到一半留下残缺的配置
+	node, err := encodeNode(c)
+	if err != nil {
+		return fmt.Errorf("序列化配置失败: %w", err)
+	}
+	data, err := renderConfig(c.configPath, c.loaded, node)
 	if err != nil {
 		return fmt.Errorf("序列化配置失败: %w", err)
 	}
 
-	if err := os.WriteFile(c.configPath, data, 0644); err != nil {
+	if err := writeFileAtomic(c.configPath, data); err != nil {
 		return fmt.Errorf("写入配置文件失败: %w", err)
 	}
+	c.loaded = node
 	return nil
 }
 
@@ -339,11 +1495,51 @@ func (c *Config) AddUpstream(name string, config UpstreamConfig) error {
 	return nil // 实际上应该由调用者决定是否立即 Save
 }
 
-// RemoveUpstream 删除上游配置
+// SetUpstreamMaintenance 设置上游维护模式
+func (c *Config) SetUpstreamMaintenance(name string, m MaintenanceConfig) error {
+	return c.updateUpstream(name, func(up *UpstreamConfig) { up.Maintenance = m })
+}
+
+// SetUpstreamDisabled 停用或启用上游
+func (c *Config) SetUpstreamDisabled(name string, disabled bool) error {
+	return c.updateUpstream(name, func(up *UpstreamConfig) { up.Disabled = disabled })
+}
+
+// updateUpstream applies fn to a configured (not deleted) upstream.
+func (c *Config) updateUpstream(name string, fn func(*UpstreamConfig)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	name = normalizeLower(name)
+	up, ok := c.Upstreams[name]
+	if !ok || up.DeletedAt != "" {
+		return fmt.Errorf("unknown upstream: %s", name)
+	}
+	fn(&up)
+	c.Upstreams[name] = up
+	return nil
+}
+
+// RemoveUpstream 删除上游配置（软删除：保留配置并记录删除时间）
 func (c *Config) RemoveUpstream(name string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	name = normalizeLower(name)
+	if name == "" {
+		return fmt.Errorf("upstream name is empty")
+	}
+	if up, ok := c.Upstreams[name]; ok && up.DeletedAt == "" {
+		up.DeletedAt = time.Now().UTC().Format(time.RFC3339)
+		c.Upstreams[name] = up
+	}
+	return nil
+}
+
+// PurgeUpstream 彻底删除上游配置（包括已软删除的）
+func (c *Config) PurgeUpstream(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	name = normalizeLower(name)
 	if name == "" {
 		return fmt.Errorf("upstream name is empty")
@@ -352,6 +1548,21 @@ func (c *Config) RemoveUpstream(name string) error {
 	return nil
 }
 
+// RestoreUpstream 恢复已软删除的上游
+func (c *Config) RestoreUpstream(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	name = normalizeLower(name)
+	up, ok := c.Upstreams[name]
+	if !ok || up.DeletedAt == "" {
+		return fmt.Errorf("no deleted upstream: %s", name)
+	}
+	up.DeletedAt = ""
+	c.Upstreams[name] = up
+	return nil
+}
+
 // IsUIHost 判断是否为 UI 请求的 Host
 func (c *Config) IsUIHost(host string) bool {
 	c.mu.RLock()
@@ -381,13 +1592,23 @@ func (c *Config) IsUIHost(host string) bool {
 
 // GetUpstream 根据子域名获取上游配置
 func (c *Config) GetUpstream(subdomain string) (*UpstreamConfig, bool) {
+	up, ok := c.LookupUpstream(subdomain)
+	if !ok || up.DeletedAt != "" {
+		return nil, false
+	}
+	return up, true
+}
+
+// LookupUpstream is GetUpstream including deleted upstreams, for describing
+// historical logs.
+func (c *Config) LookupUpstream(name string) (*UpstreamConfig, bool) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	subdomain = normalizeLower(subdomain)
-	if subdomain == "" {
+	name = normalizeLower(name)
+	if name == "" {
 		return nil, false
 	}
-	up, ok := c.Upstreams[subdomain]
+	up, ok := c.Upstreams[name]
 	if !ok {
 		return nil, false
 	}
@@ -395,13 +1616,25 @@ func (c *Config) GetUpstream(subdomain string) (*UpstreamConfig, bool) {
 }
 
 // ListUpstreams returns a copy of upstream configs for safe iteration.
+// Deleted upstreams are left out.
 func (c *Config) ListUpstreams() map[string]UpstreamConfig {
+	return c.listUpstreams(false)
+}
+
+// ListDeletedUpstreams returns a copy of the deleted upstream configs.
+func (c *Config) ListDeletedUpstreams() map[string]UpstreamConfig {
+	return c.listUpstreams(true)
+}
+
+func (c *Config) listUpstreams(deleted bool) map[string]UpstreamConfig {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
 	out := make(map[string]UpstreamConfig, len(c.Upstreams))
 	for k, v := range c.Upstreams {
-		out[k] = v
+		if (v.DeletedAt != "") == deleted {
+			out[k] = v
+		}
 	}
 	return out
 }
@@ -0,0 +1,176 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	envPrefix = "PRISMCAT_"
+	// envSeparator separates the keys of a config path in a variable name:
+	// PRISMCAT_LOGGING__MAX_RESPONSE_BODY is logging.max_response_body.
+	envSeparator = "__"
+)
+
+// legacyEnv maps the variables that predate path-style names to their
+// config paths. Unlike path-style variables, an invalid value is ignored.
+var legacyEnv = []struct{ name, path string }{
+	{"PRISMCAT_ADDR", "server.addr"},
+	{"PRISMCAT_PORT", "server.port"},
+	{"PRISMCAT_UI_HOSTS", "server.ui_hosts"},
+	{"PRISMCAT_PROXY_DOMAINS", "server.proxy_domains"},
+	{"PRISMCAT_DB_PATH", "storage.database"},
+	{"PRISMCAT_BLOB_DIR", "storage.blob_dir"},
+	{"PRISMCAT_RETENTION_DAYS", "storage.retention_days"},
+	{"PRISMCAT_ASYNC_BUFFER", "storage.async_buffer"},
+	{"PRISMCAT_UI_PASSWORD", "server.ui_password"},
+}
+
+// applyEnv overrides config fields from environment variables (KEY=value
+// pairs, as from os.Environ), so a deployment can run without a config
+// file. A variable names a config path in upper case, keys separated by
+// "__":
+//
+//	PRISMCAT_LOGGING__MAX_RESPONSE_BODY=20971520
+//	PRISMCAT_UPSTREAMS__OPENAI__TARGET=https://api.openai.com
+//	PRISMCAT_RULES__0__WHEN='path.startsWith("/v1")'
+//
+// Map keys are lower-cased; list elements are addressed by index, and the
+// index one past the end appends. Strings are taken as is, string lists also
+// accept comma-separated values, and everything else is parsed as YAML, so
+// a whole section can be set at once (PRISMCAT_RULES='[{name: a, ...}]').
+// Empty values are ignored. A single-key variable that names no top-level
+// section is left alone, since other PRISMCAT_ variables exist; a path-style
+// one that matches no field is an error.
+func applyEnv(c *Config, environ []string) error {
+	env := make(map[string]string, len(environ))
+	var names []string
+	for _, kv := range environ {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(name, envPrefix) || value == "" {
+			continue
+		}
+		env[name] = value
+		names = append(names, name)
+	}
+
+	root := reflect.ValueOf(c).Elem()
+	for _, l := range legacyEnv {
+		if value, ok := env[l.name]; ok {
+			_ = setEnvPath(root, strings.Split(l.path, "."), value)
+		}
+	}
+
+	// Sorted, a section comes before the fields inside it.
+	sort.Strings(names)
+	for _, name := range names {
+		keys := strings.Split(strings.ToLower(strings.TrimPrefix(name, envPrefix)), envSeparator)
+		if len(keys) == 1 {
+			if _, ok := structField(root, keys[0]); !ok {
+				continue
+			}
+		}
+		if err := setEnvPath(root, keys, env[name]); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// setEnvPath sets the field at keys below v to the parsed value.
+func setEnvPath(v reflect.Value, keys []string, value string) error {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	if len(keys) == 0 {
+		return setEnvValue(v, value)
+	}
+	key := keys[0]
+
+	switch v.Kind() {
+	case reflect.Struct:
+		field, ok := structField(v, key)
+		if !ok {
+			return fmt.Errorf("unknown field %q", key)
+		}
+		return setEnvPath(field, keys[1:], value)
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("unsupported map key type %s", v.Type().Key())
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		// Map elements aren't addressable: update a copy and store it back.
+		k := reflect.ValueOf(key).Convert(v.Type().Key())
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if existing := v.MapIndex(k); existing.IsValid() {
+			elem.Set(existing)
+		}
+		if err := setEnvPath(elem, keys[1:], value); err != nil {
+			return err
+		}
+		v.SetMapIndex(k, elem)
+		return nil
+	case reflect.Slice:
+		i, err := strconv.Atoi(key)
+		if err != nil || i < 0 || i > v.Len() {
+			return fmt.Errorf("invalid list index %q (list has %d elements)", key, v.Len())
+		}
+		if i == v.Len() {
+			v.Set(reflect.Append(v, reflect.New(v.Type().Elem()).Elem()))
+		}
+		return setEnvPath(v.Index(i), keys[1:], value)
+	}
+	return fmt.Errorf("%q: %s has no fields", key, v.Type())
+}
+
+func setEnvValue(v reflect.Value, value string) error {
+	switch {
+	case v.Kind() == reflect.String:
+		v.SetString(value)
+		return nil
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(value), "["):
+		list := reflect.MakeSlice(v.Type(), 0, 0)
+		for _, s := range splitCSV(value) {
+			list = reflect.Append(list, reflect.ValueOf(s).Convert(v.Type().Elem()))
+		}
+		v.Set(list)
+		return nil
+	}
+	if err := yaml.Unmarshal([]byte(value), v.Addr().Interface()); err != nil {
+		return fmt.Errorf("invalid value: %w", err)
+	}
+	return nil
+}
+
+// structField returns the field of struct v whose YAML key is key, ignoring
+// case.
+func structField(v reflect.Value, key string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if strings.EqualFold(name, key) {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
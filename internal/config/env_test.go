@@ -0,0 +1,69 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestApplyEnv(t *testing.T) {
+	c := Config{
+		Server:    ServerConfig{Port: 8080},
+		Upstreams: map[string]UpstreamConfig{"openai": {Target: "https://api.openai.com", Timeout: 120}},
+	}
+	err := applyEnv(&c, []string{
+		"PRISMCAT_PORT=9000",
+		"PRISMCAT_SERVER__PORT=9100", // path-style wins over the legacy name
+		"PRISMCAT_UI_HOSTS=Panel.local, 127.0.0.1",
+		"PRISMCAT_LOGGING__MAX_RESPONSE_BODY=20971520",
+		"PRISMCAT_LOGGING__STORE_BASE64=true",
+		"PRISMCAT_UPSTREAMS__OPENAI__TIMEOUT=30",
+		"PRISMCAT_UPSTREAMS__LOCAL__TARGET=http://127.0.0.1:11434",
+		"PRISMCAT_UPSTREAMS__LOCAL__DEFAULT_HEADERS={X-Org: acme}",
+		"PRISMCAT_RULES__0__NAME=tag-all",
+		"PRISMCAT_RULES__0__WHEN=true",
+		"PRISMCAT_SINKS__SYSLOG__ADDRESS=siem:514",
+		"PRISMCAT_S3_ACCESS_KEY_ID=ignored",
+		"PRISMCAT_SERVER__ADDR=",
+		"OTHER__PORT=1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Server.Port != 9100 || strings.Join(c.Server.UIHosts, ",") != "Panel.local,127.0.0.1" {
+		t.Fatalf("server = %+v", c.Server)
+	}
+	if c.Logging.MaxResponseBody != 20971520 || !c.Logging.StoreBase64 {
+		t.Fatalf("logging = %+v", c.Logging)
+	}
+	// Fields not named keep their value.
+	if up := c.Upstreams["openai"]; up.Timeout != 30 || up.Target != "https://api.openai.com" {
+		t.Fatalf("openai = %+v", up)
+	}
+	if up := c.Upstreams["local"]; up.Target != "http://127.0.0.1:11434" || up.DefaultHeaders["X-Org"] != "acme" {
+		t.Fatalf("local = %+v", up)
+	}
+	if len(c.Rules) != 1 || c.Rules[0].Name != "tag-all" || c.Rules[0].When != "true" {
+		t.Fatalf("rules = %+v", c.Rules)
+	}
+	if c.Sinks.Syslog == nil || c.Sinks.Syslog.Address != "siem:514" {
+		t.Fatalf("sinks.syslog = %+v", c.Sinks.Syslog)
+	}
+}
+
+func TestApplyEnvErrors(t *testing.T) {
+	for _, kv := range []string{
+		"PRISMCAT_LOGGING__MAX_RESPONSE_BOD=1",
+		"PRISMCAT_SERVER__PORT=eighty",
+		"PRISMCAT_RULES__3__NAME=gap",
+		"PRISMCAT_SERVER__PORT__X=1",
+	} {
+		if err := applyEnv(&Config{}, []string{kv}); err == nil {
+			t.Errorf("%s: want error", kv)
+		}
+	}
+	// Legacy names keep ignoring values they can't parse.
+	c := Config{Server: ServerConfig{Port: 8080}}
+	if err := applyEnv(&c, []string{"PRISMCAT_PORT=eighty"}); err != nil || c.Server.Port != 8080 {
+		t.Fatalf("legacy: port %d, %v", c.Server.Port, err)
+	}
+}
@@ -0,0 +1,204 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config file formats, chosen by file extension.
+const (
+	FormatYAML = "yaml"
+	FormatJSON = "json"
+	FormatTOML = "toml"
+)
+
+// FormatOf returns the config format for path: JSON for .json, TOML for
+// .toml, YAML otherwise.
+func FormatOf(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return FormatJSON
+	case ".toml":
+		return FormatTOML
+	}
+	return FormatYAML
+}
+
+// decodeConfig unmarshals data in the given format into out (a Config, or
+// any other YAML target). JSON and TOML documents are decoded generically
+// and then through the YAML tags, so the keys are the same in every format.
+func decodeConfig(format string, data []byte, out interface{}) error {
+	switch format {
+	case FormatJSON:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		var tree interface{}
+		if err := dec.Decode(&tree); err != nil {
+			return err
+		}
+		if dec.More() {
+			return fmt.Errorf("unexpected data after the JSON document")
+		}
+		return decodeTree(jsonNumbers(tree), out)
+	case FormatTOML:
+		tree, err := parseTOML(data)
+		if err != nil {
+			return err
+		}
+		return decodeTree(tree, out)
+	}
+	return yaml.Unmarshal(data, out)
+}
+
+func decodeTree(tree interface{}, out interface{}) error {
+	data, err := yaml.Marshal(tree)
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(data, out)
+}
+
+// jsonNumbers turns json.Number values into int64 or float64, so integer
+// fields don't go through a float.
+func jsonNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for k, elem := range v {
+			v[k] = jsonNumbers(elem)
+		}
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = jsonNumbers(elem)
+		}
+	}
+	return v
+}
+
+// encodeConfig marshals v (a Config, or a YAML node) in the given format,
+// keeping the field order of the YAML form.
+func encodeConfig(format string, v interface{}) ([]byte, error) {
+	if format == FormatYAML {
+		return yaml.Marshal(v)
+	}
+	var node yaml.Node
+	if n, ok := v.(*yaml.Node); ok {
+		node = *n
+	} else if err := node.Encode(v); err != nil {
+		return nil, err
+	}
+	tree, err := orderedTree(&node)
+	if err != nil {
+		return nil, err
+	}
+	root, ok := tree.(orderedMap)
+	if !ok {
+		return nil, fmt.Errorf("config root is not a mapping")
+	}
+	if format == FormatTOML {
+		return encodeTOML(root), nil
+	}
+	data, err := json.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// FromYAML converts a YAML config (such as the example template) to the
+// format of path, for writing a new config file there. Comments are lost.
+func FromYAML(path string, data []byte) ([]byte, error) {
+	format := FormatOf(path)
+	if format == FormatYAML {
+		return data, nil
+	}
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return nil, err
+	}
+	if len(node.Content) == 0 {
+		return encodeConfig(format, &yaml.Node{Kind: yaml.MappingNode})
+	}
+	return encodeConfig(format, node.Content[0])
+}
+
+// orderedMap is a mapping that keeps its key order, for writing JSON and
+// TOML in the order of the YAML form.
+type orderedMap []mapItem
+
+type mapItem struct {
+	Key   string
+	Value interface{}
+}
+
+func (m orderedMap) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, item := range m {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, err := json.Marshal(item.Key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(item.Value)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// orderedTree converts a YAML node into orderedMap, []interface{} and
+// scalars.
+func orderedTree(n *yaml.Node) (interface{}, error) {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) == 0 {
+			return nil, nil
+		}
+		return orderedTree(n.Content[0])
+	case yaml.AliasNode:
+		return orderedTree(n.Alias)
+	case yaml.MappingNode:
+		m := make(orderedMap, 0, len(n.Content)/2)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			value, err := orderedTree(n.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			m = append(m, mapItem{Key: n.Content[i].Value, Value: value})
+		}
+		return m, nil
+	case yaml.SequenceNode:
+		list := make([]interface{}, 0, len(n.Content))
+		for _, elem := range n.Content {
+			value, err := orderedTree(elem)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		return list, nil
+	}
+	var v interface{}
+	if err := n.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
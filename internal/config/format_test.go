@@ -0,0 +1,131 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TestExampleConfigInEveryFormat converts the example config to JSON and
+// TOML and checks each loads to the same Config as the YAML original, also
+// after a Save round trip.
+func TestExampleConfigInEveryFormat(t *testing.T) {
+	example, err := os.ReadFile(filepath.Join("..", "..", "config.example.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	want := dump(t, loadAs(t, filepath.Join(dir, "config.yaml"), example))
+
+	for _, name := range []string{"config.json", "config.toml"} {
+		path := filepath.Join(dir, name)
+		data, err := FromYAML(path, example)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		c := loadAs(t, path, data)
+		if got := dump(t, c); got != want {
+			t.Fatalf("%s loads differently:\n%s\nwant\n%s", name, got, want)
+		}
+		if err := c.Save(); err != nil {
+			t.Fatal(err)
+		}
+		c, err = Load(path)
+		if err != nil {
+			t.Fatalf("%s after Save: %v", name, err)
+		}
+		if got := dump(t, c); got != want {
+			t.Fatalf("%s differs after Save:\n%s", name, got)
+		}
+	}
+}
+
+func TestLoadTOML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	c := loadAs(t, path, []byte(`
+# comment
+[server]
+port = 9_090
+ui_hosts = [
+  "localhost", # trailing comma allowed
+  'panel.internal',
+]
+max_request_bytes = 0x100
+
+[upstreams.openai]
+target = "https://api.openai.com"
+timeout = 60
+default_headers = { "X-Org" = "a\tbé" }
+
+[upstreams."local.llm"]
+target = '''
+http://127.0.0.1:11434'''
+default_headers.X-Since = 1979-05-27
+
+[[rules]]
+name = "block"
+when = """path.startsWith("/admin")"""
+block = true
+
+[[rules]]
+name = "tag"
+when = 'true'
+tag = "all"
+`))
+	if c.Server.Port != 9090 || c.Server.MaxRequestBytes != 256 || len(c.Server.UIHosts) != 2 || c.Server.UIHosts[1] != "panel.internal" {
+		t.Fatalf("server = %+v", c.Server)
+	}
+	up := c.Upstreams["openai"]
+	if up.Target != "https://api.openai.com" || up.Timeout != 60 || up.DefaultHeaders["X-Org"] != "a\tbé" {
+		t.Fatalf("openai = %+v", up)
+	}
+	if l := c.Upstreams["local.llm"]; l.Target != "http://127.0.0.1:11434" || l.DefaultHeaders["X-Since"] != "1979-05-27" {
+		t.Fatalf("local.llm = %+v", c.Upstreams["local.llm"])
+	}
+	if len(c.Rules) != 2 || c.Rules[0].When != `path.startsWith("/admin")` || !c.Rules[0].Block || c.Rules[1].Tag != "all" {
+		t.Fatalf("rules = %+v", c.Rules)
+	}
+}
+
+func TestLoadRejectsMalformedConfig(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"dup.toml":      "[server]\nport = 1\nport = 2\n",
+		"table.toml":    "[server]\n[server]\n",
+		"string.toml":   "[server]\naddr = \"unterminated\n",
+		"trailing.json": `{"server": {}} {}`,
+		"syntax.json":   `{"server": }`,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(path); err == nil {
+			t.Errorf("%s: want error", name)
+		}
+	}
+}
+
+func loadAs(t *testing.T, path string, data []byte) *Config {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load %s: %v", filepath.Base(path), err)
+	}
+	return c
+}
+
+func dump(t *testing.T, c *Config) string {
+	t.Helper()
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(data))
+}
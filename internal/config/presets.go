@@ -0,0 +1,58 @@
+package config
+
+import "strings"
+
+// UpstreamPreset describes a well-known provider, so an upstream for it can
+// be added by name.
+type UpstreamPreset struct {
+	Name        string `json:"name"` // also the default upstream name
+	DisplayName string `json:"display_name"`
+	Target      string `json:"target"`
+	// AuthHeader is the header the provider reads its API key from. A key
+	// given when adding the preset becomes a default header, so clients can
+	// call the upstream without credentials.
+	AuthHeader string `json:"auth_header"`
+}
+
+// upstreamPresets is the built-in catalog, in display order.
+var upstreamPresets = []UpstreamPreset{
+	{Name: "openai", DisplayName: "OpenAI", Target: "https://api.openai.com", AuthHeader: "Authorization"},
+	{Name: "anthropic", DisplayName: "Anthropic", Target: "https://api.anthropic.com", AuthHeader: "x-api-key"},
+	{Name: "gemini", DisplayName: "Google Gemini", Target: "https://generativelanguage.googleapis.com", AuthHeader: "x-goog-api-key"},
+	{Name: "deepseek", DisplayName: "DeepSeek", Target: "https://api.deepseek.com", AuthHeader: "Authorization"},
+	{Name: "openrouter", DisplayName: "OpenRouter", Target: "https://openrouter.ai/api", AuthHeader: "Authorization"},
+	{Name: "groq", DisplayName: "Groq", Target: "https://api.groq.com/openai", AuthHeader: "Authorization"},
+}
+
+// presetTimeout is the timeout of upstreams added from a preset, as in
+// config.example.yaml.
+const presetTimeout = 120
+
+// UpstreamPresets returns the built-in provider catalog.
+func UpstreamPresets() []UpstreamPreset {
+	return append([]UpstreamPreset(nil), upstreamPresets...)
+}
+
+// LookupUpstreamPreset returns the preset with the given name (case-insensitive).
+func LookupUpstreamPreset(name string) (UpstreamPreset, bool) {
+	name = normalizeLower(name)
+	for _, p := range upstreamPresets {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return UpstreamPreset{}, false
+}
+
+// Upstream returns the upstream config for the preset. apiKey, if set, is
+// sent in AuthHeader ("Bearer " is added for Authorization unless present).
+func (p UpstreamPreset) Upstream(apiKey string) UpstreamConfig {
+	up := UpstreamConfig{Target: p.Target, Timeout: presetTimeout}
+	if apiKey = strings.TrimSpace(apiKey); apiKey != "" {
+		if strings.EqualFold(p.AuthHeader, "Authorization") && !strings.HasPrefix(strings.ToLower(apiKey), "bearer ") {
+			apiKey = "Bearer " + apiKey
+		}
+		up.DefaultHeaders = map[string]string{p.AuthHeader: apiKey}
+	}
+	return up
+}
@@ -0,0 +1,162 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// RouteConfig is one entry of the routing table. Routes are evaluated in
+// order before the Host / X-PrismCat-Upstream selection, and the first one
+// whose Match applies decides where the request goes. Requests no route
+// matches are routed as before.
+type RouteConfig struct {
+	Name  string     `yaml:"name,omitempty" json:"name,omitempty"`
+	Match RouteMatch `yaml:"match" json:"match"`
+
+	// Upstream and Target are shorthand for a single destination.
+	Upstream string `yaml:"upstream,omitempty" json:"upstream,omitempty"`
+	Target   string `yaml:"target,omitempty" json:"target,omitempty"`
+	// Destinations splits matching requests by weight (A/B tests).
+	Destinations []RouteDestination `yaml:"destinations,omitempty" json:"destinations,omitempty"`
+}
+
+// RouteMatch holds the conditions of a route; all set conditions must hold,
+// and an empty match applies to every request. Patterns use path.Match
+// syntax, except that Path also accepts a trailing "*" prefix as in capture
+// rules ("/v1/audio/*").
+type RouteMatch struct {
+	// Host matches the request host without port, e.g. "*.corp.example".
+	Host string `yaml:"host,omitempty" json:"host,omitempty"`
+	Path string `yaml:"path,omitempty" json:"path,omitempty"`
+	// Headers maps header names to value patterns ("*": present).
+	Headers map[string]string `yaml:"headers,omitempty" json:"headers,omitempty"`
+	// Model matches the model named in the JSON body (or a Gemini / Bedrock
+	// style URL path), e.g. "gpt-4o*".
+	Model string `yaml:"model,omitempty" json:"model,omitempty"`
+}
+
+// RouteDestination is where a route sends requests.
+type RouteDestination struct {
+	// Upstream selects the upstream whose settings (headers, type, limits)
+	// apply; empty keeps the one selected by the Host.
+	Upstream string `yaml:"upstream,omitempty" json:"upstream,omitempty"`
+	// Target replaces the upstream's target URL.
+	Target string `yaml:"target,omitempty" json:"target,omitempty"`
+	// Weight is the destination's relative share (default 1).
+	Weight int `yaml:"weight,omitempty" json:"weight,omitempty"`
+	// Variant is recorded in the log's variant field, to compare A/B arms.
+	Variant string `yaml:"variant,omitempty" json:"variant,omitempty"`
+}
+
+// RouteDestinations returns the route's destinations, the shorthand fields
+// included.
+func (r RouteConfig) RouteDestinations() []RouteDestination {
+	if r.Upstream == "" && r.Target == "" {
+		return r.Destinations
+	}
+	return append([]RouteDestination{{Upstream: r.Upstream, Target: r.Target}}, r.Destinations...)
+}
+
+// Matches reports whether the route applies to a request. model is only
+// consulted when the route matches on it.
+func (m RouteMatch) Matches(host, reqPath string, header func(string) []string, model func() string) bool {
+	if m.Host != "" {
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if ok, _ := path.Match(strings.ToLower(m.Host), strings.ToLower(host)); !ok {
+			return false
+		}
+	}
+	if m.Path != "" && !matchPath(m.Path, reqPath) {
+		return false
+	}
+	for name, pattern := range m.Headers {
+		values := header(name)
+		if len(values) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern, values[0]); !ok && pattern != "*" {
+			return false
+		}
+	}
+	if m.Model != "" {
+		if ok, _ := path.Match(m.Model, model()); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeRoutes validates the routing table and lower-cases upstream names.
+func normalizeRoutes(routes []RouteConfig) ([]RouteConfig, error) {
+	out := make([]RouteConfig, 0, len(routes))
+	for i, r := range routes {
+		name := r.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+		}
+		fail := func(format string, args ...interface{}) error {
+			return fmt.Errorf("routes[%s]: %s", name, fmt.Sprintf(format, args...))
+		}
+
+		for field, pattern := range map[string]string{"host": r.Match.Host, "path": r.Match.Path, "model": r.Match.Model} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fail("invalid match.%s %q", field, pattern)
+			}
+		}
+		for header, pattern := range r.Match.Headers {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fail("invalid match.headers.%s %q", header, pattern)
+			}
+		}
+
+		r.Upstream = normalizeLower(r.Upstream)
+		r.Destinations = append([]RouteDestination(nil), r.Destinations...)
+		for j := range r.Destinations {
+			r.Destinations[j].Upstream = normalizeLower(r.Destinations[j].Upstream)
+		}
+		dests := r.RouteDestinations()
+		if len(dests) == 0 {
+			return nil, fail("no destination (upstream, target or destinations)")
+		}
+		for j, d := range dests {
+			if d.Upstream == "" && d.Target == "" {
+				return nil, fail("destination %d: upstream or target is required", j)
+			}
+			if d.Weight < 0 {
+				return nil, fail("destination %d: negative weight", j)
+			}
+			if d.Target != "" {
+				if u, err := url.Parse(d.Target); err != nil || u.Scheme == "" || u.Host == "" {
+					return nil, fail("destination %d: invalid target %q", j, d.Target)
+				}
+			}
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// RoutesSnapshot returns a copy of the routing table.
+func (c *Config) RoutesSnapshot() []RouteConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]RouteConfig(nil), c.Routes...)
+}
+
+// SetRoutes validates and replaces the routing table.
+// Callers should call Save separately if persistence is required.
+func (c *Config) SetRoutes(routes []RouteConfig) error {
+	routes, err := normalizeRoutes(routes)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Routes = routes
+	return nil
+}
@@ -0,0 +1,233 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+// yamlIndent is the indentation Save writes YAML with, as in
+// config.example.yaml.
+const yamlIndent = 2
+
+// encodeNode returns the YAML form of c, with secret references in place of
+// the values they resolved to.
+func encodeNode(c *Config) (*yaml.Node, error) {
+	var node yaml.Node
+	if err := node.Encode(c); err != nil {
+		return nil, err
+	}
+	restoreSecretRefs(&node, c.secretRefs)
+	return &node, nil
+}
+
+// renderConfig returns the new contents of the config file at path for
+// next, the current YAML form of the config. base is the form it had when
+// loaded (or last saved).
+//
+// The file is patched rather than rewritten: only what changed between
+// base and next is applied to the document on disk, so values that came
+// from environment overrides (secrets included) stay as written, and a
+// YAML file keeps its comments and key order. A JSON or TOML file is then
+// encoded again, in its key order. A file that is missing or no longer
+// parses is written in full.
+func renderConfig(path string, base, next *yaml.Node) ([]byte, error) {
+	format := FormatOf(path)
+	data, err := os.ReadFile(path)
+	if format != FormatYAML {
+		if root, ok := fileMapping(format, data); err == nil && ok {
+			patchNode(root, base, next)
+			return encodeConfig(format, root)
+		}
+		return encodeConfig(format, next)
+	}
+	var doc yaml.Node
+	if err == nil && yaml.Unmarshal(data, &doc) == nil &&
+		doc.Kind == yaml.DocumentNode && len(doc.Content) == 1 && doc.Content[0].Kind == yaml.MappingNode {
+		patchNode(doc.Content[0], base, next)
+		return marshalYAML(&doc)
+	}
+	return marshalYAML(next)
+}
+
+// fileMapping returns the top-level mapping of a JSON or TOML config file.
+func fileMapping(format string, data []byte) (*yaml.Node, bool) {
+	if format == FormatTOML {
+		root, err := tomlDocument(data)
+		return root, err == nil && root.Kind == yaml.MappingNode
+	}
+	// JSON is YAML too; parsing it as such keeps the key order.
+	var doc yaml.Node
+	if yaml.Unmarshal(data, &doc) != nil || doc.Kind != yaml.DocumentNode ||
+		len(doc.Content) != 1 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, false
+	}
+	return doc.Content[0], true
+}
+
+func marshalYAML(node *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(yamlIndent)
+	if err := enc.Encode(node); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// patchNode applies to doc, a node of the file's document, the changes from
+// base to next. base may be nil when the value didn't exist at load time;
+// then doc is made to match next.
+func patchNode(doc, base, next *yaml.Node) {
+	if base != nil && nodesEqual(base, next) {
+		return
+	}
+	if doc.Kind != next.Kind || doc.Kind == yaml.AliasNode {
+		replaceNode(doc, next)
+		return
+	}
+	switch next.Kind {
+	case yaml.MappingNode:
+		if len(doc.Content) == 0 {
+			doc.Style = next.Style
+		}
+		for i := 0; i+1 < len(next.Content); i += 2 {
+			key, value := next.Content[i], next.Content[i+1]
+			var baseValue *yaml.Node
+			if base != nil && base.Kind == yaml.MappingNode {
+				if k := mappingIndex(base, key.Value); k >= 0 {
+					baseValue = base.Content[k+1]
+				}
+			}
+			j := mappingIndex(doc, key.Value)
+			switch {
+			case j >= 0:
+				patchNode(doc.Content[j+1], baseValue, value)
+			case baseValue == nil || !nodesEqual(baseValue, value):
+				// Defaults and environment overrides the file never had stay out.
+				doc.Content = append(doc.Content, key, value)
+			}
+		}
+		// A key present at load time and gone now was deleted (a map entry)
+		// or reset to its zero value (an omitempty field).
+		if base != nil && base.Kind == yaml.MappingNode {
+			for i := 0; i+1 < len(base.Content); i += 2 {
+				key := base.Content[i].Value
+				if mappingIndex(next, key) >= 0 {
+					continue
+				}
+				if j := mappingIndex(doc, key); j >= 0 {
+					doc.Content = append(doc.Content[:j], doc.Content[j+2:]...)
+				}
+			}
+		}
+	case yaml.SequenceNode:
+		if len(doc.Content) == 0 {
+			doc.Style = next.Style
+		}
+		for i, elem := range next.Content {
+			if i >= len(doc.Content) {
+				doc.Content = append(doc.Content, elem)
+				continue
+			}
+			var baseElem *yaml.Node
+			if base != nil && base.Kind == yaml.SequenceNode && i < len(base.Content) {
+				baseElem = base.Content[i]
+			}
+			patchNode(doc.Content[i], baseElem, elem)
+		}
+		if len(doc.Content) > len(next.Content) {
+			doc.Content = doc.Content[:len(next.Content)]
+		}
+	default:
+		if !nodesEqual(doc, next) {
+			doc.Value, doc.Tag, doc.Style = next.Value, next.Tag, next.Style
+		}
+	}
+}
+
+// replaceNode overwrites doc with next, keeping doc's comments.
+func replaceNode(doc, next *yaml.Node) {
+	head, line, foot := doc.HeadComment, doc.LineComment, doc.FootComment
+	*doc = *next
+	if doc.HeadComment == "" {
+		doc.HeadComment = head
+	}
+	if doc.LineComment == "" {
+		doc.LineComment = line
+	}
+	if doc.FootComment == "" {
+		doc.FootComment = foot
+	}
+}
+
+// mappingIndex returns the index of key's key node in mapping n, or -1. A
+// key that only differs in case or surrounding space matches too, since
+// upstream names are normalized on load.
+func mappingIndex(n *yaml.Node, key string) int {
+	fallback := -1
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		switch k := n.Content[i].Value; {
+		case k == key:
+			return i
+		case fallback < 0 && normalizeLower(k) == normalizeLower(key):
+			fallback = i
+		}
+	}
+	return fallback
+}
+
+// nodesEqual reports whether a and b decode to the same value, so that
+// 0x100 and 256, or quoted and plain strings, count as unchanged.
+func nodesEqual(a, b *yaml.Node) bool {
+	var va, vb interface{}
+	if a.Decode(&va) != nil || b.Decode(&vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+// writeFileAtomic replaces path with data through a temporary file in the
+// same directory, so a crash mid-write can't leave a truncated config. A
+// symlinked path (e.g. a mounted ConfigMap) is followed, and the file keeps
+// its permissions.
+func writeFileAtomic(path string, data []byte) error {
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+	mode := fs.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after the rename
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSavePatchesChangedKeys(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	t.Setenv("PRISMCAT_SERVER__PORT", "9999")
+	original := `# PrismCat 配置
+server:
+  port: 8080 # 监听端口
+  ui_hosts: [localhost]
+
+storage:
+  database: ` + filepath.Join(dir, "prismcat.db") + `
+  retention_days: 7 # 保留天数
+
+# 上游
+upstreams:
+  openai:
+    target: https://api.openai.com
+  local:
+    target: http://127.0.0.1:11434
+
+future_option: keep me
+`
+	c := loadAs(t, path, []byte(original))
+	if c.Server.Port != 9999 {
+		t.Fatalf("port = %d", c.Server.Port)
+	}
+
+	c.Storage.RetentionDays = 30
+	delete(c.Upstreams, "local")
+	c.Upstreams["gemini"] = UpstreamConfig{Target: "https://generativelanguage.googleapis.com"}
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := string(data)
+	for _, want := range []string{
+		"# PrismCat 配置",
+		"port: 8080 # 监听端口", // the environment override isn't written back
+		"ui_hosts: [localhost]",
+		"retention_days: 30 # 保留天数",
+		"# 上游",
+		"future_option: keep me",
+		"gemini:",
+	} {
+		if !strings.Contains(saved, want) {
+			t.Errorf("saved config lacks %q:\n%s", want, saved)
+		}
+	}
+	for _, unwanted := range []string{"11434", "max_request_body", "async_buffer"} {
+		if strings.Contains(saved, unwanted) {
+			t.Errorf("saved config has %q:\n%s", unwanted, saved)
+		}
+	}
+
+	// A second save patches against what the first one wrote.
+	c.Upstreams["openai"] = UpstreamConfig{Target: "https://proxy.example.com"}
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+	os.Unsetenv("PRISMCAT_SERVER__PORT")
+	c, err = Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Server.Port != 8080 || c.Storage.RetentionDays != 30 || len(c.Upstreams) != 2 ||
+		c.Upstreams["openai"].Target != "https://proxy.example.com" {
+		t.Fatalf("reloaded: port %d, retention %d, upstreams %+v", c.Server.Port, c.Storage.RetentionDays, c.Upstreams)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if strings.Contains(e.Name(), ".tmp-") {
+			t.Errorf("temporary file left behind: %s", e.Name())
+		}
+	}
+}
+
+func TestSaveKeepsEnvOverridesOutOfJSONAndTOML(t *testing.T) {
+	files := map[string]string{
+		"config.json": `{
+  "storage": {"database": "DB", "retention_days": 7},
+  "server": {"port": 8080},
+  "upstreams": {"openai": {"target": "https://api.openai.com"}}
+}
+`,
+		"config.toml": `[storage]
+database = "DB"
+retention_days = 7
+
+[server]
+port = 8080
+
+[upstreams.openai]
+target = "https://api.openai.com"
+`,
+	}
+	for name, original := range files {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, name)
+			t.Setenv("PRISMCAT_SERVER__PORT", "9999")
+			t.Setenv("PRISMCAT_UI_PASSWORD", "s3cret-from-env")
+			c := loadAs(t, path, []byte(strings.Replace(original, "DB", filepath.Join(dir, "prismcat.db"), 1)))
+			if c.Server.Port != 9999 || c.Server.UIPassword != "s3cret-from-env" {
+				t.Fatalf("overrides not applied: port %d", c.Server.Port)
+			}
+
+			c.Storage.RetentionDays = 30
+			c.Upstreams["gemini"] = UpstreamConfig{Target: "https://generativelanguage.googleapis.com"}
+			if err := c.Save(); err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			saved := string(data)
+			for _, unwanted := range []string{"9999", "s3cret-from-env", "ui_password", "max_request_body"} {
+				if strings.Contains(saved, unwanted) {
+					t.Errorf("saved config has %q:\n%s", unwanted, saved)
+				}
+			}
+			// The file's own key order is kept.
+			if i, j := strings.Index(saved, "retention_days"), strings.Index(saved, "port"); i < 0 || j < 0 || i > j {
+				t.Errorf("key order lost:\n%s", saved)
+			}
+
+			os.Unsetenv("PRISMCAT_SERVER__PORT")
+			os.Unsetenv("PRISMCAT_UI_PASSWORD")
+			c, err = Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Server.Port != 8080 || c.Storage.RetentionDays != 30 || len(c.Upstreams) != 2 {
+				t.Fatalf("reloaded: port %d, retention %d, upstreams %+v", c.Server.Port, c.Storage.RetentionDays, c.Upstreams)
+			}
+		})
+	}
+}
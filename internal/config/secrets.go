@@ -0,0 +1,182 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// secretRefPattern matches a secret reference inside a string value:
+// ${env:NAME} reads an environment variable, ${file:/path} a file (trailing
+// newlines dropped, as in Docker and Kubernetes secrets).
+var secretRefPattern = regexp.MustCompile(`\$\{(env|file):([^}]+)\}`)
+
+// HasSecretRef reports whether s contains a secret reference, and so is safe
+// to show or export as is.
+func HasSecretRef(s string) bool {
+	return secretRefPattern.MatchString(s)
+}
+
+// secretRef records a string field that held references, so Save can write
+// the references back instead of the secrets.
+type secretRef struct {
+	path     []string // YAML keys and list indices
+	template string   // the value as written, with references
+	resolved string
+}
+
+// resolveSecrets replaces the references in every string field of c and
+// records them in c.secretRefs.
+func resolveSecrets(c *Config) error {
+	refs, err := resolveSecretsIn(reflect.ValueOf(c).Elem(), nil)
+	if err != nil {
+		return err
+	}
+	c.secretRefs = refs
+	return nil
+}
+
+// resolveSecretsIn replaces the references in the strings reachable from v,
+// found at path in the config, and returns them.
+func resolveSecretsIn(v reflect.Value, path []string) ([]secretRef, error) {
+	var refs []secretRef
+	err := walkStrings(v, path, func(path []string, s string) (string, error) {
+		if !strings.Contains(s, "${") {
+			return s, nil
+		}
+		var firstErr error
+		resolved := secretRefPattern.ReplaceAllStringFunc(s, func(ref string) string {
+			m := secretRefPattern.FindStringSubmatch(ref)
+			v, err := readSecret(m[1], strings.TrimSpace(m[2]))
+			if err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("%s: %w", strings.Join(path, "."), err)
+			}
+			return v
+		})
+		if firstErr != nil {
+			return "", firstErr
+		}
+		if resolved != s {
+			refs = append(refs, secretRef{path: append([]string{}, path...), template: s, resolved: resolved})
+		}
+		return resolved, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+func readSecret(kind, name string) (string, error) {
+	if kind == "env" {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return v, nil
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return "", fmt.Errorf("read secret file: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// walkStrings calls fn for every string reachable from v through structs,
+// pointers, lists and maps, and stores what it returns. path holds the YAML
+// keys leading to v.
+func walkStrings(v reflect.Value, path []string, fn func(path []string, s string) (string, error)) error {
+	switch v.Kind() {
+	case reflect.String:
+		s, err := fn(path, v.String())
+		if err != nil {
+			return err
+		}
+		v.SetString(s)
+	case reflect.Ptr:
+		if !v.IsNil() {
+			return walkStrings(v.Elem(), path, fn)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+			if !f.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = strings.ToLower(f.Name)
+			}
+			if err := walkStrings(v.Field(i), append(path, name), fn); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := walkStrings(v.Index(i), append(path, strconv.Itoa(i)), fn); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			// Map elements aren't addressable: walk a copy and store it back.
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(iter.Value())
+			if err := walkStrings(elem, append(path, iter.Key().String()), fn); err != nil {
+				return err
+			}
+			v.SetMapIndex(iter.Key(), elem)
+		}
+	}
+	return nil
+}
+
+// restoreSecretRefs puts the recorded references back into node, the YAML
+// form of the config, where a field still holds the resolved value. A field
+// changed since loading is saved as it is now.
+func restoreSecretRefs(node *yaml.Node, refs []secretRef) {
+	for _, ref := range refs {
+		if n := lookupNode(node, ref.path); n != nil && n.Kind == yaml.ScalarNode && n.Value == ref.resolved {
+			n.Value = ref.template
+			n.Style = 0
+		}
+	}
+}
+
+func lookupNode(n *yaml.Node, path []string) *yaml.Node {
+	for _, key := range path {
+		switch n.Kind {
+		case yaml.MappingNode:
+			var next *yaml.Node
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				if n.Content[i].Value == key {
+					next = n.Content[i+1]
+					break
+				}
+			}
+			if next == nil {
+				return nil
+			}
+			n = next
+		case yaml.SequenceNode:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(n.Content) {
+				return nil
+			}
+			n = n.Content[i]
+		default:
+			return nil
+		}
+	}
+	return n
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSecretRefs(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "openai_key")
+	if err := os.WriteFile(keyFile, []byte("sk-from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_UI_PASS", "hunter2")
+	path := filepath.Join(dir, "config.yaml")
+	yamlData := `
+server:
+  ui_password: ${env:TEST_UI_PASS}
+storage:
+  database: ` + filepath.Join(dir, "prismcat.db") + `
+upstreams:
+  OpenAI:
+    target: https://api.openai.com
+    default_headers:
+      Authorization: Bearer ${file:` + keyFile + `}
+`
+	c := loadAs(t, path, []byte(yamlData))
+	if c.Server.UIPassword != "hunter2" {
+		t.Fatalf("ui_password = %q", c.Server.UIPassword)
+	}
+	if got := c.Upstreams["openai"].DefaultHeaders["Authorization"]; got != "Bearer sk-from-file" {
+		t.Fatalf("Authorization = %q", got)
+	}
+
+	// Saving writes the references, never the secrets.
+	c.Storage.RetentionDays = 9
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+	saved, _ := os.ReadFile(path)
+	for _, secret := range []string{"hunter2", "sk-from-file"} {
+		if strings.Contains(string(saved), secret) {
+			t.Fatalf("saved config leaks %q:\n%s", secret, saved)
+		}
+	}
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Server.UIPassword != "hunter2" || c.Storage.RetentionDays != 9 {
+		t.Fatalf("reloaded: ui_password %q, retention %d", c.Server.UIPassword, c.Storage.RetentionDays)
+	}
+
+	// A value replaced through the API is saved as given.
+	c.Server.UIPassword = "changed"
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := Load(path); c.Server.UIPassword != "changed" {
+		t.Fatalf("ui_password = %q, want changed", c.Server.UIPassword)
+	}
+
+	if err := os.WriteFile(path, []byte("server:\n  ui_password: ${env:TEST_UNSET_SECRET}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "server.ui_password") {
+		t.Fatalf("unset variable: %v", err)
+	}
+}
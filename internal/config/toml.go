@@ -0,0 +1,281 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// TOML documents are decoded by github.com/BurntSushi/toml into a generic
+// tree, then go through the YAML tags (see decodeTree), so TOML keys match
+// YAML keys. Date-times are kept as strings, since no config field is a
+// timestamp.
+//
+// Writing uses the small encoder below instead: it takes an ordered tree,
+// so a saved file keeps its own key order (see tomlDocument), or the field
+// order of the YAML form, which the library's map encoding (sorted keys)
+// would lose.
+
+// parseTOML decodes a TOML document into nested map[string]interface{}.
+func parseTOML(data []byte) (map[string]interface{}, error) {
+	root := map[string]interface{}{}
+	if _, err := toml.Decode(string(data), &root); err != nil {
+		return nil, fmt.Errorf("toml: %w", err)
+	}
+	return tomlStrings(root).(map[string]interface{}), nil
+}
+
+// tomlDocument decodes a TOML document into a YAML mapping node whose keys
+// are in the order the document lists them, for patching on save.
+func tomlDocument(data []byte) (*yaml.Node, error) {
+	root := map[string]interface{}{}
+	md, err := toml.Decode(string(data), &root)
+	if err != nil {
+		return nil, fmt.Errorf("toml: %w", err)
+	}
+	var node yaml.Node
+	if err := node.Encode(tomlStrings(root)); err != nil {
+		return nil, err
+	}
+	order := map[string]int{}
+	for i, key := range md.Keys() {
+		if _, ok := order[key.String()]; !ok {
+			order[key.String()] = i
+		}
+	}
+	sortTOMLKeys(&node, "", order)
+	return &node, nil
+}
+
+// sortTOMLKeys orders the keys of the mappings under n by their position in
+// the document. Keys of table arrays share their array's prefix.
+func sortTOMLKeys(n *yaml.Node, prefix string, order map[string]int) {
+	switch n.Kind {
+	case yaml.SequenceNode:
+		for _, elem := range n.Content {
+			sortTOMLKeys(elem, prefix, order)
+		}
+	case yaml.MappingNode:
+		type pair struct {
+			key, value *yaml.Node
+			pos        int
+		}
+		pairs := make([]pair, 0, len(n.Content)/2)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			path := (toml.Key{n.Content[i].Value}).String()
+			if prefix != "" {
+				path = prefix + "." + path
+			}
+			pos, ok := order[path]
+			if !ok {
+				pos = math.MaxInt
+			}
+			sortTOMLKeys(n.Content[i+1], path, order)
+			pairs = append(pairs, pair{n.Content[i], n.Content[i+1], pos})
+		}
+		sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].pos < pairs[j].pos })
+		n.Content = n.Content[:0]
+		for _, p := range pairs {
+			n.Content = append(n.Content, p.key, p.value)
+		}
+	}
+}
+
+// tomlStrings replaces date-time values in a decoded tree with their TOML
+// text.
+func tomlStrings(v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Time:
+		// Local date-times are decoded in zones the library names after
+		// their kind.
+		switch v.Location().String() {
+		case "date-local":
+			return v.Format("2006-01-02")
+		case "time-local":
+			return v.Format("15:04:05.999999999")
+		case "datetime-local":
+			return v.Format("2006-01-02T15:04:05.999999999")
+		}
+		return v.Format(time.RFC3339Nano)
+	case map[string]interface{}:
+		for k, elem := range v {
+			v[k] = tomlStrings(elem)
+		}
+	case []map[string]interface{}:
+		for _, elem := range v {
+			tomlStrings(elem)
+		}
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = tomlStrings(elem)
+		}
+	}
+	return v
+}
+
+// encodeTOML renders an ordered tree (see orderedTree) as TOML. Null values
+// are left out, since TOML has none.
+func encodeTOML(root orderedMap) []byte {
+	var b strings.Builder
+	writeTOMLTable(&b, nil, root)
+	return []byte(strings.TrimPrefix(b.String(), "\n"))
+}
+
+func writeTOMLTable(b *strings.Builder, path []string, table orderedMap) {
+	// Plain values come first: after a header, every key belongs to it.
+	for _, item := range table {
+		if item.Value == nil || isTOMLSection(item.Value) {
+			continue
+		}
+		b.WriteString(tomlKey(item.Key))
+		b.WriteString(" = ")
+		writeTOMLValue(b, item.Value)
+		b.WriteByte('\n')
+	}
+	for _, item := range table {
+		sub := append(append([]string{}, path...), tomlKey(item.Key))
+		switch v := item.Value.(type) {
+		case orderedMap:
+			b.WriteString("\n[" + strings.Join(sub, ".") + "]\n")
+			writeTOMLTable(b, sub, v)
+		case []interface{}:
+			if !isTOMLSection(v) {
+				continue
+			}
+			for _, elem := range v {
+				b.WriteString("\n[[" + strings.Join(sub, ".") + "]]\n")
+				writeTOMLTable(b, sub, elem.(orderedMap))
+			}
+		}
+	}
+}
+
+// isTOMLSection reports whether v is written under its own header: a table,
+// or a non-empty array holding only tables.
+func isTOMLSection(v interface{}) bool {
+	switch v := v.(type) {
+	case orderedMap:
+		return true
+	case []interface{}:
+		if len(v) == 0 {
+			return false
+		}
+		for _, elem := range v {
+			if _, ok := elem.(orderedMap); !ok {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func writeTOMLValue(b *strings.Builder, v interface{}) {
+	switch v := v.(type) {
+	case string:
+		b.WriteString(tomlString(v))
+	case bool:
+		b.WriteString(strconv.FormatBool(v))
+	case int:
+		b.WriteString(strconv.Itoa(v))
+	case int64:
+		b.WriteString(strconv.FormatInt(v, 10))
+	case uint64:
+		b.WriteString(strconv.FormatUint(v, 10))
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			b.WriteString("nan")
+		case math.IsInf(v, 1):
+			b.WriteString("inf")
+		case math.IsInf(v, -1):
+			b.WriteString("-inf")
+		default:
+			s := strconv.FormatFloat(v, 'g', -1, 64)
+			if !strings.ContainsAny(s, ".e") {
+				s += ".0"
+			}
+			b.WriteString(s)
+		}
+	case []interface{}:
+		b.WriteByte('[')
+		first := true
+		for _, elem := range v {
+			if elem == nil {
+				continue
+			}
+			if !first {
+				b.WriteString(", ")
+			}
+			first = false
+			writeTOMLValue(b, elem)
+		}
+		b.WriteByte(']')
+	case orderedMap:
+		b.WriteByte('{')
+		first := true
+		for _, item := range v {
+			if item.Value == nil {
+				continue
+			}
+			if !first {
+				b.WriteString(", ")
+			}
+			first = false
+			b.WriteString(tomlKey(item.Key) + " = ")
+			writeTOMLValue(b, item.Value)
+		}
+		b.WriteByte('}')
+	default:
+		b.WriteString(tomlString(fmt.Sprint(v)))
+	}
+}
+
+func tomlKey(k string) string {
+	if k == "" {
+		return `""`
+	}
+	for i := 0; i < len(k); i++ {
+		if !isBareKeyChar(k[i]) {
+			return tomlString(k)
+		}
+	}
+	return k
+}
+
+func tomlString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// upstreamsDocument is the form upstreams are exported in: the upstreams
+// section of a config file, so an export can also be pasted into one.
+type upstreamsDocument struct {
+	Upstreams map[string]UpstreamConfig `yaml:"upstreams"`
+}
+
+// MarshalUpstreams encodes upstreams as a config document in format
+// (FormatYAML or FormatJSON).
+func MarshalUpstreams(format string, upstreams map[string]UpstreamConfig) ([]byte, error) {
+	var node yaml.Node
+	if err := node.Encode(upstreamsDocument{Upstreams: upstreams}); err != nil {
+		return nil, err
+	}
+	if format == FormatYAML {
+		return marshalYAML(&node)
+	}
+	return encodeConfig(format, &node)
+}
+
+// ParseUpstreams decodes upstreams from a YAML or JSON document: either a
+// config document with an upstreams section, such as MarshalUpstreams
+// writes, or a bare mapping of names to upstreams.
+func ParseUpstreams(data []byte) (map[string]UpstreamConfig, error) {
+	format := FormatYAML
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		format = FormatJSON // JSON indented with tabs isn't valid YAML
+	}
+	var root yaml.Node
+	if err := decodeConfig(format, data, &root); err != nil {
+		return nil, err
+	}
+	if root.Kind == yaml.DocumentNode && len(root.Content) == 1 {
+		root = *root.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("expected a mapping of upstreams")
+	}
+	section := &root
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "upstreams" && root.Content[i+1].Kind == yaml.MappingNode {
+			section = root.Content[i+1]
+			break
+		}
+	}
+	var upstreams map[string]UpstreamConfig
+	if err := section.Decode(&upstreams); err != nil {
+		return nil, err
+	}
+	if len(upstreams) == 0 {
+		return nil, fmt.Errorf("no upstreams")
+	}
+	return upstreams, nil
+}
+
+// ExportUpstreams returns a copy of the upstreams, deleted ones left out,
+// with secret references (${env:...}, ${file:...}) in place of the values
+// they resolved to, as they are written in the config file.
+func (c *Config) ExportUpstreams() (map[string]UpstreamConfig, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	live := make(map[string]UpstreamConfig, len(c.Upstreams))
+	for name, up := range c.Upstreams {
+		if up.DeletedAt == "" {
+			live[name] = up
+		}
+	}
+	var node yaml.Node
+	if err := node.Encode(live); err != nil {
+		return nil, err
+	}
+	var refs []secretRef
+	for _, ref := range c.secretRefs {
+		if len(ref.path) > 1 && ref.path[0] == "upstreams" {
+			ref.path = ref.path[1:]
+			refs = append(refs, ref)
+		}
+	}
+	restoreSecretRefs(&node, refs)
+	out := make(map[string]UpstreamConfig, len(live))
+	if err := node.Decode(&out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ImportUpstreams validates upstreams and adds them, replacing upstreams of
+// the same name. With replace, the upstreams not imported are deleted, as by
+// RemoveUpstream. Secret references in the imported values are resolved and
+// kept for Save.
+// Callers should call Save separately if persistence is required.
+func (c *Config) ImportUpstreams(upstreams map[string]UpstreamConfig, replace bool) error {
+	upstreams, err := normalizeUpstreams(upstreams)
+	if err != nil {
+		return err
+	}
+	if len(upstreams) == 0 {
+		return fmt.Errorf("no upstreams")
+	}
+	refs, err := resolveSecretsIn(reflect.ValueOf(&upstreams).Elem(), []string{"upstreams"})
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// References recorded for the replaced upstreams no longer apply.
+	kept := c.secretRefs[:0]
+	for _, ref := range c.secretRefs {
+		if len(ref.path) > 1 && ref.path[0] == "upstreams" {
+			if _, imported := upstreams[ref.path[1]]; imported {
+				continue
+			}
+		}
+		kept = append(kept, ref)
+	}
+	c.secretRefs = append(kept, refs...)
+
+	if c.Upstreams == nil {
+		c.Upstreams = make(map[string]UpstreamConfig, len(upstreams))
+	}
+	if replace {
+		deletedAt := time.Now().UTC().Format(time.RFC3339)
+		for name, up := range c.Upstreams {
+			if _, imported := upstreams[name]; !imported && up.DeletedAt == "" {
+				up.DeletedAt = deletedAt
+				c.Upstreams[name] = up
+			}
+		}
+	}
+	for name, up := range upstreams {
+		c.Upstreams[name] = up
+	}
+	return nil
+}
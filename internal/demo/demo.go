@@ -0,0 +1,377 @@
+// Package demo emulates an OpenAI-compatible LLM server in process, so the
+// dashboard can be explored without an API key. Upstreams with type "demo"
+// are answered by Transport instead of the network.
+package demo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultModel is reported when the request names no model.
+const DefaultModel = "prismcat-demo"
+
+// maxTokens caps generated replies whatever max_tokens asks for.
+const maxTokens = 512
+
+// Transport answers requests like an OpenAI-compatible server. Streaming
+// replies are paced by FirstTokenDelay and TokenDelay (jittered) so they
+// look like a real generation in the dashboard.
+type Transport struct {
+	FirstTokenDelay time.Duration
+	TokenDelay      time.Duration
+}
+
+// NewTransport returns a Transport with realistic pacing.
+func NewTransport() *Transport {
+	return &Transport{FirstTokenDelay: 300 * time.Millisecond, TokenDelay: 35 * time.Millisecond}
+}
+
+// Client returns an http.Client backed by a realistic Transport.
+func Client() *http.Client {
+	return &http.Client{Transport: NewTransport()}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	path := strings.TrimSuffix(req.URL.Path, "/")
+	switch {
+	case strings.HasSuffix(path, "/models") && req.Method == http.MethodGet:
+		return jsonResponse(req, http.StatusOK, modelList())
+	case strings.HasSuffix(path, "/chat/completions") && req.Method == http.MethodPost:
+		return t.chatCompletion(req, body)
+	case strings.HasSuffix(path, "/embeddings") && req.Method == http.MethodPost:
+		return embeddings(req, body)
+	}
+	return errorResponse(req, http.StatusNotFound, "invalid_request_error",
+		fmt.Sprintf("The demo upstream does not serve %s %s. Try POST /v1/chat/completions.", req.Method, req.URL.Path))
+}
+
+type chatRequest struct {
+	Model               string `json:"model"`
+	Stream              bool   `json:"stream"`
+	MaxTokens           int    `json:"max_tokens"`
+	MaxCompletionTokens int    `json:"max_completion_tokens"`
+	StreamOptions       *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options"`
+	Messages []struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	} `json:"messages"`
+}
+
+type usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+func (t *Transport) chatCompletion(req *http.Request, body []byte) (*http.Response, error) {
+	var in chatRequest
+	if err := json.Unmarshal(body, &in); err != nil {
+		return errorResponse(req, http.StatusBadRequest, "invalid_request_error", "Request body is not valid JSON: "+err.Error())
+	}
+	if len(in.Messages) == 0 {
+		return errorResponse(req, http.StatusBadRequest, "invalid_request_error", "'messages' must contain at least one message.")
+	}
+	model := in.Model
+	if model == "" {
+		model = DefaultModel
+	}
+
+	prompt := 0
+	for _, m := range in.Messages {
+		prompt += 4 + estimateTokens(string(m.Content))
+	}
+	limit := in.MaxCompletionTokens
+	if limit <= 0 {
+		limit = in.MaxTokens
+	}
+	n := 20 + rand.IntN(60)
+	finish := "stop"
+	if limit > 0 && n > limit {
+		n, finish = limit, "length"
+	}
+	if n > maxTokens {
+		n = maxTokens
+	}
+	tokens := loremTokens(n)
+	u := usage{PromptTokens: prompt, CompletionTokens: len(tokens), TotalTokens: prompt + len(tokens)}
+	id := "chatcmpl-" + strings.ReplaceAll(uuid.NewString(), "-", "")[:24]
+	created := time.Now().Unix()
+
+	if !in.Stream {
+		return jsonResponse(req, http.StatusOK, map[string]interface{}{
+			"id":                 id,
+			"object":             "chat.completion",
+			"created":            created,
+			"model":              model,
+			"system_fingerprint": "fp_demo",
+			"choices": []interface{}{map[string]interface{}{
+				"index":         0,
+				"message":       map[string]interface{}{"role": "assistant", "content": strings.Join(tokens, "")},
+				"logprobs":      nil,
+				"finish_reason": finish,
+			}},
+			"usage": u,
+		})
+	}
+
+	includeUsage := in.StreamOptions != nil && in.StreamOptions.IncludeUsage
+	pr, pw := io.Pipe()
+	go t.stream(req.Context(), pw, id, model, created, tokens, finish, u, includeUsage)
+	resp := newResponse(req, http.StatusOK, pr, -1)
+	resp.Header.Set("Content-Type", "text/event-stream; charset=utf-8")
+	resp.Header.Set("Cache-Control", "no-cache")
+	return resp, nil
+}
+
+// stream writes the SSE chunks of a chat completion, pacing tokens. Usage
+// goes in a separate final chunk when requested (as OpenAI does), otherwise
+// on the finishing chunk so the dashboard still shows token counts.
+func (t *Transport) stream(ctx context.Context, w *io.PipeWriter, id, model string, created int64, tokens []string, finish string, u usage, includeUsage bool) {
+	chunk := func(delta map[string]interface{}, finishReason interface{}, extra map[string]interface{}) error {
+		c := map[string]interface{}{
+			"id":                 id,
+			"object":             "chat.completion.chunk",
+			"created":            created,
+			"model":              model,
+			"system_fingerprint": "fp_demo",
+			"choices": []interface{}{map[string]interface{}{
+				"index":         0,
+				"delta":         delta,
+				"logprobs":      nil,
+				"finish_reason": finishReason,
+			}},
+		}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return writeEvent(w, c)
+	}
+
+	err := func() error {
+		if err := sleep(ctx, jitter(t.FirstTokenDelay)); err != nil {
+			return err
+		}
+		if err := chunk(map[string]interface{}{"role": "assistant", "content": ""}, nil, nil); err != nil {
+			return err
+		}
+		for i, tok := range tokens {
+			if i > 0 {
+				if err := sleep(ctx, jitter(t.TokenDelay)); err != nil {
+					return err
+				}
+			}
+			if err := chunk(map[string]interface{}{"content": tok}, nil, nil); err != nil {
+				return err
+			}
+		}
+		if includeUsage {
+			if err := chunk(map[string]interface{}{}, finish, nil); err != nil {
+				return err
+			}
+			if err := writeEvent(w, map[string]interface{}{
+				"id": id, "object": "chat.completion.chunk", "created": created, "model": model,
+				"system_fingerprint": "fp_demo", "choices": []interface{}{}, "usage": u,
+			}); err != nil {
+				return err
+			}
+		} else if err := chunk(map[string]interface{}{}, finish, map[string]interface{}{"usage": u}); err != nil {
+			return err
+		}
+		_, err := io.WriteString(w, "data: [DONE]\n\n")
+		return err
+	}()
+	_ = w.CloseWithError(err)
+}
+
+func writeEvent(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+	return err
+}
+
+func embeddings(req *http.Request, body []byte) (*http.Response, error) {
+	var in struct {
+		Model      string          `json:"model"`
+		Input      json.RawMessage `json:"input"`
+		Dimensions int             `json:"dimensions"`
+	}
+	if err := json.Unmarshal(body, &in); err != nil {
+		return errorResponse(req, http.StatusBadRequest, "invalid_request_error", "Request body is not valid JSON: "+err.Error())
+	}
+	var inputs []string
+	var single string
+	if err := json.Unmarshal(in.Input, &single); err == nil {
+		inputs = []string{single}
+	} else if err := json.Unmarshal(in.Input, &inputs); err != nil || len(inputs) == 0 {
+		return errorResponse(req, http.StatusBadRequest, "invalid_request_error", "'input' must be a string or an array of strings.")
+	}
+	dims := in.Dimensions
+	if dims <= 0 || dims > 3072 {
+		dims = 256
+	}
+	model := in.Model
+	if model == "" {
+		model = DefaultModel + "-embedding"
+	}
+
+	data := make([]interface{}, 0, len(inputs))
+	prompt := 0
+	for i, s := range inputs {
+		prompt += estimateTokens(s)
+		data = append(data, map[string]interface{}{"object": "embedding", "index": i, "embedding": embed(s, dims)})
+	}
+	return jsonResponse(req, http.StatusOK, map[string]interface{}{
+		"object": "list",
+		"data":   data,
+		"model":  model,
+		"usage":  map[string]int{"prompt_tokens": prompt, "total_tokens": prompt},
+	})
+}
+
+// embed returns a unit vector seeded by s, so equal inputs embed equally.
+func embed(s string, dims int) []float64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	r := rand.New(rand.NewPCG(h.Sum64(), uint64(dims)))
+	v := make([]float64, dims)
+	var norm float64
+	for i := range v {
+		v[i] = r.NormFloat64()
+		norm += v[i] * v[i]
+	}
+	norm = math.Sqrt(norm)
+	for i := range v {
+		v[i] = math.Round(v[i]/norm*1e6) / 1e6
+	}
+	return v
+}
+
+func modelList() map[string]interface{} {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+	models := []interface{}{}
+	for _, id := range []string{DefaultModel, DefaultModel + "-mini", DefaultModel + "-embedding"} {
+		models = append(models, map[string]interface{}{"id": id, "object": "model", "created": created, "owned_by": "prismcat"})
+	}
+	return map[string]interface{}{"object": "list", "data": models}
+}
+
+func errorResponse(req *http.Request, status int, typ, message string) (*http.Response, error) {
+	return jsonResponse(req, status, map[string]interface{}{
+		"error": map[string]interface{}{"message": message, "type": typ, "param": nil, "code": nil},
+	})
+}
+
+func jsonResponse(req *http.Request, status int, v interface{}) (*http.Response, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	resp := newResponse(req, status, io.NopCloser(bytes.NewReader(data)), int64(len(data)))
+	resp.Header.Set("Content-Type", "application/json")
+	resp.Header.Set("Content-Length", strconv.Itoa(len(data)))
+	return resp, nil
+}
+
+func newResponse(req *http.Request, status int, body io.ReadCloser, length int64) *http.Response {
+	h := make(http.Header)
+	h.Set("X-Request-Id", "req_"+strings.ReplaceAll(uuid.NewString(), "-", ""))
+	h.Set("Openai-Processing-Ms", strconv.Itoa(20+rand.IntN(200)))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        h,
+		Body:          body,
+		ContentLength: length,
+		Request:       req,
+	}
+}
+
+var lorem = strings.Fields(`lorem ipsum dolor sit amet consectetur adipiscing elit sed do
+eiusmod tempor incididunt ut labore et dolore magna aliqua ut enim ad minim veniam quis
+nostrud exercitation ullamco laboris nisi ut aliquip ex ea commodo consequat duis aute irure
+dolor in reprehenderit in voluptate velit esse cillum dolore eu fugiat nulla pariatur
+excepteur sint occaecat cupidatat non proident sunt in culpa qui officia deserunt mollit
+anim id est laborum`)
+
+// loremTokens returns n word tokens forming sentences, each with its
+// leading space like a BPE tokenizer would emit.
+func loremTokens(n int) []string {
+	out := make([]string, 0, n)
+	sentence := 0
+	for i := 0; i < n; i++ {
+		w := lorem[rand.IntN(len(lorem))]
+		if sentence == 0 {
+			w = strings.ToUpper(w[:1]) + w[1:]
+		}
+		sentence++
+		if i == n-1 || (sentence > 5 && rand.IntN(8) == 0) {
+			w += "."
+			sentence = 0
+		} else if sentence > 2 && rand.IntN(12) == 0 {
+			w += ","
+		}
+		if i > 0 {
+			w = " " + w
+		}
+		out = append(out, w)
+	}
+	return out
+}
+
+// estimateTokens approximates a token count at four characters per token.
+func estimateTokens(s string) int {
+	return (len(s) + 3) / 4
+}
+
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
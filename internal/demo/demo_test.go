@@ -0,0 +1,111 @@
+package demo
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func do(t *testing.T, method, url, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := (&Transport{}).RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestChatCompletion(t *testing.T) {
+	resp := do(t, http.MethodPost, "http://demo.invalid/v1/chat/completions",
+		`{"model":"gpt-test","max_tokens":5,"messages":[{"role":"user","content":"hello there"}]}`)
+	defer resp.Body.Close()
+	var out struct {
+		Model   string `json:"model"`
+		Choices []struct {
+			Message      struct{ Content string } `json:"message"`
+			FinishReason string                   `json:"finish_reason"`
+		} `json:"choices"`
+		Usage usage `json:"usage"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 || out.Model != "gpt-test" || len(out.Choices) != 1 {
+		t.Fatalf("status %d, body %+v", resp.StatusCode, out)
+	}
+	if out.Choices[0].FinishReason != "length" || out.Usage.CompletionTokens != 5 || out.Usage.PromptTokens == 0 {
+		t.Fatalf("unexpected completion: %+v", out)
+	}
+}
+
+func TestChatCompletionStream(t *testing.T) {
+	resp := do(t, http.MethodPost, "http://demo.invalid/v1/chat/completions",
+		`{"stream":true,"stream_options":{"include_usage":true},"messages":[{"role":"user","content":"hi"}]}`)
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	var text strings.Builder
+	var events int
+	var done, sawUsage bool
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		data, ok := strings.CutPrefix(sc.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if data == "[DONE]" {
+			done = true
+			continue
+		}
+		events++
+		var c struct {
+			Choices []struct {
+				Delta struct{ Content string } `json:"delta"`
+			} `json:"choices"`
+			Usage *usage `json:"usage"`
+		}
+		if err := json.Unmarshal([]byte(data), &c); err != nil {
+			t.Fatalf("bad chunk %q: %v", data, err)
+		}
+		for _, ch := range c.Choices {
+			text.WriteString(ch.Delta.Content)
+		}
+		if c.Usage != nil {
+			sawUsage = len(c.Choices) == 0 && c.Usage.CompletionTokens > 0
+		}
+	}
+	if !done || !sawUsage || events < 3 || !strings.HasSuffix(text.String(), ".") {
+		t.Fatalf("done=%v usage=%v events=%d text=%q", done, sawUsage, events, text.String())
+	}
+}
+
+func TestEmbeddingsAndErrors(t *testing.T) {
+	resp := do(t, http.MethodPost, "http://demo.invalid/v1/embeddings", `{"input":["a","a"],"dimensions":8}`)
+	var out struct {
+		Data []struct {
+			Embedding []float64 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if len(out.Data) != 2 || len(out.Data[0].Embedding) != 8 || out.Data[0].Embedding[0] != out.Data[1].Embedding[0] {
+		t.Fatalf("unexpected embeddings: %+v", out)
+	}
+
+	resp = do(t, http.MethodGet, "http://demo.invalid/v1/unknown", "")
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound || !strings.Contains(string(body), `"invalid_request_error"`) {
+		t.Fatalf("status %d, body %s", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,188 @@
+// Package dnscache caches host lookups in process, including failures, so
+// upstream dials don't pay for a slow or flaky resolver on every connection.
+package dnscache
+
+import (
+	"context"
+	"net"
+	"net/http/httptrace"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache maps host names to resolved addresses. The TTLs are passed per
+// lookup so config changes apply without rebuilding the cache.
+type Cache struct {
+	lookup func(ctx context.Context, host string) ([]string, error)
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	stats   counters
+}
+
+type entry struct {
+	addrs   []string
+	err     error
+	expires time.Time
+}
+
+type counters struct {
+	hits         int64
+	negativeHits int64
+	misses       int64
+	errors       int64
+}
+
+// Stats is a point-in-time view of the cache.
+type Stats struct {
+	Hits         int64       `json:"hits"`
+	NegativeHits int64       `json:"negative_hits"`
+	Misses       int64       `json:"misses"`
+	Errors       int64       `json:"errors"`
+	Entries      []EntryInfo `json:"entries"`
+}
+
+// EntryInfo describes one live cache entry.
+type EntryInfo struct {
+	Host    string    `json:"host"`
+	Addrs   []string  `json:"addrs,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Expires time.Time `json:"expires"`
+}
+
+// New returns a cache backed by net.DefaultResolver.
+func New() *Cache {
+	return &Cache{
+		lookup:  net.DefaultResolver.LookupHost,
+		now:     time.Now,
+		entries: make(map[string]*entry),
+	}
+}
+
+// LookupHost resolves host, serving from the cache while the entry is
+// fresh. Successful results live for ttl, failures for negativeTTL (not
+// cached when <= 0). Cancelled lookups are never cached.
+func (c *Cache) LookupHost(ctx context.Context, host string, ttl, negativeTTL time.Duration) ([]string, error) {
+	c.mu.Lock()
+	if e, ok := c.entries[host]; ok && c.now().Before(e.expires) {
+		if e.err != nil {
+			c.stats.negativeHits++
+		} else {
+			c.stats.hits++
+		}
+		c.mu.Unlock()
+		return e.addrs, e.err
+	}
+	c.stats.misses++
+	c.mu.Unlock()
+
+	addrs, err := c.lookup(ctx, host)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	c.pruneLocked(now)
+	switch {
+	case err == nil && ttl > 0:
+		c.entries[host] = &entry{addrs: addrs, expires: now.Add(ttl)}
+	case err != nil:
+		c.stats.errors++
+		if negativeTTL > 0 && ctx.Err() == nil {
+			c.entries[host] = &entry{err: err, expires: now.Add(negativeTTL)}
+		}
+	}
+	return addrs, err
+}
+
+// Stats returns the counters and live entries, sorted by host.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pruneLocked(c.now())
+	out := Stats{
+		Hits:         c.stats.hits,
+		NegativeHits: c.stats.negativeHits,
+		Misses:       c.stats.misses,
+		Errors:       c.stats.errors,
+		Entries:      make([]EntryInfo, 0, len(c.entries)),
+	}
+	for host, e := range c.entries {
+		info := EntryInfo{Host: host, Addrs: append([]string(nil), e.addrs...), Expires: e.expires}
+		if e.err != nil {
+			info.Error = e.err.Error()
+		}
+		out.Entries = append(out.Entries, info)
+	}
+	sort.Slice(out.Entries, func(i, j int) bool { return out.Entries[i].Host < out.Entries[j].Host })
+	return out
+}
+
+// pruneLocked drops expired entries. Upstream hosts are few, so a full
+// scan per miss is cheap.
+func (c *Cache) pruneLocked(now time.Time) {
+	for host, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, host)
+		}
+	}
+}
+
+// DialContext returns a dial function that resolves host names through the
+// cache before dialing with dial. IP literals and a non-positive ttl go to
+// dial unchanged. Resolved addresses are tried in order until one connects,
+// skipping those of the wrong family for "tcp4"/"tcp6".
+func (c *Cache) DialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error), ttls func() (ttl, negativeTTL time.Duration)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		ttl, negativeTTL := ttls()
+		host, port, err := net.SplitHostPort(addr)
+		if ttl <= 0 || err != nil || net.ParseIP(host) != nil {
+			return dial(ctx, network, addr)
+		}
+		// Report the lookup to httptrace like net.Dialer would; a cache
+		// hit shows up as a near-zero resolution.
+		trace := httptrace.ContextClientTrace(ctx)
+		if trace != nil && trace.DNSStart != nil {
+			trace.DNSStart(httptrace.DNSStartInfo{Host: host})
+		}
+		addrs, err := c.LookupHost(ctx, host, ttl, negativeTTL)
+		if trace != nil && trace.DNSDone != nil {
+			trace.DNSDone(httptrace.DNSDoneInfo{Err: err})
+		}
+		if err != nil {
+			return nil, err
+		}
+		var firstErr error
+		for _, ip := range addrs {
+			if !familyMatches(network, ip) {
+				continue
+			}
+			conn, err := dial(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil {
+				return conn, nil
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		if firstErr == nil {
+			firstErr = &net.DNSError{Err: "no suitable address found", Name: host, IsNotFound: true}
+		}
+		return nil, firstErr
+	}
+}
+
+func familyMatches(network, ip string) bool {
+	switch network {
+	case "tcp4":
+		return !strings.Contains(ip, ":")
+	case "tcp6":
+		return strings.Contains(ip, ":")
+	}
+	return true
+}
@@ -0,0 +1,100 @@
+package dnscache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLookupHostCachesResultsAndFailures(t *testing.T) {
+	now := time.Unix(1000, 0)
+	calls := map[string]int{}
+	c := New()
+	c.now = func() time.Time { return now }
+	c.lookup = func(_ context.Context, host string) ([]string, error) {
+		calls[host]++
+		if host == "bad.test" {
+			return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		}
+		return []string{"192.0.2.1"}, nil
+	}
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if addrs, err := c.LookupHost(ctx, "good.test", time.Minute, 5*time.Second); err != nil || addrs[0] != "192.0.2.1" {
+			t.Fatalf("addrs = %v, err = %v", addrs, err)
+		}
+		if _, err := c.LookupHost(ctx, "bad.test", time.Minute, 5*time.Second); err == nil {
+			t.Fatal("expected lookup error")
+		}
+	}
+	if calls["good.test"] != 1 || calls["bad.test"] != 1 {
+		t.Fatalf("calls = %v", calls)
+	}
+
+	// The negative entry expires first.
+	now = now.Add(10 * time.Second)
+	c.LookupHost(ctx, "good.test", time.Minute, 5*time.Second)
+	c.LookupHost(ctx, "bad.test", time.Minute, 5*time.Second)
+	if calls["good.test"] != 1 || calls["bad.test"] != 2 {
+		t.Fatalf("calls = %v", calls)
+	}
+
+	s := c.Stats()
+	if s.Hits != 3 || s.NegativeHits != 2 || s.Misses != 3 || s.Errors != 2 || len(s.Entries) != 2 {
+		t.Fatalf("stats = %+v", s)
+	}
+	if s.Entries[0].Host != "bad.test" || s.Entries[0].Error == "" {
+		t.Fatalf("entries = %+v", s.Entries)
+	}
+}
+
+func TestLookupHostSkipsCancelledFailures(t *testing.T) {
+	c := New()
+	c.lookup = func(ctx context.Context, host string) ([]string, error) { return nil, ctx.Err() }
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.LookupHost(ctx, "slow.test", time.Minute, time.Minute); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v", err)
+	}
+	if n := len(c.Stats().Entries); n != 0 {
+		t.Fatalf("entries = %d, want 0", n)
+	}
+}
+
+func TestDialContextUsesCache(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	lookups := 0
+	c := New()
+	c.lookup = func(context.Context, string) ([]string, error) {
+		lookups++
+		return []string{"192.0.2.1", "127.0.0.1"}, nil
+	}
+	var dialed []string
+	dial := c.DialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		if addr == net.JoinHostPort("192.0.2.1", port) {
+			return nil, errors.New("unreachable")
+		}
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	}, func() (time.Duration, time.Duration) { return time.Minute, 0 })
+
+	for i := 0; i < 2; i++ {
+		conn, err := dial(context.Background(), "tcp", net.JoinHostPort("upstream.test", port))
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	}
+	if lookups != 1 || len(dialed) != 4 {
+		t.Fatalf("lookups = %d, dialed = %v", lookups, dialed)
+	}
+}
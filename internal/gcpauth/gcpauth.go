@@ -0,0 +1,250 @@
+// Package gcpauth obtains Google OAuth2 access tokens for Vertex AI
+// upstreams from a service account key, Application Default Credentials or
+// the GCE metadata server, and refreshes them before they expire.
+package gcpauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scope is requested for every token; Vertex AI needs cloud-platform.
+const Scope = "https://www.googleapis.com/auth/cloud-platform"
+
+const (
+	defaultTokenURI     = "https://oauth2.googleapis.com/token"
+	defaultMetadataHost = "metadata.google.internal"
+	// refreshBefore renews tokens ahead of expiry.
+	refreshBefore = 5 * time.Minute
+)
+
+// ErrNoCredentials is returned when Application Default Credentials are
+// not available.
+var ErrNoCredentials = errors.New("gcp: no credentials (set credentials_file, GOOGLE_APPLICATION_CREDENTIALS, run \"gcloud auth application-default login\", or run on GCP)")
+
+// Resolver hands out access tokens, caching one per credentials source.
+type Resolver struct {
+	client *http.Client
+	now    func() time.Time
+	getenv func(string) string
+
+	mu     sync.Mutex
+	tokens map[string]token // by credentials file ("" for ADC)
+}
+
+type token struct {
+	value   string
+	expires time.Time
+}
+
+// NewResolver returns a resolver that fetches tokens with its own client.
+func NewResolver() *Resolver {
+	return &Resolver{
+		client: &http.Client{Timeout: 30 * time.Second},
+		now:    time.Now,
+		getenv: os.Getenv,
+		tokens: make(map[string]token),
+	}
+}
+
+// Token returns a valid access token for the service account or authorized
+// user in credentialsFile, or for Application Default Credentials when
+// credentialsFile is empty: GOOGLE_APPLICATION_CREDENTIALS, then the gcloud
+// ADC file, then the GCE metadata server.
+func (r *Resolver) Token(ctx context.Context, credentialsFile string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if t, ok := r.tokens[credentialsFile]; ok && r.now().Add(refreshBefore).Before(t.expires) {
+		return t.value, nil
+	}
+
+	t, err := r.fetch(ctx, credentialsFile)
+	if err != nil {
+		return "", err
+	}
+	r.tokens[credentialsFile] = t
+	return t.value, nil
+}
+
+func (r *Resolver) fetch(ctx context.Context, credentialsFile string) (token, error) {
+	if credentialsFile != "" {
+		return r.fromFile(ctx, credentialsFile)
+	}
+	if f := r.getenv("GOOGLE_APPLICATION_CREDENTIALS"); f != "" {
+		return r.fromFile(ctx, f)
+	}
+	if f := r.gcloudADCFile(); f != "" {
+		if _, err := os.Stat(f); err == nil {
+			return r.fromFile(ctx, f)
+		}
+	}
+	t, err := r.fromMetadata(ctx)
+	if err != nil {
+		return token{}, fmt.Errorf("%w: metadata server: %v", ErrNoCredentials, err)
+	}
+	return t, nil
+}
+
+// gcloudADCFile is where "gcloud auth application-default login" writes.
+func (r *Resolver) gcloudADCFile() string {
+	if dir := r.getenv("CLOUDSDK_CONFIG"); dir != "" {
+		return filepath.Join(dir, "application_default_credentials.json")
+	}
+	if dir := r.getenv("APPDATA"); dir != "" {
+		return filepath.Join(dir, "gcloud", "application_default_credentials.json")
+	}
+	if home := r.getenv("HOME"); home != "" {
+		return filepath.Join(home, ".config", "gcloud", "application_default_credentials.json")
+	}
+	return ""
+}
+
+// credentialsFile is the JSON key format shared by service accounts and
+// gcloud authorized users.
+type credentialsFile struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (r *Resolver) fromFile(ctx context.Context, path string) (token, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return token{}, fmt.Errorf("gcp: read credentials: %w", err)
+	}
+	var f credentialsFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return token{}, fmt.Errorf("gcp: parse %s: %v", path, err)
+	}
+	tokenURI := f.TokenURI
+	if tokenURI == "" {
+		tokenURI = defaultTokenURI
+	}
+
+	var form url.Values
+	switch f.Type {
+	case "service_account":
+		assertion, err := r.assertion(f, tokenURI)
+		if err != nil {
+			return token{}, err
+		}
+		form = url.Values{
+			"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+			"assertion":  {assertion},
+		}
+	case "authorized_user":
+		form = url.Values{
+			"grant_type":    {"refresh_token"},
+			"client_id":     {f.ClientID},
+			"client_secret": {f.ClientSecret},
+			"refresh_token": {f.RefreshToken},
+		}
+	default:
+		return token{}, fmt.Errorf("gcp: %s: unsupported credentials type %q", path, f.Type)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return token{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r.exchange(req)
+}
+
+// assertion builds the signed JWT a service account trades for a token.
+func (r *Resolver) assertion(f credentialsFile, audience string) (string, error) {
+	block, _ := pem.Decode([]byte(f.PrivateKey))
+	if block == nil {
+		return "", errors.New("gcp: service account private_key is not PEM")
+	}
+	var key *rsa.PrivateKey
+	if k, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		rk, ok := k.(*rsa.PrivateKey)
+		if !ok {
+			return "", errors.New("gcp: service account private_key is not RSA")
+		}
+		key = rk
+	} else if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+		return "", fmt.Errorf("gcp: parse private_key: %v", err)
+	}
+
+	now := r.now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": f.PrivateKeyID})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   f.ClientEmail,
+		"scope": Scope,
+		"aud":   audience,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	enc := base64.RawURLEncoding
+	signing := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(signing))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return signing + "." + enc.EncodeToString(sig), nil
+}
+
+func (r *Resolver) fromMetadata(ctx context.Context) (token, error) {
+	host := r.getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = defaultMetadataHost
+	}
+	u := "http://" + host + "/computeMetadata/v1/instance/service-accounts/default/token?scopes=" + url.QueryEscape(Scope)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return token{}, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	return r.exchange(req)
+}
+
+// exchange performs a token request and reads the OAuth2 token response.
+func (r *Resolver) exchange(req *http.Request) (token, error) {
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return token{}, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return token{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return token{}, fmt.Errorf("gcp: token request to %s: HTTP %d: %s", req.URL.Host, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	var doc struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil || doc.AccessToken == "" {
+		return token{}, fmt.Errorf("gcp: invalid token response from %s", req.URL.Host)
+	}
+	return token{value: doc.AccessToken, expires: r.now().Add(time.Duration(doc.ExpiresIn) * time.Second)}, nil
+}
@@ -0,0 +1,102 @@
+package gcpauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServiceAccountToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		r.ParseForm()
+		parts := strings.Split(r.PostForm.Get("assertion"), ".")
+		if len(parts) != 3 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, sum[:], sig); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"access_token":"ya29.sa","expires_in":3600,"token_type":"Bearer"}`))
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "sa.json")
+	sa, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "bot@project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    srv.URL,
+	})
+	os.WriteFile(path, sa, 0o600)
+
+	r := NewResolver()
+	for i := 0; i < 2; i++ {
+		tok, err := r.Token(context.Background(), path)
+		if err != nil || tok != "ya29.sa" {
+			t.Fatalf("Token = %q, %v", tok, err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("token fetched %d times, want cached", calls)
+	}
+}
+
+func TestADCAuthorizedUserAndMetadata(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			r.ParseForm()
+			if r.PostForm.Get("grant_type") != "refresh_token" || r.PostForm.Get("refresh_token") != "1//rt" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"access_token":"ya29.user","expires_in":3600}`))
+		case strings.HasPrefix(r.URL.Path, "/computeMetadata/") && r.Header.Get("Metadata-Flavor") == "Google":
+			w.Write([]byte(`{"access_token":"ya29.gce","expires_in":3600}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "adc.json")
+	os.WriteFile(path, []byte(`{"type":"authorized_user","client_id":"c","client_secret":"s","refresh_token":"1//rt","token_uri":"`+srv.URL+`/token"}`), 0o600)
+
+	env := map[string]string{"GOOGLE_APPLICATION_CREDENTIALS": path}
+	r := NewResolver()
+	r.getenv = func(k string) string { return env[k] }
+	if tok, err := r.Token(context.Background(), ""); err != nil || tok != "ya29.user" {
+		t.Fatalf("ADC file: %q, %v", tok, err)
+	}
+
+	env = map[string]string{"GCE_METADATA_HOST": strings.TrimPrefix(srv.URL, "http://")}
+	r = NewResolver()
+	r.getenv = func(k string) string { return env[k] }
+	if tok, err := r.Token(context.Background(), ""); err != nil || tok != "ya29.gce" {
+		t.Fatalf("metadata: %q, %v", tok, err)
+	}
+}
@@ -0,0 +1,74 @@
+package llm
+
+import (
+	"encoding/base64"
+	"encoding/json"
+)
+
+// EmbeddingsSummary replaces an embeddings response body in logs. Vectors are
+// large and not useful to read; their shape is.
+type EmbeddingsSummary struct {
+	Summary    string      `json:"prismcat_summary"` // always "embeddings"
+	Model      string      `json:"model,omitempty"`
+	Count      int         `json:"count"`
+	Dimensions int         `json:"dimensions"`
+	Encoding   string      `json:"encoding,omitempty"` // "base64" when vectors were base64-encoded
+	Usage      interface{} `json:"usage,omitempty"`
+}
+
+// SummarizeEmbeddings recognises OpenAI, Gemini and Ollama embeddings
+// responses and returns a summary without the vectors. It returns false for
+// anything else (including truncated JSON).
+func SummarizeEmbeddings(body []byte) (*EmbeddingsSummary, bool) {
+	var m map[string]interface{}
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, false
+	}
+	s := &EmbeddingsSummary{Summary: "embeddings", Model: str(m["model"])}
+
+	switch {
+	case m["object"] == "list" && m["data"] != nil: // OpenAI
+		items := asSlice(m["data"])
+		if len(items) == 0 || asMap(items[0])["object"] != "embedding" {
+			return nil, false
+		}
+		s.Count = len(items)
+		switch v := asMap(items[0])["embedding"].(type) {
+		case []interface{}:
+			s.Dimensions = len(v)
+		case string:
+			// float32 little-endian, base64-encoded.
+			if raw, err := base64.StdEncoding.DecodeString(v); err == nil {
+				s.Dimensions = len(raw) / 4
+			}
+			s.Encoding = "base64"
+		}
+		s.Usage = m["usage"]
+
+	case asMap(m["embedding"]) != nil: // Gemini embedContent
+		s.Count = 1
+		s.Dimensions = len(asSlice(asMap(m["embedding"])["values"]))
+
+	case m["embeddings"] != nil:
+		items := asSlice(m["embeddings"])
+		s.Count = len(items)
+		if len(items) > 0 {
+			if vec, ok := items[0].([]interface{}); ok { // Ollama /api/embed
+				s.Dimensions = len(vec)
+			} else { // Gemini batchEmbedContents
+				s.Dimensions = len(asSlice(asMap(items[0])["values"]))
+			}
+		}
+		if n, ok := m["prompt_eval_count"]; ok {
+			s.Usage = map[string]interface{}{"prompt_eval_count": n}
+		}
+
+	case asSlice(m["embedding"]) != nil: // Ollama /api/embeddings (legacy)
+		s.Count = 1
+		s.Dimensions = len(asSlice(m["embedding"]))
+
+	default:
+		return nil, false
+	}
+	return s, true
+}
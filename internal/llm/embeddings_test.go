@@ -0,0 +1,29 @@
+package llm
+
+import "testing"
+
+func TestSummarizeEmbeddings(t *testing.T) {
+	cases := []struct {
+		name, body   string
+		count, dims  int
+		wantEncoding string
+	}{
+		{"openai", `{"object":"list","model":"text-embedding-3-small","data":[{"object":"embedding","index":0,"embedding":[0.1,0.2,0.3]},{"object":"embedding","index":1,"embedding":[0.4,0.5,0.6]}],"usage":{"prompt_tokens":4}}`, 2, 3, ""},
+		{"openai base64", `{"object":"list","data":[{"object":"embedding","embedding":"AAAAAAAAAAA="}]}`, 1, 2, "base64"},
+		{"gemini", `{"embedding":{"values":[1,2,3,4]}}`, 1, 4, ""},
+		{"gemini batch", `{"embeddings":[{"values":[1,2]},{"values":[3,4]}]}`, 2, 2, ""},
+		{"ollama", `{"model":"nomic","embeddings":[[1,2,3]],"prompt_eval_count":3}`, 1, 3, ""},
+	}
+	for _, c := range cases {
+		s, ok := SummarizeEmbeddings([]byte(c.body))
+		if !ok || s.Count != c.count || s.Dimensions != c.dims || s.Encoding != c.wantEncoding {
+			t.Errorf("%s: got %+v ok=%v", c.name, s, ok)
+		}
+	}
+
+	for _, body := range []string{`{"object":"list","data":[{"object":"model"}]}`, `{"object":"list","data":[{"object":"embedding","embedding":[0.1,`} {
+		if _, ok := SummarizeEmbeddings([]byte(body)); ok {
+			t.Errorf("SummarizeEmbeddings(%s) should fail", body)
+		}
+	}
+}
@@ -0,0 +1,415 @@
+package llm
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// Provider formats recognised by MergeStream.
+const (
+	FormatOpenAIChat      = "openai_chat"
+	FormatOpenAIResponses = "openai_responses"
+	FormatAnthropic       = "anthropic"
+	FormatGemini          = "gemini"
+	FormatOllama          = "ollama"
+)
+
+// MergeStream reassembles streamed events into the response body the provider
+// would have returned without streaming. It returns the detected format and
+// false when the stream is not in a recognised format.
+func MergeStream(events []SSEEvent) (map[string]interface{}, string, bool) {
+	var docs []map[string]interface{}
+	var names []string
+	for _, ev := range events {
+		if m, ok := ev.JSON().(map[string]interface{}); ok {
+			docs = append(docs, m)
+			names = append(names, ev.Event)
+		}
+	}
+	if len(docs) == 0 {
+		return nil, "", false
+	}
+
+	format := detectFormat(docs[0], names[0])
+	var merged map[string]interface{}
+	switch format {
+	case FormatOpenAIChat:
+		merged = mergeOpenAIChat(docs)
+	case FormatOpenAIResponses:
+		merged = mergeOpenAIResponses(docs)
+	case FormatAnthropic:
+		merged = mergeAnthropic(docs)
+	case FormatGemini:
+		merged = mergeGemini(docs)
+	case FormatOllama:
+		merged = mergeOllama(docs)
+	}
+	if merged == nil {
+		return nil, "", false
+	}
+	return merged, format, true
+}
+
+func detectFormat(first map[string]interface{}, event string) string {
+	typ, _ := first["type"].(string)
+	switch {
+	case first["object"] == "chat.completion.chunk":
+		return FormatOpenAIChat
+	case strings.HasPrefix(typ, "response.") || strings.HasPrefix(event, "response."):
+		return FormatOpenAIResponses
+	case typ == "message_start" || typ == "ping" || event == "message_start":
+		return FormatAnthropic
+	case first["candidates"] != nil || first["usageMetadata"] != nil:
+		return FormatGemini
+	case first["done"] != nil && (first["message"] != nil || first["response"] != nil):
+		return FormatOllama
+	case first["choices"] != nil:
+		return FormatOpenAIChat
+	}
+	return ""
+}
+
+func mergeOpenAIChat(docs []map[string]interface{}) map[string]interface{} {
+	type toolCall struct {
+		id, typ, name string
+		args          strings.Builder
+	}
+	type choice struct {
+		role, finish string
+		content      strings.Builder
+		reasoning    strings.Builder
+		tools        map[int]*toolCall
+	}
+	choices := map[int]*choice{}
+	out := map[string]interface{}{"object": "chat.completion"}
+
+	for _, d := range docs {
+		for _, k := range []string{"id", "model", "created", "system_fingerprint", "service_tier"} {
+			if v, ok := d[k]; ok && v != nil {
+				out[k] = v
+			}
+		}
+		if u, ok := d["usage"].(map[string]interface{}); ok {
+			out["usage"] = u
+		}
+		list, _ := d["choices"].([]interface{})
+		for _, raw := range list {
+			c, _ := raw.(map[string]interface{})
+			idx := toInt(c["index"])
+			ch := choices[idx]
+			if ch == nil {
+				ch = &choice{tools: map[int]*toolCall{}}
+				choices[idx] = ch
+			}
+			if fr, ok := c["finish_reason"].(string); ok && fr != "" {
+				ch.finish = fr
+			}
+			delta, _ := c["delta"].(map[string]interface{})
+			if r, ok := delta["role"].(string); ok && r != "" {
+				ch.role = r
+			}
+			if s, ok := delta["content"].(string); ok {
+				ch.content.WriteString(s)
+			}
+			for _, k := range []string{"reasoning_content", "reasoning"} {
+				if s, ok := delta[k].(string); ok {
+					ch.reasoning.WriteString(s)
+				}
+			}
+			tcs, _ := delta["tool_calls"].([]interface{})
+			for _, rawTC := range tcs {
+				tc, _ := rawTC.(map[string]interface{})
+				ti := toInt(tc["index"])
+				t := ch.tools[ti]
+				if t == nil {
+					t = &toolCall{}
+					ch.tools[ti] = t
+				}
+				if s, _ := tc["id"].(string); s != "" {
+					t.id = s
+				}
+				if s, _ := tc["type"].(string); s != "" {
+					t.typ = s
+				}
+				fn, _ := tc["function"].(map[string]interface{})
+				if s, _ := fn["name"].(string); s != "" {
+					t.name = s
+				}
+				if s, ok := fn["arguments"].(string); ok {
+					t.args.WriteString(s)
+				}
+			}
+		}
+	}
+
+	var merged []interface{}
+	for _, idx := range sortedKeys(choices) {
+		ch := choices[idx]
+		role := ch.role
+		if role == "" {
+			role = "assistant"
+		}
+		msg := map[string]interface{}{"role": role, "content": ch.content.String()}
+		if ch.reasoning.Len() > 0 {
+			msg["reasoning_content"] = ch.reasoning.String()
+		}
+		if len(ch.tools) > 0 {
+			var calls []interface{}
+			for _, ti := range sortedKeys(ch.tools) {
+				t := ch.tools[ti]
+				typ := t.typ
+				if typ == "" {
+					typ = "function"
+				}
+				calls = append(calls, map[string]interface{}{
+					"id":       t.id,
+					"type":     typ,
+					"function": map[string]interface{}{"name": t.name, "arguments": t.args.String()},
+				})
+			}
+			msg["tool_calls"] = calls
+		}
+		c := map[string]interface{}{"index": idx, "message": msg, "finish_reason": nil}
+		if ch.finish != "" {
+			c["finish_reason"] = ch.finish
+		}
+		merged = append(merged, c)
+	}
+	if merged == nil {
+		merged = []interface{}{}
+	}
+	out["choices"] = merged
+	return out
+}
+
+// mergeOpenAIResponses uses the final response object carried by
+// response.completed (or failed/incomplete); the Responses API already sends
+// the full result there.
+func mergeOpenAIResponses(docs []map[string]interface{}) map[string]interface{} {
+	var last map[string]interface{}
+	for _, d := range docs {
+		if r, ok := d["response"].(map[string]interface{}); ok {
+			last = r
+		}
+	}
+	return last
+}
+
+func mergeAnthropic(docs []map[string]interface{}) map[string]interface{} {
+	var msg map[string]interface{}
+	blocks := map[int]map[string]interface{}{}
+	partial := map[int]*strings.Builder{}
+
+	for _, d := range docs {
+		switch d["type"] {
+		case "message_start":
+			msg, _ = d["message"].(map[string]interface{})
+		case "content_block_start":
+			idx := toInt(d["index"])
+			if b, ok := d["content_block"].(map[string]interface{}); ok {
+				blocks[idx] = b
+			}
+		case "content_block_delta":
+			idx := toInt(d["index"])
+			b := blocks[idx]
+			if b == nil {
+				b = map[string]interface{}{"type": "text", "text": ""}
+				blocks[idx] = b
+			}
+			delta, _ := d["delta"].(map[string]interface{})
+			switch delta["type"] {
+			case "text_delta":
+				b["text"] = str(b["text"]) + str(delta["text"])
+			case "thinking_delta":
+				b["thinking"] = str(b["thinking"]) + str(delta["thinking"])
+			case "signature_delta":
+				b["signature"] = str(b["signature"]) + str(delta["signature"])
+			case "input_json_delta":
+				if partial[idx] == nil {
+					partial[idx] = &strings.Builder{}
+				}
+				partial[idx].WriteString(str(delta["partial_json"]))
+			}
+		case "message_delta":
+			if msg == nil {
+				msg = map[string]interface{}{}
+			}
+			if delta, ok := d["delta"].(map[string]interface{}); ok {
+				for k, v := range delta {
+					msg[k] = v
+				}
+			}
+			if u, ok := d["usage"].(map[string]interface{}); ok {
+				usage, _ := msg["usage"].(map[string]interface{})
+				if usage == nil {
+					usage = map[string]interface{}{}
+				}
+				for k, v := range u {
+					usage[k] = v
+				}
+				msg["usage"] = usage
+			}
+		}
+	}
+	if msg == nil {
+		return nil
+	}
+	for idx, sb := range partial {
+		if b := blocks[idx]; b != nil {
+			var input interface{}
+			if err := json.Unmarshal([]byte(sb.String()), &input); err == nil {
+				b["input"] = input
+			} else {
+				b["input"] = sb.String()
+			}
+		}
+	}
+	content := []interface{}{}
+	for _, idx := range sortedKeys(blocks) {
+		content = append(content, blocks[idx])
+	}
+	msg["content"] = content
+	return msg
+}
+
+func mergeGemini(docs []map[string]interface{}) map[string]interface{} {
+	type cand struct {
+		role   string
+		parts  []interface{}
+		extras map[string]interface{}
+	}
+	cands := map[int]*cand{}
+	out := map[string]interface{}{}
+
+	for _, d := range docs {
+		for k, v := range d {
+			if k != "candidates" {
+				out[k] = v
+			}
+		}
+		list, _ := d["candidates"].([]interface{})
+		for i, raw := range list {
+			c, _ := raw.(map[string]interface{})
+			idx := i
+			if _, ok := c["index"]; ok {
+				idx = toInt(c["index"])
+			}
+			cd := cands[idx]
+			if cd == nil {
+				cd = &cand{extras: map[string]interface{}{}}
+				cands[idx] = cd
+			}
+			for k, v := range c {
+				if k != "content" {
+					cd.extras[k] = v
+				}
+			}
+			content, _ := c["content"].(map[string]interface{})
+			if r, _ := content["role"].(string); r != "" {
+				cd.role = r
+			}
+			parts, _ := content["parts"].([]interface{})
+			for _, rawPart := range parts {
+				p, _ := rawPart.(map[string]interface{})
+				// Consecutive text parts of the same kind are one logical part.
+				if text, ok := p["text"].(string); ok && len(cd.parts) > 0 {
+					prev, _ := cd.parts[len(cd.parts)-1].(map[string]interface{})
+					if prevText, ok := prev["text"].(string); ok && prev["thought"] == p["thought"] {
+						prev["text"] = prevText + text
+						continue
+					}
+				}
+				cp := map[string]interface{}{}
+				for k, v := range p {
+					cp[k] = v
+				}
+				cd.parts = append(cd.parts, cp)
+			}
+		}
+	}
+
+	var merged []interface{}
+	for _, idx := range sortedKeys(cands) {
+		cd := cands[idx]
+		c := cd.extras
+		parts := cd.parts
+		if parts == nil {
+			parts = []interface{}{}
+		}
+		content := map[string]interface{}{"parts": parts}
+		if cd.role != "" {
+			content["role"] = cd.role
+		}
+		c["content"] = content
+		merged = append(merged, c)
+	}
+	if merged != nil {
+		out["candidates"] = merged
+	}
+	return out
+}
+
+func mergeOllama(docs []map[string]interface{}) map[string]interface{} {
+	out := map[string]interface{}{}
+	var content, thinking, response strings.Builder
+	var role string
+	var toolCalls []interface{}
+	chat := false
+
+	for _, d := range docs {
+		for k, v := range d {
+			if k != "message" && k != "response" {
+				out[k] = v
+			}
+		}
+		if s, ok := d["response"].(string); ok {
+			response.WriteString(s)
+		}
+		if m, ok := d["message"].(map[string]interface{}); ok {
+			chat = true
+			if r, _ := m["role"].(string); r != "" {
+				role = r
+			}
+			content.WriteString(str(m["content"]))
+			thinking.WriteString(str(m["thinking"]))
+			if tcs, ok := m["tool_calls"].([]interface{}); ok {
+				toolCalls = append(toolCalls, tcs...)
+			}
+		}
+	}
+	if chat {
+		msg := map[string]interface{}{"role": role, "content": content.String()}
+		if thinking.Len() > 0 {
+			msg["thinking"] = thinking.String()
+		}
+		if toolCalls != nil {
+			msg["tool_calls"] = toolCalls
+		}
+		out["message"] = msg
+	} else {
+		out["response"] = response.String()
+	}
+	return out
+}
+
+func toInt(v interface{}) int {
+	if f, ok := v.(float64); ok {
+		return int(f)
+	}
+	return 0
+}
+
+func str(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+func sortedKeys[V any](m map[int]V) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
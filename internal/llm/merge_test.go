@@ -0,0 +1,102 @@
+package llm
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseSSE(t *testing.T) {
+	body := ": keep-alive\n\nevent: message_start\ndata: {\"a\":1}\n\ndata: line1\ndata: line2\r\n\r\ndata: [DONE]"
+	events := ParseSSE([]byte(body))
+	if len(events) != 3 {
+		t.Fatalf("events = %+v", events)
+	}
+	if events[0].Event != "message_start" || events[0].Offset != 14 {
+		t.Fatalf("first = %+v", events[0])
+	}
+	if events[1].Data != "line1\nline2" {
+		t.Fatalf("multi-line data = %q", events[1].Data)
+	}
+	if events[2].Data != "[DONE]" || events[2].JSON() != nil {
+		t.Fatalf("trailing = %+v", events[2])
+	}
+}
+
+func mergeJSON(t *testing.T, contentType, body, wantFormat string) string {
+	t.Helper()
+	merged, format, ok := MergeStream(ParseStream(contentType, []byte(body)))
+	if !ok || format != wantFormat {
+		t.Fatalf("MergeStream: format=%q ok=%v", format, ok)
+	}
+	out, _ := json.Marshal(merged)
+	return string(out)
+}
+
+func TestMergeOpenAIChat(t *testing.T) {
+	body := `data: {"id":"c1","object":"chat.completion.chunk","model":"gpt","choices":[{"index":0,"delta":{"role":"assistant","content":"Hel"}}]}
+
+data: {"id":"c1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"content":"lo"}}]}
+
+data: {"id":"c1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","function":{"name":"get","arguments":"{\"q\":"}}]}}]}
+
+data: {"id":"c1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"1}"}}]},"finish_reason":"tool_calls"}],"usage":{"total_tokens":9}}
+
+data: [DONE]
+`
+	got := mergeJSON(t, "text/event-stream", body, FormatOpenAIChat)
+	want := `{"choices":[{"finish_reason":"tool_calls","index":0,"message":{"content":"Hello","role":"assistant","tool_calls":[{"function":{"arguments":"{\"q\":1}","name":"get"},"id":"call_1","type":"function"}]}}],"id":"c1","model":"gpt","object":"chat.completion","usage":{"total_tokens":9}}`
+	if got != want {
+		t.Fatalf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestMergeAnthropic(t *testing.T) {
+	body := `event: message_start
+data: {"type":"message_start","message":{"id":"m1","role":"assistant","content":[],"usage":{"input_tokens":5}}}
+
+event: content_block_start
+data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi"}}
+
+event: content_block_start
+data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"t1","name":"get","input":{}}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"q\":"}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"1}"}}
+
+event: message_delta
+data: {"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":7}}
+
+event: message_stop
+data: {"type":"message_stop"}
+`
+	got := mergeJSON(t, "text/event-stream", body, FormatAnthropic)
+	want := `{"content":[{"text":"Hi","type":"text"},{"id":"t1","input":{"q":1},"name":"get","type":"tool_use"}],"id":"m1","role":"assistant","stop_reason":"tool_use","usage":{"input_tokens":5,"output_tokens":7}}`
+	if got != want {
+		t.Fatalf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestMergeGeminiAndOllama(t *testing.T) {
+	gemini := `data: {"candidates":[{"content":{"role":"model","parts":[{"text":"Hel"}]}}]}
+
+data: {"candidates":[{"content":{"role":"model","parts":[{"text":"lo"}]},"finishReason":"STOP"}],"usageMetadata":{"totalTokenCount":3}}
+`
+	got := mergeJSON(t, "text/event-stream", gemini, FormatGemini)
+	want := `{"candidates":[{"content":{"parts":[{"text":"Hello"}],"role":"model"},"finishReason":"STOP"}],"usageMetadata":{"totalTokenCount":3}}`
+	if got != want {
+		t.Fatalf("gemini: got  %s\nwant %s", got, want)
+	}
+
+	ollama := "{\"model\":\"llama\",\"message\":{\"role\":\"assistant\",\"content\":\"Hel\"},\"done\":false}\n{\"model\":\"llama\",\"message\":{\"role\":\"assistant\",\"content\":\"lo\"},\"done\":true}\n"
+	got = mergeJSON(t, "application/x-ndjson", ollama, FormatOllama)
+	want = `{"done":true,"message":{"content":"Hello","role":"assistant"},"model":"llama"}`
+	if got != want {
+		t.Fatalf("ollama: got  %s\nwant %s", got, want)
+	}
+}
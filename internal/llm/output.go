@@ -0,0 +1,163 @@
+package llm
+
+import "strings"
+
+// Output is the assistant's answer extracted from a (non-streaming or merged)
+// response body.
+type Output struct {
+	Format string `json:"format"`
+	// Text is the visible answer, usually markdown.
+	Text string `json:"text"`
+	// Reasoning holds thinking/reasoning content when the provider returns it.
+	Reasoning    string `json:"reasoning,omitempty"`
+	FinishReason string `json:"finish_reason,omitempty"`
+}
+
+// DetectResponseFormat identifies the provider format of a non-streaming
+// response body (or one reassembled by MergeStream).
+func DetectResponseFormat(body map[string]interface{}) string {
+	typ, _ := body["type"].(string)
+	switch {
+	case body["choices"] != nil:
+		return FormatOpenAIChat
+	case body["object"] == "response" || body["output"] != nil:
+		return FormatOpenAIResponses
+	case typ == "message" || (body["content"] != nil && body["stop_reason"] != nil):
+		return FormatAnthropic
+	case body["candidates"] != nil:
+		return FormatGemini
+	case body["done"] != nil && (body["message"] != nil || body["response"] != nil):
+		return FormatOllama
+	}
+	return ""
+}
+
+// ExtractOutput returns the assistant's textual output. Multiple choices or
+// candidates are joined with blank lines. It returns false for unrecognised
+// bodies.
+func ExtractOutput(body map[string]interface{}) (Output, bool) {
+	out := Output{Format: DetectResponseFormat(body)}
+	var text, reasoning []string
+
+	switch out.Format {
+	case FormatOpenAIChat:
+		for _, raw := range asSlice(body["choices"]) {
+			c := asMap(raw)
+			if out.FinishReason == "" {
+				out.FinishReason = str(c["finish_reason"])
+			}
+			if msg := asMap(c["message"]); msg != nil {
+				text = appendNonEmpty(text, contentText(msg["content"]))
+				reasoning = appendNonEmpty(reasoning, str(msg["reasoning_content"]), str(msg["reasoning"]))
+			} else {
+				// Legacy /v1/completions.
+				text = appendNonEmpty(text, str(c["text"]))
+			}
+		}
+
+	case FormatOpenAIResponses:
+		// output_text is an SDK convenience some gateways include; prefer it
+		// to avoid duplicating the message items.
+		outputText := str(body["output_text"])
+		text = appendNonEmpty(text, outputText)
+		for _, raw := range asSlice(body["output"]) {
+			item := asMap(raw)
+			switch item["type"] {
+			case "message":
+				if outputText == "" {
+					text = appendNonEmpty(text, contentText(item["content"]))
+				}
+			case "reasoning":
+				for _, s := range asSlice(item["summary"]) {
+					reasoning = appendNonEmpty(reasoning, str(asMap(s)["text"]))
+				}
+			}
+		}
+		out.FinishReason = str(body["status"])
+
+	case FormatAnthropic:
+		for _, raw := range asSlice(body["content"]) {
+			b := asMap(raw)
+			switch b["type"] {
+			case "text":
+				text = appendNonEmpty(text, str(b["text"]))
+			case "thinking":
+				reasoning = appendNonEmpty(reasoning, str(b["thinking"]))
+			}
+		}
+		out.FinishReason = str(body["stop_reason"])
+
+	case FormatGemini:
+		for _, raw := range asSlice(body["candidates"]) {
+			c := asMap(raw)
+			if out.FinishReason == "" {
+				out.FinishReason = str(c["finishReason"])
+			}
+			var parts, thoughts []string
+			for _, p := range asSlice(asMap(c["content"])["parts"]) {
+				part := asMap(p)
+				if part["thought"] == true {
+					thoughts = appendNonEmpty(thoughts, str(part["text"]))
+				} else {
+					parts = appendNonEmpty(parts, str(part["text"]))
+				}
+			}
+			text = appendNonEmpty(text, strings.Join(parts, ""))
+			reasoning = appendNonEmpty(reasoning, strings.Join(thoughts, ""))
+		}
+
+	case FormatOllama:
+		if msg := asMap(body["message"]); msg != nil {
+			text = appendNonEmpty(text, str(msg["content"]))
+			reasoning = appendNonEmpty(reasoning, str(msg["thinking"]))
+		} else {
+			text = appendNonEmpty(text, str(body["response"]))
+		}
+		out.FinishReason = str(body["done_reason"])
+
+	default:
+		return out, false
+	}
+
+	out.Text = strings.Join(text, "\n\n")
+	out.Reasoning = strings.Join(reasoning, "\n\n")
+	return out, true
+}
+
+// contentText flattens a message content that is either a string or an array
+// of typed parts (OpenAI chat/responses, Anthropic).
+func contentText(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	var sb strings.Builder
+	for _, raw := range asSlice(v) {
+		p := asMap(raw)
+		switch p["type"] {
+		case "text", "output_text":
+			sb.WriteString(str(p["text"]))
+		case "refusal":
+			sb.WriteString(str(p["refusal"]))
+		}
+	}
+	return sb.String()
+}
+
+func appendNonEmpty(dst []string, values ...string) []string {
+	for _, v := range values {
+		if v != "" {
+			dst = append(dst, v)
+		}
+	}
+	return dst
+}
+
+func asMap(v interface{}) map[string]interface{} {
+	m, _ := v.(map[string]interface{})
+	return m
+}
+
+func asSlice(v interface{}) []interface{} {
+	s, _ := v.([]interface{})
+	return s
+}
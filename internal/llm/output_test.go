@@ -0,0 +1,34 @@
+package llm
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestExtractOutput(t *testing.T) {
+	cases := []struct {
+		name, body, format, text, reasoning string
+	}{
+		{"openai chat", `{"choices":[{"message":{"role":"assistant","content":"Hello","reasoning_content":"hmm"},"finish_reason":"stop"}]}`, FormatOpenAIChat, "Hello", "hmm"},
+		{"openai content parts", `{"choices":[{"message":{"content":[{"type":"text","text":"A"},{"type":"text","text":"B"}]}}]}`, FormatOpenAIChat, "AB", ""},
+		{"openai completions", `{"choices":[{"text":"legacy"}]}`, FormatOpenAIChat, "legacy", ""},
+		{"responses", `{"object":"response","status":"completed","output":[{"type":"reasoning","summary":[{"type":"summary_text","text":"think"}]},{"type":"message","content":[{"type":"output_text","text":"Answer"}]}]}`, FormatOpenAIResponses, "Answer", "think"},
+		{"anthropic", `{"type":"message","content":[{"type":"thinking","thinking":"plan"},{"type":"text","text":"Hi"},{"type":"tool_use","name":"x"}],"stop_reason":"end_turn"}`, FormatAnthropic, "Hi", "plan"},
+		{"gemini", `{"candidates":[{"content":{"parts":[{"text":"idea","thought":true},{"text":"Hel"},{"text":"lo"}]},"finishReason":"STOP"}]}`, FormatGemini, "Hello", "idea"},
+		{"ollama generate", `{"model":"llama","response":"yo","done":true}`, FormatOllama, "yo", ""},
+	}
+	for _, c := range cases {
+		var body map[string]interface{}
+		if err := json.Unmarshal([]byte(c.body), &body); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		out, ok := ExtractOutput(body)
+		if !ok || out.Format != c.format || out.Text != c.text || out.Reasoning != c.reasoning {
+			t.Errorf("%s: got %+v ok=%v", c.name, out, ok)
+		}
+	}
+
+	if _, ok := ExtractOutput(map[string]interface{}{"data": []interface{}{}}); ok {
+		t.Error("embeddings response should not be recognised")
+	}
+}
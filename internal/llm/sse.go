@@ -0,0 +1,110 @@
+// Package llm understands the payload formats of common LLM provider APIs
+// (OpenAI, Anthropic, Gemini, Ollama): parsing streamed responses and
+// reassembling them into the equivalent non-streaming body.
+package llm
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// SSEEvent is one server-sent event.
+type SSEEvent struct {
+	// Event is the "event:" field (empty means the default "message").
+	Event string `json:"event,omitempty"`
+	ID    string `json:"id,omitempty"`
+	// Data is the joined "data:" lines.
+	Data string `json:"data"`
+	// Offset is the byte offset of the event's first line in the stream.
+	Offset int `json:"offset"`
+}
+
+// JSON returns Data decoded as JSON, or nil if it is not JSON (e.g. "[DONE]").
+func (e SSEEvent) JSON() interface{} {
+	var v interface{}
+	if err := json.Unmarshal([]byte(e.Data), &v); err != nil {
+		return nil
+	}
+	return v
+}
+
+// ParseSSE splits a captured text/event-stream body into events. Comments and
+// events without data are skipped. A trailing event that was cut off (no
+// terminating blank line) is still returned.
+func ParseSSE(body []byte) []SSEEvent {
+	var (
+		events  []SSEEvent
+		cur     SSEEvent
+		data    []string
+		started = -1
+		offset  int
+	)
+	flush := func() {
+		if len(data) > 0 {
+			cur.Data = strings.Join(data, "\n")
+			cur.Offset = started
+			events = append(events, cur)
+		}
+		cur, data, started = SSEEvent{}, nil, -1
+	}
+
+	for len(body) > 0 {
+		line := body
+		next := len(body)
+		if i := bytes.IndexByte(body, '\n'); i >= 0 {
+			line, next = body[:i], i+1
+		}
+		lineStart := offset
+		offset += next
+		body = body[next:]
+
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if len(line) == 0 {
+			flush()
+			continue
+		}
+		if line[0] == ':' {
+			continue
+		}
+		if started < 0 {
+			started = lineStart
+		}
+		field, value, _ := strings.Cut(string(line), ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			cur.Event = value
+		case "data":
+			data = append(data, value)
+		case "id":
+			cur.ID = value
+		}
+	}
+	flush()
+	return events
+}
+
+// ParseNDJSON splits a newline-delimited JSON stream (e.g. Ollama) into
+// events with one JSON document each.
+func ParseNDJSON(body []byte) []SSEEvent {
+	var events []SSEEvent
+	offset := 0
+	for _, line := range bytes.SplitAfter(body, []byte("\n")) {
+		trimmed := bytes.TrimSpace(line)
+		if len(trimmed) > 0 {
+			events = append(events, SSEEvent{Data: string(trimmed), Offset: offset})
+		}
+		offset += len(line)
+	}
+	return events
+}
+
+// ParseStream parses a streamed body according to its Content-Type.
+func ParseStream(contentType string, body []byte) []SSEEvent {
+	ct := strings.ToLower(contentType)
+	if strings.Contains(ct, "ndjson") || strings.Contains(ct, "stream+json") || strings.Contains(ct, "json-seq") {
+		return ParseNDJSON(body)
+	}
+	return ParseSSE(body)
+}
@@ -0,0 +1,88 @@
+package llm
+
+import (
+	"unicode/utf8"
+)
+
+// promptFields hold the prompt in OpenAI chat/completions/responses,
+// Anthropic messages and Gemini generateContent request bodies.
+var promptFields = []string{"messages", "system", "input", "instructions", "prompt", "contents", "systemInstruction", "system_instruction", "tools", "functions"}
+
+// binaryFields hold media (base64 data, URLs) whose size says nothing about
+// their token cost; they are not counted.
+var binaryFields = map[string]bool{
+	"image_url": true, "image": true, "source": true, "input_audio": true,
+	"inline_data": true, "inlineData": true, "file_data": true, "fileData": true,
+}
+
+// EstimatePromptTokens approximates the prompt tokens of a request body:
+// a quarter token per ASCII byte, one per other character, plus a few tokens
+// of framing per message. It is close for English text but only a heuristic
+// for other content, and media parts are not counted at all.
+func EstimatePromptTokens(body map[string]interface{}) int {
+	n := 0
+	for _, f := range promptFields {
+		v, ok := body[f]
+		if !ok {
+			continue
+		}
+		if list, ok := v.([]interface{}); ok && (f == "messages" || f == "contents" || f == "input") {
+			n += 4 * len(list)
+		}
+		n += countTokens(v)
+	}
+	return n
+}
+
+func countTokens(v interface{}) int {
+	switch x := v.(type) {
+	case string:
+		return TextTokens(x)
+	case map[string]interface{}:
+		n := 0
+		for k, child := range x {
+			if !binaryFields[k] {
+				n += countTokens(child)
+			}
+		}
+		return n
+	case []interface{}:
+		n := 0
+		for _, child := range x {
+			n += countTokens(child)
+		}
+		return n
+	}
+	return 0
+}
+
+// TextTokens approximates the token count of s.
+func TextTokens(s string) int {
+	ascii, other := 0, 0
+	for i := 0; i < len(s); {
+		if s[i] < utf8.RuneSelf {
+			ascii++
+			i++
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		other++
+		i += size
+	}
+	return (ascii+3)/4 + other
+}
+
+// RequestedOutputTokens returns the output budget a request asks for
+// (max_tokens, max_completion_tokens, max_output_tokens or Gemini's
+// generationConfig.maxOutputTokens), or 0 when it sets none.
+func RequestedOutputTokens(body map[string]interface{}) int {
+	for _, k := range []string{"max_completion_tokens", "max_tokens", "max_output_tokens"} {
+		if n := toInt(body[k]); n > 0 {
+			return n
+		}
+	}
+	if gc := asMap(body["generationConfig"]); gc != nil {
+		return toInt(gc["maxOutputTokens"])
+	}
+	return 0
+}
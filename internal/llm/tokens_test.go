@@ -0,0 +1,31 @@
+package llm
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestEstimatePromptTokens(t *testing.T) {
+	var body map[string]interface{}
+	_ = json.Unmarshal([]byte(`{
+		"model": "gpt-4o",
+		"max_tokens": 64,
+		"messages": [
+			{"role": "system", "content": "Be brief."},
+			{"role": "user", "content": [
+				{"type": "text", "text": "你好，世界"},
+				{"type": "image_url", "image_url": {"url": "data:image/png;base64,`+strings.Repeat("A", 4000)+`"}}
+			]}
+		]
+	}`), &body)
+
+	// 2 messages * 4 framing + "system"/"user"/"text"/"image_url" type and
+	// role strings + "Be brief." (3) + 5 CJK characters; the image is skipped.
+	if got := EstimatePromptTokens(body); got < 15 || got > 30 {
+		t.Fatalf("EstimatePromptTokens = %d", got)
+	}
+	if got := RequestedOutputTokens(body); got != 64 {
+		t.Fatalf("RequestedOutputTokens = %d", got)
+	}
+}
@@ -0,0 +1,186 @@
+package llm
+
+import "encoding/json"
+
+// Where a tool call was found.
+const (
+	SourceRequest  = "request"  // part of the conversation history sent upstream
+	SourceResponse = "response" // newly requested by the model in this response
+)
+
+// ToolCall is one tool/function invocation, with its result when the request
+// history contains it.
+type ToolCall struct {
+	ID     string `json:"id,omitempty"`
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	// Arguments is decoded JSON when the provider sends arguments as a string.
+	Arguments interface{} `json:"arguments,omitempty"`
+	Result    interface{} `json:"result,omitempty"`
+	HasResult bool        `json:"has_result"`
+}
+
+// ToolReport lists the tools offered to the model and the calls it made.
+type ToolReport struct {
+	Declared []string   `json:"declared"`
+	Calls    []ToolCall `json:"calls"`
+}
+
+// ExtractTools collects tool declarations, calls and results from a request
+// body and its (non-streaming or merged) response body. Either may be nil.
+// Results are matched to calls by id, or by name for Gemini which has no ids.
+func ExtractTools(req, resp map[string]interface{}) ToolReport {
+	c := &toolCollector{report: ToolReport{Declared: []string{}, Calls: []ToolCall{}}}
+	if req != nil {
+		c.declared(req)
+		c.collect(req, SourceRequest)
+	}
+	if resp != nil {
+		c.collect(resp, SourceResponse)
+	}
+	return c.report
+}
+
+type toolCollector struct {
+	report ToolReport
+}
+
+func (c *toolCollector) declared(req map[string]interface{}) {
+	for _, raw := range asSlice(req["tools"]) {
+		t := asMap(raw)
+		switch {
+		case asMap(t["function"]) != nil: // OpenAI chat
+			c.report.Declared = appendNonEmpty(c.report.Declared, str(asMap(t["function"])["name"]))
+		case t["functionDeclarations"] != nil: // Gemini
+			for _, d := range asSlice(t["functionDeclarations"]) {
+				c.report.Declared = appendNonEmpty(c.report.Declared, str(asMap(d)["name"]))
+			}
+		default: // Anthropic, OpenAI Responses
+			c.report.Declared = appendNonEmpty(c.report.Declared, str(t["name"]))
+		}
+	}
+	for _, raw := range asSlice(req["functions"]) { // legacy OpenAI
+		c.report.Declared = appendNonEmpty(c.report.Declared, str(asMap(raw)["name"]))
+	}
+}
+
+func (c *toolCollector) collect(body map[string]interface{}, source string) {
+	// OpenAI chat: request messages or response choices.
+	for _, raw := range asSlice(body["messages"]) {
+		c.openAIMessage(asMap(raw), source)
+	}
+	for _, raw := range asSlice(body["choices"]) {
+		c.openAIMessage(asMap(asMap(raw)["message"]), source)
+	}
+	// OpenAI Responses: request input items or response output items.
+	for _, key := range []string{"input", "output"} {
+		for _, raw := range asSlice(body[key]) {
+			c.responsesItem(asMap(raw), source)
+		}
+	}
+	// Anthropic response.
+	if body["type"] == "message" {
+		for _, raw := range asSlice(body["content"]) {
+			c.anthropicBlock(asMap(raw), source)
+		}
+	}
+	// Gemini: request contents or response candidates.
+	for _, raw := range asSlice(body["contents"]) {
+		c.geminiParts(asMap(raw), source)
+	}
+	for _, raw := range asSlice(body["candidates"]) {
+		c.geminiParts(asMap(asMap(raw)["content"]), source)
+	}
+}
+
+func (c *toolCollector) openAIMessage(msg map[string]interface{}, source string) {
+	if msg == nil {
+		return
+	}
+	for _, raw := range asSlice(msg["tool_calls"]) {
+		tc := asMap(raw)
+		fn := asMap(tc["function"])
+		c.addCall(str(tc["id"]), str(fn["name"]), decodeArgs(fn["arguments"]), source)
+	}
+	if fc := asMap(msg["function_call"]); fc != nil {
+		c.addCall("", str(fc["name"]), decodeArgs(fc["arguments"]), source)
+	}
+	switch msg["role"] {
+	case "tool":
+		c.addResult(str(msg["tool_call_id"]), str(msg["name"]), msg["content"])
+	case "function":
+		c.addResult("", str(msg["name"]), msg["content"])
+	}
+	// Anthropic request messages share the "messages" key but carry blocks.
+	for _, raw := range asSlice(msg["content"]) {
+		c.anthropicBlock(asMap(raw), source)
+	}
+}
+
+func (c *toolCollector) responsesItem(item map[string]interface{}, source string) {
+	switch item["type"] {
+	case "function_call":
+		id := str(item["call_id"])
+		if id == "" {
+			id = str(item["id"])
+		}
+		c.addCall(id, str(item["name"]), decodeArgs(item["arguments"]), source)
+	case "function_call_output":
+		c.addResult(str(item["call_id"]), "", item["output"])
+	}
+}
+
+func (c *toolCollector) anthropicBlock(b map[string]interface{}, source string) {
+	switch b["type"] {
+	case "tool_use":
+		c.addCall(str(b["id"]), str(b["name"]), b["input"], source)
+	case "tool_result":
+		c.addResult(str(b["tool_use_id"]), "", b["content"])
+	}
+}
+
+func (c *toolCollector) geminiParts(content map[string]interface{}, source string) {
+	for _, raw := range asSlice(content["parts"]) {
+		p := asMap(raw)
+		if fc := asMap(p["functionCall"]); fc != nil {
+			c.addCall(str(fc["id"]), str(fc["name"]), fc["args"], source)
+		}
+		if fr := asMap(p["functionResponse"]); fr != nil {
+			c.addResult(str(fr["id"]), str(fr["name"]), fr["response"])
+		}
+	}
+}
+
+func (c *toolCollector) addCall(id, name string, args interface{}, source string) {
+	c.report.Calls = append(c.report.Calls, ToolCall{ID: id, Name: name, Source: source, Arguments: args})
+}
+
+// addResult attaches a result to the earliest matching call without one; an
+// orphaned result (its call was cut from the history) is kept as its own entry.
+func (c *toolCollector) addResult(id, name string, result interface{}) {
+	for i := range c.report.Calls {
+		call := &c.report.Calls[i]
+		if call.HasResult {
+			continue
+		}
+		if (id != "" && call.ID == id) || (id == "" && name != "" && call.Name == name) {
+			call.Result, call.HasResult = result, true
+			return
+		}
+	}
+	c.report.Calls = append(c.report.Calls, ToolCall{ID: id, Name: name, Source: SourceRequest, Result: result, HasResult: true})
+}
+
+// decodeArgs decodes string-encoded JSON arguments, keeping the raw string
+// when it isn't valid JSON (e.g. a truncated stream).
+func decodeArgs(v interface{}) interface{} {
+	s, ok := v.(string)
+	if !ok {
+		return v
+	}
+	var out interface{}
+	if err := json.Unmarshal([]byte(s), &out); err != nil {
+		return s
+	}
+	return out
+}
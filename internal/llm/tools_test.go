@@ -0,0 +1,54 @@
+package llm
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func decode(t *testing.T, s string) map[string]interface{} {
+	t.Helper()
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestExtractToolsOpenAI(t *testing.T) {
+	req := decode(t, `{"tools":[{"type":"function","function":{"name":"weather"}}],"messages":[
+		{"role":"user","content":"hi"},
+		{"role":"assistant","tool_calls":[{"id":"c1","type":"function","function":{"name":"weather","arguments":"{\"city\":\"Paris\"}"}}]},
+		{"role":"tool","tool_call_id":"c1","content":"sunny"}]}`)
+	resp := decode(t, `{"choices":[{"message":{"tool_calls":[{"id":"c2","function":{"name":"weather","arguments":"{\"city\":"}}]}}]}`)
+
+	r := ExtractTools(req, resp)
+	if len(r.Declared) != 1 || r.Declared[0] != "weather" || len(r.Calls) != 2 {
+		t.Fatalf("report = %+v", r)
+	}
+	first := r.Calls[0]
+	if first.Source != SourceRequest || !first.HasResult || first.Result != "sunny" || first.Arguments.(map[string]interface{})["city"] != "Paris" {
+		t.Errorf("first = %+v", first)
+	}
+	second := r.Calls[1]
+	if second.Source != SourceResponse || second.HasResult || second.Arguments != `{"city":` {
+		t.Errorf("second = %+v", second)
+	}
+}
+
+func TestExtractToolsAnthropicAndGemini(t *testing.T) {
+	req := decode(t, `{"tools":[{"name":"search"}],"messages":[
+		{"role":"assistant","content":[{"type":"tool_use","id":"t1","name":"search","input":{"q":"go"}}]},
+		{"role":"user","content":[{"type":"tool_result","tool_use_id":"t1","content":"found"}]}]}`)
+	r := ExtractTools(req, decode(t, `{"type":"message","content":[{"type":"text","text":"ok"}]}`))
+	if len(r.Calls) != 1 || r.Calls[0].Result != "found" {
+		t.Fatalf("anthropic = %+v", r)
+	}
+
+	req = decode(t, `{"tools":[{"functionDeclarations":[{"name":"calc"}]}],"contents":[
+		{"role":"model","parts":[{"functionCall":{"name":"calc","args":{"x":1}}}]},
+		{"role":"user","parts":[{"functionResponse":{"name":"calc","response":{"y":2}}}]}]}`)
+	r = ExtractTools(req, nil)
+	if len(r.Declared) != 1 || len(r.Calls) != 1 || !r.Calls[0].HasResult {
+		t.Fatalf("gemini = %+v", r)
+	}
+}
@@ -0,0 +1,54 @@
+package llm
+
+// Usage is the token accounting a provider reports in a response body.
+type Usage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+// ExtractUsage returns the token usage of a (non-streaming or merged)
+// response body. Cached and reasoning tokens are folded into input and output
+// respectively. It returns false when the body reports no usage.
+func ExtractUsage(body map[string]interface{}) (Usage, bool) {
+	var u Usage
+	switch {
+	case asMap(body["usage"]) != nil:
+		m := asMap(body["usage"])
+		if _, ok := m["prompt_tokens"]; ok {
+			// OpenAI chat/completions.
+			u.InputTokens = toInt(m["prompt_tokens"])
+			u.OutputTokens = toInt(m["completion_tokens"])
+		} else {
+			// OpenAI responses and Anthropic; Anthropic reports cache hits
+			// separately from input_tokens.
+			u.InputTokens = toInt(m["input_tokens"]) + toInt(m["cache_creation_input_tokens"]) + toInt(m["cache_read_input_tokens"])
+			u.OutputTokens = toInt(m["output_tokens"])
+		}
+		u.TotalTokens = toInt(m["total_tokens"])
+	case asMap(body["usageMetadata"]) != nil:
+		m := asMap(body["usageMetadata"])
+		u.InputTokens = toInt(m["promptTokenCount"])
+		u.OutputTokens = toInt(m["candidatesTokenCount"]) + toInt(m["thoughtsTokenCount"])
+		u.TotalTokens = toInt(m["totalTokenCount"])
+	case body["eval_count"] != nil || body["prompt_eval_count"] != nil:
+		// Ollama.
+		u.InputTokens = toInt(body["prompt_eval_count"])
+		u.OutputTokens = toInt(body["eval_count"])
+	default:
+		return u, false
+	}
+	if u.TotalTokens == 0 {
+		u.TotalTokens = u.InputTokens + u.OutputTokens
+	}
+	return u, true
+}
+
+// ExtractModel returns the model named in a request or response body
+// ("model", or Gemini's "modelVersion").
+func ExtractModel(body map[string]interface{}) string {
+	if m := str(body["model"]); m != "" {
+		return m
+	}
+	return str(body["modelVersion"])
+}
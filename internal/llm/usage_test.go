@@ -0,0 +1,33 @@
+package llm
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestExtractUsage(t *testing.T) {
+	cases := []struct {
+		name, body string
+		want       Usage
+	}{
+		{"openai chat", `{"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`, Usage{10, 5, 15}},
+		{"responses", `{"usage":{"input_tokens":8,"output_tokens":2,"total_tokens":10}}`, Usage{8, 2, 10}},
+		{"anthropic cache", `{"usage":{"input_tokens":3,"cache_read_input_tokens":100,"output_tokens":7}}`, Usage{103, 7, 110}},
+		{"gemini", `{"usageMetadata":{"promptTokenCount":4,"candidatesTokenCount":6,"thoughtsTokenCount":2,"totalTokenCount":12}}`, Usage{4, 8, 12}},
+		{"ollama", `{"done":true,"prompt_eval_count":9,"eval_count":1}`, Usage{9, 1, 10}},
+	}
+	for _, c := range cases {
+		var body map[string]interface{}
+		if err := json.Unmarshal([]byte(c.body), &body); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		got, ok := ExtractUsage(body)
+		if !ok || got != c.want {
+			t.Errorf("%s: got %+v ok=%v, want %+v", c.name, got, ok, c.want)
+		}
+	}
+
+	if _, ok := ExtractUsage(map[string]interface{}{"choices": []interface{}{}}); ok {
+		t.Error("body without usage should not report any")
+	}
+}
@@ -0,0 +1,148 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+)
+
+// probeTimeout bounds each local server probe; local servers answer fast
+// or not at all.
+const probeTimeout = time.Second
+
+// LocalServer is a model server found on this machine.
+type LocalServer struct {
+	// Type is the upstream type preset, "ollama" or "lmstudio".
+	Type   string   `json:"type"`
+	Target string   `json:"target"`
+	Models []string `json:"models"`
+	// Upstream names the configured upstream targeting this server, if any.
+	Upstream string `json:"upstream,omitempty"`
+}
+
+// localCandidates are the addresses probed by DiscoverLocal.
+var localCandidates = []struct{ typ, target string }{
+	{config.UpstreamTypeOllama, config.OllamaDefaultTarget},
+	{config.UpstreamTypeLMStudio, config.LMStudioDefaultTarget},
+}
+
+// DiscoverLocal probes the standard Ollama and LM Studio addresses and
+// returns the servers that answered their model listing endpoint. Upstream
+// is filled in from upstreams.
+func DiscoverLocal(ctx context.Context, upstreams map[string]config.UpstreamConfig) []LocalServer {
+	found := make([]*LocalServer, len(localCandidates))
+	client := &http.Client{Timeout: probeTimeout}
+	var wg sync.WaitGroup
+	for i, c := range localCandidates {
+		wg.Add(1)
+		go func(i int, typ, target string) {
+			defer wg.Done()
+			if list, err := probe(ctx, client, typ, target); err == nil {
+				found[i] = &LocalServer{Type: typ, Target: target, Models: list}
+			}
+		}(i, c.typ, c.target)
+	}
+	wg.Wait()
+
+	out := []LocalServer{}
+	for _, s := range found {
+		if s == nil {
+			continue
+		}
+		for name, up := range upstreams {
+			if sameServer(up.Target, s.Target) {
+				s.Upstream = name
+				break
+			}
+		}
+		out = append(out, *s)
+	}
+	return out
+}
+
+func probe(ctx context.Context, client *http.Client, typ, target string) ([]string, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	endpoint, _ := listEndpoint(u, typ)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxListBytes))
+	if err != nil {
+		return nil, err
+	}
+	list, err := parseList(typ, body)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(list))
+	for i, m := range list {
+		ids[i] = m.ID
+	}
+	return ids, nil
+}
+
+// sameServer reports whether two targets point at the same local server,
+// treating localhost and 127.0.0.1 alike.
+func sameServer(a, b string) bool {
+	ua, err1 := url.Parse(a)
+	ub, err2 := url.Parse(b)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	return ua.Scheme == ub.Scheme && loopbackHost(ua.Hostname()) == loopbackHost(ub.Hostname()) && ua.Port() == ub.Port()
+}
+
+func loopbackHost(h string) string {
+	switch strings.ToLower(h) {
+	case "localhost", "127.0.0.1", "::1":
+		return "loopback"
+	}
+	return h
+}
+
+// RegisterLocal adds an upstream for each server not already targeted by
+// one, named after its type ("ollama", "lmstudio", or with a numeric suffix
+// when the name is taken). It returns the servers with Upstream filled in.
+// The config is updated in memory only; callers save it if needed.
+func RegisterLocal(cfg *config.Config, servers []LocalServer) []LocalServer {
+	existing := cfg.ListUpstreams()
+	out := make([]LocalServer, 0, len(servers))
+	for _, s := range servers {
+		if s.Upstream == "" {
+			name := s.Type
+			for i := 2; ; i++ {
+				if _, taken := existing[name]; !taken {
+					break
+				}
+				name = s.Type + "-" + strconv.Itoa(i)
+			}
+			up := config.UpstreamConfig{Type: s.Type, Target: s.Target}
+			if err := cfg.AddUpstream(name, up); err == nil {
+				existing[name] = up
+				s.Upstream = name
+			}
+		}
+		out = append(out, s)
+	}
+	return out
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prismcat/prismcat/internal/config"
+)
+
+func TestDiscoverAndRegisterLocal(t *testing.T) {
+	ollama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/tags" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"models":[{"name":"llama3.2:latest","model":"llama3.2:latest"}]}`))
+	}))
+	defer ollama.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	saved := localCandidates
+	defer func() { localCandidates = saved }()
+	localCandidates = []struct{ typ, target string }{
+		{config.UpstreamTypeOllama, ollama.URL},
+		{config.UpstreamTypeLMStudio, down.URL},
+	}
+
+	cfg := &config.Config{Upstreams: map[string]config.UpstreamConfig{
+		"ollama": {Target: "https://api.openai.com"}, // name taken by an unrelated upstream
+	}}
+	found := DiscoverLocal(context.Background(), cfg.ListUpstreams())
+	if len(found) != 1 || found[0].Type != config.UpstreamTypeOllama || len(found[0].Models) != 1 || found[0].Upstream != "" {
+		t.Fatalf("found = %+v", found)
+	}
+
+	registered := RegisterLocal(cfg, found)
+	up, ok := cfg.GetUpstream("ollama-2")
+	if registered[0].Upstream != "ollama-2" || !ok || up.Type != config.UpstreamTypeOllama || up.Target != ollama.URL {
+		t.Fatalf("registered = %+v, upstream = %+v", registered, up)
+	}
+
+	// Already configured servers are reported, not registered again.
+	found = DiscoverLocal(context.Background(), cfg.ListUpstreams())
+	if len(found) != 1 || found[0].Upstream != "ollama-2" {
+		t.Fatalf("second discovery = %+v", found)
+	}
+}
@@ -0,0 +1,254 @@
+// Package models enumerates the models offered by configured upstreams. Each
+// provider's listing endpoint (OpenAI-style /v1/models, Anthropic, Gemini,
+// Ollama, Azure OpenAI) is queried and the results are merged into one cached catalog.
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/azure"
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/demo"
+)
+
+// fetchTimeout bounds each upstream listing request.
+const fetchTimeout = 15 * time.Second
+
+// maxListBytes caps a listing response body.
+const maxListBytes = 10 << 20
+
+// Model is one entry of the merged catalog, in the OpenAI list format plus
+// the upstream that serves it.
+type Model struct {
+	ID          string `json:"id"`
+	Object      string `json:"object"`
+	OwnedBy     string `json:"owned_by,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+	Upstream    string `json:"upstream"`
+}
+
+// UpstreamStatus reports how one upstream's listing was obtained.
+type UpstreamStatus struct {
+	Name      string    `json:"name"`
+	Models    int       `json:"models"`
+	FetchedAt time.Time `json:"fetched_at,omitempty"`
+	Cached    bool      `json:"cached"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Catalog fetches and caches model listings per upstream. Failed fetches
+// are not cached.
+type Catalog struct {
+	client *http.Client
+	demo   *http.Client
+	ttl    time.Duration
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]entry // by upstream name
+}
+
+type entry struct {
+	target  string // cache key: a changed target invalidates the entry
+	models  []Model
+	fetched time.Time
+}
+
+// New returns a catalog that queries upstreams with client and keeps
+// listings for ttl.
+func New(client *http.Client, ttl time.Duration) *Catalog {
+	return &Catalog{
+		client:  client,
+		demo:    &http.Client{Transport: &demo.Transport{}},
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]entry),
+	}
+}
+
+// List returns the merged models of upstreams, sorted by upstream and ID,
+// with one status per upstream. Upstreams are queried concurrently; refresh
+// bypasses the cache.
+func (c *Catalog) List(ctx context.Context, upstreams map[string]config.UpstreamConfig, refresh bool) ([]Model, []UpstreamStatus) {
+	names := make([]string, 0, len(upstreams))
+	for name := range upstreams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	statuses := make([]UpstreamStatus, len(names))
+	lists := make([][]Model, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			lists[i], statuses[i] = c.upstream(ctx, name, upstreams[name], refresh)
+		}(i, name)
+	}
+	wg.Wait()
+
+	all := []Model{}
+	for _, l := range lists {
+		all = append(all, l...)
+	}
+	return all, statuses
+}
+
+func (c *Catalog) upstream(ctx context.Context, name string, up config.UpstreamConfig, refresh bool) ([]Model, UpstreamStatus) {
+	status := UpstreamStatus{Name: name}
+
+	c.mu.Lock()
+	e, ok := c.entries[name]
+	c.mu.Unlock()
+	if ok && !refresh && e.target == up.Target && c.now().Sub(e.fetched) < c.ttl {
+		status.Models, status.FetchedAt, status.Cached = len(e.models), e.fetched, true
+		return e.models, status
+	}
+
+	list, err := c.fetch(ctx, name, up)
+	if err != nil {
+		status.Error = err.Error()
+		return nil, status
+	}
+	e = entry{target: up.Target, models: list, fetched: c.now()}
+	c.mu.Lock()
+	c.entries[name] = e
+	c.mu.Unlock()
+	status.Models, status.FetchedAt = len(list), e.fetched
+	return list, status
+}
+
+func (c *Catalog) fetch(ctx context.Context, name string, up config.UpstreamConfig) ([]Model, error) {
+	if up.Maintenance.Enabled {
+		return nil, errors.New("upstream is in maintenance")
+	}
+	if up.Type == config.UpstreamTypeBedrock || up.Type == config.UpstreamTypeVertexAI {
+		return nil, fmt.Errorf("model listing is not supported for %s upstreams", up.Type)
+	}
+	target, err := url.Parse(up.Target)
+	if err != nil || target.Host == "" {
+		return nil, fmt.Errorf("invalid target %q", up.Target)
+	}
+	endpoint, headers := listEndpoint(target, up.Type)
+	if up.Type == config.UpstreamTypeAzureOpenAI {
+		u, err := azure.RequestURL(target, &url.URL{Path: "/openai/models"}, "", up.Azure)
+		if err != nil {
+			return nil, err
+		}
+		endpoint, headers = u.String(), nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	// Credentials come from the upstream's default headers, as for proxied
+	// requests that don't carry their own.
+	for k, v := range up.DefaultHeaders {
+		req.Header.Set(k, v)
+	}
+	if up.UserAgent != "" {
+		req.Header.Set("User-Agent", up.UserAgent)
+	}
+	if up.Type == config.UpstreamTypeAzureOpenAI {
+		azure.ApplyAuth(req.Header, up.Azure)
+	}
+
+	client := c.client
+	if up.Type == config.UpstreamTypeDemo {
+		client = c.demo
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxListBytes))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: HTTP %d", req.URL.Path, resp.StatusCode)
+	}
+	return parseList(name, body)
+}
+
+// listEndpoint returns the model listing URL for target and the headers the
+// provider requires. typ is the upstream type.
+func listEndpoint(target *url.URL, typ string) (string, map[string]string) {
+	base := *target
+	base.RawQuery, base.Fragment = "", ""
+	base.Path = strings.TrimSuffix(base.Path, "/")
+	host := strings.ToLower(base.Hostname())
+
+	var headers map[string]string
+	q := url.Values{}
+	switch {
+	case strings.HasSuffix(host, "generativelanguage.googleapis.com"):
+		base.Path += "/v1beta/models"
+		q.Set("pageSize", "1000")
+	case typ == config.UpstreamTypeOllama || typ == "" && base.Port() == "11434":
+		base.Path += "/api/tags" // Ollama
+	case strings.HasSuffix(host, "anthropic.com"):
+		base.Path += "/v1/models"
+		q.Set("limit", "1000")
+		headers = map[string]string{"anthropic-version": "2023-06-01"}
+	case strings.HasSuffix(base.Path, "/v1"):
+		base.Path += "/models"
+	default:
+		base.Path += "/v1/models"
+	}
+	base.RawQuery = q.Encode()
+	return base.String(), headers
+}
+
+// parseList reads the OpenAI/Anthropic ("data") and Gemini/Ollama
+// ("models") listing formats.
+func parseList(upstream string, body []byte) ([]Model, error) {
+	var doc struct {
+		Data []struct {
+			ID          string `json:"id"`
+			OwnedBy     string `json:"owned_by"`
+			DisplayName string `json:"display_name"`
+		} `json:"data"`
+		Models []struct {
+			Name        string `json:"name"`
+			DisplayName string `json:"displayName"`
+		} `json:"models"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("invalid model list: %v", err)
+	}
+
+	out := make([]Model, 0, len(doc.Data)+len(doc.Models))
+	for _, m := range doc.Data {
+		if m.ID != "" {
+			out = append(out, Model{ID: m.ID, Object: "model", OwnedBy: m.OwnedBy, DisplayName: m.DisplayName, Upstream: upstream})
+		}
+	}
+	for _, m := range doc.Models {
+		if m.Name != "" {
+			id := strings.TrimPrefix(m.Name, "models/")
+			out = append(out, Model{ID: id, Object: "model", DisplayName: m.DisplayName, Upstream: upstream})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+)
+
+func TestCatalogList(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if r.URL.Path != "/v1/models" || r.Header.Get("Authorization") != "Bearer sk-test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"object":"list","data":[{"id":"gpt-b","owned_by":"acme"},{"id":"gpt-a"}]}`))
+	}))
+	defer srv.Close()
+
+	c := New(srv.Client(), time.Minute)
+	upstreams := map[string]config.UpstreamConfig{
+		"acme":   {Target: srv.URL, DefaultHeaders: map[string]string{"Authorization": "Bearer sk-test"}},
+		"demo":   {Type: config.UpstreamTypeDemo, Target: config.DemoTarget},
+		"noauth": {Target: srv.URL},
+	}
+	list, statuses := c.List(context.Background(), upstreams, false)
+	if len(list) != 5 || list[0].ID != "gpt-a" || list[0].Upstream != "acme" || list[2].Upstream != "demo" {
+		t.Fatalf("list = %+v", list)
+	}
+	if statuses[0].Models != 2 || statuses[1].Models != 3 || statuses[2].Error == "" {
+		t.Fatalf("statuses = %+v", statuses)
+	}
+
+	_, statuses = c.List(context.Background(), upstreams, false)
+	if !statuses[0].Cached || hits.Load() != 3 {
+		t.Fatalf("second list: statuses = %+v, hits = %d", statuses, hits.Load())
+	}
+	_, statuses = c.List(context.Background(), upstreams, true)
+	if statuses[0].Cached || hits.Load() != 5 {
+		t.Fatalf("refresh: statuses = %+v, hits = %d", statuses, hits.Load())
+	}
+}
+
+func TestListEndpoint(t *testing.T) {
+	for target, want := range map[string]string{
+		"https://api.openai.com":                    "https://api.openai.com/v1/models",
+		"https://openrouter.ai/api/v1/":             "https://openrouter.ai/api/v1/models",
+		"https://api.anthropic.com":                 "https://api.anthropic.com/v1/models?limit=1000",
+		"https://generativelanguage.googleapis.com": "https://generativelanguage.googleapis.com/v1beta/models?pageSize=1000",
+		"http://localhost:11434":                    "http://localhost:11434/api/tags",
+	} {
+		u, _ := url.Parse(target)
+		if got, _ := listEndpoint(u, ""); got != want {
+			t.Errorf("%s: got %s, want %s", target, got, want)
+		}
+	}
+	u, _ := url.Parse("http://gpu-box:8000")
+	if got, _ := listEndpoint(u, config.UpstreamTypeOllama); got != "http://gpu-box:8000/api/tags" {
+		t.Errorf("ollama type: got %s", got)
+	}
+}
+
+func TestParseListGemini(t *testing.T) {
+	list, err := parseList("gemini", []byte(`{"models":[{"name":"models/gemini-2.0-flash","displayName":"Gemini 2.0 Flash"}]}`))
+	if err != nil || len(list) != 1 || list[0].ID != "gemini-2.0-flash" || list[0].DisplayName != "Gemini 2.0 Flash" {
+		t.Fatalf("list = %+v, err = %v", list, err)
+	}
+}
@@ -0,0 +1,183 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/proxy"
+)
+
+// calloutSummary is the JSON document POSTed to an external processing hook.
+type calloutSummary struct {
+	Upstream  string              `json:"upstream"`
+	Method    string              `json:"method"`
+	Path      string              `json:"path"`
+	Query     string              `json:"query,omitempty"`
+	Headers   map[string][]string `json:"headers"`
+	BodySize  int64               `json:"body_size"`
+	Body      string              `json:"body,omitempty"`
+	Truncated bool                `json:"body_truncated,omitempty"`
+	RequestID string              `json:"request_id,omitempty"`
+}
+
+// maxCalloutResponse bounds how much of a callout verdict is read.
+const maxCalloutResponse = 64 << 10
+
+// CalloutMiddleware asks an external HTTP hook for a verdict before forwarding.
+//
+// The hook receives a calloutSummary and answers with the same JSON document
+// WASM plugins return (action "allow"/"continue" or "deny", status, message,
+// set_headers, remove_headers). Sensitive headers are never sent to the hook.
+type CalloutMiddleware struct {
+	proxy.BaseMiddleware
+
+	name      string
+	url       string
+	upstreams map[string]bool
+	timeout   time.Duration
+	failOpen  bool
+	withBody  bool
+	maxBody   int64
+	headers   map[string]string
+	sensitive []string
+	client    *http.Client
+}
+
+// NewCallouts builds callout middlewares from config. sensitiveHeaders are
+// stripped from summaries.
+func NewCallouts(defs []config.CalloutConfig, sensitiveHeaders []string) ([]*CalloutMiddleware, error) {
+	var out []*CalloutMiddleware
+	for _, d := range defs {
+		if d.URL == "" {
+			return nil, fmt.Errorf("callout %q: url is required", d.Name)
+		}
+		m := &CalloutMiddleware{
+			name:      d.Name,
+			url:       d.URL,
+			timeout:   time.Duration(d.TimeoutMs) * time.Millisecond,
+			failOpen:  d.FailOpen,
+			withBody:  d.IncludeBody,
+			maxBody:   d.MaxBodyBytes,
+			headers:   d.Headers,
+			sensitive: sensitiveHeaders,
+		}
+		if m.timeout <= 0 {
+			m.timeout = 200 * time.Millisecond
+		}
+		if m.maxBody <= 0 {
+			m.maxBody = 64 << 10
+		}
+		if len(d.Upstreams) > 0 {
+			m.upstreams = make(map[string]bool, len(d.Upstreams))
+			for _, u := range d.Upstreams {
+				m.upstreams[strings.ToLower(strings.TrimSpace(u))] = true
+			}
+		}
+		m.client = &http.Client{Timeout: m.timeout}
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+// Name returns the callout name.
+func (m *CalloutMiddleware) Name() string { return m.name }
+
+func (m *CalloutMiddleware) OnRequest(ex *proxy.Exchange, req *http.Request) error {
+	if m.upstreams != nil && !m.upstreams[ex.Upstream] {
+		return nil
+	}
+
+	verdict, err := m.call(ex, req)
+	if err != nil {
+		if m.failOpen {
+			log.Printf("callout %q failed (fail-open): %v", m.name, err)
+			return nil
+		}
+		return proxy.Reject(http.StatusServiceUnavailable, fmt.Sprintf("callout %s unavailable", m.name))
+	}
+	if rej := verdict.rejection(m.name); rej != nil {
+		return rej
+	}
+	verdict.applyHeaders(req.Header)
+	return nil
+}
+
+func (m *CalloutMiddleware) call(ex *proxy.Exchange, req *http.Request) (*pluginOutput, error) {
+	summary := calloutSummary{
+		Upstream: ex.Upstream,
+		Method:   req.Method,
+		Path:     req.URL.Path,
+		Query:    req.URL.RawQuery,
+		Headers:  make(map[string][]string, len(req.Header)),
+		BodySize: req.ContentLength,
+	}
+	if ex.Log != nil {
+		summary.RequestID = ex.Log.ID
+	}
+	for k, vv := range req.Header {
+		if !m.isSensitive(k) {
+			summary.Headers[k] = vv
+		}
+	}
+	if m.withBody {
+		if body, err := ex.RequestBody(req); err == nil {
+			summary.BodySize = int64(len(body))
+			if int64(len(body)) > m.maxBody {
+				body = body[:m.maxBody]
+				summary.Truncated = true
+			}
+			summary.Body = string(body)
+		}
+	}
+
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return nil, err
+	}
+	creq, err := http.NewRequestWithContext(req.Context(), http.MethodPost, m.url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	creq.Header.Set("Content-Type", "application/json")
+	for k, v := range m.headers {
+		creq.Header.Set(k, v)
+	}
+
+	resp, err := m.client.Do(creq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxCalloutResponse))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("hook returned HTTP %d", resp.StatusCode)
+	}
+
+	var out pluginOutput
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return &out, nil
+	}
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, fmt.Errorf("invalid verdict: %w", err)
+	}
+	return &out, nil
+}
+
+func (m *CalloutMiddleware) isSensitive(name string) bool {
+	for _, s := range m.sensitive {
+		if strings.EqualFold(name, s) {
+			return true
+		}
+	}
+	return false
+}
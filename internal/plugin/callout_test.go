@@ -0,0 +1,72 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/proxy"
+)
+
+func TestCalloutVerdict(t *testing.T) {
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var s calloutSummary
+		_ = json.NewDecoder(r.Body).Decode(&s)
+		if _, leaked := s.Headers["Authorization"]; leaked {
+			t.Errorf("sensitive header sent to hook")
+		}
+		if strings.Contains(s.Body, "forbidden") {
+			_, _ = w.Write([]byte(`{"action":"deny","status":403,"message":"nope"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"action":"allow","set_headers":{"X-Checked":"1"}}`))
+	}))
+	defer hook.Close()
+
+	cs, err := NewCallouts([]config.CalloutConfig{{Name: "hook", URL: hook.URL, IncludeBody: true}}, []string{"Authorization"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := cs[0]
+
+	req := httptest.NewRequest(http.MethodPost, "http://up/v1", strings.NewReader("hello"))
+	req.Header.Set("Authorization", "Bearer sk-secret")
+	if err := m.OnRequest(&proxy.Exchange{Upstream: "up"}, req); err != nil {
+		t.Fatalf("OnRequest: %v", err)
+	}
+	if req.Header.Get("X-Checked") != "1" {
+		t.Fatalf("verdict headers not applied")
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "http://up/v1", strings.NewReader("forbidden"))
+	err = m.OnRequest(&proxy.Exchange{Upstream: "up"}, req)
+	var rej *proxy.RejectError
+	if !errors.As(err, &rej) || rej.StatusCode != 403 || rej.Message != "nope" {
+		t.Fatalf("err = %v, want 403 nope", err)
+	}
+}
+
+func TestCalloutFailureModes(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slow.Close()
+
+	for _, failOpen := range []bool{true, false} {
+		cs, _ := NewCallouts([]config.CalloutConfig{{Name: "slow", URL: slow.URL, TimeoutMs: 20, FailOpen: failOpen}}, nil)
+		req := httptest.NewRequest(http.MethodGet, "http://up/", nil)
+		err := cs[0].OnRequest(&proxy.Exchange{Upstream: "up"}, req)
+		if failOpen && err != nil {
+			t.Fatalf("fail-open returned %v", err)
+		}
+		var rej *proxy.RejectError
+		if !failOpen && (!errors.As(err, &rej) || rej.StatusCode != http.StatusServiceUnavailable) {
+			t.Fatalf("fail-closed returned %v, want 503", err)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+// Package plugin loads user-provided extensions into the proxy pipeline.
+//
+// WASM plugins run inside a sandboxed runtime (wazero) and can transform
+// requests/responses or veto forwarding. The runtime is only compiled in with
+// the "prismcat_wasm" build tag (not "wasm", which GOARCH=wasm implies):
+//
+//	go build -tags prismcat_wasm ./cmd/prismcat
+//
+// Without the tag, configuring a WASM plugin is reported as an error at startup.
+//
+// # ABI
+//
+// A module must export:
+//
+//	alloc(size i32) -> i32                  allocate an input buffer
+//	on_request(ptr i32, len i32) -> i64     optional
+//	on_response(ptr i32, len i32) -> i64    optional
+//
+// Hooks receive a JSON document (see pluginInput) and return (ptr<<32 | len)
+// pointing at a JSON pluginOutput in module memory. An optional
+// dealloc(ptr i32, size i32) export is called to free the input buffer.
+//
+// A call that outlives its timeout, or whose request is canceled, is
+// interrupted; the module is then instantiated afresh for the next call, so
+// state kept in module memory across calls may be lost.
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/proxy"
+)
+
+// wasmInstance is implemented by the runtime-specific backend (wasm_wazero.go).
+type wasmInstance interface {
+	HasExport(name string) bool
+	Call(ctx context.Context, export string, input []byte) ([]byte, error)
+	Close(ctx context.Context) error
+}
+
+// pluginInput is passed to on_request / on_response.
+type pluginInput struct {
+	Phase      string              `json:"phase"`
+	Upstream   string              `json:"upstream"`
+	Method     string              `json:"method"`
+	URL        string              `json:"url"`
+	StatusCode int                 `json:"status_code,omitempty"`
+	Headers    map[string][]string `json:"headers"`
+	Body       string              `json:"body"`
+}
+
+// pluginOutput is returned by on_request / on_response.
+type pluginOutput struct {
+	// Action is "continue" (default) or "deny".
+	Action  string `json:"action"`
+	Status  int    `json:"status,omitempty"`
+	Message string `json:"message,omitempty"`
+
+	SetHeaders    map[string]string `json:"set_headers,omitempty"`
+	RemoveHeaders []string          `json:"remove_headers,omitempty"`
+	// Body replaces the request/response body when non-nil.
+	Body *string `json:"body,omitempty"`
+}
+
+// maxResponseTransformBytes bounds how much of a non-streaming response is
+// buffered for on_response.
+const maxResponseTransformBytes = 8 << 20
+
+// WASMMiddleware runs a WASM module as a proxy.Middleware.
+type WASMMiddleware struct {
+	proxy.BaseMiddleware
+
+	name      string
+	upstreams map[string]bool
+	timeout   time.Duration
+	inst      wasmInstance
+}
+
+// LoadWASM instantiates all configured WASM plugins.
+func LoadWASM(ctx context.Context, defs []config.WASMPluginConfig) ([]*WASMMiddleware, error) {
+	var out []*WASMMiddleware
+	for _, d := range defs {
+		if d.Path == "" {
+			return nil, fmt.Errorf("wasm plugin %q: path is required", d.Name)
+		}
+		code, err := os.ReadFile(d.Path)
+		if err != nil {
+			return nil, fmt.Errorf("wasm plugin %q: %w", d.Name, err)
+		}
+		inst, err := compileWASM(ctx, code)
+		if err != nil {
+			return nil, fmt.Errorf("wasm plugin %q: %w", d.Name, err)
+		}
+		out = append(out, newWASMMiddleware(d, inst))
+	}
+	return out, nil
+}
+
+func newWASMMiddleware(d config.WASMPluginConfig, inst wasmInstance) *WASMMiddleware {
+	m := &WASMMiddleware{
+		name:    d.Name,
+		timeout: time.Duration(d.TimeoutMs) * time.Millisecond,
+		inst:    inst,
+	}
+	if m.timeout <= 0 {
+		m.timeout = 200 * time.Millisecond
+	}
+	if len(d.Upstreams) > 0 {
+		m.upstreams = make(map[string]bool, len(d.Upstreams))
+		for _, u := range d.Upstreams {
+			m.upstreams[strings.ToLower(strings.TrimSpace(u))] = true
+		}
+	}
+	return m
+}
+
+// Name returns the plugin name.
+func (m *WASMMiddleware) Name() string { return m.name }
+
+// Close releases the WASM runtime.
+func (m *WASMMiddleware) Close(ctx context.Context) error { return m.inst.Close(ctx) }
+
+func (m *WASMMiddleware) applies(upstream string) bool {
+	return m.upstreams == nil || m.upstreams[upstream]
+}
+
+func (m *WASMMiddleware) call(ctx context.Context, export string, in pluginInput) (*pluginOutput, error) {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	raw, err := m.inst.Call(ctx, export, data)
+	if err != nil {
+		return nil, fmt.Errorf("wasm plugin %q %s: %w", m.name, export, err)
+	}
+	var out pluginOutput
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return &out, nil
+	}
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, fmt.Errorf("wasm plugin %q %s: invalid output: %w", m.name, export, err)
+	}
+	return &out, nil
+}
+
+func (m *WASMMiddleware) OnRequest(ex *proxy.Exchange, req *http.Request) error {
+	if !m.applies(ex.Upstream) || !m.inst.HasExport("on_request") {
+		return nil
+	}
+	body, err := ex.RequestBody(req)
+	if err != nil {
+		return proxy.Reject(http.StatusRequestEntityTooLarge, err.Error())
+	}
+
+	out, err := m.call(req.Context(), "on_request", pluginInput{
+		Phase:    "request",
+		Upstream: ex.Upstream,
+		Method:   req.Method,
+		URL:      req.URL.String(),
+		Headers:  req.Header,
+		Body:     string(body),
+	})
+	if err != nil {
+		return err
+	}
+	if rej := out.rejection(m.name); rej != nil {
+		return rej
+	}
+	out.applyHeaders(req.Header)
+	if out.Body != nil {
+		ex.SetRequestBody(req, []byte(*out.Body))
+	}
+	return nil
+}
+
+func (m *WASMMiddleware) OnResponse(ex *proxy.Exchange, resp *http.Response) error {
+	if !m.applies(ex.Upstream) || !m.inst.HasExport("on_response") {
+		return nil
+	}
+	// Streaming bodies are forwarded as they arrive; only headers/status can be
+	// inspected for them. Compressed bodies are passed through opaque.
+	in := pluginInput{
+		Phase:      "response",
+		Upstream:   ex.Upstream,
+		Method:     resp.Request.Method,
+		URL:        resp.Request.URL.String(),
+		StatusCode: resp.StatusCode,
+		Headers:    resp.Header,
+	}
+	buffer := resp.ContentLength >= 0 && resp.ContentLength <= maxResponseTransformBytes &&
+		resp.Header.Get("Content-Encoding") == "" &&
+		!strings.Contains(strings.ToLower(resp.Header.Get("Content-Type")), "event-stream")
+	if buffer {
+		data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseTransformBytes))
+		if err != nil {
+			return err
+		}
+		_ = resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(data))
+		in.Body = string(data)
+	}
+
+	out, err := m.call(resp.Request.Context(), "on_response", in)
+	if err != nil {
+		return err
+	}
+	if rej := out.rejection(m.name); rej != nil {
+		return rej
+	}
+	out.applyHeaders(resp.Header)
+	if out.Body != nil && buffer {
+		resp.Body = io.NopCloser(strings.NewReader(*out.Body))
+		resp.ContentLength = int64(len(*out.Body))
+		resp.Header.Set("Content-Length", fmt.Sprint(len(*out.Body)))
+	}
+	return nil
+}
+
+func (o *pluginOutput) rejection(name string) *proxy.RejectError {
+	if !strings.EqualFold(o.Action, "deny") {
+		return nil
+	}
+	status := o.Status
+	if status == 0 {
+		status = http.StatusForbidden
+	}
+	msg := o.Message
+	if msg == "" {
+		msg = "denied by plugin " + name
+	}
+	return proxy.Reject(status, msg)
+}
+
+func (o *pluginOutput) applyHeaders(h http.Header) {
+	for _, k := range o.RemoveHeaders {
+		h.Del(k)
+	}
+	for k, v := range o.SetHeaders {
+		h.Set(k, v)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !prismcat_wasm
+
+package plugin
+
+import (
+	"context"
+	"errors"
+)
+
+// errWASMUnsupported is returned when the binary was built without the prismcat_wasm tag.
+var errWASMUnsupported = errors.New("wasm plugins are not supported by this build (rebuild with -tags prismcat_wasm)")
+
+func compileWASM(ctx context.Context, code []byte) (wasmInstance, error) {
+	return nil, errWASMUnsupported
+}
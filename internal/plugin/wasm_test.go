@@ -0,0 +1,75 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/proxy"
+)
+
+// fakeInstance emulates a WASM module with a Go function per export.
+type fakeInstance struct {
+	exports map[string]func(in pluginInput) pluginOutput
+}
+
+func (f *fakeInstance) HasExport(name string) bool  { _, ok := f.exports[name]; return ok }
+func (f *fakeInstance) Close(context.Context) error { return nil }
+func (f *fakeInstance) Call(_ context.Context, export string, input []byte) ([]byte, error) {
+	var in pluginInput
+	if err := json.Unmarshal(input, &in); err != nil {
+		return nil, err
+	}
+	return json.Marshal(f.exports[export](in))
+}
+
+func TestWASMMiddlewareDeny(t *testing.T) {
+	m := newWASMMiddleware(config.WASMPluginConfig{Name: "deny"}, &fakeInstance{exports: map[string]func(pluginInput) pluginOutput{
+		"on_request": func(in pluginInput) pluginOutput {
+			if in.Body == "secret" {
+				return pluginOutput{Action: "deny", Status: 451}
+			}
+			return pluginOutput{}
+		},
+	}})
+
+	req := httptest.NewRequest(http.MethodPost, "http://up/", bytes.NewReader([]byte("secret")))
+	err := m.OnRequest(&proxy.Exchange{Upstream: "up"}, req)
+	var rej *proxy.RejectError
+	if !errors.As(err, &rej) || rej.StatusCode != 451 {
+		t.Fatalf("err = %v, want RejectError 451", err)
+	}
+}
+
+func TestWASMMiddlewareRewritesRequest(t *testing.T) {
+	newBody := `{"model":"b"}`
+	m := newWASMMiddleware(config.WASMPluginConfig{Name: "rw", Upstreams: []string{"Up"}}, &fakeInstance{exports: map[string]func(pluginInput) pluginOutput{
+		"on_request": func(in pluginInput) pluginOutput {
+			return pluginOutput{Body: &newBody, SetHeaders: map[string]string{"X-Plugin": "rw"}}
+		},
+	}})
+
+	req := httptest.NewRequest(http.MethodPost, "http://up/", bytes.NewReader([]byte(`{"model":"a"}`)))
+	if err := m.OnRequest(&proxy.Exchange{Upstream: "up"}, req); err != nil {
+		t.Fatalf("OnRequest: %v", err)
+	}
+	var buf bytes.Buffer
+	_, _ = buf.ReadFrom(req.Body)
+	if buf.String() != newBody || req.ContentLength != int64(len(newBody)) {
+		t.Fatalf("body = %q (len %d), want %q", buf.String(), req.ContentLength, newBody)
+	}
+	if req.Header.Get("X-Plugin") != "rw" {
+		t.Fatalf("missing plugin header")
+	}
+
+	// Other upstreams are untouched.
+	other := httptest.NewRequest(http.MethodPost, "http://other/", bytes.NewReader([]byte("x")))
+	if err := m.OnRequest(&proxy.Exchange{Upstream: "other"}, other); err != nil || other.Header.Get("X-Plugin") != "" {
+		t.Fatalf("plugin applied to unrelated upstream")
+	}
+}
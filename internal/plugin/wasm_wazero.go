@@ -0,0 +1,125 @@
+//go:build prismcat_wasm
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// wazeroInstance wraps a single module instance. wazero modules are not safe
+// for concurrent calls, so calls are serialized. An instance interrupted by
+// its call context is closed by the runtime; the next call starts a fresh
+// one from the compiled module (module globals and memory start over).
+type wazeroInstance struct {
+	mu       sync.Mutex
+	rt       wazero.Runtime
+	compiled wazero.CompiledModule
+	mod      api.Module // nil after an interrupted call
+}
+
+func compileWASM(ctx context.Context, code []byte) (wasmInstance, error) {
+	// Close the module when the call context is done so runaway plugins are
+	// interrupted by the per-call timeout.
+	rt := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCloseOnContextDone(true))
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, rt); err != nil {
+		_ = rt.Close(ctx)
+		return nil, fmt.Errorf("instantiate wasi: %w", err)
+	}
+
+	compiled, err := rt.CompileModule(ctx, code)
+	if err != nil {
+		_ = rt.Close(ctx)
+		return nil, fmt.Errorf("compile module: %w", err)
+	}
+	if _, ok := compiled.ExportedFunctions()["alloc"]; !ok {
+		_ = rt.Close(ctx)
+		return nil, fmt.Errorf("module does not export alloc")
+	}
+	w := &wazeroInstance{rt: rt, compiled: compiled}
+	if err := w.instantiate(ctx); err != nil {
+		_ = rt.Close(ctx)
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *wazeroInstance) instantiate(ctx context.Context) error {
+	// Reactor-style modules (TinyGo/Rust cdylib) export _initialize instead of _start.
+	// Anonymous, so a new instance can replace a closed one.
+	modCfg := wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize")
+	mod, err := w.rt.InstantiateModule(ctx, w.compiled, modCfg)
+	if err != nil {
+		return fmt.Errorf("instantiate module: %w", err)
+	}
+	w.mod = mod
+	return nil
+}
+
+func (w *wazeroInstance) HasExport(name string) bool {
+	_, ok := w.compiled.ExportedFunctions()[name]
+	return ok
+}
+
+func (w *wazeroInstance) Call(ctx context.Context, export string, input []byte) ([]byte, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.mod == nil {
+		// _initialize already ran once at load; it is not bound by this call's deadline.
+		if err := w.instantiate(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+	out, err := w.call(ctx, export, input)
+	if err != nil && ctx.Err() != nil {
+		// The runtime closed the instance when ctx ended.
+		_ = w.mod.Close(context.Background())
+		w.mod = nil
+	}
+	return out, err
+}
+
+func (w *wazeroInstance) call(ctx context.Context, export string, input []byte) ([]byte, error) {
+	fn := w.mod.ExportedFunction(export)
+	if fn == nil {
+		return nil, fmt.Errorf("export %q not found", export)
+	}
+
+	res, err := w.mod.ExportedFunction("alloc").Call(ctx, uint64(len(input)))
+	if err != nil {
+		return nil, fmt.Errorf("alloc: %w", err)
+	}
+	ptr := uint32(res[0])
+	if !w.mod.Memory().Write(ptr, input) {
+		return nil, fmt.Errorf("write input: out of range (ptr=%d len=%d)", ptr, len(input))
+	}
+	if dealloc := w.mod.ExportedFunction("dealloc"); dealloc != nil {
+		defer func() { _, _ = dealloc.Call(ctx, uint64(ptr), uint64(len(input))) }()
+	}
+
+	out, err := fn.Call(ctx, uint64(ptr), uint64(len(input)))
+	if err != nil {
+		return nil, err
+	}
+	packed := out[0]
+	outPtr, outLen := uint32(packed>>32), uint32(packed)
+	if outLen == 0 {
+		return nil, nil
+	}
+	view, ok := w.mod.Memory().Read(outPtr, outLen)
+	if !ok {
+		return nil, fmt.Errorf("read output: out of range (ptr=%d len=%d)", outPtr, outLen)
+	}
+	// The view aliases module memory; copy before the next call mutates it.
+	return append([]byte(nil), view...), nil
+}
+
+func (w *wazeroInstance) Close(ctx context.Context) error {
+	return w.rt.Close(ctx)
+}
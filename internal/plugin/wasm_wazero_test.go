@@ -0,0 +1,58 @@
+//go:build prismcat_wasm
+
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+)
+
+// spinModule exports memory, alloc (always 1024), on_request (returns no
+// output) and spin (loops forever).
+var spinModule = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, 0x01, 0x0c, 0x02, 0x60, 0x01, 0x7f, 0x01, 0x7f,
+	0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7e, 0x03, 0x04, 0x03, 0x00, 0x01, 0x01, 0x05, 0x03, 0x01, 0x00,
+	0x01, 0x07, 0x26, 0x04, 0x06, 0x6d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x02, 0x00, 0x05, 0x61, 0x6c,
+	0x6c, 0x6f, 0x63, 0x00, 0x00, 0x0a, 0x6f, 0x6e, 0x5f, 0x72, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x00, 0x01, 0x04, 0x73, 0x70, 0x69, 0x6e, 0x00, 0x02, 0x0a, 0x16, 0x03, 0x05, 0x00, 0x41, 0x80,
+	0x08, 0x0b, 0x04, 0x00, 0x42, 0x00, 0x0b, 0x09, 0x00, 0x03, 0x40, 0x0c, 0x00, 0x0b, 0x42, 0x00,
+	0x0b,
+}
+
+func TestWazeroCallAfterTimeout(t *testing.T) {
+	ctx := context.Background()
+	inst, err := compileWASM(ctx, spinModule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inst.Close(ctx)
+	m := newWASMMiddleware(config.WASMPluginConfig{Name: "spin", TimeoutMs: 20}, inst)
+
+	start := time.Now()
+	if _, err := m.call(ctx, "spin", pluginInput{}); err == nil {
+		t.Fatal("spin returned without error")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("spin interrupted after %v", d)
+	}
+
+	// The interrupted instance is replaced, so the plugin keeps working.
+	for i := 0; i < 2; i++ {
+		if _, err := m.call(ctx, "on_request", pluginInput{Phase: "request"}); err != nil {
+			t.Fatalf("call %d after timeout: %v", i, err)
+		}
+	}
+
+	// A canceled request context interrupts the call the same way.
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := m.call(canceled, "spin", pluginInput{}); err == nil {
+		t.Fatal("spin with canceled context returned without error")
+	}
+	if _, err := m.call(ctx, "on_request", pluginInput{Phase: "request"}); err != nil {
+		t.Fatalf("call after cancel: %v", err)
+	}
+}
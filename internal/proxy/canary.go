@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+// Canary variants recorded on logs.
+const (
+	variantStable = "stable"
+	variantCanary = "canary"
+)
+
+// canaryState tracks the ramp and health of one upstream's canary rollout.
+// State is in-memory: a restart restarts the ramp from the first step.
+type canaryState struct {
+	mu sync.Mutex
+
+	// key identifies the canary config this state belongs to; a config change resets it.
+	key       string
+	step      int
+	stepStart time.Time
+
+	total      int
+	errors     int
+	rolledBack bool
+}
+
+// canaryRouter holds canary state for all upstreams.
+type canaryRouter struct {
+	mu     sync.Mutex
+	states map[string]*canaryState
+	now    func() time.Time
+	rand   func(n int) int
+}
+
+func newCanaryRouter() *canaryRouter {
+	return &canaryRouter{
+		states: make(map[string]*canaryState),
+		now:    time.Now,
+		rand:   rand.Intn,
+	}
+}
+
+func canaryDefaults(c config.CanaryConfig) config.CanaryConfig {
+	if len(c.Steps) == 0 {
+		c.Steps = []int{5, 25, 100}
+	}
+	if c.StepSeconds <= 0 {
+		c.StepSeconds = 600
+	}
+	if c.MaxErrorRate <= 0 {
+		c.MaxErrorRate = 0.05
+	}
+	if c.MinRequests <= 0 {
+		c.MinRequests = 20
+	}
+	return c
+}
+
+// pick decides the variant for a request to upstream. It returns the state to
+// report the outcome to, or nil when no canary is configured.
+func (cr *canaryRouter) pick(upstream string, c config.CanaryConfig) (string, *canaryState) {
+	if c.Target == "" {
+		return "", nil
+	}
+	c = canaryDefaults(c)
+	key := fmt.Sprintf("%s|%v|%d|%g|%d", c.Target, c.Steps, c.StepSeconds, c.MaxErrorRate, c.MinRequests)
+	now := cr.now()
+
+	cr.mu.Lock()
+	st, ok := cr.states[upstream]
+	if !ok || st.key != key {
+		st = &canaryState{key: key, stepStart: now}
+		cr.states[upstream] = st
+	}
+	cr.mu.Unlock()
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.rolledBack {
+		return variantStable, st
+	}
+	// Advance the ramp; each step is judged on its own traffic.
+	interval := time.Duration(c.StepSeconds) * time.Second
+	for st.step < len(c.Steps)-1 && now.Sub(st.stepStart) >= interval {
+		st.step++
+		st.stepStart = st.stepStart.Add(interval)
+		st.total, st.errors = 0, 0
+		log.Printf("canary %s: advanced to %d%%", upstream, c.Steps[st.step])
+	}
+	if cr.rand(100) < c.Steps[st.step] {
+		return variantCanary, st
+	}
+	return variantStable, st
+}
+
+// record reports the outcome of a canary request and rolls back when the
+// error rate exceeds the configured threshold.
+func (st *canaryState) record(upstream string, c config.CanaryConfig, entry *storage.RequestLog) {
+	c = canaryDefaults(c)
+	failed := entry.Error != "" || entry.StatusCode >= 500
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.rolledBack {
+		return
+	}
+	st.total++
+	if failed {
+		st.errors++
+	}
+	if st.total >= c.MinRequests {
+		rate := float64(st.errors) / float64(st.total)
+		if rate > c.MaxErrorRate {
+			st.rolledBack = true
+			log.Printf("canary %s: rolled back to stable (error rate %.1f%% over %d requests > %.1f%%)",
+				upstream, rate*100, st.total, c.MaxErrorRate*100)
+		}
+	}
+}
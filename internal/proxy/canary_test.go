@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+func TestCanaryRampAndRollback(t *testing.T) {
+	now := time.Unix(0, 0)
+	cr := newCanaryRouter()
+	cr.now = func() time.Time { return now }
+	roll := 0
+	cr.rand = func(int) int { return roll }
+
+	c := config.CanaryConfig{Target: "http://new", Steps: []int{10, 50, 100}, StepSeconds: 60, MaxErrorRate: 0.5, MinRequests: 2}
+
+	roll = 10
+	if v, _ := cr.pick("up", c); v != variantStable {
+		t.Fatalf("step 0 roll 10: %s, want stable", v)
+	}
+	now = now.Add(61 * time.Second)
+	v, st := cr.pick("up", c)
+	if v != variantCanary {
+		t.Fatalf("step 1 roll 10: %s, want canary", v)
+	}
+
+	st.record("up", c, &storage.RequestLog{StatusCode: 502})
+	st.record("up", c, &storage.RequestLog{StatusCode: 500})
+	roll = 0
+	if v, _ := cr.pick("up", c); v != variantStable {
+		t.Fatalf("after rollback: %s, want stable", v)
+	}
+
+	// Changing the canary config restarts the rollout.
+	c.Target = "http://newer"
+	if v, _ := cr.pick("up", c); v != variantCanary {
+		t.Fatalf("new config: %s, want canary", v)
+	}
+}
+
+func TestCanaryVariantRecordedOnLog(t *testing.T) {
+	stable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Backend", "stable")
+	}))
+	defer stable.Close()
+	canary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Backend", "canary")
+	}))
+	defer canary.Close()
+
+	p, repo := newTestProxy(t, stable.URL)
+	p.cfg.Upstreams["up"] = config.UpstreamConfig{
+		Target: stable.URL,
+		Canary: config.CanaryConfig{Target: canary.URL, Steps: []int{100}},
+	}
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://up.localhost/v1/models", nil))
+	if rec.Header().Get("X-Backend") != "canary" {
+		t.Fatalf("request not routed to canary")
+	}
+	if l := repo.only(t); l.Variant != variantCanary || l.TargetURL != canary.URL+"/v1/models" {
+		t.Fatalf("variant=%q target=%q", l.Variant, l.TargetURL)
+	}
+}
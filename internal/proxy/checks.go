@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/llm"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+// tokenLimitFinishReasons are the finish reasons (lowercased) meaning the
+// model stopped at its output token limit: OpenAI / Ollama "length",
+// Anthropic "max_tokens", Gemini "MAX_TOKENS" and Responses "incomplete".
+var tokenLimitFinishReasons = map[string]bool{"length": true, "max_tokens": true, "incomplete": true}
+
+// responseAnswer is what response checks look at.
+type responseAnswer struct {
+	// text is the assistant's answer, or the whole body for responses in no
+	// known LLM format.
+	text         []byte
+	finishReason string
+}
+
+// runResponseChecks evaluates the upstream's response checks on a complete
+// 2xx response, recording each result in entry.Checks and flagging the log
+// when one fails. Truncated and compressed responses are skipped.
+func runResponseChecks(up config.UpstreamConfig, entry *storage.RequestLog, respCap *limitedCapture) {
+	if len(up.ResponseChecks) == 0 || respCap == nil || respCap.Truncated() ||
+		entry.StatusCode < 200 || entry.StatusCode >= 300 {
+		return
+	}
+	if firstHeaderValue(entry.ResponseHeaders, "Content-Encoding") != "" {
+		return
+	}
+
+	var answer *responseAnswer
+	for _, c := range up.ResponseChecks {
+		if !c.MatchPath(entry.Path) {
+			continue
+		}
+		if answer == nil {
+			answer = extractAnswer(entry, respCap.Bytes())
+		}
+		passed := checkResponse(c, answer)
+		if entry.Checks == nil {
+			entry.Checks = make(map[string]bool)
+		}
+		entry.Checks[c.Name] = passed
+		if !passed {
+			entry.AddFlag(storage.FlagCheckFailed)
+		}
+	}
+}
+
+// extractAnswer reads the answer out of a response body, reassembling
+// streamed responses first.
+func extractAnswer(entry *storage.RequestLog, body []byte) *responseAnswer {
+	var doc map[string]interface{}
+	if entry.Streaming {
+		events := llm.ParseStream(firstHeaderValue(entry.ResponseHeaders, "Content-Type"), body)
+		if merged, _, ok := llm.MergeStream(events); ok {
+			doc = merged
+		}
+	} else if json.Unmarshal(body, &doc) != nil {
+		doc = nil
+	}
+	if doc != nil {
+		if out, ok := llm.ExtractOutput(doc); ok {
+			return &responseAnswer{text: []byte(out.Text), finishReason: out.FinishReason}
+		}
+	}
+	return &responseAnswer{text: body}
+}
+
+// checkResponse reports whether the answer passes one check.
+func checkResponse(c config.ResponseCheckConfig, answer *responseAnswer) bool {
+	switch c.Type {
+	case config.CheckJSON:
+		return json.Valid(stripCodeFence(answer.text))
+	case config.CheckRequiredKeys:
+		var v interface{}
+		if json.Unmarshal(stripCodeFence(answer.text), &v) != nil {
+			return false
+		}
+		for _, key := range c.Keys {
+			if !hasJSONPath(v, strings.Split(key, ".")) {
+				return false
+			}
+		}
+		return true
+	case config.CheckFinishReason:
+		return !tokenLimitFinishReasons[strings.ToLower(answer.finishReason)]
+	}
+	return true
+}
+
+// stripCodeFence removes the markdown code fence models often wrap JSON
+// answers in ("```json ... ```").
+func stripCodeFence(text []byte) []byte {
+	text = bytes.TrimSpace(text)
+	if !bytes.HasPrefix(text, []byte("```")) || !bytes.HasSuffix(text, []byte("```")) || len(text) < 6 {
+		return text
+	}
+	inner := text[3 : len(text)-3]
+	if nl := bytes.IndexByte(inner, '\n'); nl >= 0 {
+		inner = inner[nl+1:]
+	}
+	return bytes.TrimSpace(inner)
+}
+
+// hasJSONPath reports whether path (object keys, array indexes) leads to a
+// value in v.
+func hasJSONPath(v interface{}, path []string) bool {
+	for _, head := range path {
+		switch x := v.(type) {
+		case map[string]interface{}:
+			child, ok := x[head]
+			if !ok {
+				return false
+			}
+			v = child
+		case []interface{}:
+			i, err := strconv.Atoi(head)
+			if err != nil || i < 0 || i >= len(x) {
+				return false
+			}
+			v = x[i]
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+func TestResponseChecksRecordResults(t *testing.T) {
+	var body, contentType string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		_, _ = w.Write([]byte(body))
+	}))
+	defer upstream.Close()
+
+	p, repo := newTestProxy(t, upstream.URL)
+	p.cfg.Upstreams["up"] = config.UpstreamConfig{
+		Target: upstream.URL,
+		ResponseChecks: []config.ResponseCheckConfig{
+			{Name: "json", Type: config.CheckJSON},
+			{Name: "keys", Type: config.CheckRequiredKeys, Keys: []string{"answer", "sources.0"}},
+			{Name: "finish_reason", Type: config.CheckFinishReason},
+		},
+	}
+
+	serve := func() *storage.RequestLog {
+		repo.logs = nil
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "http://up.localhost/v1/chat/completions", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("client saw %d", rec.Code)
+		}
+		return repo.only(t)
+	}
+
+	contentType = "application/json"
+	body = `{"object":"chat.completion","choices":[{"finish_reason":"stop","message":{"role":"assistant","content":"` +
+		"```json\\n{\\\"answer\\\":42,\\\"sources\\\":[\\\"a\\\"]}\\n```" + `"}}]}`
+	l := serve()
+	if want := map[string]bool{"json": true, "keys": true, "finish_reason": true}; !reflect.DeepEqual(l.Checks, want) || l.HasFlag(storage.FlagCheckFailed) {
+		t.Fatalf("checks = %v flags = %v, want all passed", l.Checks, l.Flags)
+	}
+
+	// A streamed answer cut off at the token limit, missing its sources.
+	contentType = "text/event-stream"
+	body = "data: {\"object\":\"chat.completion.chunk\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"{\\\"answer\\\":\"}}]}\n\n" +
+		"data: {\"object\":\"chat.completion.chunk\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"1}\"},\"finish_reason\":\"length\"}]}\n\n" +
+		"data: [DONE]\n\n"
+	l = serve()
+	if want := map[string]bool{"json": true, "keys": false, "finish_reason": false}; !reflect.DeepEqual(l.Checks, want) || !l.HasFlag(storage.FlagCheckFailed) {
+		t.Fatalf("checks = %v flags = %v, want keys and finish_reason failed", l.Checks, l.Flags)
+	}
+}
@@ -0,0 +1,32 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolveClientIP(t *testing.T) {
+	trusted := []string{"10.0.0.0/8", "127.0.0.1"}
+	cases := []struct {
+		remote, xff string
+		trusted     []string
+		want        string
+	}{
+		{"203.0.113.9:1234", "1.2.3.4", trusted, "203.0.113.9"},             // untrusted peer: XFF ignored
+		{"10.0.0.2:1234", "1.2.3.4", trusted, "1.2.3.4"},                    // trusted peer
+		{"10.0.0.2:1234", "6.6.6.6, 1.2.3.4, 10.0.0.5", trusted, "1.2.3.4"}, // skip trusted hops, ignore spoofed left
+		{"10.0.0.2:1234", "", trusted, "10.0.0.2"},                          // no XFF
+		{"10.0.0.2:1234", "1.2.3.4", nil, "10.0.0.2"},                       // no trusted proxies configured
+		{"[::ffff:127.0.0.1]:80", "2001:db8::1", trusted, "2001:db8::1"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = c.remote
+		if c.xff != "" {
+			r.Header.Set("X-Forwarded-For", c.xff)
+		}
+		if got := resolveClientIP(r, c.trusted); got != c.want {
+			t.Errorf("remote=%s xff=%q: got %s, want %s", c.remote, c.xff, got, c.want)
+		}
+	}
+}
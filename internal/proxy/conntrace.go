@@ -0,0 +1,188 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+// connTrace records the phases of one upstream request, from asking for a
+// connection to the end of the response body. The transport may call the
+// hooks from its dial goroutines, hence the lock.
+type connTrace struct {
+	mu sync.Mutex
+
+	getConn      time.Time
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	gotConn      time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+
+	timings storage.ConnTimings
+	dialErr bool
+	// wrote is set once the transport has written a whole request; the
+	// upstream may then have acted on it.
+	wrote bool
+}
+
+// withConnTrace attaches a connTrace to ctx.
+func withConnTrace(ctx context.Context) (context.Context, *connTrace) {
+	t := &connTrace{}
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GetConn: func(string) {
+			t.mu.Lock()
+			// Keep the first attempt: the transport may retry on a dead
+			// pooled connection, and TTFB should include that.
+			if t.getConn.IsZero() {
+				t.getConn = time.Now()
+			}
+			t.mu.Unlock()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			t.timings.Reused = info.Reused
+			// A retry on a dead pooled connection writes the request again.
+			t.gotConn = time.Now()
+			t.mu.Unlock()
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mu.Lock()
+			t.dnsStart = time.Now()
+			t.mu.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			t.timings.DNSMs = sinceMs(t.dnsStart)
+			t.mu.Unlock()
+		},
+		ConnectStart: func(string, string) {
+			t.mu.Lock()
+			// With several addresses the dialer may race them; time from
+			// the first attempt.
+			if t.connectStart.IsZero() {
+				t.connectStart = time.Now()
+			}
+			t.mu.Unlock()
+		},
+		ConnectDone: func(_, _ string, err error) {
+			t.mu.Lock()
+			if err == nil {
+				t.timings.ConnectMs = sinceMs(t.connectStart)
+				t.dialErr = false
+			} else if t.timings.ConnectMs == 0 {
+				t.dialErr = true
+			}
+			t.mu.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			t.mu.Lock()
+			t.tlsStart = time.Now()
+			t.mu.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mu.Lock()
+			t.timings.TLSMs = sinceMs(t.tlsStart)
+			t.mu.Unlock()
+		},
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			t.mu.Lock()
+			if info.Err == nil {
+				t.wrote = true
+			}
+			t.wroteRequest = time.Now()
+			t.timings.WriteMs = msBetween(t.gotConn, t.wroteRequest)
+			t.mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			t.firstByte = time.Now()
+			t.timings.TTFBMs = sinceMs(t.getConn)
+			// Zero when the upstream answered before reading the whole
+			// request (e.g. an early 413).
+			t.timings.WaitMs = msBetween(t.wroteRequest, t.firstByte)
+			t.mu.Unlock()
+		},
+	}), t
+}
+
+// result returns the recorded timings, or nil if the request never asked
+// the transport for a connection.
+func (t *connTrace) result() *storage.ConnTimings {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.getConn.IsZero() {
+		return nil
+	}
+	out := t.timings
+	return &out
+}
+
+// bodyDone records in c, the timings result returned, the transfer of the
+// response body that has just been forwarded in full (or up to an error).
+func (t *connTrace) bodyDone(c *storage.ConnTimings) {
+	if c == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c.TransferMs = sinceMs(t.firstByte)
+}
+
+func (t *connTrace) dialFailed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.dialErr
+}
+
+// requestWritten reports whether any attempt wrote the whole request.
+func (t *connTrace) requestWritten() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.wrote
+}
+
+func sinceMs(start time.Time) float64 {
+	return msBetween(start, time.Now())
+}
+
+// msBetween returns end - start in milliseconds, or 0 when either is unset
+// or end precedes start.
+func msBetween(start, end time.Time) float64 {
+	if start.IsZero() || end.IsZero() || end.Before(start) {
+		return 0
+	}
+	return float64(end.Sub(start).Microseconds()) / 1000
+}
+
+// countingDialer wraps dial so open upstream connections are counted per
+// dialed address in m.
+func countingDialer(m *transportMetrics, dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		open := m.openConns(addr)
+		open.Add(1)
+		return &countedConn{Conn: conn, open: open}, nil
+	}
+}
+
+type countedConn struct {
+	net.Conn
+	open *atomic.Int64
+	once sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() { c.open.Add(-1) })
+	return c.Conn.Close()
+}
@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/prismcat/prismcat/internal/llm"
+)
+
+// contextExceeded describes a request over its model's context limit.
+type contextExceeded struct {
+	model  string
+	limit  int
+	prompt int
+	output int
+}
+
+// checkContextLimit estimates the prompt tokens of a JSON request and
+// reports whether, together with the requested output tokens, they exceed
+// the upstream's configured limit for the model. Requests without a
+// matching limit, or whose body is not a JSON object, pass.
+func (p *Proxy) checkContextLimit(ex *Exchange, req *http.Request) (*contextExceeded, error) {
+	limits := ex.UpstreamConfig.ContextLimits
+	if len(limits) == 0 || req.Method != http.MethodPost {
+		return nil, nil
+	}
+	raw, err := ex.RequestBody(req)
+	if err != nil {
+		if errors.Is(err, ErrBodyTooLarge) {
+			return nil, nil // forwarded unchecked; the provider decides
+		}
+		return nil, err
+	}
+	var body map[string]interface{}
+	if json.Unmarshal(decodeContent(req.Header.Get("Content-Encoding"), raw), &body) != nil {
+		return nil, nil
+	}
+
+	model := llm.ExtractModel(body)
+	if model == "" {
+		model = pathModel(req.URL.Path)
+	}
+	limit := contextLimitFor(limits, model)
+	if limit <= 0 {
+		return nil, nil
+	}
+	c := &contextExceeded{
+		model:  model,
+		limit:  limit,
+		prompt: llm.EstimatePromptTokens(body),
+		output: llm.RequestedOutputTokens(body),
+	}
+	if c.prompt+c.output <= limit {
+		return nil, nil
+	}
+	return c, nil
+}
+
+// contextLimitFor returns the limit for model: an exact key, else the
+// longest matching "prefix*" key, else "*".
+func contextLimitFor(limits map[string]int, model string) int {
+	if n, ok := limits[model]; ok {
+		return n
+	}
+	best, limit := -1, 0
+	for k, n := range limits {
+		prefix, ok := strings.CutSuffix(k, "*")
+		if ok && strings.HasPrefix(model, prefix) && len(prefix) > best {
+			best, limit = len(prefix), n
+		}
+	}
+	return limit
+}
+
+// pathModel extracts the model from Gemini/Vertex (".../models/<model>:verb")
+// and Bedrock ("/model/<model>/invoke") paths.
+func pathModel(path string) string {
+	for _, m := range []struct{ marker, end string }{{"/models/", ":/"}, {"/model/", "/"}} {
+		if i := strings.LastIndex(path, m.marker); i >= 0 {
+			rest := path[i+len(m.marker):]
+			if j := strings.IndexAny(rest, m.end); j >= 0 {
+				rest = rest[:j]
+			}
+			return rest
+		}
+	}
+	return ""
+}
+
+// rejection answers in the OpenAI error format so SDKs surface the message.
+func (c *contextExceeded) rejection() *RejectError {
+	msg := c.String()
+	body, _ := json.Marshal(map[string]interface{}{
+		"error": map[string]interface{}{
+			"type":    "invalid_request_error",
+			"code":    "context_length_exceeded",
+			"message": msg,
+		},
+	})
+	return &RejectError{
+		StatusCode: http.StatusBadRequest,
+		Message:    msg,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       body,
+	}
+}
+
+func (c *contextExceeded) String() string {
+	msg := fmt.Sprintf("request to model %q needs about %d tokens", c.model, c.prompt+c.output)
+	if c.output > 0 {
+		msg += fmt.Sprintf(" (%d prompt + %d requested output)", c.prompt, c.output)
+	}
+	return msg + fmt.Sprintf(", over the %d token context limit", c.limit)
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+func TestContextLimitRejectsOversizedPrompts(t *testing.T) {
+	var hits int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+	}))
+	defer upstream.Close()
+
+	p, repo := newTestProxy(t, upstream.URL)
+	p.cfg.Upstreams["up"] = config.UpstreamConfig{
+		Target:        upstream.URL,
+		ContextLimits: map[string]int{"small-*": 100, "small-xl": 1000},
+	}
+
+	long := strings.Repeat("word ", 100) // ~125 tokens
+	cases := []struct {
+		body    string
+		blocked bool
+	}{
+		{`{"model":"small-1","messages":[{"role":"user","content":"hi"}]}`, false},
+		{`{"model":"small-1","messages":[{"role":"user","content":"` + long + `"}]}`, true},
+		{`{"model":"small-1","max_tokens":95,"messages":[{"role":"user","content":"hi"}]}`, true},
+		{`{"model":"small-xl","messages":[{"role":"user","content":"` + long + `"}]}`, false},
+		{`{"model":"other","messages":[{"role":"user","content":"` + long + `"}]}`, false},
+	}
+	for _, c := range cases {
+		repo.logs = nil
+		atomic.StoreInt32(&hits, 0)
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "http://up.localhost/v1/chat/completions", strings.NewReader(c.body)))
+		l := repo.only(t)
+
+		if !c.blocked {
+			if rec.Code != http.StatusOK || atomic.LoadInt32(&hits) != 1 {
+				t.Fatalf("%.60s: code=%d hits=%d, want forwarded", c.body, rec.Code, hits)
+			}
+			continue
+		}
+		if rec.Code != http.StatusBadRequest || atomic.LoadInt32(&hits) != 0 || !strings.Contains(rec.Body.String(), "context_length_exceeded") {
+			t.Fatalf("%.60s: code=%d hits=%d body=%s, want 400 without upstream call", c.body, rec.Code, hits, rec.Body.String())
+		}
+		if !l.HasFlag(storage.FlagContextLimit) || l.RequestBody != c.body {
+			t.Fatalf("log = %+v", l)
+		}
+	}
+}
+
+func TestPathModel(t *testing.T) {
+	for path, want := range map[string]string{
+		"/v1beta/models/gemini-2.0-flash:streamGenerateContent":                  "gemini-2.0-flash",
+		"/v1/projects/p/locations/l/publishers/google/models/gemini-pro:predict": "gemini-pro",
+		"/model/anthropic.claude-3-haiku-20240307-v1:0/converse":                 "anthropic.claude-3-haiku-20240307-v1:0",
+		"/v1/chat/completions": "",
+	} {
+		if got := pathModel(path); got != want {
+			t.Errorf("pathModel(%s) = %q, want %q", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+// duplicateTracker remembers recent request fingerprints to flag repeats
+// (retry storms, double submits). State is per process: instances sharing a
+// store don't see each other's requests.
+type duplicateTracker struct {
+	mu        sync.Mutex
+	runs      map[string]*duplicateRun // by fingerprint
+	lastSweep time.Time
+}
+
+// duplicateRun is a series of identical requests, each within the window of
+// the previous one.
+type duplicateRun struct {
+	firstID  string
+	lastSeen time.Time
+	count    int
+}
+
+func newDuplicateTracker() *duplicateTracker {
+	return &duplicateTracker{runs: make(map[string]*duplicateRun)}
+}
+
+// mark records log's fingerprint and, when an identical request was seen
+// less than window before, links log to the first log of the run and flags
+// it.
+func (d *duplicateTracker) mark(log *storage.RequestLog, window time.Duration) {
+	if window <= 0 || log.Fingerprint == "" {
+		return
+	}
+	now := log.CreatedAt
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if now.Sub(d.lastSweep) >= window {
+		for fp, run := range d.runs {
+			if now.Sub(run.lastSeen) >= window {
+				delete(d.runs, fp)
+			}
+		}
+		d.lastSweep = now
+	}
+
+	run, ok := d.runs[log.Fingerprint]
+	if !ok || now.Sub(run.lastSeen) >= window {
+		d.runs[log.Fingerprint] = &duplicateRun{firstID: log.ID, lastSeen: now, count: 1}
+		return
+	}
+	run.count++
+	if now.After(run.lastSeen) {
+		run.lastSeen = now
+	}
+	log.DuplicateOf = run.firstID
+	log.DuplicateCount = run.count
+	log.AddFlag(storage.FlagDuplicate)
+}
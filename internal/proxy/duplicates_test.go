@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+func TestDuplicateRequestsFlagged(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	p, repo := newTestProxy(t, upstream.URL)
+	p.cfg.Logging.DuplicateWindowSeconds = 60
+
+	send := func(body string) *storage.RequestLog {
+		before := len(repo.logs)
+		p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "http://up.localhost/v1/chat/completions", strings.NewReader(body)))
+		if len(repo.logs) != before+1 {
+			t.Fatalf("logs = %d, want %d", len(repo.logs), before+1)
+		}
+		var latest *storage.RequestLog
+		for _, l := range repo.logs {
+			if latest == nil || l.CreatedAt.After(latest.CreatedAt) {
+				latest = l
+			}
+		}
+		return latest
+	}
+
+	first := send(`{"model":"m","messages":[{"role":"user","content":"hi"}]}`)
+	// Key order and the stream flag don't make a request different.
+	second := send(`{"messages":[{"role":"user","content":"hi"}],"model":"m","stream":true}`)
+	third := send(`{"model":"m","messages":[{"role":"user","content":"hi"}]}`)
+	other := send(`{"model":"m","messages":[{"role":"user","content":"bye"}]}`)
+
+	if first.HasFlag(storage.FlagDuplicate) || first.DuplicateOf != "" {
+		t.Fatalf("first = %+v", first)
+	}
+	if !second.HasFlag(storage.FlagDuplicate) || second.DuplicateOf != first.ID || second.DuplicateCount != 2 {
+		t.Fatalf("second = %+v", second)
+	}
+	if third.DuplicateOf != first.ID || third.DuplicateCount != 3 {
+		t.Fatalf("third = %+v", third)
+	}
+	if other.HasFlag(storage.FlagDuplicate) {
+		t.Fatalf("other = %+v", other)
+	}
+}
@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+// defaultFailureNotifyThreshold applies when server.failure_notify_threshold
+// is 0.
+const defaultFailureNotifyThreshold = 5
+
+// UpstreamFailure reports an upstream that failed Count requests in a row.
+type UpstreamFailure struct {
+	Upstream  string
+	Count     int
+	LastError string
+}
+
+// failureTracker counts consecutive failed requests per upstream and reports
+// each streak once, when it reaches the threshold; a success ends the streak.
+type failureTracker struct {
+	mu      sync.Mutex
+	streaks map[string]int
+	notify  func(UpstreamFailure)
+}
+
+func newFailureTracker() *failureTracker {
+	return &failureTracker{streaks: make(map[string]int)}
+}
+
+// SetOnUpstreamFailing sets the callback run when an upstream reaches
+// server.failure_notify_threshold consecutive failures. It runs on the
+// request path and must not block.
+func (p *Proxy) SetOnUpstreamFailing(fn func(UpstreamFailure)) {
+	p.failures.mu.Lock()
+	p.failures.notify = fn
+	p.failures.mu.Unlock()
+}
+
+// upstreamFailure describes entry's outcome when it counts as a failure of
+// the upstream: no response at all, a 5xx, or a rejected key (401/403).
+// Errors after a successful status (e.g. a client disconnect mid-body) don't
+// count.
+func upstreamFailure(entry *storage.RequestLog) (string, bool) {
+	switch {
+	case entry.StatusCode == 0 && entry.Error != "":
+		return entry.Error, true
+	case entry.StatusCode >= 500, entry.StatusCode == http.StatusUnauthorized, entry.StatusCode == http.StatusForbidden:
+		if entry.Error != "" {
+			return entry.Error, true
+		}
+		return fmt.Sprintf("HTTP %d %s", entry.StatusCode, http.StatusText(entry.StatusCode)), true
+	}
+	return "", false
+}
+
+// record updates upstream's streak with the outcome of entry.
+func (f *failureTracker) record(upstream string, threshold int, entry *storage.RequestLog) {
+	if threshold == 0 {
+		threshold = defaultFailureNotifyThreshold
+	}
+	lastErr, failed := upstreamFailure(entry)
+
+	f.mu.Lock()
+	if !failed {
+		delete(f.streaks, upstream)
+		f.mu.Unlock()
+		return
+	}
+	f.streaks[upstream]++
+	n, notify := f.streaks[upstream], f.notify
+	f.mu.Unlock()
+
+	if notify != nil && threshold > 0 && n == threshold {
+		notify(UpstreamFailure{Upstream: upstream, Count: n, LastError: lastErr})
+	}
+}
@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUpstreamFailureNotification(t *testing.T) {
+	status := http.StatusUnauthorized
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer up.Close()
+
+	p, _ := newTestProxy(t, up.URL)
+	p.cfg.Server.FailureNotifyThreshold = 2
+	var got []UpstreamFailure
+	p.SetOnUpstreamFailing(func(f UpstreamFailure) { got = append(got, f) })
+	send := func() {
+		req := httptest.NewRequest(http.MethodGet, "http://up.localhost/v1/models", nil)
+		p.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// One notification per streak, at the threshold.
+	for i := 0; i < 3; i++ {
+		send()
+	}
+	if len(got) != 1 || got[0].Upstream != "up" || got[0].Count != 2 || got[0].LastError != "HTTP 401 Unauthorized" {
+		t.Fatalf("notifications = %+v", got)
+	}
+	// A success ends the streak; a new one notifies again.
+	status = http.StatusOK
+	send()
+	status = http.StatusBadGateway
+	send()
+	if len(got) != 1 {
+		t.Fatalf("notified before threshold: %+v", got)
+	}
+	send()
+	if len(got) != 2 || got[1].LastError != "HTTP 502 Bad Gateway" {
+		t.Fatalf("notifications = %+v", got)
+	}
+}
@@ -0,0 +1,236 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+
+	"github.com/prismcat/prismcat/internal/config"
+)
+
+// guardrail is a compiled config.GuardrailConfig.
+type guardrail struct {
+	name      string
+	upstreams map[string]bool
+	keywords  []string // lower-cased
+	patterns  []*regexp.Regexp
+	fields    [][]string
+	message   string
+}
+
+// guardrailMatch describes why a request was blocked. It never contains the
+// matched content itself, since that is what the guardrail keeps out of logs.
+type guardrailMatch struct {
+	rule   *guardrail
+	reason string
+}
+
+func compileGuardrails(defs []config.GuardrailConfig) ([]*guardrail, error) {
+	var out []*guardrail
+	for i, d := range defs {
+		g := &guardrail{name: d.Name, message: d.Message}
+		if g.name == "" {
+			g.name = fmt.Sprintf("guardrail-%d", i+1)
+		}
+		for _, k := range d.Keywords {
+			if k = strings.TrimSpace(k); k != "" {
+				g.keywords = append(g.keywords, strings.ToLower(k))
+			}
+		}
+		for _, p := range d.Patterns {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return nil, fmt.Errorf("guardrail %q: pattern %q: %w", g.name, p, err)
+			}
+			g.patterns = append(g.patterns, re)
+		}
+		if len(g.keywords) == 0 && len(g.patterns) == 0 {
+			return nil, fmt.Errorf("guardrail %q: keywords or patterns required", g.name)
+		}
+		for _, f := range d.JSONFields {
+			if f = strings.TrimSpace(f); f != "" {
+				g.fields = append(g.fields, strings.Split(f, "."))
+			}
+		}
+		if len(d.Upstreams) > 0 {
+			g.upstreams = make(map[string]bool, len(d.Upstreams))
+			for _, u := range d.Upstreams {
+				g.upstreams[strings.ToLower(strings.TrimSpace(u))] = true
+			}
+		}
+		out = append(out, g)
+	}
+	return out, nil
+}
+
+// checkGuardrails inspects the request body against the configured guardrails.
+// It returns the first match, or nil when the request may be forwarded.
+func (p *Proxy) checkGuardrails(ex *Exchange, req *http.Request) (*guardrailMatch, error) {
+	var applicable []*guardrail
+	for _, g := range p.guardrails {
+		if g.upstreams == nil || g.upstreams[ex.Upstream] {
+			applicable = append(applicable, g)
+		}
+	}
+	if len(applicable) == 0 {
+		return nil, nil
+	}
+
+	raw, err := ex.RequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	body := decodeContent(req.Header.Get("Content-Encoding"), raw)
+
+	var doc interface{}
+	var decoded bool
+	for _, g := range applicable {
+		if len(g.fields) == 0 {
+			if reason := g.match(string(body)); reason != "" {
+				return &guardrailMatch{rule: g, reason: reason}, nil
+			}
+			continue
+		}
+		if !decoded {
+			decoded = true
+			_ = json.Unmarshal(body, &doc)
+		}
+		for _, path := range g.fields {
+			var reason string
+			walkJSONPath(doc, path, nil, func(at []string, s string) bool {
+				if r := g.match(s); r != "" {
+					reason = r + " in field " + strings.Join(at, ".")
+					return false
+				}
+				return true
+			})
+			if reason != "" {
+				return &guardrailMatch{rule: g, reason: reason}, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (g *guardrail) match(s string) string {
+	if len(g.keywords) > 0 {
+		lower := strings.ToLower(s)
+		for i, k := range g.keywords {
+			if strings.Contains(lower, k) {
+				return fmt.Sprintf("keyword #%d", i+1)
+			}
+		}
+	}
+	for i, re := range g.patterns {
+		if re.MatchString(s) {
+			return fmt.Sprintf("pattern #%d", i+1)
+		}
+	}
+	return ""
+}
+
+func (m *guardrailMatch) rejection() *RejectError {
+	msg := m.rule.message
+	if msg == "" {
+		msg = "request blocked by content policy: " + m.rule.name
+	}
+	return Reject(http.StatusForbidden, msg)
+}
+
+// walkJSONPath calls fn for every string value reachable via path. "*" matches
+// any object key or array index. Returning false from fn stops the walk.
+func walkJSONPath(v interface{}, path, at []string, fn func(at []string, s string) bool) bool {
+	if len(path) == 0 {
+		// A path ending at a container checks every string beneath it.
+		return walkDeep(v, at, fn)
+	}
+	head, rest := path[0], path[1:]
+	switch x := v.(type) {
+	case map[string]interface{}:
+		if head == "*" {
+			for k, child := range x {
+				if !walkJSONPath(child, rest, append(at, k), fn) {
+					return false
+				}
+			}
+			return true
+		}
+		if child, ok := x[head]; ok {
+			return walkJSONPath(child, rest, append(at, head), fn)
+		}
+	case []interface{}:
+		if head == "*" {
+			for i, child := range x {
+				if !walkJSONPath(child, rest, append(at, strconv.Itoa(i)), fn) {
+					return false
+				}
+			}
+			return true
+		}
+		if i, err := strconv.Atoi(head); err == nil && i >= 0 && i < len(x) {
+			return walkJSONPath(x[i], rest, append(at, head), fn)
+		}
+	}
+	return true
+}
+
+// walkDeep visits every string nested anywhere under v.
+func walkDeep(v interface{}, at []string, fn func(at []string, s string) bool) bool {
+	switch x := v.(type) {
+	case string:
+		return fn(at, x)
+	case map[string]interface{}:
+		for k, child := range x {
+			if !walkDeep(child, append(at, k), fn) {
+				return false
+			}
+		}
+	case []interface{}:
+		for i, child := range x {
+			if !walkDeep(child, append(at, strconv.Itoa(i)), fn) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// decodeContent undoes Content-Encoding for inspection. Unknown encodings or
+// decode failures return the raw bytes.
+func decodeContent(encoding string, b []byte) []byte {
+	var r io.Reader
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "gzip":
+		gr, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return b
+		}
+		defer gr.Close()
+		r = gr
+	case "deflate":
+		fr := flate.NewReader(bytes.NewReader(b))
+		defer fr.Close()
+		r = fr
+	case "br":
+		r = brotli.NewReader(bytes.NewReader(b))
+	default:
+		return b
+	}
+	data, _, err := readAllLimited(r, maxBufferedRequestBody)
+	if err != nil {
+		return b
+	}
+	return data
+}
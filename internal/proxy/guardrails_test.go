@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+func TestGuardrailsBlockMatchingBodies(t *testing.T) {
+	var hits int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{
+		Server:    config.ServerConfig{ProxyDomains: []string{"localhost"}},
+		Logging:   config.LoggingConfig{MaxRequestBody: 1 << 20, MaxResponseBody: 1 << 20},
+		Upstreams: map[string]config.UpstreamConfig{"up": {Target: upstream.URL}},
+		Guardrails: []config.GuardrailConfig{
+			{Name: "secrets", Keywords: []string{"BEGIN PRIVATE KEY"}},
+			{Name: "cards", Patterns: []string{`\b\d{4}-\d{4}-\d{4}-\d{4}\b`}, JSONFields: []string{"messages.*.content"}},
+		},
+	}
+
+	cases := []struct {
+		body      string
+		blockedBy string
+	}{
+		{`{"messages":[{"role":"user","content":"hello"}]}`, ""},
+		{`{"messages":[{"role":"user","content":"-----begin private key-----"}]}`, "secrets"},
+		{`{"messages":[{"role":"user","content":"card 1234-5678-9012-3456"}]}`, "cards"},
+		// Field-scoped guardrails ignore matches outside the configured paths.
+		{`{"metadata":"1234-5678-9012-3456","messages":[]}`, ""},
+	}
+	for _, c := range cases {
+		repo := &captureRepo{}
+		p := New(cfg, repo)
+		atomic.StoreInt32(&hits, 0)
+
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "http://up.localhost/v1/chat", strings.NewReader(c.body))
+		p.ServeHTTP(rec, req)
+		l := repo.only(t)
+
+		if c.blockedBy == "" {
+			if rec.Code != http.StatusOK || atomic.LoadInt32(&hits) != 1 {
+				t.Fatalf("%s: code=%d hits=%d, want forwarded", c.body, rec.Code, hits)
+			}
+			continue
+		}
+		if rec.Code != http.StatusForbidden || atomic.LoadInt32(&hits) != 0 {
+			t.Fatalf("%s: code=%d hits=%d, want 403 without upstream call", c.body, rec.Code, hits)
+		}
+		if !l.HasFlag(storage.FlagGuardrailBlocked) || !strings.Contains(l.Error, `"`+c.blockedBy+`"`) {
+			t.Fatalf("%s: log flags=%v error=%q", c.body, l.Flags, l.Error)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package proxy
+
+import (
+	"context"
+	"net"
+
+	"github.com/prismcat/prismcat/internal/config"
+)
+
+type ipFamilyKey struct{}
+
+// withIPFamily records the upstream's ip_family for the dialer. The
+// transport dials with the request's context values, so one shared pool
+// can serve upstreams with different preferences. Pooled connections are
+// still keyed by host, so upstreams sharing a host should agree.
+func withIPFamily(ctx context.Context, family string) context.Context {
+	if family == "" || family == config.IPFamilyAuto {
+		return ctx
+	}
+	return context.WithValue(ctx, ipFamilyKey{}, family)
+}
+
+// familyDialer narrows "tcp" to "tcp4"/"tcp6" per the context's ip_family.
+func familyDialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if network == "tcp" {
+			switch ctx.Value(ipFamilyKey{}) {
+			case config.IPFamilyV4:
+				network = "tcp4"
+			case config.IPFamilyV6:
+				network = "tcp6"
+			}
+		}
+		return dial(ctx, network, addr)
+	}
+}
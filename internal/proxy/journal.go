@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"log"
+
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+// SetJournal sets the request journal (storage.journal): requests that are
+// logged are recorded in it synchronously before they are forwarded.
+func (p *Proxy) SetJournal(j *storage.Journal) {
+	p.journal = j
+}
+
+// journalRequest records entry's request in the journal, reporting whether
+// it did (no journal, or a failed write, leaves it to the async log).
+func (p *Proxy) journalRequest(entry *storage.RequestLog) bool {
+	if p.journal == nil {
+		return false
+	}
+	if err := p.journal.Begin(entry); err != nil {
+		log.Printf("request journal write failed: %v", err)
+		return false
+	}
+	return true
+}
+
+// journalBody hashes a request body as it is read and journals its digest
+// once the body has been read to the end.
+type journalBody struct {
+	r       io.Reader
+	h       hash.Hash
+	n       int64
+	journal *storage.Journal
+	id      string
+}
+
+func (p *Proxy) newJournalBody(id string, r io.Reader) *journalBody {
+	return &journalBody{r: r, h: sha256.New(), journal: p.journal, id: id}
+}
+
+func (b *journalBody) Read(buf []byte) (int, error) {
+	n, err := b.r.Read(buf)
+	b.h.Write(buf[:n])
+	b.n += int64(n)
+	if err == io.EOF && b.journal != nil {
+		if jerr := b.journal.Body(b.id, hex.EncodeToString(b.h.Sum(nil)), b.n); jerr != nil {
+			log.Printf("request journal write failed: %v", jerr)
+		}
+		b.journal = nil
+	}
+	return n, err
+}
@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+func TestJournalRecordsBodyHash(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+	}))
+	defer upstream.Close()
+
+	path := filepath.Join(t.TempDir(), "requests.journal")
+	j, err := storage.OpenJournal(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, repo := newTestProxy(t, upstream.URL)
+	p.SetJournal(j)
+
+	body := strings.Repeat(`{"model":"gpt-4o"}`, 1000)
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "http://up.localhost/v1/chat/completions", strings.NewReader(body)))
+	id := repo.only(t).ID
+	j.Close()
+
+	// The logs never reached the database (captureRepo), as in a crash.
+	db, err := storage.NewMemorySQLiteRepository()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	j, err = storage.OpenJournal(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if n, err := j.Replay(db); err != nil || n != 1 {
+		t.Fatalf("replay = %d, %v", n, err)
+	}
+	l, err := db.GetLog(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(body))
+	if l.Metadata[storage.MetadataRequestBodySHA256] != hex.EncodeToString(sum[:]) || l.RequestBodySize != int64(len(body)) {
+		t.Fatalf("log = %+v", l)
+	}
+	if l.Upstream != "up" || !l.HasFlag(storage.FlagInterrupted) {
+		t.Fatalf("log = %+v", l)
+	}
+}
+
+// shedRepo drops final logs the way a full AsyncRepository does.
+type shedRepo struct {
+	*captureRepo
+}
+
+func (r shedRepo) SaveLog(ctx context.Context, l *storage.RequestLog) error {
+	if l.StatusCode != 0 || l.Error != "" {
+		return storage.ErrAsyncQueueFull
+	}
+	return r.captureRepo.SaveLog(ctx, l)
+}
+
+func TestJournalClosesShedFinalLog(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	path := filepath.Join(t.TempDir(), "requests.journal")
+	j, err := storage.OpenJournal(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, repo := newTestProxy(t, upstream.URL)
+	p.repo = shedRepo{repo}
+	p.SetJournal(j)
+
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://up.localhost/v1/models", nil))
+	j.Close()
+
+	db, err := storage.NewMemorySQLiteRepository()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	j, err = storage.OpenJournal(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if n, err := j.Replay(db); err != nil || n != 0 {
+		t.Fatalf("completed request restored as interrupted: replay = %d, %v", n, err)
+	}
+}
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ssePing is an SSE comment: clients ignore it, intermediaries see traffic.
+var ssePing = []byte(": ping\n\n")
+
+// keepAliveWriter forwards to the client and, from a background ticker,
+// writes ssePing when nothing has been written for interval. Pings are only
+// sent between events (after a blank line, with any of SSE's line endings) so
+// they never split one, and they bypass the log capture.
+type keepAliveWriter struct {
+	http.ResponseWriter
+	flusher  http.Flusher
+	interval time.Duration
+
+	mu        sync.Mutex
+	lastWrite time.Time
+	tail      []byte // last bytes written (up to 4), for event-boundary checks
+	written   bool
+	err       error // first write error; stops further pings
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// startKeepAlive wraps w when the response is SSE and interval > 0;
+// otherwise it returns w and a no-op stop function.
+func startKeepAlive(w http.ResponseWriter, contentType string, interval time.Duration) (http.ResponseWriter, func()) {
+	flusher, ok := w.(http.Flusher)
+	if interval <= 0 || !ok {
+		return w, func() {}
+	}
+	if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType != "text/event-stream" {
+		return w, func() {}
+	}
+	k := &keepAliveWriter{
+		ResponseWriter: w,
+		flusher:        flusher,
+		interval:       interval,
+		lastWrite:      time.Now(),
+		stop:           make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+	go k.run()
+	return k, func() {
+		close(k.stop)
+		<-k.done
+	}
+}
+
+func (k *keepAliveWriter) run() {
+	defer close(k.done)
+	// Check a few times per interval so a ping follows silence promptly.
+	ticker := time.NewTicker(k.interval / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-k.stop:
+			return
+		case now := <-ticker.C:
+			k.mu.Lock()
+			atBoundary := !k.written || atEventBoundary(k.tail)
+			if k.err == nil && atBoundary && now.Sub(k.lastWrite) >= k.interval {
+				if _, err := k.ResponseWriter.Write(ssePing); err != nil {
+					k.err = err
+				} else {
+					k.flusher.Flush()
+				}
+				k.lastWrite = now
+			}
+			k.mu.Unlock()
+		}
+	}
+}
+
+func (k *keepAliveWriter) Write(p []byte) (int, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	n, err := k.ResponseWriter.Write(p)
+	if n > 0 {
+		k.written = true
+		k.lastWrite = time.Now()
+		k.tail = append(k.tail, p[max(0, n-4):n]...)
+		if len(k.tail) > 4 {
+			k.tail = append(k.tail[:0], k.tail[len(k.tail)-4:]...)
+		}
+	}
+	if err != nil && k.err == nil {
+		k.err = err
+	}
+	return n, err
+}
+
+func (k *keepAliveWriter) Flush() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.flusher.Flush()
+}
+
+// atEventBoundary reports whether b ends with a blank line: two line endings
+// in a row, each "\n", "\r" or "\r\n".
+func atEventBoundary(b []byte) bool {
+	b, ok := trimLineEnding(b)
+	if !ok {
+		return false
+	}
+	_, ok = trimLineEnding(b)
+	return ok
+}
+
+func trimLineEnding(b []byte) ([]byte, bool) {
+	switch {
+	case bytes.HasSuffix(b, []byte("\r\n")):
+		return b[:len(b)-2], true
+	case bytes.HasSuffix(b, []byte("\n")), bytes.HasSuffix(b, []byte("\r")):
+		return b[:len(b)-1], true
+	}
+	return b, false
+}
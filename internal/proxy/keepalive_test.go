@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeepAlivePingsOnlyBetweenEvents(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w, stop := startKeepAlive(rec, "text/event-stream; charset=utf-8", 40*time.Millisecond)
+
+	_, _ = w.Write([]byte("data: a\n\n"))
+	time.Sleep(120 * time.Millisecond)
+	_, _ = w.Write([]byte("data: b"))
+	time.Sleep(120 * time.Millisecond)
+	_, _ = w.Write([]byte("\n\n"))
+	stop()
+
+	body := rec.Body.String()
+	if !strings.HasPrefix(body, "data: a\n\n: ping\n\n") {
+		t.Fatalf("no ping after idle event boundary: %q", body)
+	}
+	if !strings.HasSuffix(body, "data: b\n\n") {
+		t.Fatalf("ping split an event: %q", body)
+	}
+
+	if w, _ := startKeepAlive(rec, "application/json", time.Second); w != rec {
+		t.Fatal("keep-alive wrapped a non-SSE response")
+	}
+}
+
+func TestKeepAlivePingsCRLFStreams(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w, stop := startKeepAlive(rec, "text/event-stream", 40*time.Millisecond)
+
+	_, _ = w.Write([]byte("data: a\r\n\r\n"))
+	time.Sleep(120 * time.Millisecond)
+	_, _ = w.Write([]byte("data: b\r\n"))
+	time.Sleep(120 * time.Millisecond)
+	_, _ = w.Write([]byte("\r\ndata: c\r\r"))
+	time.Sleep(120 * time.Millisecond)
+	stop()
+
+	body := rec.Body.String()
+	if !strings.HasPrefix(body, "data: a\r\n\r\n: ping\n\n") {
+		t.Fatalf("no ping after idle CRLF event boundary: %q", body)
+	}
+	if !strings.Contains(body, "data: b\r\n\r\ndata: c") {
+		t.Fatalf("ping split an event: %q", body)
+	}
+	if !strings.Contains(body, "data: c\r\r: ping\n\n") {
+		t.Fatalf("no ping after idle CR event boundary: %q", body)
+	}
+
+	for tail, want := range map[string]bool{
+		"\n\n": true, "\r\r": true, "\r\n\r\n": true, "\r\n\n": true,
+		"a\r\n": false, "a\n": false, "\r\n": false, "": false,
+	} {
+		if got := atEventBoundary([]byte(tail)); got != want {
+			t.Errorf("atEventBoundary(%q) = %v", tail, got)
+		}
+	}
+}
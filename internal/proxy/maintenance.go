@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/prismcat/prismcat/internal/config"
+)
+
+// maintenanceRejection builds the response returned while an upstream is in
+// maintenance mode. The body follows the OpenAI-style error envelope so SDKs
+// surface the message.
+func maintenanceRejection(upstream string, m config.MaintenanceConfig) *RejectError {
+	status := m.Status
+	if status == 0 {
+		status = http.StatusServiceUnavailable
+	}
+	msg := m.Message
+	if msg == "" {
+		msg = "upstream " + upstream + " is under maintenance"
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"error": map[string]interface{}{
+			"type":     "upstream_maintenance",
+			"message":  msg,
+			"upstream": upstream,
+		},
+	})
+	rej := &RejectError{
+		StatusCode: status,
+		Message:    msg,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       body,
+	}
+	if m.RetryAfter > 0 {
+		rej.Header.Set("Retry-After", strconv.Itoa(m.RetryAfter))
+	}
+	return rej
+}
+
+// disabledRejection is the response for a disabled upstream: 503 in the same
+// envelope as maintenance mode, with its own error type.
+func disabledRejection(upstream string) *RejectError {
+	msg := "upstream " + upstream + " is disabled"
+	body, _ := json.Marshal(map[string]interface{}{
+		"error": map[string]interface{}{
+			"type":     "upstream_disabled",
+			"message":  msg,
+			"upstream": upstream,
+		},
+	})
+	return &RejectError{
+		StatusCode: http.StatusServiceUnavailable,
+		Message:    msg,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       body,
+	}
+}
+
+// pathRejection is the response for a path outside the upstream's
+// allowed_paths.
+func pathRejection(upstream, reqPath string) *RejectError {
+	msg := "path " + reqPath + " is not allowed for upstream " + upstream
+	body, _ := json.Marshal(map[string]interface{}{
+		"error": map[string]interface{}{
+			"type":     "path_not_allowed",
+			"message":  msg,
+			"upstream": upstream,
+		},
+	})
+	return &RejectError{
+		StatusCode: http.StatusForbidden,
+		Message:    msg,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       body,
+	}
+}
@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prismcat/prismcat/internal/config"
+)
+
+func TestMaintenanceModeShortCircuits(t *testing.T) {
+	called := false
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer upstream.Close()
+
+	p, repo := newTestProxy(t, upstream.URL)
+	if err := p.cfg.SetUpstreamMaintenance("up", config.MaintenanceConfig{Enabled: true, Message: "rotating keys", RetryAfter: 30}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "http://up.localhost/v1/chat", nil))
+
+	if called {
+		t.Fatalf("upstream contacted during maintenance")
+	}
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "30" {
+		t.Fatalf("code=%d retry-after=%q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	var body struct {
+		Error struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error.Message != "rotating keys" {
+		t.Fatalf("body = %s (%v)", rec.Body.String(), err)
+	}
+	if l := repo.only(t); l.StatusCode != http.StatusServiceUnavailable || l.Error == "" {
+		t.Fatalf("log = %+v", l)
+	}
+}
+
+func TestDisabledAndDeletedUpstreams(t *testing.T) {
+	called := false
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer upstream.Close()
+
+	p, repo := newTestProxy(t, upstream.URL)
+	if err := p.cfg.SetUpstreamDisabled("up", true); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "http://up.localhost/v1/chat", nil))
+	var body struct {
+		Error struct {
+			Type string `json:"type"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusServiceUnavailable || body.Error.Type != "upstream_disabled" {
+		t.Fatalf("disabled: %d %s (%v)", rec.Code, rec.Body.String(), err)
+	}
+	if called {
+		t.Fatalf("disabled upstream contacted")
+	}
+	if l := repo.only(t); l.Error != "upstream disabled" {
+		t.Fatalf("log = %+v", l)
+	}
+
+	// A deleted upstream is unknown to the proxy but keeps its config.
+	if err := p.cfg.RemoveUpstream("up"); err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "http://up.localhost/v1/chat", nil))
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("deleted: %d", rec.Code)
+	}
+	if up, ok := p.cfg.LookupUpstream("up"); !ok || up.Target != upstream.URL || up.DeletedAt == "" {
+		t.Fatalf("deleted config = %+v, %v", up, ok)
+	}
+	if err := p.cfg.SetUpstreamDisabled("up", false); err == nil {
+		t.Fatalf("enabling a deleted upstream succeeded")
+	}
+
+	if err := p.cfg.RestoreUpstream("up"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.cfg.SetUpstreamDisabled("up", false); err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "http://up.localhost/v1/chat", nil))
+	if rec.Code != http.StatusOK || !called {
+		t.Fatalf("restored: %d, contacted %v", rec.Code, called)
+	}
+}
+
+func TestAllowedPaths(t *testing.T) {
+	var forwarded []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = append(forwarded, r.URL.Path)
+	}))
+	defer upstream.Close()
+
+	p, _ := newTestProxy(t, upstream.URL)
+	p.cfg.Update(func(c *config.Config) {
+		up := c.Upstreams["up"]
+		up.AllowedPaths = []string{"/v1/chat/completions", "/v1/models*"}
+		c.Upstreams["up"] = up
+	})
+
+	for path, want := range map[string]int{
+		"/v1/chat/completions":                http.StatusOK,
+		"/v1/models/gpt-4o":                   http.StatusOK,
+		"/v1/files":                           http.StatusForbidden,
+		"/v1/chat/completions/../../v1/files": http.StatusForbidden,
+		"/v1/models/../organization/api_keys": http.StatusForbidden,
+	} {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "http://up.localhost"+path, nil))
+		if rec.Code != want {
+			t.Errorf("%s: status %d, want %d (%s)", path, rec.Code, want, rec.Body)
+		}
+	}
+	if len(forwarded) != 2 {
+		t.Fatalf("forwarded = %v", forwarded)
+	}
+}
@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// metadataHeader carries client-supplied log metadata as comma-separated
+// key=value pairs with percent-encoded values, like W3C baggage:
+//
+//	X-PrismCat-Metadata: session=abc123, prompt=summarize%2Cv2
+const metadataHeader = "X-PrismCat-Metadata"
+
+const (
+	maxMetadataEntries    = 32
+	maxMetadataValueBytes = 1024
+)
+
+// parseMetadata decodes all X-PrismCat-Metadata headers. Malformed pairs are
+// skipped; later keys win. Returns nil when there are none.
+func parseMetadata(h http.Header) map[string]string {
+	var md map[string]string
+	for _, line := range h.Values(metadataHeader) {
+		for _, pair := range strings.Split(line, ",") {
+			k, v, ok := strings.Cut(pair, "=")
+			k = strings.TrimSpace(k)
+			if !ok || k == "" {
+				continue
+			}
+			v = strings.TrimSpace(v)
+			if dec, err := url.PathUnescape(v); err == nil {
+				v = dec
+			}
+			if _, exists := md[k]; !exists && len(md) >= maxMetadataEntries {
+				continue
+			}
+			if md == nil {
+				md = make(map[string]string)
+			}
+			md[k] = truncateValue(v, maxMetadataValueBytes)
+		}
+	}
+	return md
+}
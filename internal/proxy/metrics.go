@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+// transportMetrics aggregates connection-level counters per upstream, plus
+// open connections per dialed address (upstreams may share a host).
+type transportMetrics struct {
+	mu        sync.Mutex
+	upstreams map[string]*upstreamConnStats
+	open      map[string]*atomic.Int64
+}
+
+type upstreamConnStats struct {
+	requests   int64
+	dials      int64
+	dialErrors int64
+	reused     int64
+	dns        summary
+	connect    summary
+	tls        summary
+	ttfb       summary
+}
+
+// summary is a Prometheus summary without quantiles: count and sum.
+type summary struct {
+	count int64
+	sum   float64 // seconds
+}
+
+func (s *summary) observeMs(ms float64) {
+	s.count++
+	s.sum += ms / 1000
+}
+
+func newTransportMetrics() *transportMetrics {
+	return &transportMetrics{
+		upstreams: make(map[string]*upstreamConnStats),
+		open:      make(map[string]*atomic.Int64),
+	}
+}
+
+func (m *transportMetrics) openConns(addr string) *atomic.Int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.open[addr]
+	if !ok {
+		c = new(atomic.Int64)
+		m.open[addr] = c
+	}
+	return c
+}
+
+// record adds one upstream request. t is nil when no connection was
+// requested (e.g. the request was rejected first).
+func (m *transportMetrics) record(upstream string, t *storage.ConnTimings, dialErr bool) {
+	if t == nil && !dialErr {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.upstreams[upstream]
+	if !ok {
+		s = &upstreamConnStats{}
+		m.upstreams[upstream] = s
+	}
+	s.requests++
+	if dialErr {
+		s.dialErrors++
+	}
+	if t == nil {
+		return
+	}
+	if t.Reused {
+		s.reused++
+	} else if !dialErr {
+		s.dials++
+	}
+	if t.DNSMs > 0 {
+		s.dns.observeMs(t.DNSMs)
+	}
+	if t.ConnectMs > 0 {
+		s.connect.observeMs(t.ConnectMs)
+	}
+	if t.TLSMs > 0 {
+		s.tls.observeMs(t.TLSMs)
+	}
+	if t.TTFBMs > 0 {
+		s.ttfb.observeMs(t.TTFBMs)
+	}
+}
+
+// WriteMetrics writes the transport metrics in the Prometheus text
+// exposition format.
+func (p *Proxy) WriteMetrics(w io.Writer) error {
+	return p.metrics.write(w)
+}
+
+func (m *transportMetrics) write(w io.Writer) error {
+	m.mu.Lock()
+	names := make([]string, 0, len(m.upstreams))
+	stats := make(map[string]upstreamConnStats, len(m.upstreams))
+	for name, s := range m.upstreams {
+		names = append(names, name)
+		stats[name] = *s
+	}
+	addrs := make([]string, 0, len(m.open))
+	open := make(map[string]int64, len(m.open))
+	for addr, c := range m.open {
+		addrs = append(addrs, addr)
+		open[addr] = c.Load()
+	}
+	m.mu.Unlock()
+	sort.Strings(names)
+	sort.Strings(addrs)
+
+	bw := bufio.NewWriter(w)
+	counter := func(name, help string, value func(upstreamConnStats) int64) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, u := range names {
+			fmt.Fprintf(bw, "%s{upstream=\"%s\"} %d\n", name, escapeLabel(u), value(stats[u]))
+		}
+	}
+	summaryOf := func(name, help string, value func(upstreamConnStats) summary) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s summary\n", name, help, name)
+		for _, u := range names {
+			s := value(stats[u])
+			fmt.Fprintf(bw, "%s_sum{upstream=\"%s\"} %g\n", name, escapeLabel(u), s.sum)
+			fmt.Fprintf(bw, "%s_count{upstream=\"%s\"} %d\n", name, escapeLabel(u), s.count)
+		}
+	}
+
+	counter("prismcat_upstream_requests_total", "Requests sent to the upstream.",
+		func(s upstreamConnStats) int64 { return s.requests })
+	counter("prismcat_upstream_dials_total", "New connections opened to the upstream.",
+		func(s upstreamConnStats) int64 { return s.dials })
+	counter("prismcat_upstream_dial_errors_total", "Requests whose connection attempt failed.",
+		func(s upstreamConnStats) int64 { return s.dialErrors })
+	counter("prismcat_upstream_reused_connections_total", "Requests served on a pooled connection.",
+		func(s upstreamConnStats) int64 { return s.reused })
+	summaryOf("prismcat_upstream_dns_seconds", "DNS resolution time.",
+		func(s upstreamConnStats) summary { return s.dns })
+	summaryOf("prismcat_upstream_connect_seconds", "TCP connect time.",
+		func(s upstreamConnStats) summary { return s.connect })
+	summaryOf("prismcat_upstream_tls_handshake_seconds", "TLS handshake time.",
+		func(s upstreamConnStats) summary { return s.tls })
+	summaryOf("prismcat_upstream_ttfb_seconds", "Time from requesting a connection to the first response byte.",
+		func(s upstreamConnStats) summary { return s.ttfb })
+
+	fmt.Fprintf(bw, "# HELP prismcat_upstream_open_connections Open upstream connections by dialed address.\n# TYPE prismcat_upstream_open_connections gauge\n")
+	for _, addr := range addrs {
+		fmt.Fprintf(bw, "prismcat_upstream_open_connections{addr=\"%s\"} %d\n", escapeLabel(addr), open[addr])
+	}
+	return bw.Flush()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,216 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+// Middleware hooks into the proxy pipeline without modifying ServeHTTP.
+//
+// Hooks run in registration order. Each hook may mutate the objects it is given:
+//   - OnRequest sees the outgoing upstream request (headers already copied, Host set)
+//     and may change headers, URL or body. Returning an error aborts forwarding;
+//     return a *RejectError to control the status code seen by the client.
+//   - OnResponse sees the upstream response before anything is written to the client
+//     and may change status code or headers. Returning an error aborts the response
+//     with 502 (or the *RejectError status).
+//   - OnStreamChunk sees every chunk of the response body (streaming or not) before
+//     it is written to the client and captured for logging. For SSE and NDJSON
+//     streams each chunk is one complete event. It returns the bytes to forward;
+//     returning an error aborts the copy.
+//
+// Embed BaseMiddleware to implement only the hooks you need.
+type Middleware interface {
+	OnRequest(ex *Exchange, req *http.Request) error
+	OnResponse(ex *Exchange, resp *http.Response) error
+	OnStreamChunk(ex *Exchange, chunk []byte) ([]byte, error)
+}
+
+// BaseMiddleware provides no-op hooks for embedding.
+type BaseMiddleware struct{}
+
+func (BaseMiddleware) OnRequest(*Exchange, *http.Request) error   { return nil }
+func (BaseMiddleware) OnResponse(*Exchange, *http.Response) error { return nil }
+func (BaseMiddleware) OnStreamChunk(_ *Exchange, chunk []byte) ([]byte, error) {
+	return chunk, nil
+}
+
+// Exchange carries per-request state shared by middlewares.
+type Exchange struct {
+	// Upstream is the resolved upstream name (e.g. "openai").
+	Upstream string
+	// UpstreamConfig is a copy of the upstream's configuration.
+	UpstreamConfig config.UpstreamConfig
+	// Log is the log entry that will be persisted for this request.
+	// Middlewares may annotate it (e.g. Tag, Error).
+	Log *storage.RequestLog
+	// ClientRequest is the inbound request. Treat as read-only; mutate the
+	// upstream request passed to OnRequest instead.
+	ClientRequest *http.Request
+
+	// Values is free-form storage for passing state between hooks of the same middleware.
+	Values map[string]interface{}
+
+	body         []byte
+	bodyBuffered bool
+}
+
+// ErrBodyTooLarge is returned by Exchange.RequestBody when the body exceeds the buffer limit.
+var ErrBodyTooLarge = errors.New("request body too large to buffer")
+
+// maxBufferedRequestBody bounds how much of a request body middlewares may buffer.
+const maxBufferedRequestBody = 32 << 20 // 32MB
+
+// RequestBody buffers the upstream request body so middlewares can inspect it,
+// then restores req.Body so it is still forwarded (and captured) unchanged.
+// The result is cached for subsequent calls within the same exchange.
+//
+// If the body is larger than the buffer limit, ErrBodyTooLarge is returned and
+// the body is left intact for streaming.
+func (ex *Exchange) RequestBody(req *http.Request) ([]byte, error) {
+	if ex.bodyBuffered {
+		return ex.body, nil
+	}
+	if req.Body == nil || req.Body == http.NoBody {
+		ex.bodyBuffered = true
+		return nil, nil
+	}
+
+	orig := req.Body
+	data, err := io.ReadAll(io.LimitReader(orig, maxBufferedRequestBody+1))
+	if err != nil {
+		return nil, fmt.Errorf("read request body: %w", err)
+	}
+	if int64(len(data)) > maxBufferedRequestBody {
+		req.Body = &teeReadCloser{r: io.MultiReader(bytes.NewReader(data), orig), c: orig}
+		return nil, ErrBodyTooLarge
+	}
+
+	ex.setBody(req, data, orig)
+	return data, nil
+}
+
+// SetMetadata attaches a metadata key/value to the log entry. An empty value
+// removes the key.
+func (ex *Exchange) SetMetadata(key, value string) {
+	if ex.Log.Metadata == nil {
+		ex.Log.Metadata = make(map[string]string)
+	}
+	ex.Log.Metadata[key] = value
+}
+
+// SetRequestBody replaces the upstream request body and fixes up Content-Length.
+func (ex *Exchange) SetRequestBody(req *http.Request, data []byte) {
+	var closer io.Closer
+	if req.Body != nil {
+		closer = req.Body
+	}
+	ex.setBody(req, data, closer)
+	req.Header.Del("Content-Encoding")
+}
+
+func (ex *Exchange) setBody(req *http.Request, data []byte, closer io.Closer) {
+	ex.body = data
+	ex.bodyBuffered = true
+
+	if closer == nil {
+		closer = io.NopCloser(nil)
+	}
+	req.Body = &teeReadCloser{r: bytes.NewReader(data), c: closer}
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	req.ContentLength = int64(len(data))
+	if len(data) == 0 {
+		req.Body = http.NoBody
+	}
+	req.Header.Set("Content-Length", strconv.Itoa(len(data)))
+	// The client body is already in hand; waiting for the upstream's
+	// 100 Continue would only delay the request.
+	req.Header.Del("Expect")
+}
+
+// RejectError lets a middleware answer the client directly instead of forwarding.
+type RejectError struct {
+	StatusCode int
+	Message    string
+	// Header and Body are optional. When Body is nil, Message is written as text/plain.
+	Header http.Header
+	Body   []byte
+}
+
+// Reject creates a RejectError with a plain-text message.
+func Reject(statusCode int, message string) *RejectError {
+	return &RejectError{StatusCode: statusCode, Message: message}
+}
+
+func (e *RejectError) Error() string {
+	return fmt.Sprintf("rejected (%d): %s", e.StatusCode, e.Message)
+}
+
+func (e *RejectError) write(w http.ResponseWriter) {
+	for k, vv := range e.Header {
+		for _, v := range vv {
+			w.Header().Add(k, v)
+		}
+	}
+	if e.Body == nil {
+		http.Error(w, e.Message, e.StatusCode)
+		return
+	}
+	w.WriteHeader(e.StatusCode)
+	_, _ = w.Write(e.Body)
+}
+
+// asRejectError converts a middleware error into a RejectError, using fallbackStatus
+// for plain errors.
+func asRejectError(err error, fallbackStatus int) *RejectError {
+	var rej *RejectError
+	if errors.As(err, &rej) {
+		return rej
+	}
+	return Reject(fallbackStatus, err.Error())
+}
+
+func (p *Proxy) runOnRequest(ex *Exchange, req *http.Request) error {
+	for _, mw := range p.middlewares {
+		if err := mw.OnRequest(ex, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Proxy) runOnResponse(ex *Exchange, resp *http.Response) error {
+	for _, mw := range p.middlewares {
+		if err := mw.OnResponse(ex, resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chunkTransform returns a transform applying OnStreamChunk of all middlewares,
+// or nil when no middleware is registered.
+func (p *Proxy) chunkTransform(ex *Exchange) func([]byte) ([]byte, error) {
+	if len(p.middlewares) == 0 {
+		return nil
+	}
+	return func(chunk []byte) ([]byte, error) {
+		var err error
+		for _, mw := range p.middlewares {
+			chunk, err = mw.OnStreamChunk(ex, chunk)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return chunk, nil
+	}
+}
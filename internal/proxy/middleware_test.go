@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+// captureRepo records saved logs; the proxy only calls SaveLog, so the
+// remaining Repository methods come from the (nil) embedded interface.
+type captureRepo struct {
+	storage.Repository
+
+	mu   sync.Mutex
+	logs map[string]*storage.RequestLog
+}
+
+func (c *captureRepo) SaveLog(ctx context.Context, l *storage.RequestLog) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.logs == nil {
+		c.logs = make(map[string]*storage.RequestLog)
+	}
+	cp := *l
+	c.logs[l.ID] = &cp
+	return nil
+}
+
+func (c *captureRepo) only(t *testing.T) *storage.RequestLog {
+	t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.logs) != 1 {
+		t.Fatalf("logs = %d, want 1", len(c.logs))
+	}
+	for _, l := range c.logs {
+		return l
+	}
+	return nil
+}
+
+func newTestProxy(t *testing.T, target string, mws ...Middleware) (*Proxy, *captureRepo) {
+	t.Helper()
+	cfg := &config.Config{
+		Server:    config.ServerConfig{ProxyDomains: []string{"localhost"}},
+		Logging:   config.LoggingConfig{MaxRequestBody: 1 << 20, MaxResponseBody: 1 << 20},
+		Upstreams: map[string]config.UpstreamConfig{"up": {Target: target}},
+	}
+	repo := &captureRepo{}
+	return New(cfg, repo, mws...), repo
+}
+
+type headerAndBodyMiddleware struct {
+	BaseMiddleware
+	seenBody string
+}
+
+func (m *headerAndBodyMiddleware) OnRequest(ex *Exchange, req *http.Request) error {
+	body, err := ex.RequestBody(req)
+	if err != nil {
+		return err
+	}
+	m.seenBody = string(body)
+	req.Header.Set("X-Injected", "1")
+	return nil
+}
+
+func (m *headerAndBodyMiddleware) OnStreamChunk(_ *Exchange, chunk []byte) ([]byte, error) {
+	return bytes.ToUpper(chunk), nil
+}
+
+func TestMiddlewareCanInspectBodyAndTransformResponse(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("X-Injected") != "1" {
+			t.Errorf("missing injected header")
+		}
+		_, _ = w.Write([]byte("echo:" + string(body)))
+	}))
+	defer upstream.Close()
+
+	mw := &headerAndBodyMiddleware{}
+	p, repo := newTestProxy(t, upstream.URL, mw)
+
+	req := httptest.NewRequest(http.MethodPost, "http://up.localhost/v1/x", bytes.NewReader([]byte("hello")))
+	req.Header.Set("Content-Type", "text/plain")
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+
+	if mw.seenBody != "hello" {
+		t.Fatalf("middleware saw body %q, want hello", mw.seenBody)
+	}
+	if got := rec.Body.String(); got != "ECHO:HELLO" {
+		t.Fatalf("client body = %q, want ECHO:HELLO", got)
+	}
+	entry := repo.only(t)
+	if entry.RequestBody != "hello" {
+		t.Fatalf("logged request body = %q, want hello", entry.RequestBody)
+	}
+}
+
+type rejectMiddleware struct{ BaseMiddleware }
+
+func (rejectMiddleware) OnRequest(*Exchange, *http.Request) error {
+	return Reject(http.StatusForbidden, "nope")
+}
+
+func TestMiddlewareRejectSkipsUpstream(t *testing.T) {
+	called := false
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer upstream.Close()
+
+	p, repo := newTestProxy(t, upstream.URL, rejectMiddleware{})
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://up.localhost/", nil))
+
+	if called {
+		t.Fatalf("upstream was called despite rejection")
+	}
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want 403", rec.Code)
+	}
+	if entry := repo.only(t); entry.StatusCode != http.StatusForbidden || entry.Error == "" {
+		t.Fatalf("unexpected log entry: status=%d error=%q", entry.StatusCode, entry.Error)
+	}
+}
@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+)
+
+// PathRoutePrefix starts the path of requests that name their upstream in
+// the URL (server.routing_mode "path" or "both"): "/u/openai/v1/models" is
+// forwarded to upstream "openai" as "/v1/models".
+const PathRoutePrefix = "/u/"
+
+// SplitPathRoute splits a "/u/<upstream>/..." path into the upstream name and
+// the path to forward. ok is false for other paths.
+func SplitPathRoute(p string) (upstream, rest string, ok bool) {
+	if !strings.HasPrefix(p, PathRoutePrefix) {
+		return "", "", false
+	}
+	name, rest, _ := strings.Cut(p[len(PathRoutePrefix):], "/")
+	if name == "" {
+		return "", "", false
+	}
+	return strings.ToLower(name), "/" + rest, true
+}
+
+// stripPathRoute returns the upstream named by r's path prefix and a shallow
+// copy of r without the prefix, so that matching, logging and forwarding all
+// see the upstream's own path.
+func stripPathRoute(r *http.Request) (string, *http.Request, bool) {
+	name, rest, ok := SplitPathRoute(r.URL.Path)
+	if !ok {
+		return "", r, false
+	}
+	u := *r.URL
+	u.Path = rest
+	if u.RawPath != "" {
+		// The escaped form carries the same prefix (the name itself may be
+		// escaped, so cut at the first slash after it).
+		_, rawRest, _ := strings.Cut(strings.TrimPrefix(u.RawPath, PathRoutePrefix), "/")
+		u.RawPath = "/" + rawRest
+	}
+	stripped := r.WithContext(r.Context())
+	stripped.URL = &u
+	return name, stripped, true
+}
@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+func TestPathRouting(t *testing.T) {
+	var gotURI string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotURI = r.URL.RequestURI()
+	}))
+	defer upstream.Close()
+
+	p, repo := newTestProxy(t, upstream.URL)
+	serve := func(mode, url string) int {
+		p.cfg.Server.RoutingMode = mode
+		repo.logs = nil
+		gotURI = ""
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		return rec.Code
+	}
+
+	if code := serve(config.RoutingModePath, "http://127.0.0.1:8080/u/UP/v1/models?x=1"); code != http.StatusOK || gotURI != "/v1/models?x=1" {
+		t.Fatalf("path mode: %d, upstream saw %q", code, gotURI)
+	}
+	if l := repo.only(t); l.Upstream != "up" || l.Path != "/v1/models" || !l.HasFlag(storage.FlagPathRouted) {
+		t.Fatalf("log = upstream %q path %q flags %v", l.Upstream, l.Path, l.Flags)
+	}
+	if code := serve(config.RoutingModePath, "http://up.localhost/v1/models"); code != http.StatusBadRequest {
+		t.Fatalf("path mode, host only: %d, want 400", code)
+	}
+
+	// Host routing keeps the path as is.
+	if code := serve(config.RoutingModeHost, "http://up.localhost/u/other/v1"); code != http.StatusOK || gotURI != "/u/other/v1" {
+		t.Fatalf("host mode: %d, upstream saw %q", code, gotURI)
+	}
+	if code := serve(config.RoutingModeBoth, "http://up.localhost/v1/models"); code != http.StatusOK || gotURI != "/v1/models" {
+		t.Fatalf("both, by host: %d, upstream saw %q", code, gotURI)
+	}
+	if code := serve(config.RoutingModeBoth, "http://localhost/u/up/v1/models"); code != http.StatusOK || gotURI != "/v1/models" {
+		t.Fatalf("both, by path: %d, upstream saw %q", code, gotURI)
+	}
+}
+
+func TestPathRoutedSampling(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer upstream.Close()
+
+	p, repo := newTestProxy(t, upstream.URL)
+	p.cfg.Server.RoutingMode = config.RoutingModePath
+	p.cfg.Upstreams["up"] = config.UpstreamConfig{Target: upstream.URL, Sampling: config.SamplingConfig{Rate: 0.1}}
+	p.sampleRand = func() float64 { return 0.5 } // always outside the sample
+
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/u/up/ok", nil))
+	if len(repo.logs) != 0 {
+		t.Fatalf("sampled-out path-routed success was logged: %d logs", len(repo.logs))
+	}
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/u/up/fail", nil))
+	if l := repo.only(t); l.StatusCode != http.StatusBadGateway || !l.HasFlag(storage.FlagPathRouted) {
+		t.Fatalf("status = %d, flags = %v", l.StatusCode, l.Flags)
+	}
+}
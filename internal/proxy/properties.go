@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/prismcat/prismcat/internal/config"
+)
+
+// propertyHeaderPrefix marks request headers recorded as log properties, in
+// canonical header form.
+const propertyHeaderPrefix = "X-Prismcat-Property-"
+
+const (
+	defaultMaxProperties    = 20
+	defaultMaxPropertyValue = 256
+)
+
+// captureProperties collects X-PrismCat-Property-* headers as lowercased
+// key/value pairs, honouring the allowlist and limits. Keys are taken in
+// sorted order so the count cap is deterministic. Returns nil when there
+// are none.
+func captureProperties(h http.Header, cfg config.PropertiesConfig) map[string]string {
+	maxCount := cfg.MaxCount
+	if maxCount <= 0 {
+		maxCount = defaultMaxProperties
+	}
+	maxValue := cfg.MaxValueBytes
+	if maxValue <= 0 {
+		maxValue = defaultMaxPropertyValue
+	}
+	var allow map[string]bool
+	if len(cfg.Allow) > 0 {
+		allow = make(map[string]bool, len(cfg.Allow))
+		for _, k := range cfg.Allow {
+			allow[strings.ToLower(strings.TrimSpace(k))] = true
+		}
+	}
+
+	var keys []string
+	for k := range h {
+		if isPropertyHeader(k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var props map[string]string
+	for _, k := range keys {
+		key := strings.ToLower(k[len(propertyHeaderPrefix):])
+		if key == "" || (allow != nil && !allow[key]) {
+			continue
+		}
+		if len(props) >= maxCount {
+			break
+		}
+		if props == nil {
+			props = make(map[string]string)
+		}
+		props[key] = truncateValue(strings.TrimSpace(h.Get(k)), maxValue)
+	}
+	return props
+}
+
+// stripPropertyHeaders removes X-PrismCat-Property-* headers so they never
+// reach the upstream, whether or not they were recorded.
+func stripPropertyHeaders(h http.Header) {
+	for k := range h {
+		if isPropertyHeader(k) {
+			delete(h, k)
+		}
+	}
+}
+
+func isPropertyHeader(k string) bool {
+	return len(k) > len(propertyHeaderPrefix) && strings.EqualFold(k[:len(propertyHeaderPrefix)], propertyHeaderPrefix)
+}
+
+// truncateValue cuts s to at most n bytes on a rune boundary.
+func truncateValue(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	s = s[:n]
+	for len(s) > 0 && !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
@@ -5,9 +5,12 @@ import (
 	"compress/flate"
 	"compress/gzip"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math/rand"
 	"mime"
 	"net"
 	"net/http"
@@ -22,58 +25,173 @@ import (
 	"github.com/andybalholm/brotli"
 	"github.com/google/uuid"
 
+	"github.com/prismcat/prismcat/internal/awsauth"
+	"github.com/prismcat/prismcat/internal/azure"
 	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/demo"
+	"github.com/prismcat/prismcat/internal/dnscache"
+	"github.com/prismcat/prismcat/internal/gcpauth"
+	"github.com/prismcat/prismcat/internal/llm"
+	"github.com/prismcat/prismcat/internal/rules"
 	"github.com/prismcat/prismcat/internal/storage"
 )
 
+// UpstreamHeader lets a client pick the upstream by name when it can't use
+// subdomain hosts. It takes precedence over the host and is not forwarded.
+const UpstreamHeader = "X-PrismCat-Upstream"
+
 var b64Regex = regexp.MustCompile(`(data:[^\s]+?;base64,)?([A-Za-z0-9+/]{200,}[=]{0,2})`)
 
 // Proxy handles host-based upstream routing and request/response logging.
 type Proxy struct {
-	cfg    *config.Config
-	repo   storage.Repository
-	client *http.Client
+	cfg         *config.Config
+	repo        storage.LogWriter
+	client      *http.Client
+	middlewares []Middleware
+	rules       *rules.Engine
+	guardrails  []*guardrail
+	canaries    *canaryRouter
+	sampleRand  func() float64
+	bandwidth   *bandwidthLimits
+	metrics     *transportMetrics
+	dns         *dnscache.Cache
+	// retryClient resends a request after a retryable transport error; it
+	// never reuses connections, so the retry can't land on another dead one.
+	retryClient *http.Client
+	// demo serves upstreams of type "demo" in process.
+	demo *http.Client
+	// aws resolves credentials for upstreams of type "bedrock".
+	aws *awsauth.Resolver
+	// gcp issues access tokens for upstreams of type "vertex-ai".
+	gcp *gcpauth.Resolver
+	// duplicates flags repeated identical requests (logging.duplicate_window_seconds).
+	duplicates *duplicateTracker
+	// failures counts consecutive upstream failures (server.failure_notify_threshold).
+	failures *failureTracker
+	// journal records requests before they are forwarded (storage.journal).
+	journal *storage.Journal
+
+	// schemas caches compiled response schemas (see responseSchemaFor).
+	schemas sync.Map
 }
 
-// New creates a new proxy instance.
-func New(cfg *config.Config, repo storage.Repository) *Proxy {
+// New creates a new proxy instance. Middlewares run in the order given.
+func New(cfg *config.Config, repo storage.LogWriter, middlewares ...Middleware) *Proxy {
+	metrics := newTransportMetrics()
+	dns := dnscache.New()
+	dial := dns.DialContext((&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext, func() (time.Duration, time.Duration) {
+		c := cfg.DNSSnapshot()
+		return time.Duration(c.CacheTTLSeconds) * time.Second, time.Duration(c.NegativeTTLSeconds) * time.Second
+	})
 	transport := &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		DialContext: (&net.Dialer{
-			Timeout:   30 * time.Second,
-			KeepAlive: 30 * time.Second,
-		}).DialContext,
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           countingDialer(metrics, familyDialer(dial)),
 		MaxIdleConns:          100,
 		MaxIdleConnsPerHost:   10,
 		IdleConnTimeout:       90 * time.Second,
 		TLSHandshakeTimeout:   10 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
 	}
+	retryTransport := transport.Clone()
+	retryTransport.DisableKeepAlives = true
+
+	ruleEngine, err := rules.NewEngine(cfg.RulesSnapshot())
+	if err != nil {
+		log.Printf("request rules disabled: %v", err)
+		ruleEngine = nil
+	}
+	guardrails, err := compileGuardrails(cfg.GuardrailsSnapshot())
+	if err != nil {
+		log.Printf("content guardrails disabled: %v", err)
+		guardrails = nil
+	}
 
 	return &Proxy{
-		cfg:  cfg,
-		repo: repo,
-		client: &http.Client{
-			// Do not follow redirects automatically.
-			CheckRedirect: func(req *http.Request, via []*http.Request) error {
-				return http.ErrUseLastResponse
-			},
-			Transport: transport,
-		},
+		cfg:         cfg,
+		repo:        repo,
+		middlewares: middlewares,
+		rules:       ruleEngine,
+		guardrails:  guardrails,
+		canaries:    newCanaryRouter(),
+		sampleRand:  rand.Float64,
+		bandwidth:   newBandwidthLimits(),
+		metrics:     metrics,
+		dns:         dns,
+		demo:        demo.Client(),
+		aws:         awsauth.NewResolver(),
+		gcp:         gcpauth.NewResolver(),
+		duplicates:  newDuplicateTracker(),
+		failures:    newFailureTracker(),
+		client:      &http.Client{CheckRedirect: noFollowRedirects, Transport: transport},
+		retryClient: &http.Client{CheckRedirect: noFollowRedirects, Transport: retryTransport},
 	}
 }
 
+// noFollowRedirects hands redirects back to the client instead of following them.
+func noFollowRedirects(req *http.Request, via []*http.Request) error {
+	return http.ErrUseLastResponse
+}
+
+// DNSCache returns the upstream DNS cache (active when dns.cache_ttl_seconds > 0).
+func (p *Proxy) DNSCache() *dnscache.Cache {
+	return p.dns
+}
+
 // ServeHTTP proxies the request to the configured upstream and logs the traffic.
 func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
 
 	serverCfg := p.cfg.ServerSnapshot()
-	loggingCfg := p.cfg.LoggingSnapshot()
+	loggingCfg := requestLogging{LoggingConfig: p.cfg.LoggingSnapshot()}
+
+	// Extract upstream name from host (e.g. openai.localhost -> openai) or
+	// path prefix (/u/openai/...), per server.routing_mode, unless the client
+	// names it explicitly (single-host deployments).
+	var subdomain string
+	if serverCfg.RoutesByHost() {
+		subdomain = config.ExtractSubdomain(r.Host, serverCfg.ProxyDomains)
+	}
+	pathRouted := false
+	if serverCfg.RoutesByPath() {
+		if name, stripped, ok := stripPathRoute(r); ok {
+			subdomain, r, pathRouted = name, stripped, true
+		}
+	}
+	headerRouted := false
+	if name := strings.TrimSpace(r.Header.Get(UpstreamHeader)); name != "" {
+		subdomain, headerRouted = strings.ToLower(name), true
+	}
+
+	// Bound the body before routes, rules or the Azure mapping peek at it, so
+	// server.max_request_bytes also caps what they buffer.
+	if limit := serverCfg.MaxRequestBytes; limit > 0 && r.Body != nil && r.Body != http.NoBody {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+
+	// The routing table (routes) takes precedence over the host and rules.
+	route, routed := p.matchRoute(r)
+	if routed && route.Upstream != "" {
+		subdomain = route.Upstream
+	}
+
+	// Config-defined rules may override routing, block, tag or mutate headers.
+	ruleRes := p.evaluateRules(r, subdomain)
+	if ruleRes.Route != "" && !routed {
+		subdomain = ruleRes.Route
+	}
 
-	// Extract upstream name from host (e.g. openai.localhost -> openai).
-	subdomain := config.ExtractSubdomain(r.Host, serverCfg.ProxyDomains)
 	if subdomain == "" {
-		http.Error(w, "invalid host: missing subdomain", http.StatusBadRequest)
+		switch {
+		case !serverCfg.RoutesByPath():
+			http.Error(w, "invalid host: missing subdomain (or set "+UpstreamHeader+")", http.StatusBadRequest)
+		case !serverCfg.RoutesByHost():
+			http.Error(w, "missing upstream: use "+PathRoutePrefix+"<upstream>/... (or set "+UpstreamHeader+")", http.StatusBadRequest)
+		default:
+			http.Error(w, "invalid host: missing subdomain (or use "+PathRoutePrefix+"<upstream>/..., or set "+UpstreamHeader+")", http.StatusBadRequest)
+		}
 		return
 	}
 
@@ -82,29 +200,128 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("unknown upstream: %s", subdomain), http.StatusBadGateway)
 		return
 	}
+	if routed && route.Target != "" {
+		upstream.Target = route.Target
+		upstream.Canary = config.CanaryConfig{} // the route's split replaces the canary
+	}
 
-	targetURL, err := url.Parse(upstream.Target)
+	// Canary rollout: route a share of traffic to the canary target.
+	variant, canary := p.canaries.pick(subdomain, upstream.Canary)
+	target := upstream.Target
+	if variant == variantCanary {
+		target = upstream.Canary.Target
+	}
+
+	targetURL, err := url.Parse(target)
 	if err != nil {
 		http.Error(w, "invalid upstream config", http.StatusInternalServerError)
 		return
 	}
 
 	upstreamURL := buildUpstreamURL(targetURL, r.URL)
+	if upstream.Type == config.UpstreamTypeAzureOpenAI {
+		if upstreamURL, err = azureUpstreamURL(r, targetURL, upstream.Azure); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	loggingCfg.applyCaptureRules(subdomain, r.Method, r.URL.Path)
+	loggingCfg.applyNoLogHeader(r.Header.Get(NoLogHeader))
 
 	// Initial log entry (best-effort). This allows the UI to show in-flight requests.
 	logEntry := &storage.RequestLog{
-		ID:        uuid.NewString(),
-		CreatedAt: startTime,
-		Upstream:  subdomain,
-		Method:    r.Method,
-		Path:      r.URL.Path,
-		Query:     r.URL.RawQuery,
-		TargetURL: upstreamURL.String(),
-		Tag:       r.Header.Get("X-PrismCat-Tag"),
+		ID:         uuid.NewString(),
+		CreatedAt:  startTime,
+		Upstream:   subdomain,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Query:      r.URL.RawQuery,
+		TargetURL:  upstreamURL.String(),
+		Tag:        r.Header.Get("X-PrismCat-Tag"),
+		Variant:    variant,
+		ClientIP:   resolveClientIP(r, serverCfg.TrustedProxies),
+		RemoteAddr: peerAddr(r),
+		UserAgent:  r.UserAgent(),
+		Source:     p.instanceSource(),
 
 		RequestHeaders: p.sanitizeHeaders(r.Header, loggingCfg.SensitiveHeaders),
+		Properties:     captureProperties(r.Header, loggingCfg.Properties),
+		Metadata:       parseMetadata(r.Header),
+	}
+	if ruleRes.Tag != "" {
+		logEntry.Tag = ruleRes.Tag
+	}
+	if pathRouted {
+		logEntry.AddFlag(storage.FlagPathRouted)
+	}
+	if headerRouted {
+		logEntry.AddFlag(storage.FlagUpstreamHeader)
+	}
+	if routed {
+		logEntry.AddFlag(storage.FlagRouted)
+		if route.Variant != "" {
+			logEntry.Variant = route.Variant
+		}
+	}
+	loggingCfg.applySampling(upstream.Sampling, logEntry.Tag != "", p.sampleRand)
+	if ruleRes.Blocked != nil {
+		rej := ruleRejection(ruleRes.Blocked)
+		logEntry.StatusCode = rej.StatusCode
+		logEntry.Error = fmt.Sprintf("blocked by rule %q", ruleRes.Blocked.Name)
+		p.finalizeAndSaveLog(logEntry, startTime, nil, nil, loggingCfg)
+		rej.write(w)
+		return
+	}
+	if upstream.Disabled {
+		rej := disabledRejection(subdomain)
+		logEntry.StatusCode = rej.StatusCode
+		logEntry.Error = "upstream disabled"
+		p.finalizeAndSaveLog(logEntry, startTime, nil, nil, loggingCfg)
+		rej.write(w)
+		return
+	}
+	if upstream.Maintenance.Enabled {
+		rej := maintenanceRejection(subdomain, upstream.Maintenance)
+		logEntry.StatusCode = rej.StatusCode
+		logEntry.Error = "upstream in maintenance mode"
+		p.finalizeAndSaveLog(logEntry, startTime, nil, nil, loggingCfg)
+		rej.write(w)
+		return
+	}
+	if !upstream.AllowsPath(r.URL.Path) {
+		rej := pathRejection(subdomain, r.URL.Path)
+		logEntry.StatusCode = rej.StatusCode
+		logEntry.Error = "path not in upstream allowed_paths"
+		p.finalizeAndSaveLog(logEntry, startTime, nil, nil, loggingCfg)
+		rej.write(w)
+		return
+	}
+	if limit := serverCfg.MaxRequestBytes; limit > 0 {
+		if r.ContentLength > limit {
+			logEntry.StatusCode = http.StatusRequestEntityTooLarge
+			logEntry.RequestBodySize = r.ContentLength
+			logEntry.Error = fmt.Sprintf("request body too large: Content-Length %d exceeds server.max_request_bytes %d", r.ContentLength, limit)
+			logEntry.AddFlag(storage.FlagRequestTooLarge)
+			p.finalizeAndSaveLog(logEntry, startTime, nil, nil, loggingCfg)
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+	}
+	journaled := false
+	if !loggingCfg.skip && !loggingCfg.sampledOut {
+		journaled = p.journalRequest(logEntry)
+		p.saveLogSnapshot(logEntry)
+	}
+	if variant == variantCanary {
+		defer canary.record(subdomain, upstream.Canary, logEntry)
+	}
+
+	ex := &Exchange{
+		Upstream:       subdomain,
+		UpstreamConfig: *upstream,
+		Log:            logEntry,
+		ClientRequest:  r,
 	}
-	p.saveLogSnapshot(logEntry)
 
 	// Per-request timeout: do NOT mutate a shared http.Client timeout.
 	timeoutSeconds := upstream.Timeout
@@ -113,13 +330,34 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(timeoutSeconds)*time.Second)
 	defer cancel()
+	ctx, trace := withConnTrace(withIPFamily(ctx, upstream.IPFamily))
 
 	// Capture request body for logging while streaming it to the upstream (no truncation of forwarding).
 	reqCapture := newLimitedCapture(loggingCfg.MaxRequestBody)
+	reqCapture.usage = newUsageTap(r.Header, false)
+	uploadLimits, downloadLimits := p.bandwidth.forUpstream(subdomain, upstream.Bandwidth, p.cfg.BandwidthSnapshot())
+	// rejectTooLarge answers 413 when err comes from reading past
+	// server.max_request_bytes (bodies without a Content-Length).
+	rejectTooLarge := func(err error) bool {
+		var mbe *http.MaxBytesError
+		if !errors.As(err, &mbe) {
+			return false
+		}
+		logEntry.StatusCode = http.StatusRequestEntityTooLarge
+		logEntry.Error = fmt.Sprintf("request body too large: exceeds server.max_request_bytes %d", mbe.Limit)
+		logEntry.AddFlag(storage.FlagRequestTooLarge)
+		p.finalizeAndSaveLog(logEntry, startTime, reqCapture, nil, loggingCfg)
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return true
+	}
 	var body io.Reader
 	if r.Body != nil && r.Body != http.NoBody {
-		tee := io.TeeReader(r.Body, reqCapture)
-		body = &teeReadCloser{r: tee, c: r.Body}
+		var src io.Reader = r.Body
+		if journaled {
+			src = p.newJournalBody(logEntry.ID, src)
+		}
+		tee := io.TeeReader(src, reqCapture)
+		body = &teeReadCloser{r: throttleReader(ctx, tee, uploadLimits), c: r.Body}
 	}
 
 	upstreamReq, err := http.NewRequestWithContext(ctx, r.Method, upstreamURL.String(), body)
@@ -131,13 +369,130 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	p.copyHeaders(upstreamReq.Header, r.Header)
+	stripPropertyHeaders(upstreamReq.Header)
+	upstreamReq.Header.Del(metadataHeader)
+	upstreamReq.Header.Del(UpstreamHeader)
+	upstreamReq.Header.Del(NoLogHeader)
 	// Host is special: set the field (Header["Host"] is ignored by net/http client).
 	upstreamReq.Host = targetURL.Host
 	// Preserve original length semantics if present.
 	upstreamReq.ContentLength = r.ContentLength
+	// Request trailers are filled in once the client body has been read to
+	// EOF, which is before the transport sends them on.
+	upstreamReq.Trailer = r.Trailer
+	// Protocol upgrades pass through only to upstreams that allow them.
+	var upgrade string
+	if upstream.AllowUpgrade {
+		if upgrade = requestUpgrade(r.Header); upgrade != "" {
+			passUpgrade(upstreamReq.Header, r.Header)
+		}
+	}
+	// Expect: 100-continue stays on requests with a body, so the upstream
+	// can refuse before the client uploads it: the client only gets its
+	// 100 Continue once the transport starts reading the body.
+	if r.ContentLength == 0 {
+		upstreamReq.Header.Del("Expect")
+	}
+	applyUpstreamHeaders(upstreamReq.Header, *upstream)
+	if upstream.Type == config.UpstreamTypeAzureOpenAI {
+		azure.ApplyAuth(upstreamReq.Header, upstream.Azure)
+	}
+	setForwardedHeaders(upstreamReq.Header, r, serverCfg.StripClientIdentity)
+	ruleRes.Apply(upstreamReq.Header)
+
+	if match, err := p.checkGuardrails(ex, upstreamReq); err != nil || match != nil {
+		if rejectTooLarge(err) {
+			return
+		}
+		// Bodies too large to inspect are refused rather than forwarded unchecked.
+		rej := Reject(http.StatusRequestEntityTooLarge, "request body too large for content inspection")
+		if match != nil {
+			rej = match.rejection()
+			logEntry.Error = fmt.Sprintf("blocked by guardrail %q (%s)", match.rule.name, match.reason)
+		} else {
+			logEntry.Error = fmt.Sprintf("guardrail inspection failed: %v", err)
+		}
+		logEntry.StatusCode = rej.StatusCode
+		logEntry.AddFlag(storage.FlagGuardrailBlocked)
+		p.finalizeAndSaveLog(logEntry, startTime, reqCapture, nil, loggingCfg)
+		rej.write(w)
+		return
+	}
 
-	resp, err := p.client.Do(upstreamReq)
+	if over, err := p.checkContextLimit(ex, upstreamReq); err != nil || over != nil {
+		if rejectTooLarge(err) {
+			return
+		}
+		if err != nil {
+			logEntry.StatusCode = http.StatusBadRequest
+			logEntry.Error = fmt.Sprintf("context limit check failed: %v", err)
+			p.finalizeAndSaveLog(logEntry, startTime, reqCapture, nil, loggingCfg)
+			http.Error(w, "failed to read request body", http.StatusBadRequest)
+			return
+		}
+		rej := over.rejection()
+		logEntry.StatusCode = rej.StatusCode
+		logEntry.Error = "rejected: " + over.String()
+		logEntry.AddFlag(storage.FlagContextLimit)
+		p.finalizeAndSaveLog(logEntry, startTime, reqCapture, nil, loggingCfg)
+		rej.write(w)
+		return
+	}
+
+	if err := p.runOnRequest(ex, upstreamReq); err != nil {
+		if rejectTooLarge(err) {
+			return
+		}
+		rej := asRejectError(err, http.StatusInternalServerError)
+		logEntry.StatusCode = rej.StatusCode
+		logEntry.Error = fmt.Sprintf("request rejected: %s", rej.Message)
+		p.finalizeAndSaveLog(logEntry, startTime, reqCapture, nil, loggingCfg)
+		rej.write(w)
+		return
+	}
+
+	if upstream.Type == config.UpstreamTypeVertexAI {
+		tok, err := p.gcp.Token(ctx, upstream.Vertex.CredentialsFile)
+		if err != nil {
+			logEntry.Error = fmt.Sprintf("google access token: %v", err)
+			p.finalizeAndSaveLog(logEntry, startTime, reqCapture, nil, loggingCfg)
+			http.Error(w, fmt.Sprintf("upstream error: %v", err), http.StatusBadGateway)
+			return
+		}
+		upstreamReq.Header.Set("Authorization", "Bearer "+tok)
+	}
+	if upstream.Type == config.UpstreamTypeBedrock {
+		if err := p.signBedrock(upstreamReq, upstream.Bedrock); err != nil {
+			if rejectTooLarge(err) {
+				return
+			}
+			logEntry.Error = fmt.Sprintf("sign request: %v", err)
+			p.finalizeAndSaveLog(logEntry, startTime, reqCapture, nil, loggingCfg)
+			http.Error(w, fmt.Sprintf("upstream error: %v", err), http.StatusBadGateway)
+			return
+		}
+	}
+
+	client := p.client
+	if upstream.Type == config.UpstreamTypeDemo {
+		client = p.demo
+	}
+	defer p.failures.record(subdomain, serverCfg.FailureNotifyThreshold, logEntry)
+	resp, err := client.Do(upstreamReq)
+	if err != nil && client == p.client && ctx.Err() == nil && !trace.requestWritten() && retryableTransportError(err) {
+		if retry := retryRequest(upstreamReq, reqCapture); retry != nil {
+			log.Printf("upstream %s: retrying %s %s on a new connection after: %v", subdomain, r.Method, logEntry.Path, err)
+			logEntry.AddFlag(storage.FlagRetried)
+			ex.SetMetadata(retryErrorMetadata, err.Error())
+			resp, err = p.retryClient.Do(retry)
+		}
+	}
+	logEntry.Connection = trace.result()
+	p.metrics.record(subdomain, logEntry.Connection, trace.dialFailed())
 	if err != nil {
+		if rejectTooLarge(err) {
+			return
+		}
 		logEntry.Error = fmt.Sprintf("upstream request failed: %v", err)
 		p.finalizeAndSaveLog(logEntry, startTime, reqCapture, nil, loggingCfg)
 		http.Error(w, fmt.Sprintf("upstream error: %v", err), http.StatusBadGateway)
@@ -145,27 +500,177 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusSwitchingProtocols && upgrade != "" {
+		p.serveUpgrade(w, resp, upgrade, logEntry, startTime, reqCapture, loggingCfg)
+		return
+	}
+
+	if err := p.runOnResponse(ex, resp); err != nil {
+		rej := asRejectError(err, http.StatusBadGateway)
+		logEntry.StatusCode = rej.StatusCode
+		logEntry.ResponseHeaders = p.headerToMap(resp.Header)
+		logEntry.Error = fmt.Sprintf("response rejected: %s", rej.Message)
+		p.finalizeAndSaveLog(logEntry, startTime, reqCapture, nil, loggingCfg)
+		rej.write(w)
+		return
+	}
+
+	if limit := upstream.MaxResponseBytes; limit > 0 && resp.ContentLength > limit {
+		logEntry.StatusCode = http.StatusBadGateway
+		logEntry.ResponseHeaders = p.headerToMap(resp.Header)
+		logEntry.Error = fmt.Sprintf("upstream response too large: Content-Length %d exceeds max_response_bytes %d (upstream status %d)", resp.ContentLength, limit, resp.StatusCode)
+		logEntry.AddFlag(storage.FlagResponseTooLarge)
+		p.finalizeAndSaveLog(logEntry, startTime, reqCapture, nil, loggingCfg)
+		http.Error(w, fmt.Sprintf("upstream error: response of %d bytes exceeds max_response_bytes", resp.ContentLength), http.StatusBadGateway)
+		return
+	}
+
 	logEntry.StatusCode = resp.StatusCode
 	logEntry.ResponseHeaders = p.headerToMap(resp.Header)
 	logEntry.Streaming = isStreaming(resp.Header)
 
-	// Forward response headers and status code.
+	// Forward response headers and status code. Trailers the upstream
+	// announced are announced again; their values follow the body.
 	p.copyHeaders(w.Header(), resp.Header)
+	for k := range resp.Trailer {
+		w.Header().Add("Trailer", k)
+	}
 	w.WriteHeader(resp.StatusCode)
 
 	// Forward response body while capturing a bounded preview for logging.
 	respCapture := newLimitedCapture(loggingCfg.MaxResponseBody)
-	copied, copyErr := copyWithOptionalFlush(w, resp.Body, respCapture, logEntry.Streaming)
+	respCapture.usage = newUsageTap(resp.Header, logEntry.Streaming)
+	out, stopKeepAlive := startKeepAlive(w, resp.Header.Get("Content-Type"), time.Duration(upstream.StreamKeepAliveSeconds)*time.Second)
+	copyOpts := copyOptions{flush: logEntry.Streaming, transform: p.chunkTransform(ex)}
+	if logEntry.Streaming {
+		copyOpts.split = eventSplitterFor(resp.Header)
+		if copyOpts.split != nil {
+			copyOpts.onEvent = eventTimer(logEntry, startTime, loggingCfg.MaxResponseBody)
+		}
+	}
+	respBody := limitResponse(resp.Body, upstream.MaxResponseBytes)
+	copied, copyErr := copyWithOptionalFlush(out, throttleReader(ctx, respBody, downloadLimits), respCapture, copyOpts)
+	stopKeepAlive()
+	trace.bodyDone(logEntry.Connection)
 	logEntry.ResponseBodySize = copied
+	forwardTrailers(w.Header(), resp.Trailer, logEntry)
+	var tooLarge *responseTooLarge
+	if errors.As(copyErr, &tooLarge) {
+		// The status line is already out, so a 502 is no longer possible:
+		// abort the connection so the client can't take the body as complete.
+		logEntry.AddFlag(storage.FlagResponseTooLarge)
+		logEntry.Error = fmt.Sprintf("upstream response too large: %v after %d bytes forwarded; connection aborted", tooLarge, copied)
+		p.finalizeAndSaveLog(logEntry, startTime, reqCapture, respCapture, loggingCfg)
+		panic(http.ErrAbortHandler)
+	}
 	if copyErr != nil {
-		// The response may already be partially written; we can only record the error.
-		logEntry.Error = fmt.Sprintf("forward response failed: %v", copyErr)
+		// The response may already be partially written: keep what was
+		// forwarded and record where it stopped.
+		logEntry.AddFlag(storage.FlagPartial)
+		if logEntry.Streaming {
+			logEntry.Error = fmt.Sprintf("forward response failed after %d bytes, %d events: %v", copied, logEntry.StreamEvents, copyErr)
+		} else {
+			logEntry.Error = fmt.Sprintf("forward response failed after %d bytes: %v", copied, copyErr)
+		}
+	} else {
+		p.validateResponseSchema(*upstream, logEntry, respCapture)
+		runResponseChecks(*upstream, logEntry, respCapture)
 	}
 
 	p.finalizeAndSaveLog(logEntry, startTime, reqCapture, respCapture, loggingCfg)
 }
 
-func (p *Proxy) finalizeAndSaveLog(log *storage.RequestLog, startTime time.Time, reqCap, respCap *limitedCapture, loggingCfg config.LoggingConfig) {
+// requestLogging is the logging configuration resolved for one request.
+type requestLogging struct {
+	config.LoggingConfig
+	// skip drops the log entirely (capture rule "none").
+	skip bool
+	// sampledOut drops the log unless the outcome makes it worth keeping
+	// (see keepUnsampled).
+	sampledOut bool
+	slowMs     int64
+}
+
+// applySampling decides up front whether a request falls outside the
+// upstream's sample. Tagged requests are always kept.
+func (l *requestLogging) applySampling(s config.SamplingConfig, tagged bool, random func() float64) {
+	if s.Rate <= 0 || s.Rate >= 1 || tagged {
+		return
+	}
+	l.sampledOut = random() >= s.Rate
+	l.slowMs = s.SlowMs
+}
+
+// keepUnsampled reports whether a sampled-out log must be kept anyway:
+// errors, slow requests and flagged logs are never dropped.
+func keepUnsampled(log *storage.RequestLog, latencyMs, slowMs int64) bool {
+	return log.Error != "" || log.StatusCode == 0 || log.StatusCode >= 400 ||
+		hasNotableFlag(log) || (slowMs > 0 && latencyMs >= slowMs)
+}
+
+// routingFlags record how a request found its upstream. Every request
+// routed that way carries one, so they don't exempt a log from sampling.
+var routingFlags = map[string]bool{
+	storage.FlagUpstreamHeader: true,
+	storage.FlagPathRouted:     true,
+	storage.FlagRouted:         true,
+}
+
+// hasNotableFlag reports whether the log carries a flag other than a
+// routing flag.
+func hasNotableFlag(log *storage.RequestLog) bool {
+	for _, f := range log.Flags {
+		if !routingFlags[f] {
+			return true
+		}
+	}
+	return false
+}
+
+// NoLogHeader lets a client suppress body capture ("body") or the whole log
+// entry ("all") for one request, when logging.allow_no_log_header is on.
+const NoLogHeader = "X-PrismCat-No-Log"
+
+// applyNoLogHeader honours NoLogHeader. It runs after capture rules and can
+// only reduce what they capture.
+func (l *requestLogging) applyNoLogHeader(value string) {
+	if !l.AllowNoLogHeader {
+		return
+	}
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "body":
+		l.MaxRequestBody, l.MaxResponseBody = 0, 0
+	case "all":
+		l.skip = true
+	}
+}
+
+// applyCaptureRules applies the first matching logging.capture_rules entry.
+// "metadata" keeps sizes, headers and timings but no bodies: a zero capture
+// limit still counts bytes without buffering them.
+func (l *requestLogging) applyCaptureRules(upstream, method, path string) {
+	for _, rule := range l.CaptureRules {
+		if !rule.Match(upstream, method, path) {
+			continue
+		}
+		switch rule.Capture {
+		case config.CaptureNone:
+			l.skip = true
+		case config.CaptureMetadata:
+			l.MaxRequestBody, l.MaxResponseBody = 0, 0
+		}
+		return
+	}
+}
+
+func (p *Proxy) finalizeAndSaveLog(log *storage.RequestLog, startTime time.Time, reqCap, respCap *limitedCapture, loggingCfg requestLogging) {
+	if loggingCfg.skip {
+		return
+	}
+	if loggingCfg.sampledOut && !keepUnsampled(log, time.Since(startTime).Milliseconds(), loggingCfg.slowMs) {
+		return
+	}
+	recordUsage(log, reqCap, respCap)
 	if reqCap != nil {
 		log.RequestBodySize = reqCap.Total()
 		contentType := firstHeaderValue(log.RequestHeaders, "Content-Type")
@@ -173,6 +678,11 @@ func (p *Proxy) finalizeAndSaveLog(log *storage.RequestLog, startTime time.Time,
 		body, truncated := bodyForLog(contentType, contentEncoding, reqCap.Bytes(), loggingCfg.MaxRequestBody, loggingCfg.StoreBase64)
 		log.RequestBody = body
 		log.Truncated = log.Truncated || truncated
+		// A partial body would group unrelated requests.
+		if !truncated && !reqCap.Truncated() {
+			log.Fingerprint = storage.RequestFingerprint(log.Upstream, log.Method, log.Path, log.Query, []byte(body))
+			p.duplicates.mark(log, time.Duration(loggingCfg.DuplicateWindowSeconds)*time.Second)
+		}
 	}
 	if respCap != nil {
 		contentType := firstHeaderValue(log.ResponseHeaders, "Content-Type")
@@ -180,6 +690,9 @@ func (p *Proxy) finalizeAndSaveLog(log *storage.RequestLog, startTime time.Time,
 		body, truncated := bodyForLog(contentType, contentEncoding, respCap.Bytes(), loggingCfg.MaxResponseBody, loggingCfg.StoreBase64)
 		log.ResponseBody = body
 		log.Truncated = log.Truncated || truncated
+		if loggingCfg.SummarizeEmbeddings {
+			summarizeEmbeddings(log)
+		}
 	}
 
 	log.Truncated = log.Truncated ||
@@ -187,9 +700,70 @@ func (p *Proxy) finalizeAndSaveLog(log *storage.RequestLog, startTime time.Time,
 		(respCap != nil && respCap.Truncated())
 	log.Latency = time.Since(startTime).Milliseconds()
 
+	if fc := loggingCfg.FullCapture; fc.Enabled && !fullCaptureTriggered(fc, log) {
+		previewBodies(log, loggingCfg.BodyPreviewBytes)
+	}
+
 	p.saveLogSnapshot(log)
 }
 
+// fullCaptureTriggered reports whether a log keeps its full bodies under
+// conditional capture.
+func fullCaptureTriggered(fc config.FullCaptureConfig, log *storage.RequestLog) bool {
+	minStatus := fc.MinStatus
+	if minStatus <= 0 {
+		minStatus = http.StatusBadRequest
+	}
+	header := fc.TriggerHeader
+	if header == "" {
+		header = "X-PrismCat-Capture"
+	}
+	return log.Error != "" || log.StatusCode == 0 || log.StatusCode >= minStatus ||
+		(fc.SlowMs > 0 && log.Latency >= fc.SlowMs) ||
+		firstHeaderValue(log.RequestHeaders, header) != ""
+}
+
+// previewBodies cuts both bodies to maxBytes on a UTF-8 boundary.
+func previewBodies(log *storage.RequestLog, maxBytes int64) {
+	cut := false
+	for _, body := range []*string{&log.RequestBody, &log.ResponseBody} {
+		if int64(len(*body)) <= maxBytes {
+			continue
+		}
+		n := int(maxBytes)
+		if n < 0 {
+			n = 0
+		}
+		for n > 0 && !utf8.RuneStart((*body)[n]) {
+			n--
+		}
+		*body = (*body)[:n]
+		cut = true
+	}
+	if cut {
+		log.Truncated = true
+		log.AddFlag(storage.FlagBodyPreview)
+	}
+}
+
+// summarizeEmbeddings replaces an embeddings response body with its summary.
+// The path check avoids decoding every JSON response; the body shape decides.
+func summarizeEmbeddings(log *storage.RequestLog) {
+	if !strings.Contains(strings.ToLower(log.Path), "embed") || log.ResponseBody == "" {
+		return
+	}
+	summary, ok := llm.SummarizeEmbeddings([]byte(log.ResponseBody))
+	if !ok {
+		return
+	}
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return
+	}
+	log.ResponseBody = string(data)
+	log.AddFlag(storage.FlagEmbeddingsSummarized)
+}
+
 func firstHeaderValue(headers map[string][]string, key string) string {
 	if headers == nil {
 		return ""
@@ -206,10 +780,28 @@ func firstHeaderValue(headers map[string][]string, key string) string {
 	return ""
 }
 
+// instanceSource is the source stamped on logs this instance records: its
+// cluster instance ID, or empty when not clustered.
+func (p *Proxy) instanceSource() string {
+	if c := p.cfg.ClusterSnapshot(); c.Enabled {
+		return c.InstanceID
+	}
+	return ""
+}
+
 func (p *Proxy) saveLogSnapshot(entry *storage.RequestLog) {
-	if err := p.repo.SaveLog(entry); err != nil {
+	// Not the request's context: a client that hangs up still gets logged.
+	if err := p.repo.SaveLog(context.Background(), entry); err != nil {
 		// Best-effort: avoid crashing the request path.
 		log.Printf("save log failed/dropped: %v", err)
+		// The request completed even though its final log was shed (or
+		// failed): close it in the journal, or the next start would restore
+		// it as interrupted.
+		if p.journal != nil && (entry.StatusCode != 0 || entry.Error != "") {
+			if err := p.journal.End(entry.ID); err != nil {
+				log.Printf("request journal write failed: %v", err)
+			}
+		}
 	}
 }
 
@@ -228,6 +820,103 @@ func (p *Proxy) copyHeaders(dst, src http.Header) {
 	}
 }
 
+// azureUpstreamURL maps an OpenAI-style request onto the Azure OpenAI
+// deployment URL, taking the deployment from the body's "model" field.
+func azureUpstreamURL(r *http.Request, target *url.URL, az config.AzureOpenAIConfig) (*url.URL, error) {
+	var model string
+	if azure.NeedsModel(r.URL.Path) {
+		if body, ok := peekJSONBody(r).(map[string]interface{}); ok {
+			model, _ = body["model"].(string)
+		}
+	}
+	return azure.RequestURL(target, r.URL, model, az)
+}
+
+// signBedrock buffers the request body and signs req with AWS SigV4. It runs
+// last so that the signature covers the headers actually sent.
+func (p *Proxy) signBedrock(req *http.Request, bc config.BedrockConfig) error {
+	creds, err := p.aws.Resolve(req.Context(), awsauth.Credentials{
+		AccessKeyID:     bc.AccessKeyID,
+		SecretAccessKey: bc.SecretAccessKey,
+		SessionToken:    bc.SessionToken,
+	})
+	if err != nil {
+		return err
+	}
+	var data []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		data, err = io.ReadAll(io.LimitReader(req.Body, maxBufferedRequestBody+1))
+		req.Body.Close()
+		if err != nil {
+			return fmt.Errorf("read request body: %w", err)
+		}
+		if int64(len(data)) > maxBufferedRequestBody {
+			return fmt.Errorf("request body exceeds %d bytes", int64(maxBufferedRequestBody))
+		}
+		req.Body = io.NopCloser(bytes.NewReader(data))
+		req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(data)), nil }
+	}
+	req.ContentLength = int64(len(data))
+	awsauth.Sign(req, data, "bedrock", bc.Region, creds, time.Now())
+	return nil
+}
+
+// applyUpstreamHeaders adds the upstream's default headers (without overriding
+// client-provided values) and its fixed User-Agent.
+func applyUpstreamHeaders(h http.Header, up config.UpstreamConfig) {
+	for k, v := range up.DefaultHeaders {
+		if h.Get(k) == "" {
+			h.Set(k, v)
+		}
+	}
+	if up.UserAgent != "" {
+		h.Set("User-Agent", up.UserAgent)
+	}
+}
+
+// clientIdentityHeaders reveal the client's address or the proxy chain.
+var clientIdentityHeaders = []string{
+	"Forwarded",
+	"X-Forwarded-For",
+	"X-Forwarded-Host",
+	"X-Forwarded-Proto",
+	"X-Forwarded-Port",
+	"X-Real-Ip",
+	"True-Client-Ip",
+	"Cf-Connecting-Ip",
+	"X-Client-Ip",
+	"Via",
+}
+
+// setForwardedHeaders appends the client address to X-Forwarded-For and sets
+// X-Forwarded-Host/Proto (keeping values set by a proxy in front of PrismCat).
+// With strip, all client-identifying headers are removed instead.
+func setForwardedHeaders(h http.Header, r *http.Request, strip bool) {
+	if strip {
+		for _, k := range clientIdentityHeaders {
+			h.Del(k)
+		}
+		return
+	}
+
+	if clientIP, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if prior := h.Values("X-Forwarded-For"); len(prior) > 0 {
+			clientIP = strings.Join(prior, ", ") + ", " + clientIP
+		}
+		h.Set("X-Forwarded-For", clientIP)
+	}
+	if h.Get("X-Forwarded-Host") == "" {
+		h.Set("X-Forwarded-Host", r.Host)
+	}
+	if h.Get("X-Forwarded-Proto") == "" {
+		proto := "http"
+		if r.TLS != nil {
+			proto = "https"
+		}
+		h.Set("X-Forwarded-Proto", proto)
+	}
+}
+
 // sanitizeHeaders masks configured sensitive headers.
 func (p *Proxy) sanitizeHeaders(headers http.Header, sensitiveHeaders []string) map[string][]string {
 	result := make(map[string][]string)
@@ -271,6 +960,24 @@ func (p *Proxy) headerToMap(headers http.Header) map[string][]string {
 	return result
 }
 
+// forwardTrailers sends the upstream's trailers (set once its body reached
+// EOF) after the forwarded body and records them with the response headers,
+// since some streaming APIs report usage there.
+func forwardTrailers(dst http.Header, trailer http.Header, log *storage.RequestLog) {
+	for k, vv := range trailer {
+		if len(vv) == 0 {
+			continue
+		}
+		for _, v := range vv {
+			dst.Add(http.TrailerPrefix+k, v)
+		}
+		if log.ResponseHeaders == nil {
+			log.ResponseHeaders = make(map[string][]string)
+		}
+		log.ResponseHeaders[k] = vv
+	}
+}
+
 func isHopByHopHeader(header string) bool {
 	// RFC 7230, section 6.1.
 	hopByHop := []string{
@@ -394,6 +1101,8 @@ func (t *teeReadCloser) Close() error               { return t.c.Close() }
 
 type limitedCapture struct {
 	max int64
+	// usage, when set, sees every byte whatever max (see usageTap).
+	usage *usageTap
 
 	mu sync.Mutex
 
@@ -406,11 +1115,21 @@ func newLimitedCapture(max int64) *limitedCapture {
 	return &limitedCapture{max: max}
 }
 
+// skip counts n bytes that passed through without being captured.
+func (c *limitedCapture) skip(n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.total += n
+}
+
 func (c *limitedCapture) Write(p []byte) (int, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	c.total += int64(len(p))
+	if c.usage != nil {
+		_, _ = c.usage.Write(p)
+	}
 	if c.max <= 0 {
 		return len(p), nil
 	}
@@ -452,34 +1171,106 @@ func (c *limitedCapture) Truncated() bool {
 	return c.truncated
 }
 
-func copyWithOptionalFlush(dst http.ResponseWriter, src io.Reader, capture io.Writer, flush bool) (int64, error) {
+// copyOptions controls how a response body is forwarded.
+type copyOptions struct {
+	// flush flushes dst after every write (streaming responses).
+	flush bool
+	// split, when set with flush, holds bytes back until a complete event and
+	// forwards events one at a time, so each flush carries whole events.
+	split eventSplitter
+	// transform rewrites every forwarded chunk (event when split is set).
+	transform func([]byte) ([]byte, error)
+	// onEvent is called with the output offset of each event before it is
+	// written.
+	onEvent func(offset int64)
+}
+
+// maxPendingEvent bounds how much is held back waiting for an event
+// boundary; longer events are forwarded as they arrive.
+const maxPendingEvent = 1 << 20 // 1MB
+
+// copyWithOptionalFlush forwards src to dst (and capture). When transform is non-nil,
+// every chunk is passed through it before being written; the returned count is the
+// number of bytes written to dst.
+func copyWithOptionalFlush(dst http.ResponseWriter, src io.Reader, capture io.Writer, opts copyOptions) (int64, error) {
 	var w io.Writer = dst
 	if capture != nil {
 		w = io.MultiWriter(dst, capture)
 	}
 
 	buf := make([]byte, 32*1024)
-	if !flush {
+	flusher, canFlush := dst.(http.Flusher)
+	flush := opts.flush && canFlush
+	if opts.transform == nil && !flush {
 		return io.CopyBuffer(w, src, buf)
 	}
 
-	flusher, ok := dst.(http.Flusher)
-	if !ok {
-		return io.CopyBuffer(w, src, buf)
+	var total int64
+	write := func(chunk []byte) error {
+		if opts.transform != nil {
+			var err error
+			if chunk, err = opts.transform(chunk); err != nil {
+				return err
+			}
+		}
+		if len(chunk) == 0 {
+			return nil
+		}
+		if opts.onEvent != nil {
+			opts.onEvent(total)
+		}
+		n, err := w.Write(chunk)
+		total += int64(n)
+		if err != nil {
+			return err
+		}
+		if flush {
+			flusher.Flush()
+		}
+		return nil
 	}
 
-	var total int64
+	split := opts.split
+	if !flush {
+		split = nil
+	}
+	var pending []byte
 	for {
 		n, err := src.Read(buf)
 		if n > 0 {
-			wn, werr := w.Write(buf[:n])
-			total += int64(wn)
-			if werr != nil {
-				return total, werr
+			if split == nil {
+				if werr := write(buf[:n]); werr != nil {
+					return total, werr
+				}
+			} else {
+				pending = append(pending, buf[:n]...)
+				done := 0
+				for {
+					end := split(pending[done:])
+					if end < 0 {
+						break
+					}
+					if werr := write(pending[done : done+end]); werr != nil {
+						return total, werr
+					}
+					done += end
+				}
+				if len(pending)-done > maxPendingEvent {
+					if werr := write(pending[done:]); werr != nil {
+						return total, werr
+					}
+					done = len(pending)
+				}
+				pending = append(pending[:0], pending[done:]...)
 			}
-			flusher.Flush()
 		}
 		if err != nil {
+			// Forward a trailing partial event as is.
+			if len(pending) > 0 {
+				if werr := write(pending); werr != nil {
+					return total, werr
+				}
+			}
 			if err == io.EOF {
 				return total, nil
 			}
@@ -488,6 +1279,62 @@ func copyWithOptionalFlush(dst http.ResponseWriter, src io.Reader, capture io.Wr
 	}
 }
 
+// eventSplitter returns the length of the first complete event in data, or
+// -1 when data holds no complete event yet.
+type eventSplitter func(data []byte) int
+
+// eventSplitterFor picks the event framing of a streaming response. Encoded
+// (e.g. gzip) streams are forwarded unsplit.
+func eventSplitterFor(header http.Header) eventSplitter {
+	if ce := header.Get("Content-Encoding"); ce != "" && !strings.EqualFold(ce, "identity") {
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	switch strings.ToLower(mediaType) {
+	case "text/event-stream":
+		return splitSSEEvent
+	case "application/x-ndjson", "application/stream+json", "application/json-seq":
+		return splitLine
+	}
+	return nil
+}
+
+// splitSSEEvent finds the blank line ending an SSE event (LF or CRLF).
+func splitSSEEvent(data []byte) int {
+	lf := bytes.Index(data, []byte("\n\n"))
+	crlf := bytes.Index(data, []byte("\n\r\n"))
+	switch {
+	case lf < 0 && crlf < 0:
+		return -1
+	case crlf < 0 || (lf >= 0 && lf < crlf):
+		return lf + 2
+	default:
+		return crlf + 3
+	}
+}
+
+func splitLine(data []byte) int {
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return i + 1
+	}
+	return -1
+}
+
+// maxEventTimings caps the timings kept per log.
+const maxEventTimings = 10000
+
+// eventTimer counts forwarded events on log and records when each was
+// forwarded, for events that start within the captured part of the body.
+func eventTimer(log *storage.RequestLog, start time.Time, maxCapture int64) func(offset int64) {
+	return func(offset int64) {
+		log.StreamEvents++
+		if offset >= maxCapture || len(log.EventTimings) >= maxEventTimings {
+			return
+		}
+		log.EventTimings = append(log.EventTimings, storage.EventTiming{Offset: offset, Ms: time.Since(start).Milliseconds()})
+	}
+}
+
 // bodyForLog converts captured bytes to a UI-friendly string.
 // For compressed payloads, it attempts decompression first.
 // For non-textual payloads, it returns a short placeholder to avoid blowing up the UI.
@@ -516,31 +1363,13 @@ func bodyForLog(contentType, contentEncoding string, b []byte, maxOutputBytes in
 	decompressed := false
 	truncated := false
 
-	switch strings.ToLower(strings.TrimSpace(contentEncoding)) {
-	case "gzip":
-		if r, err := gzip.NewReader(bytes.NewReader(b)); err == nil {
-			if d, t, err := readAllLimited(r, maxOutputBytes); err == nil {
-				data = d
-				decompressed = true
-				truncated = truncated || t
-			}
-			r.Close()
-		}
-	case "deflate":
-		r := flate.NewReader(bytes.NewReader(b))
+	if r, ok := contentDecoder(contentEncoding, bytes.NewReader(b)); ok {
 		if d, t, err := readAllLimited(r, maxOutputBytes); err == nil {
 			data = d
 			decompressed = true
 			truncated = truncated || t
 		}
 		r.Close()
-	case "br":
-		r := brotli.NewReader(bytes.NewReader(b))
-		if d, t, err := readAllLimited(r, maxOutputBytes); err == nil {
-			data = d
-			decompressed = true
-			truncated = truncated || t
-		}
 	}
 
 	if isProbablyText(contentType) && utf8.Valid(data) {
@@ -577,6 +1406,24 @@ func bodyForLog(contentType, contentEncoding string, b []byte, maxOutputBytes in
 	return fmt.Sprintf("[binary content omitted; %d bytes captured]", len(b)), false
 }
 
+// contentDecoder returns a reader undoing a gzip, deflate or br
+// Content-Encoding, or false for any other encoding (or a bad gzip header).
+func contentDecoder(encoding string, r io.Reader) (io.ReadCloser, bool) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "gzip":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, false
+		}
+		return gz, true
+	case "deflate":
+		return flate.NewReader(r), true
+	case "br":
+		return io.NopCloser(brotli.NewReader(r)), true
+	}
+	return nil, false
+}
+
 func isProbablyText(contentType string) bool {
 	if contentType == "" {
 		return false
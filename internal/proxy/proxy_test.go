@@ -0,0 +1,584 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+func TestUpstreamUserAgentAndDefaultHeaders(t *testing.T) {
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer upstream.Close()
+
+	p, _ := newTestProxy(t, upstream.URL)
+	p.cfg.Upstreams["up"] = config.UpstreamConfig{
+		Target:    upstream.URL,
+		UserAgent: "prismcat-test/1.0",
+		DefaultHeaders: map[string]string{
+			"Anthropic-Version": "2023-06-01",
+			"X-Team":            "default",
+		},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://up.localhost/", nil)
+	req.Header.Set("User-Agent", "curl/8")
+	req.Header.Set("X-Team", "client")
+	p.ServeHTTP(httptest.NewRecorder(), req)
+
+	if ua := got.Get("User-Agent"); ua != "prismcat-test/1.0" {
+		t.Fatalf("User-Agent = %q", ua)
+	}
+	if v := got.Get("Anthropic-Version"); v != "2023-06-01" {
+		t.Fatalf("default header missing: %q", v)
+	}
+	if v := got.Get("X-Team"); v != "client" {
+		t.Fatalf("client header overridden: %q", v)
+	}
+}
+
+func TestForwardedHeaders(t *testing.T) {
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer upstream.Close()
+
+	p, _ := newTestProxy(t, upstream.URL)
+	newReq := func() *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "http://up.localhost/", nil)
+		req.RemoteAddr = "10.0.0.7:5555"
+		req.Header.Set("X-Forwarded-For", "203.0.113.1")
+		req.Header.Set("X-Real-IP", "203.0.113.1")
+		return req
+	}
+
+	p.ServeHTTP(httptest.NewRecorder(), newReq())
+	if v := got.Get("X-Forwarded-For"); v != "203.0.113.1, 10.0.0.7" {
+		t.Fatalf("X-Forwarded-For = %q", v)
+	}
+	if got.Get("X-Forwarded-Host") != "up.localhost" || got.Get("X-Forwarded-Proto") != "http" {
+		t.Fatalf("X-Forwarded-Host/Proto = %q/%q", got.Get("X-Forwarded-Host"), got.Get("X-Forwarded-Proto"))
+	}
+
+	p.cfg.Server.StripClientIdentity = true
+	p.ServeHTTP(httptest.NewRecorder(), newReq())
+	for _, k := range []string{"X-Forwarded-For", "X-Forwarded-Host", "X-Real-Ip"} {
+		if v := got.Get(k); v != "" {
+			t.Fatalf("%s leaked with strip_client_identity: %q", k, v)
+		}
+	}
+}
+
+func TestUpstreamHeaderRouting(t *testing.T) {
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer upstream.Close()
+
+	p, repo := newTestProxy(t, upstream.URL)
+	p.cfg.Upstreams["openai-eu"] = config.UpstreamConfig{Target: upstream.URL}
+
+	req := httptest.NewRequest(http.MethodGet, "http://203.0.113.9:8080/v1/models", nil)
+	req.Header.Set("X-PrismCat-Upstream", "OpenAI-EU")
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if v := got.Get("X-PrismCat-Upstream"); v != "" {
+		t.Fatalf("upstream header forwarded: %q", v)
+	}
+	entry := repo.only(t)
+	if entry.Upstream != "openai-eu" || !entry.HasFlag(storage.FlagUpstreamHeader) {
+		t.Fatalf("upstream = %q, flags = %v", entry.Upstream, entry.Flags)
+	}
+
+	// The routing flag alone doesn't exempt a success from sampling.
+	p.cfg.Upstreams["openai-eu"] = config.UpstreamConfig{Target: upstream.URL, Sampling: config.SamplingConfig{Rate: 0.1}}
+	p.sampleRand = func() float64 { return 0.5 }
+	repo.logs = nil
+	p.ServeHTTP(httptest.NewRecorder(), req)
+	if len(repo.logs) != 0 {
+		t.Fatalf("sampled-out header-routed success was logged: %d logs", len(repo.logs))
+	}
+}
+
+func TestPropertyHeaders(t *testing.T) {
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer upstream.Close()
+
+	p, repo := newTestProxy(t, upstream.URL)
+	p.cfg.Logging.Properties = config.PropertiesConfig{Allow: []string{"Feature", "customer-id"}, MaxValueBytes: 4}
+
+	req := httptest.NewRequest(http.MethodGet, "http://up.localhost/", nil)
+	req.Header.Set("X-PrismCat-Property-Feature", "search")
+	req.Header.Set("X-PrismCat-Property-Customer-Id", "42")
+	req.Header.Set("X-PrismCat-Property-Other", "dropped")
+	p.ServeHTTP(httptest.NewRecorder(), req)
+
+	for k := range got {
+		if strings.HasPrefix(k, "X-Prismcat-Property-") {
+			t.Fatalf("%s forwarded upstream", k)
+		}
+	}
+	props := repo.only(t).Properties
+	if len(props) != 2 || props["feature"] != "sear" || props["customer-id"] != "42" {
+		t.Fatalf("properties = %v", props)
+	}
+}
+
+func TestMetadataHeader(t *testing.T) {
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer upstream.Close()
+
+	p, repo := newTestProxy(t, upstream.URL)
+	req := httptest.NewRequest(http.MethodGet, "http://up.localhost/", nil)
+	req.Header.Add("X-PrismCat-Metadata", "session=abc, prompt=summarize%2Cv2")
+	req.Header.Add("X-PrismCat-Metadata", "bad, =x, session=def")
+	p.ServeHTTP(httptest.NewRecorder(), req)
+
+	if v := got.Get("X-PrismCat-Metadata"); v != "" {
+		t.Fatalf("metadata header forwarded: %q", v)
+	}
+	md := repo.only(t).Metadata
+	if len(md) != 2 || md["session"] != "def" || md["prompt"] != "summarize,v2" {
+		t.Fatalf("metadata = %v", md)
+	}
+}
+
+type chunkRecorder struct {
+	BaseMiddleware
+	chunks []string
+}
+
+func (m *chunkRecorder) OnStreamChunk(_ *Exchange, chunk []byte) ([]byte, error) {
+	m.chunks = append(m.chunks, string(chunk))
+	return chunk, nil
+}
+
+func TestStreamForwardsWholeEvents(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, part := range []string{"data: a", "\n\ndata: b\n\nda", "ta: c\n\n"} {
+			_, _ = w.Write([]byte(part))
+			w.(http.Flusher).Flush()
+			time.Sleep(20 * time.Millisecond)
+		}
+	}))
+	defer upstream.Close()
+
+	mw := &chunkRecorder{}
+	p, repo := newTestProxy(t, upstream.URL, mw)
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://up.localhost/v1/stream", nil))
+
+	if got := strings.Join(mw.chunks, "|"); got != "data: a\n\n|data: b\n\n|data: c\n\n" {
+		t.Fatalf("chunks = %q", got)
+	}
+	timings := repo.only(t).EventTimings
+	if len(timings) != 3 || timings[1].Offset != 9 || timings[2].Offset != 18 {
+		t.Fatalf("timings = %+v", timings)
+	}
+	if timings[2].Ms < timings[1].Ms || timings[2].Ms < 20 {
+		t.Fatalf("timings not increasing: %+v", timings)
+	}
+}
+
+func TestStreamFailureKeepsPartialResponse(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		// Promise more than is sent so the connection ends mid-body.
+		w.Header().Set("Content-Length", "1000")
+		_, _ = w.Write([]byte("data: a\n\ndata: b\n\ndata: c"))
+	}))
+	defer upstream.Close()
+
+	p, repo := newTestProxy(t, upstream.URL)
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://up.localhost/v1/stream", nil))
+
+	entry := repo.only(t)
+	if !entry.HasFlag(storage.FlagPartial) || entry.StreamEvents != 3 || entry.ResponseBodySize != 25 {
+		t.Fatalf("flags = %v, events = %d, size = %d", entry.Flags, entry.StreamEvents, entry.ResponseBodySize)
+	}
+	if entry.ResponseBody != "data: a\n\ndata: b\n\ndata: c" || !strings.Contains(entry.Error, "after 25 bytes, 3 events") {
+		t.Fatalf("body = %q, error = %q", entry.ResponseBody, entry.Error)
+	}
+}
+
+func TestSummarizeEmbeddingsKeepsClientResponse(t *testing.T) {
+	const body = `{"object":"list","model":"m","data":[{"object":"embedding","embedding":[0.1,0.2]}],"usage":{"total_tokens":2}}`
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(body))
+	}))
+	defer upstream.Close()
+
+	p, repo := newTestProxy(t, upstream.URL)
+	p.cfg.Logging.SummarizeEmbeddings = true
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "http://up.localhost/v1/embeddings", strings.NewReader(`{"input":"hi"}`)))
+	if rec.Body.String() != body {
+		t.Fatalf("client body = %q", rec.Body)
+	}
+	entry := repo.only(t)
+	if !entry.HasFlag(storage.FlagEmbeddingsSummarized) || strings.Contains(entry.ResponseBody, "0.1") {
+		t.Fatalf("logged body = %s, flags = %v", entry.ResponseBody, entry.Flags)
+	}
+	if !strings.Contains(entry.ResponseBody, `"dimensions":2`) {
+		t.Fatalf("logged body = %s", entry.ResponseBody)
+	}
+}
+
+func TestCaptureRules(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("secret audio"))
+	}))
+	defer upstream.Close()
+
+	p, repo := newTestProxy(t, upstream.URL)
+	p.cfg.Logging.CaptureRules = []config.CaptureRuleConfig{
+		{Method: "GET", Path: "/v1/models", Capture: config.CaptureNone},
+		{Path: "/v1/audio/*", Capture: config.CaptureMetadata},
+	}
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://up.localhost/v1/models", nil))
+	if rec.Code != http.StatusOK || len(repo.logs) != 0 {
+		t.Fatalf("status = %d, logs = %d; want 200 and no log", rec.Code, len(repo.logs))
+	}
+
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "http://up.localhost/v1/audio/speech", strings.NewReader("hello")))
+	if rec.Body.String() != "secret audio" {
+		t.Fatalf("client body = %q", rec.Body)
+	}
+	entry := repo.only(t)
+	if entry.RequestBody != "" || entry.ResponseBody != "" {
+		t.Fatalf("bodies captured: %q / %q", entry.RequestBody, entry.ResponseBody)
+	}
+	if entry.RequestBodySize != 5 || entry.ResponseBodySize != 12 || entry.Truncated {
+		t.Fatalf("sizes = %d/%d, truncated = %v", entry.RequestBodySize, entry.ResponseBodySize, entry.Truncated)
+	}
+}
+
+func TestNoLogHeader(t *testing.T) {
+	var forwarded string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Get("X-PrismCat-No-Log")
+		_, _ = w.Write([]byte("secret"))
+	}))
+	defer upstream.Close()
+
+	send := func(p *Proxy, mode string) {
+		req := httptest.NewRequest(http.MethodPost, "http://up.localhost/v1/chat", strings.NewReader("prompt"))
+		req.Header.Set("X-PrismCat-No-Log", mode)
+		p.ServeHTTP(httptest.NewRecorder(), req)
+		if forwarded != "" {
+			t.Fatalf("no-log header forwarded: %q", forwarded)
+		}
+	}
+
+	// Ignored unless the server allows it.
+	p, repo := newTestProxy(t, upstream.URL)
+	send(p, "all")
+	if entry := repo.only(t); entry.RequestBody != "prompt" {
+		t.Fatalf("request body = %q", entry.RequestBody)
+	}
+
+	p, repo = newTestProxy(t, upstream.URL)
+	p.cfg.Logging.AllowNoLogHeader = true
+	send(p, "body")
+	if entry := repo.only(t); entry.RequestBody != "" || entry.ResponseBody != "" || entry.ResponseBodySize != 6 {
+		t.Fatalf("bodies = %q / %q, size = %d", entry.RequestBody, entry.ResponseBody, entry.ResponseBodySize)
+	}
+
+	p, repo = newTestProxy(t, upstream.URL)
+	p.cfg.Logging.AllowNoLogHeader = true
+	send(p, "all")
+	if len(repo.logs) != 0 {
+		t.Fatalf("logs = %d, want none", len(repo.logs))
+	}
+}
+
+func TestSamplingKeepsErrors(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer upstream.Close()
+
+	p, repo := newTestProxy(t, upstream.URL)
+	p.cfg.Upstreams["up"] = config.UpstreamConfig{Target: upstream.URL, Sampling: config.SamplingConfig{Rate: 0.1}}
+	p.sampleRand = func() float64 { return 0.5 } // always outside the sample
+
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://up.localhost/ok", nil))
+	if len(repo.logs) != 0 {
+		t.Fatalf("sampled-out success was logged: %d logs", len(repo.logs))
+	}
+
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://up.localhost/fail", nil))
+	if entry := repo.only(t); entry.StatusCode != http.StatusBadGateway {
+		t.Fatalf("status = %d, want 502", entry.StatusCode)
+	}
+
+	p.sampleRand = func() float64 { return 0.05 }
+	repo.logs = nil
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://up.localhost/ok", nil))
+	repo.only(t)
+}
+
+func TestFullCaptureTriggers(t *testing.T) {
+	body := strings.Repeat("x", 100)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	defer upstream.Close()
+
+	p, repo := newTestProxy(t, upstream.URL)
+	p.cfg.Logging.BodyPreviewBytes = 10
+	p.cfg.Logging.FullCapture = config.FullCaptureConfig{Enabled: true}
+
+	tests := []struct {
+		path    string
+		header  string
+		wantLen int
+	}{
+		{"/ok", "", 10},
+		{"/fail", "", 100},
+		{"/ok", "1", 100},
+	}
+	for _, tt := range tests {
+		repo.logs = nil
+		req := httptest.NewRequest(http.MethodPost, "http://up.localhost"+tt.path, strings.NewReader(body))
+		if tt.header != "" {
+			req.Header.Set("X-PrismCat-Capture", tt.header)
+		}
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		if rec.Body.Len() != 100 {
+			t.Fatalf("%s: client body = %d bytes", tt.path, rec.Body.Len())
+		}
+		entry := repo.only(t)
+		if len(entry.RequestBody) != tt.wantLen || len(entry.ResponseBody) != tt.wantLen {
+			t.Fatalf("%s header=%q: bodies = %d/%d bytes, want %d", tt.path, tt.header, len(entry.RequestBody), len(entry.ResponseBody), tt.wantLen)
+		}
+		if preview := entry.HasFlag(storage.FlagBodyPreview); preview != (tt.wantLen == 10) {
+			t.Fatalf("%s: body_preview flag = %v", tt.path, preview)
+		}
+	}
+}
+
+func TestConnTimingsAndMetrics(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// "Generate" before the first byte, then stream the rest.
+		time.Sleep(delay)
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		time.Sleep(delay)
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+
+	p, repo := newTestProxy(t, upstream.URL)
+	for i := 0; i < 2; i++ {
+		p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://up.localhost/", nil))
+	}
+
+	reused := 0
+	for _, l := range repo.logs {
+		if l.Connection == nil || l.Connection.TTFBMs <= 0 {
+			t.Fatalf("connection = %+v", l.Connection)
+		}
+		if c := l.Connection; c.WaitMs < 15 || c.TransferMs < 15 || c.WaitMs > c.TTFBMs {
+			t.Fatalf("breakdown = %+v, want ~%v wait and transfer", c, delay)
+		}
+		if l.Connection.Reused {
+			reused++
+		}
+	}
+	if len(repo.logs) != 2 || reused != 1 {
+		t.Fatalf("logs = %d, reused = %d", len(repo.logs), reused)
+	}
+
+	var out strings.Builder
+	if err := p.WriteMetrics(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`prismcat_upstream_requests_total{upstream="up"} 2`,
+		`prismcat_upstream_dials_total{upstream="up"} 1`,
+		`prismcat_upstream_reused_connections_total{upstream="up"} 1`,
+		`prismcat_upstream_ttfb_seconds_count{upstream="up"} 2`,
+		`prismcat_upstream_open_connections{addr="` + strings.TrimPrefix(upstream.URL, "http://") + `"} 1`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestUpstreamIPFamily(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	// The test server listens on 127.0.0.1 only.
+	for family, want := range map[string]int{
+		config.IPFamilyV4: http.StatusOK,
+		config.IPFamilyV6: http.StatusBadGateway,
+	} {
+		p, _ := newTestProxy(t, upstream.URL)
+		p.cfg.Upstreams["up"] = config.UpstreamConfig{Target: upstream.URL, IPFamily: family}
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://up.localhost/", nil))
+		if rec.Code != want {
+			t.Errorf("%s: status = %d, want %d", family, rec.Code, want)
+		}
+	}
+}
+
+func TestDemoUpstream(t *testing.T) {
+	p, repo := newTestProxy(t, "")
+	p.cfg.Upstreams["up"] = config.UpstreamConfig{Type: config.UpstreamTypeDemo, Target: config.DemoTarget}
+
+	req := httptest.NewRequest(http.MethodPost, "http://up.localhost/v1/chat/completions",
+		strings.NewReader(`{"model":"m","messages":[{"role":"user","content":"hi"}]}`))
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"chat.completion"`) {
+		t.Fatalf("status %d, body %s", rec.Code, rec.Body.String())
+	}
+	l := repo.only(t)
+	if l.StatusCode != http.StatusOK || l.Error != "" || !strings.Contains(l.ResponseBody, `"usage"`) {
+		t.Fatalf("log = %+v", l)
+	}
+}
+
+func TestAzureOpenAIUpstream(t *testing.T) {
+	var got *http.Request
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Clone(r.Context())
+	}))
+	defer upstream.Close()
+
+	p, repo := newTestProxy(t, upstream.URL)
+	p.cfg.Upstreams["up"] = config.UpstreamConfig{
+		Type:   config.UpstreamTypeAzureOpenAI,
+		Target: upstream.URL,
+		Azure: config.AzureOpenAIConfig{
+			APIVersion:  "2024-10-21",
+			Deployments: map[string]string{"gpt-4o": "prod-4o"},
+		},
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "http://up.localhost/v1/chat/completions",
+		strings.NewReader(`{"model":"gpt-4o","messages":[]}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	p.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got.URL.Path != "/openai/deployments/prod-4o/chat/completions" || got.URL.RawQuery != "api-version=2024-10-21" {
+		t.Fatalf("upstream URL = %s", got.URL)
+	}
+	if got.Header.Get("api-key") != "secret" || got.Header.Get("Authorization") != "" {
+		t.Fatalf("auth headers = %v", got.Header)
+	}
+	if l := repo.only(t); !strings.HasSuffix(l.TargetURL, "/openai/deployments/prod-4o/chat/completions?api-version=2024-10-21") {
+		t.Fatalf("target_url = %q", l.TargetURL)
+	}
+}
+
+func TestBedrockUpstreamSigned(t *testing.T) {
+	var auth, token, body string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth, token = r.Header.Get("Authorization"), r.Header.Get("X-Amz-Security-Token")
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+	}))
+	defer upstream.Close()
+
+	p, repo := newTestProxy(t, upstream.URL)
+	p.cfg.Upstreams["up"] = config.UpstreamConfig{
+		Type:   config.UpstreamTypeBedrock,
+		Target: upstream.URL,
+		Bedrock: config.BedrockConfig{
+			Region:          "us-west-2",
+			AccessKeyID:     "AKIDTEST",
+			SecretAccessKey: "secret",
+			SessionToken:    "session",
+		},
+	}
+
+	const payload = `{"messages":[{"role":"user","content":[{"text":"hi"}]}]}`
+	req := httptest.NewRequest(http.MethodPost, "http://up.localhost/model/anthropic.claude-3-haiku-20240307-v1:0/converse",
+		strings.NewReader(payload))
+	req.Header.Set("Authorization", "Bearer client")
+	req.Header.Set("Content-Type", "application/json")
+	p.ServeHTTP(httptest.NewRecorder(), req)
+
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDTEST/") ||
+		!strings.Contains(auth, "/us-west-2/bedrock/aws4_request, SignedHeaders=content-type;host;x-amz-date;x-amz-security-token,") {
+		t.Fatalf("Authorization = %q", auth)
+	}
+	if token != "session" || body != payload {
+		t.Fatalf("token %q, body %q", token, body)
+	}
+	if l := repo.only(t); l.RequestBody != payload {
+		t.Fatalf("logged request body = %q", l.RequestBody)
+	}
+}
+
+func TestVertexAIUpstreamToken(t *testing.T) {
+	var auth string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			w.Write([]byte(`{"access_token":"ya29.test","expires_in":3600}`))
+			return
+		}
+		auth = r.Header.Get("Authorization")
+	}))
+	defer upstream.Close()
+
+	creds := filepath.Join(t.TempDir(), "adc.json")
+	os.WriteFile(creds, []byte(`{"type":"authorized_user","client_id":"c","client_secret":"s","refresh_token":"r","token_uri":"`+upstream.URL+`/token"}`), 0o600)
+
+	p, _ := newTestProxy(t, upstream.URL)
+	p.cfg.Upstreams["up"] = config.UpstreamConfig{
+		Type:   config.UpstreamTypeVertexAI,
+		Target: upstream.URL,
+		Vertex: config.VertexConfig{CredentialsFile: creds},
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "http://up.localhost/v1/projects/p/locations/us-central1/publishers/google/models/gemini-2.0-flash:generateContent",
+		strings.NewReader(`{"contents":[]}`))
+	req.Header.Set("Authorization", "Bearer stale")
+	p.ServeHTTP(httptest.NewRecorder(), req)
+
+	if auth != "Bearer ya29.test" {
+		t.Fatalf("Authorization = %q", auth)
+	}
+}
@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"syscall"
+)
+
+// retryErrorMetadata is the metadata key holding the transport error that
+// caused a retry (see FlagRetried).
+const retryErrorMetadata = "retry_error"
+
+// retryableTransportError reports whether err, returned by the transport
+// before the request was fully written, means the upstream dropped the connection
+// rather than refused the request: an HTTP/2 GOAWAY or refused stream, or a
+// connection reset or closed under the request. Timeouts and cancellations
+// are not retried.
+func retryableTransportError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var mbe *http.MaxBytesError
+	if errors.As(err, &mbe) {
+		return false
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "GOAWAY") || strings.Contains(msg, "REFUSED_STREAM") ||
+		strings.Contains(msg, "server closed idle connection")
+}
+
+// retryRequest returns a copy of req to send again, or nil when its body
+// can't be resent: it must be empty, buffered (GetBody), or held in full by
+// bodyCapture after streaming to the failed attempt. Callers only retry
+// requests that were never fully written, so the upstream can't have acted
+// on them.
+func retryRequest(req *http.Request, bodyCapture *limitedCapture) *http.Request {
+	retry := req.Clone(req.Context())
+	switch {
+	case req.Body == nil || req.Body == http.NoBody:
+	case req.GetBody != nil:
+		body, err := req.GetBody()
+		if err != nil {
+			return nil
+		}
+		retry.Body = body
+	case req.ContentLength >= 0 && bodyCapture.Total() == req.ContentLength:
+		// With capture off (max 0) bytes are counted but not kept.
+		data := bodyCapture.Bytes()
+		if int64(len(data)) != req.ContentLength {
+			return nil
+		}
+		retry.Body = io.NopCloser(bytes.NewReader(data))
+		retry.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(data)), nil }
+		// The body is in hand: don't wait for a 100 Continue again.
+		retry.Header.Del("Expect")
+	default:
+		return nil
+	}
+	return retry
+}
@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+// resetFirstListener resets its first connection once a little of the
+// request has arrived, so the client fails while still writing it.
+type resetFirstListener struct {
+	net.Listener
+	reset atomic.Bool
+}
+
+func (l *resetFirstListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil || !l.reset.CompareAndSwap(false, true) {
+			return conn, err
+		}
+		go func() {
+			_, _ = conn.Read(make([]byte, 1024))
+			if tcp, ok := conn.(*net.TCPConn); ok {
+				_ = tcp.SetLinger(0) // close with a RST
+			}
+			conn.Close()
+		}()
+	}
+}
+
+func TestRetryAfterResetDuringWrite(t *testing.T) {
+	var calls atomic.Int32
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		_, _ = io.WriteString(w, "ok")
+	}))
+	upstream.Config.MaxHeaderBytes = 32 << 20
+	upstream.Listener = &resetFirstListener{Listener: upstream.Listener}
+	upstream.Start()
+	defer upstream.Close()
+
+	p, repo := newTestProxy(t, upstream.URL)
+	req := httptest.NewRequest(http.MethodGet, "http://up.localhost/v1/models", nil)
+	// Headers far larger than the socket buffers keep the client writing
+	// until the reset arrives.
+	req.Header.Set("X-Padding", strings.Repeat("p", 16<<20))
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+		t.Fatalf("client saw %d %q", rec.Code, rec.Body.String())
+	}
+	l := repo.only(t)
+	if !l.HasFlag(storage.FlagRetried) || l.Metadata[retryErrorMetadata] == "" || l.Error != "" {
+		t.Fatalf("flags = %v metadata = %v error = %q", l.Flags, l.Metadata, l.Error)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("upstream handled %d requests, want 1", n)
+	}
+}
+
+func TestNoRetryAfterRequestWritten(t *testing.T) {
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.ReadAll(r.Body)
+		calls.Add(1)
+		// Drop the connection without answering, after the whole request
+		// arrived: the upstream may already have acted on it.
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer upstream.Close()
+
+	p, repo := newTestProxy(t, upstream.URL)
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "http://up.localhost/v1/chat", strings.NewReader(`{"n":1}`)))
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("client saw %d, want 502", rec.Code)
+	}
+	if l := repo.only(t); l.HasFlag(storage.FlagRetried) || l.Error == "" {
+		t.Fatalf("flags = %v error = %q", l.Flags, l.Error)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("upstream calls = %d, want 1", n)
+	}
+}
+
+func TestRetryRequestBody(t *testing.T) {
+	newReq := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "http://up/v1/chat", io.NopCloser(strings.NewReader(`{"n":1}`)))
+		req.ContentLength = 7
+		return req
+	}
+
+	captured := newLimitedCapture(1024)
+	_, _ = captured.Write([]byte(`{"n":1}`))
+	retry := retryRequest(newReq(), captured)
+	if retry == nil {
+		t.Fatal("fully captured body: no retry")
+	}
+	if body, _ := io.ReadAll(retry.Body); string(body) != `{"n":1}` {
+		t.Fatalf("retry body = %q", body)
+	}
+
+	// Capture off: the bytes were counted but not kept.
+	counted := newLimitedCapture(0)
+	_, _ = counted.Write([]byte(`{"n":1}`))
+	if retryRequest(newReq(), counted) != nil {
+		t.Fatal("uncaptured body retried")
+	}
+
+	truncated := newLimitedCapture(3)
+	_, _ = truncated.Write([]byte(`{"n":1}`))
+	if retryRequest(newReq(), truncated) != nil {
+		t.Fatal("truncated body retried")
+	}
+}
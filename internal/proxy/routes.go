@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/llm"
+)
+
+// routeMatch is the routing table's decision for a request.
+type routeMatch struct {
+	Name string
+	config.RouteDestination
+}
+
+// matchRoute evaluates the routing table against the inbound request and
+// picks a destination of the first matching route, by weight. The table is
+// read per request, so changes made through the API apply immediately. The
+// JSON body is peeked (bounded, restored) only if a route matches on model.
+func (p *Proxy) matchRoute(r *http.Request) (routeMatch, bool) {
+	routes := p.cfg.RoutesSnapshot()
+	if len(routes) == 0 {
+		return routeMatch{}, false
+	}
+
+	model, modelRead := "", false
+	requestModel := func() string {
+		if !modelRead {
+			modelRead = true
+			if body, ok := peekJSONBody(r).(map[string]interface{}); ok {
+				model = llm.ExtractModel(body)
+			}
+			if model == "" {
+				model = pathModel(r.URL.Path)
+			}
+		}
+		return model
+	}
+
+	for _, route := range routes {
+		if !route.Match.Matches(r.Host, r.URL.Path, r.Header.Values, requestModel) {
+			continue
+		}
+		return routeMatch{Name: route.Name, RouteDestination: p.pickDestination(route.RouteDestinations())}, true
+	}
+	return routeMatch{}, false
+}
+
+// pickDestination chooses one of dests in proportion to their weights
+// (default 1).
+func (p *Proxy) pickDestination(dests []config.RouteDestination) config.RouteDestination {
+	if len(dests) == 1 {
+		return dests[0]
+	}
+	weight := func(d config.RouteDestination) int {
+		if d.Weight == 0 {
+			return 1
+		}
+		return d.Weight
+	}
+	total := 0
+	for _, d := range dests {
+		total += weight(d)
+	}
+	n := int(p.sampleRand() * float64(total))
+	for _, d := range dests {
+		if n -= weight(d); n < 0 {
+			return d
+		}
+	}
+	return dests[len(dests)-1]
+}
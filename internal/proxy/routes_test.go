@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+func TestRoutingTable(t *testing.T) {
+	servers := map[string]*httptest.Server{}
+	var hit string
+	for _, name := range []string{"main", "alt", "canary"} {
+		name := name
+		servers[name] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hit = name
+		}))
+		defer servers[name].Close()
+	}
+
+	p, repo := newTestProxy(t, servers["main"].URL)
+	p.cfg.Update(func(c *config.Config) {
+		c.Upstreams["alt"] = config.UpstreamConfig{Target: servers["alt"].URL}
+	})
+	err := p.cfg.SetRoutes([]config.RouteConfig{
+		{Name: "audio", Match: config.RouteMatch{Path: "/v1/audio/*"}, Upstream: "ALT"},
+		{Name: "claude", Match: config.RouteMatch{Model: "claude-*"}, Upstream: "alt"},
+		{Name: "beta", Match: config.RouteMatch{Headers: map[string]string{"X-Beta": "*"}}, Target: servers["canary"].URL},
+		{Name: "split", Match: config.RouteMatch{Path: "/v1/embeddings"}, Destinations: []config.RouteDestination{
+			{Variant: "a", Target: servers["main"].URL, Weight: 3},
+			{Variant: "b", Upstream: "alt"},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name, path, body, header, want, variant string
+	}{
+		{name: "host", path: "/v1/chat/completions", body: `{"model":"gpt-4o"}`, want: "main"},
+		{name: "path", path: "/v1/audio/speech", want: "alt"},
+		{name: "model", path: "/v1/chat/completions", body: `{"model":"claude-3-5-sonnet"}`, want: "alt"},
+		{name: "header target", path: "/v1/chat/completions", header: "X-Beta", want: "canary"},
+	}
+	for _, tc := range cases {
+		hit = ""
+		req := httptest.NewRequest(http.MethodPost, "http://up.localhost"+tc.path, strings.NewReader(tc.body))
+		if tc.header != "" {
+			req.Header.Set(tc.header, "1/2")
+		}
+		p.ServeHTTP(httptest.NewRecorder(), req)
+		if hit != tc.want {
+			t.Errorf("%s: forwarded to %q, want %q", tc.name, hit, tc.want)
+		}
+		l := repo.only(t)
+		if routed := l.HasFlag(storage.FlagRouted); routed != (tc.name != "host") {
+			t.Errorf("%s: routed flag = %v", tc.name, routed)
+		}
+		if tc.body != "" && !strings.Contains(l.RequestBody, tc.body) {
+			t.Errorf("%s: body not captured after model peek: %q", tc.name, l.RequestBody)
+		}
+		repo.logs = nil
+	}
+
+	// Weights 3:1 — draws below 0.75 take the first destination.
+	for draw, want := range map[float64]string{0.1: "a", 0.74: "a", 0.8: "b"} {
+		p.sampleRand = func() float64 { return draw }
+		hit = ""
+		p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "http://up.localhost/v1/embeddings", nil))
+		l := repo.only(t)
+		repo.logs = nil
+		if l.Variant != want || (want == "a") != (hit == "main") || (want == "b") != (hit == "alt") {
+			t.Errorf("draw %v: variant %q, forwarded to %q", draw, l.Variant, hit)
+		}
+	}
+
+	// A routed success is still subject to sampling.
+	p.cfg.Update(func(c *config.Config) {
+		c.Upstreams["alt"] = config.UpstreamConfig{Target: servers["alt"].URL, Sampling: config.SamplingConfig{Rate: 0.1}}
+	})
+	p.sampleRand = func() float64 { return 0.5 }
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "http://up.localhost/v1/audio/speech", nil))
+	if len(repo.logs) != 0 {
+		t.Fatalf("sampled-out routed success was logged: %d logs", len(repo.logs))
+	}
+
+	if err := p.cfg.SetRoutes([]config.RouteConfig{{Match: config.RouteMatch{Path: "/v1/*"}}}); err == nil {
+		t.Fatal("route without destination accepted")
+	}
+	if err := p.cfg.SetRoutes([]config.RouteConfig{{Target: "not a url"}}); err == nil {
+		t.Fatal("invalid target accepted")
+	}
+}
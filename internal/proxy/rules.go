@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/prismcat/prismcat/internal/rules"
+)
+
+// evaluateRules runs the configured request rules against the inbound request.
+// If any rule references the JSON body, the body is buffered (bounded) and
+// restored so it is still forwarded and captured unchanged.
+func (p *Proxy) evaluateRules(r *http.Request, upstream string) rules.Result {
+	if p.rules.Empty() {
+		return rules.Result{}
+	}
+
+	env := &rules.Env{
+		Host:     r.Host,
+		Path:     r.URL.Path,
+		Method:   r.Method,
+		Query:    r.URL.RawQuery,
+		Upstream: upstream,
+		Header:   r.Header,
+	}
+	if p.rules.NeedsJSON() {
+		env.JSON = peekJSONBody(r)
+	}
+	return p.rules.Evaluate(env)
+}
+
+// peekJSONBody decodes the request body as JSON without consuming it.
+// Bodies larger than the middleware buffer limit are not decoded.
+func peekJSONBody(r *http.Request) interface{} {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	orig := r.Body
+	data, err := io.ReadAll(io.LimitReader(orig, maxBufferedRequestBody+1))
+	r.Body = &teeReadCloser{r: io.MultiReader(bytes.NewReader(data), orig), c: orig}
+	if err != nil || int64(len(data)) > maxBufferedRequestBody {
+		return nil
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil
+	}
+	return v
+}
+
+func ruleRejection(rule *rules.Rule) *RejectError {
+	msg := rule.Message
+	if msg == "" {
+		msg = "request blocked by rule: " + rule.Name
+	}
+	return Reject(rule.Status, msg)
+}
@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log"
+	"mime"
+	"os"
+	"strings"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/schema"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+// responseSchemaFor returns the compiled schema configured for the request path, if any.
+// Compiled schemas are cached by source so config reloads pick up new schemas.
+func (p *Proxy) responseSchemaFor(up config.UpstreamConfig, path string) *schema.Schema {
+	for _, rs := range up.ResponseSchemas {
+		if !rs.MatchPath(path) {
+			continue
+		}
+		key := "file:" + rs.SchemaFile
+		if rs.SchemaFile == "" {
+			raw, _ := json.Marshal(rs.Schema)
+			key = "inline:" + string(raw)
+		}
+		if cached, ok := p.schemas.Load(key); ok {
+			return cached.(*schema.Schema)
+		}
+
+		var (
+			s   *schema.Schema
+			err error
+		)
+		if rs.SchemaFile != "" {
+			var data []byte
+			if data, err = os.ReadFile(rs.SchemaFile); err == nil {
+				s, err = schema.Compile(data)
+			}
+		} else {
+			s, err = schema.CompileValue(rs.Schema)
+		}
+		if err != nil {
+			log.Printf("response schema for %q ignored: %v", rs.Path, err)
+			return nil
+		}
+		p.schemas.Store(key, s)
+		return s
+	}
+	return nil
+}
+
+// validateResponseSchema flags the log when a complete JSON response body fails
+// its configured schema. Streaming, truncated, compressed and non-2xx responses
+// are skipped.
+func (p *Proxy) validateResponseSchema(up config.UpstreamConfig, entry *storage.RequestLog, respCap *limitedCapture) {
+	if len(up.ResponseSchemas) == 0 || respCap == nil || respCap.Truncated() ||
+		entry.Streaming || entry.StatusCode < 200 || entry.StatusCode >= 300 {
+		return
+	}
+	if firstHeaderValue(entry.ResponseHeaders, "Content-Encoding") != "" {
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(firstHeaderValue(entry.ResponseHeaders, "Content-Type"))
+	if mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+		return
+	}
+	s := p.responseSchemaFor(up, entry.Path)
+	if s == nil {
+		return
+	}
+	// The flag (not Error) records the drift: the request itself succeeded.
+	if err := s.ValidateJSON(respCap.Bytes()); err != nil {
+		entry.AddFlag(storage.FlagSchemaInvalid)
+		log.Printf("response schema violation (%s %s): %v", entry.Upstream, entry.Path, err)
+	}
+}
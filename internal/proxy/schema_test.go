@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+func TestResponseSchemaFlagsInvalidResponses(t *testing.T) {
+	body := `{"id":"x"}`
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(body))
+	}))
+	defer upstream.Close()
+
+	p, repo := newTestProxy(t, upstream.URL)
+	p.cfg.Upstreams["up"] = config.UpstreamConfig{
+		Target: upstream.URL,
+		ResponseSchemas: []config.ResponseSchemaConfig{{
+			Path: "/v1/*",
+			Schema: map[string]interface{}{
+				"type":     "object",
+				"required": []interface{}{"id", "choices"},
+			},
+		}},
+	}
+
+	serve := func(path string) *storage.RequestLog {
+		repo.logs = nil
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "http://up.localhost"+path, nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != body {
+			t.Fatalf("client saw %d %q", rec.Code, rec.Body.String())
+		}
+		return repo.only(t)
+	}
+
+	if l := serve("/v1/chat"); !l.HasFlag(storage.FlagSchemaInvalid) || l.Error != "" {
+		t.Fatalf("flags = %v error = %q, want schema_invalid without error", l.Flags, l.Error)
+	}
+	if l := serve("/other"); l.HasFlag(storage.FlagSchemaInvalid) {
+		t.Fatalf("unmatched path flagged")
+	}
+
+	body = `{"id":"x","choices":[]}`
+	if l := serve("/v1/chat"); l.HasFlag(storage.FlagSchemaInvalid) {
+		t.Fatalf("valid response flagged")
+	}
+}
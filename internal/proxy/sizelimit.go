@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+)
+
+// responseTooLarge is returned by limitResponse readers past the limit.
+type responseTooLarge struct {
+	limit int64
+}
+
+func (e *responseTooLarge) Error() string {
+	return fmt.Sprintf("response exceeds max_response_bytes %d", e.limit)
+}
+
+// limitResponse returns r, failing with *responseTooLarge once more than
+// limit bytes have been read. The first limit bytes are still returned.
+// limit <= 0 disables the check.
+func limitResponse(r io.Reader, limit int64) io.Reader {
+	if limit <= 0 {
+		return r
+	}
+	return &limitedResponse{r: r, limit: limit, remaining: limit}
+}
+
+type limitedResponse struct {
+	r         io.Reader
+	limit     int64
+	remaining int64
+}
+
+func (l *limitedResponse) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, &responseTooLarge{limit: l.limit}
+	}
+	// Read one byte past the limit to tell "exactly limit" from "more".
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.remaining {
+		n = int(l.remaining)
+		l.remaining = -1
+		return n, &responseTooLarge{limit: l.limit}
+	}
+	l.remaining -= int64(n)
+	return n, err
+}
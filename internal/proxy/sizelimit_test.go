@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/rules"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+func TestMaxResponseBytes(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(r.URL.Query().Get("n"))
+		if r.URL.Query().Get("chunked") == "" {
+			w.Header().Set("Content-Length", strconv.Itoa(n))
+		}
+		_, _ = w.Write([]byte(strings.Repeat("x", n)))
+	}))
+	defer upstream.Close()
+
+	p, repo := newTestProxy(t, upstream.URL)
+	p.cfg.Upstreams["up"] = config.UpstreamConfig{Target: upstream.URL, MaxResponseBytes: 100}
+
+	serve := func(query string) (rec *httptest.ResponseRecorder, aborted bool) {
+		repo.logs = nil
+		rec = httptest.NewRecorder()
+		defer func() {
+			if v := recover(); v != nil {
+				if v != http.ErrAbortHandler {
+					panic(v)
+				}
+				aborted = true
+			}
+		}()
+		p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://up.localhost/big?"+query, nil))
+		return rec, false
+	}
+
+	rec, aborted := serve("n=100")
+	if aborted || rec.Code != http.StatusOK || rec.Body.Len() != 100 || repo.only(t).HasFlag(storage.FlagResponseTooLarge) {
+		t.Fatalf("at limit: code=%d len=%d aborted=%v", rec.Code, rec.Body.Len(), aborted)
+	}
+
+	rec, aborted = serve("n=101")
+	if l := repo.only(t); aborted || rec.Code != http.StatusBadGateway || l.StatusCode != http.StatusBadGateway ||
+		!l.HasFlag(storage.FlagResponseTooLarge) || !strings.Contains(l.Error, "Content-Length 101") {
+		t.Fatalf("announced: code=%d aborted=%v log=%+v", rec.Code, aborted, l)
+	}
+
+	rec, aborted = serve("n=5000&chunked=1")
+	if l := repo.only(t); !aborted || rec.Body.Len() != 100 || !l.HasFlag(storage.FlagResponseTooLarge) || l.ResponseBodySize != 100 {
+		t.Fatalf("chunked: aborted=%v len=%d log=%+v", aborted, rec.Body.Len(), l)
+	}
+}
+
+func TestMaxRequestBytes(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+	}))
+	defer upstream.Close()
+
+	p, repo := newTestProxy(t, upstream.URL)
+	p.cfg.Server.MaxRequestBytes = 100
+
+	for _, c := range []struct {
+		size    int
+		chunked bool
+		want    int
+	}{
+		{100, false, http.StatusOK},
+		{101, false, http.StatusRequestEntityTooLarge},
+		{100, true, http.StatusOK},
+		{5000, true, http.StatusRequestEntityTooLarge},
+	} {
+		repo.logs = nil
+		req := httptest.NewRequest(http.MethodPost, "http://up.localhost/v1/chat/completions", strings.NewReader(strings.Repeat("x", c.size)))
+		if c.chunked {
+			req.ContentLength = -1
+		}
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		l := repo.only(t)
+		if rec.Code != c.want || l.StatusCode != c.want || l.HasFlag(storage.FlagRequestTooLarge) != (c.want != http.StatusOK) {
+			t.Fatalf("size %d chunked %v: code=%d log=%+v", c.size, c.chunked, rec.Code, l)
+		}
+	}
+}
+
+// byteCounter counts the bytes read from it.
+type byteCounter struct {
+	r io.Reader
+	n int64
+}
+
+func (c *byteCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func TestMaxRequestBytesBoundsRuleBuffering(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+	}))
+	defer upstream.Close()
+
+	p, repo := newTestProxy(t, upstream.URL)
+	p.cfg.Server.MaxRequestBytes = 100
+	engine, err := rules.NewEngine([]config.RuleConfig{{Name: "tag-model", When: `json.model == "gpt-4o"`, Tag: "gpt"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.rules = engine
+
+	body := &byteCounter{r: strings.NewReader(`{"model":"gpt-4o","pad":"` + strings.Repeat("x", 1<<20) + `"}`)}
+	req := httptest.NewRequest(http.MethodPost, "http://up.localhost/v1/chat/completions", body)
+	req.ContentLength = -1
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge || !repo.only(t).HasFlag(storage.FlagRequestTooLarge) {
+		t.Fatalf("code = %d", rec.Code)
+	}
+	// Rule evaluation read no further than the limit (plus a read buffer).
+	if body.n > 64<<10 {
+		t.Fatalf("read %d bytes of the body", body.n)
+	}
+
+	// Within the limit, rules still see the body.
+	repo.logs = nil
+	req = httptest.NewRequest(http.MethodPost, "http://up.localhost/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o"}`))
+	p.ServeHTTP(httptest.NewRecorder(), req)
+	if l := repo.only(t); l.StatusCode != http.StatusOK || l.Tag != "gpt" {
+		t.Fatalf("status = %d tag = %q", l.StatusCode, l.Tag)
+	}
+}
@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+)
+
+// throttleChunk bounds each read so a single large read can't burst far
+// past the limit before waiting.
+const throttleChunk = 16 * 1024
+
+// rateLimiter is a token bucket of bytes with a one-second burst. Reads
+// take tokens up front and may go into debt; the debt is paid by waiting.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	tokens float64
+	last   time.Time
+}
+
+// setRate changes the rate, keeping the accumulated tokens within the new burst.
+func (l *rateLimiter) setRate(rate int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = float64(rate)
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+}
+
+// reserve takes n bytes and returns how long to wait before using them.
+func (l *rateLimiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.rate {
+			l.tokens = l.rate
+		}
+	} else {
+		l.tokens = l.rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// bandwidthLimits holds the shared buckets, keyed by scope and direction.
+// Rates follow the config on every lookup.
+type bandwidthLimits struct {
+	mu      sync.Mutex
+	buckets map[string]*rateLimiter
+}
+
+func newBandwidthLimits() *bandwidthLimits {
+	return &bandwidthLimits{buckets: make(map[string]*rateLimiter)}
+}
+
+// get returns the bucket for key at rate, or nil when rate is unlimited.
+func (b *bandwidthLimits) get(key string, rate int64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	l, ok := b.buckets[key]
+	if !ok {
+		l = &rateLimiter{}
+		b.buckets[key] = l
+	}
+	b.mu.Unlock()
+	l.setRate(rate)
+	return l
+}
+
+// forUpstream returns the upload and download limiters that apply to an
+// upstream: its own and the global ones.
+func (b *bandwidthLimits) forUpstream(name string, upstream, global config.BandwidthConfig) (upload, download []*rateLimiter) {
+	add := func(list []*rateLimiter, l *rateLimiter) []*rateLimiter {
+		if l != nil {
+			list = append(list, l)
+		}
+		return list
+	}
+	upload = add(upload, b.get("upstream:"+name+":up", upstream.UploadBytesPerSec))
+	upload = add(upload, b.get("global:up", global.UploadBytesPerSec))
+	download = add(download, b.get("upstream:"+name+":down", upstream.DownloadBytesPerSec))
+	download = add(download, b.get("global:down", global.DownloadBytesPerSec))
+	return upload, download
+}
+
+// throttleReader limits reads from r by all of limiters. It returns r
+// unchanged when there are none.
+func throttleReader(ctx context.Context, r io.Reader, limiters []*rateLimiter) io.Reader {
+	if len(limiters) == 0 {
+		return r
+	}
+	return &throttledReader{ctx: ctx, r: r, limiters: limiters}
+}
+
+type throttledReader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*rateLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		var wait time.Duration
+		for _, l := range t.limiters {
+			if d := l.reserve(n); d > wait {
+				wait = d
+			}
+		}
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-t.ctx.Done():
+				timer.Stop()
+				return n, t.ctx.Err()
+			}
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+)
+
+func TestRateLimiterReserve(t *testing.T) {
+	l := &rateLimiter{}
+	l.setRate(1000)
+	if d := l.reserve(1000); d != 0 {
+		t.Fatalf("burst wait = %v, want 0", d)
+	}
+	if d := l.reserve(500); d < 450*time.Millisecond || d > 500*time.Millisecond {
+		t.Fatalf("wait = %v, want ~500ms", d)
+	}
+}
+
+func TestThrottleReaderSharesBuckets(t *testing.T) {
+	limits := newBandwidthLimits()
+	up, down := limits.forUpstream("a", config.BandwidthConfig{DownloadBytesPerSec: 1 << 20}, config.BandwidthConfig{DownloadBytesPerSec: 200_000})
+	if len(up) != 0 || len(down) != 2 {
+		t.Fatalf("limiters: up %d, down %d", len(up), len(down))
+	}
+	// Another upstream shares the global bucket only.
+	if _, other := limits.forUpstream("b", config.BandwidthConfig{}, config.BandwidthConfig{DownloadBytesPerSec: 200_000}); len(other) != 1 || other[0] != down[1] {
+		t.Fatalf("global bucket not shared")
+	}
+
+	// 200KB burst, then 100KB at 200KB/s.
+	start := time.Now()
+	n, err := io.Copy(io.Discard, throttleReader(context.Background(), bytes.NewReader(make([]byte, 300_000)), down))
+	if err != nil || n != 300_000 {
+		t.Fatalf("copied %d, err %v", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Fatalf("elapsed = %v, want >= 400ms", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := io.Copy(io.Discard, throttleReader(ctx, bytes.NewReader(make([]byte, 300_000)), down)); err != context.Canceled {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+}
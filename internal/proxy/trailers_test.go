@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResponseTrailersForwarded(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Usage-Tokens")
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "data: {}\n\n")
+		w.(http.Flusher).Flush()
+		w.Header().Set("X-Usage-Tokens", "42")
+	}))
+	defer upstream.Close()
+
+	p, repo := newTestProxy(t, upstream.URL)
+	srv := httptest.NewServer(p)
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/v1/stream", nil)
+	req.Host = "up.localhost"
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	srv.Close() // waits for the handler, and so for the log
+
+	if string(body) != "data: {}\n\n" || resp.Trailer.Get("X-Usage-Tokens") != "42" {
+		t.Fatalf("body = %q, trailer = %v", body, resp.Trailer)
+	}
+	if got := repo.only(t).ResponseHeaders["X-Usage-Tokens"]; len(got) != 1 || got[0] != "42" {
+		t.Fatalf("logged trailer = %v", got)
+	}
+}
+
+// countingReader records whether the client body was ever read.
+type countingReader struct {
+	r    io.Reader
+	read int32
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	atomic.StoreInt32(&c.read, 1)
+	return c.r.Read(p)
+}
+
+func TestExpectContinueLetsUpstreamRefuseEarly(t *testing.T) {
+	var expect string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expect = r.Header.Get("Expect")
+		http.Error(w, "unauthorized", http.StatusUnauthorized) // without reading the body
+	}))
+	defer upstream.Close()
+
+	p, _ := newTestProxy(t, upstream.URL)
+	srv := httptest.NewServer(p)
+	defer srv.Close()
+
+	body := &countingReader{r: strings.NewReader(strings.Repeat("x", 1<<16))}
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/v1/files", body)
+	req.Host = "up.localhost"
+	req.ContentLength = 1 << 16
+	req.Header.Set("Expect", "100-continue")
+	client := &http.Client{Transport: &http.Transport{ExpectContinueTimeout: 10 * time.Second}}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized || expect != "100-continue" {
+		t.Fatalf("status = %d, upstream Expect = %q", resp.StatusCode, expect)
+	}
+	if atomic.LoadInt32(&body.read) != 0 {
+		t.Fatal("client body was uploaded although the upstream refused it")
+	}
+}
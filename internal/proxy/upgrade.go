@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+// requestUpgrade returns the Upgrade header of a request that asks to switch
+// protocols (e.g. "websocket", "h2c"), or "" for an ordinary request.
+func requestUpgrade(h http.Header) string {
+	if !parseConnectionHeader(h.Values("Connection"))["Upgrade"] {
+		return ""
+	}
+	return strings.Join(h.Values("Upgrade"), ", ")
+}
+
+// passUpgrade restores what copyHeaders stripped from an upgrade request:
+// Upgrade, Connection and the headers Connection names (h2c needs
+// HTTP2-Settings). Fixed hop-by-hop headers stay stripped.
+func passUpgrade(dst, src http.Header) {
+	var conn []string
+	for k := range parseConnectionHeader(src.Values("Connection")) {
+		if k != "Upgrade" && isHopByHopHeader(k) {
+			continue
+		}
+		conn = append(conn, k)
+		if k != "Upgrade" {
+			dst[k] = src.Values(k)
+		}
+	}
+	sort.Strings(conn)
+	dst.Set("Connection", strings.Join(conn, ", "))
+	dst["Upgrade"] = src.Values("Upgrade")
+}
+
+// offeredUpgrade reports whether got is one of the comma-separated
+// protocols the client offered.
+func offeredUpgrade(requested, got string) bool {
+	if got == "" {
+		return false
+	}
+	for _, proto := range strings.Split(requested, ",") {
+		if strings.EqualFold(strings.TrimSpace(proto), got) {
+			return true
+		}
+	}
+	return false
+}
+
+// tunnelStats counts the bytes relayed over a switched connection.
+type tunnelStats struct {
+	up, down int64
+}
+
+// serveUpgrade relays a 101 response and logs the tunnel once it closes.
+func (p *Proxy) serveUpgrade(w http.ResponseWriter, resp *http.Response, requested string, logEntry *storage.RequestLog, startTime time.Time, reqCapture *limitedCapture, loggingCfg requestLogging) {
+	logEntry.ResponseHeaders = p.headerToMap(resp.Header)
+	backConn, err := upgradedConn(resp, requested)
+	if err != nil {
+		logEntry.StatusCode = http.StatusBadGateway
+		logEntry.Error = fmt.Sprintf("upgrade failed: %v", err)
+		p.finalizeAndSaveLog(logEntry, startTime, reqCapture, nil, loggingCfg)
+		http.Error(w, fmt.Sprintf("upstream error: %v", err), http.StatusBadGateway)
+		return
+	}
+	stats, hijacked, err := p.switchProtocols(w, resp, backConn)
+	if !hijacked {
+		logEntry.StatusCode = http.StatusInternalServerError
+		logEntry.Error = fmt.Sprintf("upgrade failed: %v", err)
+		p.finalizeAndSaveLog(logEntry, startTime, reqCapture, nil, loggingCfg)
+		http.Error(w, "protocol upgrade not supported", http.StatusInternalServerError)
+		return
+	}
+	logEntry.StatusCode = resp.StatusCode
+	logEntry.AddFlag(storage.FlagUpgraded)
+	logEntry.ResponseBodySize = stats.down
+	reqCapture.skip(stats.up)
+	if err != nil {
+		logEntry.Error = fmt.Sprintf("upgrade failed: %v", err)
+	}
+	p.finalizeAndSaveLog(logEntry, startTime, reqCapture, nil, loggingCfg)
+}
+
+// upgradedConn returns the upstream side of a 101 response, after checking
+// that the upstream switched to a protocol the client offered.
+func upgradedConn(resp *http.Response, requested string) (io.ReadWriteCloser, error) {
+	got := resp.Header.Get("Upgrade")
+	if !offeredUpgrade(requested, got) {
+		return nil, fmt.Errorf("upstream switched to protocol %q, client asked for %q", got, requested)
+	}
+	backConn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		return nil, errors.New("upstream 101 response body is not writable")
+	}
+	return backConn, nil
+}
+
+// switchProtocols hijacks the client connection, relays the upstream's 101
+// and then copies bytes both ways until either side closes. Only a failed
+// hijack leaves w usable for an error response.
+func (p *Proxy) switchProtocols(w http.ResponseWriter, resp *http.Response, backConn io.ReadWriteCloser) (stats tunnelStats, hijacked bool, err error) {
+	defer backConn.Close()
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return stats, false, fmt.Errorf("hijack client connection: %w", err)
+	}
+	defer conn.Close()
+
+	h := make(http.Header)
+	p.copyHeaders(h, resp.Header)
+	h.Set("Connection", "Upgrade")
+	h.Set("Upgrade", resp.Header.Get("Upgrade"))
+	if _, err := brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n"); err != nil {
+		return stats, true, err
+	}
+	if err := h.Write(brw); err != nil {
+		return stats, true, err
+	}
+	if _, err := brw.WriteString("\r\n"); err != nil {
+		return stats, true, err
+	}
+	if err := brw.Flush(); err != nil {
+		return stats, true, err
+	}
+
+	// Closing both ends once either direction finishes unblocks the other.
+	var once sync.Once
+	closeBoth := func() {
+		once.Do(func() {
+			conn.Close()
+			backConn.Close()
+		})
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// brw.Reader holds anything the client sent after its request.
+		stats.up, _ = io.Copy(backConn, brw.Reader)
+		closeBoth()
+	}()
+	stats.down, _ = io.Copy(conn, backConn)
+	closeBoth()
+	wg.Wait()
+	return stats, true, nil
+}
@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+// echoUpgradeServer switches to an "echo" protocol that sends back every
+// line it reads.
+func echoUpgradeServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.EqualFold(r.Header.Get("Upgrade"), "echo") || r.Header.Get("X-Echo-Settings") != "1" {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		_, _ = brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		_ = brw.Flush()
+		for {
+			line, err := brw.ReadString('\n')
+			if err != nil {
+				return
+			}
+			_, _ = brw.WriteString(line)
+			_ = brw.Flush()
+		}
+	}))
+}
+
+// dialUpgrade sends an upgrade request through the proxy over a raw
+// connection and returns the connection with the parsed response.
+func dialUpgrade(t *testing.T, addr string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: up.localhost\r\n"+
+		"Connection: Upgrade, X-Echo-Settings\r\nUpgrade: echo\r\nX-Echo-Settings: 1\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, br, resp
+}
+
+func TestAllowUpgradeTunnels(t *testing.T) {
+	upstream := echoUpgradeServer(t)
+	defer upstream.Close()
+
+	p, repo := newTestProxy(t, upstream.URL)
+	// The tunnel must outlive the per-request timeout.
+	p.cfg.Upstreams["up"] = config.UpstreamConfig{Target: upstream.URL, AllowUpgrade: true, Timeout: 1}
+	srv := httptest.NewServer(p)
+
+	conn, br, resp := dialUpgrade(t, srv.Listener.Addr().String())
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Upgrade") != "echo" {
+		t.Fatalf("status = %d, headers = %v", resp.StatusCode, resp.Header)
+	}
+	for _, msg := range []string{"hello\n", "again\n"} {
+		_, _ = io.WriteString(conn, msg)
+		if got, err := br.ReadString('\n'); err != nil || got != msg {
+			t.Fatalf("echo = %q, %v", got, err)
+		}
+		time.Sleep(600 * time.Millisecond)
+	}
+	conn.Close()
+	defer srv.Close() // doesn't wait for hijacked connections
+
+	var l *storage.RequestLog
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if l = repo.only(t); l.StatusCode != 0 {
+			break
+		}
+	}
+	if l.StatusCode != http.StatusSwitchingProtocols || !l.HasFlag(storage.FlagUpgraded) {
+		t.Fatalf("log = status %d flags %v error %q", l.StatusCode, l.Flags, l.Error)
+	}
+	if l.RequestBodySize != 12 || l.ResponseBodySize != 12 {
+		t.Fatalf("tunnel sizes = %d up, %d down", l.RequestBodySize, l.ResponseBodySize)
+	}
+}
+
+func TestUpgradeStrippedByDefault(t *testing.T) {
+	upstream := echoUpgradeServer(t)
+	defer upstream.Close()
+
+	p, _ := newTestProxy(t, upstream.URL)
+	srv := httptest.NewServer(p)
+	defer srv.Close()
+
+	conn, _, resp := dialUpgrade(t, srv.Listener.Addr().String())
+	defer conn.Close()
+	if resp.StatusCode != http.StatusUpgradeRequired {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusUpgradeRequired)
+	}
+}
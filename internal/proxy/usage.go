@@ -0,0 +1,262 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/prismcat/prismcat/internal/llm"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+const (
+	// usageWindow is the size of the head and of the tail a usageTap keeps
+	// of a plain body.
+	usageWindow = 64 << 10
+	// usageMaxEvents bounds the usage-bearing stream events a usageTap keeps
+	// (the latest win); usageMaxEvent skips larger events.
+	usageMaxEvents = 8
+	usageMaxEvent  = 256 << 10
+	// usageMaxEncoded bounds a compressed body kept for decoding at the end.
+	usageMaxEncoded = 1 << 20
+)
+
+// usageMarkers are the keys of provider usage reports (see llm.ExtractUsage).
+var usageMarkers = [][]byte{[]byte(`"usage"`), []byte(`"usageMetadata"`), []byte(`"eval_count"`)}
+
+// usageTap sees every byte of a request or response body, whatever the
+// capture limits, and keeps just enough to read the model and the token
+// usage the provider reported: for a stream, its first event and the
+// latest events mentioning usage; otherwise the head and tail of the body.
+// A compressed body is kept whole, up to usageMaxEncoded, and decoded at
+// the end. Writes come from one goroutine; it is read once they are done.
+type usageTap struct {
+	streaming   bool
+	contentType string
+	encoding    string
+
+	head, tail []byte
+	// gap is set once bytes between head and tail have been discarded.
+	gap bool
+
+	encoded  []byte
+	overflow bool
+
+	// pending is the stream's incomplete last event; skipEvent drops the
+	// rest of one that outgrew usageMaxEvent.
+	pending   []byte
+	skipEvent bool
+	first     []byte
+	events    [][]byte
+}
+
+// newUsageTap returns a tap for a body with the given headers.
+func newUsageTap(header http.Header, streaming bool) *usageTap {
+	encoding := strings.ToLower(strings.TrimSpace(header.Get("Content-Encoding")))
+	if encoding == "identity" {
+		encoding = ""
+	}
+	return &usageTap{streaming: streaming, contentType: header.Get("Content-Type"), encoding: encoding}
+}
+
+func (t *usageTap) Write(p []byte) (int, error) {
+	n := len(p)
+	if t.encoding != "" {
+		if !t.overflow && len(t.encoded)+len(p) <= usageMaxEncoded {
+			t.encoded = append(t.encoded, p...)
+		} else {
+			t.overflow, t.encoded = true, nil
+		}
+		return n, nil
+	}
+	if t.streaming {
+		t.scanEvents(p)
+		return n, nil
+	}
+	if room := usageWindow - len(t.head); room > 0 {
+		if room > len(p) {
+			room = len(p)
+		}
+		t.head = append(t.head, p[:room]...)
+		p = p[room:]
+	}
+	t.tail = append(t.tail, p...)
+	if len(t.tail) > 2*usageWindow {
+		t.tail = t.tail[:copy(t.tail, t.tail[len(t.tail)-usageWindow:])]
+		t.gap = true
+	}
+	return n, nil
+}
+
+// scanEvents splits a stream into events (SSE blocks, or NDJSON lines) and
+// keeps the ones usage may be read from.
+func (t *usageTap) scanEvents(p []byte) {
+	t.pending = append(t.pending, p...)
+	for {
+		end, next := t.eventEnd(t.pending)
+		if end < 0 {
+			break
+		}
+		if t.skipEvent {
+			t.skipEvent = false
+		} else {
+			t.keepEvent(t.pending[:end])
+		}
+		t.pending = t.pending[next:]
+	}
+	if len(t.pending) > usageMaxEvent {
+		t.pending, t.skipEvent = nil, true
+	}
+	t.pending = append([]byte(nil), t.pending...)
+}
+
+// eventEnd returns where the first complete event in b ends and the next
+// one starts, or -1.
+func (t *usageTap) eventEnd(b []byte) (end, next int) {
+	if isNDJSON(t.contentType) {
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			return i, i + 1
+		}
+		return -1, -1
+	}
+	end, next = -1, -1
+	if i := bytes.Index(b, []byte("\n\n")); i >= 0 {
+		end, next = i, i+2
+	}
+	if i := bytes.Index(b, []byte("\r\n\r\n")); i >= 0 && (end < 0 || i < end) {
+		end, next = i, i+4
+	}
+	return end, next
+}
+
+func (t *usageTap) keepEvent(ev []byte) {
+	if len(bytes.TrimSpace(ev)) == 0 {
+		return
+	}
+	if t.first == nil {
+		// The first event with data tells the provider format apart.
+		if isNDJSON(t.contentType) || bytes.Contains(ev, []byte("data:")) {
+			t.first = append([]byte(nil), ev...)
+		}
+		return
+	}
+	for _, m := range usageMarkers {
+		if bytes.Contains(ev, m) {
+			if len(t.events) == usageMaxEvents {
+				t.events = t.events[1:]
+			}
+			t.events = append(t.events, append([]byte(nil), ev...))
+			return
+		}
+	}
+}
+
+// body returns the decoded document: a JSON object, or for a stream the
+// merge of the events kept. ok is false when nothing could be read.
+func (t *usageTap) body() (map[string]interface{}, bool) {
+	if t.encoding != "" {
+		if t.overflow || len(t.encoded) == 0 {
+			return nil, false
+		}
+		r, ok := contentDecoder(t.encoding, bytes.NewReader(t.encoded))
+		if !ok {
+			return nil, false
+		}
+		defer r.Close()
+		plain := &usageTap{streaming: t.streaming, contentType: t.contentType}
+		if _, err := io.Copy(plain, r); err != nil {
+			return nil, false
+		}
+		return plain.body()
+	}
+	if t.streaming {
+		if t.first == nil {
+			return nil, false
+		}
+		events := append([][]byte{t.first}, t.events...)
+		if len(t.pending) > 0 && !t.skipEvent {
+			events = append(events, t.pending)
+		}
+		sep := []byte("\n\n")
+		if isNDJSON(t.contentType) {
+			sep = []byte("\n")
+		}
+		stream := bytes.Join(events, sep)
+		merged, _, ok := llm.MergeStream(llm.ParseStream(t.contentType, stream))
+		return merged, ok
+	}
+	if !t.gap {
+		var doc map[string]interface{}
+		if json.Unmarshal(append(t.head, t.tail...), &doc) == nil {
+			return doc, true
+		}
+		return nil, false
+	}
+	// Too large to keep whole: read the usage keys out of the tail (where
+	// providers put them) or the head.
+	doc := map[string]interface{}{}
+	for _, key := range []string{"usage", "usageMetadata", "eval_count", "prompt_eval_count", "model", "modelVersion"} {
+		if v, ok := findJSONKey(t.tail, key); ok {
+			doc[key] = v
+		} else if v, ok := findJSONKey(t.head, key); ok {
+			doc[key] = v
+		}
+	}
+	return doc, len(doc) > 0
+}
+
+// findJSONKey decodes the value following the last "key": in data that is
+// not inside a string.
+func findJSONKey(data []byte, key string) (interface{}, bool) {
+	pattern := []byte(`"` + key + `"`)
+	for end := len(data); end > 0; {
+		i := bytes.LastIndex(data[:end], pattern)
+		if i < 0 {
+			return nil, false
+		}
+		end = i
+		if i > 0 && data[i-1] == '\\' {
+			continue // an escaped quote: part of a string
+		}
+		rest := bytes.TrimLeft(data[i+len(pattern):], " \t\r\n")
+		if len(rest) == 0 || rest[0] != ':' {
+			continue
+		}
+		var v interface{}
+		if json.NewDecoder(bytes.NewReader(rest[1:])).Decode(&v) == nil {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func isNDJSON(contentType string) bool {
+	ct := strings.ToLower(contentType)
+	return strings.Contains(ct, "ndjson") || strings.Contains(ct, "stream+json") || strings.Contains(ct, "json-seq")
+}
+
+// recordUsage sets the model and the token usage of a finished log from
+// what the request and response taps saw. The model comes from the
+// request, then the response, then a Gemini-style path.
+func recordUsage(log *storage.RequestLog, reqCap, respCap *limitedCapture) {
+	if reqCap != nil && reqCap.usage != nil {
+		if req, ok := reqCap.usage.body(); ok {
+			log.Model = llm.ExtractModel(req)
+		}
+	}
+	if respCap != nil && respCap.usage != nil {
+		if resp, ok := respCap.usage.body(); ok {
+			if log.Model == "" {
+				log.Model = llm.ExtractModel(resp)
+			}
+			if u, ok := llm.ExtractUsage(resp); ok {
+				log.InputTokens, log.OutputTokens, log.TotalTokens = int64(u.InputTokens), int64(u.OutputTokens), int64(u.TotalTokens)
+			}
+		}
+	}
+	if log.Model == "" {
+		log.Model = pathModel(log.Path)
+	}
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUsageRecordedWithoutBodyCapture(t *testing.T) {
+	padding := strings.Repeat("lorem ipsum ", 20000) // well past usageWindow
+	openAI := `{"model":"gpt-4o-2024","choices":[{"message":{"content":"` + padding + `"}}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":17}}`
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/json":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(openAI))
+		case "/gzip":
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			_, _ = zw.Write([]byte(openAI))
+			_ = zw.Close()
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Encoding", "gzip")
+			_, _ = w.Write(buf.Bytes())
+		case "/sse":
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = w.Write([]byte("event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"model\":\"claude-sonnet-4\",\"usage\":{\"input_tokens\":20,\"output_tokens\":1}}}\n\n"))
+			for i := 0; i < 200; i++ {
+				_, _ = w.Write([]byte("event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\"" + padding[:1000] + "\"}}\n\n"))
+			}
+			_, _ = w.Write([]byte("event: message_delta\ndata: {\"type\":\"message_delta\",\"usage\":{\"output_tokens\":8}}\n\n"))
+		}
+	}))
+	defer upstream.Close()
+
+	p, repo := newTestProxy(t, upstream.URL)
+	for _, max := range []int64{0, 64} {
+		p.cfg.Logging.MaxRequestBody, p.cfg.Logging.MaxResponseBody = max, max
+		for _, c := range []struct {
+			path, model    string
+			in, out, total int64
+		}{
+			{"/json", "gpt-4o", 10, 5, 17},
+			{"/gzip", "gpt-4o", 10, 5, 17},
+			{"/sse", "claude-sonnet-4", 20, 8, 28},
+		} {
+			repo.logs = nil
+			req := httptest.NewRequest(http.MethodPost, "http://up.localhost"+c.path, strings.NewReader(`{"model":"`+c.model+`","messages":[{"role":"user","content":"`+padding[:500]+`"}]}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Accept-Encoding", "gzip")
+			p.ServeHTTP(httptest.NewRecorder(), req)
+			l := repo.only(t)
+			if l.Model != c.model || l.InputTokens != c.in || l.OutputTokens != c.out || l.TotalTokens != c.total {
+				t.Fatalf("max=%d %s: usage = %s %d/%d/%d", max, c.path, l.Model, l.InputTokens, l.OutputTokens, l.TotalTokens)
+			}
+			if int64(len(l.ResponseBody)) > max {
+				t.Fatalf("max=%d %s: captured %d bytes", max, c.path, len(l.ResponseBody))
+			}
+		}
+	}
+}
+
+func TestUsageFromResponseModelAndPath(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasPrefix(r.URL.Path, "/v1beta/") {
+			_, _ = w.Write([]byte(`{"usageMetadata":{"promptTokenCount":3,"candidatesTokenCount":4,"totalTokenCount":7}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"model":"gpt-4o-mini","usage":{"prompt_tokens":1,"completion_tokens":2,"total_tokens":3}}`))
+	}))
+	defer upstream.Close()
+
+	p, repo := newTestProxy(t, upstream.URL)
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://up.localhost/v1/models/x", nil))
+	if l := repo.only(t); l.Model != "gpt-4o-mini" || l.TotalTokens != 3 {
+		t.Fatalf("response model: %s %d", l.Model, l.TotalTokens)
+	}
+	repo.logs = nil
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "http://up.localhost/v1beta/models/gemini-2.5-pro:generateContent", strings.NewReader(`{}`)))
+	if l := repo.only(t); l.Model != "gemini-2.5-pro" || l.InputTokens != 3 || l.OutputTokens != 4 || l.TotalTokens != 7 {
+		t.Fatalf("path model: %s %d/%d/%d", l.Model, l.InputTokens, l.OutputTokens, l.TotalTokens)
+	}
+}
@@ -0,0 +1,109 @@
+// Package redact masks personal data and credentials in captured text before
+// it leaves PrismCat (exports, bundles).
+package redact
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Replacement kinds; the masked text becomes "[REDACTED:<KIND>]".
+const (
+	KindAPIKey = "API_KEY"
+	KindEmail  = "EMAIL"
+	KindCard   = "CARD"
+	KindPhone  = "PHONE"
+	KindIP     = "IP"
+	KindCustom = "CUSTOM"
+)
+
+type rule struct {
+	kind string
+	re   *regexp.Regexp
+	// valid, if set, filters regex matches (e.g. Luhn check for cards).
+	valid func(string) bool
+}
+
+// Order matters: keys first, since they may contain digit runs or "@"-free
+// fragments that later rules would otherwise partially mask.
+var defaultRules = []rule{
+	{kind: KindAPIKey, re: regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/-]{16,}=*`)},
+	{kind: KindAPIKey, re: regexp.MustCompile(`\b(?:sk-(?:ant-|proj-)?[A-Za-z0-9_-]{16,}|AIza[0-9A-Za-z_-]{35}|AKIA[0-9A-Z]{16}|gh[pousr]_[A-Za-z0-9]{36,}|xox[abpr]-[A-Za-z0-9-]{10,})`)},
+	{kind: KindEmail, re: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)},
+	{kind: KindCard, re: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), valid: luhn},
+	{kind: KindPhone, re: regexp.MustCompile(`\+\d{1,3}[ .-]?\(?\d{1,4}\)?(?:[ .-]?\d{2,4}){2,4}\b`)},
+	{kind: KindPhone, re: regexp.MustCompile(`\(?\b\d{3}\)?[ .-]\d{3}[ .-]\d{4}\b`)},
+	{kind: KindPhone, re: regexp.MustCompile(`\b1[3-9]\d{9}\b`)}, // 中国大陆手机号
+	{kind: KindIP, re: regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)\b`)},
+}
+
+// Redactor applies the built-in PII rules plus any custom patterns.
+type Redactor struct {
+	rules []rule
+}
+
+// New returns a Redactor with the built-in rules and the given extra regular
+// expressions (masked as KindCustom).
+func New(extraPatterns []string) (*Redactor, error) {
+	rules := append([]rule(nil), defaultRules...)
+	for _, p := range extraPatterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact pattern %q: %w", p, err)
+		}
+		rules = append(rules, rule{kind: KindCustom, re: re})
+	}
+	return &Redactor{rules: rules}, nil
+}
+
+// String returns s with every match replaced by its "[REDACTED:<KIND>]" marker.
+// The marker contains no quotes or backslashes, so redacting JSON text keeps
+// it valid JSON.
+func (r *Redactor) String(s string) string {
+	for _, rl := range r.rules {
+		rl := rl
+		s = rl.re.ReplaceAllStringFunc(s, func(m string) string {
+			if rl.valid != nil && !rl.valid(m) {
+				return m
+			}
+			return "[REDACTED:" + rl.kind + "]"
+		})
+	}
+	return s
+}
+
+// Headers returns a copy of headers with every value redacted.
+func (r *Redactor) Headers(headers map[string][]string) map[string][]string {
+	if headers == nil {
+		return nil
+	}
+	out := make(map[string][]string, len(headers))
+	for k, vv := range headers {
+		values := make([]string, len(vv))
+		for i, v := range vv {
+			values[i] = r.String(v)
+		}
+		out[k] = values
+	}
+	return out
+}
+
+// luhn validates a card number candidate, ignoring spaces and dashes.
+func luhn(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c == ' ' || c == '-' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
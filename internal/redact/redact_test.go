@@ -0,0 +1,33 @@
+package redact
+
+import "testing"
+
+func TestRedactorString(t *testing.T) {
+	r, err := New([]string{`user_[0-9]+`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct{ in, want string }{
+		{`{"email":"alice@example.com"}`, `{"email":"[REDACTED:EMAIL]"}`},
+		{"key sk-proj-abcdefghijklmnop1234 end", "key [REDACTED:API_KEY] end"},
+		{"Bearer abcdefghijklmnopqrstu", "[REDACTED:API_KEY]"},
+		{"card 4111 1111 1111 1111", "card [REDACTED:CARD]"},
+		{"not a card 4111 1111 1111 1112", "not a card 4111 1111 1111 1112"},
+		{"call +1 415 555 2671 now", "call [REDACTED:PHONE] now"},
+		{"手机 13812345678", "手机 [REDACTED:PHONE]"},
+		{"from 203.0.113.9", "from [REDACTED:IP]"},
+		{`{"created":1700000000,"tokens":123}`, `{"created":1700000000,"tokens":123}`},
+		{"owner user_42", "owner [REDACTED:CUSTOM]"},
+	}
+	for _, c := range cases {
+		if got := r.String(c.in); got != c.want {
+			t.Errorf("String(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestNewRejectsInvalidPattern(t *testing.T) {
+	if _, err := New([]string{"("}); err == nil {
+		t.Fatal("expected error")
+	}
+}
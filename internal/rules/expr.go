@@ -0,0 +1,689 @@
+package rules
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The expression language is a small CEL-like subset:
+//
+//	literals     "str" 'str' 42 1.5 true false null ["a", "b"]
+//	attributes   host path method query upstream header["X-Foo"] query_param["k"] json.model json.messages[0].role
+//	operators    == != < <= > >= && || ! in
+//	methods      s.startsWith(x) s.endsWith(x) s.contains(x) s.matches(re) s.lower() size(x)
+//
+// Missing attributes evaluate to null; comparisons against null are false
+// (except == null / != null), so rules never fail at runtime on absent data.
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func lex(src string) ([]token, error) {
+	var toks []token
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"' || c == '\'':
+			start := i
+			quote := c
+			i++
+			var b strings.Builder
+			for {
+				if i >= len(src) {
+					return nil, fmt.Errorf("unterminated string at %d", start)
+				}
+				ch := src[i]
+				if ch == quote {
+					i++
+					break
+				}
+				if ch == '\\' && i+1 < len(src) {
+					i++
+					switch src[i] {
+					case 'n':
+						b.WriteByte('\n')
+					case 't':
+						b.WriteByte('\t')
+					default:
+						b.WriteByte(src[i])
+					}
+					i++
+					continue
+				}
+				b.WriteByte(ch)
+				i++
+			}
+			toks = append(toks, token{kind: tokString, text: b.String(), pos: start})
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.') {
+				i++
+			}
+			toks = append(toks, token{kind: tokNumber, text: src[start:i], pos: start})
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			start := i
+			for i < len(src) && (src[i] == '_' || src[i] >= 'a' && src[i] <= 'z' || src[i] >= 'A' && src[i] <= 'Z' || src[i] >= '0' && src[i] <= '9') {
+				i++
+			}
+			toks = append(toks, token{kind: tokIdent, text: src[start:i], pos: start})
+		default:
+			start := i
+			two := ""
+			if i+1 < len(src) {
+				two = src[i : i+2]
+			}
+			switch two {
+			case "==", "!=", "<=", ">=", "&&", "||":
+				toks = append(toks, token{kind: tokOp, text: two, pos: start})
+				i += 2
+				continue
+			}
+			if strings.IndexByte("<>!()[].,", c) < 0 {
+				return nil, fmt.Errorf("unexpected character %q at %d", c, i)
+			}
+			toks = append(toks, token{kind: tokOp, text: string(c), pos: start})
+			i++
+		}
+	}
+	toks = append(toks, token{kind: tokEOF, pos: len(src)})
+	return toks, nil
+}
+
+// node is a compiled expression tree node.
+type node interface {
+	eval(env *Env) interface{}
+}
+
+type literalNode struct{ v interface{} }
+
+type listNode struct{ items []node }
+
+type identNode struct{ name string }
+
+type fieldNode struct {
+	target node
+	name   string
+}
+
+type indexNode struct {
+	target node
+	index  node
+}
+
+type unaryNode struct {
+	op string
+	x  node
+}
+
+type binaryNode struct {
+	op   string
+	l, r node
+}
+
+type callNode struct {
+	target node // nil for global functions
+	name   string
+	args   []node
+}
+
+type parser struct {
+	toks []token
+	pos  int
+}
+
+// Expr is a compiled expression.
+type Expr struct {
+	src  string
+	root node
+}
+
+// String returns the source text.
+func (e *Expr) String() string { return e.src }
+
+// UsesJSON reports whether the expression references the request JSON body.
+func (e *Expr) UsesJSON() bool {
+	return exprUsesJSON(e.root)
+}
+
+func exprUsesJSON(n node) bool {
+	switch v := n.(type) {
+	case identNode:
+		return v.name == "json"
+	case listNode:
+		for _, it := range v.items {
+			if exprUsesJSON(it) {
+				return true
+			}
+		}
+	case fieldNode:
+		return exprUsesJSON(v.target)
+	case indexNode:
+		return exprUsesJSON(v.target) || exprUsesJSON(v.index)
+	case unaryNode:
+		return exprUsesJSON(v.x)
+	case binaryNode:
+		return exprUsesJSON(v.l) || exprUsesJSON(v.r)
+	case callNode:
+		if v.target != nil && exprUsesJSON(v.target) {
+			return true
+		}
+		for _, a := range v.args {
+			if exprUsesJSON(a) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Compile parses an expression.
+func Compile(src string) (*Expr, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+func (p *parser) peek() token { return p.toks[p.pos] }
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) accept(kind tokenKind, text string) bool {
+	t := p.peek()
+	if t.kind == kind && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	t := p.next()
+	if t.kind != tokOp || t.text != text {
+		return fmt.Errorf("expected %q at %d, got %q", text, t.pos, t.text)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept(tokOp, "||") {
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = binaryNode{op: "||", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	l, err := p.parseCompare()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept(tokOp, "&&") {
+		r, err := p.parseCompare()
+		if err != nil {
+			return nil, err
+		}
+		l = binaryNode{op: "&&", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseCompare() (node, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	switch {
+	case t.kind == tokOp && (t.text == "==" || t.text == "!=" || t.text == "<" || t.text == "<=" || t.text == ">" || t.text == ">="):
+		p.next()
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return binaryNode{op: t.text, l: l, r: r}, nil
+	case t.kind == tokIdent && t.text == "in":
+		p.next()
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return binaryNode{op: "in", l: l, r: r}, nil
+	}
+	return l, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.accept(tokOp, "!") {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryNode{op: "!", x: x}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *parser) parsePostfix() (node, error) {
+	n, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept(tokOp, "."):
+			t := p.next()
+			if t.kind != tokIdent {
+				return nil, fmt.Errorf("expected field name at %d", t.pos)
+			}
+			if p.accept(tokOp, "(") {
+				args, err := p.parseArgs()
+				if err != nil {
+					return nil, err
+				}
+				call := callNode{target: n, name: t.text, args: args}
+				if err := validateCall(call); err != nil {
+					return nil, err
+				}
+				n = call
+				continue
+			}
+			n = fieldNode{target: n, name: t.text}
+		case p.accept(tokOp, "["):
+			idx, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			n = indexNode{target: n, index: idx}
+		default:
+			return n, nil
+		}
+	}
+}
+
+func (p *parser) parseArgs() ([]node, error) {
+	var args []node
+	if p.accept(tokOp, ")") {
+		return args, nil
+	}
+	for {
+		a, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, a)
+		if p.accept(tokOp, ")") {
+			return args, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokString:
+		return literalNode{v: t.text}, nil
+	case tokNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at %d", t.text, t.pos)
+		}
+		return literalNode{v: f}, nil
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return literalNode{v: true}, nil
+		case "false":
+			return literalNode{v: false}, nil
+		case "null":
+			return literalNode{v: nil}, nil
+		}
+		if p.accept(tokOp, "(") {
+			args, err := p.parseArgs()
+			if err != nil {
+				return nil, err
+			}
+			call := callNode{name: t.text, args: args}
+			if err := validateCall(call); err != nil {
+				return nil, err
+			}
+			return call, nil
+		}
+		if !knownIdents[t.text] {
+			return nil, fmt.Errorf("unknown attribute %q at %d", t.text, t.pos)
+		}
+		return identNode{name: t.text}, nil
+	case tokOp:
+		switch t.text {
+		case "(":
+			n, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return n, nil
+		case "[":
+			var items []node
+			if p.accept(tokOp, "]") {
+				return listNode{}, nil
+			}
+			for {
+				it, err := p.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, it)
+				if p.accept(tokOp, "]") {
+					return listNode{items: items}, nil
+				}
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	if t.kind == tokEOF {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+}
+
+var knownIdents = map[string]bool{
+	"host": true, "path": true, "method": true, "query": true, "upstream": true,
+	"header": true, "query_param": true, "json": true,
+}
+
+var callArity = map[string]int{
+	"startsWith": 1, "endsWith": 1, "contains": 1, "matches": 1, "lower": 0, "size": 1,
+}
+
+func validateCall(c callNode) error {
+	want, ok := callArity[c.name]
+	if !ok {
+		return fmt.Errorf("unknown function %q", c.name)
+	}
+	if c.name == "size" {
+		if c.target != nil || len(c.args) != 1 {
+			return fmt.Errorf("size() takes exactly one argument")
+		}
+		return nil
+	}
+	if c.target == nil {
+		return fmt.Errorf("%s() must be called as a method, e.g. path.%s(...)", c.name, c.name)
+	}
+	if len(c.args) != want {
+		return fmt.Errorf("%s() takes %d argument(s), got %d", c.name, want, len(c.args))
+	}
+	if c.name == "matches" {
+		if lit, ok := c.args[0].(literalNode); ok {
+			s, _ := lit.v.(string)
+			if _, err := compileRegexp(s); err != nil {
+				return fmt.Errorf("invalid regex %q: %w", s, err)
+			}
+		}
+	}
+	return nil
+}
+
+var regexCache sync.Map // string -> *regexp.Regexp
+
+func compileRegexp(s string) (*regexp.Regexp, error) {
+	if v, ok := regexCache.Load(s); ok {
+		return v.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return nil, err
+	}
+	regexCache.Store(s, re)
+	return re, nil
+}
+
+// Eval evaluates the expression and reports whether the result is truthy.
+func (e *Expr) Eval(env *Env) bool {
+	return truthy(e.root.eval(env))
+}
+
+func (n literalNode) eval(*Env) interface{} { return n.v }
+
+func (n listNode) eval(env *Env) interface{} {
+	out := make([]interface{}, len(n.items))
+	for i, it := range n.items {
+		out[i] = it.eval(env)
+	}
+	return out
+}
+
+func (n identNode) eval(env *Env) interface{} { return env.lookup(n.name) }
+
+func (n fieldNode) eval(env *Env) interface{} {
+	return index(n.target.eval(env), n.name)
+}
+
+func (n indexNode) eval(env *Env) interface{} {
+	return index(n.target.eval(env), n.index.eval(env))
+}
+
+func index(target, key interface{}) interface{} {
+	switch t := target.(type) {
+	case map[string]interface{}:
+		k, ok := key.(string)
+		if !ok {
+			return nil
+		}
+		return t[k]
+	case headerMap:
+		k, ok := key.(string)
+		if !ok {
+			return nil
+		}
+		return t.get(k)
+	case []interface{}:
+		f, ok := key.(float64)
+		if !ok {
+			return nil
+		}
+		i := int(f)
+		if i < 0 || i >= len(t) {
+			return nil
+		}
+		return t[i]
+	}
+	return nil
+}
+
+func (n unaryNode) eval(env *Env) interface{} {
+	return !truthy(n.x.eval(env))
+}
+
+func (n binaryNode) eval(env *Env) interface{} {
+	switch n.op {
+	case "&&":
+		return truthy(n.l.eval(env)) && truthy(n.r.eval(env))
+	case "||":
+		return truthy(n.l.eval(env)) || truthy(n.r.eval(env))
+	}
+
+	l := n.l.eval(env)
+	r := n.r.eval(env)
+	switch n.op {
+	case "==":
+		return equal(l, r)
+	case "!=":
+		return !equal(l, r)
+	case "in":
+		list, ok := r.([]interface{})
+		if !ok {
+			if s, ok := r.(string); ok {
+				ls, ok := l.(string)
+				return ok && strings.Contains(s, ls)
+			}
+			return false
+		}
+		for _, it := range list {
+			if equal(l, it) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Ordering comparisons: numbers or strings only.
+	if lf, ok := l.(float64); ok {
+		rf, ok := r.(float64)
+		if !ok {
+			return false
+		}
+		return compareOrdered(n.op, lf < rf, lf == rf)
+	}
+	if ls, ok := l.(string); ok {
+		rs, ok := r.(string)
+		if !ok {
+			return false
+		}
+		return compareOrdered(n.op, ls < rs, ls == rs)
+	}
+	return false
+}
+
+func compareOrdered(op string, less, eq bool) bool {
+	switch op {
+	case "<":
+		return less
+	case "<=":
+		return less || eq
+	case ">":
+		return !less && !eq
+	case ">=":
+		return !less
+	}
+	return false
+}
+
+func (n callNode) eval(env *Env) interface{} {
+	if n.name == "size" {
+		switch v := n.args[0].eval(env).(type) {
+		case string:
+			return float64(len(v))
+		case []interface{}:
+			return float64(len(v))
+		case map[string]interface{}:
+			return float64(len(v))
+		}
+		return float64(0)
+	}
+
+	s, ok := n.target.eval(env).(string)
+	if !ok {
+		if n.name == "lower" {
+			return nil
+		}
+		return false
+	}
+	if n.name == "lower" {
+		return strings.ToLower(s)
+	}
+	arg, ok := n.args[0].eval(env).(string)
+	if !ok {
+		return false
+	}
+	switch n.name {
+	case "startsWith":
+		return strings.HasPrefix(s, arg)
+	case "endsWith":
+		return strings.HasSuffix(s, arg)
+	case "contains":
+		return strings.Contains(s, arg)
+	case "matches":
+		re, err := compileRegexp(arg)
+		if err != nil {
+			return false
+		}
+		return re.MatchString(s)
+	}
+	return false
+}
+
+func equal(a, b interface{}) bool {
+	switch av := a.(type) {
+	case nil:
+		return b == nil
+	case string:
+		bv, ok := b.(string)
+		return ok && av == bv
+	case float64:
+		bv, ok := b.(float64)
+		return ok && av == bv
+	case bool:
+		bv, ok := b.(bool)
+		return ok && av == bv
+	}
+	return false
+}
+
+func truthy(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	case string:
+		return t != ""
+	case float64:
+		return t != 0
+	case []interface{}:
+		return len(t) > 0
+	case map[string]interface{}:
+		return len(t) > 0
+	}
+	return true
+}
@@ -0,0 +1,189 @@
+// Package rules evaluates config-defined request rules (routing, blocking,
+// tagging and header mutation) using a small CEL-like expression language.
+package rules
+
+import (
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"net/url"
+
+	"github.com/prismcat/prismcat/internal/config"
+)
+
+// Env holds the request attributes visible to expressions.
+type Env struct {
+	Host     string
+	Path     string
+	Method   string
+	Query    string
+	Upstream string
+	Header   http.Header
+	// JSON is the decoded request body (nil when the body is absent or not JSON).
+	JSON interface{}
+
+	queryParams url.Values
+}
+
+// headerMap resolves keys case-insensitively and returns the first value.
+type headerMap map[string][]string
+
+func (h headerMap) get(k string) interface{} {
+	if vv, ok := h[k]; ok && len(vv) > 0 {
+		return vv[0]
+	}
+	if vv, ok := h[textproto.CanonicalMIMEHeaderKey(k)]; ok && len(vv) > 0 {
+		return vv[0]
+	}
+	return nil
+}
+
+func (env *Env) lookup(name string) interface{} {
+	switch name {
+	case "host":
+		return env.Host
+	case "path":
+		return env.Path
+	case "method":
+		return env.Method
+	case "query":
+		return env.Query
+	case "upstream":
+		return env.Upstream
+	case "header":
+		return headerMap(env.Header)
+	case "query_param":
+		if env.queryParams == nil {
+			env.queryParams, _ = url.ParseQuery(env.Query)
+		}
+		return headerMap(env.queryParams)
+	case "json":
+		return env.JSON
+	}
+	return nil
+}
+
+// Rule is a compiled rule.
+type Rule struct {
+	Name          string
+	When          *Expr
+	Route         string
+	Block         bool
+	Status        int
+	Message       string
+	Tag           string
+	SetHeaders    map[string]string
+	RemoveHeaders []string
+	Stop          bool
+}
+
+// Engine evaluates rules in order.
+type Engine struct {
+	rules     []*Rule
+	needsJSON bool
+}
+
+// NewEngine compiles rule definitions. An empty definition list yields an engine
+// that never matches.
+func NewEngine(defs []config.RuleConfig) (*Engine, error) {
+	e := &Engine{}
+	for i, d := range defs {
+		name := d.Name
+		if name == "" {
+			name = fmt.Sprintf("rule#%d", i+1)
+		}
+		if d.When == "" {
+			return nil, fmt.Errorf("rule %q: when is required", name)
+		}
+		expr, err := Compile(d.When)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", name, err)
+		}
+		if d.Route == "" && !d.Block && d.Tag == "" && len(d.SetHeaders) == 0 && len(d.RemoveHeaders) == 0 {
+			return nil, fmt.Errorf("rule %q: no action (route, block, tag, set_headers, remove_headers)", name)
+		}
+		status := d.Status
+		if status == 0 {
+			status = http.StatusForbidden
+		}
+		e.rules = append(e.rules, &Rule{
+			Name:          name,
+			When:          expr,
+			Route:         d.Route,
+			Block:         d.Block,
+			Status:        status,
+			Message:       d.Message,
+			Tag:           d.Tag,
+			SetHeaders:    d.SetHeaders,
+			RemoveHeaders: d.RemoveHeaders,
+			Stop:          d.Stop,
+		})
+		e.needsJSON = e.needsJSON || expr.UsesJSON()
+	}
+	return e, nil
+}
+
+// Empty reports whether the engine has no rules.
+func (e *Engine) Empty() bool { return e == nil || len(e.rules) == 0 }
+
+// NeedsJSON reports whether any rule references the JSON request body.
+func (e *Engine) NeedsJSON() bool { return e != nil && e.needsJSON }
+
+// Result is the combined outcome of all matching rules.
+type Result struct {
+	// Matched lists the names of matching rules, in evaluation order.
+	Matched []string
+	// Route is the upstream chosen by the first matching routing rule.
+	Route string
+	// Blocked is the first matching blocking rule (evaluation stops there).
+	Blocked *Rule
+	// Tag is the last tag set by a matching rule.
+	Tag           string
+	SetHeaders    map[string]string
+	RemoveHeaders []string
+}
+
+// Evaluate runs all rules against env.
+func (e *Engine) Evaluate(env *Env) Result {
+	var res Result
+	if e == nil {
+		return res
+	}
+	for _, r := range e.rules {
+		if !r.When.Eval(env) {
+			continue
+		}
+		res.Matched = append(res.Matched, r.Name)
+		if r.Block {
+			res.Blocked = r
+			return res
+		}
+		if r.Route != "" && res.Route == "" {
+			res.Route = r.Route
+		}
+		if r.Tag != "" {
+			res.Tag = r.Tag
+		}
+		for k, v := range r.SetHeaders {
+			if res.SetHeaders == nil {
+				res.SetHeaders = make(map[string]string)
+			}
+			res.SetHeaders[k] = v
+		}
+		res.RemoveHeaders = append(res.RemoveHeaders, r.RemoveHeaders...)
+		if r.Stop {
+			break
+		}
+	}
+	return res
+}
+
+// Apply applies header mutations to h.
+func (res Result) Apply(h http.Header) {
+	for _, k := range res.RemoveHeaders {
+		h.Del(k)
+	}
+	for k, v := range res.SetHeaders {
+		h.Set(k, v)
+	}
+}
@@ -0,0 +1,85 @@
+package rules
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/prismcat/prismcat/internal/config"
+)
+
+func TestExprEval(t *testing.T) {
+	env := &Env{
+		Host:   "openai.localhost",
+		Path:   "/v1/chat/completions",
+		Method: "POST",
+		Query:  "stream=true",
+		Header: http.Header{"X-Team": []string{"search"}},
+		JSON: map[string]interface{}{
+			"model":    "gpt-4o",
+			"messages": []interface{}{map[string]interface{}{"role": "system"}},
+		},
+	}
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`path.startsWith("/v1/chat")`, true},
+		{`method == "GET"`, false},
+		{`header["x-team"] == "search"`, true},
+		{`header["X-Missing"] == null`, true},
+		{`json.model in ["gpt-4o", "gpt-4.1"]`, true},
+		{`json.messages[0].role == 'system' && !(query_param["stream"] == "false")`, true},
+		{`size(json.messages) > 1`, false},
+		{`path.matches("^/v1/(chat|completions)")`, true},
+		{`json.missing.deep == "x" || host.endsWith(".localhost")`, true},
+		{`json.model.lower().contains("GPT")`, false},
+	}
+	for _, tt := range tests {
+		expr, err := Compile(tt.expr)
+		if err != nil {
+			t.Fatalf("Compile(%q): %v", tt.expr, err)
+		}
+		if got := expr.Eval(env); got != tt.want {
+			t.Errorf("Eval(%q) = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, src := range []string{
+		`path ==`,
+		`bogus == "x"`,
+		`path.startsWith()`,
+		`path.matches("(")`,
+		`"unterminated`,
+	} {
+		if _, err := Compile(src); err == nil {
+			t.Errorf("Compile(%q) succeeded, want error", src)
+		}
+	}
+}
+
+func TestEngineEvaluateOrder(t *testing.T) {
+	e, err := NewEngine([]config.RuleConfig{
+		{Name: "tag-chat", When: `path.contains("chat")`, Tag: "chat", SetHeaders: map[string]string{"X-A": "1"}},
+		{Name: "route-eu", When: `header["X-Region"] == "eu"`, Route: "openai-eu"},
+		{Name: "block-debug", When: `path.startsWith("/debug")`, Block: true},
+	})
+	if err != nil {
+		t.Fatalf("NewEngine: %v", err)
+	}
+	if e.NeedsJSON() {
+		t.Fatalf("NeedsJSON = true, want false")
+	}
+
+	res := e.Evaluate(&Env{Path: "/v1/chat", Header: http.Header{"X-Region": []string{"eu"}}})
+	if res.Route != "openai-eu" || res.Tag != "chat" || res.SetHeaders["X-A"] != "1" || res.Blocked != nil {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+
+	res = e.Evaluate(&Env{Path: "/debug/x"})
+	if res.Blocked == nil || res.Blocked.Name != "block-debug" || res.Blocked.Status != http.StatusForbidden {
+		t.Fatalf("expected block-debug, got %+v", res)
+	}
+}
@@ -0,0 +1,408 @@
+// Package schema implements a pragmatic subset of JSON Schema (draft 2020-12)
+// used to validate upstream responses.
+//
+// Supported keywords: type, enum, const, properties, required,
+// additionalProperties, items, minItems, maxItems, minLength, maxLength,
+// pattern, minimum, maximum, allOf, anyOf, oneOf, not. Unknown keywords
+// (including $schema, $id, title, description) are ignored, so schemas
+// written for full validators still load.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Schema is a compiled JSON Schema node.
+type Schema struct {
+	// always is set for boolean schemas (true accepts, false rejects).
+	always *bool
+
+	types     []string
+	enum      []interface{}
+	constVal  interface{}
+	hasConst  bool
+	props     map[string]*Schema
+	required  []string
+	addlProps *Schema
+	items     *Schema
+	minItems  *int
+	maxItems  *int
+	minLength *int
+	maxLength *int
+	pattern   *regexp.Regexp
+	minimum   *float64
+	maximum   *float64
+	allOf     []*Schema
+	anyOf     []*Schema
+	oneOf     []*Schema
+	not       *Schema
+}
+
+// Compile parses a JSON Schema document.
+func Compile(data []byte) (*Schema, error) {
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parse schema: %w", err)
+	}
+	return compileValue(raw, "#")
+}
+
+// CompileValue compiles an already-decoded schema (e.g. inline YAML).
+func CompileValue(v interface{}) (*Schema, error) {
+	// Round-trip through JSON to normalize YAML maps and number types.
+	data, err := json.Marshal(normalizeYAML(v))
+	if err != nil {
+		return nil, fmt.Errorf("encode schema: %w", err)
+	}
+	return Compile(data)
+}
+
+func compileValue(raw interface{}, at string) (*Schema, error) {
+	switch v := raw.(type) {
+	case bool:
+		return &Schema{always: &v}, nil
+	case map[string]interface{}:
+		return compileObject(v, at)
+	default:
+		return nil, fmt.Errorf("%s: schema must be an object or boolean", at)
+	}
+}
+
+func compileObject(m map[string]interface{}, at string) (*Schema, error) {
+	s := &Schema{}
+	var err error
+
+	switch t := m["type"].(type) {
+	case nil:
+	case string:
+		s.types = []string{t}
+	case []interface{}:
+		for _, x := range t {
+			str, ok := x.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s/type: expected string", at)
+			}
+			s.types = append(s.types, str)
+		}
+	default:
+		return nil, fmt.Errorf("%s/type: expected string or array", at)
+	}
+
+	if e, ok := m["enum"].([]interface{}); ok {
+		s.enum = e
+	}
+	if c, ok := m["const"]; ok {
+		s.constVal, s.hasConst = c, true
+	}
+
+	if props, ok := m["properties"].(map[string]interface{}); ok {
+		s.props = make(map[string]*Schema, len(props))
+		for name, sub := range props {
+			if s.props[name], err = compileValue(sub, at+"/properties/"+name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if req, ok := m["required"].([]interface{}); ok {
+		for _, x := range req {
+			if str, ok := x.(string); ok {
+				s.required = append(s.required, str)
+			}
+		}
+	}
+	if ap, ok := m["additionalProperties"]; ok {
+		if s.addlProps, err = compileValue(ap, at+"/additionalProperties"); err != nil {
+			return nil, err
+		}
+	}
+	if it, ok := m["items"]; ok {
+		if s.items, err = compileValue(it, at+"/items"); err != nil {
+			return nil, err
+		}
+	}
+
+	s.minItems = intKeyword(m, "minItems")
+	s.maxItems = intKeyword(m, "maxItems")
+	s.minLength = intKeyword(m, "minLength")
+	s.maxLength = intKeyword(m, "maxLength")
+	s.minimum = floatKeyword(m, "minimum")
+	s.maximum = floatKeyword(m, "maximum")
+
+	if p, ok := m["pattern"].(string); ok {
+		if s.pattern, err = regexp.Compile(p); err != nil {
+			return nil, fmt.Errorf("%s/pattern: %w", at, err)
+		}
+	}
+
+	for _, kw := range []struct {
+		name string
+		dst  *[]*Schema
+	}{{"allOf", &s.allOf}, {"anyOf", &s.anyOf}, {"oneOf", &s.oneOf}} {
+		list, ok := m[kw.name].([]interface{})
+		if !ok {
+			continue
+		}
+		for i, sub := range list {
+			c, err := compileValue(sub, fmt.Sprintf("%s/%s/%d", at, kw.name, i))
+			if err != nil {
+				return nil, err
+			}
+			*kw.dst = append(*kw.dst, c)
+		}
+	}
+	if n, ok := m["not"]; ok {
+		if s.not, err = compileValue(n, at+"/not"); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func intKeyword(m map[string]interface{}, key string) *int {
+	if f, ok := m[key].(float64); ok {
+		n := int(f)
+		return &n
+	}
+	return nil
+}
+
+func floatKeyword(m map[string]interface{}, key string) *float64 {
+	if f, ok := m[key].(float64); ok {
+		return &f
+	}
+	return nil
+}
+
+// ValidateJSON decodes data and validates it against the schema.
+func (s *Schema) ValidateJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	return s.Validate(v)
+}
+
+// Validate checks a decoded JSON value (as produced by encoding/json).
+// The returned error describes the first violation with a JSON pointer.
+func (s *Schema) Validate(v interface{}) error {
+	return s.validate(v, "")
+}
+
+func (s *Schema) validate(v interface{}, at string) error {
+	if s.always != nil {
+		if *s.always {
+			return nil
+		}
+		return violation(at, "no value allowed")
+	}
+
+	if len(s.types) > 0 && !matchesAnyType(v, s.types) {
+		return violation(at, "expected %s, got %s", strings.Join(s.types, " or "), typeOf(v))
+	}
+	if s.enum != nil {
+		found := false
+		for _, e := range s.enum {
+			if equalJSON(v, e) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return violation(at, "value not in enum")
+		}
+	}
+	if s.hasConst && !equalJSON(v, s.constVal) {
+		return violation(at, "value does not match const")
+	}
+
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for _, r := range s.required {
+			if _, ok := val[r]; !ok {
+				return violation(at, "missing required property %q", r)
+			}
+		}
+		// Iterate in sorted order so the reported violation is deterministic.
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			sub, ok := s.props[k]
+			if !ok {
+				sub = s.addlProps
+			}
+			if sub == nil {
+				continue
+			}
+			if err := sub.validate(val[k], at+"/"+escapePointer(k)); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if s.minItems != nil && len(val) < *s.minItems {
+			return violation(at, "expected at least %d items, got %d", *s.minItems, len(val))
+		}
+		if s.maxItems != nil && len(val) > *s.maxItems {
+			return violation(at, "expected at most %d items, got %d", *s.maxItems, len(val))
+		}
+		if s.items != nil {
+			for i, item := range val {
+				if err := s.items.validate(item, fmt.Sprintf("%s/%d", at, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		n := len([]rune(val))
+		if s.minLength != nil && n < *s.minLength {
+			return violation(at, "string shorter than %d", *s.minLength)
+		}
+		if s.maxLength != nil && n > *s.maxLength {
+			return violation(at, "string longer than %d", *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(val) {
+			return violation(at, "string does not match pattern %q", s.pattern.String())
+		}
+	case float64:
+		if s.minimum != nil && val < *s.minimum {
+			return violation(at, "%v is less than minimum %v", val, *s.minimum)
+		}
+		if s.maximum != nil && val > *s.maximum {
+			return violation(at, "%v is greater than maximum %v", val, *s.maximum)
+		}
+	}
+
+	for _, sub := range s.allOf {
+		if err := sub.validate(v, at); err != nil {
+			return err
+		}
+	}
+	if len(s.anyOf) > 0 {
+		ok := false
+		for _, sub := range s.anyOf {
+			if sub.validate(v, at) == nil {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return violation(at, "value does not match any schema in anyOf")
+		}
+	}
+	if len(s.oneOf) > 0 {
+		n := 0
+		for _, sub := range s.oneOf {
+			if sub.validate(v, at) == nil {
+				n++
+			}
+		}
+		if n != 1 {
+			return violation(at, "value matches %d schemas in oneOf, want exactly 1", n)
+		}
+	}
+	if s.not != nil && s.not.validate(v, at) == nil {
+		return violation(at, "value must not match schema in not")
+	}
+	return nil
+}
+
+// ValidationError describes a schema violation.
+type ValidationError struct {
+	// Pointer is a JSON pointer to the offending value ("" is the document root).
+	Pointer string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	at := e.Pointer
+	if at == "" {
+		at = "/"
+	}
+	return at + ": " + e.Message
+}
+
+func violation(at, format string, args ...interface{}) error {
+	return &ValidationError{Pointer: at, Message: fmt.Sprintf(format, args...)}
+}
+
+func escapePointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
+
+func matchesAnyType(v interface{}, types []string) bool {
+	for _, t := range types {
+		switch t {
+		case "integer":
+			if f, ok := v.(float64); ok && f == float64(int64(f)) {
+				return true
+			}
+		case "number":
+			if _, ok := v.(float64); ok {
+				return true
+			}
+		default:
+			if typeOf(v) == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func typeOf(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+func equalJSON(a, b interface{}) bool {
+	ab, err1 := json.Marshal(a)
+	bb, err2 := json.Marshal(b)
+	return err1 == nil && err2 == nil && string(ab) == string(bb)
+}
+
+// normalizeYAML converts map[interface{}]interface{} (yaml.v2 style) into
+// map[string]interface{} so the value can be JSON-encoded.
+func normalizeYAML(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, val := range x {
+			m[fmt.Sprint(k)] = normalizeYAML(val)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, val := range x {
+			m[k] = normalizeYAML(val)
+		}
+		return m
+	case []interface{}:
+		out := make([]interface{}, len(x))
+		for i, val := range x {
+			out[i] = normalizeYAML(val)
+		}
+		return out
+	default:
+		return v
+	}
+}
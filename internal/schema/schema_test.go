@@ -0,0 +1,79 @@
+package schema
+
+import (
+	"strings"
+	"testing"
+)
+
+const chatSchema = `{
+  "type": "object",
+  "required": ["id", "choices"],
+  "properties": {
+    "id": {"type": "string"},
+    "choices": {
+      "type": "array",
+      "minItems": 1,
+      "items": {
+        "type": "object",
+        "required": ["message"],
+        "properties": {
+          "index": {"type": "integer", "minimum": 0},
+          "finish_reason": {"enum": ["stop", "length", "tool_calls", null]}
+        }
+      }
+    }
+  }
+}`
+
+func TestValidateJSON(t *testing.T) {
+	s, err := Compile([]byte(chatSchema))
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+
+	cases := []struct {
+		doc     string
+		wantErr string
+	}{
+		{`{"id":"x","choices":[{"index":0,"message":{},"finish_reason":"stop"}]}`, ""},
+		{`{"id":"x","choices":[{"index":0,"message":{},"finish_reason":null}]}`, ""},
+		{`{"choices":[]}`, `missing required property "id"`},
+		{`{"id":"x","choices":[]}`, "/choices: expected at least 1 items"},
+		{`{"id":1,"choices":[{"message":{}}]}`, "/id: expected string, got number"},
+		{`{"id":"x","choices":[{"index":1.5,"message":{}}]}`, "/choices/0/index: expected integer"},
+		{`{"id":"x","choices":[{"message":{},"finish_reason":"eos"}]}`, "/choices/0/finish_reason: value not in enum"},
+		{`not json`, "invalid JSON"},
+	}
+	for _, c := range cases {
+		err := s.ValidateJSON([]byte(c.doc))
+		if c.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", c.doc, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+			t.Errorf("%s: err = %v, want containing %q", c.doc, err, c.wantErr)
+		}
+	}
+}
+
+func TestCompileValueFromYAMLShape(t *testing.T) {
+	s, err := CompileValue(map[string]interface{}{
+		"type":     "object",
+		"required": []interface{}{"ok"},
+		"properties": map[string]interface{}{
+			"ok": map[string]interface{}{"type": "boolean"},
+		},
+		"additionalProperties": false,
+	})
+	if err != nil {
+		t.Fatalf("CompileValue: %v", err)
+	}
+	if err := s.ValidateJSON([]byte(`{"ok":true}`)); err != nil {
+		t.Fatalf("valid doc rejected: %v", err)
+	}
+	if err := s.ValidateJSON([]byte(`{"ok":true,"extra":1}`)); err == nil {
+		t.Fatalf("additionalProperties:false not enforced")
+	}
+}
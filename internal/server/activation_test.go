@@ -0,0 +1,37 @@
+//go:build !windows
+
+package server
+
+import (
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestFileListeners(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A raw descriptor not owned by an *os.File, as with LISTEN_FDS;
+	// fileListeners takes ownership of it.
+	fd, err := syscall.Dup(int(f.Fd()))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lns, err := fileListeners(fd, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lns[0].Close()
+	if got, want := listenerPort(lns[0]), listenerPort(ln); got != want {
+		t.Fatalf("port = %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// maxPortFallback is how many ports after the configured one are tried
+// when port fallback is enabled.
+const maxPortFallback = 20
+
+// ErrPortInUse is returned by Start when the configured port is taken and
+// port fallback is disabled (or no nearby port is free).
+var ErrPortInUse = errors.New("port already in use")
+
+// wsaEADDRINUSE is Windows' WSAEADDRINUSE, which syscall.EADDRINUSE does
+// not match there.
+const wsaEADDRINUSE = syscall.Errno(10048)
+
+func isAddrInUse(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	return errno == syscall.EADDRINUSE || errno == wsaEADDRINUSE
+}
+
+// listen binds addr:port. With fallback, a taken port moves on to the next
+// ones, up to maxPortFallback. It returns the listener and the bound port.
+func listen(addr string, port int, fallback bool) (net.Listener, int, error) {
+	tries := 1
+	if fallback && port != 0 {
+		tries += maxPortFallback
+	}
+	for i := 0; i < tries; i++ {
+		p := port + i
+		if p > 65535 {
+			break
+		}
+		ln, err := net.Listen("tcp", net.JoinHostPort(addr, strconv.Itoa(p)))
+		if err == nil {
+			return ln, ln.Addr().(*net.TCPAddr).Port, nil
+		}
+		if !isAddrInUse(err) {
+			return nil, 0, err
+		}
+	}
+	if fallback {
+		return nil, 0, fmt.Errorf("%w: %d-%d", ErrPortInUse, port, port+tries-1)
+	}
+	return nil, 0, fmt.Errorf("%w: %d", ErrPortInUse, port)
+}
+
+// sdListenFDsStart is the first descriptor passed by systemd socket activation.
+const sdListenFDsStart = 3
+
+// activationListeners returns the listeners passed by systemd socket
+// activation (LISTEN_PID / LISTEN_FDS), or nil when not socket-activated.
+// The variables are unset so child processes don't inherit them.
+func activationListeners() ([]net.Listener, error) {
+	pid, fds := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
+	if fds == "" {
+		return nil, nil
+	}
+	// LISTEN_PID guards against variables inherited from a parent process.
+	if pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	_ = os.Unsetenv("LISTEN_PID")
+	_ = os.Unsetenv("LISTEN_FDS")
+	_ = os.Unsetenv("LISTEN_FDNAMES")
+
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+	return fileListeners(sdListenFDsStart, n)
+}
+
+// fileListeners wraps descriptors first..first+n-1 as listeners.
+func fileListeners(first, n int) ([]net.Listener, error) {
+	lns := make([]net.Listener, 0, n)
+	for fd := first; fd < first+n; fd++ {
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		ln, err := net.FileListener(f)
+		// FileListener dups the descriptor; the original is no longer needed.
+		_ = f.Close()
+		if err != nil {
+			for _, l := range lns {
+				_ = l.Close()
+			}
+			return nil, fmt.Errorf("socket activation fd %d: %w", fd, err)
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
+
+// listenerPort returns the TCP port of ln, or 0 for other socket types.
+func listenerPort(ln net.Listener) int {
+	if addr, ok := ln.Addr().(*net.TCPAddr); ok {
+		return addr.Port
+	}
+	return 0
+}
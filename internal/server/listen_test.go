@@ -0,0 +1,29 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestListenPortFallback(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	port := taken.Addr().(*net.TCPAddr).Port
+
+	if _, _, err := listen("127.0.0.1", port, false); !errors.Is(err, ErrPortInUse) {
+		t.Fatalf("err = %v, want ErrPortInUse", err)
+	}
+
+	ln, got, err := listen("127.0.0.1", port, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if got <= port || got > port+maxPortFallback {
+		t.Fatalf("port = %d, want in (%d, %d]", got, port, port+maxPortFallback)
+	}
+}
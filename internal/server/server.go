@@ -3,11 +3,13 @@ package server
 import (
 	"context"
 	"embed"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"log"
 	"mime"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -20,6 +22,7 @@ import (
 	"github.com/prismcat/prismcat/internal/api"
 	"github.com/prismcat/prismcat/internal/config"
 	"github.com/prismcat/prismcat/internal/proxy"
+	"github.com/prismcat/prismcat/internal/sink"
 	"github.com/prismcat/prismcat/internal/storage"
 )
 
@@ -49,6 +52,16 @@ func hasPathExt(urlPath string) bool {
 	return dot > 0 && dot < len(base)-1
 }
 
+// isPathRoute reports whether a request names its upstream with a
+// "/u/<upstream>" path prefix that server.routing_mode lets through.
+func isPathRoute(cfg config.ServerConfig, path string) bool {
+	if !cfg.RoutesByPath() {
+		return false
+	}
+	_, _, ok := proxy.SplitPathRoute(path)
+	return ok
+}
+
 func applyCORS(w http.ResponseWriter, r *http.Request, cfg config.ServerConfig) {
 	if len(cfg.CORSAllowOrigins) == 0 {
 		return
@@ -174,19 +187,67 @@ type Server struct {
 	proxy  *proxy.Proxy
 	api    *api.Handler
 	server *http.Server
+
+	portFallback bool
+	onListening  func(port int)
 }
 
 // New 创建服务器实例
-func New(cfg *config.Config, repo storage.Repository, blobs storage.BlobStore) *Server {
+// middlewares 按顺序注册到代理管道（插件、嵌入方扩展等）。
+func New(cfg *config.Config, repo storage.Repository, blobs storage.BlobStore, middlewares ...proxy.Middleware) *Server {
+	p := proxy.New(cfg, repo, middlewares...)
+	h := api.New(cfg, repo, blobs)
+	h.SetMetrics(p)
+	h.SetDNSCache(p.DNSCache())
 	return &Server{
 		cfg:   cfg,
 		repo:  repo,
 		blobs: blobs,
-		proxy: proxy.New(cfg, repo),
-		api:   api.New(cfg, repo, blobs),
+		proxy: p,
+		api:   h,
 	}
 }
 
+// SetDiskGuard 设置磁盘空间监控（用于健康检查）
+func (s *Server) SetDiskGuard(g *storage.DiskGuard) {
+	s.api.SetDiskGuard(g)
+}
+
+// SetRecovery 设置启动恢复与完整性检查结果（用于健康检查）
+func (s *Server) SetRecovery(r *storage.Recovery) {
+	s.api.SetRecovery(r)
+}
+
+// SetJournal 设置请求 journal：记录日志的请求在转发前同步写入，崩溃后启动时恢复
+func (s *Server) SetJournal(j *storage.Journal) {
+	s.proxy.SetJournal(j)
+}
+
+// SetLeader 设置集群维护租约状态（用于健康检查）
+func (s *Server) SetLeader(isLeader func() bool) {
+	s.api.SetLeader(isLeader)
+}
+
+// SetSinks 设置日志外送（用于健康检查）
+func (s *Server) SetSinks(r *sink.Repository) {
+	s.api.SetSinks(r)
+}
+
+// SetPortFallback 端口被占用时是否依次尝试后续端口（最多 20 个）
+func (s *Server) SetPortFallback(enabled bool) {
+	s.portFallback = enabled
+}
+
+// SetOnListening 设置端口绑定成功后的回调，参数为实际监听的端口
+func (s *Server) SetOnListening(fn func(port int)) {
+	s.onListening = fn
+}
+
+// SetOnUpstreamFailing 设置上游连续失败达到阈值时的回调（server.failure_notify_threshold）
+func (s *Server) SetOnUpstreamFailing(fn func(proxy.UpstreamFailure)) {
+	s.proxy.SetOnUpstreamFailing(fn)
+}
+
 // Start 启动服务器
 func (s *Server) Start() error {
 	mux := http.NewServeMux()
@@ -245,17 +306,47 @@ func (s *Server) Start() error {
 			return
 		}
 
-		// Routing: UI Host (Control Panel + API) vs Proxy Host
-		if s.cfg.IsUIHost(r.Host) {
+		// Routing: UI Host (Control Panel + API) vs Proxy Host. An explicit
+		// upstream header, or a /u/<upstream> path with path routing on,
+		// always means proxy traffic, even on a UI host.
+		if s.cfg.IsUIHost(r.Host) && r.Header.Get(proxy.UpstreamHeader) == "" && !isPathRoute(serverCfg, r.URL.Path) {
 			authMiddleware(mux).ServeHTTP(w, r)
 		} else {
 			s.proxy.ServeHTTP(w, r)
 		}
 	})
 
-	addr := fmt.Sprintf("%s:%d", serverCfg.Addr, serverCfg.Port)
+	// systemd 套接字激活时直接使用传入的监听套接字，重启期间连接由 systemd 排队
+	listeners, err := activationListeners()
+	if err != nil {
+		return fmt.Errorf("服务器启动失败: %w", err)
+	}
+	port := serverCfg.Port
+	if len(listeners) > 0 {
+		for _, ln := range listeners {
+			log.Printf("使用 systemd 传入的监听套接字: %s", ln.Addr())
+		}
+		if p := listenerPort(listeners[0]); p != 0 {
+			port = p
+		}
+	} else {
+		// 先绑定端口，冲突时在返回前报告（或按需改用下一个空闲端口）
+		var ln net.Listener
+		ln, port, err = listen(serverCfg.Addr, serverCfg.Port, s.portFallback)
+		if err != nil {
+			if errors.Is(err, ErrPortInUse) && !s.portFallback {
+				return fmt.Errorf("服务器启动失败: %w（可使用 -auto-port 自动改用下一个空闲端口）", err)
+			}
+			return fmt.Errorf("服务器启动失败: %w", err)
+		}
+		if port != serverCfg.Port {
+			log.Printf("⚠️ 端口 %d 已被占用，改用端口 %d", serverCfg.Port, port)
+		}
+		listeners = []net.Listener{ln}
+	}
+
 	s.server = &http.Server{
-		Addr:         addr,
+		Addr:         listeners[0].Addr().String(),
 		Handler:      mainHandler,
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 0, // 流式响应需要禁用写超时
@@ -263,18 +354,28 @@ func (s *Server) Start() error {
 	}
 
 	log.Printf("🐱 PrismCat 启动成功！")
-	log.Printf("📊 控制台: http://localhost:%d", serverCfg.Port)
+	log.Printf("📊 控制台: http://localhost:%d", port)
 	proxyDomain := "localhost"
 	if len(serverCfg.ProxyDomains) > 0 {
 		proxyDomain = serverCfg.ProxyDomains[0]
 	}
-	log.Printf("🔀 代理示例: http://openai.%s:%d", proxyDomain, serverCfg.Port)
+	if serverCfg.RoutesByHost() {
+		log.Printf("🔀 代理示例: http://openai.%s:%d", proxyDomain, port)
+	}
+	if serverCfg.RoutesByPath() {
+		log.Printf("🔀 代理示例: http://localhost:%d%sopenai", port, proxy.PathRoutePrefix)
+	}
 	log.Println("按 Ctrl+C 停止服务")
+	if s.onListening != nil {
+		s.onListening(port)
+	}
 
-	errCh := make(chan error, 1)
-	go func() {
-		errCh <- s.server.ListenAndServe()
-	}()
+	errCh := make(chan error, len(listeners))
+	for _, ln := range listeners {
+		go func() {
+			errCh <- s.server.Serve(ln)
+		}()
+	}
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -0,0 +1,196 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+var clickHouseIdent = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// clickHouseSchema is the column list of the exported table. Columns omitted
+// from an insert (bodies when disabled) take their defaults.
+const clickHouseSchema = `(
+	id String,
+	created_at DateTime64(3, 'UTC'),
+	source LowCardinality(String),
+	upstream LowCardinality(String),
+	method LowCardinality(String),
+	path String,
+	query String,
+	status_code UInt16,
+	latency_ms Int64,
+	request_body_size Int64,
+	response_body_size Int64,
+	streaming Bool,
+	truncated Bool,
+	error String,
+	tag String,
+	client_ip String,
+	user_agent String,
+	variant LowCardinality(String),
+	flags Array(LowCardinality(String)),
+	request_body_ref String,
+	response_body_ref String,
+	request_body String,
+	response_body String
+)
+ENGINE = ReplacingMergeTree
+PARTITION BY toYYYYMM(created_at)
+ORDER BY (upstream, created_at, id)`
+
+// ClickHouse inserts log summaries into a ClickHouse table over the HTTP
+// interface using JSONEachRow.
+type ClickHouse struct {
+	cfg    config.ClickHouseSinkConfig
+	table  string // quoted `db`.`table`
+	source string
+	client *http.Client
+
+	created bool
+}
+
+type clickHouseRow struct {
+	ID               string   `json:"id"`
+	CreatedAt        string   `json:"created_at"`
+	Source           string   `json:"source"`
+	Upstream         string   `json:"upstream"`
+	Method           string   `json:"method"`
+	Path             string   `json:"path"`
+	Query            string   `json:"query"`
+	StatusCode       int      `json:"status_code"`
+	Latency          int64    `json:"latency_ms"`
+	RequestBodySize  int64    `json:"request_body_size"`
+	ResponseBodySize int64    `json:"response_body_size"`
+	Streaming        bool     `json:"streaming"`
+	Truncated        bool     `json:"truncated"`
+	Error            string   `json:"error"`
+	Tag              string   `json:"tag"`
+	ClientIP         string   `json:"client_ip"`
+	UserAgent        string   `json:"user_agent"`
+	Variant          string   `json:"variant"`
+	Flags            []string `json:"flags"`
+	RequestBodyRef   string   `json:"request_body_ref"`
+	ResponseBodyRef  string   `json:"response_body_ref"`
+	RequestBody      *string  `json:"request_body,omitempty"`
+	ResponseBody     *string  `json:"response_body,omitempty"`
+}
+
+// NewClickHouse creates a ClickHouse sink. The table is created on the first
+// write.
+func NewClickHouse(cfg config.ClickHouseSinkConfig) (*ClickHouse, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("sinks.clickhouse.url: want an http(s) URL, got %q", cfg.URL)
+	}
+	if cfg.Database == "" {
+		cfg.Database = "default"
+	}
+	if cfg.Table == "" {
+		cfg.Table = "prismcat_logs"
+	}
+	if !clickHouseIdent.MatchString(cfg.Database) || !clickHouseIdent.MatchString(cfg.Table) {
+		return nil, errors.New("sinks.clickhouse: database and table must be plain identifiers")
+	}
+	source, _ := os.Hostname()
+	return &ClickHouse{
+		cfg:    cfg,
+		table:  "`" + cfg.Database + "`.`" + cfg.Table + "`",
+		source: source,
+		client: &http.Client{Timeout: writeTimeout},
+	}, nil
+}
+
+func (c *ClickHouse) Name() string { return "clickhouse" }
+
+func (c *ClickHouse) Write(ctx context.Context, logs []*storage.RequestLog) error {
+	if !c.created {
+		if err := c.exec(ctx, "CREATE TABLE IF NOT EXISTS "+c.table+" "+clickHouseSchema, nil); err != nil {
+			return err
+		}
+		c.created = true
+	}
+
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, l := range logs {
+		if err := enc.Encode(c.row(l)); err != nil {
+			return err
+		}
+	}
+	return c.exec(ctx, "INSERT INTO "+c.table+" FORMAT JSONEachRow", &body)
+}
+
+func (c *ClickHouse) row(l *storage.RequestLog) clickHouseRow {
+	row := clickHouseRow{
+		ID:               l.ID,
+		CreatedAt:        l.CreatedAt.UTC().Format("2006-01-02 15:04:05.000"),
+		Source:           l.Source,
+		Upstream:         l.Upstream,
+		Method:           l.Method,
+		Path:             l.Path,
+		Query:            l.Query,
+		StatusCode:       l.StatusCode,
+		Latency:          l.Latency,
+		RequestBodySize:  l.RequestBodySize,
+		ResponseBodySize: l.ResponseBodySize,
+		Streaming:        l.Streaming,
+		Truncated:        l.Truncated,
+		Error:            l.Error,
+		Tag:              l.Tag,
+		ClientIP:         l.ClientIP,
+		UserAgent:        l.UserAgent,
+		Variant:          l.Variant,
+		Flags:            l.Flags,
+		RequestBodyRef:   l.RequestBodyRef,
+		ResponseBodyRef:  l.ResponseBodyRef,
+	}
+	if row.Source == "" {
+		row.Source = c.source
+	}
+	if row.Flags == nil {
+		row.Flags = []string{}
+	}
+	if c.cfg.Bodies {
+		row.RequestBody = &l.RequestBody
+		row.ResponseBody = &l.ResponseBody
+	}
+	return row
+}
+
+// exec runs query; for inserts the rows are sent as the request body.
+func (c *ClickHouse) exec(ctx context.Context, query string, data io.Reader) error {
+	endpoint := strings.TrimRight(c.cfg.URL, "/") + "/?" + url.Values{"query": {query}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, data)
+	if err != nil {
+		return err
+	}
+	if c.cfg.Username != "" {
+		req.Header.Set("X-ClickHouse-User", c.cfg.Username)
+	}
+	if c.cfg.Password != "" {
+		req.Header.Set("X-ClickHouse-Key", c.cfg.Password)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("clickhouse: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+func TestClickHouseSinkCreatesTableAndInsertsRows(t *testing.T) {
+	var mu sync.Mutex
+	var queries []string
+	var rows []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-ClickHouse-User") != "writer" {
+			http.Error(w, "auth", http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		queries = append(queries, r.URL.Query().Get("query"))
+		sc := bufio.NewScanner(r.Body)
+		for sc.Scan() {
+			var row map[string]interface{}
+			if err := json.Unmarshal(sc.Bytes(), &row); err != nil {
+				t.Errorf("bad row %q: %v", sc.Text(), err)
+			}
+			rows = append(rows, row)
+		}
+	}))
+	defer srv.Close()
+
+	ch, err := NewClickHouse(config.ClickHouseSinkConfig{URL: srv.URL, Username: "writer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs := []*storage.RequestLog{{
+		ID:           "log-1",
+		CreatedAt:    time.Date(2024, 5, 1, 12, 0, 0, 250e6, time.UTC),
+		Upstream:     "openai",
+		StatusCode:   200,
+		ResponseBody: "secret body",
+	}}
+	for i := 0; i < 2; i++ {
+		if err := ch.Write(context.Background(), logs); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(queries) != 3 || !strings.HasPrefix(queries[0], "CREATE TABLE IF NOT EXISTS `default`.`prismcat_logs`") {
+		t.Fatalf("queries = %q", queries)
+	}
+	if queries[1] != "INSERT INTO `default`.`prismcat_logs` FORMAT JSONEachRow" {
+		t.Fatalf("insert query = %q", queries[1])
+	}
+	if len(rows) != 2 {
+		t.Fatalf("rows = %d, want 2", len(rows))
+	}
+	row := rows[0]
+	if row["id"] != "log-1" || row["created_at"] != "2024-05-01 12:00:00.250" || row["source"] == "" {
+		t.Fatalf("row = %v", row)
+	}
+	if _, ok := row["response_body"]; ok {
+		t.Fatalf("bodies exported without bodies: true: %v", row)
+	}
+}
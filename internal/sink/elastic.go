@@ -0,0 +1,189 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+// elasticMapping indexes summaries as keywords/numbers for aggregations and
+// bodies and errors as text for full-text search. Headers are kept in
+// _source but not indexed, and dynamic mapping is off so arbitrary header
+// names can't blow up the mapping.
+var elasticMapping = map[string]interface{}{
+	"dynamic": false,
+	"properties": map[string]interface{}{
+		"id":                 esType("keyword"),
+		"created_at":         esType("date"),
+		"source":             esType("keyword"),
+		"upstream":           esType("keyword"),
+		"target_url":         esType("keyword"),
+		"method":             esType("keyword"),
+		"path":               esType("keyword"),
+		"query":              map[string]interface{}{"type": "keyword", "ignore_above": 2048},
+		"request_headers":    map[string]interface{}{"type": "object", "enabled": false},
+		"request_body":       esType("text"),
+		"request_body_ref":   esType("keyword"),
+		"request_body_size":  esType("long"),
+		"status_code":        esType("integer"),
+		"response_headers":   map[string]interface{}{"type": "object", "enabled": false},
+		"response_body":      esType("text"),
+		"response_body_ref":  esType("keyword"),
+		"response_body_size": esType("long"),
+		"streaming":          esType("boolean"),
+		"latency_ms":         esType("long"),
+		"error":              esType("text"),
+		"truncated":          esType("boolean"),
+		"tag":                esType("keyword"),
+		"client_ip":          esType("keyword"),
+		"remote_addr":        esType("keyword"),
+		"user_agent":         map[string]interface{}{"type": "keyword", "ignore_above": 512},
+		"variant":            esType("keyword"),
+		"flags":              esType("keyword"),
+	},
+}
+
+func esType(t string) map[string]interface{} {
+	return map[string]interface{}{"type": t}
+}
+
+// Elastic indexes logs into Elasticsearch or OpenSearch with the bulk API.
+type Elastic struct {
+	cfg    config.ElasticSinkConfig
+	client *http.Client
+
+	templated bool
+}
+
+// NewElastic creates an Elasticsearch/OpenSearch sink. The index template is
+// installed on the first write.
+func NewElastic(cfg config.ElasticSinkConfig) (*Elastic, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("sinks.elasticsearch.url: want an http(s) URL, got %q", cfg.URL)
+	}
+	if cfg.Index == "" {
+		cfg.Index = "prismcat-logs"
+	}
+	// Index names must be lowercase and can't contain these.
+	if cfg.Index != strings.ToLower(cfg.Index) || strings.ContainsAny(cfg.Index, ` "*\<|,>/?#:`) {
+		return nil, fmt.Errorf("sinks.elasticsearch.index: invalid index name %q", cfg.Index)
+	}
+	cfg.URL = strings.TrimRight(cfg.URL, "/")
+	return &Elastic{cfg: cfg, client: &http.Client{Timeout: writeTimeout}}, nil
+}
+
+func (e *Elastic) Name() string { return "elasticsearch" }
+
+func (e *Elastic) Write(ctx context.Context, logs []*storage.RequestLog) error {
+	if !e.templated {
+		if err := e.installTemplate(ctx); err != nil {
+			return err
+		}
+		e.templated = true
+	}
+
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, l := range logs {
+		action := map[string]interface{}{"index": map[string]string{"_index": e.indexFor(l.CreatedAt), "_id": l.ID}}
+		if err := enc.Encode(action); err != nil {
+			return err
+		}
+		if err := enc.Encode(l); err != nil {
+			return err
+		}
+	}
+
+	var res struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			ID     string          `json:"_id"`
+			Status int             `json:"status"`
+			Error  json.RawMessage `json:"error"`
+		} `json:"items"`
+	}
+	if err := e.do(ctx, http.MethodPost, "/_bulk", "application/x-ndjson", &body, &res); err != nil {
+		return err
+	}
+	if !res.Errors {
+		return nil
+	}
+	failed := 0
+	var first string
+	for _, item := range res.Items {
+		for _, r := range item {
+			if r.Status >= 300 {
+				failed++
+				if first == "" {
+					first = fmt.Sprintf("%s: %d %s", r.ID, r.Status, r.Error)
+				}
+			}
+		}
+	}
+	return fmt.Errorf("elasticsearch: %d of %d documents rejected, first %s", failed, len(logs), first)
+}
+
+// indexFor returns the target index for a log created at t.
+func (e *Elastic) indexFor(t time.Time) string {
+	if !e.cfg.Daily {
+		return e.cfg.Index
+	}
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return e.cfg.Index + "-" + t.UTC().Format("2006.01.02")
+}
+
+// installTemplate creates or updates the composable index template, which
+// both Elasticsearch (7.8+) and OpenSearch support.
+func (e *Elastic) installTemplate(ctx context.Context) error {
+	template := map[string]interface{}{
+		"index_patterns": []string{e.cfg.Index, e.cfg.Index + "-*"},
+		"template":       map[string]interface{}{"mappings": elasticMapping},
+		"priority":       100,
+		"_meta":          map[string]string{"managed_by": "prismcat"},
+	}
+	data, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+	return e.do(ctx, http.MethodPut, "/_index_template/"+url.PathEscape(e.cfg.Index), "application/json", bytes.NewReader(data), nil)
+}
+
+func (e *Elastic) do(ctx context.Context, method, path, contentType string, body io.Reader, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, e.cfg.URL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	switch {
+	case e.cfg.APIKey != "":
+		req.Header.Set("Authorization", "ApiKey "+e.cfg.APIKey)
+	case e.cfg.Username != "":
+		req.SetBasicAuth(e.cfg.Username, e.cfg.Password)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("elasticsearch %s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
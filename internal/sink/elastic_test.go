@@ -0,0 +1,75 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+func TestElasticSinkBulkIndexesDailyIndices(t *testing.T) {
+	var templates, bulks int
+	var actions []map[string]map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "ApiKey k" {
+			http.Error(w, "auth", http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/_index_template/prismcat-logs":
+			templates++
+			data, _ := io.ReadAll(r.Body)
+			if !strings.Contains(string(data), `"prismcat-logs-*"`) {
+				t.Errorf("template = %s", data)
+			}
+			_, _ = w.Write([]byte(`{"acknowledged":true}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/_bulk":
+			bulks++
+			sc := bufio.NewScanner(r.Body)
+			for i := 0; sc.Scan(); i++ {
+				if i%2 == 0 {
+					var a map[string]map[string]string
+					if err := json.Unmarshal(sc.Bytes(), &a); err != nil {
+						t.Errorf("bad action %q", sc.Text())
+					}
+					actions = append(actions, a)
+				}
+			}
+			if bulks == 1 {
+				_, _ = w.Write([]byte(`{"errors":false,"items":[]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"errors":true,"items":[{"index":{"_id":"b","status":400,"error":{"type":"mapper_parsing_exception"}}}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	es, err := NewElastic(config.ElasticSinkConfig{URL: srv.URL + "/", Daily: true, APIKey: "k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs := []*storage.RequestLog{{ID: "a", CreatedAt: time.Date(2024, 5, 1, 23, 0, 0, 0, time.UTC), StatusCode: 200}}
+	if err := es.Write(context.Background(), logs); err != nil {
+		t.Fatal(err)
+	}
+	if err := es.Write(context.Background(), []*storage.RequestLog{{ID: "b", StatusCode: 500}}); err == nil || !strings.Contains(err.Error(), "1 of 1") {
+		t.Fatalf("rejected document not reported: %v", err)
+	}
+
+	if templates != 1 || bulks != 2 {
+		t.Fatalf("templates=%d bulks=%d", templates, bulks)
+	}
+	if got := actions[0]["index"]; got["_index"] != "prismcat-logs-2024.05.01" || got["_id"] != "a" {
+		t.Fatalf("action = %v", got)
+	}
+}
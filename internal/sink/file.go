@@ -0,0 +1,183 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+const defaultFileMaxSizeMB = 100
+
+// rotatedTimeFormat sorts lexically, so the oldest rotated file sorts first.
+const rotatedTimeFormat = "20060102T150405.000"
+
+// File appends logs as JSON lines to a local file and rotates it by size.
+type File struct {
+	cfg      config.FileSinkConfig
+	maxBytes int64
+	blobs    storage.BlobStore
+	now      func() time.Time
+
+	f    *os.File
+	size int64
+}
+
+// NewFile opens (or creates) the capture file. blobs is only used with
+// inline_bodies and may be nil.
+func NewFile(cfg config.FileSinkConfig, blobs storage.BlobStore) (*File, error) {
+	if strings.TrimSpace(cfg.Path) == "" {
+		return nil, errors.New("sinks.file.path: required")
+	}
+	if cfg.MaxSizeMB <= 0 {
+		cfg.MaxSizeMB = defaultFileMaxSizeMB
+	}
+	s := &File{
+		cfg:      cfg,
+		maxBytes: int64(cfg.MaxSizeMB) << 20,
+		blobs:    blobs,
+		now:      time.Now,
+	}
+	if err := os.MkdirAll(filepath.Dir(cfg.Path), 0o755); err != nil {
+		return nil, fmt.Errorf("sinks.file.path: %w", err)
+	}
+	if err := s.open(); err != nil {
+		return nil, fmt.Errorf("sinks.file.path: %w", err)
+	}
+	return s, nil
+}
+
+func (s *File) Name() string { return "file" }
+
+func (s *File) open() error {
+	f, err := os.OpenFile(s.cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f, s.size = f, st.Size()
+	return nil
+}
+
+// Write appends the batch, first rotating a file that has reached its size
+// limit. Everything that can fail runs before the write, so a retried batch
+// only repeats lines when the write itself failed part-way.
+func (s *File) Write(ctx context.Context, logs []*storage.RequestLog) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, l := range logs {
+		if s.cfg.InlineBodies {
+			var err error
+			if l, err = s.inlineBodies(ctx, l); err != nil {
+				return err
+			}
+		}
+		if err := enc.Encode(l); err != nil {
+			return err
+		}
+	}
+	if s.f != nil && s.size >= s.maxBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	if s.f == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	n, err := s.f.Write(buf.Bytes())
+	s.size += int64(n)
+	return err
+}
+
+// inlineBodies returns a copy of l with detached bodies read back in full.
+// A merged stream keeps its reassembled JSON; bodies missing from the blob
+// store keep their preview.
+func (s *File) inlineBodies(ctx context.Context, l *storage.RequestLog) (*storage.RequestLog, error) {
+	if s.blobs == nil || (l.RequestBodyRef == "" && l.ResponseBodyRef == "") {
+		return l, nil
+	}
+	cp := *l
+	resolve := func(body, ref *string) error {
+		if *ref == "" {
+			return nil
+		}
+		data, err := s.blobs.Get(ctx, *ref)
+		if errors.Is(err, storage.ErrBlobNotFound) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read body %s: %w", *ref, err)
+		}
+		*body, *ref = string(data), ""
+		return nil
+	}
+	if err := resolve(&cp.RequestBody, &cp.RequestBodyRef); err != nil {
+		return nil, err
+	}
+	if !cp.HasFlag(storage.FlagStreamMerged) {
+		if err := resolve(&cp.ResponseBody, &cp.ResponseBodyRef); err != nil {
+			return nil, err
+		}
+	}
+	return &cp, nil
+}
+
+// rotate renames the active file aside and prunes rotated files past
+// max_files. The next write opens a new active file.
+func (s *File) rotate() error {
+	if err := s.f.Close(); err != nil {
+		return err
+	}
+	s.f = nil
+	ext := filepath.Ext(s.cfg.Path)
+	base := strings.TrimSuffix(s.cfg.Path, ext)
+	if err := os.Rename(s.cfg.Path, base+"-"+s.now().UTC().Format(rotatedTimeFormat)+ext); err != nil {
+		return err
+	}
+	if s.cfg.MaxFiles <= 0 {
+		return nil
+	}
+	matches, err := filepath.Glob(base + "-*" + ext)
+	if err != nil {
+		return err
+	}
+	var old []string
+	for _, m := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(m, base+"-"), ext)
+		if _, err := time.Parse(rotatedTimeFormat, stamp); err == nil {
+			old = append(old, m)
+		}
+	}
+	sort.Strings(old)
+	for len(old) > s.cfg.MaxFiles {
+		if err := os.Remove(old[0]); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		old = old[1:]
+	}
+	return nil
+}
+
+func (s *File) Close() error {
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
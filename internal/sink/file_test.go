@@ -0,0 +1,114 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+func TestFileSinkRotatesAndPrunes(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "capture.jsonl")
+	s, err := NewFile(config.FileSinkConfig{Path: path, MaxFiles: 2}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.maxBytes = 1 // every batch after the first starts a new file
+	clock := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	s.now = func() time.Time { clock = clock.Add(time.Second); return clock }
+
+	ctx := context.Background()
+	for _, id := range []string{"a", "b", "c", "d"} {
+		if err := s.Write(ctx, []*storage.RequestLog{{ID: id, StatusCode: 200}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// An unrelated file that merely shares the prefix is left alone.
+	if err := os.WriteFile(filepath.Join(dir, "capture-notes.jsonl"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write(ctx, []*storage.RequestLog{{ID: "e", StatusCode: 200}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	want := []string{
+		"capture-20260102T150408.000.jsonl", // "c"
+		"capture-20260102T150409.000.jsonl", // "d"
+		"capture-notes.jsonl",
+		"capture.jsonl", // "e"
+	}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Fatalf("files = %v, want %v", names, want)
+	}
+	if ids := readIDs(t, filepath.Join(dir, want[1])); strings.Join(ids, ",") != "d" {
+		t.Fatalf("rotated file holds %v", ids)
+	}
+	if ids := readIDs(t, path); strings.Join(ids, ",") != "e" {
+		t.Fatalf("active file holds %v", ids)
+	}
+}
+
+func TestFileSinkInlinesBodies(t *testing.T) {
+	ctx := context.Background()
+	blobs := storage.NewMemoryBlobStore(0)
+	full := strings.Repeat("x", 100)
+	ref, err := blobs.Put(ctx, []byte(full))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	s, err := NewFile(config.FileSinkConfig{Path: path, InlineBodies: true}, blobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := &storage.RequestLog{ID: "a", StatusCode: 200, ResponseBody: full[:10], ResponseBodyRef: ref}
+	if err := s.Write(ctx, []*storage.RequestLog{entry}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	data, _ := os.ReadFile(path)
+	var got storage.RequestLog
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ResponseBody != full || got.ResponseBodyRef != "" {
+		t.Fatalf("line = body %d bytes, ref %q", len(got.ResponseBody), got.ResponseBodyRef)
+	}
+	if entry.ResponseBodyRef != ref {
+		t.Fatal("shared log entry was modified")
+	}
+}
+
+func readIDs(t *testing.T, path string) []string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var ids []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var l storage.RequestLog
+		if err := json.Unmarshal(sc.Bytes(), &l); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, l.ID)
+	}
+	return ids
+}
@@ -0,0 +1,346 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+const (
+	kafkaMetadataTTL = 5 * time.Minute
+	// kafkaMaxRequestBytes keeps produce requests under the broker's default
+	// message.max.bytes (1MB).
+	kafkaMaxRequestBytes = 900 << 10
+	kafkaProduceTimeout  = 10 * time.Second
+)
+
+// Kafka publishes each log as a JSON message keyed by log ID. It speaks the
+// Kafka wire protocol directly and waits for all in-sync replicas (acks=all).
+//
+// A batch is settled partition by partition: when some partitions fail (or
+// have no leader), Write reports an error and the retried batch only resends
+// the logs that were not acknowledged.
+type Kafka struct {
+	cfg config.KafkaSinkConfig
+
+	conns map[string]*kafkaConn
+	meta  *kafkaMetadata
+	// settled holds the IDs of logs of the batch being retried that were
+	// acknowledged by the broker or dropped as oversized.
+	settled map[string]bool
+}
+
+type kafkaMetadata struct {
+	brokers   map[int32]string // node id -> host:port
+	leaders   []int32          // leader node id by partition index; -1 if none
+	fetchedAt time.Time
+}
+
+// NewKafka creates a Kafka sink. Brokers are contacted lazily.
+func NewKafka(cfg config.KafkaSinkConfig) (*Kafka, error) {
+	if len(cfg.Brokers) == 0 || cfg.Topic == "" {
+		return nil, errors.New("sinks.kafka: brokers and topic are required")
+	}
+	return &Kafka{cfg: cfg, conns: make(map[string]*kafkaConn), settled: make(map[string]bool)}, nil
+}
+
+func (k *Kafka) Name() string { return "kafka" }
+
+func (k *Kafka) Write(ctx context.Context, logs []*storage.RequestLog) error {
+	k.keepSettled(logs)
+	meta, err := k.metadata(ctx)
+	if err != nil {
+		return err
+	}
+
+	// broker -> partition -> records, preserving log order per partition.
+	byBroker := make(map[string]map[int32][]kafkaRecord)
+	leaderless := 0
+	for _, l := range logs {
+		if k.settled[l.ID] {
+			continue
+		}
+		value, err := json.Marshal(l)
+		if err != nil {
+			return err
+		}
+		rec := kafkaRecord{key: []byte(l.ID), value: value, time: l.CreatedAt}
+		if rec.time.IsZero() {
+			rec.time = time.Now()
+		}
+		if n := rec.size(); n > kafkaMaxRequestBytes {
+			// The broker would refuse it on every attempt.
+			log.Printf("sink kafka: dropped log %s: %d bytes exceeds the %d byte limit", l.ID, n, kafkaMaxRequestBytes)
+			k.settled[l.ID] = true
+			continue
+		}
+		p := partitionFor(rec.key, len(meta.leaders))
+		addr, ok := meta.brokers[meta.leaders[p]]
+		if !ok {
+			leaderless++
+			continue
+		}
+		if byBroker[addr] == nil {
+			byBroker[addr] = make(map[int32][]kafkaRecord)
+		}
+		byBroker[addr][p] = append(byBroker[addr][p], rec)
+	}
+
+	var errs []error
+	for addr, parts := range byBroker {
+		if err := k.produce(ctx, addr, parts); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if leaderless > 0 {
+		errs = append(errs, fmt.Errorf("kafka: %d logs wait for a partition leader of topic %s", leaderless, k.cfg.Topic))
+	}
+	if len(errs) > 0 {
+		// Leadership may have moved; refetch before the retry.
+		k.meta = nil
+		return errors.Join(errs...)
+	}
+	clear(k.settled)
+	return nil
+}
+
+// keepSettled forgets settled logs that are not part of logs: the previous
+// batch gave up and this is a new one.
+func (k *Kafka) keepSettled(logs []*storage.RequestLog) {
+	if len(k.settled) == 0 {
+		return
+	}
+	current := make(map[string]bool, len(logs))
+	for _, l := range logs {
+		current[l.ID] = true
+	}
+	for id := range k.settled {
+		if !current[id] {
+			delete(k.settled, id)
+		}
+	}
+}
+
+// Close closes all broker connections.
+func (k *Kafka) Close() error {
+	for addr, c := range k.conns {
+		_ = c.conn.Close()
+		delete(k.conns, addr)
+	}
+	return nil
+}
+
+// produce sends the records to one broker, splitting them into requests
+// that stay under kafkaMaxRequestBytes. Every request is sent even if an
+// earlier one failed; the errors are joined.
+func (k *Kafka) produce(ctx context.Context, addr string, parts map[int32][]kafkaRecord) error {
+	var errs []error
+	req := make(map[int32][]kafkaRecord)
+	size := 0
+	flush := func() {
+		if len(req) == 0 {
+			return
+		}
+		if err := k.sendProduce(ctx, addr, req); err != nil {
+			errs = append(errs, err)
+		}
+		req = make(map[int32][]kafkaRecord)
+		size = 0
+	}
+	for p, records := range parts {
+		for _, r := range records {
+			n := r.size()
+			if size > 0 && size+n > kafkaMaxRequestBytes {
+				flush()
+			}
+			req[p] = append(req[p], r)
+			size += n
+		}
+	}
+	flush()
+	return errors.Join(errs...)
+}
+
+// sendProduce sends one produce request and marks the records of every
+// partition the broker acknowledged as settled.
+func (k *Kafka) sendProduce(ctx context.Context, addr string, parts map[int32][]kafkaRecord) error {
+	var e kafkaEncoder
+	e.nullString() // transactional id
+	e.int16(-1)    // acks=all
+	e.int32(int32(kafkaProduceTimeout / time.Millisecond))
+	e.int32(1)
+	e.string(k.cfg.Topic)
+	e.int32(int32(len(parts)))
+	for p, records := range parts {
+		e.int32(p)
+		e.bytes(encodeRecordBatch(records))
+	}
+
+	resp, err := k.roundTrip(ctx, addr, apiProduce, 3, e.b)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	d := kafkaDecoder{b: resp}
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		d.string()
+		for j, m := 0, d.arrayLen(); j < m; j++ {
+			p := d.int32()
+			code := d.int16()
+			d.int64() // base offset
+			d.int64() // log append time
+			if d.err != nil {
+				break
+			}
+			if code != 0 {
+				errs = append(errs, fmt.Errorf("kafka: produce to %s[%d]: %w", k.cfg.Topic, p, kafkaError(code)))
+				continue
+			}
+			for _, r := range parts[p] {
+				k.settled[string(r.key)] = true
+			}
+		}
+	}
+	if d.err != nil {
+		errs = append(errs, d.err)
+	}
+	return errors.Join(errs...)
+}
+
+// metadata returns cached partition leaders, refreshing them from the first
+// reachable bootstrap broker when stale.
+func (k *Kafka) metadata(ctx context.Context) (*kafkaMetadata, error) {
+	if k.meta != nil && time.Since(k.meta.fetchedAt) < kafkaMetadataTTL {
+		return k.meta, nil
+	}
+
+	var e kafkaEncoder
+	e.int32(1)
+	e.string(k.cfg.Topic)
+	e.bool(false) // allow_auto_topic_creation
+
+	var lastErr error
+	for _, addr := range k.cfg.Brokers {
+		resp, err := k.roundTrip(ctx, addr, apiMetadata, 4, e.b)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		meta, err := k.parseMetadata(resp)
+		if err != nil {
+			return nil, err
+		}
+		k.meta = meta
+		return meta, nil
+	}
+	return nil, fmt.Errorf("kafka: metadata: %w", lastErr)
+}
+
+func (k *Kafka) parseMetadata(resp []byte) (*kafkaMetadata, error) {
+	d := kafkaDecoder{b: resp}
+	meta := &kafkaMetadata{brokers: make(map[int32]string), fetchedAt: time.Now()}
+	d.int32() // throttle time
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		id := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.string() // rack
+		meta.brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.string() // cluster id
+	d.int32()  // controller id
+
+	var topicErr error
+	leaders := make(map[int32]int32)
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		code := d.int16()
+		name := d.string()
+		d.int8() // is_internal
+		for j, m := 0, d.arrayLen(); j < m; j++ {
+			d.int16() // partition error; a missing leader is reported as -1
+			p := d.int32()
+			leaders[p] = d.int32()
+			for r, c := 0, d.arrayLen(); r < c; r++ {
+				d.int32() // replicas
+			}
+			for r, c := 0, d.arrayLen(); r < c; r++ {
+				d.int32() // isr
+			}
+		}
+		if name == k.cfg.Topic && code != 0 {
+			topicErr = fmt.Errorf("kafka: topic %s: %w", name, kafkaError(code))
+		}
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("kafka: metadata: %w", d.err)
+	}
+	if topicErr != nil {
+		return nil, topicErr
+	}
+	if len(leaders) == 0 {
+		return nil, fmt.Errorf("kafka: topic %s has no partitions", k.cfg.Topic)
+	}
+	meta.leaders = make([]int32, len(leaders))
+	for p := range meta.leaders {
+		leader, ok := leaders[int32(p)]
+		if !ok {
+			return nil, fmt.Errorf("kafka: topic %s: partition %d missing from metadata", k.cfg.Topic, p)
+		}
+		meta.leaders[p] = leader
+	}
+	return meta, nil
+}
+
+// roundTrip sends a request to addr over a cached connection, dropping the
+// connection on any transport error.
+func (k *Kafka) roundTrip(ctx context.Context, addr string, apiKey, version int16, body []byte) ([]byte, error) {
+	c, err := k.conn(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.roundTrip(ctx, apiKey, version, body)
+	if err != nil {
+		_ = c.conn.Close()
+		delete(k.conns, addr)
+		return nil, fmt.Errorf("kafka %s: %w", addr, err)
+	}
+	return resp, nil
+}
+
+func (k *Kafka) conn(ctx context.Context, addr string) (*kafkaConn, error) {
+	if c, ok := k.conns[addr]; ok {
+		return c, nil
+	}
+	var nc net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if k.cfg.TLS {
+		host, _, _ := net.SplitHostPort(addr)
+		td := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host}}
+		nc, err = td.DialContext(ctx, "tcp", addr)
+	} else {
+		nc, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("kafka %s: %w", addr, err)
+	}
+	c := &kafkaConn{conn: nc, r: bufio.NewReader(nc)}
+	if k.cfg.Username != "" {
+		if err := c.saslPlain(ctx, k.cfg.Username, k.cfg.Password); err != nil {
+			_ = nc.Close()
+			return nil, fmt.Errorf("kafka %s: %w", addr, err)
+		}
+	}
+	k.conns[addr] = c
+	return c, nil
+}
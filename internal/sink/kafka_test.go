@@ -0,0 +1,340 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+func TestMurmur2MatchesJavaClient(t *testing.T) {
+	cases := map[string]int32{
+		"21":                       -973932308,
+		"foobar":                   -790332482,
+		"a-little-bit-long-string": -985981536,
+		"abc":                      479470107,
+	}
+	for in, want := range cases {
+		if got := int32(murmur2([]byte(in))); got != want {
+			t.Errorf("murmur2(%q) = %d, want %d", in, got, want)
+		}
+	}
+}
+
+// fakeBroker answers Metadata v4 and Produce v3 for a two-partition topic
+// and records the decoded messages by partition.
+type fakeBroker struct {
+	ln    net.Listener
+	topic string
+
+	mu       sync.Mutex
+	received map[int32][]kafkaRecord
+	// noLeader lists partitions reported with leader -1.
+	noLeader map[int32]bool
+	// failures counts produce requests to refuse per partition.
+	failures map[int32]int
+}
+
+func newFakeBroker(t *testing.T, topic string) *fakeBroker {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBroker{ln: ln, topic: topic, received: make(map[int32][]kafkaRecord),
+		noLeader: make(map[int32]bool), failures: make(map[int32]int)}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(t, c)
+		}
+	}()
+	return b
+}
+
+func (b *fakeBroker) serve(t *testing.T, c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(r, req); err != nil {
+			return
+		}
+		d := kafkaDecoder{b: req}
+		apiKey, version, corr := d.int16(), d.int16(), d.int32()
+		d.string() // client id
+
+		var e kafkaEncoder
+		e.int32(0)
+		e.int32(corr)
+		switch {
+		case apiKey == apiMetadata && version == 4:
+			host, port, _ := net.SplitHostPort(b.ln.Addr().String())
+			p, _ := strconv.Atoi(port)
+			e.int32(0) // throttle
+			e.int32(1)
+			e.int32(7) // node id
+			e.string(host)
+			e.int32(int32(p))
+			e.nullString() // rack
+			e.nullString() // cluster id
+			e.int32(7)     // controller
+			e.int32(1)
+			e.int16(0)
+			e.string(b.topic)
+			e.bool(false)
+			e.int32(2)
+			for part := int32(0); part < 2; part++ {
+				leader := int32(7)
+				b.mu.Lock()
+				if b.noLeader[part] {
+					leader = -1
+				}
+				b.mu.Unlock()
+				e.int16(0)
+				e.int32(part)
+				e.int32(leader)
+				e.int32(1)
+				e.int32(7)
+				e.int32(1)
+				e.int32(7)
+			}
+		case apiKey == apiProduce && version == 3:
+			d.string() // transactional id
+			if acks := d.int16(); acks != -1 {
+				t.Errorf("acks = %d, want -1", acks)
+			}
+			d.int32()
+			type result struct {
+				part int32
+				code int16
+			}
+			var results []result
+			for i, n := 0, d.arrayLen(); i < n; i++ {
+				if topic := d.string(); topic != b.topic {
+					t.Errorf("topic = %q", topic)
+				}
+				for j, m := 0, d.arrayLen(); j < m; j++ {
+					part := d.int32()
+					batch := d.bytes()
+					b.mu.Lock()
+					fail := b.failures[part] > 0
+					if fail {
+						b.failures[part]--
+					}
+					b.mu.Unlock()
+					if fail {
+						results = append(results, result{part, 6}) // NOT_LEADER_OR_FOLLOWER
+						continue
+					}
+					b.record(t, part, batch)
+					results = append(results, result{part, 0})
+				}
+			}
+			e.int32(1)
+			e.string(b.topic)
+			e.int32(int32(len(results)))
+			for _, res := range results {
+				e.int32(res.part)
+				e.int16(res.code)
+				e.int64(0)
+				e.int64(-1)
+			}
+			e.int32(0) // throttle
+		default:
+			t.Errorf("unexpected api %d v%d", apiKey, version)
+			return
+		}
+		binary.BigEndian.PutUint32(e.b, uint32(len(e.b)-4))
+		if _, err := c.Write(e.b); err != nil {
+			return
+		}
+	}
+}
+
+// record decodes a v2 record batch, checking its CRC.
+func (b *fakeBroker) record(t *testing.T, part int32, batch []byte) {
+	d := kafkaDecoder{b: batch}
+	d.int64() // base offset
+	if n := d.int32(); int(n) != len(d.b) {
+		t.Errorf("batch length = %d, have %d", n, len(d.b))
+	}
+	d.int32()
+	if magic := d.int8(); magic != 2 {
+		t.Errorf("magic = %d", magic)
+	}
+	crc := uint32(d.int32())
+	if got := crc32.Checksum(d.b, crc32.MakeTable(crc32.Castagnoli)); got != crc {
+		t.Errorf("crc = %x, want %x", crc, got)
+	}
+	d.int16()
+	d.int32()
+	base := d.int64()
+	d.int64()
+	d.int64()
+	d.int16()
+	d.int32()
+	count := int(d.int32())
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := 0; i < count; i++ {
+		d.varint() // length
+		d.int8()
+		ts := d.varint()
+		d.varint()
+		key := d.varbytes()
+		value := d.varbytes()
+		d.varint()
+		b.received[part] = append(b.received[part], kafkaRecord{key: key, value: value, time: time.UnixMilli(base + ts)})
+	}
+	if d.err != nil {
+		t.Errorf("decode batch: %v", d.err)
+	}
+}
+
+func TestKafkaSinkPublishesKeyedJSON(t *testing.T) {
+	broker := newFakeBroker(t, "prismcat-logs")
+	k, err := NewKafka(config.KafkaSinkConfig{Brokers: []string{broker.ln.Addr().String()}, Topic: "prismcat-logs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+
+	created := time.UnixMilli(1700000000000)
+	var logs []*storage.RequestLog
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		logs = append(logs, &storage.RequestLog{ID: id, CreatedAt: created, Upstream: "openai", StatusCode: 200})
+	}
+	if err := k.Write(context.Background(), logs); err != nil {
+		t.Fatal(err)
+	}
+
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	total := 0
+	for part, records := range broker.received {
+		for _, r := range records {
+			total++
+			if want := partitionFor(r.key, 2); want != part {
+				t.Errorf("key %s on partition %d, want %d", r.key, part, want)
+			}
+			var got storage.RequestLog
+			if err := json.Unmarshal(r.value, &got); err != nil || got.ID != string(r.key) {
+				t.Errorf("value for key %s = %s (%v)", r.key, r.value, err)
+			}
+			if !r.time.Equal(created) {
+				t.Errorf("timestamp = %v, want %v", r.time, created)
+			}
+		}
+	}
+	if total != len(logs) {
+		t.Fatalf("received %d records, want %d", total, len(logs))
+	}
+}
+
+func TestKafkaSinkRetriesOnlyFailedPartitions(t *testing.T) {
+	broker := newFakeBroker(t, "prismcat-logs")
+	k, err := NewKafka(config.KafkaSinkConfig{Brokers: []string{broker.ln.Addr().String()}, Topic: "prismcat-logs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+
+	var logs []*storage.RequestLog
+	for _, id := range []string{"a", "b", "c", "d", "e", "f"} {
+		logs = append(logs, &storage.RequestLog{ID: id, StatusCode: 200})
+	}
+	logs = append(logs, &storage.RequestLog{ID: "huge", StatusCode: 200, ResponseBody: strings.Repeat("x", kafkaMaxRequestBytes)})
+
+	// Partition 0 refuses the first produce, partition 1 has no leader.
+	broker.mu.Lock()
+	broker.failures[0] = 1
+	broker.noLeader[1] = true
+	broker.mu.Unlock()
+	if err := k.Write(context.Background(), logs); err == nil {
+		t.Fatal("write succeeded with failing partitions")
+	}
+	broker.mu.Lock()
+	if n := len(broker.received[0]) + len(broker.received[1]); n != 0 {
+		t.Errorf("received %d records from a failed write", n)
+	}
+	broker.noLeader[1] = false
+	broker.mu.Unlock()
+
+	// The retry delivers every log once; the oversized one is dropped.
+	if err := k.Write(context.Background(), logs); err != nil {
+		t.Fatal(err)
+	}
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	seen := make(map[string]int)
+	for _, records := range broker.received {
+		for _, r := range records {
+			seen[string(r.key)]++
+		}
+	}
+	for _, l := range logs[:6] {
+		if seen[l.ID] != 1 {
+			t.Errorf("log %s delivered %d times", l.ID, seen[l.ID])
+		}
+	}
+	if seen["huge"] != 0 {
+		t.Error("oversized log was produced")
+	}
+}
+
+func TestKafkaSinkSkipsAcknowledgedPartitionsOnRetry(t *testing.T) {
+	broker := newFakeBroker(t, "prismcat-logs")
+	k, err := NewKafka(config.KafkaSinkConfig{Brokers: []string{broker.ln.Addr().String()}, Topic: "prismcat-logs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+
+	var logs []*storage.RequestLog
+	for _, id := range []string{"a", "b", "c", "d", "e", "f"} {
+		logs = append(logs, &storage.RequestLog{ID: id, StatusCode: 200})
+	}
+	broker.mu.Lock()
+	broker.failures[1] = 1
+	broker.mu.Unlock()
+	if err := k.Write(context.Background(), logs); err == nil {
+		t.Fatal("write succeeded with a failing partition")
+	}
+	broker.mu.Lock()
+	first := len(broker.received[0])
+	broker.mu.Unlock()
+	if first == 0 {
+		t.Fatal("partition 0 received nothing")
+	}
+	if err := k.Write(context.Background(), logs); err != nil {
+		t.Fatal(err)
+	}
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	if n := len(broker.received[0]); n != first {
+		t.Errorf("partition 0 received %d records after the retry, want %d", n, first)
+	}
+	if n := len(broker.received[0]) + len(broker.received[1]); n != len(logs) {
+		t.Errorf("received %d records, want %d", n, len(logs))
+	}
+}
@@ -0,0 +1,358 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"time"
+)
+
+// Kafka protocol subset: request header v1, Metadata v4, Produce v3 with
+// record batch v2, SaslHandshake v1 and SaslAuthenticate v0. These are
+// supported by brokers from 1.0 through 4.x.
+const (
+	apiProduce          int16 = 0
+	apiMetadata         int16 = 3
+	apiSaslHandshake    int16 = 17
+	apiSaslAuthenticate int16 = 36
+
+	kafkaClientID = "prismcat"
+)
+
+var kafkaErrorNames = map[int16]string{
+	3:  "UNKNOWN_TOPIC_OR_PARTITION",
+	5:  "LEADER_NOT_AVAILABLE",
+	6:  "NOT_LEADER_OR_FOLLOWER",
+	7:  "REQUEST_TIMED_OUT",
+	10: "MESSAGE_TOO_LARGE",
+	29: "TOPIC_AUTHORIZATION_FAILED",
+	33: "UNSUPPORTED_SASL_MECHANISM",
+	58: "SASL_AUTHENTICATION_FAILED",
+}
+
+func kafkaError(code int16) error {
+	if name, ok := kafkaErrorNames[code]; ok {
+		return fmt.Errorf("kafka error %d (%s)", code, name)
+	}
+	return fmt.Errorf("kafka error %d", code)
+}
+
+// kafkaEncoder appends big-endian protocol primitives.
+type kafkaEncoder struct {
+	b []byte
+}
+
+func (e *kafkaEncoder) int8(v int8)   { e.b = append(e.b, byte(v)) }
+func (e *kafkaEncoder) int16(v int16) { e.b = binary.BigEndian.AppendUint16(e.b, uint16(v)) }
+func (e *kafkaEncoder) int32(v int32) { e.b = binary.BigEndian.AppendUint32(e.b, uint32(v)) }
+func (e *kafkaEncoder) int64(v int64) { e.b = binary.BigEndian.AppendUint64(e.b, uint64(v)) }
+func (e *kafkaEncoder) bool(v bool) {
+	if v {
+		e.int8(1)
+	} else {
+		e.int8(0)
+	}
+}
+
+func (e *kafkaEncoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.b = append(e.b, s...)
+}
+
+func (e *kafkaEncoder) nullString() { e.int16(-1) }
+
+func (e *kafkaEncoder) bytes(b []byte) {
+	e.int32(int32(len(b)))
+	e.b = append(e.b, b...)
+}
+
+// varint and varbytes are the zigzag encodings used inside records.
+func (e *kafkaEncoder) varint(v int64) { e.b = binary.AppendVarint(e.b, v) }
+
+func (e *kafkaEncoder) varbytes(b []byte) {
+	if b == nil {
+		e.varint(-1)
+		return
+	}
+	e.varint(int64(len(b)))
+	e.b = append(e.b, b...)
+}
+
+// kafkaDecoder reads protocol primitives; the first short read sticks in err.
+type kafkaDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *kafkaDecoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.b) {
+		d.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	out := d.b[:n]
+	d.b = d.b[n:]
+	return out
+}
+
+func (d *kafkaDecoder) int8() int8 {
+	if b := d.take(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int16() int16 {
+	if b := d.take(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int32() int32 {
+	if b := d.take(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int64() int64 {
+	if b := d.take(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.take(int(n)))
+}
+
+func (d *kafkaDecoder) bytes() []byte {
+	n := d.int32()
+	if n < 0 {
+		return nil
+	}
+	return d.take(int(n))
+}
+
+// arrayLen reads an array length, treating null as empty.
+func (d *kafkaDecoder) arrayLen() int {
+	n := d.int32()
+	if n < 0 {
+		return 0
+	}
+	if int(n) > len(d.b) {
+		d.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	return int(n)
+}
+
+func (d *kafkaDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *kafkaDecoder) varbytes() []byte {
+	n := d.varint()
+	if n < 0 {
+		return nil
+	}
+	return d.take(int(n))
+}
+
+type kafkaRecord struct {
+	key   []byte
+	value []byte
+	time  time.Time
+}
+
+// size estimates the record's share of a produce request.
+func (r kafkaRecord) size() int {
+	return len(r.key) + len(r.value) + 32
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// encodeRecordBatch builds an uncompressed v2 record batch.
+func encodeRecordBatch(records []kafkaRecord) []byte {
+	base := records[0].time.UnixMilli()
+	maxTS := base
+	var body kafkaEncoder
+	for i, r := range records {
+		ts := r.time.UnixMilli()
+		if ts > maxTS {
+			maxTS = ts
+		}
+		var rec kafkaEncoder
+		rec.int8(0) // attributes
+		rec.varint(ts - base)
+		rec.varint(int64(i))
+		rec.varbytes(r.key)
+		rec.varbytes(r.value)
+		rec.varint(0) // headers
+		body.varint(int64(len(rec.b)))
+		body.b = append(body.b, rec.b...)
+	}
+
+	// Everything from attributes on is covered by the CRC.
+	var crcPart kafkaEncoder
+	crcPart.int16(0) // attributes: no compression, create time
+	crcPart.int32(int32(len(records) - 1))
+	crcPart.int64(base)
+	crcPart.int64(maxTS)
+	crcPart.int64(-1) // producer id
+	crcPart.int16(-1) // producer epoch
+	crcPart.int32(-1) // base sequence
+	crcPart.int32(int32(len(records)))
+	crcPart.b = append(crcPart.b, body.b...)
+
+	var batch kafkaEncoder
+	batch.int64(0)                                 // base offset
+	batch.int32(int32(4 + 1 + 4 + len(crcPart.b))) // length after this field
+	batch.int32(-1)                                // partition leader epoch
+	batch.int8(2)                                  // magic
+	batch.int32(int32(crc32.Checksum(crcPart.b, castagnoli)))
+	batch.b = append(batch.b, crcPart.b...)
+	return batch.b
+}
+
+// murmur2 matches the Java client's default partitioner, so keyed messages
+// land on the same partitions as they would from other producers.
+func murmur2(data []byte) uint32 {
+	const (
+		seed = 0x9747b28c
+		m    = 0x5bd1e995
+		r    = 24
+	)
+	length := len(data)
+	h := uint32(seed) ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+	tail := data[length&^3:]
+	switch len(tail) {
+	case 3:
+		h ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(tail[0])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return h
+}
+
+func partitionFor(key []byte, partitions int) int32 {
+	return int32((murmur2(key) & 0x7fffffff) % uint32(partitions))
+}
+
+// kafkaConn is one broker connection. Requests are sent one at a time.
+type kafkaConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	corr int32
+}
+
+// roundTrip sends one request and returns the response body after the
+// correlation id.
+func (c *kafkaConn) roundTrip(ctx context.Context, apiKey, version int16, body []byte) ([]byte, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = c.conn.SetDeadline(deadline)
+	} else {
+		_ = c.conn.SetDeadline(time.Time{})
+	}
+	c.corr++
+
+	var e kafkaEncoder
+	e.int32(0) // size, patched below
+	e.int16(apiKey)
+	e.int16(version)
+	e.int32(c.corr)
+	e.string(kafkaClientID)
+	e.b = append(e.b, body...)
+	binary.BigEndian.PutUint32(e.b, uint32(len(e.b)-4))
+	if _, err := c.conn.Write(e.b); err != nil {
+		return nil, err
+	}
+
+	var size [4]byte
+	if _, err := io.ReadFull(c.r, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n < 4 || n > 64<<20 {
+		return nil, fmt.Errorf("kafka: bad response size %d", n)
+	}
+	resp := make([]byte, n)
+	if _, err := io.ReadFull(c.r, resp); err != nil {
+		return nil, err
+	}
+	if corr := int32(binary.BigEndian.Uint32(resp)); corr != c.corr {
+		return nil, fmt.Errorf("kafka: correlation id %d, want %d", corr, c.corr)
+	}
+	return resp[4:], nil
+}
+
+// saslPlain authenticates with SASL/PLAIN.
+func (c *kafkaConn) saslPlain(ctx context.Context, user, pass string) error {
+	var e kafkaEncoder
+	e.string("PLAIN")
+	resp, err := c.roundTrip(ctx, apiSaslHandshake, 1, e.b)
+	if err != nil {
+		return err
+	}
+	d := kafkaDecoder{b: resp}
+	if code := d.int16(); code != 0 {
+		return fmt.Errorf("sasl handshake: %w", kafkaError(code))
+	}
+
+	e = kafkaEncoder{}
+	e.bytes([]byte("\x00" + user + "\x00" + pass))
+	resp, err = c.roundTrip(ctx, apiSaslAuthenticate, 0, e.b)
+	if err != nil {
+		return err
+	}
+	d = kafkaDecoder{b: resp}
+	code := d.int16()
+	msg := d.string()
+	if d.err != nil {
+		return d.err
+	}
+	if code != 0 {
+		if msg != "" {
+			return errors.New("sasl authenticate: " + msg)
+		}
+		return fmt.Errorf("sasl authenticate: %w", kafkaError(code))
+	}
+	return nil
+}
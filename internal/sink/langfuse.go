@@ -0,0 +1,174 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+// Langfuse exports LLM calls to the Langfuse ingestion API as one trace with
+// one generation each. Event and object IDs derive from the log ID, so a
+// retried batch updates rather than duplicates.
+type Langfuse struct {
+	cfg    config.LangfuseSinkConfig
+	blobs  storage.BlobStore
+	client *http.Client
+}
+
+type langfuseEvent struct {
+	ID        string                 `json:"id"`
+	Type      string                 `json:"type"`
+	Timestamp string                 `json:"timestamp"`
+	Body      map[string]interface{} `json:"body"`
+}
+
+// NewLangfuse creates a Langfuse exporter. blobs is used to read detached
+// bodies and may be nil.
+func NewLangfuse(cfg config.LangfuseSinkConfig, blobs storage.BlobStore) (*Langfuse, error) {
+	if cfg.Host == "" {
+		cfg.Host = "https://cloud.langfuse.com"
+	}
+	if u, err := url.Parse(cfg.Host); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("sinks.langfuse.host: want an http(s) URL, got %q", cfg.Host)
+	}
+	if cfg.PublicKey == "" || cfg.SecretKey == "" {
+		return nil, errors.New("sinks.langfuse: public_key and secret_key are required")
+	}
+	cfg.Host = strings.TrimRight(cfg.Host, "/")
+	return &Langfuse{cfg: cfg, blobs: blobs, client: &http.Client{Timeout: writeTimeout}}, nil
+}
+
+func (f *Langfuse) Name() string { return "langfuse" }
+
+func (f *Langfuse) Write(ctx context.Context, logs []*storage.RequestLog) error {
+	var batch []langfuseEvent
+	for _, l := range logs {
+		if c, ok := decodeLLMCall(ctx, f.blobs, l); ok {
+			batch = append(batch, f.events(c)...)
+		}
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(map[string]interface{}{"batch": batch})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.cfg.Host+"/api/public/ingestion", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(f.cfg.PublicKey, f.cfg.SecretKey)
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusMultiStatus {
+		return fmt.Errorf("langfuse: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	// 207 reports per-event results.
+	var res struct {
+		Errors []struct {
+			ID      string `json:"id"`
+			Status  int    `json:"status"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if json.Unmarshal(body, &res) == nil && len(res.Errors) > 0 {
+		e := res.Errors[0]
+		return fmt.Errorf("langfuse: %d of %d events rejected, first %s: %d %s", len(res.Errors), len(batch), e.ID, e.Status, e.Message)
+	}
+	return nil
+}
+
+func (f *Langfuse) events(c *llmCall) []langfuseEvent {
+	l := c.log
+	ts := c.start.UTC().Format(time.RFC3339Nano)
+	input, _ := c.prompt()
+	var output interface{}
+	if c.hasOutput {
+		output = c.output.Text
+	}
+	metadata := map[string]interface{}{
+		"prismcat_log_id": l.ID,
+		"upstream":        l.Upstream,
+		"path":            l.Path,
+		"status_code":     l.StatusCode,
+		"streaming":       l.Streaming,
+	}
+	if l.Source != "" {
+		metadata["source"] = l.Source
+	}
+	if len(l.Properties) > 0 {
+		metadata["properties"] = l.Properties
+	}
+	for k, v := range l.Metadata {
+		if _, taken := metadata[k]; !taken {
+			metadata[k] = v
+		}
+	}
+	var tags []string
+	if l.Tag != "" {
+		tags = append(tags, l.Tag)
+	}
+
+	name := c.model
+	if name == "" {
+		name = l.Upstream + " " + l.Path
+	}
+	trace := map[string]interface{}{
+		"id":        l.ID,
+		"name":      name,
+		"timestamp": ts,
+		"input":     input,
+		"output":    output,
+		"metadata":  metadata,
+		"tags":      tags,
+	}
+
+	generation := map[string]interface{}{
+		"id":              l.ID + "-generation",
+		"traceId":         l.ID,
+		"name":            name,
+		"startTime":       ts,
+		"endTime":         c.end.UTC().Format(time.RFC3339Nano),
+		"model":           c.model,
+		"modelParameters": c.params(),
+		"input":           input,
+		"output":          output,
+		"metadata":        metadata,
+		"level":           "DEFAULT",
+	}
+	if c.hasUsage {
+		generation["usage"] = map[string]interface{}{
+			"input":  c.usage.InputTokens,
+			"output": c.usage.OutputTokens,
+			"total":  c.usage.TotalTokens,
+			"unit":   "TOKENS",
+		}
+	}
+	if c.failed() {
+		generation["level"] = "ERROR"
+		generation["statusMessage"] = c.errorMessage()
+	}
+
+	return []langfuseEvent{
+		{ID: l.ID + "-trace", Type: "trace-create", Timestamp: ts, Body: trace},
+		{ID: l.ID + "-generation", Type: "generation-create", Timestamp: ts, Body: generation},
+	}
+}
@@ -0,0 +1,165 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+// LangSmith exports LLM calls to LangSmith as root "llm" runs through the
+// batch ingestion endpoint.
+type LangSmith struct {
+	cfg    config.LangSmithSinkConfig
+	blobs  storage.BlobStore
+	client *http.Client
+}
+
+// NewLangSmith creates a LangSmith exporter. blobs is used to read detached
+// bodies and may be nil.
+func NewLangSmith(cfg config.LangSmithSinkConfig, blobs storage.BlobStore) (*LangSmith, error) {
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://api.smith.langchain.com"
+	}
+	if u, err := url.Parse(cfg.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("sinks.langsmith.endpoint: want an http(s) URL, got %q", cfg.Endpoint)
+	}
+	if cfg.APIKey == "" {
+		return nil, errors.New("sinks.langsmith: api_key is required")
+	}
+	if cfg.Project == "" {
+		cfg.Project = "default"
+	}
+	cfg.Endpoint = strings.TrimRight(cfg.Endpoint, "/")
+	return &LangSmith{cfg: cfg, blobs: blobs, client: &http.Client{Timeout: writeTimeout}}, nil
+}
+
+func (s *LangSmith) Name() string { return "langsmith" }
+
+func (s *LangSmith) Write(ctx context.Context, logs []*storage.RequestLog) error {
+	var runs []map[string]interface{}
+	for _, l := range logs {
+		if c, ok := decodeLLMCall(ctx, s.blobs, l); ok {
+			runs = append(runs, s.run(c))
+		}
+	}
+	if len(runs) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(map[string]interface{}{"post": runs})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.Endpoint+"/runs/batch", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", s.cfg.APIKey)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("langsmith: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (s *LangSmith) run(c *llmCall) map[string]interface{} {
+	l := c.log
+	id := runID(l.ID)
+
+	outputs := map[string]interface{}{}
+	if c.response != nil {
+		for k, v := range c.response {
+			outputs[k] = v
+		}
+	} else if c.hasOutput {
+		outputs["output"] = c.output.Text
+	}
+	if c.hasUsage {
+		outputs["usage_metadata"] = map[string]int{
+			"input_tokens":  c.usage.InputTokens,
+			"output_tokens": c.usage.OutputTokens,
+			"total_tokens":  c.usage.TotalTokens,
+		}
+	}
+
+	metadata := map[string]interface{}{
+		"ls_provider":     l.Upstream,
+		"ls_model_name":   c.model,
+		"prismcat_log_id": l.ID,
+		"path":            l.Path,
+		"status_code":     l.StatusCode,
+	}
+	if l.Source != "" {
+		metadata["source"] = l.Source
+	}
+	if len(l.Properties) > 0 {
+		metadata["properties"] = l.Properties
+	}
+	for k, v := range l.Metadata {
+		if _, taken := metadata[k]; !taken {
+			metadata[k] = v
+		}
+	}
+
+	name := c.model
+	if name == "" {
+		name = l.Upstream
+	}
+	run := map[string]interface{}{
+		"id":           id,
+		"trace_id":     id,
+		"dotted_order": dottedOrder(c.start, id),
+		"name":         name,
+		"run_type":     "llm",
+		"start_time":   c.start.UTC().Format(time.RFC3339Nano),
+		"end_time":     c.end.UTC().Format(time.RFC3339Nano),
+		"inputs":       c.request,
+		"outputs":      outputs,
+		"extra": map[string]interface{}{
+			"metadata":          metadata,
+			"invocation_params": c.params(),
+		},
+		"session_name": s.cfg.Project,
+	}
+	if l.Tag != "" {
+		run["tags"] = []string{l.Tag}
+	}
+	if c.failed() {
+		run["error"] = c.errorMessage()
+	}
+	return run
+}
+
+// runID returns the log ID when it is a UUID (as PrismCat generates) and a
+// stable name-based UUID otherwise; LangSmith requires UUID run IDs.
+func runID(logID string) string {
+	if id, err := uuid.Parse(logID); err == nil {
+		return id.String()
+	}
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte("prismcat:"+logID)).String()
+}
+
+// dottedOrder is the ordering key of a root run: its start time in
+// microseconds followed by its ID.
+func dottedOrder(start time.Time, id string) string {
+	t := start.UTC()
+	return fmt.Sprintf("%s%06dZ%s", t.Format("20060102T150405"), t.Nanosecond()/1000, id)
+}
@@ -0,0 +1,151 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/llm"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+// llmCall is a captured LLM request/response decoded for the observability
+// exporters (Langfuse, LangSmith).
+type llmCall struct {
+	log        *storage.RequestLog
+	start, end time.Time
+	model      string
+	request    map[string]interface{}
+	response   map[string]interface{} // nil when the body isn't JSON
+	output     llm.Output
+	hasOutput  bool
+	usage      llm.Usage
+	hasUsage   bool
+}
+
+// promptKeys are the request fields that carry the prompt across providers.
+var promptKeys = []string{"system", "instructions", "systemInstruction", "messages", "contents", "input", "prompt"}
+
+// paramKeys are scalar request fields reported as model parameters.
+var paramKeys = []string{"temperature", "top_p", "top_k", "max_tokens", "max_completion_tokens", "max_output_tokens", "stream", "seed", "reasoning_effort"}
+
+// decodeLLMCall returns false for logs that don't look like LLM calls
+// (embeddings, model listings, non-JSON traffic). Detached bodies are read
+// from blobs when available; l itself is not modified.
+func decodeLLMCall(ctx context.Context, blobs storage.BlobStore, l *storage.RequestLog) (*llmCall, bool) {
+	if strings.Contains(l.Path, "embed") || l.HasFlag(storage.FlagEmbeddingsSummarized) {
+		return nil, false
+	}
+	c := &llmCall{
+		log:   l,
+		start: l.CreatedAt,
+		end:   l.CreatedAt.Add(time.Duration(l.Latency) * time.Millisecond),
+	}
+
+	if err := json.Unmarshal([]byte(fullBody(ctx, blobs, l.RequestBody, l.RequestBodyRef)), &c.request); err != nil || c.request == nil {
+		return nil, false
+	}
+	if _, ok := c.prompt(); !ok {
+		return nil, false
+	}
+
+	respBody := l.ResponseBody
+	if !l.HasFlag(storage.FlagStreamMerged) {
+		respBody = fullBody(ctx, blobs, l.ResponseBody, l.ResponseBodyRef)
+		if l.Streaming {
+			events := llm.ParseStream(headerValue(l.ResponseHeaders, "Content-Type"), []byte(respBody))
+			if merged, _, ok := llm.MergeStream(events); ok {
+				c.response = merged
+			}
+		}
+	}
+	if c.response == nil {
+		_ = json.Unmarshal([]byte(respBody), &c.response)
+	}
+	if c.response != nil {
+		c.output, c.hasOutput = llm.ExtractOutput(c.response)
+		c.usage, c.hasUsage = llm.ExtractUsage(c.response)
+	}
+
+	c.model = llm.ExtractModel(c.request)
+	if c.model == "" && c.response != nil {
+		c.model = llm.ExtractModel(c.response)
+	}
+	if c.model == "" {
+		// Gemini names the model in the path: /v1beta/models/<model>:generateContent.
+		if _, rest, ok := strings.Cut(l.Path, "/models/"); ok {
+			c.model, _, _ = strings.Cut(rest, ":")
+		}
+	}
+	return c, true
+}
+
+// prompt returns the prompt fields of the request. A lone messages array is
+// returned as is, which the exporters render as a chat.
+func (c *llmCall) prompt() (interface{}, bool) {
+	fields := make(map[string]interface{})
+	for _, k := range promptKeys {
+		if v, ok := c.request[k]; ok {
+			fields[k] = v
+		}
+	}
+	if len(fields) == 0 {
+		return nil, false
+	}
+	if msgs, ok := fields["messages"]; ok && len(fields) == 1 {
+		return msgs, true
+	}
+	return fields, true
+}
+
+// params returns scalar model parameters from the request.
+func (c *llmCall) params() map[string]interface{} {
+	out := make(map[string]interface{})
+	for _, k := range paramKeys {
+		switch v := c.request[k].(type) {
+		case string, float64, bool:
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// failed reports whether the call errored upstream or at the proxy.
+func (c *llmCall) failed() bool {
+	return c.log.Error != "" || c.log.StatusCode >= 400
+}
+
+// errorMessage describes a failed call.
+func (c *llmCall) errorMessage() string {
+	if c.log.Error != "" {
+		return c.log.Error
+	}
+	if c.failed() {
+		return strconv.Itoa(c.log.StatusCode) + " " + http.StatusText(c.log.StatusCode)
+	}
+	return ""
+}
+
+func fullBody(ctx context.Context, blobs storage.BlobStore, body, ref string) string {
+	if ref == "" || blobs == nil {
+		return body
+	}
+	data, err := blobs.Get(ctx, ref)
+	if err != nil {
+		// Evicted since capture: fall back to the stored preview.
+		return body
+	}
+	return string(data)
+}
+
+func headerValue(headers map[string][]string, name string) string {
+	for k, vv := range headers {
+		if strings.EqualFold(k, name) && len(vv) > 0 {
+			return vv[0]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,99 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+func TestLangfuseExportsStreamedGeneration(t *testing.T) {
+	ctx := context.Background()
+	blobs, err := storage.NewFileBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := "data: {\"model\":\"gpt-4o\",\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\",\"content\":\"Hel\"}}]}\n\n" +
+		"data: {\"model\":\"gpt-4o\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"lo\"},\"finish_reason\":\"stop\"}]}\n\n" +
+		"data: {\"model\":\"gpt-4o\",\"choices\":[],\"usage\":{\"prompt_tokens\":7,\"completion_tokens\":2,\"total_tokens\":9}}\n\n" +
+		"data: [DONE]\n\n"
+	ref, err := blobs.Put(ctx, []byte(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got struct {
+		Batch []langfuseEvent `json:"batch"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); r.URL.Path != "/api/public/ingestion" || user != "pk" || pass != "sk" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(http.StatusMultiStatus)
+		_, _ = w.Write([]byte(`{"successes":[],"errors":[]}`))
+	}))
+	defer srv.Close()
+
+	f, err := NewLangfuse(config.LangfuseSinkConfig{Host: srv.URL, PublicKey: "pk", SecretKey: "sk"}, blobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs := []*storage.RequestLog{
+		{
+			ID:              "log-1",
+			CreatedAt:       time.Now(),
+			Upstream:        "openai",
+			Path:            "/v1/chat/completions",
+			RequestBody:     `{"model":"gpt-4o","stream":true,"temperature":0.2,"messages":[{"role":"user","content":"Hi"}]}`,
+			StatusCode:      200,
+			ResponseHeaders: map[string][]string{"Content-Type": {"text/event-stream"}},
+			ResponseBody:    "data: {\"model\"", // preview; the full stream is detached
+			ResponseBodyRef: ref,
+			Streaming:       true,
+			Latency:         1500,
+		},
+		// Not an LLM call: skipped.
+		{ID: "log-2", Path: "/v1/models", StatusCode: 200},
+	}
+	if err := f.Write(ctx, logs); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got.Batch) != 2 || got.Batch[1].Type != "generation-create" {
+		t.Fatalf("batch = %+v", got.Batch)
+	}
+	gen := got.Batch[1].Body
+	if gen["model"] != "gpt-4o" || gen["output"] != "Hello" || gen["traceId"] != "log-1" {
+		t.Fatalf("generation = %v", gen)
+	}
+	usage, _ := gen["usage"].(map[string]interface{})
+	if usage["input"] != 7.0 || usage["output"] != 2.0 || usage["total"] != 9.0 {
+		t.Fatalf("usage = %v", usage)
+	}
+	if params, _ := gen["modelParameters"].(map[string]interface{}); params["temperature"] != 0.2 {
+		t.Fatalf("modelParameters = %v", gen["modelParameters"])
+	}
+}
+
+func TestLangSmithRunIDs(t *testing.T) {
+	const id = "0b6f5c4e-8f0a-4a55-9d7c-2f3e1a9b8c7d"
+	if got := runID(id); got != id {
+		t.Fatalf("runID(uuid) = %s", got)
+	}
+	if a, b := runID("custom"), runID("custom"); a != b || len(a) != 36 {
+		t.Fatalf("runID(custom) = %s, %s", a, b)
+	}
+	start := time.Date(2024, 5, 1, 12, 0, 0, 123456789, time.UTC)
+	if got, want := dottedOrder(start, id), "20240501T120000123456Z"+id; got != want {
+		t.Fatalf("dottedOrder = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,240 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+// OTLP severity numbers.
+const (
+	otlpSeverityInfo  = 9
+	otlpSeverityWarn  = 13
+	otlpSeverityError = 17
+)
+
+// OTLP exports logs as OpenTelemetry log records using the OTLP/HTTP JSON
+// encoding.
+type OTLP struct {
+	cfg      config.OTLPSinkConfig
+	endpoint string
+	resource []otlpKeyValue
+	client   *http.Client
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+// otlpValue is an AnyValue; exactly one field is set. 64-bit integers are
+// strings in the JSON encoding.
+type otlpValue struct {
+	StringValue *string         `json:"stringValue,omitempty"`
+	IntValue    *string         `json:"intValue,omitempty"`
+	BoolValue   *bool           `json:"boolValue,omitempty"`
+	ArrayValue  *otlpArrayValue `json:"arrayValue,omitempty"`
+}
+
+type otlpArrayValue struct {
+	Values []otlpValue `json:"values"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano         string         `json:"timeUnixNano"`
+	ObservedTimeUnixNano string         `json:"observedTimeUnixNano"`
+	SeverityNumber       int            `json:"severityNumber"`
+	SeverityText         string         `json:"severityText"`
+	Body                 otlpValue      `json:"body"`
+	Attributes           []otlpKeyValue `json:"attributes"`
+	TraceID              string         `json:"traceId,omitempty"`
+	SpanID               string         `json:"spanId,omitempty"`
+}
+
+// NewOTLP creates an OTLP log exporter.
+func NewOTLP(cfg config.OTLPSinkConfig) (*OTLP, error) {
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("sinks.otlp.endpoint: want an http(s) URL, got %q", cfg.Endpoint)
+	}
+	endpoint := strings.TrimRight(cfg.Endpoint, "/")
+	if !strings.HasSuffix(endpoint, "/v1/logs") {
+		endpoint += "/v1/logs"
+	}
+	service := cfg.ServiceName
+	if service == "" {
+		service = "prismcat"
+	}
+	resource := []otlpKeyValue{
+		otlpString("service.name", service),
+		otlpString("service.version", config.Version),
+	}
+	if host, err := os.Hostname(); err == nil {
+		resource = append(resource, otlpString("host.name", host))
+	}
+	return &OTLP{cfg: cfg, endpoint: endpoint, resource: resource, client: &http.Client{Timeout: writeTimeout}}, nil
+}
+
+func (o *OTLP) Name() string { return "otlp" }
+
+func (o *OTLP) Write(ctx context.Context, logs []*storage.RequestLog) error {
+	records := make([]otlpLogRecord, 0, len(logs))
+	for _, l := range logs {
+		records = append(records, o.record(l))
+	}
+	payload := map[string]interface{}{
+		"resourceLogs": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{"attributes": o.resource},
+			"scopeLogs": []interface{}{map[string]interface{}{
+				"scope":      map[string]string{"name": "prismcat", "version": config.Version},
+				"logRecords": records,
+			}},
+		}},
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range o.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("otlp: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (o *OTLP) record(l *storage.RequestLog) otlpLogRecord {
+	rec := otlpLogRecord{
+		TimeUnixNano:         strconv.FormatInt(l.CreatedAt.UnixNano(), 10),
+		ObservedTimeUnixNano: strconv.FormatInt(time.Now().UnixNano(), 10),
+		SeverityNumber:       otlpSeverityInfo,
+		SeverityText:         "INFO",
+		Body:                 otlpStringValue(fmt.Sprintf("%s %s %d", l.Method, l.Path, l.StatusCode)),
+	}
+	switch {
+	case l.Error != "" || l.StatusCode >= 500:
+		rec.SeverityNumber, rec.SeverityText = otlpSeverityError, "ERROR"
+	case l.StatusCode >= 400:
+		rec.SeverityNumber, rec.SeverityText = otlpSeverityWarn, "WARN"
+	}
+	rec.TraceID, rec.SpanID = parseTraceparent(l.RequestHeaders)
+
+	attrs := []otlpKeyValue{
+		otlpString("prismcat.log_id", l.ID),
+		otlpString("prismcat.upstream", l.Upstream),
+		otlpString("http.request.method", l.Method),
+		otlpString("url.path", l.Path),
+		otlpInt("http.response.status_code", int64(l.StatusCode)),
+		otlpInt("prismcat.latency_ms", l.Latency),
+		otlpInt("http.request.body.size", l.RequestBodySize),
+		otlpInt("http.response.body.size", l.ResponseBodySize),
+		otlpBool("prismcat.streaming", l.Streaming),
+	}
+	optional := []struct{ key, val string }{
+		{"url.query", l.Query},
+		{"url.full", l.TargetURL},
+		{"error.message", l.Error},
+		{"client.address", l.ClientIP},
+		{"user_agent.original", l.UserAgent},
+		{"prismcat.tag", l.Tag},
+		{"prismcat.variant", l.Variant},
+		{"prismcat.source", l.Source},
+		{"prismcat.request.body_ref", l.RequestBodyRef},
+		{"prismcat.response.body_ref", l.ResponseBodyRef},
+	}
+	if o.cfg.Bodies {
+		optional = append(optional,
+			struct{ key, val string }{"prismcat.request.body", l.RequestBody},
+			struct{ key, val string }{"prismcat.response.body", l.ResponseBody},
+		)
+	}
+	for _, a := range optional {
+		if a.val != "" {
+			attrs = append(attrs, otlpString(a.key, a.val))
+		}
+	}
+	if len(l.Flags) > 0 {
+		values := make([]otlpValue, 0, len(l.Flags))
+		for _, f := range l.Flags {
+			values = append(values, otlpStringValue(f))
+		}
+		attrs = append(attrs, otlpKeyValue{Key: "prismcat.flags", Value: otlpValue{ArrayValue: &otlpArrayValue{Values: values}}})
+	}
+	attrs = appendOTLPMap(attrs, "prismcat.property.", l.Properties)
+	attrs = appendOTLPMap(attrs, "prismcat.metadata.", l.Metadata)
+	rec.Attributes = attrs
+	return rec
+}
+
+// appendOTLPMap adds one string attribute per key, in key order.
+func appendOTLPMap(attrs []otlpKeyValue, prefix string, m map[string]string) []otlpKeyValue {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		attrs = append(attrs, otlpString(prefix+k, m[k]))
+	}
+	return attrs
+}
+
+// parseTraceparent extracts the trace and parent span IDs from a W3C
+// traceparent header ("00-<32 hex>-<16 hex>-<2 hex>").
+func parseTraceparent(headers map[string][]string) (traceID, spanID string) {
+	parts := strings.Split(strings.TrimSpace(headerValue(headers, "traceparent")), "-")
+	if len(parts) < 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return "", ""
+	}
+	if _, err := hex.DecodeString(parts[1] + parts[2]); err != nil {
+		return "", ""
+	}
+	// All-zero IDs are invalid.
+	if strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return "", ""
+	}
+	return strings.ToLower(parts[1]), strings.ToLower(parts[2])
+}
+
+func otlpStringValue(s string) otlpValue {
+	return otlpValue{StringValue: &s}
+}
+
+func otlpString(key, val string) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpStringValue(val)}
+}
+
+func otlpInt(key string, val int64) otlpKeyValue {
+	s := strconv.FormatInt(val, 10)
+	return otlpKeyValue{Key: key, Value: otlpValue{IntValue: &s}}
+}
+
+func otlpBool(key string, val bool) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpValue{BoolValue: &val}}
+}
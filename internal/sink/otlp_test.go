@@ -0,0 +1,68 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+func TestOTLPSinkExportsCorrelatedLogRecords(t *testing.T) {
+	var got struct {
+		ResourceLogs []struct {
+			ScopeLogs []struct {
+				LogRecords []otlpLogRecord `json:"logRecords"`
+			} `json:"scopeLogs"`
+		} `json:"resourceLogs"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/logs" || r.Header.Get("Authorization") != "Bearer t" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+
+	o, err := NewOTLP(config.OTLPSinkConfig{Endpoint: srv.URL, Headers: map[string]string{"Authorization": "Bearer t"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = o.Write(context.Background(), []*storage.RequestLog{{
+		ID:             "log-1",
+		CreatedAt:      time.Unix(1700000000, 0),
+		Method:         "POST",
+		Path:           "/v1/chat/completions",
+		StatusCode:     502,
+		RequestHeaders: map[string][]string{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}},
+		Flags:          []string{"schema_invalid"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := got.ResourceLogs[0].ScopeLogs[0].LogRecords[0]
+	if rec.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || rec.SpanID != "00f067aa0ba902b7" {
+		t.Fatalf("trace = %s/%s", rec.TraceID, rec.SpanID)
+	}
+	if rec.SeverityText != "ERROR" || rec.TimeUnixNano != "1700000000000000000" {
+		t.Fatalf("record = %+v", rec)
+	}
+	attrs := make(map[string]otlpValue)
+	for _, a := range rec.Attributes {
+		attrs[a.Key] = a.Value
+	}
+	if v := attrs["http.response.status_code"].IntValue; v == nil || *v != "502" {
+		t.Fatalf("status attribute = %v", v)
+	}
+	if v := attrs["prismcat.flags"].ArrayValue; v == nil || len(v.Values) != 1 {
+		t.Fatalf("flags attribute = %v", v)
+	}
+}
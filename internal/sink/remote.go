@@ -0,0 +1,114 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+// Remote ships logs to a central PrismCat instance through its /api/ingest
+// endpoint, so several local proxies can feed one team dashboard. Bodies the
+// central instance reports missing are uploaded from the local blob store.
+type Remote struct {
+	baseURL  string
+	password string
+	source   string
+	blobs    storage.BlobStore
+	client   *http.Client
+}
+
+// NewRemote creates a remote sink. blobs may be nil when bodies are never
+// detached.
+func NewRemote(cfg config.RemoteSinkConfig, blobs storage.BlobStore) (*Remote, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("sinks.remote.url: want an http(s) URL, got %q", cfg.URL)
+	}
+	source := cfg.Source
+	if source == "" {
+		source, _ = os.Hostname()
+	}
+	return &Remote{
+		baseURL:  strings.TrimRight(cfg.URL, "/"),
+		password: cfg.Password,
+		source:   source,
+		blobs:    blobs,
+		client:   &http.Client{Timeout: writeTimeout},
+	}, nil
+}
+
+func (r *Remote) Name() string { return "remote" }
+
+func (r *Remote) Write(ctx context.Context, logs []*storage.RequestLog) error {
+	payload, err := json.Marshal(map[string]interface{}{
+		"source": r.source,
+		"logs":   logs,
+	})
+	if err != nil {
+		return err
+	}
+	var res struct {
+		Accepted     int      `json:"accepted"`
+		MissingBlobs []string `json:"missing_blobs"`
+	}
+	if err := r.do(ctx, http.MethodPost, "/api/ingest", "application/json", payload, &res); err != nil {
+		return err
+	}
+
+	for _, ref := range res.MissingBlobs {
+		if err := r.uploadBlob(ctx, ref); err != nil {
+			return fmt.Errorf("upload blob %s: %w", ref, err)
+		}
+	}
+	return nil
+}
+
+func (r *Remote) uploadBlob(ctx context.Context, ref string) error {
+	if r.blobs == nil {
+		return nil
+	}
+	data, err := r.blobs.Get(ctx, ref)
+	if errors.Is(err, storage.ErrBlobNotFound) {
+		// Evicted or collected locally since the log was written.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return r.do(ctx, http.MethodPut, "/api/blobs/"+url.PathEscape(ref), "application/octet-stream", data, nil)
+}
+
+func (r *Remote) do(ctx context.Context, method, path, contentType string, body []byte, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, r.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if r.password != "" {
+		req.SetBasicAuth("prismcat", r.password)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,239 @@
+// Package sink ships finalized request logs to external destinations after
+// they have been stored locally.
+package sink
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+const (
+	defaultBatchSize     = 100
+	defaultFlushInterval = 2 * time.Second
+	defaultBuffer        = 1024
+
+	writeTimeout = 30 * time.Second
+	maxAttempts  = 3
+	closeTimeout = 5 * time.Second
+)
+
+// Sink receives finalized logs in batches. Implementations must not modify
+// the logs; the same entries are handed to every sink. Write is never called
+// concurrently for one sink. Sinks that also implement io.Closer are closed
+// after their last batch.
+type Sink interface {
+	Name() string
+	Write(ctx context.Context, logs []*storage.RequestLog) error
+}
+
+// Options controls batching for one sink.
+type Options struct {
+	BatchSize     int
+	FlushInterval time.Duration
+}
+
+// NewOptions converts a sink's batch_size / flush_interval_ms settings.
+func NewOptions(batchSize, flushIntervalMs int) Options {
+	return Options{
+		BatchSize:     batchSize,
+		FlushInterval: time.Duration(flushIntervalMs) * time.Millisecond,
+	}
+}
+
+// Stats counts what a sink has shipped and lost.
+type Stats struct {
+	Name    string `json:"name"`
+	Sent    uint64 `json:"sent"`
+	Failed  uint64 `json:"failed"`  // logs in batches that failed every attempt
+	Dropped uint64 `json:"dropped"` // logs dropped because the sink fell behind
+}
+
+// Repository wraps a Repository and forwards every finalized log to the
+// attached sinks once the inner SaveLog succeeds.
+//
+// Place it between DetachingRepository and AsyncRepository: detached body
+// refs are then already set and shipping never blocks the proxy path.
+type Repository struct {
+	storage.Repository
+
+	mu         sync.Mutex
+	dispatches []*dispatcher
+}
+
+// NewRepository creates a wrapper with no sinks attached.
+func NewRepository(inner storage.Repository) *Repository {
+	return &Repository{Repository: inner}
+}
+
+// Add attaches a sink. Call it before logs start flowing.
+func (r *Repository) Add(s Sink, opts Options) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dispatches = append(r.dispatches, newDispatcher(s, opts))
+}
+
+// Stats returns per-sink counters.
+func (r *Repository) Stats() []Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]Stats, 0, len(r.dispatches))
+	for _, d := range r.dispatches {
+		out = append(out, Stats{
+			Name:    d.sink.Name(),
+			Sent:    d.sent.Load(),
+			Failed:  d.failed.Load(),
+			Dropped: d.dropped.Load(),
+		})
+	}
+	return out
+}
+
+func (r *Repository) SaveLog(ctx context.Context, entry *storage.RequestLog) error {
+	if err := r.Repository.SaveLog(ctx, entry); err != nil {
+		return err
+	}
+	// The initial in-flight snapshot has neither a status nor an error yet.
+	if entry == nil || (entry.StatusCode == 0 && entry.Error == "") {
+		return nil
+	}
+	r.mu.Lock()
+	for _, d := range r.dispatches {
+		d.enqueue(entry)
+	}
+	r.mu.Unlock()
+	return nil
+}
+
+// Close flushes pending batches (bounded by a short timeout) and closes the
+// inner repository.
+func (r *Repository) Close() error {
+	r.mu.Lock()
+	dispatches := r.dispatches
+	r.dispatches = nil
+	r.mu.Unlock()
+	for _, d := range dispatches {
+		d.close()
+	}
+	return r.Repository.Close()
+}
+
+// dispatcher batches logs for one sink on its own goroutine, so a slow sink
+// only sheds its own backlog.
+type dispatcher struct {
+	sink      Sink
+	batchSize int
+	interval  time.Duration
+
+	ch   chan *storage.RequestLog
+	done chan struct{}
+	stop context.CancelFunc
+	ctx  context.Context
+
+	sent    atomic.Uint64
+	failed  atomic.Uint64
+	dropped atomic.Uint64
+}
+
+func newDispatcher(s Sink, opts Options) *dispatcher {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = defaultFlushInterval
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &dispatcher{
+		sink:      s,
+		batchSize: opts.BatchSize,
+		interval:  opts.FlushInterval,
+		ch:        make(chan *storage.RequestLog, defaultBuffer),
+		done:      make(chan struct{}),
+		ctx:       ctx,
+		stop:      cancel,
+	}
+	go d.run()
+	return d
+}
+
+func (d *dispatcher) enqueue(entry *storage.RequestLog) {
+	select {
+	case d.ch <- entry:
+	default:
+		d.dropped.Add(1)
+	}
+}
+
+func (d *dispatcher) run() {
+	defer close(d.done)
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	batch := make([]*storage.RequestLog, 0, d.batchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			d.send(batch)
+			batch = make([]*storage.RequestLog, 0, d.batchSize)
+		}
+	}
+	for {
+		select {
+		case entry, ok := <-d.ch:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, entry)
+			if len(batch) >= d.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// send writes a batch, retrying with backoff. Retries stop early once the
+// dispatcher is closing.
+func (d *dispatcher) send(batch []*storage.RequestLog) {
+	var err error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(time.Duration(attempt) * time.Second):
+			case <-d.ctx.Done():
+			}
+		}
+		ctx, cancel := context.WithTimeout(d.ctx, writeTimeout)
+		err = d.sink.Write(ctx, batch)
+		cancel()
+		if err == nil {
+			d.sent.Add(uint64(len(batch)))
+			return
+		}
+		if d.ctx.Err() != nil {
+			break
+		}
+	}
+	d.failed.Add(uint64(len(batch)))
+	log.Printf("sink %s: dropped %d logs: %v", d.sink.Name(), len(batch), err)
+}
+
+func (d *dispatcher) close() {
+	close(d.ch)
+	select {
+	case <-d.done:
+	case <-time.After(closeTimeout):
+		d.stop()
+		<-d.done
+	}
+	d.stop()
+	if c, ok := d.sink.(io.Closer); ok {
+		_ = c.Close()
+	}
+}
@@ -0,0 +1,221 @@
+package sink
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+const (
+	syslogSeverityErr     = 3
+	syslogSeverityWarning = 4
+	syslogSeverityInfo    = 6
+
+	// syslogMaxError keeps a message well inside a UDP datagram.
+	syslogMaxError = 512
+)
+
+// Syslog sends one RFC 5424 access record per log to a syslog collector.
+type Syslog struct {
+	network  string
+	address  string
+	facility int
+	hostname string
+	appName  string
+	procID   string
+
+	conn net.Conn
+}
+
+// NewSyslog creates a syslog sink. The connection is opened on the first
+// write and reopened after a failure.
+func NewSyslog(cfg config.SyslogSinkConfig) (*Syslog, error) {
+	if _, _, err := net.SplitHostPort(cfg.Address); err != nil {
+		return nil, fmt.Errorf("sinks.syslog.address: want host:port, got %q", cfg.Address)
+	}
+	network := strings.ToLower(cfg.Network)
+	switch network {
+	case "":
+		network = "udp"
+	case "udp", "tcp", "tls":
+	default:
+		return nil, fmt.Errorf("sinks.syslog.network: want udp, tcp or tls, got %q", cfg.Network)
+	}
+	facility := strings.ToLower(cfg.Facility)
+	if facility == "" {
+		facility = "local0"
+	}
+	code, ok := syslogFacilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("sinks.syslog.facility: unknown facility %q", cfg.Facility)
+	}
+	hostname := cfg.Hostname
+	if hostname == "" {
+		hostname, _ = os.Hostname()
+	}
+	appName := cfg.AppName
+	if appName == "" {
+		appName = "prismcat"
+	}
+	return &Syslog{
+		network:  network,
+		address:  cfg.Address,
+		facility: code,
+		hostname: syslogHeaderField(hostname, 255),
+		appName:  syslogHeaderField(appName, 48),
+		procID:   strconv.Itoa(os.Getpid()),
+	}, nil
+}
+
+func (s *Syslog) Name() string { return "syslog" }
+
+// Write sends one message per log. Delivery is at-least-once: a batch
+// retried after a broken connection repeats the messages sent before it.
+func (s *Syslog) Write(ctx context.Context, logs []*storage.RequestLog) error {
+	if s.conn == nil {
+		if err := s.dial(ctx); err != nil {
+			return err
+		}
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = s.conn.SetWriteDeadline(deadline)
+	}
+	for _, l := range logs {
+		msg := s.format(l)
+		if s.network != "udp" {
+			msg = strconv.Itoa(len(msg)) + " " + msg
+		}
+		if _, err := s.conn.Write([]byte(msg)); err != nil {
+			_ = s.conn.Close()
+			s.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Syslog) dial(ctx context.Context) error {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var (
+		conn net.Conn
+		err  error
+	)
+	switch s.network {
+	case "tls":
+		host, _, _ := net.SplitHostPort(s.address)
+		td := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host}}
+		conn, err = td.DialContext(ctx, "tcp", s.address)
+	default:
+		conn, err = dialer.DialContext(ctx, s.network, s.address)
+	}
+	if err != nil {
+		return fmt.Errorf("syslog: dial %s: %w", s.address, err)
+	}
+	s.conn = conn
+	return nil
+}
+
+// format renders an RFC 5424 message without structured data; the text is
+// key=value pairs, which SIEM parsers split without extra configuration.
+func (s *Syslog) format(l *storage.RequestLog) string {
+	severity := syslogSeverityInfo
+	switch {
+	case l.Error != "" || l.StatusCode >= 500:
+		severity = syslogSeverityErr
+	case l.StatusCode >= 400:
+		severity = syslogSeverityWarning
+	}
+	ts := l.CreatedAt
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %s access - ",
+		s.facility*8+severity, ts.UTC().Format("2006-01-02T15:04:05.000Z"), s.hostname, s.appName, s.procID)
+	first := true
+	kv := func(k, v string) {
+		if v == "" {
+			return
+		}
+		if !first {
+			b.WriteByte(' ')
+		}
+		first = false
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(syslogValue(v))
+	}
+	kv("id", l.ID)
+	kv("upstream", l.Upstream)
+	kv("method", l.Method)
+	kv("path", l.Path)
+	kv("status", strconv.Itoa(l.StatusCode))
+	kv("latency_ms", strconv.FormatInt(l.Latency, 10))
+	kv("req_bytes", strconv.FormatInt(l.RequestBodySize, 10))
+	kv("resp_bytes", strconv.FormatInt(l.ResponseBodySize, 10))
+	kv("client_ip", l.ClientIP)
+	kv("user_agent", l.UserAgent)
+	kv("tag", l.Tag)
+	kv("source", l.Source)
+	kv("flags", strings.Join(l.Flags, ","))
+	if len(l.Error) > syslogMaxError {
+		kv("error", l.Error[:syslogMaxError]+"...")
+	} else {
+		kv("error", l.Error)
+	}
+	return b.String()
+}
+
+// syslogValue quotes values with spaces, quotes or '=' and flattens newlines,
+// so each record stays on one line.
+func syslogValue(v string) string {
+	v = strings.NewReplacer("\r", " ", "\n", " ").Replace(v)
+	if !strings.ContainsAny(v, ` "=\`) {
+		return v
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+}
+
+// syslogHeaderField makes a header field printable ASCII without spaces,
+// as RFC 5424 requires, and caps its length.
+func syslogHeaderField(v string, max int) string {
+	v = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, v)
+	if v == "" {
+		return "-"
+	}
+	if len(v) > max {
+		v = v[:max]
+	}
+	return v
+}
+
+// Close closes the collector connection.
+func (s *Syslog) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
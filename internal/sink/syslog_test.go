@@ -0,0 +1,117 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/storage"
+)
+
+var syslogTestLogs = []*storage.RequestLog{
+	{
+		ID: "a", CreatedAt: time.Date(2026, 1, 2, 3, 4, 5, 6e6, time.UTC), Upstream: "openai",
+		Method: "POST", Path: "/v1/chat/completions", StatusCode: 200, Latency: 120,
+		RequestBodySize: 10, ResponseBodySize: 20, ClientIP: "10.0.0.1", UserAgent: "curl/8.0",
+	},
+	{ID: "b", Upstream: "openai", Method: "GET", Path: "/v1/models", StatusCode: 502, Error: "upstream request failed: \"dial\"\nrefused"},
+}
+
+func TestSyslogUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	s, err := NewSyslog(config.SyslogSinkConfig{Address: pc.LocalAddr().String(), Facility: "local3", Hostname: "proxy 1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Write(context.Background(), syslogTestLogs); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 2048)
+	var msgs []string
+	for range syslogTestLogs {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, string(buf[:n]))
+	}
+	want := "<158>1 2026-01-02T03:04:05.006Z proxy_1 prismcat " + s.procID +
+		" access - id=a upstream=openai method=POST path=/v1/chat/completions status=200 latency_ms=120" +
+		" req_bytes=10 resp_bytes=20 client_ip=10.0.0.1 user_agent=curl/8.0"
+	if msgs[0] != want {
+		t.Fatalf("message =\n%s\nwant\n%s", msgs[0], want)
+	}
+	if !strings.HasPrefix(msgs[1], "<155>1 ") || !strings.HasSuffix(msgs[1], ` error="upstream request failed: \"dial\" refused"`) {
+		t.Fatalf("error message = %s", msgs[1])
+	}
+}
+
+func TestSyslogTCPOctetCounting(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	got := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		br := bufio.NewReader(conn)
+		var msgs []string
+		for range syslogTestLogs {
+			size, err := br.ReadString(' ')
+			if err != nil {
+				break
+			}
+			n, _ := strconv.Atoi(strings.TrimSpace(size))
+			msg := make([]byte, n)
+			if _, err := io.ReadFull(br, msg); err != nil {
+				break
+			}
+			msgs = append(msgs, string(msg))
+		}
+		got <- msgs
+	}()
+
+	s, err := NewSyslog(config.SyslogSinkConfig{Address: ln.Addr().String(), Network: "tcp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Write(context.Background(), syslogTestLogs); err != nil {
+		t.Fatal(err)
+	}
+	msgs := <-got
+	if len(msgs) != 2 || !strings.Contains(msgs[0], " id=a ") || !strings.Contains(msgs[1], " id=b ") {
+		t.Fatalf("messages = %q", msgs)
+	}
+}
+
+func TestSyslogConfigErrors(t *testing.T) {
+	for _, cfg := range []config.SyslogSinkConfig{
+		{Address: "siem"},
+		{Address: "siem:514", Network: "http"},
+		{Address: "siem:514", Facility: "local9"},
+	} {
+		if _, err := NewSyslog(cfg); err == nil {
+			t.Errorf("%+v: want error", cfg)
+		}
+	}
+}
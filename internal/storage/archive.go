@@ -0,0 +1,283 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+)
+
+// ErrArchiveUnavailable is returned when a body lives only in the archive
+// tier and the archive can't be read right now.
+var ErrArchiveUnavailable = errors.New("archive unavailable")
+
+// NewArchive opens the archive tier described by cfg.
+func NewArchive(cfg config.ArchiveConfig) (BlobStore, error) {
+	if cfg.S3 != nil {
+		return NewS3Archive(*cfg.S3), nil
+	}
+	return NewDirArchive(cfg.Dir)
+}
+
+// DirArchive stores gzip-compressed blobs in a local directory, using the
+// FileBlobStore layout so the same garbage collection applies.
+type DirArchive struct {
+	files *FileBlobStore
+}
+
+func NewDirArchive(dir string) (*DirArchive, error) {
+	files, err := NewFileBlobStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &DirArchive{files: files}, nil
+}
+
+func (a *DirArchive) Put(ctx context.Context, data []byte) (string, error) {
+	_ = ctx
+	sum := sha256.Sum256(data)
+	packed, err := gzipBlob(data)
+	if err != nil {
+		return "", err
+	}
+	if err := a.files.writeFile(hex.EncodeToString(sum[:]), packed); err != nil {
+		return "", err
+	}
+	return newSHA256Ref(sum), nil
+}
+
+func (a *DirArchive) Get(ctx context.Context, ref string) ([]byte, error) {
+	_, hexHash, err := parseBlobRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	packed, err := a.files.Get(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return gunzipBlob(packed, hexHash)
+}
+
+func (a *DirArchive) Exists(ctx context.Context, ref string) (bool, error) {
+	return a.files.Exists(ctx, ref)
+}
+
+// Delete removes an archived blob. Deleting a missing blob is not an error.
+func (a *DirArchive) Delete(ctx context.Context, ref string) error {
+	return a.files.Delete(ctx, ref)
+}
+
+// GarbageCollect removes archived blobs no log references any more.
+func (a *DirArchive) GarbageCollect(ctx context.Context, referencedRefs []string, minAge time.Duration) (*GCReport, error) {
+	return a.files.GarbageCollect(ctx, referencedRefs, minAge)
+}
+
+// Stats reports the compressed size of the archive.
+func (a *DirArchive) Stats(ctx context.Context) (BlobStats, error) {
+	return a.files.Stats(ctx)
+}
+
+func gzipBlob(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// gunzipBlob decompresses an archived blob and checks it against its hash,
+// so a corrupt archive object is reported rather than served.
+func gunzipBlob(packed []byte, hexHash string) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(packed))
+	if err != nil {
+		return nil, fmt.Errorf("archived blob %s: %w", hexHash, err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("archived blob %s: %w", hexHash, err)
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != hexHash {
+		return nil, fmt.Errorf("archived blob %s: content hash mismatch", hexHash)
+	}
+	return data, nil
+}
+
+// TieredBlobStore puts new blobs in the hot store and reads through to the
+// archive for blobs that were moved there, so archived bodies stay
+// reachable under their original refs.
+type TieredBlobStore struct {
+	hot     BlobStore
+	archive BlobStore
+}
+
+func NewTieredBlobStore(hot, archive BlobStore) *TieredBlobStore {
+	return &TieredBlobStore{hot: hot, archive: archive}
+}
+
+// Hot returns the store new blobs go to.
+func (s *TieredBlobStore) Hot() BlobStore { return s.hot }
+
+// Archive returns the cold tier.
+func (s *TieredBlobStore) Archive() BlobStore { return s.archive }
+
+// HotBlobs returns the hot store of a TieredBlobStore, or bs itself.
+func HotBlobs(bs BlobStore) BlobStore {
+	if t, ok := bs.(*TieredBlobStore); ok {
+		return t.hot
+	}
+	return bs
+}
+
+func (s *TieredBlobStore) Put(ctx context.Context, data []byte) (string, error) {
+	return s.hot.Put(ctx, data)
+}
+
+// Get reads from the hot store, then the archive. Archive failures other
+// than a missing blob are wrapped in ErrArchiveUnavailable.
+func (s *TieredBlobStore) Get(ctx context.Context, ref string) ([]byte, error) {
+	data, err := s.hot.Get(ctx, ref)
+	if !errors.Is(err, ErrBlobNotFound) {
+		return data, err
+	}
+	data, err = s.archive.Get(ctx, ref)
+	if err != nil && !errors.Is(err, ErrBlobNotFound) && !errors.Is(err, ErrInvalidBlobRef) {
+		return nil, fmt.Errorf("%w: %v", ErrArchiveUnavailable, err)
+	}
+	return data, err
+}
+
+func (s *TieredBlobStore) Exists(ctx context.Context, ref string) (bool, error) {
+	if ok, err := s.hot.Exists(ctx, ref); ok || err != nil {
+		return ok, err
+	}
+	return s.archive.Exists(ctx, ref)
+}
+
+// Delete removes the blob from both tiers.
+func (s *TieredBlobStore) Delete(ctx context.Context, ref string) error {
+	for _, bs := range []BlobStore{s.hot, s.archive} {
+		if d, ok := bs.(BlobDeleter); ok {
+			if err := d.Delete(ctx, ref); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Stats reports the hot store only; archive size is reported separately.
+func (s *TieredBlobStore) Stats(ctx context.Context) (BlobStats, error) {
+	if st, ok := s.hot.(BlobStatser); ok {
+		return st.Stats(ctx)
+	}
+	return BlobStats{}, nil
+}
+
+// ArchiveResult reports what ArchiveBodiesBefore moved.
+type ArchiveResult struct {
+	Before time.Time `json:"before"`
+	// Logs counts logs marked FlagBodyArchived.
+	Logs int64 `json:"logs"`
+	// Blobs counts bodies written to the archive; Bytes is their
+	// uncompressed size.
+	Blobs int   `json:"blobs"`
+	Bytes int64 `json:"bytes"`
+}
+
+// archiveBatch bounds the logs (and inline bodies) held in memory at once.
+const archiveBatch = 100
+
+// ArchiveBodiesBefore moves the full bodies of logs created before the
+// cutoff to the archive tier. Detached blobs are copied and then removed
+// from the hot store; inline bodies over previewBytes are written to the
+// archive and replaced by a preview plus ref, as if they had been detached.
+// Each log gets FlagBodyArchived. A hot blob shared with a newer log moves
+// too; that log reads it through the archive.
+//
+// Logs still waiting in the async write queue are not seen.
+func ArchiveBodiesBefore(ctx context.Context, repo Repository, blobs *TieredBlobStore, before time.Time, previewBytes int64) (*ArchiveResult, error) {
+	res := &ArchiveResult{Before: before}
+	moved := make(map[string]bool)
+	err := func() error {
+		var from time.Time
+		for {
+			logs, err := repo.ArchivableLogs(ctx, from, before, previewBytes, archiveBatch)
+			if err != nil || len(logs) == 0 {
+				return err
+			}
+			for _, l := range logs {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				if err := blobs.archiveBody(ctx, &l.RequestBody, &l.RequestBodyRef, previewBytes, moved, res); err != nil {
+					return err
+				}
+				if err := blobs.archiveBody(ctx, &l.ResponseBody, &l.ResponseBodyRef, previewBytes, moved, res); err != nil {
+					return err
+				}
+				if err := repo.ReplaceBodies(ctx, l, FlagBodyArchived); err != nil && !errors.Is(err, ErrLogNotFound) {
+					return err
+				}
+				res.Logs++
+				from = l.CreatedAt
+			}
+		}
+	}()
+
+	// Hot copies go only after their logs are marked, and even after a
+	// failed run, since every moved blob is already safe in the archive.
+	if d, ok := blobs.hot.(BlobDeleter); ok {
+		for ref := range moved {
+			if derr := d.Delete(context.Background(), ref); derr != nil && err == nil {
+				err = derr
+			}
+		}
+	}
+	return res, err
+}
+
+func (s *TieredBlobStore) archiveBody(ctx context.Context, body, ref *string, previewBytes int64, moved map[string]bool, res *ArchiveResult) error {
+	if *ref != "" {
+		if moved[*ref] {
+			return nil
+		}
+		data, err := s.hot.Get(ctx, *ref)
+		if errors.Is(err, ErrBlobNotFound) {
+			return nil // archived with an earlier log
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := s.archive.Put(ctx, data); err != nil {
+			return fmt.Errorf("archive %s: %w", *ref, err)
+		}
+		moved[*ref] = true
+		res.Blobs++
+		res.Bytes += int64(len(data))
+		return nil
+	}
+
+	if int64(len(*body)) <= previewBytes {
+		return nil
+	}
+	r, err := s.archive.Put(ctx, stringBytes(*body))
+	if err != nil {
+		return fmt.Errorf("archive inline body: %w", err)
+	}
+	res.Blobs++
+	res.Bytes += int64(len(*body))
+	*ref = r
+	*body = truncateUTF8(*body, previewBytes)
+	return nil
+}
@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/awsauth"
+	"github.com/prismcat/prismcat/internal/config"
+)
+
+// maxArchiveObjectBytes caps how much of one archive object is read back.
+const maxArchiveObjectBytes = 1 << 30
+
+// S3Archive stores gzip-compressed blobs as objects named
+// <prefix><hash>.gz in an S3 bucket, signed with SigV4. It has no garbage
+// collection; expire old objects with a bucket lifecycle rule instead.
+type S3Archive struct {
+	cfg    config.S3ArchiveConfig
+	creds  *awsauth.Resolver
+	client *http.Client
+	now    func() time.Time
+}
+
+func NewS3Archive(cfg config.S3ArchiveConfig) *S3Archive {
+	return &S3Archive{
+		cfg:    cfg,
+		creds:  awsauth.NewResolver(),
+		client: &http.Client{Timeout: 60 * time.Second},
+		now:    time.Now,
+	}
+}
+
+// objectURL uses virtual-hosted addressing on AWS and path-style addressing
+// on a custom endpoint, which is what S3-compatible services expect.
+func (a *S3Archive) objectURL(hexHash string) string {
+	key := url.PathEscape(a.cfg.Prefix + hexHash + ".gz")
+	key = strings.ReplaceAll(key, "%2F", "/")
+	if a.cfg.Endpoint != "" {
+		return strings.TrimRight(a.cfg.Endpoint, "/") + "/" + a.cfg.Bucket + "/" + key
+	}
+	return "https://" + a.cfg.Bucket + ".s3." + a.cfg.Region + ".amazonaws.com/" + key
+}
+
+func (a *S3Archive) do(ctx context.Context, method, hexHash string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, a.objectURL(hexHash), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/gzip")
+	}
+	creds, err := a.creds.Resolve(ctx, awsauth.Credentials{
+		AccessKeyID:     a.cfg.AccessKeyID,
+		SecretAccessKey: a.cfg.SecretAccessKey,
+		SessionToken:    a.cfg.SessionToken,
+	})
+	if err != nil {
+		return nil, err
+	}
+	awsauth.Sign(req, body, "s3", a.cfg.Region, creds, a.now())
+	return a.client.Do(req)
+}
+
+// check turns a non-2xx response into an error, closing its body.
+func (a *S3Archive) check(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrBlobNotFound
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("s3 %s %s: HTTP %d: %s", resp.Request.Method, resp.Request.URL.Path, resp.StatusCode, strings.TrimSpace(string(msg)))
+}
+
+func (a *S3Archive) Put(ctx context.Context, data []byte) (string, error) {
+	ref := newSHA256Ref(sha256.Sum256(data))
+	_, hexHash, _ := parseBlobRef(ref)
+	packed, err := gzipBlob(data)
+	if err != nil {
+		return "", err
+	}
+	resp, err := a.do(ctx, http.MethodPut, hexHash, packed)
+	if err != nil {
+		return "", err
+	}
+	if err := a.check(resp); err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return ref, nil
+}
+
+func (a *S3Archive) Get(ctx context.Context, ref string) ([]byte, error) {
+	_, hexHash, err := parseBlobRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := a.do(ctx, http.MethodGet, hexHash, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.check(resp); err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	packed, err := io.ReadAll(io.LimitReader(resp.Body, maxArchiveObjectBytes))
+	if err != nil {
+		return nil, err
+	}
+	return gunzipBlob(packed, hexHash)
+}
+
+func (a *S3Archive) Exists(ctx context.Context, ref string) (bool, error) {
+	_, hexHash, err := parseBlobRef(ref)
+	if err != nil {
+		return false, err
+	}
+	resp, err := a.do(ctx, http.MethodHead, hexHash, nil)
+	if err != nil {
+		return false, err
+	}
+	if err := a.check(resp); err != nil {
+		if err == ErrBlobNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+// Delete removes an archived object. S3 treats deleting a missing key as
+// success.
+func (a *S3Archive) Delete(ctx context.Context, ref string) error {
+	_, hexHash, err := parseBlobRef(ref)
+	if err != nil {
+		return err
+	}
+	resp, err := a.do(ctx, http.MethodDelete, hexHash, nil)
+	if err != nil {
+		return err
+	}
+	if err := a.check(resp); err != nil && err != ErrBlobNotFound {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
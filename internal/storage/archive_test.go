@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+)
+
+func TestArchiveBodiesBefore(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestSQLite(t)
+	hot, err := NewFileBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := NewDirArchive(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs := NewTieredBlobStore(hot, archive)
+
+	detached := strings.Repeat("detached body ", 200)
+	ref, err := hot.Put(ctx, []byte(detached))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inline := strings.Repeat("inline body ", 50)
+	old := time.Now().Add(-48 * time.Hour)
+	for _, l := range []*RequestLog{
+		{ID: "detached", CreatedAt: old, RequestBody: detached[:16], RequestBodyRef: ref},
+		{ID: "inline", CreatedAt: old.Add(time.Second), ResponseBody: inline},
+		{ID: "small", CreatedAt: old.Add(2 * time.Second), ResponseBody: "ok"},
+		{ID: "new", CreatedAt: time.Now(), ResponseBody: inline},
+	} {
+		l.Upstream, l.Method = "openai", "POST"
+		if err := repo.SaveLog(context.Background(), l); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err := ArchiveBodiesBefore(ctx, repo, blobs, time.Now().Add(-24*time.Hour), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Logs != 2 || res.Blobs != 2 || res.Bytes != int64(len(detached)+len(inline)) {
+		t.Fatalf("result = %+v", res)
+	}
+	if ok, _ := hot.Exists(ctx, ref); ok {
+		t.Fatal("archived blob still in the hot store")
+	}
+	if got, err := blobs.Get(ctx, ref); err != nil || string(got) != detached {
+		t.Fatalf("read-through = %d bytes, %v", len(got), err)
+	}
+
+	l, err := repo.GetLog(context.Background(), "inline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.HasFlag(FlagBodyArchived) || l.ResponseBodyRef == "" || l.ResponseBody != inline[:16] {
+		t.Fatalf("inline log = flags %v ref %q body %q", l.Flags, l.ResponseBodyRef, l.ResponseBody)
+	}
+	if got, err := blobs.Get(ctx, l.ResponseBodyRef); err != nil || string(got) != inline {
+		t.Fatalf("archived inline body = %q, %v", got, err)
+	}
+	for _, id := range []string{"small", "new"} {
+		if l, _ := repo.GetLog(context.Background(), id); l.HasFlag(FlagBodyArchived) || l.ResponseBodyRef != "" {
+			t.Fatalf("%s archived: %+v", id, l)
+		}
+	}
+
+	// A second run finds nothing left to do.
+	if res, err = ArchiveBodiesBefore(ctx, repo, blobs, time.Now().Add(-24*time.Hour), 16); err != nil || res.Logs != 0 {
+		t.Fatalf("second run = %+v, %v", res, err)
+	}
+}
+
+// unreachableArchive fails every read, as an offline bucket would.
+type unreachableArchive struct{ BlobStore }
+
+func (unreachableArchive) Get(context.Context, string) ([]byte, error) {
+	return nil, errors.New("dial tcp: connection refused")
+}
+
+func TestTieredBlobStoreArchiveUnavailable(t *testing.T) {
+	ctx := context.Background()
+	blobs := NewTieredBlobStore(NewMemoryBlobStore(0), unreachableArchive{})
+	_, err := blobs.Get(ctx, newSHA256Ref([32]byte{1}))
+	if !errors.Is(err, ErrArchiveUnavailable) {
+		t.Fatalf("err = %v, want ErrArchiveUnavailable", err)
+	}
+}
+
+func TestS3Archive(t *testing.T) {
+	var mu sync.Mutex
+	objects := map[string][]byte{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") ||
+			!strings.Contains(r.Header.Get("Authorization"), "/us-east-1/s3/aws4_request") ||
+			r.Header.Get("X-Amz-Content-Sha256") == "" {
+			http.Error(w, "unsigned", http.StatusForbidden)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			objects[r.URL.Path], _ = io.ReadAll(r.Body)
+		case http.MethodGet, http.MethodHead:
+			data, ok := objects[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = w.Write(data)
+		case http.MethodDelete:
+			delete(objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	a := NewS3Archive(config.S3ArchiveConfig{
+		Bucket: "logs", Region: "us-east-1", Endpoint: srv.URL, Prefix: "prismcat/",
+		AccessKeyID: "AKID", SecretAccessKey: "secret",
+	})
+	data := bytes.Repeat([]byte("archived "), 100)
+	ref, err := a.Put(ctx, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, hexHash, _ := parseBlobRef(ref)
+	stored, ok := objects["/logs/prismcat/"+hexHash+".gz"]
+	if !ok || len(stored) >= len(data) {
+		t.Fatalf("objects = %v", objects)
+	}
+	if got, err := a.Get(ctx, ref); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Get = %d bytes, %v", len(got), err)
+	}
+	if ok, err := a.Exists(ctx, ref); !ok || err != nil {
+		t.Fatalf("Exists = %v, %v", ok, err)
+	}
+	if err := a.Delete(ctx, ref); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Get(ctx, ref); !errors.Is(err, ErrBlobNotFound) {
+		t.Fatalf("Get after delete: %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"context"
 	"errors"
 	"log"
 	"sync"
@@ -33,8 +34,33 @@ type AsyncRepository struct {
 
 	wg      sync.WaitGroup
 	dropped atomic.Uint64
+
+	droppedSuccess  atomic.Uint64
+	droppedError    atomic.Uint64
+	droppedSnapshot atomic.Uint64
+}
+
+// QueueStats describes the async log queue and what it has shed.
+type QueueStats struct {
+	Length   int        `json:"length"`
+	Capacity int        `json:"capacity"`
+	Dropped  DropCounts `json:"dropped"`
+}
+
+// DropCounts counts dropped entries per class.
+type DropCounts struct {
+	// Success is final updates of successful requests, shed first.
+	Success uint64 `json:"success"`
+	// Error is final updates of failed requests (status >= 400 or error).
+	Error uint64 `json:"error"`
+	// Snapshot is initial in-flight inserts.
+	Snapshot uint64 `json:"snapshot"`
 }
 
+// successHighWater is the queue fill ratio above which success updates are
+// dropped, keeping the remaining room for errors and snapshots.
+const successHighWater = 0.75
+
 // NewAsyncRepository creates an async wrapper with a bounded queue.
 func NewAsyncRepository(inner Repository, buffer int) *AsyncRepository {
 	if buffer <= 0 {
@@ -50,7 +76,8 @@ func NewAsyncRepository(inner Repository, buffer int) *AsyncRepository {
 	go func() {
 		defer a.wg.Done()
 		for entry := range a.ch {
-			if err := a.inner.SaveLog(entry); err != nil {
+			// The caller's request is long gone; the write has no deadline.
+			if err := a.inner.SaveLog(context.Background(), entry); err != nil {
 				// Best-effort: avoid crashing the proxy path.
 				log.Printf("save log failed: %v", err)
 			}
@@ -65,7 +92,20 @@ func (a *AsyncRepository) Dropped() uint64 {
 	return a.dropped.Load()
 }
 
-func (a *AsyncRepository) SaveLog(log *RequestLog) error {
+// QueueStats returns the current queue fill and per-class drop counters.
+func (a *AsyncRepository) QueueStats() QueueStats {
+	return QueueStats{
+		Length:   len(a.ch),
+		Capacity: cap(a.ch),
+		Dropped: DropCounts{
+			Success:  a.droppedSuccess.Load(),
+			Error:    a.droppedError.Load(),
+			Snapshot: a.droppedSnapshot.Load(),
+		},
+	}
+}
+
+func (a *AsyncRepository) SaveLog(ctx context.Context, log *RequestLog) error {
 	if log == nil {
 		return nil
 	}
@@ -90,30 +130,131 @@ func (a *AsyncRepository) SaveLog(log *RequestLog) error {
 		a.inflightMu.Unlock()
 	}()
 
+	// Near capacity, successful updates give way so that errors and in-flight
+	// snapshots still fit. A dropped success update leaves its snapshot behind
+	// with no status, which is better than losing a failure.
+	counter := a.dropCounter(log)
+	if counter == &a.droppedSuccess && float64(len(a.ch)) >= successHighWater*float64(cap(a.ch)) {
+		a.dropped.Add(1)
+		counter.Add(1)
+		return ErrAsyncQueueFull
+	}
+
 	c := cloneRequestLog(log)
 	select {
 	case a.ch <- c:
 		return nil
 	default:
 		a.dropped.Add(1)
+		counter.Add(1)
 		return ErrAsyncQueueFull
 	}
 }
 
-func (a *AsyncRepository) GetLog(id string) (*RequestLog, error) {
-	return a.inner.GetLog(id)
+// dropCounter classifies an entry: an initial snapshot has neither a status
+// nor an error yet.
+func (a *AsyncRepository) dropCounter(log *RequestLog) *atomic.Uint64 {
+	switch {
+	case log.StatusCode == 0 && log.Error == "":
+		return &a.droppedSnapshot
+	case log.StatusCode >= 400 || log.Error != "":
+		return &a.droppedError
+	default:
+		return &a.droppedSuccess
+	}
+}
+
+func (a *AsyncRepository) GetLog(ctx context.Context, id string) (*RequestLog, error) {
+	return a.inner.GetLog(ctx, id)
+}
+
+func (a *AsyncRepository) GetLogMetadata(ctx context.Context, id string) (map[string]string, error) {
+	return a.inner.GetLogMetadata(ctx, id)
+}
+
+func (a *AsyncRepository) SetLogMetadata(ctx context.Context, id string, md map[string]string) error {
+	return a.inner.SetLogMetadata(ctx, id, md)
+}
+
+func (a *AsyncRepository) ListLogs(ctx context.Context, filter LogFilter) ([]*RequestLog, int64, error) {
+	return a.inner.ListLogs(ctx, filter)
+}
+
+func (a *AsyncRepository) ListReplays(ctx context.Context, logID string) ([]*Replay, error) {
+	return a.inner.ListReplays(ctx, logID)
+}
+
+// SaveReplay is synchronous: replays are API actions, not proxy traffic.
+func (a *AsyncRepository) SaveReplay(ctx context.Context, r *Replay) error {
+	return a.inner.SaveReplay(ctx, r)
+}
+
+func (a *AsyncRepository) DeleteLogsBefore(ctx context.Context, beforeTime time.Time) (int64, error) {
+	return a.inner.DeleteLogsBefore(ctx, beforeTime)
+}
+
+func (a *AsyncRepository) ClearBlobRefsBefore(ctx context.Context, before time.Time, flag string) (int64, error) {
+	return a.inner.ClearBlobRefsBefore(ctx, before, flag)
+}
+
+func (a *AsyncRepository) ReplaceBodies(ctx context.Context, l *RequestLog, flag string) error {
+	return a.inner.ReplaceBodies(ctx, l, flag)
 }
 
-func (a *AsyncRepository) ListLogs(filter LogFilter) ([]*RequestLog, int64, error) {
-	return a.inner.ListLogs(filter)
+func (a *AsyncRepository) DeleteLogs(ctx context.Context, ids []string) (int64, error) {
+	return a.inner.DeleteLogs(ctx, ids)
 }
 
-func (a *AsyncRepository) DeleteLogsBefore(beforeTime time.Time) (int64, error) {
-	return a.inner.DeleteLogsBefore(beforeTime)
+func (a *AsyncRepository) ScanLogContent(ctx context.Context, fn func(*RequestLog) error) error {
+	return a.inner.ScanLogContent(ctx, fn)
 }
 
-func (a *AsyncRepository) GetStats(since *time.Time) (*LogStats, error) {
-	return a.inner.GetStats(since)
+func (a *AsyncRepository) ListBlobRefs(ctx context.Context) ([]string, error) {
+	return a.inner.ListBlobRefs(ctx)
+}
+
+func (a *AsyncRepository) BlobRefsBefore(ctx context.Context, before time.Time) ([]string, int64, error) {
+	return a.inner.BlobRefsBefore(ctx, before)
+}
+
+func (a *AsyncRepository) ArchivableLogs(ctx context.Context, from, before time.Time, minInline int64, limit int) ([]*RequestLog, error) {
+	return a.inner.ArchivableLogs(ctx, from, before, minInline, limit)
+}
+
+func (a *AsyncRepository) GetStats(ctx context.Context, since *time.Time) (*LogStats, error) {
+	return a.inner.GetStats(ctx, since)
+}
+
+func (a *AsyncRepository) GetUpstreamStats(ctx context.Context, upstream string, since *time.Time) (*UpstreamStats, error) {
+	return a.inner.GetUpstreamStats(ctx, upstream, since)
+}
+
+func (a *AsyncRepository) GetPropertyStats(ctx context.Context, key string, since *time.Time, limit int) ([]PropertyStat, error) {
+	return a.inner.GetPropertyStats(ctx, key, since, limit)
+}
+
+func (a *AsyncRepository) GetCheckStats(ctx context.Context, upstream string, since *time.Time) ([]CheckStat, error) {
+	return a.inner.GetCheckStats(ctx, upstream, since)
+}
+
+func (a *AsyncRepository) GetTrafficStats(ctx context.Context, from, to, upstream string) ([]TrafficStat, error) {
+	return a.inner.GetTrafficStats(ctx, from, to, upstream)
+}
+
+func (a *AsyncRepository) GetHourlyRollups(ctx context.Context, from, to, upstream, model string) ([]HourlyRollup, error) {
+	return a.inner.GetHourlyRollups(ctx, from, to, upstream, model)
+}
+
+func (a *AsyncRepository) GetStorageStats(ctx context.Context) (*StorageStats, error) {
+	return a.inner.GetStorageStats(ctx)
+}
+
+func (a *AsyncRepository) GetUpstreamFootprints(ctx context.Context) ([]UpstreamFootprint, error) {
+	return a.inner.GetUpstreamFootprints(ctx)
+}
+
+func (a *AsyncRepository) Snapshot(ctx context.Context, dstPath string) error {
+	return a.inner.Snapshot(ctx, dstPath)
 }
 
 func (a *AsyncRepository) Close() error {
@@ -141,9 +282,32 @@ func cloneRequestLog(in *RequestLog) *RequestLog {
 	out := *in
 	out.RequestHeaders = cloneHeaders(in.RequestHeaders)
 	out.ResponseHeaders = cloneHeaders(in.ResponseHeaders)
+	if in.Flags != nil {
+		out.Flags = append([]string(nil), in.Flags...)
+	}
+	if in.EventTimings != nil {
+		out.EventTimings = append([]EventTiming(nil), in.EventTimings...)
+	}
+	if in.Connection != nil {
+		c := *in.Connection
+		out.Connection = &c
+	}
+	out.Properties = cloneStringMap(in.Properties)
+	out.Metadata = cloneStringMap(in.Metadata)
 	return &out
 }
 
+func cloneStringMap(in map[string]string) map[string]string {
+	if in == nil {
+		return nil
+	}
+	out := make(map[string]string, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
+
 func cloneHeaders(in map[string][]string) map[string][]string {
 	if len(in) == 0 {
 		return nil
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"context"
 	"errors"
 	"sync"
 	"testing"
@@ -13,7 +14,7 @@ type memRepo struct {
 	logs   []*RequestLog
 }
 
-func (m *memRepo) SaveLog(log *RequestLog) error {
+func (m *memRepo) SaveLog(ctx context.Context, log *RequestLog) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	if m.closed {
@@ -26,13 +27,64 @@ func (m *memRepo) SaveLog(log *RequestLog) error {
 	return nil
 }
 
-func (m *memRepo) GetLog(id string) (*RequestLog, error) { return nil, errors.New("not implemented") }
-func (m *memRepo) ListLogs(filter LogFilter) ([]*RequestLog, int64, error) {
+func (m *memRepo) GetLog(ctx context.Context, id string) (*RequestLog, error) {
+	return nil, errors.New("not implemented")
+}
+func (m *memRepo) ListLogs(ctx context.Context, filter LogFilter) ([]*RequestLog, int64, error) {
 	return nil, 0, errors.New("not implemented")
 }
-func (m *memRepo) DeleteLogsBefore(before time.Time) (int64, error) { return 0, nil }
-func (m *memRepo) GetStats(since *time.Time) (*LogStats, error)     { return &LogStats{}, nil }
-func (m *memRepo) Close() error                                     { m.mu.Lock(); m.closed = true; m.mu.Unlock(); return nil }
+func (m *memRepo) DeleteLogsBefore(ctx context.Context, before time.Time) (int64, error) {
+	return 0, nil
+}
+func (m *memRepo) DeleteLogs(ctx context.Context, ids []string) (int64, error) { return 0, nil }
+func (m *memRepo) GetLogMetadata(ctx context.Context, id string) (map[string]string, error) {
+	return nil, nil
+}
+func (m *memRepo) SetLogMetadata(ctx context.Context, id string, md map[string]string) error {
+	return nil
+}
+func (m *memRepo) SaveReplay(ctx context.Context, r *Replay) error                      { return nil }
+func (m *memRepo) ListReplays(ctx context.Context, logID string) ([]*Replay, error)     { return nil, nil }
+func (m *memRepo) ScanLogContent(ctx context.Context, fn func(*RequestLog) error) error { return nil }
+func (m *memRepo) ClearBlobRefsBefore(ctx context.Context, before time.Time, flag string) (int64, error) {
+	return 0, nil
+}
+func (m *memRepo) ReplaceBodies(ctx context.Context, l *RequestLog, flag string) error { return nil }
+func (m *memRepo) ArchivableLogs(ctx context.Context, from, before time.Time, minInline int64, limit int) ([]*RequestLog, error) {
+	return nil, nil
+}
+func (m *memRepo) BlobRefsBefore(ctx context.Context, before time.Time) ([]string, int64, error) {
+	return nil, 0, nil
+}
+func (m *memRepo) ListBlobRefs(ctx context.Context) ([]string, error) { return nil, nil }
+func (m *memRepo) Snapshot(ctx context.Context, dstPath string) error {
+	return errors.New("not implemented")
+}
+func (m *memRepo) GetStats(ctx context.Context, since *time.Time) (*LogStats, error) {
+	return &LogStats{}, nil
+}
+func (m *memRepo) GetUpstreamStats(ctx context.Context, upstream string, since *time.Time) (*UpstreamStats, error) {
+	return &UpstreamStats{Upstream: upstream}, nil
+}
+func (m *memRepo) GetPropertyStats(ctx context.Context, key string, since *time.Time, limit int) ([]PropertyStat, error) {
+	return nil, nil
+}
+func (m *memRepo) GetCheckStats(ctx context.Context, upstream string, since *time.Time) ([]CheckStat, error) {
+	return nil, nil
+}
+func (m *memRepo) GetTrafficStats(ctx context.Context, from, to, upstream string) ([]TrafficStat, error) {
+	return nil, nil
+}
+func (m *memRepo) GetHourlyRollups(ctx context.Context, from, to, upstream, model string) ([]HourlyRollup, error) {
+	return nil, nil
+}
+func (m *memRepo) GetStorageStats(ctx context.Context) (*StorageStats, error) {
+	return &StorageStats{}, nil
+}
+func (m *memRepo) GetUpstreamFootprints(ctx context.Context) ([]UpstreamFootprint, error) {
+	return nil, nil
+}
+func (m *memRepo) Close() error { m.mu.Lock(); m.closed = true; m.mu.Unlock(); return nil }
 
 func TestAsyncRepositoryCloseDrainsQueue(t *testing.T) {
 	inner := &memRepo{}
@@ -40,7 +92,7 @@ func TestAsyncRepositoryCloseDrainsQueue(t *testing.T) {
 
 	const n = 10
 	for i := 0; i < n; i++ {
-		if err := a.SaveLog(&RequestLog{ID: "id"}); err != nil {
+		if err := a.SaveLog(context.Background(), &RequestLog{ID: "id"}); err != nil {
 			t.Fatalf("SaveLog failed: %v", err)
 		}
 	}
@@ -68,7 +120,7 @@ func TestAsyncRepositoryCloseConcurrentSaveDoesNotPanic(t *testing.T) {
 		go func() {
 			defer wg.Done()
 			for {
-				err := a.SaveLog(&RequestLog{ID: "id"})
+				err := a.SaveLog(context.Background(), &RequestLog{ID: "id"})
 				if err == ErrAsyncClosed {
 					return
 				}
@@ -83,3 +135,49 @@ func TestAsyncRepositoryCloseConcurrentSaveDoesNotPanic(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+type blockingRepo struct {
+	memRepo
+	gate chan struct{}
+}
+
+func (b *blockingRepo) SaveLog(ctx context.Context, log *RequestLog) error {
+	<-b.gate
+	return b.memRepo.SaveLog(ctx, log)
+}
+
+func TestAsyncRepositoryShedsSuccessFirst(t *testing.T) {
+	inner := &blockingRepo{gate: make(chan struct{})}
+	a := NewAsyncRepository(inner, 4)
+
+	// The worker takes the first entry and blocks on it.
+	_ = a.SaveLog(context.Background(), &RequestLog{ID: "first"})
+	for len(a.ch) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := a.SaveLog(context.Background(), &RequestLog{ID: "ok", StatusCode: 200}); err != nil {
+			t.Fatalf("success %d: %v", i, err)
+		}
+	}
+	// 3/4 full: successes are shed, errors and snapshots still fit.
+	if err := a.SaveLog(context.Background(), &RequestLog{ID: "ok", StatusCode: 200}); err != ErrAsyncQueueFull {
+		t.Fatalf("success over high water: err = %v", err)
+	}
+	if err := a.SaveLog(context.Background(), &RequestLog{ID: "err", StatusCode: 500}); err != nil {
+		t.Fatalf("error entry: %v", err)
+	}
+	if err := a.SaveLog(context.Background(), &RequestLog{ID: "snap"}); err != ErrAsyncQueueFull {
+		t.Fatalf("snapshot on full queue: err = %v", err)
+	}
+
+	stats := a.QueueStats()
+	want := DropCounts{Success: 1, Snapshot: 1}
+	if stats.Dropped != want || stats.Length != 4 || stats.Capacity != 4 || a.Dropped() != 2 {
+		t.Fatalf("stats = %+v, dropped = %d", stats, a.Dropped())
+	}
+
+	close(inner.gate)
+	_ = a.Close()
+}
@@ -0,0 +1,245 @@
+package storage
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+)
+
+// Backup archive layout (tar.gz):
+//
+//	prismcat.db     consistent database snapshot
+//	config.yaml     the config file as found on disk (optional)
+//	blobs/<hex>     every blob referenced by the snapshot
+//	manifest.json   BackupManifest, written last
+const (
+	backupDBName       = "prismcat.db"
+	backupConfigName   = "config.yaml"
+	backupBlobPrefix   = "blobs/"
+	backupManifestName = "manifest.json"
+)
+
+// BackupManifest describes a backup archive.
+type BackupManifest struct {
+	Version   string    `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	DBBytes   int64     `json:"db_bytes"`
+	Blobs     int       `json:"blobs"`
+	BlobBytes int64     `json:"blob_bytes"`
+	// MissingBlobs counts refs whose blob was already gone; those logs keep
+	// only their preview after a restore.
+	MissingBlobs int  `json:"missing_blobs"`
+	Config       bool `json:"config"`
+}
+
+// WriteBackup writes a tar.gz backup of the database, the blobs it references
+// and the config file at configPath ("" skips the config). It is safe to run
+// while the proxy is writing logs.
+func WriteBackup(ctx context.Context, w io.Writer, repo Repository, blobs BlobStore, configPath string) (*BackupManifest, error) {
+	tmpDir, err := os.MkdirTemp("", "prismcat-backup-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, backupDBName)
+	if err := repo.Snapshot(ctx, dbPath); err != nil {
+		return nil, fmt.Errorf("snapshot database: %w", err)
+	}
+	// Take refs from the snapshot itself so blobs match the archived logs.
+	snap, err := NewSQLiteRepository(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	refs, err := snap.ListBlobRefs(ctx)
+	_ = snap.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &BackupManifest{Version: config.Version, CreatedAt: time.Now()}
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	// The database can be large: stream it rather than reading it whole.
+	db, err := os.Open(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	info, err := db.Stat()
+	if err != nil {
+		return nil, err
+	}
+	manifest.DBBytes = info.Size()
+	if err := tw.WriteHeader(&tar.Header{Name: backupDBName, Mode: 0644, Size: info.Size(), ModTime: manifest.CreatedAt}); err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(tw, db); err != nil {
+		return nil, err
+	}
+
+	if configPath != "" {
+		if data, err := os.ReadFile(configPath); err == nil {
+			if err := writeTarFile(tw, backupConfigName, data); err != nil {
+				return nil, err
+			}
+			manifest.Config = true
+		}
+	}
+
+	if blobs != nil {
+		for _, ref := range refs {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			_, hexHash, err := parseBlobRef(ref)
+			if err != nil {
+				continue
+			}
+			data, err := blobs.Get(ctx, ref)
+			if errors.Is(err, ErrBlobNotFound) {
+				manifest.MissingBlobs++
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("read blob %s: %w", ref, err)
+			}
+			if err := writeTarFile(tw, backupBlobPrefix+hexHash, data); err != nil {
+				return nil, err
+			}
+			manifest.Blobs++
+			manifest.BlobBytes += int64(len(data))
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeTarFile(tw, backupManifestName, data); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, gz.Close()
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: time.Now()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// RestoreBackup restores an archive written by WriteBackup. The database at
+// dbPath is replaced, so PrismCat must not be running. Blobs are re-hashed on
+// the way in. When configPath is set and the archive has a config, the
+// current file is kept as configPath+".bak" and replaced.
+func RestoreBackup(r io.Reader, dbPath string, blobs BlobStore, configPath string) (*BackupManifest, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a backup archive: %w", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	ctx := context.Background()
+	tmpDB := dbPath + ".restoring"
+	defer os.Remove(tmpDB)
+
+	var manifest BackupManifest
+	var haveDB bool
+	var configData []byte
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case hdr.Name == backupDBName:
+			if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
+				return nil, err
+			}
+			f, err := os.Create(tmpDB)
+			if err != nil {
+				return nil, err
+			}
+			_, err = io.Copy(f, tr)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return nil, err
+			}
+			haveDB = true
+
+		case hdr.Name == backupConfigName:
+			if configData, err = io.ReadAll(tr); err != nil {
+				return nil, err
+			}
+
+		case strings.HasPrefix(hdr.Name, backupBlobPrefix):
+			if blobs == nil {
+				continue
+			}
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+			ref, err := blobs.Put(ctx, data)
+			if err != nil {
+				return nil, fmt.Errorf("restore blob %s: %w", hdr.Name, err)
+			}
+			if _, hexHash, _ := parseBlobRef(ref); hexHash != strings.TrimPrefix(hdr.Name, backupBlobPrefix) {
+				return nil, fmt.Errorf("blob %s is corrupt", hdr.Name)
+			}
+
+		case hdr.Name == backupManifestName:
+			if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+				return nil, fmt.Errorf("read manifest: %w", err)
+			}
+		}
+	}
+	if !haveDB {
+		return nil, errors.New("backup archive has no database")
+	}
+
+	// Stale WAL/SHM files would be replayed over the restored database.
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(dbPath + suffix); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	if err := os.Rename(tmpDB, dbPath); err != nil {
+		return nil, err
+	}
+
+	if configPath != "" && configData != nil {
+		if old, err := os.ReadFile(configPath); err == nil {
+			if err := os.WriteFile(configPath+".bak", old, 0644); err != nil {
+				return nil, err
+			}
+		}
+		if err := os.WriteFile(configPath, configData, 0644); err != nil {
+			return nil, err
+		}
+	}
+	return &manifest, nil
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBackupRestoreRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestSQLite(t)
+	blobs, err := NewFileBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := blobs.Put(ctx, []byte("detached body"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.SaveLog(context.Background(), &RequestLog{ID: "log-1", CreatedAt: time.Now(), Upstream: "openai", Method: "POST", Path: "/v1/chat", RequestBodyRef: ref})
+	if err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configPath, []byte("server:\n  port: 9090\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var archive bytes.Buffer
+	manifest, err := WriteBackup(ctx, &archive, repo, blobs, configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Blobs != 1 || !manifest.Config || manifest.DBBytes == 0 {
+		t.Fatalf("manifest = %+v", manifest)
+	}
+
+	restoreDir := t.TempDir()
+	dbPath := filepath.Join(restoreDir, "prismcat.db")
+	restoredBlobs, err := NewFileBlobStore(filepath.Join(restoreDir, "blobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	restoredConfig := filepath.Join(restoreDir, "config.yaml")
+	if _, err := RestoreBackup(&archive, dbPath, restoredBlobs, restoredConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := NewSQLiteRepository(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	if l, err := restored.GetLog(context.Background(), "log-1"); err != nil || l.RequestBodyRef != ref {
+		t.Fatalf("restored log = %+v, err = %v", l, err)
+	}
+	if data, err := restoredBlobs.Get(ctx, ref); err != nil || string(data) != "detached body" {
+		t.Fatalf("restored blob = %q, err = %v", data, err)
+	}
+	if data, _ := os.ReadFile(restoredConfig); string(data) != "server:\n  port: 9090\n" {
+		t.Fatalf("restored config = %q", data)
+	}
+}
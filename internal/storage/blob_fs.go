@@ -37,19 +37,31 @@ func (s *FileBlobStore) Put(ctx context.Context, data []byte) (string, error) {
 	ref := newSHA256Ref(sum)
 	_, hexHash, _ := parseBlobRef(ref)
 
+	if err := s.writeFile(hexHash, data); err != nil {
+		return "", err
+	}
+	return ref, nil
+}
+
+// writeFile stores data under hexHash unless a blob is already there. A
+// blob reused that way gets a fresh mtime, so that GarbageCollect and
+// EnforceBlobQuota, which spare recently written files, spare it too.
+func (s *FileBlobStore) writeFile(hexHash string, data []byte) error {
 	finalPath := s.pathFor(hexHash)
 	if _, err := os.Stat(finalPath); err == nil {
-		return ref, nil
+		now := time.Now()
+		_ = os.Chtimes(finalPath, now, now)
+		return nil
 	}
 
 	dir := filepath.Dir(finalPath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
-		return "", err
+		return err
 	}
 
 	tmpPath := filepath.Join(dir, ".tmp-"+hexHash+"-"+strconv.FormatInt(time.Now().UnixNano(), 10))
 	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
-		return "", err
+		return err
 	}
 
 	// Rename is atomic on the same filesystem.
@@ -57,13 +69,12 @@ func (s *FileBlobStore) Put(ctx context.Context, data []byte) (string, error) {
 		// If another writer won the race, keep the existing blob.
 		if _, statErr := os.Stat(finalPath); statErr == nil {
 			_ = os.Remove(tmpPath)
-			return ref, nil
+			return nil
 		}
 		_ = os.Remove(tmpPath)
-		return "", fmt.Errorf("store blob: %w", err)
+		return fmt.Errorf("store blob: %w", err)
 	}
-
-	return ref, nil
+	return nil
 }
 
 func (s *FileBlobStore) Get(ctx context.Context, ref string) ([]byte, error) {
@@ -99,10 +110,33 @@ func (s *FileBlobStore) Exists(ctx context.Context, ref string) (bool, error) {
 	return false, err
 }
 
+// Delete removes a blob. Deleting a missing blob is not an error.
+func (s *FileBlobStore) Delete(ctx context.Context, ref string) error {
+	_ = ctx
+	_, hexHash, err := parseBlobRef(ref)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(s.pathFor(hexHash)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// GCReport describes one GarbageCollect run.
+type GCReport struct {
+	// Scanned is the number of blob files examined.
+	Scanned int `json:"scanned"`
+	// Referenced is the number of distinct refs the logs still point to.
+	Referenced     int   `json:"referenced"`
+	Deleted        int   `json:"deleted"`
+	ReclaimedBytes int64 `json:"reclaimed_bytes"`
+}
+
 // GarbageCollect removes unreferenced blob files.
 // referencedRefs should contain canonical refs stored in the log table (e.g. "sha256:<hex>").
 // minAge avoids deleting blobs created very recently (to reduce races with in-flight log writes).
-func (s *FileBlobStore) GarbageCollect(ctx context.Context, referencedRefs []string, minAge time.Duration) (int, error) {
+func (s *FileBlobStore) GarbageCollect(ctx context.Context, referencedRefs []string, minAge time.Duration) (*GCReport, error) {
 	_ = ctx
 
 	referenced := make(map[string]struct{}, len(referencedRefs))
@@ -113,13 +147,13 @@ func (s *FileBlobStore) GarbageCollect(ctx context.Context, referencedRefs []str
 		}
 		referenced[hexHash] = struct{}{}
 	}
+	report := &GCReport{Referenced: len(referenced)}
 
 	var cutoff time.Time
 	if minAge > 0 {
 		cutoff = time.Now().Add(-minAge)
 	}
 
-	deleted := 0
 	err := filepath.WalkDir(s.baseDir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
@@ -138,24 +172,27 @@ func (s *FileBlobStore) GarbageCollect(ctx context.Context, referencedRefs []str
 		if _, err := hex.DecodeString(name); err != nil {
 			return nil
 		}
+		report.Scanned++
 		if _, ok := referenced[name]; ok {
 			return nil
 		}
 
-		if !cutoff.IsZero() {
-			info, err := d.Info()
-			if err == nil && info.ModTime().After(cutoff) {
-				return nil
-			}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if !cutoff.IsZero() && info.ModTime().After(cutoff) {
+			return nil
 		}
 
 		if err := os.Remove(path); err == nil {
-			deleted++
+			report.Deleted++
+			report.ReclaimedBytes += info.Size()
 		}
 		return nil
 	})
 	if err != nil {
-		return deleted, err
+		return report, err
 	}
 
 	// Best-effort: remove empty prefix directories.
@@ -169,7 +206,31 @@ func (s *FileBlobStore) GarbageCollect(ctx context.Context, referencedRefs []str
 		}
 	}
 
-	return deleted, nil
+	return report, nil
+}
+
+// Stats counts blob files and their total size.
+func (s *FileBlobStore) Stats(ctx context.Context) (BlobStats, error) {
+	var stats BlobStats
+	err := filepath.WalkDir(s.baseDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".tmp-") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		stats.Count++
+		stats.Bytes += info.Size()
+		return nil
+	})
+	return stats, err
 }
 
 func (s *FileBlobStore) pathFor(hexHash string) string {
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileBlobStoreGarbageCollectReport(t *testing.T) {
+	ctx := context.Background()
+	blobs, err := NewFileBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keep, err := blobs.Put(ctx, []byte("still referenced"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	orphan, err := blobs.Put(ctx, []byte("orphaned"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := blobs.GarbageCollect(ctx, []string{keep, keep}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := GCReport{Scanned: 2, Referenced: 1, Deleted: 1, ReclaimedBytes: int64(len("orphaned"))}
+	if *report != want {
+		t.Fatalf("report = %+v, want %+v", *report, want)
+	}
+	if ok, _ := blobs.Exists(ctx, orphan); ok {
+		t.Fatal("orphan not deleted")
+	}
+	if ok, _ := blobs.Exists(ctx, keep); !ok {
+		t.Fatal("referenced blob deleted")
+	}
+}
+
+func TestEnforceBlobQuotaEvictsLeastRecentlyReferenced(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestSQLite(t)
+	blobs, err := NewFileBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	oldRef, _ := blobs.Put(ctx, []byte(strings.Repeat("o", 100)))
+	newRef, _ := blobs.Put(ctx, []byte(strings.Repeat("n", 100)))
+	for _, l := range []*RequestLog{
+		{ID: "old", CreatedAt: now.Add(-2 * time.Hour), RequestBody: "ooo", RequestBodyRef: oldRef, Flags: []string{FlagStreamMerged}},
+		{ID: "new", CreatedAt: now.Add(-time.Hour), ResponseBody: "nnn", ResponseBodyRef: newRef},
+	} {
+		l.Upstream, l.Method, l.Path = "openai", "POST", "/v1/chat"
+		if err := repo.SaveLog(context.Background(), l); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := EnforceBlobQuota(ctx, repo, blobs, 150, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.TotalBytes != 200 || report.Evicted != 1 || report.ReclaimedBytes != 100 || report.LogsUpdated != 1 {
+		t.Fatalf("report = %+v", report)
+	}
+	if ok, _ := blobs.Exists(ctx, oldRef); ok {
+		t.Fatal("least recently referenced blob kept")
+	}
+	old, _ := repo.GetLog(context.Background(), "old")
+	if old.RequestBodyRef != "" || old.RequestBody != "ooo" || !old.HasFlag(FlagBodyEvicted) || !old.HasFlag(FlagStreamMerged) {
+		t.Fatalf("old log = ref %q body %q flags %v", old.RequestBodyRef, old.RequestBody, old.Flags)
+	}
+	if recent, _ := repo.GetLog(context.Background(), "new"); recent.ResponseBodyRef != newRef || recent.HasFlag(FlagBodyEvicted) {
+		t.Fatalf("new log = ref %q flags %v", recent.ResponseBodyRef, recent.Flags)
+	}
+}
+
+func TestEnforceBlobQuotaSparesRecentBlobs(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestSQLite(t)
+	blobs, err := NewFileBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().Add(-2 * time.Hour)
+	staleData, reusedData := []byte(strings.Repeat("s", 100)), []byte(strings.Repeat("r", 100))
+	staleRef, _ := blobs.Put(ctx, staleData)
+	reusedRef, _ := blobs.Put(ctx, reusedData)
+	for _, ref := range []string{staleRef, reusedRef} {
+		_, hexHash, _ := parseBlobRef(ref)
+		if err := os.Chtimes(blobs.pathFor(hexHash), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A new log stores the same body again: the existing blob is reused.
+	if ref, _ := blobs.Put(ctx, reusedData); ref != reusedRef {
+		t.Fatalf("reused ref = %q", ref)
+	}
+	l := &RequestLog{ID: "reuse", CreatedAt: old, Upstream: "openai", Method: "POST", Path: "/v1/chat", ResponseBodyRef: reusedRef}
+	if err := repo.SaveLog(ctx, l); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := EnforceBlobQuota(ctx, repo, blobs, 50, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Evicted != 1 || report.LogsUpdated != 0 {
+		t.Fatalf("report = %+v", report)
+	}
+	if ok, _ := blobs.Exists(ctx, staleRef); ok {
+		t.Fatal("stale blob kept")
+	}
+	if ok, _ := blobs.Exists(ctx, reusedRef); !ok {
+		t.Fatal("recently reused blob evicted")
+	}
+	if got, _ := repo.GetLog(ctx, "reuse"); got.ResponseBodyRef != reusedRef || got.HasFlag(FlagBodyEvicted) {
+		t.Fatalf("log = ref %q flags %v", got.ResponseBodyRef, got.Flags)
+	}
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+)
+
+// MemoryBlobStore keeps blobs in process memory, for ephemeral sessions
+// where nothing may touch disk. Once the store would exceed maxBytes, the
+// oldest blobs are dropped first; logs pointing to them keep their preview.
+type MemoryBlobStore struct {
+	maxBytes int64
+
+	mu    sync.Mutex
+	blobs map[string][]byte
+	order []string // insertion order, oldest first
+	size  int64
+}
+
+// NewMemoryBlobStore creates an in-memory store capped at maxBytes
+// (<= 0: unlimited).
+func NewMemoryBlobStore(maxBytes int64) *MemoryBlobStore {
+	return &MemoryBlobStore{maxBytes: maxBytes, blobs: make(map[string][]byte)}
+}
+
+func (s *MemoryBlobStore) Put(ctx context.Context, data []byte) (string, error) {
+	_ = ctx
+
+	ref := newSHA256Ref(sha256.Sum256(data))
+	size := int64(len(data))
+	if s.maxBytes > 0 && size > s.maxBytes {
+		return "", fmt.Errorf("blob of %d bytes exceeds the in-memory store cap (%d bytes)", size, s.maxBytes)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.blobs[ref]; ok {
+		return ref, nil
+	}
+	for s.maxBytes > 0 && s.size+size > s.maxBytes && len(s.order) > 0 {
+		oldest := s.order[0]
+		s.order = s.order[1:]
+		s.size -= int64(len(s.blobs[oldest]))
+		delete(s.blobs, oldest)
+	}
+	// Callers may reuse data; keep a private copy.
+	s.blobs[ref] = append([]byte(nil), data...)
+	s.order = append(s.order, ref)
+	s.size += size
+	return ref, nil
+}
+
+func (s *MemoryBlobStore) Get(ctx context.Context, ref string) ([]byte, error) {
+	_ = ctx
+
+	key, err := canonicalRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.blobs[key]
+	if !ok {
+		return nil, ErrBlobNotFound
+	}
+	return data, nil
+}
+
+func (s *MemoryBlobStore) Exists(ctx context.Context, ref string) (bool, error) {
+	_ = ctx
+
+	key, err := canonicalRef(ref)
+	if err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.blobs[key]
+	return ok, nil
+}
+
+// Delete removes a blob. Deleting a missing blob is not an error.
+func (s *MemoryBlobStore) Delete(ctx context.Context, ref string) error {
+	_ = ctx
+
+	key, err := canonicalRef(ref)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.blobs[key]
+	if !ok {
+		return nil
+	}
+	delete(s.blobs, key)
+	s.size -= int64(len(data))
+	for i, r := range s.order {
+		if r == key {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// Size returns the bytes currently held.
+func (s *MemoryBlobStore) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// canonicalRef normalizes the accepted ref spellings to "sha256:<hex>".
+func canonicalRef(ref string) (string, error) {
+	_, hexHash, err := parseBlobRef(ref)
+	if err != nil {
+		return "", err
+	}
+	return "sha256:" + hexHash, nil
+}
@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMemoryBlobStoreEvictsOldest(t *testing.T) {
+	ctx := context.Background()
+	blobs := NewMemoryBlobStore(10)
+
+	first, err := blobs.Put(ctx, []byte("aaaaaa"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := blobs.Put(ctx, []byte("bbbbbb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blobs.Get(ctx, first); !errors.Is(err, ErrBlobNotFound) {
+		t.Fatalf("oldest blob should be evicted, got %v", err)
+	}
+	data, err := blobs.Get(ctx, "blob://"+strings.ToUpper(strings.TrimPrefix(second, "sha256:")))
+	if err != nil || string(data) != "bbbbbb" {
+		t.Fatalf("Get = %q, %v", data, err)
+	}
+	if got := blobs.Size(); got != 6 {
+		t.Fatalf("Size = %d, want 6", got)
+	}
+	if _, err := blobs.Put(ctx, []byte("too large for the cap")); err == nil {
+		t.Fatal("expected error for a blob over the cap")
+	}
+}
+
+func TestMemorySQLiteTrimToSize(t *testing.T) {
+	repo, err := NewMemorySQLiteRepository()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+
+	body := strings.Repeat("x", 4096)
+	for i := 0; i < 300; i++ {
+		if err := repo.SaveLog(context.Background(), &RequestLog{Upstream: "u", Method: "GET", Path: "/", TargetURL: "http://x/", ResponseBody: body}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deleted, err := repo.TrimToSize(512 << 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted == 0 {
+		t.Fatal("expected logs to be trimmed")
+	}
+	_, total, err := repo.ListLogs(context.Background(), LogFilter{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total+deleted != 300 {
+		t.Fatalf("total %d + deleted %d != 300", total, deleted)
+	}
+	if again, err := repo.TrimToSize(512 << 10); err != nil || again != 0 {
+		t.Fatalf("second trim = %d, %v", again, err)
+	}
+}
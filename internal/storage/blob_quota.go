@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// QuotaReport describes one EnforceBlobQuota run.
+type QuotaReport struct {
+	TotalBytes     int64 `json:"total_bytes"`
+	Evicted        int   `json:"evicted"`
+	ReclaimedBytes int64 `json:"reclaimed_bytes"`
+	// LogsUpdated counts body refs cleared on logs (a log can lose both).
+	LogsUpdated int64 `json:"logs_updated"`
+}
+
+// quotaLowWater is the fraction of maxBytes eviction shrinks the store to, so
+// the next few detached bodies don't immediately trigger another run.
+const quotaLowWater = 0.9
+
+// EnforceBlobQuota evicts the least-recently-referenced blobs once the store
+// holds more than maxBytes. A blob's recency is the newest log pointing to it;
+// unreferenced blobs use their file time. Logs that pointed to an evicted blob
+// lose the ref and get FlagBodyEvicted. As in GarbageCollect, blobs written
+// (or reused, see writeFile) within minAge are kept, since a log being saved
+// may point to them after the refs are cleared.
+func EnforceBlobQuota(ctx context.Context, repo *SQLiteRepository, blobs *FileBlobStore, maxBytes int64, minAge time.Duration) (*QuotaReport, error) {
+	report := &QuotaReport{}
+	if maxBytes <= 0 {
+		return report, nil
+	}
+	var cutoff time.Time
+	if minAge > 0 {
+		cutoff = time.Now().Add(-minAge)
+	}
+	recent := func(modTime time.Time) bool { return !cutoff.IsZero() && modTime.After(cutoff) }
+
+	type blobFile struct {
+		hexHash  string
+		size     int64
+		modTime  time.Time
+		lastUsed time.Time
+	}
+	var files []blobFile
+	err := filepath.WalkDir(blobs.baseDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name := d.Name()
+		if d.IsDir() || len(name) != sha256.Size*2 {
+			return nil
+		}
+		if _, err := hex.DecodeString(name); err != nil {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		files = append(files, blobFile{hexHash: name, size: info.Size(), modTime: info.ModTime(), lastUsed: info.ModTime()})
+		report.TotalBytes += info.Size()
+		return nil
+	})
+	if err != nil || report.TotalBytes <= maxBytes {
+		return report, err
+	}
+
+	lastUsed, err := repo.BlobRefLastUsed(ctx)
+	if err != nil {
+		return report, err
+	}
+	index := make(map[string]int, len(files))
+	for i, f := range files {
+		index[f.hexHash] = i
+	}
+	for ref, t := range lastUsed {
+		_, hexHash, err := parseBlobRef(ref)
+		if err != nil {
+			continue
+		}
+		if i, ok := index[hexHash]; ok && t.After(files[i].lastUsed) {
+			files[i].lastUsed = t
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].lastUsed.Before(files[j].lastUsed) })
+
+	target := int64(float64(maxBytes) * quotaLowWater)
+	remaining := report.TotalBytes
+	var evict []blobFile
+	for _, f := range files {
+		if remaining <= target {
+			break
+		}
+		if recent(f.modTime) {
+			continue
+		}
+		evict = append(evict, f)
+		remaining -= f.size
+	}
+
+	// Clear refs first so no log points at a missing blob.
+	refs := make([]string, len(evict))
+	for i, f := range evict {
+		refs[i] = "sha256:" + f.hexHash
+	}
+	if report.LogsUpdated, err = repo.ClearBlobRefs(ctx, refs, FlagBodyEvicted); err != nil {
+		return report, err
+	}
+	for _, f := range evict {
+		path := blobs.pathFor(f.hexHash)
+		// Reused since the walk: a log saved meanwhile may point to it.
+		if info, err := os.Stat(path); err == nil && recent(info.ModTime()) {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			continue
+		}
+		report.Evicted++
+		report.ReclaimedBytes += f.size
+	}
+	return report, nil
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// setChecks records a log's response check results, replacing earlier
+// results of the same checks.
+func setChecks(ctx context.Context, tx *sql.Tx, id string, checks map[string]bool) error {
+	for name, passed := range checks {
+		if _, err := tx.ExecContext(ctx, "INSERT OR REPLACE INTO log_checks (log_id, name, passed) VALUES (?, ?, ?)", id, name, passed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getChecks reads a log's response check results, nil when it has none.
+func (r *SQLiteRepository) getChecks(ctx context.Context, id string) (map[string]bool, error) {
+	rows, err := r.reader().QueryContext(ctx, "SELECT name, passed FROM log_checks WHERE log_id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var checks map[string]bool
+	for rows.Next() {
+		var name string
+		var passed bool
+		if err := rows.Scan(&name, &passed); err != nil {
+			return nil, err
+		}
+		if checks == nil {
+			checks = make(map[string]bool)
+		}
+		checks[name] = passed
+	}
+	return checks, rows.Err()
+}
+
+func (r *SQLiteRepository) GetCheckStats(ctx context.Context, upstream string, since *time.Time) ([]CheckStat, error) {
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
+	where := "WHERE 1 = 1"
+	var args []interface{}
+	if upstream != "" {
+		where += " AND l.upstream = ?"
+		args = append(args, upstream)
+	}
+	if since != nil {
+		where += " AND l.created_at >= ?"
+		args = append(args, *since)
+	}
+
+	rows, err := r.reader().QueryContext(ctx, fmt.Sprintf(`
+	SELECT l.upstream, c.name,
+		SUM(CASE WHEN c.passed THEN 1 ELSE 0 END),
+		SUM(CASE WHEN c.passed THEN 0 ELSE 1 END)
+	FROM log_checks c JOIN request_logs l ON l.id = c.log_id
+	%s
+	GROUP BY l.upstream, c.name
+	ORDER BY l.upstream, c.name
+	`, where), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []CheckStat{}
+	for rows.Next() {
+		var st CheckStat
+		if err := rows.Scan(&st.Upstream, &st.Name, &st.Passed, &st.Failed); err != nil {
+			return nil, err
+		}
+		stats = append(stats, st)
+	}
+	return stats, rows.Err()
+}
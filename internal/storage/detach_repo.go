@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
 	"log"
 	"strings"
 	"time"
@@ -9,6 +10,7 @@ import (
 	"unsafe"
 
 	"github.com/prismcat/prismcat/internal/config"
+	"github.com/prismcat/prismcat/internal/llm"
 )
 
 // DetachingRepository detaches large bodies into a BlobStore before persisting logs.
@@ -20,6 +22,7 @@ type DetachingRepository struct {
 	inner Repository
 	blobs BlobStore
 	cfg   *config.Config
+	disk  *DiskGuard
 }
 
 func NewDetachingRepository(inner Repository, blobs BlobStore, cfg *config.Config) *DetachingRepository {
@@ -30,22 +33,34 @@ func NewDetachingRepository(inner Repository, blobs BlobStore, cfg *config.Confi
 	}
 }
 
-func (r *DetachingRepository) SaveLog(logEntry *RequestLog) error {
+// SetDiskGuard makes SaveLog store metadata only while the guard reports low
+// disk space.
+func (r *DetachingRepository) SetDiskGuard(g *DiskGuard) {
+	r.disk = g
+}
+
+func (r *DetachingRepository) SaveLog(ctx context.Context, logEntry *RequestLog) error {
+	if logEntry != nil && r.disk.Low() {
+		dropBodies(logEntry)
+		return r.inner.SaveLog(ctx, logEntry)
+	}
 	if r.blobs == nil || r.cfg == nil {
-		return r.inner.SaveLog(logEntry)
+		return r.inner.SaveLog(ctx, logEntry)
 	}
 	if logEntry == nil {
-		return r.inner.SaveLog(logEntry)
+		return r.inner.SaveLog(ctx, logEntry)
 	}
 
 	logging := r.cfg.LoggingSnapshot()
 	detachOver := logging.DetachBodyOverBytes
 	if detachOver <= 0 {
-		return r.inner.SaveLog(logEntry)
+		return r.inner.SaveLog(ctx, logEntry)
 	}
 	previewBytes := logging.BodyPreviewBytes
 
-	ctx := context.Background()
+	if logEntry.Streaming && logEntry.ResponseBodyRef == "" && logEntry.ResponseBody != "" {
+		r.storeMergedStream(ctx, logEntry, detachOver)
+	}
 
 	if logEntry.RequestBodyRef == "" && int64(len(logEntry.RequestBody)) > detachOver {
 		ref, err := r.blobs.Put(ctx, stringBytes(logEntry.RequestBody))
@@ -69,7 +84,49 @@ func (r *DetachingRepository) SaveLog(logEntry *RequestLog) error {
 		}
 	}
 
-	return r.inner.SaveLog(logEntry)
+	return r.inner.SaveLog(ctx, logEntry)
+}
+
+// storeMergedStream keeps a streaming response both ways: the raw stream goes
+// to the blob store and the reassembled JSON replaces the inline body, so the
+// default view is readable while the original bytes stay available. Streams
+// that can't be reassembled, or whose merged form is itself over the detach
+// threshold, are left to the regular detaching below.
+func (r *DetachingRepository) storeMergedStream(ctx context.Context, logEntry *RequestLog, detachOver int64) {
+	var contentType string
+	for k, vv := range logEntry.ResponseHeaders {
+		if strings.EqualFold(k, "Content-Type") && len(vv) > 0 {
+			contentType = vv[0]
+		}
+	}
+	events := llm.ParseStream(contentType, stringBytes(logEntry.ResponseBody))
+	merged, _, ok := llm.MergeStream(events)
+	if !ok {
+		return
+	}
+	data, err := json.Marshal(merged)
+	if err != nil || int64(len(data)) > detachOver {
+		return
+	}
+
+	ref, err := r.blobs.Put(ctx, stringBytes(logEntry.ResponseBody))
+	if err != nil {
+		log.Printf("blob put (raw stream) failed: %v", err)
+		return
+	}
+	logEntry.ResponseBodyRef = ref
+	logEntry.ResponseBody = string(data)
+	logEntry.AddFlag(FlagStreamMerged)
+}
+
+// dropBodies keeps a log's metadata only. Sizes stay as captured.
+func dropBodies(logEntry *RequestLog) {
+	if logEntry.RequestBody == "" && logEntry.ResponseBody == "" {
+		return
+	}
+	logEntry.RequestBody, logEntry.ResponseBody = "", ""
+	logEntry.Truncated = true
+	logEntry.AddFlag(FlagDiskLow)
 }
 
 func truncateUTF8(s string, maxBytes int64) string {
@@ -109,20 +166,96 @@ func stringBytes(s string) []byte {
 	return unsafe.Slice(unsafe.StringData(s), len(s))
 }
 
-func (r *DetachingRepository) GetLog(id string) (*RequestLog, error) {
-	return r.inner.GetLog(id)
+func (r *DetachingRepository) GetLog(ctx context.Context, id string) (*RequestLog, error) {
+	return r.inner.GetLog(ctx, id)
+}
+
+func (r *DetachingRepository) GetLogMetadata(ctx context.Context, id string) (map[string]string, error) {
+	return r.inner.GetLogMetadata(ctx, id)
+}
+
+func (r *DetachingRepository) SetLogMetadata(ctx context.Context, id string, md map[string]string) error {
+	return r.inner.SetLogMetadata(ctx, id, md)
+}
+
+func (r *DetachingRepository) ListLogs(ctx context.Context, filter LogFilter) ([]*RequestLog, int64, error) {
+	return r.inner.ListLogs(ctx, filter)
+}
+
+func (r *DetachingRepository) ListReplays(ctx context.Context, logID string) ([]*Replay, error) {
+	return r.inner.ListReplays(ctx, logID)
+}
+
+func (r *DetachingRepository) SaveReplay(ctx context.Context, rp *Replay) error {
+	return r.inner.SaveReplay(ctx, rp)
+}
+
+func (r *DetachingRepository) DeleteLogsBefore(ctx context.Context, beforeTime time.Time) (int64, error) {
+	return r.inner.DeleteLogsBefore(ctx, beforeTime)
+}
+
+func (r *DetachingRepository) ClearBlobRefsBefore(ctx context.Context, before time.Time, flag string) (int64, error) {
+	return r.inner.ClearBlobRefsBefore(ctx, before, flag)
+}
+
+func (r *DetachingRepository) ReplaceBodies(ctx context.Context, l *RequestLog, flag string) error {
+	return r.inner.ReplaceBodies(ctx, l, flag)
+}
+
+func (r *DetachingRepository) DeleteLogs(ctx context.Context, ids []string) (int64, error) {
+	return r.inner.DeleteLogs(ctx, ids)
+}
+
+func (r *DetachingRepository) ScanLogContent(ctx context.Context, fn func(*RequestLog) error) error {
+	return r.inner.ScanLogContent(ctx, fn)
+}
+
+func (r *DetachingRepository) ListBlobRefs(ctx context.Context) ([]string, error) {
+	return r.inner.ListBlobRefs(ctx)
+}
+
+func (r *DetachingRepository) BlobRefsBefore(ctx context.Context, before time.Time) ([]string, int64, error) {
+	return r.inner.BlobRefsBefore(ctx, before)
+}
+
+func (r *DetachingRepository) ArchivableLogs(ctx context.Context, from, before time.Time, minInline int64, limit int) ([]*RequestLog, error) {
+	return r.inner.ArchivableLogs(ctx, from, before, minInline, limit)
+}
+
+func (r *DetachingRepository) GetStats(ctx context.Context, since *time.Time) (*LogStats, error) {
+	return r.inner.GetStats(ctx, since)
+}
+
+func (r *DetachingRepository) GetUpstreamStats(ctx context.Context, upstream string, since *time.Time) (*UpstreamStats, error) {
+	return r.inner.GetUpstreamStats(ctx, upstream, since)
+}
+
+func (r *DetachingRepository) GetPropertyStats(ctx context.Context, key string, since *time.Time, limit int) ([]PropertyStat, error) {
+	return r.inner.GetPropertyStats(ctx, key, since, limit)
+}
+
+func (r *DetachingRepository) GetCheckStats(ctx context.Context, upstream string, since *time.Time) ([]CheckStat, error) {
+	return r.inner.GetCheckStats(ctx, upstream, since)
+}
+
+func (r *DetachingRepository) GetTrafficStats(ctx context.Context, from, to, upstream string) ([]TrafficStat, error) {
+	return r.inner.GetTrafficStats(ctx, from, to, upstream)
+}
+
+func (r *DetachingRepository) GetHourlyRollups(ctx context.Context, from, to, upstream, model string) ([]HourlyRollup, error) {
+	return r.inner.GetHourlyRollups(ctx, from, to, upstream, model)
 }
 
-func (r *DetachingRepository) ListLogs(filter LogFilter) ([]*RequestLog, int64, error) {
-	return r.inner.ListLogs(filter)
+func (r *DetachingRepository) GetStorageStats(ctx context.Context) (*StorageStats, error) {
+	return r.inner.GetStorageStats(ctx)
 }
 
-func (r *DetachingRepository) DeleteLogsBefore(beforeTime time.Time) (int64, error) {
-	return r.inner.DeleteLogsBefore(beforeTime)
+func (r *DetachingRepository) GetUpstreamFootprints(ctx context.Context) ([]UpstreamFootprint, error) {
+	return r.inner.GetUpstreamFootprints(ctx)
 }
 
-func (r *DetachingRepository) GetStats(since *time.Time) (*LogStats, error) {
-	return r.inner.GetStats(since)
+func (r *DetachingRepository) Snapshot(ctx context.Context, dstPath string) error {
+	return r.inner.Snapshot(ctx, dstPath)
 }
 
 func (r *DetachingRepository) Close() error {
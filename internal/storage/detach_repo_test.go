@@ -43,7 +43,7 @@ func TestDetachingRepositoryDetachesLargeBodies(t *testing.T) {
 		ResponseBody: "abcd",       // 4 bytes
 	}
 
-	if err := repo.SaveLog(entry); err != nil {
+	if err := repo.SaveLog(context.Background(), entry); err != nil {
 		t.Fatalf("SaveLog failed: %v", err)
 	}
 
@@ -90,7 +90,7 @@ func TestDetachingRepositoryTruncateUTF8DoesNotSplitRunes(t *testing.T) {
 		RequestBody: full,
 	}
 
-	if err := repo.SaveLog(entry); err != nil {
+	if err := repo.SaveLog(context.Background(), entry); err != nil {
 		t.Fatalf("SaveLog failed: %v", err)
 	}
 
@@ -119,3 +119,74 @@ func TestDetachingRepositoryTruncateUTF8DoesNotSplitRunes(t *testing.T) {
 		t.Fatalf("RequestBody preview length = %d, want <= %d", len(saved.RequestBody), cfg.Logging.BodyPreviewBytes)
 	}
 }
+
+func TestDetachingRepositoryStoresMergedStream(t *testing.T) {
+	inner := &memRepo{}
+	blobs := &memBlobStore{}
+
+	cfg := &config.Config{}
+	cfg.Logging.DetachBodyOverBytes = 1 << 20
+	cfg.Logging.BodyPreviewBytes = 4
+
+	repo := NewDetachingRepository(inner, blobs, cfg)
+
+	raw := "data: {\"object\":\"chat.completion.chunk\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hi\"}}]}\n\ndata: [DONE]\n\n"
+	entry := &RequestLog{
+		ID:              "id",
+		Streaming:       true,
+		ResponseHeaders: map[string][]string{"Content-Type": {"text/event-stream"}},
+		ResponseBody:    raw,
+	}
+	if err := repo.SaveLog(context.Background(), entry); err != nil {
+		t.Fatalf("SaveLog failed: %v", err)
+	}
+
+	if blobs.puts != 1 || string(blobs.data[0]) != raw {
+		t.Fatalf("raw stream not stored as blob: puts=%d", blobs.puts)
+	}
+	saved := inner.logs[0]
+	if saved.ResponseBodyRef == "" || !saved.HasFlag(FlagStreamMerged) {
+		t.Fatalf("ref = %q, flags = %v", saved.ResponseBodyRef, saved.Flags)
+	}
+	if !strings.Contains(saved.ResponseBody, `"content":"Hi"`) || strings.Contains(saved.ResponseBody, "data:") {
+		t.Fatalf("ResponseBody = %q, want merged JSON", saved.ResponseBody)
+	}
+}
+
+func TestDetachingRepositoryLowDiskStoresMetadataOnly(t *testing.T) {
+	inner := &memRepo{}
+	blobs := &memBlobStore{}
+
+	cfg := &config.Config{}
+	cfg.Logging.DetachBodyOverBytes = 8
+	cfg.Storage.Database = "data/prismcat.db"
+	cfg.Storage.MinFreeDiskMB = 100
+
+	free := int64(50 << 20)
+	guard := NewDiskGuard(cfg)
+	guard.freeSpace = func(string) (int64, error) { return free, nil }
+	if !guard.Check().Low {
+		t.Fatal("guard not low at 50MB free")
+	}
+
+	repo := NewDetachingRepository(inner, blobs, cfg)
+	repo.SetDiskGuard(guard)
+	if err := repo.SaveLog(context.Background(), &RequestLog{ID: "id", RequestBody: "0123456789", RequestBodySize: 10}); err != nil {
+		t.Fatal(err)
+	}
+	got := inner.logs[0]
+	if blobs.puts != 0 || got.RequestBody != "" || got.RequestBodySize != 10 || !got.HasFlag(FlagDiskLow) {
+		t.Fatalf("puts = %d, stored = %+v", blobs.puts, got)
+	}
+
+	free = 200 << 20
+	if guard.Check().Low {
+		t.Fatal("guard still low at 200MB free")
+	}
+	if err := repo.SaveLog(context.Background(), &RequestLog{ID: "id2", RequestBody: "0123456789"}); err != nil {
+		t.Fatal(err)
+	}
+	if blobs.puts != 1 {
+		t.Fatalf("puts = %d after recovery, want 1", blobs.puts)
+	}
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+)
+
+// DiskStatus is the last free-space reading of a DiskGuard.
+type DiskStatus struct {
+	// FreeBytes is the lowest free space across the watched volumes.
+	FreeBytes    int64     `json:"free_bytes"`
+	MinFreeBytes int64     `json:"min_free_bytes"`
+	Low          bool      `json:"low"`
+	CheckedAt    time.Time `json:"checked_at"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// DiskGuard watches free space on the volumes holding the database and blob
+// store. While it reports Low, DetachingRepository drops bodies and stores
+// metadata only, so a full disk can't corrupt the database.
+type DiskGuard struct {
+	cfg *config.Config
+
+	mu     sync.RWMutex
+	status DiskStatus
+
+	// freeSpace is swapped in tests.
+	freeSpace func(dir string) (int64, error)
+}
+
+// NewDiskGuard creates a guard for cfg.Storage's database and blob dirs. The
+// threshold (storage.min_free_disk_mb) is re-read on every check.
+func NewDiskGuard(cfg *config.Config) *DiskGuard {
+	return &DiskGuard{cfg: cfg, freeSpace: diskFreeBytes}
+}
+
+// Check reads free space now and logs when the guard trips or recovers.
+func (g *DiskGuard) Check() DiskStatus {
+	storageCfg := g.cfg.StorageSnapshot()
+	next := DiskStatus{MinFreeBytes: storageCfg.MinFreeDiskMB << 20, CheckedAt: time.Now(), FreeBytes: -1}
+
+	dirs := []string{filepath.Dir(storageCfg.Database)}
+	if storageCfg.BlobStore == "fs" && storageCfg.BlobDir != "" {
+		dirs = append(dirs, storageCfg.BlobDir)
+	}
+	for _, dir := range dirs {
+		free, err := g.freeSpace(dir)
+		if err != nil {
+			// Keep logging bodies when free space can't be read.
+			next.Error = err.Error()
+			continue
+		}
+		if next.FreeBytes < 0 || free < next.FreeBytes {
+			next.FreeBytes = free
+		}
+	}
+	next.Low = next.MinFreeBytes > 0 && next.FreeBytes >= 0 && next.FreeBytes < next.MinFreeBytes
+
+	g.mu.Lock()
+	prev := g.status
+	g.status = next
+	g.mu.Unlock()
+
+	switch {
+	case next.Low && !prev.Low:
+		log.Printf("WARNING: low disk space (%d MB free, threshold %d MB); logging metadata only until space is freed",
+			next.FreeBytes>>20, storageCfg.MinFreeDiskMB)
+	case !next.Low && prev.Low:
+		log.Printf("disk space recovered (%d MB free); resuming body logging", next.FreeBytes>>20)
+	}
+	return next
+}
+
+// Run checks every interval until stop is closed.
+func (g *DiskGuard) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		g.Check()
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Low reports whether the last check found free space below the threshold.
+func (g *DiskGuard) Low() bool {
+	if g == nil {
+		return false
+	}
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.status.Low
+}
+
+// Status returns the last reading.
+func (g *DiskGuard) Status() DiskStatus {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.status
+}
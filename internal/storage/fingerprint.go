@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"net/url"
+	"strings"
+)
+
+// fingerprintIgnoredKeys are top-level JSON request fields that don't change
+// what is being asked: transport options and caller bookkeeping.
+var fingerprintIgnoredKeys = []string{"stream", "stream_options", "user", "metadata", "store"}
+
+// RequestFingerprint returns a content hash ("sha256:<hex>") identifying
+// equivalent requests: same upstream, method, path, query (order-insensitive)
+// and body. JSON bodies are compared canonically (key order and whitespace
+// ignored, fingerprintIgnoredKeys dropped), so the same prompt sent
+// streaming or not, by different users, maps to one fingerprint.
+func RequestFingerprint(upstream, method, path, rawQuery string, body []byte) string {
+	var b bytes.Buffer
+	b.WriteString(upstream)
+	b.WriteByte('\n')
+	b.WriteString(strings.ToUpper(method))
+	b.WriteByte('\n')
+	b.WriteString(path)
+	if q, err := url.ParseQuery(rawQuery); err == nil && len(q) > 0 {
+		b.WriteByte('?')
+		b.WriteString(q.Encode())
+	}
+	b.WriteByte('\n')
+	b.Write(canonicalBody(body))
+	return newSHA256Ref(sha256.Sum256(b.Bytes()))
+}
+
+// canonicalBody re-encodes a JSON object body with sorted keys and no
+// volatile fields; other bodies are only trimmed.
+func canonicalBody(body []byte) []byte {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || body[0] != '{' {
+		return body
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc map[string]interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return body
+	}
+	for _, k := range fingerprintIgnoredKeys {
+		delete(doc, k)
+	}
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return body
+	}
+	return out
+}
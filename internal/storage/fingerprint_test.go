@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRequestFingerprint(t *testing.T) {
+	base := RequestFingerprint("openai", "POST", "/v1/chat/completions", "a=1&b=2",
+		[]byte(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`))
+
+	same := map[string]string{
+		"key order and whitespace": RequestFingerprint("openai", "post", "/v1/chat/completions", "b=2&a=1",
+			[]byte(` { "messages": [{"content":"hi","role":"user"}], "model": "gpt-4o" } `)),
+		"ignored fields": RequestFingerprint("openai", "POST", "/v1/chat/completions", "a=1&b=2",
+			[]byte(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}],"stream":true,"user":"alice"}`)),
+	}
+	for name, fp := range same {
+		if fp != base {
+			t.Errorf("%s: fingerprint differs", name)
+		}
+	}
+
+	different := map[string]string{
+		"upstream": RequestFingerprint("azure", "POST", "/v1/chat/completions", "a=1&b=2",
+			[]byte(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`)),
+		"prompt": RequestFingerprint("openai", "POST", "/v1/chat/completions", "a=1&b=2",
+			[]byte(`{"model":"gpt-4o","messages":[{"role":"user","content":"hello"}]}`)),
+		"temperature": RequestFingerprint("openai", "POST", "/v1/chat/completions", "a=1&b=2",
+			[]byte(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}],"temperature":0}`)),
+	}
+	for name, fp := range different {
+		if fp == base {
+			t.Errorf("%s: fingerprint should differ", name)
+		}
+	}
+}
+
+func TestSQLiteListLogsFingerprint(t *testing.T) {
+	repo := newTestSQLite(t)
+	for id, fp := range map[string]string{"a": "sha256:aa", "b": "sha256:aa", "c": "sha256:cc", "d": ""} {
+		if err := repo.SaveLog(context.Background(), &RequestLog{ID: id, CreatedAt: time.Now(), Method: "POST", Path: "/", Fingerprint: fp}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := listIDs(t, repo, LogFilter{Fingerprint: "sha256:aa"})
+	if len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Fatalf("got %v", got)
+	}
+	log, err := repo.GetLog(context.Background(), "c")
+	if err != nil || log.Fingerprint != "sha256:cc" {
+		t.Fatalf("GetLog: %+v, %v", log, err)
+	}
+}
+
+func TestSQLiteDuplicateOf(t *testing.T) {
+	repo := newTestSQLite(t)
+	for _, l := range []*RequestLog{
+		{ID: "first", Fingerprint: "sha256:aa"},
+		{ID: "again", Fingerprint: "sha256:aa", DuplicateOf: "first", DuplicateCount: 2, Flags: []string{FlagDuplicate}},
+		{ID: "other", Fingerprint: "sha256:bb"},
+	} {
+		l.CreatedAt, l.Method, l.Path = time.Now(), "POST", "/"
+		if err := repo.SaveLog(context.Background(), l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := listIDs(t, repo, LogFilter{DuplicateOf: "first"}); len(got) != 1 || got[0] != "again" {
+		t.Fatalf("got %v", got)
+	}
+	log, err := repo.GetLog(context.Background(), "again")
+	if err != nil || log.DuplicateOf != "first" || log.DuplicateCount != 2 || !log.HasFlag(FlagDuplicate) {
+		t.Fatalf("GetLog: %+v, %v", log, err)
+	}
+}
@@ -0,0 +1,371 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// journalCompactBytes is the journal size past which it is rewritten with
+// only the requests still open (or twice the open requests' size, if more).
+const journalCompactBytes = 1 << 20
+
+// MetadataRequestBodySHA256 is the metadata key under which Replay records
+// the journaled SHA-256 of an interrupted request's body.
+const MetadataRequestBodySHA256 = "request_body_sha256"
+
+// interruptedError is the error given to logs left in flight by a crash.
+const interruptedError = "interrupted: the proxy stopped before the request completed"
+
+// Journal record kinds.
+const (
+	journalBegin = "begin"
+	journalBody  = "body"
+	journalEnd   = "end"
+)
+
+// journalRecord is one line of the journal.
+type journalRecord struct {
+	Op        string     `json:"op"`
+	ID        string     `json:"id"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	Upstream  string     `json:"upstream,omitempty"`
+	Method    string     `json:"method,omitempty"`
+	Path      string     `json:"path,omitempty"`
+	Query     string     `json:"query,omitempty"`
+	TargetURL string     `json:"target_url,omitempty"`
+	Tag       string     `json:"tag,omitempty"`
+	Source    string     `json:"source,omitempty"`
+	// BodySHA256 and BodySize describe the request body as it was read
+	// from the client, in full (no capture limit applies).
+	BodySHA256 string `json:"body_sha256,omitempty"`
+	BodySize   int64  `json:"body_size,omitempty"`
+}
+
+// Journal is a small append-only file recording requests as they are
+// forwarded. Records are written synchronously on the request path, outside
+// the async log queue, so that a request is known to have been sent even if
+// the process dies before its queued logs reach the database. A request's
+// records are closed once its final log is saved (see JournalRepository);
+// at startup Replay restores the requests the last run left open.
+//
+// The file holds one JSON record per line. Once it grows past
+// journalCompactBytes it is rewritten with only the open requests.
+type Journal struct {
+	path string
+	sync bool
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+	// compactAt is the size that triggers the next compaction: twice the
+	// size after the last one, so many open requests don't cause one per End.
+	compactAt int64
+	// open holds the encoded records of requests not closed yet.
+	open map[string][][]byte
+	// leftover lists the requests the last run left open, until Replay.
+	leftover []string
+}
+
+// JournalPath returns the journal file for a database: next to it, one per
+// cluster instance (source) since instances may share the database.
+func JournalPath(database, source string) string {
+	name := "requests.journal"
+	if source != "" {
+		name = "requests-" + source + ".journal"
+	}
+	return filepath.Join(filepath.Dir(database), name)
+}
+
+// OpenJournal opens (or creates) the journal at path, loading the requests a
+// previous run left open. With sync, every record is fsynced.
+func OpenJournal(path string, sync bool) (*Journal, error) {
+	j := &Journal{path: path, sync: sync, open: make(map[string][][]byte)}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var rec journalRecord
+		// A torn last line (crash mid-write) doesn't decode; skip it.
+		if json.Unmarshal(scanner.Bytes(), &rec) != nil || rec.ID == "" {
+			continue
+		}
+		switch rec.Op {
+		case journalBegin:
+			j.open[rec.ID] = [][]byte{append([]byte(nil), scanner.Bytes()...)}
+		case journalBody:
+			if lines, ok := j.open[rec.ID]; ok {
+				j.open[rec.ID] = append(lines, append([]byte(nil), scanner.Bytes()...))
+			}
+		case journalEnd:
+			delete(j.open, rec.ID)
+		}
+	}
+	for id := range j.open {
+		j.leftover = append(j.leftover, id)
+	}
+	sort.Strings(j.leftover)
+
+	// Rewrite the file with only the open requests, dropping a torn line.
+	if err := j.compact(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// Begin records a request about to be forwarded, from its in-flight log.
+func (j *Journal) Begin(l *RequestLog) error {
+	createdAt := l.CreatedAt
+	line, err := json.Marshal(journalRecord{
+		Op:        journalBegin,
+		ID:        l.ID,
+		CreatedAt: &createdAt,
+		Upstream:  l.Upstream,
+		Method:    l.Method,
+		Path:      l.Path,
+		Query:     l.Query,
+		TargetURL: l.TargetURL,
+		Tag:       l.Tag,
+		Source:    l.Source,
+	})
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.open[l.ID] = [][]byte{line}
+	return j.write(line)
+}
+
+// Body records the SHA-256 (hex) and size of a request's body once it has
+// been read to the end. Requests not begun are ignored.
+func (j *Journal) Body(id, sha256 string, size int64) error {
+	line, err := json.Marshal(journalRecord{Op: journalBody, ID: id, BodySHA256: sha256, BodySize: size})
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	lines, ok := j.open[id]
+	if !ok {
+		return nil
+	}
+	j.open[id] = append(lines, line)
+	return j.write(line)
+}
+
+// End closes a request whose final log has been saved. Requests not begun
+// are ignored.
+func (j *Journal) End(id string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, ok := j.open[id]; !ok {
+		return nil
+	}
+	delete(j.open, id)
+	if j.size >= j.compactAt {
+		return j.compact()
+	}
+	line, err := json.Marshal(journalRecord{Op: journalEnd, ID: id})
+	if err != nil {
+		return err
+	}
+	return j.write(line)
+}
+
+// Close closes the journal file.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f == nil {
+		return nil
+	}
+	err := j.f.Close()
+	j.f = nil
+	return err
+}
+
+// write appends a record. j.mu must be held.
+func (j *Journal) write(line []byte) error {
+	if j.f == nil {
+		return errors.New("journal closed")
+	}
+	n, err := j.f.Write(append(line, '\n'))
+	j.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if j.sync {
+		return j.f.Sync()
+	}
+	return nil
+}
+
+// compact rewrites the journal with only the open requests' records and
+// swaps it in atomically. j.mu must be held (or j not yet shared).
+func (j *Journal) compact() error {
+	var buf bytes.Buffer
+	for _, lines := range j.open {
+		for _, line := range lines {
+			buf.Write(line)
+			buf.WriteByte('\n')
+		}
+	}
+
+	tmp := j.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o600); err != nil {
+		return err
+	}
+	if j.sync {
+		if err := syncFile(tmp); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmp, j.path); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	if j.f != nil {
+		j.f.Close()
+	}
+	j.f, j.size = f, int64(buf.Len())
+	j.compactAt = 2 * j.size
+	if j.compactAt < journalCompactBytes {
+		j.compactAt = journalCompactBytes
+	}
+	return nil
+}
+
+func syncFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// Replay restores the requests the last run left open: their logs are marked
+// FlagInterrupted, with the journaled body hash in their metadata, and
+// created from the journal when the crash also lost the in-flight log.
+// Requests whose final log made it to the database are only closed. It
+// returns the number of logs restored.
+func (j *Journal) Replay(r *SQLiteRepository) (int64, error) {
+	j.mu.Lock()
+	leftover := j.leftover
+	j.leftover = nil
+	records := make(map[string][][]byte, len(leftover))
+	for _, id := range leftover {
+		records[id] = j.open[id]
+	}
+	j.mu.Unlock()
+
+	var restored int64
+	for _, id := range leftover {
+		l, err := r.GetLog(context.Background(), id)
+		switch {
+		case err == nil && (l.StatusCode != 0 || l.Error != ""):
+			// Saved in full; only the end record was lost.
+		case err == nil || errors.Is(err, sql.ErrNoRows):
+			if l == nil {
+				l = &RequestLog{ID: id}
+			}
+			applyJournalRecords(l, records[id])
+			l.Error = interruptedError
+			l.AddFlag(FlagInterrupted)
+			if err := r.SaveLog(context.Background(), l); err != nil {
+				return restored, fmt.Errorf("restore %s: %w", id, err)
+			}
+			restored++
+		default:
+			return restored, err
+		}
+		if err := j.End(id); err != nil {
+			return restored, err
+		}
+	}
+	return restored, nil
+}
+
+// applyJournalRecords fills in l from its journal records, keeping what the
+// in-flight log already holds.
+func applyJournalRecords(l *RequestLog, lines [][]byte) {
+	for _, line := range lines {
+		var rec journalRecord
+		if json.Unmarshal(line, &rec) != nil {
+			continue
+		}
+		switch rec.Op {
+		case journalBegin:
+			if l.CreatedAt.IsZero() && rec.CreatedAt != nil {
+				l.CreatedAt = *rec.CreatedAt
+			}
+			setIfEmpty(&l.Upstream, rec.Upstream)
+			setIfEmpty(&l.Method, rec.Method)
+			setIfEmpty(&l.Path, rec.Path)
+			setIfEmpty(&l.Query, rec.Query)
+			setIfEmpty(&l.TargetURL, rec.TargetURL)
+			setIfEmpty(&l.Tag, rec.Tag)
+			setIfEmpty(&l.Source, rec.Source)
+		case journalBody:
+			if l.RequestBodySize == 0 {
+				l.RequestBodySize = rec.BodySize
+			}
+			if l.Metadata == nil {
+				l.Metadata = make(map[string]string)
+			}
+			l.Metadata[MetadataRequestBodySHA256] = rec.BodySHA256
+		}
+	}
+}
+
+func setIfEmpty(dst *string, v string) {
+	if *dst == "" {
+		*dst = v
+	}
+}
+
+// JournalRepository closes a request's journal records once its final log
+// has been saved. Place it inside AsyncRepository, so that a request stays
+// open while its log is only queued; a final log the queue sheds is closed
+// by the proxy instead.
+type JournalRepository struct {
+	Repository
+	journal *Journal
+}
+
+// NewJournalRepository wraps inner, closing requests in journal.
+func NewJournalRepository(inner Repository, journal *Journal) *JournalRepository {
+	return &JournalRepository{Repository: inner, journal: journal}
+}
+
+// SaveLog saves the log and, for a final log, closes its request.
+func (r *JournalRepository) SaveLog(ctx context.Context, l *RequestLog) error {
+	if err := r.Repository.SaveLog(ctx, l); err != nil {
+		return err
+	}
+	if l.StatusCode != 0 || l.Error != "" {
+		if err := r.journal.End(l.ID); err != nil {
+			return fmt.Errorf("journal: %w", err)
+		}
+	}
+	return nil
+}
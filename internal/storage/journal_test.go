@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJournalReplay(t *testing.T) {
+	repo := newTestSQLite(t)
+	path := filepath.Join(t.TempDir(), "requests.journal")
+	j, err := OpenJournal(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracked := NewJournalRepository(repo, j)
+
+	created := time.Now().Add(-time.Minute).Truncate(time.Second)
+	for _, id := range []string{"lost", "inflight", "saved", "done"} {
+		l := &RequestLog{ID: id, CreatedAt: created, Upstream: "openai", Method: "POST", Path: "/v1/chat/completions"}
+		if err := j.Begin(l); err != nil {
+			t.Fatal(err)
+		}
+		if err := j.Body(id, "abc123", 42); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// "lost": the crash took its queued in-flight log; "inflight": only the
+	// in-flight log landed; "saved": the final log landed but the end record
+	// didn't; "done": closed normally.
+	if err := tracked.SaveLog(context.Background(), &RequestLog{ID: "inflight", CreatedAt: created, Upstream: "openai"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SaveLog(context.Background(), &RequestLog{ID: "saved", CreatedAt: created, Upstream: "openai", StatusCode: 200}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tracked.SaveLog(context.Background(), &RequestLog{ID: "done", CreatedAt: created, Upstream: "openai", StatusCode: 200}); err != nil {
+		t.Fatal(err)
+	}
+	// Simulate a crash: a torn last line, no clean shutdown.
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	f.WriteString(`{"op":"begin","id":"tor`)
+	f.Close()
+
+	j, err = OpenJournal(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	rec := Recover(repo, j, "", time.Now())
+	if s := rec.Status(); s.Journaled != 2 || s.Interrupted != 2 {
+		t.Fatalf("status = %+v", s)
+	}
+
+	for _, id := range []string{"lost", "inflight"} {
+		l, err := repo.GetLog(context.Background(), id)
+		if err != nil {
+			t.Fatalf("%s: %v", id, err)
+		}
+		if !l.HasFlag(FlagInterrupted) || l.Error == "" || l.Metadata[MetadataRequestBodySHA256] != "abc123" || l.RequestBodySize != 42 {
+			t.Errorf("%s = %+v", id, l)
+		}
+		if l.Path != "/v1/chat/completions" || !l.CreatedAt.Equal(created) {
+			t.Errorf("%s: path %q created %v", id, l.Path, l.CreatedAt)
+		}
+	}
+	if l, _ := repo.GetLog(context.Background(), "saved"); l.HasFlag(FlagInterrupted) || l.StatusCode != 200 {
+		t.Errorf("saved = %+v", l)
+	}
+	if _, err := repo.GetLog(context.Background(), "done"); err != nil {
+		t.Errorf("done: %v", err)
+	}
+
+	// Replayed requests are closed: a second start finds nothing.
+	j.Close()
+	j, err = OpenJournal(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := j.Replay(repo); err != nil || n != 0 {
+		t.Fatalf("second replay = %d, %v", n, err)
+	}
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// AcquireLease takes or renews the named lease for holder until now+ttl. It
+// succeeds when the lease is free, expired or already held by holder, and
+// reports whether holder owns the lease afterwards. The check and the write
+// are one statement, so competing instances can't both win.
+func (r *SQLiteRepository) AcquireLease(name, holder string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	result, err := r.db.Exec(`
+	INSERT INTO leases (name, holder, expires_at) VALUES (?, ?, ?)
+	ON CONFLICT(name) DO UPDATE SET holder = excluded.holder, expires_at = excluded.expires_at
+	WHERE leases.holder = excluded.holder OR leases.expires_at < ?`,
+		name, holder, now.Add(ttl).UnixMilli(), now.UnixMilli())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// ReleaseLease gives up the named lease if holder owns it, so another
+// instance can take over without waiting for it to expire.
+func (r *SQLiteRepository) ReleaseLease(name, holder string) error {
+	_, err := r.db.Exec("DELETE FROM leases WHERE name = ? AND holder = ?", name, holder)
+	return err
+}
+
+// LeaseHolder returns the current holder of the named lease, or "" when it
+// is free or expired.
+func (r *SQLiteRepository) LeaseHolder(name string) (string, error) {
+	var holder string
+	err := r.db.QueryRow("SELECT holder FROM leases WHERE name = ? AND expires_at >= ?", name, time.Now().UnixMilli()).Scan(&holder)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return holder, err
+}
+
+// Leader keeps a lease renewed in the background so one of several
+// instances sharing a database can run jobs that must not run twice.
+type Leader struct {
+	repo   *SQLiteRepository
+	name   string
+	holder string
+	ttl    time.Duration
+
+	mu        sync.Mutex
+	heldUntil time.Time
+}
+
+// NewLeader creates a Leader competing for lease name as holder.
+func NewLeader(repo *SQLiteRepository, name, holder string, ttl time.Duration) *Leader {
+	return &Leader{repo: repo, name: name, holder: holder, ttl: ttl}
+}
+
+// Run renews the lease every third of its TTL until stop is closed, then
+// releases it.
+func (l *Leader) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	for {
+		l.renew()
+		select {
+		case <-ticker.C:
+		case <-stop:
+			l.mu.Lock()
+			held := time.Now().Before(l.heldUntil)
+			l.heldUntil = time.Time{}
+			l.mu.Unlock()
+			if held {
+				if err := l.repo.ReleaseLease(l.name, l.holder); err != nil {
+					log.Printf("release %s lease failed: %v", l.name, err)
+				}
+			}
+			return
+		}
+	}
+}
+
+func (l *Leader) renew() {
+	start := time.Now()
+	ok, err := l.repo.AcquireLease(l.name, l.holder, l.ttl)
+	if err != nil {
+		log.Printf("renew %s lease failed: %v", l.name, err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	was := time.Now().Before(l.heldUntil)
+	if ok {
+		// Stop trusting the lease a third of the TTL before it expires, so
+		// a stalled renewal can't overlap with the next holder.
+		l.heldUntil = start.Add(l.ttl - l.ttl/3)
+	} else if err == nil {
+		l.heldUntil = time.Time{}
+	}
+	if now := time.Now().Before(l.heldUntil); now != was {
+		if now {
+			log.Printf("acquired %s lease as %s", l.name, l.holder)
+		} else {
+			log.Printf("lost %s lease", l.name)
+		}
+	}
+}
+
+// IsLeader reports whether this instance currently holds the lease.
+func (l *Leader) IsLeader() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return time.Now().Before(l.heldUntil)
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prismcat/prismcat/internal/config"
+)
+
+func TestAcquireLease(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shared.db")
+	a, err := NewSQLiteRepository(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	// A second instance opening the same database.
+	b, err := NewSQLiteRepository(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if ok, err := a.AcquireLease("maintenance", "a", time.Minute); err != nil || !ok {
+		t.Fatalf("a acquire = %v, %v", ok, err)
+	}
+	if ok, _ := b.AcquireLease("maintenance", "b", time.Minute); ok {
+		t.Fatal("b took a held lease")
+	}
+	if ok, _ := a.AcquireLease("maintenance", "a", time.Minute); !ok {
+		t.Fatal("a could not renew its lease")
+	}
+	if holder, _ := b.LeaseHolder("maintenance"); holder != "a" {
+		t.Fatalf("holder = %q", holder)
+	}
+
+	if err := a.ReleaseLease("maintenance", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := b.AcquireLease("maintenance", "b", time.Millisecond); !ok {
+		t.Fatal("b could not take a released lease")
+	}
+	time.Sleep(5 * time.Millisecond)
+	if holder, _ := a.LeaseHolder("maintenance"); holder != "" {
+		t.Fatalf("expired lease still held by %q", holder)
+	}
+	if ok, _ := a.AcquireLease("maintenance", "a", time.Minute); !ok {
+		t.Fatal("a could not take an expired lease")
+	}
+}
+
+func TestLeader(t *testing.T) {
+	repo := newTestSQLite(t)
+	first := NewLeader(repo, "maintenance", "first", 300*time.Millisecond)
+	second := NewLeader(repo, "maintenance", "second", 300*time.Millisecond)
+
+	stopFirst := make(chan struct{})
+	go first.Run(stopFirst)
+	waitFor(t, first.IsLeader)
+	stopSecond := make(chan struct{})
+	defer close(stopSecond)
+	go second.Run(stopSecond)
+	time.Sleep(150 * time.Millisecond)
+	if second.IsLeader() {
+		t.Fatal("both instances lead")
+	}
+
+	close(stopFirst) // releases the lease
+	waitFor(t, second.IsLeader)
+	if first.IsLeader() {
+		t.Fatal("stopped leader still reports leadership")
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestClusterInstancesOnOneHost starts two clustered instances on one host
+// from the same config, without instance_id: they must not share a lease,
+// a journal or each other's in-flight logs.
+func TestClusterInstancesOnOneHost(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	db := filepath.Join(dir, "shared.db")
+	data := "storage:\n  database: " + db + "\ncluster:\n  enabled: true\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	var repos []*SQLiteRepository
+	for i := 0; i < 2; i++ {
+		cfg, err := config.Load(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, cfg.ClusterSnapshot().InstanceID)
+		repo, err := NewSQLiteRepository(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer repo.Close()
+		repos = append(repos, repo)
+	}
+	host, _ := os.Hostname()
+	if ids[0] == ids[1] || !strings.HasPrefix(ids[0], host+"-") {
+		t.Fatalf("instance ids = %q", ids)
+	}
+	if JournalPath(db, ids[0]) == JournalPath(db, ids[1]) {
+		t.Fatalf("instances share journal %s", JournalPath(db, ids[0]))
+	}
+
+	if ok, err := repos[0].AcquireLease("maintenance", ids[0], time.Minute); err != nil || !ok {
+		t.Fatalf("first acquire = %v, %v", ok, err)
+	}
+	if ok, _ := repos[1].AcquireLease("maintenance", ids[1], time.Minute); ok {
+		t.Fatal("both instances hold the maintenance lease")
+	}
+
+	started := time.Now()
+	for i, id := range ids {
+		l := &RequestLog{ID: id, CreatedAt: started.Add(-time.Minute), Upstream: "openai", Source: id}
+		if err := repos[i].SaveLog(context.Background(), l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The second instance restarting only recovers its own logs.
+	if n, err := repos[1].MarkInterrupted(ids[1], started); err != nil || n != 1 {
+		t.Fatalf("MarkInterrupted = %d, %v", n, err)
+	}
+	if l, _ := repos[0].GetLog(context.Background(), ids[0]); l.HasFlag(FlagInterrupted) {
+		t.Fatal("first instance's in-flight log marked interrupted")
+	}
+}
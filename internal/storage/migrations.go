@@ -0,0 +1,454 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// migration is one numbered schema change. Pending migrations run in
+// order, each in its own transaction, and are recorded in schema_version.
+// Never edit or renumber a released migration; add a new one instead.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *sql.Tx) error
+}
+
+// migrations is the full schema history. Databases created before
+// versioning (schema_version missing) replay it from the start, so the
+// early steps must tolerate objects that already exist.
+var migrations = []migration{
+	{1, "request_logs", migrateRequestLogs},
+	{2, "log_properties and log_metadata", migrateKVTables},
+	{3, "traffic_daily aggregate", migrateTrafficDaily},
+	{4, "request fingerprint", migrateFingerprint},
+	{5, "leases", migrateLeases},
+	{6, "duplicate requests", migrateDuplicates},
+	{7, "hourly rollups", migrateHourlyRollups},
+	{8, "replays", migrateReplays},
+	{9, "response checks", migrateLogChecks},
+	{10, "total tokens", migrateTotalTokens},
+}
+
+// migrate brings the database up to the latest schema version. A file
+// database holding data is backed up first (see backupBeforeMigrate).
+func (r *SQLiteRepository) migrate() error {
+	if _, err := r.db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at DATETIME NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("create schema_version: %w", err)
+	}
+	current, err := r.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	latest := migrations[len(migrations)-1].version
+	if current > latest {
+		return fmt.Errorf("database schema version %d is newer than this build supports (%d)", current, latest)
+	}
+	if current == latest {
+		return nil
+	}
+
+	if err := r.backupBeforeMigrate(current); err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := r.applyMigration(m); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+		}
+	}
+	return nil
+}
+
+// SchemaVersion returns the highest applied migration, 0 for none.
+func (r *SQLiteRepository) SchemaVersion() (int, error) {
+	var v sql.NullInt64
+	if err := r.db.QueryRow("SELECT MAX(version) FROM schema_version").Scan(&v); err != nil {
+		return 0, fmt.Errorf("read schema version: %w", err)
+	}
+	return int(v.Int64), nil
+}
+
+func (r *SQLiteRepository) applyMigration(m migration) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := m.up(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)", m.version, m.name, time.Now()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// backupBeforeMigrate copies a database that already holds logs to
+// "<db>.v<from>.bak" before its schema changes, so a failed or unwanted
+// upgrade can be rolled back by hand. New and in-memory databases are skipped.
+func (r *SQLiteRepository) backupBeforeMigrate(from int) error {
+	if r.path == "" || r.path == ":memory:" || strings.HasPrefix(r.path, "file:") {
+		return nil
+	}
+	var tables int
+	if err := r.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'request_logs'").Scan(&tables); err != nil {
+		return err
+	}
+	if tables == 0 {
+		return nil
+	}
+	dst := fmt.Sprintf("%s.v%d.bak", r.path, from)
+	if err := r.Snapshot(context.Background(), dst); err != nil {
+		return fmt.Errorf("backup before migration: %w", err)
+	}
+	log.Printf("Database backed up to %s before schema migration", dst)
+	return nil
+}
+
+func migrateRequestLogs(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS request_logs (
+		id TEXT PRIMARY KEY,
+		created_at DATETIME NOT NULL,
+		upstream TEXT NOT NULL,
+		target_url TEXT NOT NULL,
+		method TEXT NOT NULL,
+		path TEXT NOT NULL,
+		query TEXT,
+		request_headers TEXT,
+		request_body TEXT,
+		request_body_ref TEXT,
+		request_body_size INTEGER DEFAULT 0,
+		status_code INTEGER DEFAULT 0,
+		response_headers TEXT,
+		response_body TEXT,
+		response_body_ref TEXT,
+		response_body_size INTEGER DEFAULT 0,
+		streaming INTEGER DEFAULT 0,
+		latency_ms INTEGER DEFAULT 0,
+		error TEXT,
+		truncated INTEGER DEFAULT 0
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_logs_created_at ON request_logs(created_at DESC);
+	CREATE INDEX IF NOT EXISTS idx_logs_upstream ON request_logs(upstream);
+	CREATE INDEX IF NOT EXISTS idx_logs_status_code ON request_logs(status_code);
+	CREATE INDEX IF NOT EXISTS idx_logs_method ON request_logs(method);
+	`); err != nil {
+		return err
+	}
+	// Columns added before schema versioning; older databases may lack any of them.
+	for _, col := range []string{
+		"request_body_ref TEXT",
+		"response_body_ref TEXT",
+		"tag TEXT DEFAULT ''",
+		"flags TEXT DEFAULT ''",
+		"variant TEXT DEFAULT ''",
+		"client_ip TEXT DEFAULT ''",
+		"remote_addr TEXT DEFAULT ''",
+		"user_agent TEXT DEFAULT ''",
+		"source TEXT DEFAULT ''",
+		"event_timings TEXT DEFAULT ''",
+		"stream_events INTEGER DEFAULT 0",
+		"conn_timings TEXT DEFAULT ''",
+	} {
+		if err := addColumn(tx, "request_logs", col); err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec(`
+	CREATE INDEX IF NOT EXISTS idx_logs_client_ip ON request_logs(client_ip);
+	CREATE INDEX IF NOT EXISTS idx_logs_tag ON request_logs(tag);
+	`)
+	return err
+}
+
+// migrateKVTables creates the custom property (X-PrismCat-Property-*) and
+// metadata tables. Keeping them apart from request_logs lets any key be
+// filtered on; the delete triggers follow every path that deletes logs.
+func migrateKVTables(tx *sql.Tx) error {
+	for _, table := range []string{"log_properties", "log_metadata"} {
+		if _, err := tx.Exec(fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %[1]s (
+			log_id TEXT NOT NULL,
+			key TEXT NOT NULL,
+			value TEXT NOT NULL,
+			PRIMARY KEY (log_id, key)
+		);
+		CREATE INDEX IF NOT EXISTS idx_%[1]s_kv ON %[1]s(key, value);
+		CREATE TRIGGER IF NOT EXISTS trg_logs_delete_%[1]s AFTER DELETE ON request_logs
+		BEGIN
+			DELETE FROM %[1]s WHERE log_id = old.id;
+		END;
+		`, table)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateTrafficDaily creates the per-day, per-upstream traffic aggregate.
+// Triggers keep it in step with request_logs (a log's sizes grow from its
+// in-flight snapshot to the final save), and deliberately not on delete, so
+// volume history outlives retention and purges. Existing logs are counted
+// once when the table is first created.
+func migrateTrafficDaily(tx *sql.Tx) error {
+	var exists int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'traffic_daily'").Scan(&exists); err != nil {
+		return err
+	}
+	// created_at is stored in local time, so its date prefix is the local day.
+	if _, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS traffic_daily (
+		day TEXT NOT NULL,
+		upstream TEXT NOT NULL,
+		requests INTEGER NOT NULL DEFAULT 0,
+		bytes_in INTEGER NOT NULL DEFAULT 0,
+		bytes_out INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (day, upstream)
+	);
+	CREATE TRIGGER IF NOT EXISTS trg_logs_traffic_insert AFTER INSERT ON request_logs
+	BEGIN
+		INSERT INTO traffic_daily (day, upstream, requests, bytes_in, bytes_out)
+		VALUES (substr(new.created_at, 1, 10), new.upstream, 1, new.request_body_size, new.response_body_size)
+		ON CONFLICT(day, upstream) DO UPDATE SET
+			requests = requests + 1,
+			bytes_in = bytes_in + excluded.bytes_in,
+			bytes_out = bytes_out + excluded.bytes_out;
+	END;
+	CREATE TRIGGER IF NOT EXISTS trg_logs_traffic_update AFTER UPDATE OF request_body_size, response_body_size ON request_logs
+	BEGIN
+		UPDATE traffic_daily SET
+			bytes_in = bytes_in + new.request_body_size - old.request_body_size,
+			bytes_out = bytes_out + new.response_body_size - old.response_body_size
+		WHERE day = substr(old.created_at, 1, 10) AND upstream = old.upstream;
+	END;
+	`); err != nil {
+		return err
+	}
+	if exists != 0 {
+		return nil
+	}
+	_, err := tx.Exec(`
+	INSERT OR IGNORE INTO traffic_daily (day, upstream, requests, bytes_in, bytes_out)
+	SELECT substr(created_at, 1, 10), upstream, COUNT(*), COALESCE(SUM(request_body_size), 0), COALESCE(SUM(response_body_size), 0)
+	FROM request_logs GROUP BY 1, 2
+	`)
+	return err
+}
+
+// migrateFingerprint adds the normalized request fingerprint used to compare
+// responses to the same request over time. Older logs keep an empty value.
+func migrateFingerprint(tx *sql.Tx) error {
+	if err := addColumn(tx, "request_logs", "fingerprint TEXT DEFAULT ''"); err != nil {
+		return err
+	}
+	_, err := tx.Exec("CREATE INDEX IF NOT EXISTS idx_logs_fingerprint ON request_logs(fingerprint, created_at)")
+	return err
+}
+
+// migrateLeases adds the table instances sharing a database use to elect
+// which of them runs maintenance jobs (see AcquireLease).
+func migrateLeases(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS leases (
+		name TEXT PRIMARY KEY,
+		holder TEXT NOT NULL,
+		expires_at INTEGER NOT NULL -- unix milliseconds
+	)`)
+	return err
+}
+
+// migrateDuplicates adds the link from a repeated request to the first log
+// of its run of duplicates (see RequestLog.DuplicateOf).
+func migrateDuplicates(tx *sql.Tx) error {
+	if err := addColumn(tx, "request_logs", "duplicate_of TEXT DEFAULT ''"); err != nil {
+		return err
+	}
+	return addColumn(tx, "request_logs", "duplicate_count INTEGER DEFAULT 0")
+}
+
+// rollupFinal and rollupClass are shared by the rollup triggers; the
+// placeholder %[1]s is the row alias (new or old). The in-flight snapshot
+// (no status, no error) is not counted.
+const (
+	rollupFinal = `(%[1]s.status_code != 0 OR COALESCE(%[1]s.error, '') != '')`
+	rollupClass = `CASE WHEN %[1]s.status_code = 0 THEN 'error' ELSE (%[1]s.status_code / 100) || 'xx' END`
+	rollupKey   = `replace(substr(%[1]s.created_at, 1, 13), ' ', 'T'), %[1]s.upstream, COALESCE(%[1]s.model, ''), ` + rollupClass
+)
+
+// migrateHourlyRollups adds the model and token columns and the per-hour
+// aggregate long-range charts read instead of request_logs. As with
+// traffic_daily, triggers keep it in step (an updated log first takes back
+// what it added, so re-saving a log never counts it twice) and it outlives
+// deletes. Existing logs are counted once, without model or tokens.
+func migrateHourlyRollups(tx *sql.Tx) error {
+	for _, def := range []string{"model TEXT DEFAULT ''", "input_tokens INTEGER DEFAULT 0", "output_tokens INTEGER DEFAULT 0"} {
+		if err := addColumn(tx, "request_logs", def); err != nil {
+			return err
+		}
+	}
+	var exists int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'rollup_hourly'").Scan(&exists); err != nil {
+		return err
+	}
+	newKey, oldKey := fmt.Sprintf(rollupKey, "new"), fmt.Sprintf(rollupKey, "old")
+	upsert := `ON CONFLICT(hour, upstream, model, status_class) DO UPDATE SET
+			requests = requests + 1,
+			input_tokens = input_tokens + excluded.input_tokens,
+			output_tokens = output_tokens + excluded.output_tokens,
+			latency_ms_sum = latency_ms_sum + excluded.latency_ms_sum`
+	// created_at is stored in local time as "2006-01-02 15:04:05...", so its
+	// first 13 characters are the local hour; the key spells it
+	// "2006-01-02T15".
+	if _, err := tx.Exec(fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS rollup_hourly (
+		hour TEXT NOT NULL,
+		upstream TEXT NOT NULL,
+		model TEXT NOT NULL,
+		status_class TEXT NOT NULL,
+		requests INTEGER NOT NULL DEFAULT 0,
+		input_tokens INTEGER NOT NULL DEFAULT 0,
+		output_tokens INTEGER NOT NULL DEFAULT 0,
+		latency_ms_sum INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (hour, upstream, model, status_class)
+	);
+	CREATE TRIGGER IF NOT EXISTS trg_logs_rollup_insert AFTER INSERT ON request_logs
+	WHEN %[1]s
+	BEGIN
+		INSERT INTO rollup_hourly (hour, upstream, model, status_class, requests, input_tokens, output_tokens, latency_ms_sum)
+		VALUES (%[3]s, 1, new.input_tokens, new.output_tokens, new.latency_ms)
+		%[5]s;
+	END;
+	CREATE TRIGGER IF NOT EXISTS trg_logs_rollup_update
+	AFTER UPDATE OF created_at, upstream, status_code, error, latency_ms, model, input_tokens, output_tokens ON request_logs
+	BEGIN
+		UPDATE rollup_hourly SET
+			requests = requests - 1,
+			input_tokens = input_tokens - old.input_tokens,
+			output_tokens = output_tokens - old.output_tokens,
+			latency_ms_sum = latency_ms_sum - old.latency_ms
+		WHERE %[2]s AND (hour, upstream, model, status_class) = (%[4]s);
+		INSERT INTO rollup_hourly (hour, upstream, model, status_class, requests, input_tokens, output_tokens, latency_ms_sum)
+		SELECT %[3]s, 1, new.input_tokens, new.output_tokens, new.latency_ms
+		WHERE %[1]s
+		%[5]s;
+	END;
+	`, fmt.Sprintf(rollupFinal, "new"), fmt.Sprintf(rollupFinal, "old"), newKey, oldKey, upsert)); err != nil {
+		return err
+	}
+	if exists != 0 {
+		return nil
+	}
+	_, err := tx.Exec(fmt.Sprintf(`
+	INSERT OR IGNORE INTO rollup_hourly (hour, upstream, model, status_class, requests, input_tokens, output_tokens, latency_ms_sum)
+	SELECT %s, COUNT(*), 0, 0, COALESCE(SUM(latency_ms), 0)
+	FROM request_logs AS l WHERE %s GROUP BY 1, 2, 3, 4
+	`, fmt.Sprintf(rollupKey, "l"), fmt.Sprintf(rollupFinal, "l")))
+	return err
+}
+
+// migrateReplays adds the history of /api/replay executions started from a
+// log (see Replay). A log's replays are deleted with it.
+func migrateReplays(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS replays (
+		id TEXT PRIMARY KEY,
+		log_id TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		actor TEXT NOT NULL DEFAULT '',
+		upstream TEXT NOT NULL,
+		method TEXT NOT NULL,
+		path TEXT NOT NULL DEFAULT '',
+		status_code INTEGER NOT NULL DEFAULT 0,
+		latency_ms INTEGER NOT NULL DEFAULT 0,
+		response_size INTEGER NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS idx_replays_log ON replays(log_id, created_at);
+	CREATE TRIGGER IF NOT EXISTS trg_logs_delete_replays AFTER DELETE ON request_logs
+	BEGIN
+		DELETE FROM replays WHERE log_id = old.id;
+	END;
+	`)
+	return err
+}
+
+// addColumn adds a column (given as its full definition, name first)
+// unless the table already has it.
+func addColumn(tx *sql.Tx, table, def string) error {
+	name := strings.Fields(def)[0]
+	has, err := hasColumn(tx, table, name)
+	if err != nil || has {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, def)); err != nil {
+		return fmt.Errorf("add column %s.%s: %w", table, name, err)
+	}
+	return nil
+}
+
+func hasColumn(tx *sql.Tx, table, colName string) (bool, error) {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s);", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid int
+		var name string
+		var ctype string
+		var notnull int
+		var dfltValue any
+		var pk int
+		if err := rows.Scan(&cid, &name, &ctype, &notnull, &dfltValue, &pk); err != nil {
+			return false, err
+		}
+		if name == colName {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// migrateLogChecks creates the per-log response check results. The index
+// serves GetCheckStats, which groups them by check.
+func migrateLogChecks(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS log_checks (
+		log_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		passed INTEGER NOT NULL,
+		PRIMARY KEY (log_id, name)
+	);
+	CREATE INDEX IF NOT EXISTS idx_log_checks_name ON log_checks(name, passed);
+	CREATE TRIGGER IF NOT EXISTS trg_logs_delete_log_checks AFTER DELETE ON request_logs
+	BEGIN
+		DELETE FROM log_checks WHERE log_id = old.id;
+	END;
+	`)
+	return err
+}
+
+// migrateTotalTokens adds the provider-reported token total. Existing logs
+// get the sum of their input and output tokens.
+func migrateTotalTokens(tx *sql.Tx) error {
+	if err := addColumn(tx, "request_logs", "total_tokens INTEGER DEFAULT 0"); err != nil {
+		return err
+	}
+	_, err := tx.Exec("UPDATE request_logs SET total_tokens = input_tokens + output_tokens WHERE input_tokens != 0 OR output_tokens != 0")
+	return err
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	// A database from before schema versioning: early columns only.
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`
+	CREATE TABLE request_logs (
+		id TEXT PRIMARY KEY, created_at DATETIME NOT NULL, upstream TEXT NOT NULL,
+		target_url TEXT NOT NULL, method TEXT NOT NULL, path TEXT NOT NULL, query TEXT,
+		request_headers TEXT, request_body TEXT, request_body_ref TEXT, request_body_size INTEGER DEFAULT 0,
+		status_code INTEGER DEFAULT 0, response_headers TEXT, response_body TEXT, response_body_ref TEXT,
+		response_body_size INTEGER DEFAULT 0, streaming INTEGER DEFAULT 0, latency_ms INTEGER DEFAULT 0,
+		error TEXT, truncated INTEGER DEFAULT 0, tag TEXT DEFAULT ''
+	)`); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if _, err := db.Exec(`INSERT INTO request_logs (id, created_at, upstream, target_url, method, path, query,
+		request_headers, request_body, request_body_ref, request_body_size,
+		response_headers, response_body, response_body_ref, response_body_size, error)
+		VALUES ('old', ?, 'openai', 'http://x', 'GET', '/', '', '{}', '', '', 3, '{}', '', '', 7, '')`, now); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	repo, err := NewSQLiteRepository(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+
+	if v, err := repo.SchemaVersion(); err != nil || v != migrations[len(migrations)-1].version {
+		t.Fatalf("version = %d, %v", v, err)
+	}
+	if _, err := os.Stat(path + ".v0.bak"); err != nil {
+		t.Fatalf("pre-migration backup: %v", err)
+	}
+	if l, err := repo.GetLog(context.Background(), "old"); err != nil || l.Upstream != "openai" {
+		t.Fatalf("GetLog = %+v, %v", l, err)
+	}
+	day := now.Format("2006-01-02")
+	if stats, _ := repo.GetTrafficStats(context.Background(), day, day, ""); len(stats) != 1 || stats[0].BytesOut != 7 {
+		t.Fatalf("backfilled traffic = %+v", stats)
+	}
+
+	// Reopening an up-to-date database neither migrates nor backs up again.
+	repo.Close()
+	os.Remove(path + ".v0.bak")
+	repo, err = NewSQLiteRepository(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+	if _, err := os.Stat(path + ".v0.bak"); !os.IsNotExist(err) {
+		t.Fatalf("unexpected backup: %v", err)
+	}
+}
+
+func TestMigrateRejectsNewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "future.db")
+	repo, err := NewSQLiteRepository(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.db.Exec("INSERT INTO schema_version (version, name, applied_at) VALUES (999, 'future', ?)", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	repo.Close()
+
+	if _, err := NewSQLiteRepository(path); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Fatalf("err = %v", err)
+	}
+}
@@ -1,6 +1,13 @@
 package storage
 
-import "time"
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrLogNotFound is returned for operations on a log ID that doesn't exist.
+var ErrLogNotFound = errors.New("log not found")
 
 // RequestLog 请求日志记录
 type RequestLog struct {
@@ -33,25 +40,253 @@ type RequestLog struct {
 	Error     string `json:"error,omitempty"` // 错误信息
 	Truncated bool   `json:"truncated"`       // 响应体是否被截断
 	Tag       string `json:"tag,omitempty"`   // 来自 X-PrismCat-Tag 请求头
+
+	// ClientIP is the resolved client address (see server.trusted_proxies).
+	ClientIP string `json:"client_ip,omitempty"`
+	// RemoteAddr is the direct peer address (the last proxy hop when behind one).
+	RemoteAddr string `json:"remote_addr,omitempty"`
+	UserAgent  string `json:"user_agent,omitempty"`
+
+	// Variant is "stable" or "canary" when the upstream has a canary rollout.
+	Variant string `json:"variant,omitempty"`
+
+	// Source names the PrismCat instance a shipped log came from (empty for
+	// local traffic).
+	Source string `json:"source,omitempty"`
+
+	// Properties are caller-supplied dimensions captured from
+	// X-PrismCat-Property-* request headers (lowercased key → value).
+	Properties map[string]string `json:"properties,omitempty"`
+
+	// Metadata is free-form key/value annotation attached by the client
+	// (X-PrismCat-Metadata header), middlewares or the API after the fact.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// Checks holds the results of the upstream's response checks by name
+	// (true: passed); see FlagCheckFailed.
+	Checks map[string]bool `json:"checks,omitempty"`
+
+	// EventTimings record when each streamed event reached the client,
+	// keyed by its byte offset in the captured response body.
+	EventTimings []EventTiming `json:"event_timings,omitempty"`
+	// StreamEvents counts the events forwarded for an SSE/NDJSON response.
+	StreamEvents int64 `json:"stream_events,omitempty"`
+
+	// Connection is the upstream connection breakdown (DNS, connect, TLS,
+	// time to first byte); nil when no request reached the transport.
+	Connection *ConnTimings `json:"connection,omitempty"`
+
+	// Fingerprint identifies equivalent requests (see RequestFingerprint);
+	// empty when the request body wasn't captured in full.
+	Fingerprint string `json:"fingerprint,omitempty"`
+
+	// DuplicateOf is the ID of the first log of a run of identical requests
+	// (same fingerprint, each within logging.duplicate_window_seconds of the
+	// previous) when this log repeats it; DuplicateCount is its position in
+	// the run (2 for the first repeat).
+	DuplicateOf    string `json:"duplicate_of,omitempty"`
+	DuplicateCount int    `json:"duplicate_count,omitempty"`
+
+	// Model is the model named in the request (or response) body and
+	// InputTokens/OutputTokens/TotalTokens the usage the response reported
+	// (JSON or SSE). The proxy reads them from the bodies as they are
+	// forwarded, whatever the capture limits, and they feed the hourly
+	// rollups. TotalTokens is the provider's own
+	// total when it reports one (it may count tokens outside input and
+	// output), else their sum.
+	Model        string `json:"model,omitempty"`
+	InputTokens  int64  `json:"input_tokens,omitempty"`
+	OutputTokens int64  `json:"output_tokens,omitempty"`
+	TotalTokens  int64  `json:"total_tokens,omitempty"`
+
+	// Flags are machine-set markers (e.g. FlagSchemaInvalid) for filtering and stats.
+	Flags []string `json:"flags,omitempty"`
+}
+
+// EventTiming is the time a streamed event was forwarded to the client.
+type EventTiming struct {
+	// Offset is the event's byte offset in the captured response body.
+	Offset int64 `json:"offset"`
+	// Ms is the time since the request started, in milliseconds.
+	Ms int64 `json:"ms"`
+}
+
+// ConnTimings is the latency breakdown of one upstream request, for telling
+// slow DNS, dials, TLS or uploads apart from a slow upstream. Phases skipped
+// on a reused connection are zero.
+type ConnTimings struct {
+	// Reused reports whether an idle pooled connection was used.
+	Reused bool `json:"reused"`
+	// DNSMs, ConnectMs and TLSMs are the durations of each dial phase.
+	DNSMs     float64 `json:"dns_ms,omitempty"`
+	ConnectMs float64 `json:"connect_ms,omitempty"`
+	TLSMs     float64 `json:"tls_ms,omitempty"`
+	// TTFBMs is the time from asking for a connection to the first
+	// response byte, dial phases included.
+	TTFBMs float64 `json:"ttfb_ms,omitempty"`
+	// WriteMs is the time spent sending the request headers and body once
+	// the connection was ready.
+	WriteMs float64 `json:"write_ms,omitempty"`
+	// WaitMs is the time from the request being fully sent to the first
+	// response byte: the upstream's own processing, which for a
+	// non-streaming LLM response is the whole generation.
+	WaitMs float64 `json:"wait_ms,omitempty"`
+	// TransferMs is the time from the first response byte until the body
+	// was fully forwarded; for a streamed response, the generation.
+	TransferMs float64 `json:"transfer_ms,omitempty"`
+}
+
+// Log flags.
+const (
+	// FlagSchemaInvalid marks a response that failed its configured JSON Schema.
+	FlagSchemaInvalid = "schema_invalid"
+	// FlagGuardrailBlocked marks a request rejected by a content guardrail.
+	FlagGuardrailBlocked = "guardrail_blocked"
+	// FlagStreamMerged marks a streaming log whose inline response_body holds
+	// the reassembled JSON; the raw stream is in response_body_ref.
+	FlagStreamMerged = "stream_merged"
+	// FlagEmbeddingsSummarized marks an embeddings response stored as a summary
+	// without vectors (logging.summarize_embeddings).
+	FlagEmbeddingsSummarized = "embeddings_summarized"
+	// FlagBodyPreview marks a log whose bodies were cut to previews because no
+	// full-capture trigger fired.
+	FlagBodyPreview = "body_preview"
+	// FlagDiskLow marks a log stored without bodies because free disk space
+	// was below storage.min_free_disk_mb.
+	FlagDiskLow = "disk_low"
+	// FlagBodyEvicted marks a log whose detached body was evicted from the
+	// blob store to stay under storage.max_blob_bytes. Only the preview remains.
+	FlagBodyEvicted = "body_evicted"
+	// FlagBodyPurged marks a log whose detached bodies were removed by a
+	// body purge (POST /api/maintenance/purge-bodies). Only the preview remains.
+	FlagBodyPurged = "body_purged"
+	// FlagPartial marks a response that failed mid-body (upstream reset,
+	// timeout or client disconnect); the log holds what was forwarded so far.
+	FlagPartial = "partial"
+	// FlagUpstreamHeader marks a request routed by its X-PrismCat-Upstream
+	// header rather than the host.
+	FlagUpstreamHeader = "upstream_header"
+	// FlagPathRouted marks a request that named its upstream with a
+	// "/u/<upstream>" path prefix (server.routing_mode path or both).
+	FlagPathRouted = "path_routed"
+	// FlagContextLimit marks a request rejected because its estimated tokens
+	// exceeded the model's configured context limit.
+	FlagContextLimit = "context_limit"
+	// FlagDuplicate marks a request identical to one sent shortly before
+	// (see RequestLog.DuplicateOf).
+	FlagDuplicate = "duplicate"
+	// FlagBodyArchived marks a log whose full bodies were moved to the
+	// archive tier (storage.archive). The preview stays inline.
+	FlagBodyArchived = "body_archived"
+	// FlagResponseTooLarge marks a response cut off by the upstream's
+	// max_response_bytes.
+	FlagResponseTooLarge = "response_too_large"
+	// FlagRequestTooLarge marks a request refused for exceeding
+	// server.max_request_bytes.
+	FlagRequestTooLarge = "request_too_large"
+	// FlagUpgraded marks a connection switched to another protocol
+	// (WebSocket, h2c) on an upstream with allow_upgrade. The body sizes
+	// count the tunnel's bytes in each direction; its traffic is not captured.
+	FlagUpgraded = "upgraded"
+	// FlagInterrupted marks a log left in flight by a crash or kill: the
+	// in-flight snapshot was saved but the final log never was (see Recover).
+	FlagInterrupted = "interrupted"
+	// FlagRouted marks a request whose destination was chosen by the routing
+	// table (routes) rather than the host.
+	FlagRouted = "routed"
+	// FlagCheckFailed marks a response that failed at least one of its
+	// upstream's response checks (see RequestLog.Checks).
+	FlagCheckFailed = "check_failed"
+	// FlagRetried marks a request sent a second time, on a new connection,
+	// after the upstream dropped the first one (HTTP/2 GOAWAY, connection
+	// reset) before responding.
+	FlagRetried = "retried"
+)
+
+// HasFlag reports whether the log carries the flag.
+func (l *RequestLog) HasFlag(flag string) bool {
+	for _, f := range l.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// AddFlag adds a flag once.
+func (l *RequestLog) AddFlag(flag string) {
+	if !l.HasFlag(flag) {
+		l.Flags = append(l.Flags, flag)
+	}
+}
+
+// RemoveFlag removes a flag if present.
+func (l *RequestLog) RemoveFlag(flag string) {
+	var out []string
+	for _, f := range l.Flags {
+		if f != flag {
+			out = append(out, f)
+		}
+	}
+	l.Flags = out
 }
 
 // LogFilter 日志查询过滤器
 type LogFilter struct {
-	Upstream   string     // 按上游名称过滤
-	Method     string     // 按请求方法过滤
-	StatusCode int        // 按状态码过滤
-	Path       string     // 按路径模糊搜索
-	Tag        string     // 按标签过滤
-	StartTime  *time.Time // 开始时间
-	EndTime    *time.Time // 结束时间
-	HasError   *bool      // 是否有错误
-	Streaming  *bool      // 是否为流式
+	Upstream    string     // 按上游名称过滤
+	Method      string     // 按请求方法过滤
+	StatusCode  int        // 按状态码过滤
+	Path        string     // 按路径模糊搜索
+	PathRegex   string     // 按路径正则匹配（Go regexp 语法）
+	Tag         string     // 按标签过滤
+	StartTime   *time.Time // 开始时间
+	EndTime     *time.Time // 结束时间
+	HasError    *bool      // 是否有错误
+	Streaming   *bool      // 是否为流式
+	Flag        string     // 按标记过滤（如 schema_invalid）
+	Variant     string     // 按灰度变体过滤（stable/canary）
+	ClientIP    string     // 按客户端 IP 过滤
+	RemoteAddr  string     // 按直连对端地址过滤
+	UserAgent   string     // 按 User-Agent 模糊搜索
+	Source      string     // 按来源实例过滤（日志转发）
+	Fingerprint string     // 按请求指纹过滤（见 RequestFingerprint）
+	DuplicateOf string     // 按首次请求 ID 过滤其重复请求
+
+	// Properties 按自定义属性过滤，多个键为 AND 关系，值为精确匹配。
+	Properties map[string]string
+	// Metadata 按元数据过滤，规则同 Properties。
+	Metadata map[string]string
+
+	// 多值与排除过滤：同一字段的多个值为 OR 关系，Exclude* 排除匹配项。
+	// Paths 为子串匹配，StatusCodes 为闭区间。
+	Upstreams          []string
+	ExcludeUpstreams   []string
+	Methods            []string
+	ExcludeMethods     []string
+	Tags               []string
+	ExcludeTags        []string
+	Paths              []string
+	ExcludePaths       []string
+	StatusCodes        []StatusRange
+	ExcludeStatusCodes []StatusRange
+
+	// 范围过滤（0 表示不限制）。BodySize 取请求体与响应体中较大者。
+	MinLatencyMs int64
+	MaxLatencyMs int64
+	MinBodySize  int64
+	MaxBodySize  int64
 
 	// 分页
 	Offset int
 	Limit  int
 }
 
+// StatusRange is an inclusive status code range; Min == Max for a single code.
+type StatusRange struct {
+	Min int
+	Max int
+}
+
 // LogStats 日志统计
 type LogStats struct {
 	TotalRequests  int64            `json:"total_requests"`
@@ -59,20 +294,210 @@ type LogStats struct {
 	ErrorCount     int64            `json:"error_count"`
 	StreamingCount int64            `json:"streaming_count"`
 	AvgLatency     float64          `json:"avg_latency_ms"`
+	SchemaInvalid  int64            `json:"schema_invalid_count"`
+	CheckFailed    int64            `json:"check_failed_count"`
+	InputTokens    int64            `json:"input_tokens"`
+	OutputTokens   int64            `json:"output_tokens"`
+	TotalTokens    int64            `json:"total_tokens"`
 	ByUpstream     map[string]int64 `json:"by_upstream"`
 	ByStatusCode   map[int]int64    `json:"by_status_code"`
 }
 
+// PropertyStat 某个自定义属性值的聚合统计
+type PropertyStat struct {
+	Value      string  `json:"value"`
+	Count      int64   `json:"count"`
+	ErrorCount int64   `json:"error_count"`
+	AvgLatency float64 `json:"avg_latency_ms"`
+}
+
+// CheckStat 某个上游某项响应检查的通过/失败统计
+type CheckStat struct {
+	Upstream string `json:"upstream"`
+	Name     string `json:"name"`
+	Passed   int64  `json:"passed"`
+	Failed   int64  `json:"failed"`
+}
+
+// UpstreamStats 单个上游的请求统计
+//
+// Only finished requests count: in-flight logs (no status, no error yet) are
+// left out. Errors are responses of 400 and above plus requests that got no
+// response (NetworkErrors).
+type UpstreamStats struct {
+	Upstream       string `json:"upstream"`
+	TotalRequests  int64  `json:"total_requests"`
+	SuccessCount   int64  `json:"success_count"`
+	ErrorCount     int64  `json:"error_count"`
+	StreamingCount int64  `json:"streaming_count"`
+
+	ClientErrors  int64         `json:"client_errors"`  // 4xx
+	ServerErrors  int64         `json:"server_errors"`  // 5xx
+	NetworkErrors int64         `json:"network_errors"` // no response
+	ByStatusCode  map[int]int64 `json:"by_status_code"`
+	// TopErrors are the most frequent error messages, most frequent first.
+	TopErrors []ErrorStat `json:"top_errors"`
+
+	Latency      LatencyStats `json:"latency_ms"`
+	InputTokens  int64        `json:"input_tokens"`
+	OutputTokens int64        `json:"output_tokens"`
+	TotalTokens  int64        `json:"total_tokens"`
+
+	LastSeenAt  *time.Time `json:"last_seen_at,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// ErrorStat 某条错误信息的出现次数
+type ErrorStat struct {
+	Message string `json:"message"`
+	Count   int64  `json:"count"`
+}
+
+// LatencyStats 延迟分布 (毫秒)
+type LatencyStats struct {
+	Avg float64 `json:"avg"`
+	P50 int64   `json:"p50"`
+	P90 int64   `json:"p90"`
+	P95 int64   `json:"p95"`
+	P99 int64   `json:"p99"`
+	Max int64   `json:"max"`
+}
+
+// TrafficStat 某上游某天的流量汇总
+//
+// BytesIn is request body bytes received from clients, BytesOut response
+// body bytes sent back. Day is the local date (YYYY-MM-DD).
+type TrafficStat struct {
+	Day      string `json:"day"`
+	Upstream string `json:"upstream"`
+	Requests int64  `json:"requests"`
+	BytesIn  int64  `json:"bytes_in"`
+	BytesOut int64  `json:"bytes_out"`
+}
+
+// Replay 一次请求重放（/api/replay）的执行记录
+//
+// Replays started from a log are recorded against it (LogID), so the
+// experiments run on a request keep their history.
+type Replay struct {
+	ID        string    `json:"id"`
+	LogID     string    `json:"log_id"`
+	CreatedAt time.Time `json:"created_at"`
+	// Actor is who ran the replay: the control panel's Basic auth user,
+	// else the client address.
+	Actor    string `json:"actor,omitempty"`
+	Upstream string `json:"upstream"`
+	Method   string `json:"method"`
+	Path     string `json:"path"`
+	// StatusCode is 0 when the upstream sent no response (see Error).
+	StatusCode   int    `json:"status_code"`
+	LatencyMs    int64  `json:"latency_ms"`
+	ResponseSize int64  `json:"response_size"`
+	Error        string `json:"error,omitempty"`
+}
+
+// HourlyRollup 某小时、上游、模型、状态类别的请求汇总
+//
+// Hour is the local hour ("2006-01-02T15"). StatusClass is "2xx" to "5xx",
+// or "error" for requests that got no response. Model is empty when the
+// body named none (and for logs stored before rollups existed).
+type HourlyRollup struct {
+	Hour         string `json:"hour"`
+	Upstream     string `json:"upstream"`
+	Model        string `json:"model"`
+	StatusClass  string `json:"status_class"`
+	Requests     int64  `json:"requests"`
+	InputTokens  int64  `json:"input_tokens"`
+	OutputTokens int64  `json:"output_tokens"`
+	LatencyMsSum int64  `json:"latency_ms_sum"`
+}
+
+// LogReader 只读查询接口（日志浏览、统计）
+//
+// Reads may be served by a separate read-only pool or a replica, so they can
+// lag slightly behind writes and must never block log ingestion. They stop
+// when ctx is done (e.g. the HTTP client went away) or the repository's
+// query timeout expires, returning the context's error.
+type LogReader interface {
+	GetLog(ctx context.Context, id string) (*RequestLog, error)
+	// GetLogMetadata returns a log's metadata, or ErrLogNotFound for unknown IDs.
+	GetLogMetadata(ctx context.Context, id string) (map[string]string, error)
+	ListLogs(ctx context.Context, filter LogFilter) ([]*RequestLog, int64, error) // 返回日志列表和总数
+	// ListReplays returns a log's replays, newest first, or ErrLogNotFound
+	// for unknown IDs.
+	ListReplays(ctx context.Context, logID string) ([]*Replay, error)
+
+	// 统计
+	GetStats(ctx context.Context, since *time.Time) (*LogStats, error)
+	// GetPropertyStats groups logs by the value of property key, most
+	// frequent first, returning at most limit values.
+	GetPropertyStats(ctx context.Context, key string, since *time.Time, limit int) ([]PropertyStat, error)
+	// GetCheckStats counts passes and failures of each response check per
+	// upstream, optionally for one upstream.
+	GetCheckStats(ctx context.Context, upstream string, since *time.Time) ([]CheckStat, error)
+	// GetTrafficStats returns daily traffic between the from and to days
+	// (inclusive, YYYY-MM-DD), optionally for one upstream. Totals are kept
+	// in an aggregate and survive log deletion.
+	GetTrafficStats(ctx context.Context, from, to, upstream string) ([]TrafficStat, error)
+	// GetHourlyRollups returns the hourly aggregates between the from and to
+	// hours (inclusive, 2006-01-02T15), optionally for one upstream and
+	// model. Like traffic stats they survive log deletion.
+	GetHourlyRollups(ctx context.Context, from, to, upstream, model string) ([]HourlyRollup, error)
+	// GetUpstreamStats summarizes one upstream's finished requests:
+	// volume, error breakdown, latency percentiles and token totals.
+	GetUpstreamStats(ctx context.Context, upstream string, since *time.Time) (*UpstreamStats, error)
+	GetStorageStats(ctx context.Context) (*StorageStats, error) // 数据库占用, 不含 blob
+	// GetUpstreamFootprints reports the storage each upstream's logs take,
+	// largest first. It reads every body's length, so it is not cheap.
+	GetUpstreamFootprints(ctx context.Context) ([]UpstreamFootprint, error)
+}
+
+// LogWriter 写入接口（日志采集、清理）
+//
+// Writes stop when ctx is done. Queued writers (AsyncRepository) only use
+// it to enqueue: the write itself outlives the caller's request.
+type LogWriter interface {
+	SaveLog(ctx context.Context, log *RequestLog) error
+	// SetLogMetadata updates a log's metadata; an empty value removes the
+	// key. Returns ErrLogNotFound for unknown IDs.
+	SetLogMetadata(ctx context.Context, id string, md map[string]string) error
+	// SaveReplay records a replay of an existing log. Returns ErrLogNotFound
+	// for unknown log IDs.
+	SaveReplay(ctx context.Context, r *Replay) error
+	DeleteLogsBefore(ctx context.Context, before time.Time) (int64, error) // 返回删除数量
+	DeleteLogs(ctx context.Context, ids []string) (int64, error)           // 按 ID 删除, 返回删除数量
+	// ClearBlobRefsBefore drops the body refs of logs created before the
+	// cutoff and marks them with flag, keeping the inline previews. Returns
+	// the number of logs updated.
+	ClearBlobRefsBefore(ctx context.Context, before time.Time, flag string) (int64, error)
+	// ReplaceBodies stores l's inline bodies and body refs and marks it with
+	// flag. Returns ErrLogNotFound for unknown IDs.
+	ReplaceBodies(ctx context.Context, l *RequestLog, flag string) error
+}
+
 // Repository 存储接口
 type Repository interface {
-	// 日志操作
-	SaveLog(log *RequestLog) error
-	GetLog(id string) (*RequestLog, error)
-	ListLogs(filter LogFilter) ([]*RequestLog, int64, error) // 返回日志列表和总数
-	DeleteLogsBefore(before time.Time) (int64, error)        // 返回删除数量
+	LogReader
+	LogWriter
 
-	// 统计
-	GetStats(since *time.Time) (*LogStats, error)
+	// Maintenance scans always read the primary database: blob GC must not
+	// miss refs written after a replica's last sync.
+
+	// ScanLogContent calls fn for every log with only ID, Path, Query and the
+	// body/body-ref fields populated. Iteration stops at the first error.
+	ScanLogContent(ctx context.Context, fn func(*RequestLog) error) error
+	// ListBlobRefs returns all distinct blob refs currently referenced by logs.
+	ListBlobRefs(ctx context.Context) ([]string, error)
+	// BlobRefsBefore returns the distinct blob refs held by logs created
+	// before the cutoff, and how many such logs hold at least one.
+	BlobRefsBefore(ctx context.Context, before time.Time) ([]string, int64, error)
+	// ArchivableLogs returns up to limit logs created in [from, before),
+	// oldest first, that lack FlagBodyArchived and hold a body ref or an
+	// inline body over minInline bytes. Only ID, CreatedAt, Flags and the
+	// body/body-ref fields are populated.
+	ArchivableLogs(ctx context.Context, from, before time.Time, minInline int64, limit int) ([]*RequestLog, error)
+	// Snapshot writes a consistent copy of the database to dstPath (backups).
+	Snapshot(ctx context.Context, dstPath string) error
 
 	// 生命周期
 	Close() error
@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"regexp"
+	"time"
+)
+
+// BlobDeleter is implemented by blob stores that support removing single blobs.
+type BlobDeleter interface {
+	Delete(ctx context.Context, ref string) error
+}
+
+// PurgeQuery selects logs for content-based deletion (e.g. data-deletion
+// requests). A log matches when its path, query or either body contains
+// Contains or matches Pattern. Detached bodies are matched in full, not just
+// their inline preview.
+type PurgeQuery struct {
+	Contains string
+	Pattern  *regexp.Regexp
+	// DryRun only reports matches without deleting anything.
+	DryRun bool
+}
+
+// PurgeResult reports what PurgeByContent matched and removed.
+type PurgeResult struct {
+	IDs          []string `json:"ids"`
+	Matched      int      `json:"matched"`
+	Deleted      int64    `json:"deleted"`
+	BlobsDeleted int      `json:"blobs_deleted"`
+	DryRun       bool     `json:"dry_run"`
+}
+
+func (q PurgeQuery) match(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	if q.Contains != "" && bytes.Contains(b, []byte(q.Contains)) {
+		return true
+	}
+	return q.Pattern != nil && q.Pattern.Match(b)
+}
+
+// PurgeByContent deletes every log whose content matches q, together with the
+// blobs holding matching bodies. Blobs are content-addressed, so any other log
+// sharing a matching blob has the same body and is deleted too.
+//
+// Logs still waiting in the async write queue are not seen.
+func PurgeByContent(ctx context.Context, repo Repository, blobs BlobStore, q PurgeQuery) (*PurgeResult, error) {
+	if q.Contains == "" && q.Pattern == nil {
+		return nil, errors.New("purge: empty query")
+	}
+
+	blobMatches := make(map[string]bool) // ref -> matched
+	matchRef := func(ref string) (bool, error) {
+		if ref == "" || blobs == nil {
+			return false, nil
+		}
+		if m, ok := blobMatches[ref]; ok {
+			return m, nil
+		}
+		data, err := blobs.Get(ctx, ref)
+		if err != nil {
+			if errors.Is(err, ErrBlobNotFound) {
+				blobMatches[ref] = false
+				return false, nil
+			}
+			return false, err
+		}
+		m := q.match(data)
+		blobMatches[ref] = m
+		return m, nil
+	}
+
+	res := &PurgeResult{IDs: []string{}, DryRun: q.DryRun}
+	err := repo.ScanLogContent(ctx, func(l *RequestLog) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		hit := q.match([]byte(l.Path)) || q.match([]byte(l.Query)) ||
+			q.match([]byte(l.RequestBody)) || q.match([]byte(l.ResponseBody))
+		for _, ref := range []string{l.RequestBodyRef, l.ResponseBodyRef} {
+			m, err := matchRef(ref)
+			if err != nil {
+				return err
+			}
+			hit = hit || m
+		}
+		if hit {
+			res.IDs = append(res.IDs, l.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	res.Matched = len(res.IDs)
+	if q.DryRun || res.Matched == 0 {
+		return res, nil
+	}
+
+	if res.Deleted, err = repo.DeleteLogs(ctx, res.IDs); err != nil {
+		return res, err
+	}
+	deleter, ok := blobs.(BlobDeleter)
+	if !ok {
+		return res, nil
+	}
+	for ref, m := range blobMatches {
+		if !m {
+			continue
+		}
+		if err := deleter.Delete(ctx, ref); err != nil {
+			return res, err
+		}
+		res.BlobsDeleted++
+	}
+	return res, nil
+}
+
+// BodyPurgeResult reports what PurgeBodiesBefore removed.
+type BodyPurgeResult struct {
+	Before time.Time `json:"before"`
+	// Logs counts logs that lost their detached bodies.
+	Logs int64 `json:"logs"`
+	// Blobs counts distinct blobs those logs referenced; BlobsDeleted those
+	// no newer log still needs.
+	Blobs        int  `json:"blobs"`
+	BlobsDeleted int  `json:"blobs_deleted"`
+	DryRun       bool `json:"dry_run"`
+}
+
+// PurgeBodiesBefore drops the detached bodies of logs created before the
+// cutoff while keeping the logs, their metadata and inline previews. Those
+// logs get FlagBodyPurged; blobs still referenced by newer logs are kept.
+//
+// Logs still waiting in the async write queue are not seen.
+func PurgeBodiesBefore(ctx context.Context, repo Repository, blobs BlobStore, before time.Time, dryRun bool) (*BodyPurgeResult, error) {
+	refs, logs, err := repo.BlobRefsBefore(ctx, before)
+	if err != nil {
+		return nil, err
+	}
+	res := &BodyPurgeResult{Before: before, Logs: logs, Blobs: len(refs), DryRun: dryRun}
+	if dryRun || logs == 0 {
+		return res, nil
+	}
+
+	// Clear refs first so no log points at a missing blob.
+	if res.Logs, err = repo.ClearBlobRefsBefore(ctx, before, FlagBodyPurged); err != nil {
+		return res, err
+	}
+	deleter, ok := blobs.(BlobDeleter)
+	if !ok {
+		return res, nil
+	}
+	remaining, err := repo.ListBlobRefs(ctx)
+	if err != nil {
+		return res, err
+	}
+	inUse := make(map[string]bool, len(remaining))
+	for _, ref := range remaining {
+		inUse[ref] = true
+	}
+	for _, ref := range refs {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		if inUse[ref] {
+			continue
+		}
+		if err := deleter.Delete(ctx, ref); err != nil {
+			return res, err
+		}
+		res.BlobsDeleted++
+	}
+	return res, nil
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPurgeByContent(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestSQLite(t)
+	blobs, err := NewFileBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	detached := `{"messages":[{"content":"` + strings.Repeat("x", 100) + ` contact bob@example.com"}]}`
+	ref, err := blobs.Put(ctx, []byte(detached))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range []*RequestLog{
+		{ID: "inline", RequestBody: `{"user":"alice@example.com"}`},
+		{ID: "detached", RequestBody: detached[:20], RequestBodyRef: ref},
+		{ID: "query", Path: "/v1/users", Query: "id=user-42"},
+		{ID: "other", RequestBody: `{"user":"carol@example.org"}`},
+	} {
+		l.CreatedAt, l.Upstream, l.Method = time.Now(), "openai", "POST"
+		if err := repo.SaveLog(context.Background(), l); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	q := PurgeQuery{Pattern: regexp.MustCompile(`(alice|bob)@example\.com`), Contains: "user-42", DryRun: true}
+	res, err := PurgeByContent(ctx, repo, blobs, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Matched != 3 || res.Deleted != 0 {
+		t.Fatalf("dry run = %+v", res)
+	}
+	if ok, _ := blobs.Exists(ctx, ref); !ok {
+		t.Fatal("dry run deleted the blob")
+	}
+
+	q.DryRun = false
+	if res, err = PurgeByContent(ctx, repo, blobs, q); err != nil {
+		t.Fatal(err)
+	}
+	if res.Deleted != 3 || res.BlobsDeleted != 1 {
+		t.Fatalf("purge = %+v", res)
+	}
+	if got := listIDs(t, repo, LogFilter{}); strings.Join(got, ",") != "other" {
+		t.Fatalf("remaining = %v", got)
+	}
+	if ok, _ := blobs.Exists(ctx, ref); ok {
+		t.Fatal("matching blob not deleted")
+	}
+}
+
+func TestPurgeBodiesBefore(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestSQLite(t)
+	blobs, err := NewFileBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldOnly, _ := blobs.Put(ctx, []byte("old body"))
+	shared, _ := blobs.Put(ctx, []byte("shared body"))
+	now := time.Now()
+	for _, l := range []*RequestLog{
+		{ID: "old", CreatedAt: now.Add(-48 * time.Hour), RequestBody: "old", RequestBodyRef: oldOnly, ResponseBodyRef: shared},
+		{ID: "old-inline", CreatedAt: now.Add(-48 * time.Hour), RequestBody: "inline"},
+		{ID: "new", CreatedAt: now, ResponseBodyRef: shared},
+	} {
+		l.Upstream, l.Method = "openai", "POST"
+		if err := repo.SaveLog(context.Background(), l); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cutoff := now.Add(-24 * time.Hour)
+	res, err := PurgeBodiesBefore(ctx, repo, blobs, cutoff, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Logs != 1 || res.Blobs != 2 || res.BlobsDeleted != 0 {
+		t.Fatalf("dry run = %+v", res)
+	}
+
+	if res, err = PurgeBodiesBefore(ctx, repo, blobs, cutoff, false); err != nil {
+		t.Fatal(err)
+	}
+	if res.Logs != 1 || res.BlobsDeleted != 1 {
+		t.Fatalf("purge = %+v", res)
+	}
+	if ok, _ := blobs.Exists(ctx, oldOnly); ok {
+		t.Fatal("old blob not deleted")
+	}
+	if ok, _ := blobs.Exists(ctx, shared); !ok {
+		t.Fatal("blob still used by a newer log was deleted")
+	}
+
+	old, err := repo.GetLog(context.Background(), "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.RequestBody != "old" || old.RequestBodyRef != "" || old.ResponseBodyRef != "" || !old.HasFlag(FlagBodyPurged) {
+		t.Fatalf("old log = %+v", old)
+	}
+	if l, _ := repo.GetLog(context.Background(), "new"); l.ResponseBodyRef != shared {
+		t.Fatalf("new log lost its ref: %+v", l)
+	}
+}
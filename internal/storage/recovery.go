@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// maxIntegrityProblems caps the problems PRAGMA integrity_check reports.
+const maxIntegrityProblems = 20
+
+// RecoveryStatus is the outcome of the checks run at startup.
+type RecoveryStatus struct {
+	// Interrupted is the number of in-flight logs marked FlagInterrupted.
+	Interrupted int64 `json:"interrupted"`
+	// Journaled is the number of interrupted requests restored from the
+	// request journal (included in Interrupted).
+	Journaled int64 `json:"journaled,omitempty"`
+	// Integrity is "pending" while PRAGMA integrity_check runs, then "ok"
+	// or "failed" (see Problems), or "error" when the check itself failed.
+	Integrity string     `json:"integrity"`
+	Problems  []string   `json:"problems,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+}
+
+// Recovery holds the startup checks' status for /api/health.
+type Recovery struct {
+	mu     sync.RWMutex
+	status RecoveryStatus
+}
+
+// Recover marks the logs this instance left in flight before started as
+// interrupted, then runs the integrity check in the background so a large
+// database doesn't hold up startup. source is the instance's log source:
+// other instances sharing the database may still be serving their requests.
+// With a journal, the requests it holds are restored first, including those
+// whose in-flight log never reached the database (nil: no journal).
+func Recover(r *SQLiteRepository, journal *Journal, source string, started time.Time) *Recovery {
+	rec := &Recovery{status: RecoveryStatus{Integrity: "pending"}}
+	if journal != nil {
+		n, err := journal.Replay(r)
+		if err != nil {
+			log.Printf("replaying the request journal failed: %v", err)
+		} else if n > 0 {
+			log.Printf("restored %d requests left in flight by the last run from the journal", n)
+		}
+		rec.status.Journaled = n
+	}
+	n, err := r.MarkInterrupted(source, started)
+	if err != nil {
+		log.Printf("marking interrupted logs failed: %v", err)
+	} else if n > 0 {
+		log.Printf("marked %d logs left in flight by the last run as interrupted", n)
+	}
+	rec.status.Interrupted = rec.status.Journaled + n
+
+	go func() {
+		problems, err := r.IntegrityCheck(maxIntegrityProblems)
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		now := time.Now()
+		rec.status.CheckedAt = &now
+		switch {
+		case err != nil:
+			rec.status.Integrity, rec.status.Error = "error", err.Error()
+			log.Printf("database integrity check failed to run: %v", err)
+		case len(problems) > 0:
+			rec.status.Integrity, rec.status.Problems = "failed", problems
+			log.Printf("WARNING: database integrity check found problems: %v", problems)
+		default:
+			rec.status.Integrity = "ok"
+		}
+	}()
+	return rec
+}
+
+// Status returns the current recovery status.
+func (rec *Recovery) Status() RecoveryStatus {
+	rec.mu.RLock()
+	defer rec.mu.RUnlock()
+	s := rec.status
+	s.Problems = append([]string(nil), s.Problems...)
+	return s
+}
+
+// MarkInterrupted flags the in-flight snapshots (no status, no error) from
+// source created before the given time as FlagInterrupted and gives them an
+// error, so they count as failed requests instead of staying pending.
+func (r *SQLiteRepository) MarkInterrupted(source string, before time.Time) (int64, error) {
+	flag := FlagInterrupted
+	result, err := r.db.Exec(`
+	UPDATE request_logs SET error = ?, flags = CASE
+		WHEN flags IS NULL OR flags = '' THEN ?
+		WHEN (',' || flags || ',') LIKE ? THEN flags
+		ELSE flags || ',' || ?
+	END
+	WHERE status_code = 0 AND COALESCE(error, '') = '' AND COALESCE(source, '') = ? AND created_at < ?`,
+		interruptedError,
+		flag, "%,"+flag+",%", flag, source, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// IntegrityCheck runs PRAGMA integrity_check and returns up to max problems;
+// none means the database is intact.
+func (r *SQLiteRepository) IntegrityCheck(max int) ([]string, error) {
+	rows, err := r.db.Query(fmt.Sprintf("PRAGMA integrity_check(%d)", max))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return nil, err
+		}
+		if line != "ok" {
+			problems = append(problems, line)
+		}
+	}
+	return problems, rows.Err()
+}
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRecoverMarksInterruptedLogs(t *testing.T) {
+	repo := newTestSQLite(t)
+	started := time.Now()
+	before := started.Add(-time.Minute)
+	for _, l := range []*RequestLog{
+		{ID: "inflight", CreatedAt: before, Upstream: "openai"},
+		{ID: "done", CreatedAt: before, Upstream: "openai", StatusCode: 200},
+		{ID: "failed", CreatedAt: before, Upstream: "openai", Error: "upstream request failed"},
+		{ID: "peer", CreatedAt: before, Upstream: "openai", Source: "other-instance"},
+		{ID: "new", CreatedAt: started.Add(time.Second), Upstream: "openai"},
+	} {
+		if err := repo.SaveLog(context.Background(), l); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := Recover(repo, nil, "", started)
+	if s := rec.Status(); s.Interrupted != 1 {
+		t.Fatalf("interrupted = %d, want 1", s.Interrupted)
+	}
+	l, err := repo.GetLog(context.Background(), "inflight")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.HasFlag(FlagInterrupted) || l.Error == "" {
+		t.Fatalf("inflight = flags %v, error %q", l.Flags, l.Error)
+	}
+	for _, id := range []string{"done", "failed", "peer", "new"} {
+		if l, _ := repo.GetLog(context.Background(), id); l.HasFlag(FlagInterrupted) {
+			t.Errorf("%s marked interrupted", id)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for rec.Status().Integrity == "pending" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s := rec.Status(); s.Integrity != "ok" || s.CheckedAt == nil {
+		t.Fatalf("integrity = %+v", s)
+	}
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SaveReplay records a replay of an existing log, assigning its ID and time
+// when unset. Returns ErrLogNotFound for unknown log IDs.
+func (r *SQLiteRepository) SaveReplay(ctx context.Context, rp *Replay) error {
+	if err := r.logExists(ctx, r.db, rp.LogID); err != nil {
+		return err
+	}
+	if rp.ID == "" {
+		rp.ID = uuid.New().String()
+	}
+	if rp.CreatedAt.IsZero() {
+		rp.CreatedAt = time.Now()
+	}
+	_, err := r.db.ExecContext(ctx, `
+	INSERT INTO replays (id, log_id, created_at, actor, upstream, method, path, status_code, latency_ms, response_size, error)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		rp.ID, rp.LogID, rp.CreatedAt, rp.Actor, rp.Upstream, rp.Method, rp.Path,
+		rp.StatusCode, rp.LatencyMs, rp.ResponseSize, rp.Error)
+	return err
+}
+
+// ListReplays returns a log's replays, newest first, or ErrLogNotFound for
+// unknown IDs.
+func (r *SQLiteRepository) ListReplays(ctx context.Context, logID string) ([]*Replay, error) {
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
+	if err := r.logExists(ctx, r.reader(), logID); err != nil {
+		return nil, err
+	}
+	rows, err := r.reader().QueryContext(ctx, `
+	SELECT id, log_id, created_at, actor, upstream, method, path, status_code, latency_ms, response_size, error
+	FROM replays WHERE log_id = ? ORDER BY created_at DESC, rowid DESC`, logID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	replays := []*Replay{}
+	for rows.Next() {
+		var rp Replay
+		if err := rows.Scan(&rp.ID, &rp.LogID, &rp.CreatedAt, &rp.Actor, &rp.Upstream, &rp.Method, &rp.Path,
+			&rp.StatusCode, &rp.LatencyMs, &rp.ResponseSize, &rp.Error); err != nil {
+			return nil, err
+		}
+		replays = append(replays, &rp)
+	}
+	return replays, rows.Err()
+}
@@ -1,21 +1,36 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
-	_ "modernc.org/sqlite"
+	"modernc.org/sqlite"
 )
 
 // SQLiteRepository implements Repository using SQLite.
 type SQLiteRepository struct {
-	db *sql.DB
+	db   *sql.DB
+	path string
+	// rdb serves LogReader queries when a read pool is open (see
+	// OpenReadPool); nil means reads share db.
+	rdb *sql.DB
+	// queryTimeout bounds each LogReader call (see SetQueryTimeout).
+	queryTimeout time.Duration
 }
 
+// defaultQueryTimeout is the LogReader query timeout unless SetQueryTimeout
+// changes it.
+const defaultQueryTimeout = 30 * time.Second
+
 // NewSQLiteRepository creates a new SQLite repository.
 func NewSQLiteRepository(dbPath string) (*SQLiteRepository, error) {
 	db, err := sql.Open("sqlite", dbPath)
@@ -34,7 +49,7 @@ func NewSQLiteRepository(dbPath string) (*SQLiteRepository, error) {
 	db.SetMaxOpenConns(5)
 	db.SetMaxIdleConns(5)
 
-	repo := &SQLiteRepository{db: db}
+	repo := &SQLiteRepository{db: db, path: dbPath, queryTimeout: defaultQueryTimeout}
 	if err := repo.migrate(); err != nil {
 		_ = db.Close()
 		return nil, err
@@ -43,117 +58,145 @@ func NewSQLiteRepository(dbPath string) (*SQLiteRepository, error) {
 	return repo, nil
 }
 
-func applySQLitePragmas(db *sql.DB) error {
-	// Use Query so PRAGMA statements that return rows are handled consistently.
-	pragmas := []string{
-		"PRAGMA journal_mode=WAL;",
-		"PRAGMA synchronous=NORMAL;",
-		"PRAGMA busy_timeout=5000;",
+// OpenReadPool routes LogReader queries to a separate read-only pool of up
+// to maxConns connections, so slow dashboard queries never hold the
+// connections log ingestion writes through. replicaPath points reads at a
+// replica of the database (e.g. kept in sync by Litestream or LiteFS); empty
+// opens the primary database file again, read-only. Call it before the
+// repository is shared.
+func (r *SQLiteRepository) OpenReadPool(replicaPath string, maxConns int) error {
+	path := replicaPath
+	if path == "" {
+		path = r.path
 	}
-	for _, stmt := range pragmas {
-		rows, err := db.Query(stmt)
-		if err != nil {
-			return fmt.Errorf("apply sqlite pragma failed (%s): %w", stmt, err)
-		}
-		_ = rows.Close()
+	if path == "" || path == ":memory:" {
+		return errors.New("read pool needs a database file")
 	}
-	return nil
-}
-
-func (r *SQLiteRepository) migrate() error {
-	schema := `
-	CREATE TABLE IF NOT EXISTS request_logs (
-		id TEXT PRIMARY KEY,
-		created_at DATETIME NOT NULL,
-		upstream TEXT NOT NULL,
-		target_url TEXT NOT NULL,
-		method TEXT NOT NULL,
-		path TEXT NOT NULL,
-		query TEXT,
-		request_headers TEXT,
-		request_body TEXT,
-		request_body_ref TEXT,
-		request_body_size INTEGER DEFAULT 0,
-		status_code INTEGER DEFAULT 0,
-		response_headers TEXT,
-		response_body TEXT,
-		response_body_ref TEXT,
-		response_body_size INTEGER DEFAULT 0,
-		streaming INTEGER DEFAULT 0,
-		latency_ms INTEGER DEFAULT 0,
-		error TEXT,
-		truncated INTEGER DEFAULT 0
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_logs_created_at ON request_logs(created_at DESC);
-	CREATE INDEX IF NOT EXISTS idx_logs_upstream ON request_logs(upstream);
-	CREATE INDEX IF NOT EXISTS idx_logs_status_code ON request_logs(status_code);
-	CREATE INDEX IF NOT EXISTS idx_logs_method ON request_logs(method);
-	`
-	if _, err := r.db.Exec(schema); err != nil {
-		return fmt.Errorf("database migrate failed: %w", err)
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("open read pool: %w", err)
 	}
-
-	// Backward-compatible migration for existing DBs.
-	if err := r.ensureLogColumn("request_body_ref", "request_body_ref TEXT"); err != nil {
-		return err
+	if maxConns <= 0 {
+		maxConns = 4
 	}
-	if err := r.ensureLogColumn("response_body_ref", "response_body_ref TEXT"); err != nil {
-		return err
+	// query_only is set per connection through the DSN, so every pooled
+	// connection gets it; the driver accepts "file:" URIs with parameters.
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
 	}
-	if err := r.ensureLogColumn("tag", "tag TEXT DEFAULT ''"); err != nil {
-		return err
+	rdb, err := sql.Open("sqlite", path+sep+"_pragma=query_only(1)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return fmt.Errorf("open read pool: %w", err)
+	}
+	rdb.SetMaxOpenConns(maxConns)
+	rdb.SetMaxIdleConns(maxConns)
+	var one int
+	if err := rdb.QueryRow("SELECT 1 FROM request_logs LIMIT 1").Scan(&one); err != nil && err != sql.ErrNoRows {
+		_ = rdb.Close()
+		return fmt.Errorf("open read pool %s: %w", path, err)
 	}
-	// Index for tag filtering.
-	if _, err := r.db.Exec("CREATE INDEX IF NOT EXISTS idx_logs_tag ON request_logs(tag)"); err != nil {
-		return fmt.Errorf("create tag index: %w", err)
+	if r.rdb != nil {
+		_ = r.rdb.Close()
 	}
+	r.rdb = rdb
 	return nil
 }
 
-func (r *SQLiteRepository) ensureLogColumn(colName, colDef string) error {
-	has, err := r.hasColumn("request_logs", colName)
-	if err != nil {
-		return err
-	}
-	if has {
-		return nil
+// SetQueryTimeout bounds each LogReader call: a query still running after d
+// is interrupted and returns context.DeadlineExceeded, so a slow aggregate
+// can't hold a pooled connection indefinitely. 0 disables the bound (the
+// caller's context still applies). Call it before the repository is shared.
+func (r *SQLiteRepository) SetQueryTimeout(d time.Duration) {
+	r.queryTimeout = d
+}
+
+// readContext derives the context of one LogReader call from the caller's.
+func (r *SQLiteRepository) readContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
 	}
-	if _, err := r.db.Exec(fmt.Sprintf("ALTER TABLE request_logs ADD COLUMN %s", colDef)); err != nil {
-		return fmt.Errorf("add column %s failed: %w", colName, err)
+	return context.WithTimeout(ctx, r.queryTimeout)
+}
+
+// reader returns the pool for LogReader queries.
+func (r *SQLiteRepository) reader() *sql.DB {
+	if r.rdb != nil {
+		return r.rdb
 	}
-	return nil
+	return r.db
 }
 
-func (r *SQLiteRepository) hasColumn(table, colName string) (bool, error) {
-	rows, err := r.db.Query(fmt.Sprintf("PRAGMA table_info(%s);", table))
-	if err != nil {
-		return false, err
+// NewMemorySQLiteRepository creates a repository whose database lives only
+// in process memory (SQLite's memdb VFS), for ephemeral sessions. The pool
+// shares one database, which is gone once the repository is closed.
+func NewMemorySQLiteRepository() (*SQLiteRepository, error) {
+	// The leading slash makes the memdb database shared by all connections.
+	dsn := "file:/prismcat-" + uuid.New().String() + "?vfs=memdb&_pragma=busy_timeout(5000)"
+	return NewSQLiteRepository(dsn)
+}
+
+// TrimToSize deletes the oldest logs, a batch at a time, until the pages in
+// use fit in maxBytes, and returns how many were deleted. It caps the
+// in-memory database; the traffic aggregate is kept.
+func (r *SQLiteRepository) TrimToSize(maxBytes int64) (int64, error) {
+	if maxBytes <= 0 {
+		return 0, nil
 	}
-	defer rows.Close()
+	var deleted int64
+	for {
+		var pageSize, pages, freePages int64
+		if err := r.db.QueryRow("PRAGMA page_size").Scan(&pageSize); err != nil {
+			return deleted, err
+		}
+		if err := r.db.QueryRow("PRAGMA page_count").Scan(&pages); err != nil {
+			return deleted, err
+		}
+		if err := r.db.QueryRow("PRAGMA freelist_count").Scan(&freePages); err != nil {
+			return deleted, err
+		}
+		if (pages-freePages)*pageSize <= maxBytes {
+			return deleted, nil
+		}
 
-	for rows.Next() {
-		var cid int
-		var name string
-		var ctype string
-		var notnull int
-		var dfltValue any
-		var pk int
-		if err := rows.Scan(&cid, &name, &ctype, &notnull, &dfltValue, &pk); err != nil {
-			return false, err
+		var rows int64
+		if err := r.db.QueryRow("SELECT COUNT(*) FROM request_logs").Scan(&rows); err != nil {
+			return deleted, err
+		}
+		batch := rows / 10
+		if batch < 100 {
+			batch = 100
+		}
+		result, err := r.db.Exec("DELETE FROM request_logs WHERE id IN (SELECT id FROM request_logs ORDER BY created_at ASC LIMIT ?)", batch)
+		if err != nil {
+			return deleted, err
 		}
-		if name == colName {
-			return true, nil
+		n, _ := result.RowsAffected()
+		if n == 0 {
+			return deleted, nil
 		}
+		deleted += n
 	}
-	if err := rows.Err(); err != nil {
-		return false, err
+}
+
+func applySQLitePragmas(db *sql.DB) error {
+	// Use Query so PRAGMA statements that return rows are handled consistently.
+	pragmas := []string{
+		"PRAGMA journal_mode=WAL;",
+		"PRAGMA synchronous=NORMAL;",
+		"PRAGMA busy_timeout=5000;",
 	}
-	return false, nil
+	for _, stmt := range pragmas {
+		rows, err := db.Query(stmt)
+		if err != nil {
+			return fmt.Errorf("apply sqlite pragma failed (%s): %w", stmt, err)
+		}
+		_ = rows.Close()
+	}
+	return nil
 }
 
 // SaveLog inserts or updates a log entry (upsert by id).
-func (r *SQLiteRepository) SaveLog(log *RequestLog) error {
+func (r *SQLiteRepository) SaveLog(ctx context.Context, log *RequestLog) error {
 	if log.ID == "" {
 		log.ID = uuid.New().String()
 	}
@@ -169,8 +212,9 @@ func (r *SQLiteRepository) SaveLog(log *RequestLog) error {
 		id, created_at, upstream, target_url, method, path, query,
 		request_headers, request_body, request_body_ref, request_body_size,
 		status_code, response_headers, response_body, response_body_ref, response_body_size,
-		streaming, latency_ms, error, truncated, tag
-	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		streaming, latency_ms, error, truncated, tag, flags, variant, client_ip, remote_addr, user_agent, source, event_timings, stream_events, conn_timings, fingerprint,
+		duplicate_of, duplicate_count, model, input_tokens, output_tokens, total_tokens
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	ON CONFLICT(id) DO UPDATE SET
 		created_at = excluded.created_at,
 		upstream = excluded.upstream,
@@ -191,31 +235,181 @@ func (r *SQLiteRepository) SaveLog(log *RequestLog) error {
 		latency_ms = excluded.latency_ms,
 		error = excluded.error,
 		truncated = excluded.truncated,
-		tag = excluded.tag
+		tag = excluded.tag,
+		flags = excluded.flags,
+		variant = excluded.variant,
+		client_ip = excluded.client_ip,
+		remote_addr = excluded.remote_addr,
+		user_agent = excluded.user_agent,
+		source = excluded.source,
+		event_timings = excluded.event_timings,
+		stream_events = excluded.stream_events,
+		conn_timings = excluded.conn_timings,
+		fingerprint = excluded.fingerprint,
+		duplicate_of = excluded.duplicate_of,
+		duplicate_count = excluded.duplicate_count,
+		model = excluded.model,
+		input_tokens = excluded.input_tokens,
+		output_tokens = excluded.output_tokens,
+		total_tokens = excluded.total_tokens
 	`
 
-	_, err := r.db.Exec(query,
+	args := []interface{}{
 		log.ID, log.CreatedAt, log.Upstream, log.TargetURL, log.Method, log.Path, log.Query,
 		string(reqHeaders), log.RequestBody, log.RequestBodyRef, log.RequestBodySize,
 		log.StatusCode, string(respHeaders), log.ResponseBody, log.ResponseBodyRef, log.ResponseBodySize,
-		log.Streaming, log.Latency, log.Error, log.Truncated, log.Tag,
-	)
-	return err
+		log.Streaming, log.Latency, log.Error, log.Truncated, log.Tag, joinFlags(log.Flags), log.Variant, log.ClientIP, log.RemoteAddr, log.UserAgent, log.Source,
+		marshalEventTimings(log.EventTimings), log.StreamEvents, marshalConnTimings(log.Connection), log.Fingerprint,
+		log.DuplicateOf, log.DuplicateCount, log.Model, log.InputTokens, log.OutputTokens, log.TotalTokens,
+	}
+	if len(log.Properties) == 0 && len(log.Metadata) == 0 && len(log.Checks) == 0 {
+		_, err := r.db.ExecContext(ctx, query, args...)
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return err
+	}
+	if err := setKV(ctx, tx, "log_properties", log.ID, log.Properties); err != nil {
+		return err
+	}
+	if err := setKV(ctx, tx, "log_metadata", log.ID, log.Metadata); err != nil {
+		return err
+	}
+	if err := setChecks(ctx, tx, log.ID, log.Checks); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (r *SQLiteRepository) GetLog(id string) (*RequestLog, error) {
+func (r *SQLiteRepository) GetLog(ctx context.Context, id string) (*RequestLog, error) {
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
 	query := `
 	SELECT id, created_at, upstream, target_url, method, path, query,
 		request_headers, request_body, request_body_ref, request_body_size,
 		status_code, response_headers, response_body, response_body_ref, response_body_size,
-		streaming, latency_ms, error, truncated, tag
+		streaming, latency_ms, error, truncated, tag, flags, variant, client_ip, remote_addr, user_agent, source, event_timings, stream_events, conn_timings, fingerprint,
+		duplicate_of, duplicate_count, model, input_tokens, output_tokens, total_tokens
 	FROM request_logs WHERE id = ?
 	`
-	row := r.db.QueryRow(query, id)
-	return r.scanLog(row)
+	row := r.reader().QueryRowContext(ctx, query, id)
+	log, err := r.scanLog(row)
+	if err != nil {
+		return nil, err
+	}
+	if log.Properties, err = r.getKV(ctx, r.reader(), "log_properties", id); err != nil {
+		return nil, err
+	}
+	if log.Metadata, err = r.getKV(ctx, r.reader(), "log_metadata", id); err != nil {
+		return nil, err
+	}
+	if log.Checks, err = r.getChecks(ctx, id); err != nil {
+		return nil, err
+	}
+	return log, nil
+}
+
+// GetLogMetadata returns the metadata of a log (nil when it has none), or
+// ErrLogNotFound.
+func (r *SQLiteRepository) GetLogMetadata(ctx context.Context, id string) (map[string]string, error) {
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
+	if err := r.logExists(ctx, r.reader(), id); err != nil {
+		return nil, err
+	}
+	return r.getKV(ctx, r.reader(), "log_metadata", id)
+}
+
+// SetLogMetadata sets metadata keys on an existing log; an empty value
+// removes the key. Returns ErrLogNotFound for unknown IDs.
+func (r *SQLiteRepository) SetLogMetadata(ctx context.Context, id string, md map[string]string) error {
+	if err := r.logExists(ctx, r.db, id); err != nil {
+		return err
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := setKV(ctx, tx, "log_metadata", id, md); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *SQLiteRepository) logExists(ctx context.Context, db *sql.DB, id string) error {
+	var one int
+	err := db.QueryRowContext(ctx, "SELECT 1 FROM request_logs WHERE id = ?", id).Scan(&one)
+	if err == sql.ErrNoRows {
+		return ErrLogNotFound
+	}
+	return err
 }
 
-func (r *SQLiteRepository) ListLogs(filter LogFilter) ([]*RequestLog, int64, error) {
+// setKV upserts kv into a per-log key/value table; empty values delete.
+func setKV(ctx context.Context, tx *sql.Tx, table, id string, kv map[string]string) error {
+	for k, v := range kv {
+		var err error
+		if v == "" {
+			_, err = tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE log_id = ? AND key = ?", id, k)
+		} else {
+			_, err = tx.ExecContext(ctx, "INSERT OR REPLACE INTO "+table+" (log_id, key, value) VALUES (?, ?, ?)", id, k, v)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getKV reads a log's entries from a per-log key/value table, nil when it
+// has none.
+func (r *SQLiteRepository) getKV(ctx context.Context, db *sql.DB, table, id string) (map[string]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT key, value FROM "+table+" WHERE log_id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var kv map[string]string
+	for rows.Next() {
+		var k, v string
+		if err := rows.Scan(&k, &v); err != nil {
+			return nil, err
+		}
+		if kv == nil {
+			kv = make(map[string]string)
+		}
+		kv[k] = v
+	}
+	return kv, rows.Err()
+}
+
+// appendKVConditions requires every key in kv to match exactly in table.
+func appendKVConditions(conditions []string, args []interface{}, table string, kv map[string]string) ([]string, []interface{}) {
+	keys := make([]string, 0, len(kv))
+	for k := range kv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		conditions = append(conditions, "id IN (SELECT log_id FROM "+table+" WHERE key = ? AND value = ?)")
+		args = append(args, k, kv[k])
+	}
+	return conditions, args
+}
+
+func (r *SQLiteRepository) ListLogs(ctx context.Context, filter LogFilter) ([]*RequestLog, int64, error) {
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
 	var conditions []string
 	var args []interface{}
 
@@ -235,6 +429,10 @@ func (r *SQLiteRepository) ListLogs(filter LogFilter) ([]*RequestLog, int64, err
 		conditions = append(conditions, "path LIKE ?")
 		args = append(args, "%"+filter.Path+"%")
 	}
+	if filter.PathRegex != "" {
+		conditions = append(conditions, "path REGEXP ?")
+		args = append(args, filter.PathRegex)
+	}
 	if filter.StartTime != nil {
 		conditions = append(conditions, "created_at >= ?")
 		args = append(args, *filter.StartTime)
@@ -258,6 +456,82 @@ func (r *SQLiteRepository) ListLogs(filter LogFilter) ([]*RequestLog, int64, err
 		conditions = append(conditions, "tag = ?")
 		args = append(args, filter.Tag)
 	}
+	if filter.ClientIP != "" {
+		conditions = append(conditions, "client_ip = ?")
+		args = append(args, filter.ClientIP)
+	}
+	conditions, args = appendInCondition(conditions, args, "upstream", filter.Upstreams, false)
+	conditions, args = appendInCondition(conditions, args, "upstream", filter.ExcludeUpstreams, true)
+	conditions, args = appendInCondition(conditions, args, "method", filter.Methods, false)
+	conditions, args = appendInCondition(conditions, args, "method", filter.ExcludeMethods, true)
+	conditions, args = appendInCondition(conditions, args, "COALESCE(tag, '')", filter.Tags, false)
+	conditions, args = appendInCondition(conditions, args, "COALESCE(tag, '')", filter.ExcludeTags, true)
+	if len(filter.Paths) > 0 {
+		var ors []string
+		for _, p := range filter.Paths {
+			ors = append(ors, "path LIKE ?")
+			args = append(args, "%"+p+"%")
+		}
+		conditions = append(conditions, "("+strings.Join(ors, " OR ")+")")
+	}
+	for _, p := range filter.ExcludePaths {
+		conditions = append(conditions, "path NOT LIKE ?")
+		args = append(args, "%"+p+"%")
+	}
+	if cond, condArgs := statusRangeCondition(filter.StatusCodes); cond != "" {
+		conditions = append(conditions, cond)
+		args = append(args, condArgs...)
+	}
+	if cond, condArgs := statusRangeCondition(filter.ExcludeStatusCodes); cond != "" {
+		conditions = append(conditions, "NOT "+cond)
+		args = append(args, condArgs...)
+	}
+	if filter.MinLatencyMs > 0 {
+		conditions = append(conditions, "latency_ms >= ?")
+		args = append(args, filter.MinLatencyMs)
+	}
+	if filter.MaxLatencyMs > 0 {
+		conditions = append(conditions, "latency_ms <= ?")
+		args = append(args, filter.MaxLatencyMs)
+	}
+	if filter.MinBodySize > 0 {
+		conditions = append(conditions, "MAX(request_body_size, response_body_size) >= ?")
+		args = append(args, filter.MinBodySize)
+	}
+	if filter.MaxBodySize > 0 {
+		conditions = append(conditions, "MAX(request_body_size, response_body_size) <= ?")
+		args = append(args, filter.MaxBodySize)
+	}
+	if filter.RemoteAddr != "" {
+		conditions = append(conditions, "remote_addr = ?")
+		args = append(args, filter.RemoteAddr)
+	}
+	if filter.UserAgent != "" {
+		conditions = append(conditions, "user_agent LIKE ?")
+		args = append(args, "%"+filter.UserAgent+"%")
+	}
+	if filter.Variant != "" {
+		conditions = append(conditions, "variant = ?")
+		args = append(args, filter.Variant)
+	}
+	if filter.Source != "" {
+		conditions = append(conditions, "source = ?")
+		args = append(args, filter.Source)
+	}
+	if filter.Fingerprint != "" {
+		conditions = append(conditions, "fingerprint = ?")
+		args = append(args, filter.Fingerprint)
+	}
+	if filter.DuplicateOf != "" {
+		conditions = append(conditions, "duplicate_of = ?")
+		args = append(args, filter.DuplicateOf)
+	}
+	if filter.Flag != "" {
+		conditions = append(conditions, "(',' || COALESCE(flags, '') || ',') LIKE ?")
+		args = append(args, "%,"+filter.Flag+",%")
+	}
+	conditions, args = appendKVConditions(conditions, args, "log_properties", filter.Properties)
+	conditions, args = appendKVConditions(conditions, args, "log_metadata", filter.Metadata)
 
 	where := ""
 	if len(conditions) > 0 {
@@ -267,7 +541,7 @@ func (r *SQLiteRepository) ListLogs(filter LogFilter) ([]*RequestLog, int64, err
 	// Total count (for pagination).
 	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM request_logs %s", where)
 	var total int64
-	if err := r.db.QueryRow(countQuery, args...).Scan(&total); err != nil {
+	if err := r.reader().QueryRowContext(ctx, countQuery, args...).Scan(&total); err != nil {
 		return nil, 0, err
 	}
 
@@ -282,14 +556,15 @@ func (r *SQLiteRepository) ListLogs(filter LogFilter) ([]*RequestLog, int64, err
 	query := fmt.Sprintf(`
 	SELECT id, created_at, upstream, target_url, method, path, query,
 		request_body_size, status_code, response_body_size,
-		streaming, latency_ms, error, truncated, tag
+		streaming, latency_ms, error, truncated, tag, flags, variant, client_ip, remote_addr, user_agent, source, fingerprint,
+		duplicate_of, duplicate_count, model, input_tokens, output_tokens, total_tokens
 	FROM request_logs %s
 	ORDER BY created_at DESC
 	LIMIT ? OFFSET ?
 	`, where)
 
 	args = append(args, filter.Limit, filter.Offset)
-	rows, err := r.db.Query(query, args...)
+	rows, err := r.reader().QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -310,15 +585,73 @@ func (r *SQLiteRepository) ListLogs(filter LogFilter) ([]*RequestLog, int64, err
 	return logs, total, nil
 }
 
-func (r *SQLiteRepository) DeleteLogsBefore(before time.Time) (int64, error) {
-	result, err := r.db.Exec("DELETE FROM request_logs WHERE created_at < ?", before)
+func (r *SQLiteRepository) DeleteLogsBefore(ctx context.Context, before time.Time) (int64, error) {
+	result, err := r.db.ExecContext(ctx, "DELETE FROM request_logs WHERE created_at < ?", before)
 	if err != nil {
 		return 0, err
 	}
 	return result.RowsAffected()
 }
 
-func (r *SQLiteRepository) GetStats(since *time.Time) (*LogStats, error) {
+// deleteLogsBatch keeps the IN list well below SQLite's bound-parameter limit.
+const deleteLogsBatch = 500
+
+func (r *SQLiteRepository) DeleteLogs(ctx context.Context, ids []string) (int64, error) {
+	var deleted int64
+	for len(ids) > 0 {
+		batch := ids
+		if len(batch) > deleteLogsBatch {
+			batch = batch[:deleteLogsBatch]
+		}
+		ids = ids[len(batch):]
+
+		args := make([]interface{}, len(batch))
+		for i, id := range batch {
+			args[i] = id
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(batch)), ",")
+		result, err := r.db.ExecContext(ctx, "DELETE FROM request_logs WHERE id IN ("+placeholders+")", args...)
+		if err != nil {
+			return deleted, err
+		}
+		n, _ := result.RowsAffected()
+		deleted += n
+	}
+	return deleted, nil
+}
+
+func (r *SQLiteRepository) ScanLogContent(ctx context.Context, fn func(*RequestLog) error) error {
+	rows, err := r.db.QueryContext(ctx, `
+	SELECT id, path, query, request_body, request_body_ref, response_body, response_body_ref
+	FROM request_logs
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var log RequestLog
+		var query, reqBody, reqRef, respBody, respRef sql.NullString
+		if err := rows.Scan(&log.ID, &log.Path, &query, &reqBody, &reqRef, &respBody, &respRef); err != nil {
+			return err
+		}
+		log.Query = query.String
+		log.RequestBody, log.RequestBodyRef = reqBody.String, reqRef.String
+		log.ResponseBody, log.ResponseBodyRef = respBody.String, respRef.String
+		if err := fn(&log); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// GetStats runs its three scans of request_logs concurrently on the read
+// pool; the first failure cancels the others.
+func (r *SQLiteRepository) GetStats(ctx context.Context, since *time.Time) (*LogStats, error) {
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
 	stats := &LogStats{
 		ByUpstream:   make(map[string]int64),
 		ByStatusCode: make(map[int]int64),
@@ -337,65 +670,524 @@ func (r *SQLiteRepository) GetStats(since *time.Time) (*LogStats, error) {
 		SUM(CASE WHEN status_code >= 200 AND status_code < 400 THEN 1 ELSE 0 END) as success,
 		SUM(CASE WHEN (error IS NOT NULL AND error != '') OR status_code >= 400 THEN 1 ELSE 0 END) as errors,
 		SUM(CASE WHEN streaming = 1 THEN 1 ELSE 0 END) as streaming,
-		COALESCE(AVG(latency_ms), 0) as avg_latency
+		COALESCE(AVG(latency_ms), 0) as avg_latency,
+		SUM(CASE WHEN (',' || COALESCE(flags, '') || ',') LIKE '%%,schema_invalid,%%' THEN 1 ELSE 0 END) as schema_invalid,
+		SUM(CASE WHEN (',' || COALESCE(flags, '') || ',') LIKE '%%,check_failed,%%' THEN 1 ELSE 0 END) as check_failed,
+		COALESCE(SUM(input_tokens), 0) as input_tokens,
+		COALESCE(SUM(output_tokens), 0) as output_tokens,
+		COALESCE(SUM(total_tokens), 0) as total_tokens
 	FROM request_logs %s
 	`, where)
 
-	if err := r.db.QueryRow(query, args...).Scan(
+	scans := []func() error{
+		func() error {
+			return r.reader().QueryRowContext(ctx, query, args...).Scan(
+				&stats.TotalRequests,
+				&stats.SuccessCount,
+				&stats.ErrorCount,
+				&stats.StreamingCount,
+				&stats.AvgLatency,
+				&stats.SchemaInvalid,
+				&stats.CheckFailed,
+				&stats.InputTokens,
+				&stats.OutputTokens,
+				&stats.TotalTokens,
+			)
+		},
+		func() error {
+			return r.countBy(ctx, "upstream", where, args, func(rows *sql.Rows) error {
+				var upstream string
+				var count int64
+				if err := rows.Scan(&upstream, &count); err != nil {
+					return err
+				}
+				stats.ByUpstream[upstream] = count
+				return nil
+			})
+		},
+		func() error {
+			return r.countBy(ctx, "status_code", where, args, func(rows *sql.Rows) error {
+				var code int
+				var count int64
+				if err := rows.Scan(&code, &count); err != nil {
+					return err
+				}
+				stats.ByStatusCode[code] = count
+				return nil
+			})
+		},
+	}
+
+	errs := make([]error, len(scans))
+	var wg sync.WaitGroup
+	for i, scan := range scans {
+		wg.Add(1)
+		go func(i int, scan func() error) {
+			defer wg.Done()
+			if errs[i] = scan(); errs[i] != nil {
+				cancel()
+			}
+		}(i, scan)
+	}
+	wg.Wait()
+
+	// Report the failure itself, not the cancellations it caused.
+	var first error
+	for _, err := range errs {
+		switch {
+		case err == nil:
+		case first == nil || errors.Is(first, context.Canceled) && !errors.Is(err, context.Canceled):
+			first = err
+		}
+	}
+	if first != nil {
+		return nil, first
+	}
+	return stats, nil
+}
+
+// countBy runs "SELECT column, COUNT(*) ... GROUP BY column" over
+// request_logs and calls scan for each row.
+func (r *SQLiteRepository) countBy(ctx context.Context, column, where string, args []interface{}, scan func(*sql.Rows) error) error {
+	rows, err := r.reader().QueryContext(ctx, fmt.Sprintf("SELECT %s, COUNT(*) FROM request_logs %s GROUP BY %s", column, where, column), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (r *SQLiteRepository) GetPropertyStats(ctx context.Context, key string, since *time.Time, limit int) ([]PropertyStat, error) {
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
+	if limit <= 0 {
+		limit = 50
+	}
+	if limit > 1000 {
+		limit = 1000
+	}
+	where := "WHERE p.key = ?"
+	args := []interface{}{key}
+	if since != nil {
+		where += " AND l.created_at >= ?"
+		args = append(args, *since)
+	}
+	args = append(args, limit)
+
+	rows, err := r.reader().QueryContext(ctx, fmt.Sprintf(`
+	SELECT p.value,
+		COUNT(*) as total,
+		SUM(CASE WHEN (l.error IS NOT NULL AND l.error != '') OR l.status_code >= 400 THEN 1 ELSE 0 END) as errors,
+		COALESCE(AVG(l.latency_ms), 0) as avg_latency
+	FROM log_properties p JOIN request_logs l ON l.id = p.log_id
+	%s
+	GROUP BY p.value
+	ORDER BY total DESC, p.value
+	LIMIT ?
+	`, where), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []PropertyStat{}
+	for rows.Next() {
+		var st PropertyStat
+		if err := rows.Scan(&st.Value, &st.Count, &st.ErrorCount, &st.AvgLatency); err != nil {
+			return nil, err
+		}
+		stats = append(stats, st)
+	}
+	return stats, rows.Err()
+}
+
+// topErrorsLimit is how many distinct error messages GetUpstreamStats returns.
+const topErrorsLimit = 5
+
+func (r *SQLiteRepository) GetUpstreamStats(ctx context.Context, upstream string, since *time.Time) (*UpstreamStats, error) {
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
+	stats := &UpstreamStats{
+		Upstream:     upstream,
+		ByStatusCode: make(map[int]int64),
+		TopErrors:    []ErrorStat{},
+	}
+
+	// In-flight logs (saved before the response) have neither yet.
+	where := "WHERE upstream = ? AND (status_code > 0 OR (error IS NOT NULL AND error != ''))"
+	args := []interface{}{upstream}
+	if since != nil {
+		where += " AND created_at >= ?"
+		args = append(args, *since)
+	}
+	const failed = "((error IS NOT NULL AND error != '') OR status_code >= 400)"
+
+	if err := r.reader().QueryRowContext(ctx, fmt.Sprintf(`
+	SELECT
+		COUNT(*),
+		COALESCE(SUM(CASE WHEN status_code >= 200 AND status_code < 400 THEN 1 ELSE 0 END), 0),
+		COALESCE(SUM(CASE WHEN %[2]s THEN 1 ELSE 0 END), 0),
+		COALESCE(SUM(CASE WHEN streaming = 1 THEN 1 ELSE 0 END), 0),
+		COALESCE(SUM(CASE WHEN status_code >= 400 AND status_code < 500 THEN 1 ELSE 0 END), 0),
+		COALESCE(SUM(CASE WHEN status_code >= 500 THEN 1 ELSE 0 END), 0),
+		COALESCE(SUM(CASE WHEN status_code = 0 THEN 1 ELSE 0 END), 0),
+		COALESCE(AVG(latency_ms), 0),
+		COALESCE(SUM(input_tokens), 0),
+		COALESCE(SUM(output_tokens), 0),
+		COALESCE(SUM(total_tokens), 0)
+	FROM request_logs %[1]s
+	`, where, failed), args...).Scan(
 		&stats.TotalRequests,
 		&stats.SuccessCount,
 		&stats.ErrorCount,
 		&stats.StreamingCount,
-		&stats.AvgLatency,
+		&stats.ClientErrors,
+		&stats.ServerErrors,
+		&stats.NetworkErrors,
+		&stats.Latency.Avg,
+		&stats.InputTokens,
+		&stats.OutputTokens,
+		&stats.TotalTokens,
 	); err != nil {
 		return nil, err
 	}
+	if stats.TotalRequests == 0 {
+		return stats, nil
+	}
 
-	upstreamQuery := fmt.Sprintf("SELECT upstream, COUNT(*) FROM request_logs %s GROUP BY upstream", where)
-	rows, err := r.db.Query(upstreamQuery, args...)
+	rows, err := r.reader().QueryContext(ctx, fmt.Sprintf("SELECT status_code, COUNT(*) FROM request_logs %s AND status_code >= 400 GROUP BY status_code", where), args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	for rows.Next() {
-		var upstream string
+		var code int
 		var count int64
-		if err := rows.Scan(&upstream, &count); err != nil {
+		if err := rows.Scan(&code, &count); err != nil {
 			return nil, err
 		}
-		stats.ByUpstream[upstream] = count
+		stats.ByStatusCode[code] = count
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 
-	statusQuery := fmt.Sprintf("SELECT status_code, COUNT(*) FROM request_logs %s GROUP BY status_code", where)
-	rows2, err := r.db.Query(statusQuery, args...)
+	errRows, err := r.reader().QueryContext(ctx, fmt.Sprintf(`
+	SELECT error, COUNT(*) AS n FROM request_logs %s AND error IS NOT NULL AND error != ''
+	GROUP BY error ORDER BY n DESC, error LIMIT ?
+	`, where), append(args, topErrorsLimit)...)
 	if err != nil {
 		return nil, err
 	}
-	defer rows2.Close()
-	for rows2.Next() {
-		var code int
+	defer errRows.Close()
+	for errRows.Next() {
+		var st ErrorStat
+		if err := errRows.Scan(&st.Message, &st.Count); err != nil {
+			return nil, err
+		}
+		stats.TopErrors = append(stats.TopErrors, st)
+	}
+	if err := errRows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Percentiles in one ordered pass: the latency at index q*(n-1).
+	latRows, err := r.reader().QueryContext(ctx, fmt.Sprintf("SELECT latency_ms FROM request_logs %s ORDER BY latency_ms", where), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer latRows.Close()
+	at := func(q float64) int64 { return int64(q * float64(stats.TotalRequests-1)) }
+	targets := []struct {
+		index int64
+		dst   *int64
+	}{
+		{at(0.50), &stats.Latency.P50},
+		{at(0.90), &stats.Latency.P90},
+		{at(0.95), &stats.Latency.P95},
+		{at(0.99), &stats.Latency.P99},
+	}
+	for i := int64(0); latRows.Next(); i++ {
+		var ms int64
+		if err := latRows.Scan(&ms); err != nil {
+			return nil, err
+		}
+		for _, t := range targets {
+			if t.index == i {
+				*t.dst = ms
+			}
+		}
+		stats.Latency.Max = ms
+	}
+	if err := latRows.Err(); err != nil {
+		return nil, err
+	}
+
+	// ORDER BY keeps the column type so the driver returns a time.Time.
+	for _, q := range []struct {
+		cond string
+		dst  **time.Time
+	}{
+		{"", &stats.LastSeenAt},
+		{" AND " + failed, &stats.LastErrorAt},
+	} {
+		var t time.Time
+		err := r.reader().QueryRowContext(ctx, fmt.Sprintf("SELECT created_at FROM request_logs %s%s ORDER BY created_at DESC LIMIT 1", where, q.cond), args...).Scan(&t)
+		switch {
+		case err == nil:
+			*q.dst = &t
+		case err != sql.ErrNoRows:
+			return nil, err
+		}
+	}
+	return stats, nil
+}
+
+func (r *SQLiteRepository) GetTrafficStats(ctx context.Context, from, to, upstream string) ([]TrafficStat, error) {
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
+	conditions := []string{"day >= ?", "day <= ?"}
+	args := []interface{}{from, to}
+	if upstream != "" {
+		conditions = append(conditions, "upstream = ?")
+		args = append(args, upstream)
+	}
+	rows, err := r.reader().QueryContext(ctx, fmt.Sprintf(`
+	SELECT day, upstream, requests, bytes_in, bytes_out
+	FROM traffic_daily WHERE %s
+	ORDER BY day, upstream
+	`, strings.Join(conditions, " AND ")), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []TrafficStat{}
+	for rows.Next() {
+		var st TrafficStat
+		if err := rows.Scan(&st.Day, &st.Upstream, &st.Requests, &st.BytesIn, &st.BytesOut); err != nil {
+			return nil, err
+		}
+		stats = append(stats, st)
+	}
+	return stats, rows.Err()
+}
+
+func (r *SQLiteRepository) GetHourlyRollups(ctx context.Context, from, to, upstream, model string) ([]HourlyRollup, error) {
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
+	conditions := []string{"hour >= ?", "hour <= ?", "requests > 0"}
+	args := []interface{}{from, to}
+	if upstream != "" {
+		conditions = append(conditions, "upstream = ?")
+		args = append(args, upstream)
+	}
+	if model != "" {
+		conditions = append(conditions, "model = ?")
+		args = append(args, model)
+	}
+	rows, err := r.reader().QueryContext(ctx, fmt.Sprintf(`
+	SELECT hour, upstream, model, status_class, requests, input_tokens, output_tokens, latency_ms_sum
+	FROM rollup_hourly WHERE %s
+	ORDER BY hour, upstream, model, status_class
+	`, strings.Join(conditions, " AND ")), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rollups := []HourlyRollup{}
+	for rows.Next() {
+		var h HourlyRollup
+		if err := rows.Scan(&h.Hour, &h.Upstream, &h.Model, &h.StatusClass, &h.Requests, &h.InputTokens, &h.OutputTokens, &h.LatencyMsSum); err != nil {
+			return nil, err
+		}
+		rollups = append(rollups, h)
+	}
+	return rollups, rows.Err()
+}
+
+// GetStorageStats reports database file sizes and row counts. File sizes are
+// best-effort: in-memory or URI-style paths report 0.
+func (r *SQLiteRepository) GetStorageStats(ctx context.Context) (*StorageStats, error) {
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
+	stats := &StorageStats{RowsByUpstream: make(map[string]int64)}
+	if v, err := r.SchemaVersion(); err == nil {
+		stats.SchemaVersion = v
+	}
+	if info, err := os.Stat(r.path); err == nil {
+		stats.DBBytes = info.Size()
+	}
+	if info, err := os.Stat(r.path + "-wal"); err == nil {
+		stats.WALBytes = info.Size()
+	}
+
+	var pageSize, freePages int64
+	if err := r.db.QueryRowContext(ctx, "PRAGMA page_size").Scan(&pageSize); err != nil {
+		return nil, err
+	}
+	if err := r.db.QueryRowContext(ctx, "PRAGMA freelist_count").Scan(&freePages); err != nil {
+		return nil, err
+	}
+	stats.FreeBytes = pageSize * freePages
+
+	rows, err := r.reader().QueryContext(ctx, "SELECT upstream, COUNT(*) FROM request_logs GROUP BY upstream")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var upstream string
 		var count int64
-		if err := rows2.Scan(&code, &count); err != nil {
+		if err := rows.Scan(&upstream, &count); err != nil {
 			return nil, err
 		}
-		stats.ByStatusCode[code] = count
+		stats.RowsByUpstream[upstream] = count
+		stats.Rows += count
 	}
-	if err := rows2.Err(); err != nil {
+	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 
+	// ORDER BY keeps the column type so the driver returns a time.Time.
+	var oldest time.Time
+	err = r.reader().QueryRowContext(ctx, "SELECT created_at FROM request_logs ORDER BY created_at ASC LIMIT 1").Scan(&oldest)
+	switch {
+	case err == nil:
+		stats.OldestAt = &oldest
+	case err != sql.ErrNoRows:
+		return nil, err
+	}
 	return stats, nil
 }
 
+// GetUpstreamFootprints sums inline body bytes per upstream, plus the size of
+// the distinct blobs each upstream references. Blob sizes come from the body
+// size columns, which match the stored blob for spilled bodies.
+func (r *SQLiteRepository) GetUpstreamFootprints(ctx context.Context) ([]UpstreamFootprint, error) {
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+
+	byUpstream := make(map[string]*UpstreamFootprint)
+	get := func(upstream string) *UpstreamFootprint {
+		f, ok := byUpstream[upstream]
+		if !ok {
+			f = &UpstreamFootprint{Upstream: upstream}
+			byUpstream[upstream] = f
+		}
+		return f
+	}
+
+	rows, err := r.reader().QueryContext(ctx, `
+	SELECT upstream, COUNT(*),
+		IFNULL(SUM(IFNULL(LENGTH(CAST(request_body AS BLOB)), 0) + IFNULL(LENGTH(CAST(response_body AS BLOB)), 0)), 0)
+	FROM request_logs GROUP BY upstream`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var upstream string
+		var count, inline int64
+		if err := rows.Scan(&upstream, &count, &inline); err != nil {
+			return nil, err
+		}
+		f := get(upstream)
+		f.Rows, f.InlineBytes = count, inline
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// UNION drops refs repeated within an upstream (deduplicated bodies).
+	blobRows, err := r.reader().QueryContext(ctx, `
+	SELECT upstream, COUNT(*), IFNULL(SUM(size), 0) FROM (
+		SELECT upstream, request_body_ref AS ref, request_body_size AS size
+		FROM request_logs WHERE request_body_ref IS NOT NULL AND request_body_ref != ''
+		UNION
+		SELECT upstream, response_body_ref, response_body_size
+		FROM request_logs WHERE response_body_ref IS NOT NULL AND response_body_ref != ''
+	) GROUP BY upstream`)
+	if err != nil {
+		return nil, err
+	}
+	defer blobRows.Close()
+	for blobRows.Next() {
+		var upstream string
+		var count, size int64
+		if err := blobRows.Scan(&upstream, &count, &size); err != nil {
+			return nil, err
+		}
+		f := get(upstream)
+		f.BlobCount, f.BlobBytes = count, size
+	}
+	if err := blobRows.Err(); err != nil {
+		return nil, err
+	}
+
+	out := make([]UpstreamFootprint, 0, len(byUpstream))
+	for _, f := range byUpstream {
+		f.TotalBytes = f.InlineBytes + f.BlobBytes
+		out = append(out, *f)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].TotalBytes != out[j].TotalBytes {
+			return out[i].TotalBytes > out[j].TotalBytes
+		}
+		return out[i].Upstream < out[j].Upstream
+	})
+	return out, nil
+}
+
+// Snapshot writes a consistent copy of the database to dstPath using SQLite's
+// online backup API, which (unlike copying the file) includes WAL contents.
+func (r *SQLiteRepository) Snapshot(ctx context.Context, dstPath string) error {
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn interface{}) error {
+		src, ok := driverConn.(interface {
+			NewBackup(dstURI string) (*sqlite.Backup, error)
+		})
+		if !ok {
+			return errors.New("sqlite driver does not support online backup")
+		}
+		b, err := src.NewBackup(dstPath)
+		if err != nil {
+			return err
+		}
+		// One step copies everything under a single read transaction.
+		if _, err := b.Step(-1); err != nil {
+			_ = b.Finish()
+			return err
+		}
+		return b.Finish()
+	})
+}
+
 func (r *SQLiteRepository) Close() error {
+	if r.rdb != nil {
+		_ = r.rdb.Close()
+	}
 	return r.db.Close()
 }
 
 // ListBlobRefs returns all distinct blob refs currently referenced by logs.
-func (r *SQLiteRepository) ListBlobRefs() ([]string, error) {
+func (r *SQLiteRepository) ListBlobRefs(ctx context.Context) ([]string, error) {
 	query := `
 	SELECT request_body_ref AS ref
 	FROM request_logs
@@ -405,7 +1197,7 @@ func (r *SQLiteRepository) ListBlobRefs() ([]string, error) {
 	FROM request_logs
 	WHERE response_body_ref IS NOT NULL AND response_body_ref != ''
 	`
-	rows, err := r.db.Query(query)
+	rows, err := r.db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -427,14 +1219,184 @@ func (r *SQLiteRepository) ListBlobRefs() ([]string, error) {
 	return refs, nil
 }
 
+// BlobRefLastUsed maps every referenced blob ref to the newest created_at of
+// the logs pointing to it. It scans the whole table, so it is bounded like a
+// LogReader call.
+func (r *SQLiteRepository) BlobRefLastUsed(ctx context.Context) (map[string]time.Time, error) {
+	ctx, cancel := r.readContext(ctx)
+	defer cancel()
+	lastUsed := make(map[string]time.Time)
+	for _, col := range []string{"request_body_ref", "response_body_ref"} {
+		rows, err := r.db.QueryContext(ctx, fmt.Sprintf("SELECT %s, created_at FROM request_logs WHERE %s IS NOT NULL AND %s != ''", col, col, col))
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var ref string
+			var createdAt time.Time
+			if err := rows.Scan(&ref, &createdAt); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if createdAt.After(lastUsed[ref]) {
+				lastUsed[ref] = createdAt
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return lastUsed, nil
+}
+
+// BlobRefsBefore lists the refs held by logs created before the cutoff.
+func (r *SQLiteRepository) BlobRefsBefore(ctx context.Context, before time.Time) ([]string, int64, error) {
+	var logs int64
+	err := r.db.QueryRowContext(ctx, `
+	SELECT COUNT(*) FROM request_logs
+	WHERE created_at < ? AND (IFNULL(request_body_ref, '') != '' OR IFNULL(response_body_ref, '') != '')`, before).Scan(&logs)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+	SELECT request_body_ref FROM request_logs
+	WHERE created_at < ? AND request_body_ref IS NOT NULL AND request_body_ref != ''
+	UNION
+	SELECT response_body_ref FROM request_logs
+	WHERE created_at < ? AND response_body_ref IS NOT NULL AND response_body_ref != ''`, before, before)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	var refs []string
+	for rows.Next() {
+		var ref string
+		if err := rows.Scan(&ref); err != nil {
+			return nil, 0, err
+		}
+		refs = append(refs, ref)
+	}
+	return refs, logs, rows.Err()
+}
+
+// ClearBlobRefsBefore removes both body refs from logs created before the
+// cutoff and marks them with flag. The inline previews are kept.
+func (r *SQLiteRepository) ClearBlobRefsBefore(ctx context.Context, before time.Time, flag string) (int64, error) {
+	result, err := r.db.ExecContext(ctx, `
+	UPDATE request_logs SET request_body_ref = '', response_body_ref = '', flags = CASE
+		WHEN flags IS NULL OR flags = '' THEN ?
+		WHEN (',' || flags || ',') LIKE ? THEN flags
+		ELSE flags || ',' || ?
+	END
+	WHERE created_at < ? AND (IFNULL(request_body_ref, '') != '' OR IFNULL(response_body_ref, '') != '')`,
+		flag, "%,"+flag+",%", flag, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ArchivableLogs implements Repository.
+func (r *SQLiteRepository) ArchivableLogs(ctx context.Context, from, before time.Time, minInline int64, limit int) ([]*RequestLog, error) {
+	rows, err := r.db.QueryContext(ctx, `
+	SELECT id, created_at, flags, request_body, request_body_ref, response_body, response_body_ref
+	FROM request_logs
+	WHERE created_at >= ? AND created_at < ? AND (',' || COALESCE(flags, '') || ',') NOT LIKE ?
+		AND (IFNULL(request_body_ref, '') != '' OR IFNULL(response_body_ref, '') != ''
+			OR LENGTH(CAST(request_body AS BLOB)) > ? OR LENGTH(CAST(response_body AS BLOB)) > ?)
+	ORDER BY created_at ASC
+	LIMIT ?`, from, before, "%,"+FlagBodyArchived+",%", minInline, minInline, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var logs []*RequestLog
+	for rows.Next() {
+		var log RequestLog
+		var flags, reqBody, reqRef, respBody, respRef sql.NullString
+		if err := rows.Scan(&log.ID, &log.CreatedAt, &flags, &reqBody, &reqRef, &respBody, &respRef); err != nil {
+			return nil, err
+		}
+		log.Flags = splitFlags(flags.String)
+		log.RequestBody, log.RequestBodyRef = reqBody.String, reqRef.String
+		log.ResponseBody, log.ResponseBodyRef = respBody.String, respRef.String
+		logs = append(logs, &log)
+	}
+	return logs, rows.Err()
+}
+
+// ReplaceBodies implements LogWriter.
+func (r *SQLiteRepository) ReplaceBodies(ctx context.Context, l *RequestLog, flag string) error {
+	result, err := r.db.ExecContext(ctx, `
+	UPDATE request_logs SET request_body = ?, request_body_ref = ?, response_body = ?, response_body_ref = ?, flags = CASE
+		WHEN flags IS NULL OR flags = '' THEN ?
+		WHEN (',' || flags || ',') LIKE ? THEN flags
+		ELSE flags || ',' || ?
+	END
+	WHERE id = ?`,
+		l.RequestBody, l.RequestBodyRef, l.ResponseBody, l.ResponseBodyRef,
+		flag, "%,"+flag+",%", flag, l.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrLogNotFound
+	}
+	return nil
+}
+
+// ClearBlobRefs removes refs from every log pointing to them and marks those
+// logs with flag. The inline previews are kept. Each statement scans the
+// table and is bounded like a LogReader call.
+func (r *SQLiteRepository) ClearBlobRefs(ctx context.Context, refs []string, flag string) (int64, error) {
+	var updated int64
+	for len(refs) > 0 {
+		batch := refs
+		if len(batch) > deleteLogsBatch {
+			batch = batch[:deleteLogsBatch]
+		}
+		refs = refs[len(batch):]
+
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(batch)), ",")
+		for _, col := range []string{"request_body_ref", "response_body_ref"} {
+			args := []interface{}{flag, "%," + flag + ",%", flag}
+			for _, ref := range batch {
+				args = append(args, ref)
+			}
+			stmtCtx, cancel := r.readContext(ctx)
+			result, err := r.db.ExecContext(stmtCtx, fmt.Sprintf(`
+			UPDATE request_logs SET %s = '', flags = CASE
+				WHEN flags IS NULL OR flags = '' THEN ?
+				WHEN (',' || flags || ',') LIKE ? THEN flags
+				ELSE flags || ',' || ?
+			END
+			WHERE %s IN (%s)`, col, col, placeholders), args...)
+			cancel()
+			if err != nil {
+				return updated, err
+			}
+			n, _ := result.RowsAffected()
+			updated += n
+		}
+	}
+	return updated, nil
+}
+
 func (r *SQLiteRepository) scanLogSummary(scanner interface{ Scan(...interface{}) error }) (*RequestLog, error) {
 	var log RequestLog
 	var streaming, truncated int
+	var flags, variant, clientIP, remoteAddr, userAgent, source, fingerprint, duplicateOf, model sql.NullString
+	var duplicateCount, inputTokens, outputTokens, totalTokens sql.NullInt64
 
 	err := scanner.Scan(
 		&log.ID, &log.CreatedAt, &log.Upstream, &log.TargetURL, &log.Method, &log.Path, &log.Query,
 		&log.RequestBodySize, &log.StatusCode, &log.ResponseBodySize,
-		&streaming, &log.Latency, &log.Error, &truncated, &log.Tag,
+		&streaming, &log.Latency, &log.Error, &truncated, &log.Tag, &flags, &variant, &clientIP, &remoteAddr, &userAgent, &source, &fingerprint,
+		&duplicateOf, &duplicateCount, &model, &inputTokens, &outputTokens, &totalTokens,
 	)
 	if err != nil {
 		return nil, err
@@ -442,6 +1404,19 @@ func (r *SQLiteRepository) scanLogSummary(scanner interface{ Scan(...interface{}
 
 	log.Streaming = streaming == 1
 	log.Truncated = truncated == 1
+	log.Flags = splitFlags(flags.String)
+	log.Variant = variant.String
+	log.ClientIP = clientIP.String
+	log.RemoteAddr = remoteAddr.String
+	log.UserAgent = userAgent.String
+	log.Source = source.String
+	log.Fingerprint = fingerprint.String
+	log.DuplicateOf = duplicateOf.String
+	log.DuplicateCount = int(duplicateCount.Int64)
+	log.Model = model.String
+	log.InputTokens = inputTokens.Int64
+	log.OutputTokens = outputTokens.Int64
+	log.TotalTokens = totalTokens.Int64
 
 	return &log, nil
 }
@@ -450,12 +1425,15 @@ func (r *SQLiteRepository) scanLog(scanner interface{ Scan(...interface{}) error
 	var log RequestLog
 	var reqHeaders, respHeaders string
 	var streaming, truncated int
+	var flags, variant, clientIP, remoteAddr, userAgent, source, eventTimings, connTimings, fingerprint, duplicateOf, model sql.NullString
+	var streamEvents, duplicateCount, inputTokens, outputTokens, totalTokens sql.NullInt64
 
 	err := scanner.Scan(
 		&log.ID, &log.CreatedAt, &log.Upstream, &log.TargetURL, &log.Method, &log.Path, &log.Query,
 		&reqHeaders, &log.RequestBody, &log.RequestBodyRef, &log.RequestBodySize,
 		&log.StatusCode, &respHeaders, &log.ResponseBody, &log.ResponseBodyRef, &log.ResponseBodySize,
-		&streaming, &log.Latency, &log.Error, &truncated, &log.Tag,
+		&streaming, &log.Latency, &log.Error, &truncated, &log.Tag, &flags, &variant, &clientIP, &remoteAddr, &userAgent, &source, &eventTimings, &streamEvents, &connTimings, &fingerprint,
+		&duplicateOf, &duplicateCount, &model, &inputTokens, &outputTokens, &totalTokens,
 	)
 	if err != nil {
 		return nil, err
@@ -463,6 +1441,19 @@ func (r *SQLiteRepository) scanLog(scanner interface{ Scan(...interface{}) error
 
 	log.Streaming = streaming == 1
 	log.Truncated = truncated == 1
+	log.Flags = splitFlags(flags.String)
+	log.Variant = variant.String
+	log.ClientIP = clientIP.String
+	log.RemoteAddr = remoteAddr.String
+	log.UserAgent = userAgent.String
+	log.Source = source.String
+	log.Fingerprint = fingerprint.String
+	log.DuplicateOf = duplicateOf.String
+	log.DuplicateCount = int(duplicateCount.Int64)
+	log.Model = model.String
+	log.InputTokens = inputTokens.Int64
+	log.OutputTokens = outputTokens.Int64
+	log.TotalTokens = totalTokens.Int64
 
 	if reqHeaders != "" && reqHeaders != "null" {
 		log.RequestHeaders = unmarshalHeaders(reqHeaders)
@@ -470,10 +1461,79 @@ func (r *SQLiteRepository) scanLog(scanner interface{ Scan(...interface{}) error
 	if respHeaders != "" && respHeaders != "null" {
 		log.ResponseHeaders = unmarshalHeaders(respHeaders)
 	}
+	log.StreamEvents = streamEvents.Int64
+	if eventTimings.String != "" {
+		_ = json.Unmarshal([]byte(eventTimings.String), &log.EventTimings)
+	}
+	if connTimings.String != "" {
+		log.Connection = &ConnTimings{}
+		_ = json.Unmarshal([]byte(connTimings.String), log.Connection)
+	}
 
 	return &log, nil
 }
 
+// appendInCondition adds "col IN (...)" (or NOT IN) for a non-empty value list.
+func appendInCondition(conditions []string, args []interface{}, col string, values []string, negate bool) ([]string, []interface{}) {
+	if len(values) == 0 {
+		return conditions, args
+	}
+	op := "IN"
+	if negate {
+		op = "NOT IN"
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ")
+	conditions = append(conditions, fmt.Sprintf("%s %s (%s)", col, op, placeholders))
+	for _, v := range values {
+		args = append(args, v)
+	}
+	return conditions, args
+}
+
+// statusRangeCondition builds an OR of BETWEEN clauses.
+func statusRangeCondition(ranges []StatusRange) (string, []interface{}) {
+	if len(ranges) == 0 {
+		return "", nil
+	}
+	var ors []string
+	var args []interface{}
+	for _, r := range ranges {
+		ors = append(ors, "status_code BETWEEN ? AND ?")
+		args = append(args, r.Min, r.Max)
+	}
+	return "(" + strings.Join(ors, " OR ") + ")", args
+}
+
+// joinFlags stores flags as a comma-separated list.
+func joinFlags(flags []string) string {
+	return strings.Join(flags, ",")
+}
+
+func splitFlags(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// marshalEventTimings stores timings as JSON, empty when there are none.
+func marshalEventTimings(timings []EventTiming) string {
+	if len(timings) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(timings)
+	return string(data)
+}
+
+// marshalConnTimings stores connection timings as JSON, empty when absent.
+func marshalConnTimings(t *ConnTimings) string {
+	if t == nil {
+		return ""
+	}
+	data, _ := json.Marshal(t)
+	return string(data)
+}
+
 func unmarshalHeaders(data string) map[string][]string {
 	// First try unmarshaling as map[string][]string (new format)
 	var multi map[string][]string
@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"sync"
+
+	"modernc.org/sqlite"
+)
+
+// SQLite has REGEXP syntax but no implementation; "X REGEXP Y" calls the
+// user function regexp(Y, X). Register it for every connection opened by the driver.
+func init() {
+	if err := sqlite.RegisterDeterministicScalarFunction("regexp", 2, sqliteRegexp); err != nil {
+		panic(fmt.Sprintf("register sqlite regexp: %v", err))
+	}
+}
+
+// regexpCache holds compiled patterns; queries reuse the same few patterns
+// across many rows, so compile once.
+var regexpCache = struct {
+	sync.Mutex
+	m map[string]*regexp.Regexp
+}{m: make(map[string]*regexp.Regexp)}
+
+const maxCachedRegexps = 64
+
+func compileCachedRegexp(pattern string) (*regexp.Regexp, error) {
+	regexpCache.Lock()
+	defer regexpCache.Unlock()
+	if re, ok := regexpCache.m[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(regexpCache.m) >= maxCachedRegexps {
+		regexpCache.m = make(map[string]*regexp.Regexp)
+	}
+	regexpCache.m[pattern] = re
+	return re, nil
+}
+
+func sqliteRegexp(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+	pattern, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("regexp: pattern must be text")
+	}
+	var subject string
+	switch v := args[1].(type) {
+	case nil:
+		return false, nil
+	case string:
+		subject = v
+	case []byte:
+		subject = string(v)
+	default:
+		subject = fmt.Sprint(v)
+	}
+	re, err := compileCachedRegexp(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return re.MatchString(subject), nil
+}
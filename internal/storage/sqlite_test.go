@@ -0,0 +1,569 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSQLite(t *testing.T) *SQLiteRepository {
+	t.Helper()
+	repo, err := NewSQLiteRepository(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepository: %v", err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	return repo
+}
+
+func listIDs(t *testing.T, repo *SQLiteRepository, f LogFilter) []string {
+	t.Helper()
+	logs, total, err := repo.ListLogs(context.Background(), f)
+	if err != nil {
+		t.Fatalf("ListLogs(%+v): %v", f, err)
+	}
+	if int(total) != len(logs) {
+		t.Fatalf("total = %d, logs = %d", total, len(logs))
+	}
+	ids := make([]string, 0, len(logs))
+	for _, l := range logs {
+		ids = append(ids, l.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestSQLiteListLogsRangeFilters(t *testing.T) {
+	repo := newTestSQLite(t)
+	now := time.Now()
+	for _, l := range []*RequestLog{
+		{ID: "fast-small", Latency: 50, RequestBodySize: 10, ResponseBodySize: 100},
+		{ID: "slow-small", Latency: 5000, RequestBodySize: 10, ResponseBodySize: 100},
+		{ID: "fast-huge", Latency: 80, RequestBodySize: 2 << 20, ResponseBodySize: 100},
+	} {
+		l.CreatedAt, l.Upstream, l.Method, l.Path = now, "openai", "POST", "/v1/chat"
+		if err := repo.SaveLog(context.Background(), l); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		f    LogFilter
+		want []string
+	}{
+		{LogFilter{MinLatencyMs: 1000}, []string{"slow-small"}},
+		{LogFilter{MaxLatencyMs: 100}, []string{"fast-huge", "fast-small"}},
+		{LogFilter{MinBodySize: 1 << 20}, []string{"fast-huge"}},
+		{LogFilter{MaxBodySize: 1000, MaxLatencyMs: 100}, []string{"fast-small"}},
+	}
+	for _, c := range cases {
+		got := listIDs(t, repo, c.f)
+		if len(got) != len(c.want) {
+			t.Errorf("%+v: got %v, want %v", c.f, got, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("%+v: got %v, want %v", c.f, got, c.want)
+				break
+			}
+		}
+	}
+}
+
+func TestSQLiteListLogsMultiValueAndExclusion(t *testing.T) {
+	repo := newTestSQLite(t)
+	now := time.Now()
+	for _, l := range []*RequestLog{
+		{ID: "openai-200", Upstream: "openai", Path: "/v1/chat", StatusCode: 200},
+		{ID: "openai-models", Upstream: "openai", Path: "/v1/models", StatusCode: 200},
+		{ID: "azure-429", Upstream: "azure", Path: "/v1/chat", StatusCode: 429},
+		{ID: "gemini-503", Upstream: "gemini", Path: "/v1/chat", StatusCode: 503, Tag: "batch"},
+	} {
+		l.CreatedAt, l.Method = now, "POST"
+		if err := repo.SaveLog(context.Background(), l); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		name string
+		f    LogFilter
+		want []string
+	}{
+		{"upstream in", LogFilter{Upstreams: []string{"openai", "azure"}, ExcludePaths: []string{"/v1/models"}}, []string{"azure-429", "openai-200"}},
+		{"status ranges", LogFilter{StatusCodes: []StatusRange{{429, 429}, {500, 599}}}, []string{"azure-429", "gemini-503"}},
+		{"exclude status", LogFilter{ExcludeStatusCodes: []StatusRange{{400, 599}}}, []string{"openai-200", "openai-models"}},
+		{"exclude tag keeps untagged", LogFilter{ExcludeTags: []string{"batch"}, ExcludeUpstreams: []string{"openai"}}, []string{"azure-429"}},
+	}
+	for _, c := range cases {
+		got := listIDs(t, repo, c.f)
+		if strings.Join(got, ",") != strings.Join(c.want, ",") {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestSQLiteLogProperties(t *testing.T) {
+	repo := newTestSQLite(t)
+	now := time.Now()
+	for _, l := range []*RequestLog{
+		{ID: "a", StatusCode: 200, Latency: 100, Properties: map[string]string{"feature": "search", "customer": "acme"}},
+		{ID: "b", StatusCode: 500, Latency: 300, Properties: map[string]string{"feature": "search"}},
+		{ID: "c", StatusCode: 200, Latency: 50, Properties: map[string]string{"feature": "chat", "customer": "acme"}},
+		{ID: "d", StatusCode: 200},
+	} {
+		l.CreatedAt = now
+		if err := repo.SaveLog(context.Background(), l); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := listIDs(t, repo, LogFilter{Properties: map[string]string{"feature": "search"}}); strings.Join(got, ",") != "a,b" {
+		t.Errorf("feature=search: %v", got)
+	}
+	if got := listIDs(t, repo, LogFilter{Properties: map[string]string{"feature": "search", "customer": "acme"}}); strings.Join(got, ",") != "a" {
+		t.Errorf("feature=search&customer=acme: %v", got)
+	}
+	if l, err := repo.GetLog(context.Background(), "c"); err != nil || l.Properties["customer"] != "acme" {
+		t.Fatalf("GetLog = %+v, %v", l, err)
+	}
+
+	stats, err := repo.GetPropertyStats(context.Background(), "feature", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 || stats[0] != (PropertyStat{Value: "search", Count: 2, ErrorCount: 1, AvgLatency: 200}) || stats[1].Value != "chat" {
+		t.Fatalf("stats = %+v", stats)
+	}
+
+	if _, err := repo.DeleteLogs(context.Background(), []string{"a"}); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := repo.db.QueryRow("SELECT COUNT(*) FROM log_properties WHERE log_id = 'a'").Scan(&n); err != nil || n != 0 {
+		t.Fatalf("orphaned properties: %d, %v", n, err)
+	}
+}
+
+func TestSQLiteUpstreamStats(t *testing.T) {
+	repo := newTestSQLite(t)
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	logs := []*RequestLog{
+		{ID: "in-flight", Upstream: "openai"},
+		{ID: "other", Upstream: "gemini", StatusCode: 200, Latency: 1},
+		{ID: "401", Upstream: "openai", StatusCode: 401, Latency: 20},
+		{ID: "503", Upstream: "openai", StatusCode: 503, Latency: 30, Error: "upstream overloaded"},
+		{ID: "dial", Upstream: "openai", Error: "dial tcp: connection refused", Latency: 40},
+		{ID: "dial-2", Upstream: "openai", Error: "dial tcp: connection refused", Latency: 50},
+	}
+	for i := 0; i < 6; i++ {
+		logs = append(logs, &RequestLog{
+			ID: fmt.Sprintf("ok-%d", i), Upstream: "openai", StatusCode: 200, Latency: int64(100 * (i + 1)),
+			Streaming: i%2 == 0, InputTokens: 10, OutputTokens: 5,
+		})
+	}
+	for i, l := range logs {
+		l.CreatedAt = base.Add(time.Duration(i) * time.Minute)
+		if err := repo.SaveLog(context.Background(), l); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	st, err := repo.GetUpstreamStats(context.Background(), "openai", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.TotalRequests != 10 || st.SuccessCount != 6 || st.ErrorCount != 4 || st.StreamingCount != 3 {
+		t.Fatalf("counts = %+v", st)
+	}
+	if st.ClientErrors != 1 || st.ServerErrors != 1 || st.NetworkErrors != 2 || st.ByStatusCode[401] != 1 || st.ByStatusCode[503] != 1 || len(st.ByStatusCode) != 2 {
+		t.Fatalf("errors = %+v", st)
+	}
+	if len(st.TopErrors) != 2 || st.TopErrors[0] != (ErrorStat{Message: "dial tcp: connection refused", Count: 2}) {
+		t.Fatalf("top errors = %+v", st.TopErrors)
+	}
+	// Sorted latencies: 20 30 40 50 100 200 300 400 500 600.
+	if l := st.Latency; l.P50 != 100 || l.P90 != 500 || l.P99 != 500 || l.Max != 600 || l.Avg != 224 {
+		t.Fatalf("latency = %+v", l)
+	}
+	if st.InputTokens != 60 || st.OutputTokens != 30 {
+		t.Fatalf("tokens = %d/%d", st.InputTokens, st.OutputTokens)
+	}
+	if st.LastSeenAt == nil || !st.LastSeenAt.Equal(base.Add(11*time.Minute)) ||
+		st.LastErrorAt == nil || !st.LastErrorAt.Equal(base.Add(5*time.Minute)) {
+		t.Fatalf("last seen %v, last error %v", st.LastSeenAt, st.LastErrorAt)
+	}
+
+	since := base.Add(8 * time.Minute)
+	if st, err := repo.GetUpstreamStats(context.Background(), "openai", &since); err != nil || st.TotalRequests != 4 || st.ErrorCount != 0 || st.LastErrorAt != nil {
+		t.Fatalf("since: %+v, %v", st, err)
+	}
+	if st, err := repo.GetUpstreamStats(context.Background(), "unknown", nil); err != nil || st.TotalRequests != 0 || st.LastSeenAt != nil {
+		t.Fatalf("unknown: %+v, %v", st, err)
+	}
+}
+
+func TestSQLiteCheckStats(t *testing.T) {
+	repo := newTestSQLite(t)
+	for _, l := range []*RequestLog{
+		{ID: "a", Upstream: "openai", StatusCode: 200, Checks: map[string]bool{"json": true, "finish_reason": true}},
+		{ID: "b", Upstream: "openai", StatusCode: 200, Checks: map[string]bool{"json": false, "finish_reason": true}},
+		{ID: "c", Upstream: "claude", StatusCode: 200, Checks: map[string]bool{"json": true}},
+		{ID: "d", Upstream: "claude", StatusCode: 200},
+	} {
+		if err := repo.SaveLog(context.Background(), l); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if l, err := repo.GetLog(context.Background(), "b"); err != nil || l.Checks["json"] || !l.Checks["finish_reason"] {
+		t.Fatalf("GetLog = %+v, %v", l, err)
+	}
+	stats, err := repo.GetCheckStats(context.Background(), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []CheckStat{
+		{Upstream: "claude", Name: "json", Passed: 1},
+		{Upstream: "openai", Name: "finish_reason", Passed: 2},
+		{Upstream: "openai", Name: "json", Passed: 1, Failed: 1},
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if stats, _ := repo.GetCheckStats(context.Background(), "claude", nil); len(stats) != 1 {
+		t.Fatalf("claude stats = %+v", stats)
+	}
+
+	if _, err := repo.DeleteLogs(context.Background(), []string{"b"}); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := repo.db.QueryRow("SELECT COUNT(*) FROM log_checks WHERE log_id = 'b'").Scan(&n); err != nil || n != 0 {
+		t.Fatalf("orphaned checks: %d, %v", n, err)
+	}
+}
+
+func TestSQLiteLogMetadata(t *testing.T) {
+	repo := newTestSQLite(t)
+	for _, l := range []*RequestLog{
+		{ID: "a", Metadata: map[string]string{"session": "s1"}},
+		{ID: "b"},
+	} {
+		l.CreatedAt = time.Now()
+		if err := repo.SaveLog(context.Background(), l); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := repo.SetLogMetadata(context.Background(), "b", map[string]string{"session": "s1", "rating": "good"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SetLogMetadata(context.Background(), "a", map[string]string{"session": "", "rating": "bad"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SetLogMetadata(context.Background(), "missing", map[string]string{"k": "v"}); err != ErrLogNotFound {
+		t.Fatalf("unknown log: err = %v", err)
+	}
+
+	if got := listIDs(t, repo, LogFilter{Metadata: map[string]string{"session": "s1"}}); strings.Join(got, ",") != "b" {
+		t.Errorf("session=s1: %v", got)
+	}
+	md, err := repo.GetLogMetadata(context.Background(), "a")
+	if err != nil || len(md) != 1 || md["rating"] != "bad" {
+		t.Fatalf("GetLogMetadata(a) = %v, %v", md, err)
+	}
+	// Re-saving the finalized log keeps metadata set through the API.
+	if err := repo.SaveLog(context.Background(), &RequestLog{ID: "b", CreatedAt: time.Now(), StatusCode: 200}); err != nil {
+		t.Fatal(err)
+	}
+	if l, err := repo.GetLog(context.Background(), "b"); err != nil || l.Metadata["rating"] != "good" {
+		t.Fatalf("GetLog(b) = %+v, %v", l, err)
+	}
+}
+
+func TestSQLiteListLogsPathRegex(t *testing.T) {
+	repo := newTestSQLite(t)
+	for _, id := range []string{"/v1/chat/completions", "/v1/completions", "/v1/models", "/v2/chat"} {
+		if err := repo.SaveLog(context.Background(), &RequestLog{ID: id, CreatedAt: time.Now(), Upstream: "openai", Method: "POST", Path: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := listIDs(t, repo, LogFilter{PathRegex: `^/v1/(chat|completions)`})
+	if strings.Join(got, ",") != "/v1/chat/completions,/v1/completions" {
+		t.Fatalf("got %v", got)
+	}
+}
+
+func TestSQLiteGetStorageStats(t *testing.T) {
+	repo := newTestSQLite(t)
+	stats, err := repo.GetStorageStats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Rows != 0 || stats.OldestAt != nil {
+		t.Fatalf("empty db stats = %+v", stats)
+	}
+
+	oldest := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i, up := range []string{"openai", "openai", "gemini"} {
+		l := &RequestLog{ID: up + string(rune('a'+i)), CreatedAt: oldest.Add(time.Duration(i) * time.Hour), Upstream: up, Method: "GET", Path: "/"}
+		if err := repo.SaveLog(context.Background(), l); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err = repo.GetStorageStats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Rows != 3 || stats.RowsByUpstream["openai"] != 2 || stats.RowsByUpstream["gemini"] != 1 {
+		t.Fatalf("rows = %d, by upstream = %v", stats.Rows, stats.RowsByUpstream)
+	}
+	if stats.OldestAt == nil || !stats.OldestAt.Equal(oldest) {
+		t.Fatalf("oldest = %v, want %v", stats.OldestAt, oldest)
+	}
+	if stats.DBBytes == 0 {
+		t.Fatal("db_bytes = 0")
+	}
+}
+
+func TestSQLiteUpstreamFootprints(t *testing.T) {
+	repo := newTestSQLite(t)
+	for _, l := range []*RequestLog{
+		{ID: "a", Upstream: "openai", RequestBody: "héllo", ResponseBody: "world"},
+		{ID: "b", Upstream: "openai", RequestBodyRef: "sha256:aa", RequestBodySize: 1000, ResponseBodyRef: "sha256:bb", ResponseBodySize: 500},
+		{ID: "c", Upstream: "openai", RequestBodyRef: "sha256:aa", RequestBodySize: 1000},
+		{ID: "d", Upstream: "gemini", RequestBody: "hi"},
+	} {
+		l.CreatedAt, l.Method, l.Path = time.Now(), "POST", "/"
+		if err := repo.SaveLog(context.Background(), l); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := repo.GetUpstreamFootprints(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []UpstreamFootprint{
+		{Upstream: "openai", Rows: 3, InlineBytes: 11, BlobBytes: 1500, BlobCount: 2, TotalBytes: 1511},
+		{Upstream: "gemini", Rows: 1, InlineBytes: 2, TotalBytes: 2},
+	}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestSQLiteTrafficStats(t *testing.T) {
+	repo := newTestSQLite(t)
+	now := time.Now()
+	day := now.Format("2006-01-02")
+
+	// In-flight snapshot, then the final save of the same log.
+	l := &RequestLog{ID: "a", CreatedAt: now, Upstream: "openai"}
+	if err := repo.SaveLog(context.Background(), l); err != nil {
+		t.Fatal(err)
+	}
+	l.RequestBodySize, l.ResponseBodySize = 100, 1000
+	if err := repo.SaveLog(context.Background(), l); err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range []*RequestLog{
+		{ID: "b", CreatedAt: now, Upstream: "openai", RequestBodySize: 10, ResponseBodySize: 20},
+		{ID: "c", CreatedAt: now, Upstream: "claude", RequestBodySize: 5},
+	} {
+		if err := repo.SaveLog(context.Background(), l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Totals survive log deletion.
+	if _, err := repo.DeleteLogs(context.Background(), []string{"a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := repo.GetTrafficStats(context.Background(), day, day, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TrafficStat{
+		{Day: day, Upstream: "claude", Requests: 1, BytesIn: 5},
+		{Day: day, Upstream: "openai", Requests: 2, BytesIn: 110, BytesOut: 1020},
+	}
+	if len(stats) != 2 || stats[0] != want[0] || stats[1] != want[1] {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if stats, _ := repo.GetTrafficStats(context.Background(), day, day, "claude"); len(stats) != 1 {
+		t.Fatalf("upstream filter: %+v", stats)
+	}
+}
+
+func TestSQLiteHourlyRollups(t *testing.T) {
+	repo := newTestSQLite(t)
+	now := time.Now()
+	hour := now.Format("2006-01-02T15")
+
+	l := &RequestLog{ID: "a", CreatedAt: now, Upstream: "openai", Path: "/v1/chat/completions",
+		RequestBody: `{"model":"gpt-4o","messages":[]}`}
+	// in-flight snapshot: not counted
+	if err := repo.SaveLog(context.Background(), l); err != nil {
+		t.Fatal(err)
+	}
+	l.StatusCode, l.Latency = 200, 100
+	l.ResponseBody = `{"usage":{"prompt_tokens":10,"completion_tokens":5}}`
+	l.Model, l.InputTokens, l.OutputTokens, l.TotalTokens = "gpt-4o", 10, 5, 15
+	// Saving the final log twice counts it once.
+	for i := 0; i < 2; i++ {
+		if err := repo.SaveLog(context.Background(), l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, l := range []*RequestLog{
+		{ID: "b", CreatedAt: now, Upstream: "openai", Model: "gpt-4o", StatusCode: 200, Latency: 50, InputTokens: 1, OutputTokens: 2},
+		{ID: "c", CreatedAt: now, Upstream: "openai", Model: "gpt-4o", StatusCode: 502, Latency: 7},
+		{ID: "d", CreatedAt: now, Upstream: "gemini", Model: "gemini-2.5-pro", Path: "/v1beta/models/gemini-2.5-pro:generateContent", StatusCode: 200},
+	} {
+		if err := repo.SaveLog(context.Background(), l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Rollups survive log deletion.
+	if _, err := repo.DeleteLogs(context.Background(), []string{"a", "b", "c", "d"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := repo.GetHourlyRollups(context.Background(), hour, hour, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []HourlyRollup{
+		{Hour: hour, Upstream: "gemini", Model: "gemini-2.5-pro", StatusClass: "2xx", Requests: 1},
+		{Hour: hour, Upstream: "openai", Model: "gpt-4o", StatusClass: "2xx", Requests: 2, InputTokens: 11, OutputTokens: 7, LatencyMsSum: 150},
+		{Hour: hour, Upstream: "openai", Model: "gpt-4o", StatusClass: "5xx", Requests: 1, LatencyMsSum: 7},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("rollups = %+v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("rollups[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if got, _ := repo.GetHourlyRollups(context.Background(), hour, hour, "openai", "gpt-4o"); len(got) != 2 {
+		t.Fatalf("filtered rollups = %+v", got)
+	}
+}
+
+func TestSQLiteReadPool(t *testing.T) {
+	repo := newTestSQLite(t)
+	if err := repo.OpenReadPool("", 2); err != nil {
+		t.Fatalf("OpenReadPool: %v", err)
+	}
+	log := &RequestLog{ID: "a", Upstream: "u", Method: "GET", Path: "/", TargetURL: "http://x/"}
+	if err := repo.SaveLog(context.Background(), log); err != nil {
+		t.Fatalf("SaveLog: %v", err)
+	}
+	if err := repo.SetLogMetadata(context.Background(), "a", map[string]string{"k": "v"}); err != nil {
+		t.Fatalf("SetLogMetadata: %v", err)
+	}
+	got, err := repo.GetLog(context.Background(), "a")
+	if err != nil {
+		t.Fatalf("GetLog: %v", err)
+	}
+	if got.Metadata["k"] != "v" {
+		t.Fatalf("metadata = %v", got.Metadata)
+	}
+	if ids := listIDs(t, repo, LogFilter{}); len(ids) != 1 {
+		t.Fatalf("ids = %v", ids)
+	}
+	if _, err := repo.reader().Exec("DELETE FROM request_logs"); err == nil {
+		t.Fatal("read pool accepted a write")
+	}
+
+	replica := filepath.Join(t.TempDir(), "replica.db")
+	if err := repo.Snapshot(context.Background(), replica); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	if err := repo.OpenReadPool(replica, 1); err != nil {
+		t.Fatalf("OpenReadPool(replica): %v", err)
+	}
+	if _, err := repo.DeleteLogs(context.Background(), []string{"a"}); err != nil {
+		t.Fatalf("DeleteLogs: %v", err)
+	}
+	// Reads come from the replica, which still has the log.
+	if _, err := repo.GetLog(context.Background(), "a"); err != nil {
+		t.Fatalf("GetLog from replica: %v", err)
+	}
+
+	if err := repo.OpenReadPool(filepath.Join(t.TempDir(), "missing.db"), 1); err == nil {
+		t.Fatal("expected error for a replica without request_logs")
+	}
+}
+
+func TestSQLiteQueryContext(t *testing.T) {
+	repo := newTestSQLite(t)
+	// A single read connection: the concurrent stats scans take turns.
+	if err := repo.OpenReadPool("", 1); err != nil {
+		t.Fatalf("OpenReadPool: %v", err)
+	}
+	for _, l := range []*RequestLog{
+		{ID: "a", Upstream: "openai", StatusCode: 200},
+		{ID: "b", Upstream: "claude", StatusCode: 500},
+	} {
+		if err := repo.SaveLog(context.Background(), l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats, err := repo.GetStats(context.Background(), nil)
+	if err != nil || stats.TotalRequests != 2 || stats.ByUpstream["claude"] != 1 || stats.ByStatusCode[500] != 1 {
+		t.Fatalf("GetStats = %+v, %v", stats, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := repo.GetStats(ctx, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled GetStats: %v", err)
+	}
+	if _, _, err := repo.ListLogs(ctx, LogFilter{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled ListLogs: %v", err)
+	}
+
+	repo.SetQueryTimeout(time.Nanosecond)
+	if _, err := repo.GetStats(context.Background(), nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("timed out GetStats: %v", err)
+	}
+	repo.SetQueryTimeout(-1)
+	if _, err := repo.GetLog(context.Background(), "a"); err != nil {
+		t.Fatalf("GetLog without timeout: %v", err)
+	}
+}
+
+func TestSQLiteTokenStats(t *testing.T) {
+	repo := newTestSQLite(t)
+	for _, l := range []*RequestLog{
+		{ID: "json", Upstream: "openai", StatusCode: 200, Model: "gpt-4o", InputTokens: 10, OutputTokens: 5, TotalTokens: 17},
+		{ID: "sse", Upstream: "claude", StatusCode: 200, Streaming: true, Model: "claude-sonnet-4", InputTokens: 20, OutputTokens: 8, TotalTokens: 28},
+	} {
+		if err := repo.SaveLog(context.Background(), l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list, _, err := repo.ListLogs(context.Background(), LogFilter{Upstream: "openai"})
+	if err != nil || len(list) != 1 || list[0].TotalTokens != 17 || list[0].Model != "gpt-4o" {
+		t.Fatalf("ListLogs = %+v, %v", list, err)
+	}
+	stats, err := repo.GetStats(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.InputTokens != 30 || stats.OutputTokens != 13 || stats.TotalTokens != 45 {
+		t.Fatalf("stats tokens = %d/%d/%d", stats.InputTokens, stats.OutputTokens, stats.TotalTokens)
+	}
+}
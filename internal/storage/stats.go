@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// StorageStats describes how much disk space logs take.
+type StorageStats struct {
+	// DBBytes is the main database file size.
+	DBBytes int64 `json:"db_bytes"`
+	// WALBytes is the write-ahead log size; it shrinks on checkpoint.
+	WALBytes int64 `json:"wal_bytes"`
+	// FreeBytes is space inside the database file that VACUUM would reclaim.
+	FreeBytes      int64            `json:"free_bytes"`
+	Rows           int64            `json:"rows"`
+	RowsByUpstream map[string]int64 `json:"rows_by_upstream"`
+	OldestAt       *time.Time       `json:"oldest_at,omitempty"`
+	// SchemaVersion is the last applied database migration.
+	SchemaVersion int `json:"schema_version"`
+	// Blobs is set when the blob store can report its size.
+	Blobs *BlobStats `json:"blobs,omitempty"`
+	// Archive is set when the archive tier can report its (compressed) size.
+	Archive *BlobStats `json:"archive,omitempty"`
+}
+
+// UpstreamFootprint is the storage taken by one upstream's logs.
+type UpstreamFootprint struct {
+	Upstream string `json:"upstream"`
+	Rows     int64  `json:"rows"`
+	// InlineBytes is the size of bodies stored in the database.
+	InlineBytes int64 `json:"inline_bytes"`
+	// BlobBytes is the size of the distinct blobs the upstream's logs
+	// reference. A blob shared with another upstream counts for both.
+	BlobBytes int64 `json:"blob_bytes"`
+	BlobCount int64 `json:"blob_count"`
+	// TotalBytes is InlineBytes plus BlobBytes.
+	TotalBytes int64 `json:"total_bytes"`
+}
+
+// BlobStats describes the blob store contents.
+type BlobStats struct {
+	Count int64 `json:"count"`
+	Bytes int64 `json:"bytes"`
+}
+
+// BlobStatser is implemented by blob stores that can report their size.
+type BlobStatser interface {
+	Stats(ctx context.Context) (BlobStats, error)
+}
@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StatsCache wraps a Repository and caches the aggregate queries behind the
+// dashboard (GetStats, GetPropertyStats, GetTrafficStats, GetHourlyRollups,
+// GetCheckStats); the first two scan the whole log table on every poll.
+//
+// Every write bumps a generation. A cached result is reused while it is
+// younger than the TTL, so under steady traffic a dashboard triggers at most
+// one scan per TTL; with no writes since it was computed it stays valid
+// indefinitely. Deletes drop the cache at once, so they show up immediately.
+//
+// Place it inside AsyncRepository, so that writes count when they land rather
+// than when they are queued.
+type StatsCache struct {
+	Repository
+
+	ttl time.Duration
+	// shared disables reuse past the TTL: other instances write to the same
+	// database without bumping our generation.
+	shared bool
+	now    func() time.Time
+
+	gen     atomic.Uint64
+	mu      sync.Mutex
+	entries map[string]statsEntry
+}
+
+type statsEntry struct {
+	value interface{}
+	gen   uint64
+	at    time.Time
+}
+
+// NewStatsCache caches inner's stats for ttl. shared marks a database that
+// other instances also write to.
+func NewStatsCache(inner Repository, ttl time.Duration, shared bool) *StatsCache {
+	return &StatsCache{
+		Repository: inner,
+		ttl:        ttl,
+		shared:     shared,
+		now:        time.Now,
+		entries:    make(map[string]statsEntry),
+	}
+}
+
+// cached returns the entry for key if it is still valid, or computes and
+// stores a new one. Concurrent misses may both compute; the last one wins.
+func (c *StatsCache) cached(key string, compute func() (interface{}, error)) (interface{}, error) {
+	gen := c.gen.Load()
+	now := c.now()
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && (now.Sub(e.at) < c.ttl || (e.gen == gen && !c.shared)) {
+		return e.value, nil
+	}
+
+	v, err := compute()
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.entries[key] = statsEntry{value: v, gen: gen, at: now}
+	c.mu.Unlock()
+	return v, nil
+}
+
+// invalidate drops every cached result.
+func (c *StatsCache) invalidate() {
+	c.gen.Add(1)
+	c.mu.Lock()
+	c.entries = make(map[string]statsEntry)
+	c.mu.Unlock()
+}
+
+func sinceKey(since *time.Time) string {
+	if since == nil {
+		return ""
+	}
+	return since.UTC().Format(time.RFC3339Nano)
+}
+
+// GetStats returns a copy, since callers may fill in the maps.
+func (c *StatsCache) GetStats(ctx context.Context, since *time.Time) (*LogStats, error) {
+	v, err := c.cached("stats|"+sinceKey(since), func() (interface{}, error) {
+		return c.Repository.GetStats(ctx, since)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s := *v.(*LogStats)
+	s.ByUpstream = make(map[string]int64, len(s.ByUpstream))
+	for k, n := range v.(*LogStats).ByUpstream {
+		s.ByUpstream[k] = n
+	}
+	s.ByStatusCode = make(map[int]int64, len(s.ByStatusCode))
+	for k, n := range v.(*LogStats).ByStatusCode {
+		s.ByStatusCode[k] = n
+	}
+	return &s, nil
+}
+
+func (c *StatsCache) GetPropertyStats(ctx context.Context, key string, since *time.Time, limit int) ([]PropertyStat, error) {
+	v, err := c.cached(fmt.Sprintf("property|%q|%s|%d", key, sinceKey(since), limit), func() (interface{}, error) {
+		return c.Repository.GetPropertyStats(ctx, key, since, limit)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append([]PropertyStat{}, v.([]PropertyStat)...), nil
+}
+
+func (c *StatsCache) GetCheckStats(ctx context.Context, upstream string, since *time.Time) ([]CheckStat, error) {
+	v, err := c.cached(fmt.Sprintf("checks|%q|%s", upstream, sinceKey(since)), func() (interface{}, error) {
+		return c.Repository.GetCheckStats(ctx, upstream, since)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append([]CheckStat{}, v.([]CheckStat)...), nil
+}
+
+// GetUpstreamStats returns a copy, since callers may fill in the map.
+func (c *StatsCache) GetUpstreamStats(ctx context.Context, upstream string, since *time.Time) (*UpstreamStats, error) {
+	v, err := c.cached(fmt.Sprintf("upstream|%q|%s", upstream, sinceKey(since)), func() (interface{}, error) {
+		return c.Repository.GetUpstreamStats(ctx, upstream, since)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s := *v.(*UpstreamStats)
+	s.ByStatusCode = make(map[int]int64, len(s.ByStatusCode))
+	for k, n := range v.(*UpstreamStats).ByStatusCode {
+		s.ByStatusCode[k] = n
+	}
+	s.TopErrors = append([]ErrorStat{}, s.TopErrors...)
+	return &s, nil
+}
+
+func (c *StatsCache) GetTrafficStats(ctx context.Context, from, to, upstream string) ([]TrafficStat, error) {
+	v, err := c.cached(fmt.Sprintf("traffic|%s|%s|%q", from, to, upstream), func() (interface{}, error) {
+		return c.Repository.GetTrafficStats(ctx, from, to, upstream)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append([]TrafficStat{}, v.([]TrafficStat)...), nil
+}
+
+func (c *StatsCache) GetHourlyRollups(ctx context.Context, from, to, upstream, model string) ([]HourlyRollup, error) {
+	v, err := c.cached(fmt.Sprintf("hourly|%s|%s|%q|%q", from, to, upstream, model), func() (interface{}, error) {
+		return c.Repository.GetHourlyRollups(ctx, from, to, upstream, model)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append([]HourlyRollup{}, v.([]HourlyRollup)...), nil
+}
+
+func (c *StatsCache) SaveLog(ctx context.Context, log *RequestLog) error {
+	err := c.Repository.SaveLog(ctx, log)
+	c.gen.Add(1)
+	return err
+}
+
+func (c *StatsCache) ReplaceBodies(ctx context.Context, l *RequestLog, flag string) error {
+	err := c.Repository.ReplaceBodies(ctx, l, flag)
+	c.gen.Add(1)
+	return err
+}
+
+func (c *StatsCache) ClearBlobRefsBefore(ctx context.Context, before time.Time, flag string) (int64, error) {
+	n, err := c.Repository.ClearBlobRefsBefore(ctx, before, flag)
+	c.gen.Add(1)
+	return n, err
+}
+
+func (c *StatsCache) DeleteLogsBefore(ctx context.Context, beforeTime time.Time) (int64, error) {
+	n, err := c.Repository.DeleteLogsBefore(ctx, beforeTime)
+	c.invalidate()
+	return n, err
+}
+
+func (c *StatsCache) DeleteLogs(ctx context.Context, ids []string) (int64, error) {
+	n, err := c.Repository.DeleteLogs(ctx, ids)
+	c.invalidate()
+	return n, err
+}
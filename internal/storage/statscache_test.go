@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// countingStats counts the aggregate queries that reach the database.
+type countingStats struct {
+	Repository
+	queries int
+}
+
+func (c *countingStats) GetStats(ctx context.Context, since *time.Time) (*LogStats, error) {
+	c.queries++
+	return c.Repository.GetStats(ctx, since)
+}
+
+func TestStatsCache(t *testing.T) {
+	inner := &countingStats{Repository: newTestSQLite(t)}
+	c := NewStatsCache(inner, 5*time.Second, false)
+	clock := time.Now()
+	c.now = func() time.Time { return clock }
+
+	save := func(id string) {
+		t.Helper()
+		if err := c.SaveLog(context.Background(), &RequestLog{ID: id, CreatedAt: time.Now(), Upstream: "openai", Method: "GET", StatusCode: 200}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	total := func() int64 {
+		t.Helper()
+		s, err := c.GetStats(context.Background(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.ByUpstream["scribble"] = 1 // must not leak into the cache
+		return s.TotalRequests
+	}
+
+	save("a")
+	if n := total(); n != 1 || inner.queries != 1 {
+		t.Fatalf("total = %d after %d queries", n, inner.queries)
+	}
+	// With no writes the result stays valid past the TTL.
+	clock = clock.Add(time.Minute)
+	if n := total(); n != 1 || inner.queries != 1 {
+		t.Fatalf("idle: total = %d after %d queries", n, inner.queries)
+	}
+	if s, _ := c.GetStats(context.Background(), nil); s.ByUpstream["scribble"] != 0 {
+		t.Fatal("caller's change leaked into the cache")
+	}
+
+	// A write is picked up once the TTL has passed, not before.
+	save("b")
+	clock = clock.Add(time.Minute)
+	if n := total(); n != 2 || inner.queries != 2 {
+		t.Fatalf("after write: total = %d after %d queries", n, inner.queries)
+	}
+	save("c")
+	clock = clock.Add(time.Second)
+	if n := total(); n != 2 || inner.queries != 2 {
+		t.Fatalf("within TTL: total = %d after %d queries", n, inner.queries)
+	}
+
+	// Deletes invalidate at once.
+	if _, err := c.DeleteLogs(context.Background(), []string{"a"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := total(); n != 2 || inner.queries != 3 {
+		t.Fatalf("after delete: total = %d after %d queries", n, inner.queries)
+	}
+
+	// Another instance may write to a shared database: only the TTL holds.
+	shared := NewStatsCache(inner, 5*time.Second, true)
+	shared.now = c.now
+	_, _ = shared.GetStats(context.Background(), nil)
+	clock = clock.Add(time.Minute)
+	_, _ = shared.GetStats(context.Background(), nil)
+	if inner.queries != 5 {
+		t.Fatalf("shared: %d queries, want 5", inner.queries)
+	}
+}
@@ -0,0 +1,612 @@
+// Package client is a typed Go client for the PrismCat management API.
+//
+// It talks to the UI host (e.g. http://localhost:8080) and mirrors the JSON
+// shapes served under /api. Types are defined here rather than re-exported
+// from internal packages so external modules can depend on them.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client calls the PrismCat management API.
+type Client struct {
+	baseURL    *url.URL
+	password   string
+	httpClient *http.Client
+}
+
+// Option customizes a Client.
+type Option func(*Client)
+
+// WithPassword sets the control panel password (server.ui_password).
+// It is sent via HTTP Basic auth.
+func WithPassword(password string) Option {
+	return func(c *Client) { c.password = password }
+}
+
+// WithHTTPClient overrides the underlying HTTP client.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		if hc != nil {
+			c.httpClient = hc
+		}
+	}
+}
+
+// New creates a client for the given base URL (e.g. "http://localhost:8080").
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimRight(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("parse base url: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("base url must be absolute: %q", baseURL)
+	}
+	c := &Client{
+		baseURL:    u,
+		httpClient: &http.Client{Timeout: 60 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// APIError is returned for non-2xx responses.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("prismcat api: %d %s", e.StatusCode, e.Message)
+}
+
+// ConnTimings is the upstream latency breakdown of a log, in milliseconds.
+type ConnTimings struct {
+	Reused     bool    `json:"reused"`
+	DNSMs      float64 `json:"dns_ms,omitempty"`
+	ConnectMs  float64 `json:"connect_ms,omitempty"`
+	TLSMs      float64 `json:"tls_ms,omitempty"`
+	TTFBMs     float64 `json:"ttfb_ms,omitempty"`
+	WriteMs    float64 `json:"write_ms,omitempty"`
+	WaitMs     float64 `json:"wait_ms,omitempty"`
+	TransferMs float64 `json:"transfer_ms,omitempty"`
+}
+
+// RequestLog mirrors a stored request log.
+type RequestLog struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	Upstream  string `json:"upstream"`
+	TargetURL string `json:"target_url"`
+
+	Method          string              `json:"method"`
+	Path            string              `json:"path"`
+	Query           string              `json:"query,omitempty"`
+	RequestHeaders  map[string][]string `json:"request_headers,omitempty"`
+	RequestBody     string              `json:"request_body,omitempty"`
+	RequestBodyRef  string              `json:"request_body_ref,omitempty"`
+	RequestBodySize int64               `json:"request_body_size"`
+
+	StatusCode       int                 `json:"status_code"`
+	ResponseHeaders  map[string][]string `json:"response_headers,omitempty"`
+	ResponseBody     string              `json:"response_body,omitempty"`
+	ResponseBodyRef  string              `json:"response_body_ref,omitempty"`
+	ResponseBodySize int64               `json:"response_body_size"`
+
+	Streaming bool   `json:"streaming"`
+	Latency   int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+	Truncated bool   `json:"truncated"`
+	Tag       string `json:"tag,omitempty"`
+	// StreamEvents counts forwarded SSE/NDJSON events; with the "partial"
+	// flag it shows where a failed stream stopped.
+	StreamEvents int64 `json:"stream_events,omitempty"`
+	// Connection breaks down upstream connection setup and time to first byte.
+	Connection *ConnTimings `json:"connection,omitempty"`
+
+	ClientIP   string   `json:"client_ip,omitempty"`
+	RemoteAddr string   `json:"remote_addr,omitempty"`
+	UserAgent  string   `json:"user_agent,omitempty"`
+	Variant    string   `json:"variant,omitempty"`
+	Flags      []string `json:"flags,omitempty"`
+	Source     string   `json:"source,omitempty"`
+
+	// Model and the token counts are what the request and response bodies
+	// reported; zero when they named none.
+	Model        string `json:"model,omitempty"`
+	InputTokens  int64  `json:"input_tokens,omitempty"`
+	OutputTokens int64  `json:"output_tokens,omitempty"`
+	TotalTokens  int64  `json:"total_tokens,omitempty"`
+
+	Properties map[string]string `json:"properties,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+}
+
+// LogFilter selects logs for ListLogs. Zero values are ignored.
+//
+// Upstream, Method, Path and Tag accept comma-separated values (OR). Status
+// takes codes and ranges such as "429,500-599" and is combined with StatusCode.
+// The Exclude* fields drop matching logs.
+type LogFilter struct {
+	Upstream   string
+	Method     string
+	Path       string
+	PathRegex  string
+	Tag        string
+	Flag       string
+	Variant    string
+	ClientIP   string
+	RemoteAddr string
+	UserAgent  string
+	Source     string
+	StatusCode int
+	Status     string
+
+	// Properties and Metadata match custom properties and metadata keys
+	// exactly (all keys must match).
+	Properties map[string]string
+	Metadata   map[string]string
+
+	ExcludeUpstream string
+	ExcludeMethod   string
+	ExcludePath     string
+	ExcludeTag      string
+	ExcludeStatus   string
+
+	MinLatencyMs int64
+	MaxLatencyMs int64
+	MinBodySize  int64
+	MaxBodySize  int64
+	StartTime    time.Time
+	EndTime      time.Time
+	Offset       int
+	Limit        int
+}
+
+func (f LogFilter) values() url.Values {
+	v := url.Values{}
+	setIf := func(k, val string) {
+		if val != "" {
+			v.Set(k, val)
+		}
+	}
+	setIf("upstream", f.Upstream)
+	setIf("method", f.Method)
+	setIf("path", f.Path)
+	setIf("path_regex", f.PathRegex)
+	setIf("tag", f.Tag)
+	setIf("flag", f.Flag)
+	setIf("variant", f.Variant)
+	setIf("client_ip", f.ClientIP)
+	setIf("remote_addr", f.RemoteAddr)
+	setIf("user_agent", f.UserAgent)
+	setIf("source", f.Source)
+	for k, val := range f.Properties {
+		v.Set("property."+k, val)
+	}
+	for k, val := range f.Metadata {
+		v.Set("metadata."+k, val)
+	}
+	status := f.Status
+	if f.StatusCode > 0 {
+		status = strings.Trim(strconv.Itoa(f.StatusCode)+","+status, ",")
+	}
+	setIf("status_code", status)
+	setIf("upstream!", f.ExcludeUpstream)
+	setIf("method!", f.ExcludeMethod)
+	setIf("path!", f.ExcludePath)
+	setIf("tag!", f.ExcludeTag)
+	setIf("status_code!", f.ExcludeStatus)
+	setInt := func(k string, n int64) {
+		if n > 0 {
+			v.Set(k, strconv.FormatInt(n, 10))
+		}
+	}
+	setInt("min_latency_ms", f.MinLatencyMs)
+	setInt("max_latency_ms", f.MaxLatencyMs)
+	setInt("min_body_size", f.MinBodySize)
+	setInt("max_body_size", f.MaxBodySize)
+	if !f.StartTime.IsZero() {
+		v.Set("start_time", f.StartTime.Format(time.RFC3339))
+	}
+	if !f.EndTime.IsZero() {
+		v.Set("end_time", f.EndTime.Format(time.RFC3339))
+	}
+	if f.Offset > 0 {
+		v.Set("offset", strconv.Itoa(f.Offset))
+	}
+	if f.Limit > 0 {
+		v.Set("limit", strconv.Itoa(f.Limit))
+	}
+	return v
+}
+
+// LogPage is one page of ListLogs results.
+type LogPage struct {
+	Logs   []*RequestLog `json:"logs"`
+	Total  int64         `json:"total"`
+	Offset int           `json:"offset"`
+	Limit  int           `json:"limit"`
+}
+
+// Stats mirrors /api/stats.
+type Stats struct {
+	TotalRequests  int64            `json:"total_requests"`
+	SuccessCount   int64            `json:"success_count"`
+	ErrorCount     int64            `json:"error_count"`
+	StreamingCount int64            `json:"streaming_count"`
+	AvgLatency     float64          `json:"avg_latency_ms"`
+	SchemaInvalid  int64            `json:"schema_invalid_count"`
+	ByUpstream     map[string]int64 `json:"by_upstream"`
+	ByStatusCode   map[string]int64 `json:"by_status_code"`
+}
+
+// StorageStats is returned by /api/storage/stats.
+type StorageStats struct {
+	DBBytes        int64            `json:"db_bytes"`
+	WALBytes       int64            `json:"wal_bytes"`
+	FreeBytes      int64            `json:"free_bytes"`
+	Rows           int64            `json:"rows"`
+	RowsByUpstream map[string]int64 `json:"rows_by_upstream"`
+	OldestAt       *time.Time       `json:"oldest_at,omitempty"`
+	SchemaVersion  int              `json:"schema_version"`
+	Blobs          *struct {
+		Count int64 `json:"count"`
+		Bytes int64 `json:"bytes"`
+	} `json:"blobs,omitempty"`
+}
+
+// BlobGCReport is returned by /api/maintenance/blob-gc.
+type BlobGCReport struct {
+	Scanned        int   `json:"scanned"`
+	Referenced     int   `json:"referenced"`
+	Deleted        int   `json:"deleted"`
+	ReclaimedBytes int64 `json:"reclaimed_bytes"`
+}
+
+// ReplayRequest is sent to /api/replay.
+type ReplayRequest struct {
+	Upstream string            `json:"upstream"`
+	Method   string            `json:"method"`
+	Path     string            `json:"path"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Body     string            `json:"body,omitempty"`
+}
+
+// ReplayResponse is returned by /api/replay.
+type ReplayResponse struct {
+	StatusCode int                 `json:"status_code"`
+	Headers    map[string][]string `json:"headers"`
+	Body       string              `json:"body"`
+	Truncated  bool                `json:"truncated"`
+}
+
+// ListLogs returns a page of log summaries (bodies and headers are omitted).
+func (c *Client) ListLogs(ctx context.Context, filter LogFilter) (*LogPage, error) {
+	var page LogPage
+	if err := c.do(ctx, http.MethodGet, "/api/logs", filter.values(), nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// GetLog returns a full log entry by id.
+func (c *Client) GetLog(ctx context.Context, id string) (*RequestLog, error) {
+	return c.GetLogView(ctx, id, "")
+}
+
+// GetLogView fetches a log choosing how a streaming response body is
+// returned: "raw" (captured stream), "merged" (reassembled JSON) or "" (as stored).
+func (c *Client) GetLogView(ctx context.Context, id, view string) (*RequestLog, error) {
+	var q url.Values
+	if view != "" {
+		q = url.Values{"view": {view}}
+	}
+	var entry RequestLog
+	if err := c.do(ctx, http.MethodGet, "/api/logs/"+url.PathEscape(id), q, nil, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// Stats returns aggregate statistics, optionally limited to logs created at or after since.
+func (c *Client) Stats(ctx context.Context, since time.Time) (*Stats, error) {
+	q := url.Values{}
+	if !since.IsZero() {
+		q.Set("since", since.Format(time.RFC3339))
+	}
+	var stats Stats
+	if err := c.do(ctx, http.MethodGet, "/api/stats", q, nil, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// LogMetadata returns the metadata attached to a log.
+func (c *Client) LogMetadata(ctx context.Context, id string) (map[string]string, error) {
+	var md map[string]string
+	if err := c.do(ctx, http.MethodGet, "/api/logs/"+url.PathEscape(id)+"/metadata", nil, nil, &md); err != nil {
+		return nil, err
+	}
+	return md, nil
+}
+
+// SetLogMetadata merges md into a log's metadata and returns the result. An
+// empty value removes the key.
+func (c *Client) SetLogMetadata(ctx context.Context, id string, md map[string]string) (map[string]string, error) {
+	var out map[string]string
+	if err := c.do(ctx, http.MethodPatch, "/api/logs/"+url.PathEscape(id)+"/metadata", nil, md, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PropertyStat is one value of a custom property in /api/stats/properties.
+type PropertyStat struct {
+	Value      string  `json:"value"`
+	Count      int64   `json:"count"`
+	ErrorCount int64   `json:"error_count"`
+	AvgLatency float64 `json:"avg_latency_ms"`
+}
+
+// PropertyStats groups logs by the values of custom property key, most
+// frequent first. since and limit are optional.
+func (c *Client) PropertyStats(ctx context.Context, key string, since time.Time, limit int) ([]PropertyStat, error) {
+	q := url.Values{"key": {key}}
+	if !since.IsZero() {
+		q.Set("since", since.Format(time.RFC3339))
+	}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	var out struct {
+		Values []PropertyStat `json:"values"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/api/stats/properties", q, nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Values, nil
+}
+
+// TrafficStat is one upstream's body volume on one day in /api/stats/traffic.
+type TrafficStat struct {
+	Day      string `json:"day"`
+	Upstream string `json:"upstream"`
+	Requests int64  `json:"requests"`
+	BytesIn  int64  `json:"bytes_in"`
+	BytesOut int64  `json:"bytes_out"`
+}
+
+// TrafficStats returns daily traffic per upstream between from and to
+// (YYYY-MM-DD, inclusive). Empty arguments use the server defaults: the
+// last 30 days, all upstreams.
+func (c *Client) TrafficStats(ctx context.Context, from, to, upstream string) ([]TrafficStat, error) {
+	q := url.Values{}
+	if from != "" {
+		q.Set("from", from)
+	}
+	if to != "" {
+		q.Set("to", to)
+	}
+	if upstream != "" {
+		q.Set("upstream", upstream)
+	}
+	var out struct {
+		Days []TrafficStat `json:"days"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/api/stats/traffic", q, nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Days, nil
+}
+
+// StorageStats reports the disk usage of the database and blob store.
+func (c *Client) StorageStats(ctx context.Context) (*StorageStats, error) {
+	var stats StorageStats
+	if err := c.do(ctx, http.MethodGet, "/api/storage/stats", nil, nil, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// BlobGC deletes blobs no longer referenced by any log. Blobs newer than
+// minAge are kept; 0 uses the server default (1h).
+func (c *Client) BlobGC(ctx context.Context, minAge time.Duration) (*BlobGCReport, error) {
+	q := url.Values{}
+	if minAge > 0 {
+		q.Set("min_age", minAge.String())
+	}
+	var report BlobGCReport
+	if err := c.do(ctx, http.MethodPost, "/api/maintenance/blob-gc", q, nil, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// Backup streams a backup archive (tar.gz) into w.
+func (c *Client) Backup(ctx context.Context, w io.Writer) error {
+	req, err := c.newRequest(ctx, http.MethodGet, "/api/maintenance/backup", nil, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// Replay sends a request to an upstream through PrismCat and returns the upstream response.
+func (c *Client) Replay(ctx context.Context, req ReplayRequest) (*ReplayResponse, error) {
+	var resp ReplayResponse
+	if err := c.do(ctx, http.MethodPost, "/api/replay", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Maintenance mirrors an upstream's maintenance mode settings.
+type Maintenance struct {
+	Enabled    bool   `json:"enabled"`
+	Status     int    `json:"status,omitempty"`
+	Message    string `json:"message,omitempty"`
+	RetryAfter int    `json:"retry_after,omitempty"`
+}
+
+// SetMaintenance enables or disables maintenance mode for an upstream.
+func (c *Client) SetMaintenance(ctx context.Context, upstream string, m Maintenance) error {
+	body := struct {
+		Name string `json:"name"`
+		Maintenance
+	}{upstream, m}
+	return c.do(ctx, http.MethodPost, "/api/upstreams/maintenance", nil, body, nil)
+}
+
+// PurgeRequest selects logs to delete by content. A log matches if its path,
+// query or a body contains Contains or matches the regexp Pattern.
+type PurgeRequest struct {
+	Contains string `json:"contains,omitempty"`
+	Pattern  string `json:"pattern,omitempty"`
+	DryRun   bool   `json:"dry_run,omitempty"`
+}
+
+// PurgeResult reports the logs matched and removed by PurgeLogs.
+type PurgeResult struct {
+	IDs          []string `json:"ids"`
+	Matched      int      `json:"matched"`
+	Deleted      int64    `json:"deleted"`
+	BlobsDeleted int      `json:"blobs_deleted"`
+	DryRun       bool     `json:"dry_run"`
+}
+
+// PurgeLogs deletes all logs (and their blobs) whose content matches req.
+// Use DryRun to preview the matches first.
+func (c *Client) PurgeLogs(ctx context.Context, req PurgeRequest) (*PurgeResult, error) {
+	var out PurgeResult
+	if err := c.do(ctx, http.MethodPost, "/api/logs/purge", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Blob downloads a detached body by ref (e.g. RequestLog.ResponseBodyRef).
+func (c *Client) Blob(ctx context.Context, ref string) ([]byte, error) {
+	return c.getRaw(ctx, "/api/blobs/"+url.PathEscape(ref), nil)
+}
+
+// LogBundle downloads the shareable bundle of a log. format is "json" or
+// "zip"; empty means json. Credentials are always masked; redactPII also
+// masks personal data in bodies and headers.
+func (c *Client) LogBundle(ctx context.Context, id, format string, redactPII bool) ([]byte, error) {
+	q := url.Values{}
+	if format != "" {
+		q.Set("format", format)
+	}
+	if redactPII {
+		q.Set("redact", "true")
+	}
+	return c.getRaw(ctx, "/api/logs/"+url.PathEscape(id)+"/bundle", q)
+}
+
+// getRaw performs a GET and returns the undecoded response body.
+func (c *Client) getRaw(ctx context.Context, path string, query url.Values) ([]byte, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, path, query, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Request, error) {
+	u := *c.baseURL
+	u.Path = strings.TrimRight(u.Path, "/") + path
+	if len(query) > 0 {
+		u.RawQuery = query.Encode()
+	}
+
+	var rd io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("encode request: %w", err)
+		}
+		rd = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), rd)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.password != "" {
+		req.SetBasicAuth("prismcat", c.password)
+	}
+	return req, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	req, err := c.newRequest(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var payload struct {
+		Error string `json:"error"`
+	}
+	msg := strings.TrimSpace(string(data))
+	if json.Unmarshal(data, &payload) == nil && payload.Error != "" {
+		msg = payload.Error
+	}
+	return &APIError{StatusCode: resp.StatusCode, Message: msg}
+}
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestListLogsEncodesFilterAndAuth(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/logs" {
+			t.Errorf("path = %q, want /api/logs", r.URL.Path)
+		}
+		if got := r.URL.Query().Get("upstream"); got != "openai" {
+			t.Errorf("upstream = %q, want openai", got)
+		}
+		if got := r.URL.Query().Get("status_code"); got != "429" {
+			t.Errorf("status_code = %q, want 429", got)
+		}
+		if _, pass, ok := r.BasicAuth(); !ok || pass != "secret" {
+			t.Errorf("basic auth password = %q (ok=%v), want secret", pass, ok)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"logs":  []map[string]interface{}{{"id": "a", "upstream": "openai", "status_code": 429}},
+			"total": 1,
+			"limit": 50,
+		})
+	}))
+	defer srv.Close()
+
+	c, err := New(srv.URL, WithPassword("secret"))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	page, err := c.ListLogs(context.Background(), LogFilter{Upstream: "openai", StatusCode: 429})
+	if err != nil {
+		t.Fatalf("ListLogs: %v", err)
+	}
+	if page.Total != 1 || len(page.Logs) != 1 || page.Logs[0].ID != "a" {
+		t.Fatalf("unexpected page: %+v", page)
+	}
+}
+
+func TestAPIErrorCarriesServerMessage(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error":"日志不存在"}`))
+	}))
+	defer srv.Close()
+
+	c, err := New(srv.URL)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	_, err = c.GetLog(context.Background(), "missing")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("err = %v, want *APIError", err)
+	}
+	if apiErr.StatusCode != http.StatusNotFound || apiErr.Message != "日志不存在" {
+		t.Fatalf("unexpected error: %+v", apiErr)
+	}
+}
@@ -0,0 +1,138 @@
+package client
+
+import (
+	"context"
+	"time"
+)
+
+// StreamOptions controls Stream.
+type StreamOptions struct {
+	// Filter restricts which logs are delivered. Offset/Limit/StartTime are managed by Stream.
+	Filter LogFilter
+	// Interval between polls. Default 2s.
+	Interval time.Duration
+	// Since delivers logs created at or after this time. Default: time of the call.
+	Since time.Time
+	// IncludeInFlight also delivers logs that have not completed yet
+	// (no status code and no error). Completed logs are still delivered once
+	// they finish, so an entry may be seen twice.
+	IncludeInFlight bool
+}
+
+// Stream polls /api/logs and delivers new log summaries on the returned channel,
+// oldest first. The channel is closed when ctx is done. Poll errors are sent on
+// the error channel (buffered, best-effort) and polling continues.
+func (c *Client) Stream(ctx context.Context, opts StreamOptions) (<-chan *RequestLog, <-chan error) {
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+	since := opts.Since
+	if since.IsZero() {
+		since = time.Now()
+	}
+
+	out := make(chan *RequestLog, 64)
+	errs := make(chan error, 8)
+
+	go func() {
+		defer close(out)
+		defer close(errs)
+
+		// id -> completed? Entries are pruned once they fall behind the polling window.
+		seen := make(map[string]bool)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			windowStart, err := c.streamPoll(ctx, opts, since, seen, out)
+			if err != nil && ctx.Err() == nil {
+				select {
+				case errs <- err:
+				default:
+				}
+			}
+			if !windowStart.IsZero() {
+				since = windowStart
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return out, errs
+}
+
+// streamPoll fetches all logs created since `since` and emits unseen ones.
+// It returns the created_at of the oldest still-in-flight log (or the newest log seen),
+// which becomes the next polling window start.
+func (c *Client) streamPoll(ctx context.Context, opts StreamOptions, since time.Time, seen map[string]bool, out chan<- *RequestLog) (time.Time, error) {
+	const pageSize = 500
+
+	filter := opts.Filter
+	filter.StartTime = since
+	filter.Limit = pageSize
+	filter.Offset = 0
+
+	var batch []*RequestLog
+	for {
+		page, err := c.ListLogs(ctx, filter)
+		if err != nil {
+			return time.Time{}, err
+		}
+		batch = append(batch, page.Logs...)
+		if len(page.Logs) < pageSize || int64(len(batch)) >= page.Total {
+			break
+		}
+		filter.Offset += len(page.Logs)
+	}
+
+	// API returns newest first; deliver oldest first.
+	var next time.Time
+	var oldestInFlight time.Time
+	current := make(map[string]bool, len(batch))
+	for i := len(batch) - 1; i >= 0; i-- {
+		entry := batch[i]
+		done := entry.StatusCode != 0 || entry.Error != ""
+		current[entry.ID] = true
+		if entry.CreatedAt.After(next) {
+			next = entry.CreatedAt
+		}
+		if !done && (oldestInFlight.IsZero() || entry.CreatedAt.Before(oldestInFlight)) {
+			oldestInFlight = entry.CreatedAt
+		}
+
+		prevDone, wasSeen := seen[entry.ID]
+		switch {
+		case done && (!wasSeen || !prevDone):
+		case !done && !wasSeen && opts.IncludeInFlight:
+		default:
+			if !wasSeen {
+				seen[entry.ID] = done
+			}
+			continue
+		}
+		seen[entry.ID] = done
+
+		select {
+		case out <- entry:
+		case <-ctx.Done():
+			return time.Time{}, ctx.Err()
+		}
+	}
+
+	for id := range seen {
+		if !current[id] {
+			delete(seen, id)
+		}
+	}
+
+	if !oldestInFlight.IsZero() {
+		return oldestInFlight, nil
+	}
+	return next, nil
+}